@@ -1,16 +1,25 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
+	"project-sage/internal/auth"
 	"project-sage/internal/billing" // internal package for billing logic
+	"project-sage/internal/database"
+	"project-sage/internal/httputil"
+	"project-sage/internal/jsonbody"
+	"project-sage/internal/ratelimit"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // main is the entry point for the BillingService.
@@ -21,7 +30,7 @@ func main() {
 		log.Fatal("DB_CONNECTION_STRING environment variable is not set")
 	}
 
-	db, err := connectDB(connStr)
+	db, err := database.Connect("pgx", connStr, database.RetryConfigFromEnv())
 	if err != nil {
 		log.Fatalf("Could not connect to database: %v", err)
 	}
@@ -30,22 +39,92 @@ func main() {
 
 	// manual dependency injection
 	// create the repository, pass it to the service, and pass the service to the handler.
-	billingRepo := billing.NewPostgresRepository(db)
-	billingService := billing.NewService(billingRepo)
-	billingHandler := billing.NewHandler(billingService)
+	// Credits that would take a balance over this are refused, so a runaway payment loop can't mint millions of tokens.
+	// 0 turns it off.
+	// Each repository call is cut off after DB_QUERY_TIMEOUT (default 5s), so a hung query or a long wait on a locked row can't hold a connection.
+	billingRepo := billing.NewPostgresRepositoryWithOptions(db, billing.RepositoryOptions{
+		MaxBalance:   envInt("MAX_TOKEN_BALANCE", billing.DefaultMaxBalance),
+		QueryTimeout: database.QueryTimeoutFromEnv(),
+	})
+	// Monthly tokens per membership tier, eg "premium=10,premium_plus=25". Unset means no grants.
+	grants, err := billing.ParseTierGrants(os.Getenv("MONTHLY_TIER_GRANTS"))
+	if err != nil {
+		log.Fatalf("Invalid MONTHLY_TIER_GRANTS: %v", err)
+	}
+	// What each cost type debits, eg "standard=1,priority=2,follow_up=0". Unset means those defaults.
+	costTypes, err := billing.ParseCostTypes(os.Getenv("TOKEN_COST_TYPES"))
+	if err != nil {
+		log.Fatalf("Invalid TOKEN_COST_TYPES: %v", err)
+	}
+	// Balance change events go to BALANCE_EVENTS_WEBHOOK_URL, if it's set. They're queued and sent in the background,
+	// so a slow listener never holds up a debit.
+	var publisher billing.Publisher
+	if url := os.Getenv("BALANCE_EVENTS_WEBHOOK_URL"); url != "" {
+		queue := billing.NewPublishQueue(billing.NewWebhookPublisher(url), billing.DefaultPublishQueueConfig())
+		go queue.Run(context.Background())
+		publisher = queue
+	}
+	// Debit and credit counts and handler latency, scraped from /metrics along with the Go runtime's own.
+	metrics := billing.NewMetrics(prometheus.DefaultRegisterer)
+	billingService := billing.NewServiceWithOptions(billingRepo, billing.Options{
+		Grants:    grants,
+		CostTypes: costTypes,
+		Publisher: publisher,
+		Metrics:   metrics,
+		// One /token/add call can't credit more than this, so a typo'd support credit is refused rather than applied.
+		MaxCreditAmount: envInt("MAX_CREDIT_AMOUNT", billing.DefaultMaxCreditAmount),
+		// A debit or hold that takes a balance down to this also sends a low_balance event, so the app can offer more tokens.
+		LowBalanceThreshold: envInt("LOW_BALANCE_THRESHOLD", 0),
+	})
+	// Per-user rate limit on /token/debit, so a client looping on it can't drain a balance in seconds.
+	// The rate is per minute like the RequestService's. 0 turns it off.
+	var debitLimiter ratelimit.Store
+	if perMinute := envInt("DEBIT_RATE_LIMIT_PER_MINUTE", 30); perMinute > 0 {
+		debitLimiter = ratelimit.NewMemoryStore(ratelimit.Config{
+			Rate:  float64(perMinute) / 60,
+			Burst: envInt("DEBIT_RATE_LIMIT_BURST", 10),
+		})
+	}
+	billingHandler := billing.NewHandler(billingService, debitLimiter)
+
+	// The sweeper gives back token holds nobody committed or released, eg from a RequestService that crashed mid-create.
+	sweeperCfg := billing.DefaultHoldSweeperConfig()
+	sweeperCfg.TTL = time.Duration(envInt("HOLD_TTL_SECONDS", 600)) * time.Second
+	go billing.NewHoldSweeper(billingRepo, sweeperCfg).Run(context.Background())
+	// And this one takes expired promo and trial tokens off balances. They can't be spent either way, so it only tidies up.
+	go billing.NewLotSweeper(billingRepo, billing.DefaultLotSweeperConfig()).Run(context.Background())
+
+	// Without a secret every billing route would answer 401, so refuse to start instead.
+	internalToken := auth.InternalTokenFromEnv()
+	if internalToken == "" {
+		log.Fatal("INTERNAL_API_TOKEN environment variable is not set")
+	}
 
 	// Set up the router
 	r := chi.NewRouter()
-	r.Use(middleware.Logger)    // Log requests
-	r.Use(middleware.Recoverer) // For any panics
+	r.Use(middleware.Logger)  // Log requests
+	r.Use(httputil.Recoverer) // For any panics
+	// Unknown routes and methods get a problem body too.
+	r.NotFound(httputil.NotFound)
+	r.MethodNotAllowed(httputil.MethodNotAllowed)
+	// Cap JSON request bodies. The limit comes from MAX_REQUEST_BODY_BYTES (default 1MB).
+	r.Use(jsonbody.Limit(jsonbody.MaxBytesFromEnv()))
 
 	// Basic health check endpoint.
 	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("BillingService OK"))
 	})
+	// Prometheus scrapes this from inside the cluster, so like the health check it doesn't need the token.
+	r.Handle("/metrics", promhttp.Handler())
 
 	// Let the handler set up its routes (like /token/debit).
-	billingHandler.RegisterRoutes(r)
+	// They're only for our own services, so every one of them needs the shared INTERNAL_API_TOKEN.
+	// The health check stays open for the load balancer.
+	r.Group(func(r chi.Router) {
+		r.Use(metrics.Middleware) // Before the token check, so 401s are timed too
+		r.Use(auth.RequireInternalToken(internalToken))
+		billingHandler.RegisterRoutes(r)
+	})
 
 	// Get the port from env or use the default.
 	port := os.Getenv("PORT")
@@ -61,15 +140,16 @@ func main() {
 	}
 }
 
-// connectDB is a helper to open and verify the database connection.
-func connectDB(connStr string) (*sql.DB, error) {
-	db, err := sql.Open("pgx", connStr)
-	if err != nil {
-		return nil, err
+// envInt reads an integer environment variable, falling back to def if it's unset or invalid.
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
 	}
-	// Ping() to make sure the connection is actually valid.
-	if err = db.Ping(); err != nil {
-		return nil, err
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("WARNING: invalid %s=%q, using default %d", name, v, def)
+		return def
 	}
-	return db, nil
+	return n
 }
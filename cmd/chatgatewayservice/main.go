@@ -6,7 +6,13 @@ import (
 	"net/http"
 	"os"
 
+	"project-sage/internal/auth"
 	"project-sage/internal/chat"
+	"project-sage/internal/cors"
+	"project-sage/internal/database"
+	"project-sage/internal/httpclient"
+	"project-sage/internal/httputil"
+	"project-sage/internal/jsonbody"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -16,25 +22,70 @@ import (
 func main() {
 
 	// This service's main dependency is the Twilio client.
-	_ = os.Getenv("TWILIO_ACCOUNT_SID")
-	_ = os.Getenv("TWILIO_AUTH_TOKEN")
-	_ = os.Getenv("TWILIO_API_KEY")
-	_ = os.Getenv("TWILIO_API_SECRET")
+	twilioAuthToken := os.Getenv("TWILIO_AUTH_TOKEN") // Also what the webhook signatures are checked with
+	tokenCfg := chat.TokenConfig{
+		AccountSID: os.Getenv("TWILIO_ACCOUNT_SID"),
+		APIKey:     os.Getenv("TWILIO_API_KEY"),
+		APISecret:  os.Getenv("TWILIO_API_SECRET"),
+		ServiceSID: os.Getenv("TWILIO_CONVERSATIONS_SERVICE_SID"),
+	}
 
 	// We'll use our stub client for now.
 	twilioClient := chat.NewStubTwilioClient()
 	// When we build the real client, we'll swap this line, eg:
 	// twilioClient := chat.NewRealTwilioClient(accountSID, authToken, ...)
+	// Access tokens don't need the REST API, so with an API key they're real already.
+	if tokenCfg.AccountSID != "" && tokenCfg.APIKey != "" && tokenCfg.APISecret != "" {
+		twilioClient = chat.NewAccessTokenClient(tokenCfg, twilioClient)
+	} else {
+		log.Println("WARNING: TWILIO_ACCOUNT_SID, TWILIO_API_KEY or TWILIO_API_SECRET not set, /chat/token hands out fake tokens")
+	}
+
+	// The service owns the conversations table.
+	connStr := os.Getenv("DB_CONNECTION_STRING")
+	if connStr == "" {
+		log.Fatal("DB_CONNECTION_STRING environment variable is not set")
+	}
+	db, err := database.Connect("pgx", connStr, database.RetryConfigFromEnv())
+	if err != nil {
+		log.Fatalf("Could not connect to database: %v", err)
+	}
+	defer db.Close()
+	// Each repository call is cut off after DB_QUERY_TIMEOUT (default 5s), so a hung query can't hold a connection.
+	chatRepo := chat.NewPostgresRepositoryWithOptions(db, chat.RepositoryOptions{QueryTimeout: database.QueryTimeoutFromEnv()})
+
+	// The webhook escalates chats through the RequestService. Without one configured, escalations are only logged.
+	var requestClient chat.RequestClient
+	if requestSvcURL := os.Getenv("REQUEST_SERVICE_URL"); requestSvcURL != "" {
+		requestClient = chat.NewHTTPRequestClient(requestSvcURL, auth.InternalTokenFromEnv(), httpclient.TimeoutFromEnv("REQUEST_CLIENT_TIMEOUT", chat.DefaultRequestClientTimeout))
+	} else {
+		requestClient = chat.NewStubRequestClient()
+	}
 
-	// Inject the client into the service
-	chatService := chat.NewService(twilioClient)
+	// Inject the clients and repository into the service.
+	// BOT_IDENTITY is the bot's Twilio identity, defaulting to chat.DefaultBotIdentity.
+	// HANDOFF_TRIGGER_PHRASE is what the user types to get an expert, defaulting to chat.DefaultTriggerPhrase.
+	// TWILIO_TOKEN_TTL is how long access tokens last, defaulting to chat.DefaultTokenTTL.
+	chatService := chat.NewService(twilioClient, chatRepo, requestClient, os.Getenv("BOT_IDENTITY"), os.Getenv("HANDOFF_TRIGGER_PHRASE"),
+		httpclient.TimeoutFromEnv("TWILIO_TOKEN_TTL", chat.DefaultTokenTTL))
 
-	// Inject service into the handler
-	chatHandler := chat.NewHandler(chatService)
+	// Inject service into the handler. WEBHOOK_URL must be the exact URL configured in Twilio, or no signature will match.
+	webhookCfg := chat.WebhookConfig{AuthToken: twilioAuthToken, URL: os.Getenv("WEBHOOK_URL")}
+	if webhookCfg.AuthToken == "" || webhookCfg.URL == "" {
+		log.Println("WARNING: TWILIO_AUTH_TOKEN or WEBHOOK_URL not set, /chat/webhook will refuse all calls")
+	}
+	chatHandler := chat.NewHandler(chatService, webhookCfg, auth.InternalTokenFromEnv())
 
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
+	r.Use(httputil.Recoverer)
+	// Unknown routes and methods get a problem body too.
+	r.NotFound(httputil.NotFound)
+	r.MethodNotAllowed(httputil.MethodNotAllowed)
+	// CORS for the client app. Allowed origins come from ALLOWED_ORIGINS.
+	r.Use(cors.Middleware(cors.FromEnv()))
+	// Cap JSON request bodies. The limit comes from MAX_REQUEST_BODY_BYTES (default 1MB).
+	r.Use(jsonbody.Limit(jsonbody.MaxBytesFromEnv()))
 
 	// Health check endpoint
 	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
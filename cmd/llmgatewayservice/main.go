@@ -5,7 +5,14 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
+	"project-sage/internal/auth"
+	"project-sage/internal/cors"
+	"project-sage/internal/httpclient"
+	"project-sage/internal/httputil"
+	"project-sage/internal/jsonbody"
 	"project-sage/internal/llm" // The internal package for this service
 
 	"github.com/go-chi/chi/v5"
@@ -17,22 +24,46 @@ func main() {
 
 	// This service depends on clients for other services.
 
-	// Get external service URLs. For now, they aren't used by the stubs.
+	// Get external service URLs.
 	chatGatewayURL := os.Getenv("CHAT_GATEWAY_URL") // eg "http://chatgateway:8084"
-	_ = os.Getenv("GEMINI_API_KEY")
 
-	geminiClient := llm.NewStubGeminiClient()
-	chatClient := llm.NewHTTPChatGatewayClient(chatGatewayURL)
+	// Use the real Gemini API when a key is configured, otherwise fall back to the canned stub.
+	var geminiClient llm.GeminiClient
+	if apiKey := os.Getenv("GEMINI_API_KEY"); apiKey != "" {
+		geminiClient = llm.NewHTTPGeminiClient(llm.GeminiConfig{
+			APIKey:  apiKey,
+			Model:   os.Getenv("GEMINI_MODEL"),
+			Timeout: time.Duration(envInt("GEMINI_TIMEOUT_SECONDS", 60)) * time.Second,
+		})
+	} else {
+		geminiClient = llm.NewStubGeminiClient()
+	}
+	// BOT_IDENTITY must match the ChatGatewayService's, or the bot's own messages would look like the user's
+	// and the gateway would refuse to post its replies.
+	chatClient := llm.NewHTTPChatGatewayClient(chatGatewayURL, os.Getenv("BOT_IDENTITY"), auth.InternalTokenFromEnv(),
+		httpclient.TimeoutFromEnv("CHAT_GATEWAY_CLIENT_TIMEOUT", llm.DefaultChatGatewayTimeout))
 
-	// Inject clients into the service
-	llmService := llm.NewService(geminiClient, chatClient)
+	// Inject clients into the service. Summaries only look at the newest SUMMARY_HISTORY_LIMIT messages.
+	llmService := llm.NewService(geminiClient, chatClient, envInt("SUMMARY_HISTORY_LIMIT", llm.DefaultSummaryHistoryLimit))
 
-	// Inject service into the handler
-	llmHandler := llm.NewHandler(llmService)
+	// Inject service into the handler. Social chat histories are capped at SOCIAL_CHAT_MAX_MESSAGES messages
+	// and SOCIAL_CHAT_MAX_CHARS characters.
+	defLimits := llm.DefaultChatLimits()
+	llmHandler := llm.NewHandler(llmService, llm.ChatLimits{
+		MaxMessages: envInt("SOCIAL_CHAT_MAX_MESSAGES", defLimits.MaxMessages),
+		MaxChars:    envInt("SOCIAL_CHAT_MAX_CHARS", defLimits.MaxChars),
+	})
 
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
+	r.Use(httputil.Recoverer)
+	// Unknown routes and methods get a problem body too.
+	r.NotFound(httputil.NotFound)
+	r.MethodNotAllowed(httputil.MethodNotAllowed)
+	// CORS for the client app. Allowed origins come from ALLOWED_ORIGINS.
+	r.Use(cors.Middleware(cors.FromEnv()))
+	// Cap JSON request bodies. The limit comes from MAX_REQUEST_BODY_BYTES (default 1MB).
+	r.Use(jsonbody.Limit(jsonbody.MaxBytesFromEnv()))
 
 	// Health check endpoint
 	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -52,3 +83,17 @@ func main() {
 		log.Fatalf("Could not start server: %v", err)
 	}
 }
+
+// envInt reads an integer environment variable, falling back to def if it's unset or invalid.
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("WARNING: invalid %s=%q, using default %d", name, v, def)
+		return def
+	}
+	return n
+}
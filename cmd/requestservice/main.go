@@ -1,28 +1,49 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
-
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"project-sage/internal/auth"
+	"project-sage/internal/cors"
+	"project-sage/internal/database"
+	"project-sage/internal/httpclient"
+	"project-sage/internal/httputil"
+	"project-sage/internal/jsonbody"
+	"project-sage/internal/ratelimit"
 	"project-sage/internal/request" // The internal package for this service
+	"project-sage/internal/request/requestpb"
+	"project-sage/internal/usercache"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"google.golang.org/grpc"
 )
 
 // main is the entry point for the RequestService.
 // THis initializes dependencies and starts the HTTP server.
 func main() {
+	// SIGINT or SIGTERM (what Kubernetes sends before killing the pod) cancels ctx, which stops the background
+	// workers and starts the shutdown at the bottom.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Must get the database connection string from the environment.
 	connStr := os.Getenv("DB_CONNECTION_STRING")
 	if connStr == "" {
 		log.Fatal("DB_CONNECTION_STRING not set")
 	}
 
-	db, err := connectDB(connStr)
+	db, err := database.Connect("pgx", connStr, database.RetryConfigFromEnv())
 	if err != nil {
 		log.Fatalf("Could not connect to database: %v", err)
 	}
@@ -30,7 +51,8 @@ func main() {
 	log.Println("Database connected!")
 
 	// Initialize the repository.
-	requestRepo := request.NewPostgresRepository(db)
+	// Each repository call is cut off after DB_QUERY_TIMEOUT (default 5s), so a hung query can't hold a connection.
+	requestRepo := request.NewPostgresRepositoryWithOptions(db, request.RepositoryOptions{QueryTimeout: database.QueryTimeoutFromEnv()})
 
 	// Get external service urls from the environment.
 	billingSvcURL := os.Getenv("BILLING_SERVICE_URL")
@@ -38,28 +60,93 @@ func main() {
 	chatSvcURL := os.Getenv("CHAT_SERVICE_URL")
 	userSvcURL := os.Getenv("USER_SERVICE_URL")
 
-	// Initialize the HTTP clients for other services.
-	billingClient := request.NewHTTPBillingClient(billingSvcURL)
-	llmClient := request.NewHTTPLLMClient(llmSvcURL)
-	chatClient := request.NewHTTPChatClient(chatSvcURL)
-	userClient := request.NewHTTPUserClient(userSvcURL)
+	// Initialize the HTTP clients for other services. Each one's timeout can be set with a Go duration, eg BILLING_CLIENT_TIMEOUT=3s.
+	billingClient := request.NewHTTPBillingClient(billingSvcURL, auth.InternalTokenFromEnv(),
+		httpclient.TimeoutFromEnv("BILLING_CLIENT_TIMEOUT", request.DefaultClientTimeout))
+	// Summaries of long conversations can take a while, so the LLM timeout is configurable.
+	// The service puts that deadline on the ctx and the client honors it, so it's set in one place.
+	llmTimeout := time.Duration(envInt("LLM_TIMEOUT_SECONDS", 15)) * time.Second
+	llmClient := request.NewHTTPLLMClient(llmSvcURL, request.LLMClientConfig{Timeout: llmTimeout, HonorParentDeadline: true})
+	chatClient := request.NewHTTPChatClient(chatSvcURL, auth.InternalTokenFromEnv(), httpclient.TimeoutFromEnv("CHAT_CLIENT_TIMEOUT", request.DefaultClientTimeout))
+	userTimeout := httpclient.TimeoutFromEnv("USER_CLIENT_TIMEOUT", request.DefaultClientTimeout)
+	// Profiles are looked up on every create, so they're cached for USER_PROFILE_CACHE_SECONDS. 0 turns the cache off.
+	var userClient request.UserClient = request.NewHTTPUserClient(userSvcURL, auth.InternalTokenFromEnv(), userTimeout)
+	if ttl := envInt("USER_PROFILE_CACHE_SECONDS", 30); ttl > 0 {
+		userClient = usercache.New(userClient, time.Duration(ttl)*time.Second)
+	}
+	expertClient := request.NewHTTPExpertClient(userSvcURL, auth.InternalTokenFromEnv(), userTimeout) // Experts are served by the UserService too
+
+	// Notifications go to a webhook if one is configured, otherwise they're just logged.
+	var notificationClient request.NotificationClient
+	if notifySvcURL := os.Getenv("NOTIFICATION_SERVICE_URL"); notifySvcURL != "" {
+		notificationClient = request.NewHTTPNotificationClient(notifySvcURL,
+			httpclient.TimeoutFromEnv("NOTIFICATION_CLIENT_TIMEOUT", request.DefaultClientTimeout))
+	} else {
+		notificationClient = request.NewStubNotificationClient()
+	}
 
 	// Initialize the service, injecting dependencies.
-	requestService := request.NewService(requestRepo, billingClient, llmClient, chatClient, userClient)
+	opts := request.DefaultOptions()
+	opts.LLMTimeout = llmTimeout
+	opts.ReopenWindow = time.Duration(envInt("REOPEN_WINDOW_MINUTES", 30)) * time.Minute
+	opts.TokenCosts = map[string]int{
+		request.RequestTypeStandard: envInt("STANDARD_REQUEST_TOKEN_COST", 1),
+		request.RequestTypePriority: envInt("PRIORITY_REQUEST_TOKEN_COST", 3),
+	}
+	requestService := request.NewServiceWithOptions(requestRepo, billingClient, llmClient, chatClient, userClient, expertClient, opts)
+
+	// The outbox dispatcher delivers what CreateRequest and the accepts saved in outbox_events (removing the bot,
+	// notifying experts, telling the user an expert joined), retrying with backoff until each one goes through.
+	outboxCfg := request.DefaultOutboxConfig()
+	outboxCfg.Interval = time.Duration(envInt("OUTBOX_INTERVAL_SECONDS", 1)) * time.Second
+	outboxDispatcher := request.NewOutboxDispatcher(requestRepo, chatClient, notificationClient, expertClient, outboxCfg)
+	go outboxDispatcher.Run(ctx)
+
+	// Active requests with no activity for IDLE_REQUEST_TTL_MINUTES are resolved, in case the expert forgot.
+	// 0 turns it off.
+	if ttl := envInt("IDLE_REQUEST_TTL_MINUTES", 24*60); ttl > 0 {
+		idleCfg := request.DefaultIdleResolverConfig()
+		idleCfg.TTL = time.Duration(ttl) * time.Minute
+		go request.NewIdleResolver(requestRepo, idleCfg).Run(ctx)
+	}
+
+	// Per-user rate limit for request creation. The rate is configured per minute since that's how people think about it.
+	createLimiter := ratelimit.NewMemoryStore(ratelimit.Config{
+		Rate:  float64(envInt("CREATE_RATE_LIMIT_PER_MINUTE", 6)) / 60,
+		Burst: envInt("CREATE_RATE_LIMIT_BURST", 3),
+	})
 
 	// Initialize the handler.
-	requestHandler := request.NewHandler(requestService)
+	requestHandler := request.NewHandler(requestService, createLimiter, auth.InternalTokenFromEnv())
 
 	// Set up the chi router.
 	r := chi.NewRouter()
-	r.Use(middleware.Logger)    // Log incoming requests.
-	r.Use(middleware.Recoverer) // Prevent panics from crashing the server.
+	r.Use(middleware.Logger)  // Log incoming requests.
+	r.Use(httputil.Recoverer) // Prevent panics from crashing the server.
+	// Unknown routes and methods get a problem body too.
+	r.NotFound(httputil.NotFound)
+	r.MethodNotAllowed(httputil.MethodNotAllowed)
+	// CORS for the client app. Allowed origins come from ALLOWED_ORIGINS.
+	r.Use(cors.Middleware(cors.FromEnv()))
+	// Cap JSON request bodies. The limit comes from MAX_REQUEST_BODY_BYTES (default 1MB).
+	r.Use(jsonbody.Limit(jsonbody.MaxBytesFromEnv()))
+	// Who's calling: the UserService turns the Firebase uid the gateway verified into a user or expert id and a role.
+	r.Use(auth.Middleware(auth.NewHTTPIdentityResolver(userSvcURL, auth.InternalTokenFromEnv(), httpclient.New(userTimeout))))
 
 	// Simple health check endpoint.
 	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("RequestService OK"))
 	})
 
+	// Deep health check for the load balancer. It also pings the DB and every configured downstream.
+	healthChecker := request.NewHealthChecker(db, map[string]string{
+		"billing":      billingSvcURL,
+		"llm_gateway":  llmSvcURL,
+		"chat_gateway": chatSvcURL,
+		"user":         userSvcURL,
+	})
+	r.Get("/health/deep", healthChecker.HandleDeepHealth)
+
 	// Register all the API routes from the handler.
 	requestHandler.RegisterRoutes(r)
 
@@ -69,24 +156,70 @@ func main() {
 		port = "8082"
 	}
 
-	log.Printf("RequestService starting on port %s", port)
+	// The gRPC API for internal callers runs on its own port, sharing the same service.
+	grpcPort := os.Getenv("GRPC_PORT")
+	if grpcPort == "" {
+		grpcPort = "9082"
+	}
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", grpcPort))
+	if err != nil {
+		log.Fatalf("Could not listen on gRPC port: %v", err)
+	}
+	// Like the HTTP /internal routes, every call needs the shared INTERNAL_API_TOKEN, here in x-internal-token metadata.
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(request.InternalTokenInterceptor(auth.InternalTokenFromEnv())))
+	requestpb.RegisterRequestServiceServer(grpcServer, request.NewGRPCServer(requestService))
+	go func() {
+		log.Printf("RequestService gRPC starting on port %s", grpcPort)
+		if err := grpcServer.Serve(lis); err != nil {
+			log.Fatalf("Could not start gRPC server: %v", err)
+		}
+	}()
+
+	httpServer := &http.Server{Addr: fmt.Sprintf(":%s", port), Handler: r}
+	go func() {
+		log.Printf("RequestService starting on port %s", port)
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Could not start server: %v", err)
+		}
+	}()
+
+	// Block until we're told to stop, then let in-flight calls on both servers finish.
+	// Anything still running after SHUTDOWN_TIMEOUT_SECONDS (eg a /request/pending/watch stream) is cut off.
+	<-ctx.Done()
+	log.Println("RequestService shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(envInt("SHUTDOWN_TIMEOUT_SECONDS", 15))*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("WARNING: HTTP server did not shut down cleanly: %v", err)
+	}
+	stopGRPC(shutdownCtx, grpcServer)
+}
 
-	// Block and run the web server.
-	if err := http.ListenAndServe(fmt.Sprintf(":%s", port), r); err != nil {
-		log.Fatalf("Could not start server: %v", err)
+// stopGRPC stops the gRPC server gracefully, or outright if that hasn't finished when ctx is done.
+func stopGRPC(ctx context.Context, s *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Println("WARNING: gRPC calls still running at the shutdown deadline, stopping them")
+		s.Stop()
 	}
 }
 
-// connectDB opens and verifies the database connection.
-func connectDB(connStr string) (*sql.DB, error) {
-	// Use "pgx" as the driver name
-	db, err := sql.Open("pgx", connStr)
-	if err != nil {
-		return nil, err
+// envInt reads an integer environment variable, falling back to def if it's unset or invalid.
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
 	}
-	// Ping() verifies the connection is actually alive.
-	if err = db.Ping(); err != nil {
-		return nil, err
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("WARNING: invalid %s=%q, using default %d", name, v, def)
+		return def
 	}
-	return db, nil
+	return n
 }
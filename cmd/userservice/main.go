@@ -1,12 +1,19 @@
 package main
 
 import (
-	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"project-sage/internal/auth"
+	"project-sage/internal/billing"
+	"project-sage/internal/cors"
+	"project-sage/internal/database"
+	"project-sage/internal/httpclient"
+	"project-sage/internal/httputil"
+	"project-sage/internal/jsonbody"
 	"project-sage/internal/user" // internal package for user logic
+	"strconv"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -20,7 +27,7 @@ func main() {
 		log.Fatal("DB_CONNECTION_STRING environment variable is not set")
 	}
 
-	db, err := connectDB(connStr)
+	db, err := database.Connect("pgx", connStr, database.RetryConfigFromEnv())
 	if err != nil {
 		log.Fatalf("Could not connect to database: %v", err)
 	}
@@ -31,20 +38,48 @@ func main() {
 	// We create each layer and pass it to the next.
 
 	//  Data access layer.
-	userRepo := user.NewPostgresRepository(db)
+	// Each repository call is cut off after DB_QUERY_TIMEOUT (default 5s), so a hung query can't hold a connection.
+	repoOpts := user.RepositoryOptions{QueryTimeout: database.QueryTimeoutFromEnv()}
+	userRepo := user.NewPostgresRepositoryWithOptions(db, repoOpts)
+	expertRepo := user.NewPostgresExpertRepositoryWithOptions(db, repoOpts)
 
 	// business logic layer.
-	userService := user.NewService(userRepo)
+	// New user defaults can be overridden for promotions.
+	userCfg := user.DefaultServiceConfig()
+	userCfg.StartingTokens = envInt("NEW_USER_TOKEN_GRANT", userCfg.StartingTokens)
+	if tier := os.Getenv("NEW_USER_TIER"); tier != "" {
+		userCfg.StartingTier = tier
+	}
+	// The paid tiers and their monthly tokens, shared with the BillingService, eg "premium=10,premium_plus=25".
+	grants, err := billing.ParseTierGrants(os.Getenv("MONTHLY_TIER_GRANTS"))
+	if err != nil {
+		log.Fatalf("Invalid MONTHLY_TIER_GRANTS: %v", err)
+	}
+	userCfg.TierGrants = grants
+	// Deleting an account forfeits its tokens through the BillingService. The timeout can be set with a Go duration, eg BILLING_CLIENT_TIMEOUT=3s.
+	billingClient := user.NewHTTPBillingClient(os.Getenv("BILLING_SERVICE_URL"), auth.InternalTokenFromEnv(),
+		httpclient.TimeoutFromEnv("BILLING_CLIENT_TIMEOUT", user.DefaultClientTimeout))
+	userService := user.NewService(userRepo, expertRepo, billingClient, userCfg)
 
 	// API layer. Takes the service.
-	userHandler := user.NewHandler(userService)
+	// Other services call the internal routes with INTERNAL_API_TOKEN.
+	userHandler := user.NewHandler(userService, auth.InternalTokenFromEnv())
 
 	// Set up the chi router.
 	r := chi.NewRouter()
 
 	// Add standard middleware.
-	r.Use(middleware.Logger)    // Log requests
-	r.Use(middleware.Recoverer) // Handle panics gracefully
+	r.Use(middleware.Logger)  // Log requests
+	r.Use(httputil.Recoverer) // Handle panics gracefully
+	// Unknown routes and methods get a problem body too.
+	r.NotFound(httputil.NotFound)
+	r.MethodNotAllowed(httputil.MethodNotAllowed)
+	// CORS for the client app. Allowed origins come from ALLOWED_ORIGINS.
+	r.Use(cors.Middleware(cors.FromEnv()))
+	// Cap JSON request bodies. The limit comes from MAX_REQUEST_BODY_BYTES (default 1MB).
+	r.Use(jsonbody.Limit(jsonbody.MaxBytesFromEnv()))
+	// Who's calling, for the admin routes. This service owns the users and experts, so it looks them up itself.
+	r.Use(auth.Middleware(userService))
 
 	// Simple health check.
 	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -68,15 +103,16 @@ func main() {
 	}
 }
 
-// connectDB is a helper to open and verify the database connection.
-func connectDB(connStr string) (*sql.DB, error) {
-	db, err := sql.Open("pgx", connStr)
-	if err != nil {
-		return nil, err
+// envInt reads an integer env var, falling back to def if it's unset or bad.
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
 	}
-	// Ping() ensures the connection is actually valid.
-	if err = db.Ping(); err != nil {
-		return nil, err
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("WARNING: invalid %s=%q, using default %d", name, v, def)
+		return def
 	}
-	return db, nil
+	return n
 }
@@ -18,6 +18,7 @@ type contextKey string
 const (
 	UserIDKey   = contextKey("user_id")
 	ExpertIDKey = contextKey("expert_id")
+	RoleKey     = contextKey("role")
 )
 
 // SetUserID returns a new request with the user's ID added to its context.
@@ -52,3 +53,19 @@ func GetExpertID(ctx context.Context) (uuid.UUID, error) {
 	}
 	return id, nil
 }
+
+// SetRole returns a new request with the caller's role added to its context.
+// The auth middleware sets it from the user's or expert's Role, next to their id.
+func SetRole(r *http.Request, role string) *http.Request {
+	ctx := context.WithValue(r.Context(), RoleKey, role)
+	return r.WithContext(ctx)
+}
+
+// GetRole retrieves the caller's role from the context, eg "user", "expert" or "superadmin".
+func GetRole(ctx context.Context) (string, error) {
+	role, ok := ctx.Value(RoleKey).(string)
+	if !ok || role == "" {
+		return "", fmt.Errorf("no role in context")
+	}
+	return role, nil
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"os"
+)
+
+// Our services call each other's internal endpoints with a shared secret in a header, so being able to reach a port
+// isn't enough to use it. It's a stopgap until there's mTLS or a service mesh between them.
+
+// InternalTokenHeader is the header that carries the shared secret.
+const InternalTokenHeader = "X-Internal-Token"
+
+// InternalTokenFromEnv reads the shared secret from INTERNAL_API_TOKEN. Empty if it's unset.
+func InternalTokenFromEnv() string {
+	return os.Getenv("INTERNAL_API_TOKEN")
+}
+
+// RequireInternalToken is middleware that answers 401 unless the request's X-Internal-Token matches secret.
+// An empty secret matches nothing, so a service started without one locks its routes instead of opening them.
+func RequireInternalToken(secret string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !ValidInternalToken(secret, r.Header.Get(InternalTokenHeader)) {
+				writeAuthError(w, http.StatusUnauthorized, "Not authorized")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ValidInternalToken reports whether got is the shared secret, for transports other than HTTP headers (eg gRPC
+// metadata). Like RequireInternalToken, an empty secret matches nothing.
+func ValidInternalToken(secret, got string) bool {
+	if secret == "" {
+		return false
+	}
+	// Hashing both sides first keeps the compare constant time even when the lengths differ.
+	want, have := sha256.Sum256([]byte(secret)), sha256.Sum256([]byte(got))
+	return subtle.ConstantTimeCompare(have[:], want[:]) == 1
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// FirebaseIDHeader carries the caller's Firebase uid. The API gateway sets it once it has verified the Firebase JWT,
+// and drops any copy the client sent, so the services behind it can trust it.
+const FirebaseIDHeader = "X-Firebase-ID"
+
+// Identity is who a Firebase account is in our system. The same account can have a user and an expert profile.
+type Identity struct {
+	UserID   uuid.UUID // uuid.Nil if the account has no user profile
+	ExpertID uuid.UUID // uuid.Nil if the account has no active expert profile
+	Role     string    // One of the Role constants
+}
+
+// ErrUnknownIdentity is returned by a resolver for a Firebase account with neither a user nor an expert profile,
+// eg one that hasn't registered yet.
+var ErrUnknownIdentity = errors.New("no user or expert for this firebase account")
+
+// IdentityResolver finds who a Firebase account is. The UserService answers from its own tables, every other
+// service asks it over HTTP.
+type IdentityResolver interface {
+	ResolveIdentity(ctx context.Context, firebaseID string) (*Identity, error)
+}
+
+// Middleware puts the caller's ids and role in the request context, from the Firebase uid in X-Firebase-ID.
+// A request without the header, or from an account with no profile yet, goes through with nothing added:
+// routes that need a caller answer 401 themselves, and RequireRole does for the guarded ones.
+// If the resolver fails the request gets a 500, rather than going on as if nobody had signed in.
+func Middleware(resolver IdentityResolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			firebaseID := r.Header.Get(FirebaseIDHeader)
+			if firebaseID == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			id, err := resolver.ResolveIdentity(r.Context(), firebaseID)
+			if errors.Is(err, ErrUnknownIdentity) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if err != nil {
+				log.Printf("ERROR: could not resolve the caller's identity: %v", err)
+				writeAuthError(w, http.StatusInternalServerError, "Could not check credentials")
+				return
+			}
+			if id.UserID != uuid.Nil {
+				r = SetUserID(r, id.UserID)
+			}
+			if id.ExpertID != uuid.Nil {
+				r = SetExpertID(r, id.ExpertID)
+			}
+			if id.Role != "" {
+				r = SetRole(r, id.Role)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// resolverFunc lets a test answer ResolveIdentity with a function.
+type resolverFunc func(ctx context.Context, firebaseID string) (*Identity, error)
+
+func (f resolverFunc) ResolveIdentity(ctx context.Context, firebaseID string) (*Identity, error) {
+	return f(ctx, firebaseID)
+}
+
+// TestMiddleware checks a known account's ids and role reach the handler, and an unknown or missing one goes
+// through with nothing set.
+func TestMiddleware(t *testing.T) {
+	userID, expertID := uuid.New(), uuid.New()
+	resolver := resolverFunc(func(ctx context.Context, firebaseID string) (*Identity, error) {
+		switch firebaseID {
+		case "fb-expert":
+			return &Identity{UserID: userID, ExpertID: expertID, Role: RoleExpert}, nil
+		case "fb-broken":
+			return nil, errors.New("user service is down")
+		}
+		return nil, ErrUnknownIdentity
+	})
+
+	var gotUser, gotExpert uuid.UUID
+	var gotRole string
+	h := Middleware(resolver)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUser, _ = GetUserID(r.Context())
+		gotExpert, _ = GetExpertID(r.Context())
+		gotRole, _ = GetRole(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name       string
+		firebaseID string
+		wantStatus int
+		wantUser   uuid.UUID
+		wantExpert uuid.UUID
+		wantRole   string
+	}{
+		{"known account", "fb-expert", http.StatusOK, userID, expertID, RoleExpert},
+		{"unknown account", "fb-new", http.StatusOK, uuid.Nil, uuid.Nil, ""},
+		{"no header", "", http.StatusOK, uuid.Nil, uuid.Nil, ""},
+		{"resolver fails", "fb-broken", http.StatusInternalServerError, uuid.Nil, uuid.Nil, ""},
+	}
+	for _, tt := range tests {
+		gotUser, gotExpert, gotRole = uuid.Nil, uuid.Nil, ""
+		req := httptest.NewRequest("GET", "/request/pending", nil)
+		if tt.firebaseID != "" {
+			req.Header.Set(FirebaseIDHeader, tt.firebaseID)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != tt.wantStatus {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.wantStatus, rr.Code)
+		}
+		if gotUser != tt.wantUser || gotExpert != tt.wantExpert || gotRole != tt.wantRole {
+			t.Errorf("%s: expected user %s, expert %s, role %q; got %s, %s, %q",
+				tt.name, tt.wantUser, tt.wantExpert, tt.wantRole, gotUser, gotExpert, gotRole)
+		}
+	}
+}
+
+// TestHTTPIdentityResolver checks the lookup sends the internal token, decodes the ids that are there, and turns
+// a 404 into ErrUnknownIdentity.
+func TestHTTPIdentityResolver(t *testing.T) {
+	userID := uuid.New()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/users/internal/identity" || r.Header.Get(InternalTokenHeader) != "internal-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("firebase_id") != "fb-user" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"user_id":"` + userID.String() + `","role":"user"}`))
+	}))
+	defer srv.Close()
+	resolver := NewHTTPIdentityResolver(srv.URL, "internal-secret", srv.Client())
+
+	id, err := resolver.ResolveIdentity(context.Background(), "fb-user")
+	if err != nil {
+		t.Fatalf("ResolveIdentity() returned unexpected error: %v", err)
+	}
+	if id.UserID != userID || id.ExpertID != uuid.Nil || id.Role != RoleUser {
+		t.Errorf("Unexpected identity: %+v", id)
+	}
+	if _, err := resolver.ResolveIdentity(context.Background(), "fb-new"); !errors.Is(err, ErrUnknownIdentity) {
+		t.Errorf("Expected ErrUnknownIdentity, got %v", err)
+	}
+	if _, err := NewHTTPIdentityResolver(srv.URL, "wrong", srv.Client()).ResolveIdentity(context.Background(), "fb-user"); err == nil {
+		t.Error("Expected an error when the user service refuses the token")
+	}
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/google/uuid"
+)
+
+// httpIdentityResolver asks the UserService who a Firebase account is.
+type httpIdentityResolver struct {
+	httpClient    *http.Client
+	baseURL       string
+	internalToken string
+}
+
+// NewHTTPIdentityResolver returns an IdentityResolver that calls the UserService at baseURL with the internal token.
+func NewHTTPIdentityResolver(baseURL, internalToken string, httpClient *http.Client) IdentityResolver {
+	return &httpIdentityResolver{
+		httpClient:    httpClient,
+		baseURL:       baseURL,
+		internalToken: internalToken,
+	}
+}
+
+// identityResponse must match the UserService's GET /users/internal/identity response.
+type identityResponse struct {
+	UserID   *uuid.UUID `json:"user_id"`
+	ExpertID *uuid.UUID `json:"expert_id"`
+	Role     string     `json:"role"`
+}
+
+// ResolveIdentity implements IdentityResolver. A 404 from the UserService is ErrUnknownIdentity.
+func (c *httpIdentityResolver) ResolveIdentity(ctx context.Context, firebaseID string) (*Identity, error) {
+	u := fmt.Sprintf("%s/users/internal/identity?firebase_id=%s", c.baseURL, url.QueryEscape(firebaseID))
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create identity http request: %w", err)
+	}
+	req.Header.Set(InternalTokenHeader, c.internalToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("identity request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrUnknownIdentity
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("user service (identity) returned non-200 status: %d", resp.StatusCode)
+	}
+
+	var body identityResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("could not decode identity: %w", err)
+	}
+	id := &Identity{Role: body.Role}
+	if body.UserID != nil {
+		id.UserID = *body.UserID
+	}
+	if body.ExpertID != nil {
+		id.ExpertID = *body.ExpertID
+	}
+	return id, nil
+}
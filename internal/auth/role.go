@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"net/http"
+	"slices"
+
+	"project-sage/internal/httputil"
+)
+
+// The roles the auth middleware puts in the context. Users are "user" or "superadmin", experts are "expert".
+const (
+	RoleUser       = "user"
+	RoleExpert     = "expert"
+	RoleSuperadmin = "superadmin"
+)
+
+// RequireRole is middleware that only lets callers with the given role through. A request with no role at all
+// gets 401, since the auth middleware never saw it, and one with a different role gets 403.
+// It's a coarse check on the route. Services still check anything that depends on the request itself,
+// eg that an expert is the one assigned.
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return RequireAnyRole(role)
+}
+
+// RequireAnyRole is RequireRole for routes more than one role may call, eg experts and superadmins.
+func RequireAnyRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, err := GetRole(r.Context())
+			if err != nil {
+				writeAuthError(w, http.StatusUnauthorized, "Not authorized")
+				return
+			}
+			if !slices.Contains(roles, got) {
+				writeAuthError(w, http.StatusForbidden, "Forbidden")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// writeAuthError sends the same problem body the services' handlers do.
+func writeAuthError(w http.ResponseWriter, status int, message string) {
+	httputil.WriteError(w, status, message)
+}
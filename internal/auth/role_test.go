@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"project-sage/internal/httputil"
+)
+
+// TestRequireRole checks the matching role gets through, a different one gets 403, and none at all gets 401.
+func TestRequireRole(t *testing.T) {
+	reached := false
+	h := RequireRole(RoleExpert)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for _, tc := range []struct {
+		name string
+		role string
+		want int
+	}{
+		{"allowed", RoleExpert, http.StatusOK},
+		{"other role", RoleUser, http.StatusForbidden},
+		{"superadmin isn't an expert", RoleSuperadmin, http.StatusForbidden},
+		{"no role", "", http.StatusUnauthorized},
+	} {
+		reached = false
+		req := httptest.NewRequest("GET", "/request/pending", nil)
+		if tc.role != "" {
+			req = SetRole(req, tc.role)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != tc.want {
+			t.Errorf("%s: expected status %d, got %d", tc.name, tc.want, rr.Code)
+		}
+		if reached != (tc.want == http.StatusOK) {
+			t.Errorf("%s: expected the handler to be reached only when allowed, reached=%v", tc.name, reached)
+		}
+		if tc.want != http.StatusOK && rr.Header().Get("Content-Type") != httputil.ProblemContentType {
+			t.Errorf("%s: expected a problem, got %q", tc.name, rr.Header().Get("Content-Type"))
+		}
+	}
+}
+
+// TestRequireAnyRole checks every listed role gets through and the rest don't.
+func TestRequireAnyRole(t *testing.T) {
+	h := RequireAnyRole(RoleExpert, RoleSuperadmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for _, tc := range []struct {
+		role string
+		want int
+	}{
+		{RoleExpert, http.StatusOK},
+		{RoleSuperadmin, http.StatusOK},
+		{RoleUser, http.StatusForbidden},
+		{"", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest("POST", "/request/123/resummarize", nil)
+		if tc.role != "" {
+			req = SetRole(req, tc.role)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != tc.want {
+			t.Errorf("role %q: expected status %d, got %d", tc.role, tc.want, rr.Code)
+		}
+	}
+}
+
+// TestGetRole checks the role round trips through the context, and a missing one is an error.
+func TestGetRole(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	if _, err := GetRole(req.Context()); err == nil {
+		t.Error("Expected an error with no role in the context")
+	}
+	req = SetRole(req, RoleSuperadmin)
+	if role, err := GetRole(req.Context()); err != nil || role != RoleSuperadmin {
+		t.Errorf("Expected %q, got %q (%v)", RoleSuperadmin, role, err)
+	}
+}
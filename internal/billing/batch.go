@@ -0,0 +1,32 @@
+package billing
+
+import "github.com/google/uuid"
+
+// maxBatchCreditItems caps one /token/add-batch call. Bigger campaigns are sent in several calls with the same reference.
+const maxBatchCreditItems = 1000
+
+// BatchCreditItem is one user's credit in a batch.
+type BatchCreditItem struct {
+	UserID uuid.UUID
+	Amount int
+}
+
+// Outcomes of one item in a batch credit.
+const (
+	BatchCredited        = "credited"         // Credited by this call
+	BatchAlreadyCredited = "already_credited" // Credited under this reference before, so not again
+	BatchUserNotFound    = "user_not_found"
+	BatchCapExceeded     = "balance_cap_exceeded" // Would have taken the user over the maximum balance, so not credited
+)
+
+// BatchCreditResult is what happened to one item of a batch, in the same order the items were given.
+type BatchCreditResult struct {
+	UserID     uuid.UUID
+	Status     string
+	NewBalance int // The balance after the credit. For already_credited, the balance the first credit left. 0 otherwise
+}
+
+// Ok reports whether the user has the credit now, whether this call gave it or an earlier one did.
+func (r BatchCreditResult) Ok() bool {
+	return r.Status == BatchCredited || r.Status == BatchAlreadyCredited
+}
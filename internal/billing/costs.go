@@ -0,0 +1,82 @@
+package billing
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Cost types are what each kind of operation costs, so callers say what they're doing and billing decides the price.
+// They're configured like the tier grants, and a debit by cost type records the type in its ledger entry.
+
+// The cost types every deployment has unless TOKEN_COST_TYPES says otherwise.
+const (
+	CostStandard = "standard"  // A normal request
+	CostPriority = "priority"  // A request that jumps the queue
+	CostFollowUp = "follow_up" // Another question on a request already paid for. Free, but still in the ledger
+)
+
+// maxCostTypeLength keeps a cost type to a short label.
+const maxCostTypeLength = 64
+
+// CostTypes maps a cost type to the tokens it costs. 0 is allowed, for things that are free but worth recording.
+type CostTypes map[string]int
+
+// DefaultCostTypes returns the costs used when none are configured.
+func DefaultCostTypes() CostTypes {
+	return CostTypes{
+		CostStandard: 1,
+		CostPriority: 2,
+		CostFollowUp: 0,
+	}
+}
+
+// ParseCostTypes reads the costs from "type=amount" pairs separated by commas, eg "standard=1,priority=2,follow_up=0".
+// An empty string means the defaults.
+func ParseCostTypes(s string) (CostTypes, error) {
+	if strings.TrimSpace(s) == "" {
+		return DefaultCostTypes(), nil
+	}
+	costs := CostTypes{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		costType, rawAmount, ok := strings.Cut(pair, "=")
+		costType = strings.TrimSpace(costType)
+		if !ok || costType == "" || len(costType) > maxCostTypeLength {
+			return nil, fmt.Errorf("invalid cost type %q, want type=amount", pair)
+		}
+		amount, err := strconv.Atoi(strings.TrimSpace(rawAmount))
+		if err != nil || amount < 0 || amount > maxDebitAmount {
+			return nil, fmt.Errorf("invalid amount for cost type %q: %q, want 0 to %d", costType, rawAmount, maxDebitAmount)
+		}
+		costs[costType] = amount
+	}
+	return costs, nil
+}
+
+// DebitCost takes what costType costs, all or nothing, and records the cost type in the ledger entry.
+// A free cost type still gets its entry, and succeeds even on an empty balance. Returns ErrUnknownCostType for
+// a cost type that isn't configured.
+func (s *service) DebitCost(ctx context.Context, userID uuid.UUID, costType, referenceID string) (*LedgerEntry, error) {
+	amount, ok := s.costs[costType]
+	if !ok {
+		s.metrics.debited(opDebitCost, ErrUnknownCostType)
+		return nil, fmt.Errorf("%w %q", ErrUnknownCostType, costType)
+	}
+	entry, err := s.repo.DebitCost(ctx, userID, amount, costType, referenceID)
+	s.metrics.debited(opDebitCost, err)
+	if err != nil {
+		return nil, err
+	}
+	// Nothing changed for a free one, so there's nothing to tell anyone.
+	if entry.Amount > 0 {
+		s.publishDebit(ctx, newBalanceEvent("ledger:"+entry.EntryID.String(), userID, -entry.Amount, entry.BalanceAfter, ReasonDebit))
+	}
+	return entry, nil
+}
@@ -0,0 +1,92 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"go.uber.org/mock/gomock"
+)
+
+func TestParseCostTypes(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    CostTypes
+		wantErr bool
+	}{
+		{"", DefaultCostTypes(), false},
+		{"standard=1", CostTypes{"standard": 1}, false},
+		{" standard = 1 , follow_up=0, urgent=5,", CostTypes{"standard": 1, "follow_up": 0, "urgent": 5}, false},
+		{"standard", nil, true},
+		{"=1", nil, true},
+		{"standard=one", nil, true},
+		{"standard=-1", nil, true},
+		// Over what one debit is allowed to take.
+		{"standard=11", nil, true},
+	}
+	for _, tc := range tests {
+		got, err := ParseCostTypes(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ParseCostTypes(%q) error = %v, wantErr %v", tc.in, err, tc.wantErr)
+			continue
+		}
+		if !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("ParseCostTypes(%q) = %v, want %v", tc.in, got, tc.want)
+		}
+	}
+}
+
+// TestService_DebitCost checks a cost type is debited at its price with the type passed down for the ledger,
+// a free one is still recorded but sends no event, and an unknown one never reaches the repository.
+func TestService_DebitCost(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	var events []*BalanceEvent
+	s := NewServiceWithOptions(mockRepo, Options{
+		CostTypes: CostTypes{"standard": 1, "priority": 3, "follow_up": 0},
+		Publisher: capturePublisher(ctrl, &events),
+	})
+
+	ctx := context.Background()
+	userID := uuid.New()
+	mockRepo.EXPECT().DebitCost(ctx, userID, 3, "priority", "req-1").
+		Return(&LedgerEntry{EntryID: uuid.New(), Amount: 3, CostType: "priority", BalanceAfter: 2}, nil)
+	mockRepo.EXPECT().DebitCost(ctx, userID, 0, "follow_up", "req-1:2").
+		Return(&LedgerEntry{EntryID: uuid.New(), Amount: 0, CostType: "follow_up", BalanceAfter: 2}, nil)
+
+	entry, err := s.DebitCost(ctx, userID, "priority", "req-1")
+	if err != nil {
+		t.Fatalf("DebitCost() returned unexpected error: %v", err)
+	}
+	if entry.Amount != 3 || entry.CostType != "priority" {
+		t.Errorf("Unexpected entry %+v", entry)
+	}
+	if _, err := s.DebitCost(ctx, userID, "follow_up", "req-1:2"); err != nil {
+		t.Fatalf("DebitCost() returned unexpected error for a free cost type: %v", err)
+	}
+	if _, err := s.DebitCost(ctx, userID, "express", ""); !errors.Is(err, ErrUnknownCostType) {
+		t.Errorf("Expected ErrUnknownCostType, got %v", err)
+	}
+
+	if len(events) != 1 || events[0].Delta != -3 || events[0].NewBalance != 2 {
+		t.Errorf("Expected one event for the priced debit, got %+v", events)
+	}
+}
+
+// TestService_DefaultCostTypes checks a service without configured cost types keeps the standard single token.
+func TestService_DefaultCostTypes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	s := NewService(mockRepo)
+
+	ctx := context.Background()
+	userID := uuid.New()
+	mockRepo.EXPECT().DebitCost(ctx, userID, 1, CostStandard, "").Return(&LedgerEntry{Amount: 1}, nil)
+	if _, err := s.DebitCost(ctx, userID, CostStandard, ""); err != nil {
+		t.Fatalf("DebitCost() returned unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package billing
+
+import "errors"
+
+// Sentinel errors returned by the repository and passed up through the service.
+// The handler maps these to status codes with errors.Is.
+var (
+	// ErrInsufficientFunds means the debit guard failed. The atomic UPDATE can't tell a zero balance from a missing user, so this covers both.
+	ErrInsufficientFunds = errors.New("insufficient funds or user not found")
+	// ErrNotFound means the user does not exist.
+	ErrNotFound = errors.New("user not found")
+	// ErrInvalidAmount means a debit asked for zero or fewer tokens.
+	ErrInvalidAmount = errors.New("amount must be positive")
+	// ErrDebitNotFound means there's no debit for that user with the given entry id or reference.
+	ErrDebitNotFound = errors.New("debit not found")
+	// ErrAlreadyRefunded means the debit has been refunded before.
+	ErrAlreadyRefunded = errors.New("debit already refunded")
+	// ErrHoldNotFound means the user has no hold with that id.
+	ErrHoldNotFound = errors.New("hold not found")
+	// ErrHoldReleased means the hold's tokens were already given back, so it can't be committed.
+	ErrHoldReleased = errors.New("hold already released")
+	// ErrHoldCommitted means the hold was already spent, so it can't be released.
+	ErrHoldCommitted = errors.New("hold already committed")
+	// ErrInvalidExpiry means expiring tokens were credited with an expiry that has already passed.
+	ErrInvalidExpiry = errors.New("expiry must be in the future")
+	// ErrInvalidSource means a credit's source wasn't "purchase", "grant" or "refund".
+	ErrInvalidSource = errors.New("source must be purchase, grant or refund")
+	// ErrCreditTooLarge means a single credit asked for more tokens than one call is allowed to add.
+	ErrCreditTooLarge = errors.New("amount is over the most one credit can add")
+	// ErrUnknownCostType means a debit asked for a cost type that isn't configured.
+	ErrUnknownCostType = errors.New("unknown cost type")
+	// ErrBalanceCapExceeded means a credit would take the balance over the configured maximum, so none of it was credited.
+	// No real user gets near the cap, so this is usually a bug upstream, eg a payment credited in a loop.
+	ErrBalanceCapExceeded = errors.New("credit would exceed the maximum balance")
+)
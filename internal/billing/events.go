@@ -0,0 +1,258 @@
+package billing
+
+//go:generate mockgen -destination=./events_mock_test.go -package=billing -source=events.go Publisher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"project-sage/internal/httpclient"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// After every debit or credit the service publishes a token_balance_changed event, so other services
+// (the UserService's cache, notifications) hear about it without polling /token/balance.
+// Publishing never fails the billing operation. The worst case is a late or lost event, and new_balance
+// is always the real balance, so a consumer that missed one catches up on the next.
+
+// EventTokenBalanceChanged is the type of every BalanceEvent, except low balance ones.
+const EventTokenBalanceChanged = "token_balance_changed"
+
+// EventLowBalance is sent as well as the token_balance_changed event when a debit or hold takes a balance from above
+// the low balance threshold to at or below it, so the app can offer more tokens. It's sent once per crossing:
+// debits that fail for lack of tokens, or that start at or below the threshold already, don't send another.
+const EventLowBalance = "low_balance"
+
+// Why a balance changed, the reason field of a BalanceEvent.
+const (
+	ReasonDebit        = "debit"
+	ReasonRefund       = "refund"
+	ReasonCredit       = "credit"
+	ReasonBatchCredit  = "batch_credit"
+	ReasonHold         = "hold"          // Tokens set aside for a request. They're off the balance straight away
+	ReasonHoldReleased = "hold_released" // A hold given back
+	ReasonForfeit      = "forfeit"       // The balance was taken away because the account was deleted
+)
+
+// BalanceEvent is the payload of a token_balance_changed event.
+type BalanceEvent struct {
+	Type string `json:"type"`
+	// EventID is the same every time the same operation is retried (eg a repeated reference_id),
+	// so consumers can drop repeats instead of applying the delta twice.
+	EventID    string    `json:"event_id"`
+	UserID     uuid.UUID `json:"user_id"`
+	Delta      int       `json:"delta"` // Negative for debits and holds
+	NewBalance int       `json:"new_balance"`
+	Reason     string    `json:"reason"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// newBalanceEvent fills in the type and the time.
+func newBalanceEvent(eventID string, userID uuid.UUID, delta, newBalance int, reason string) *BalanceEvent {
+	return &BalanceEvent{
+		Type:       EventTokenBalanceChanged,
+		EventID:    eventID,
+		UserID:     userID,
+		Delta:      delta,
+		NewBalance: newBalance,
+		Reason:     reason,
+		OccurredAt: time.Now().UTC(),
+	}
+}
+
+// newLowBalanceEvent is the low_balance event for the debit or hold that sent ev. Its event id follows ev's,
+// so a retried debit repeats it just like it repeats ev.
+func newLowBalanceEvent(ev *BalanceEvent) *BalanceEvent {
+	low := *ev
+	low.Type = EventLowBalance
+	low.EventID = "low_balance:" + ev.EventID
+	return &low
+}
+
+// Publisher sends balance events to whoever is listening.
+type Publisher interface {
+	Publish(ctx context.Context, ev *BalanceEvent) error
+}
+
+// noopPublisher drops every event. It's the default, for when nobody is listening.
+type noopPublisher struct{}
+
+// NewNoopPublisher returns a Publisher that does nothing.
+func NewNoopPublisher() Publisher {
+	return noopPublisher{}
+}
+
+func (noopPublisher) Publish(ctx context.Context, ev *BalanceEvent) error {
+	return nil
+}
+
+// webhookPublisher POSTs each event as JSON to one URL.
+type webhookPublisher struct {
+	httpClient *http.Client
+	url        string
+}
+
+// NewWebhookPublisher is the constructor. Anything but a 2xx from url counts as a failure.
+func NewWebhookPublisher(url string) Publisher {
+	return &webhookPublisher{
+		httpClient: httpclient.New(5 * time.Second),
+		url:        url,
+	}
+}
+
+func (p *webhookPublisher) Publish(ctx context.Context, ev *BalanceEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("could not marshal balance event: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", p.url, bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("could not create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned non-2xx status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ErrPublishQueueFull means the PublishQueue had no room, so the event was dropped.
+var ErrPublishQueueFull = errors.New("balance event queue is full")
+
+// PublishQueueConfig tunes the queue. Zero values are filled from DefaultPublishQueueConfig.
+type PublishQueueConfig struct {
+	Size        int           // Events waiting before new ones are dropped
+	Timeout     time.Duration // Per-attempt deadline
+	MinBackoff  time.Duration // Wait after the first failure. It doubles per attempt
+	MaxBackoff  time.Duration // Cap on the wait between attempts
+	MaxAttempts int           // Attempts before an event is given up on
+}
+
+// DefaultPublishQueueConfig returns the settings used when none are configured.
+func DefaultPublishQueueConfig() PublishQueueConfig {
+	return PublishQueueConfig{
+		Size:        1000,
+		Timeout:     5 * time.Second,
+		MinBackoff:  time.Second,
+		MaxBackoff:  time.Minute,
+		MaxAttempts: 10,
+	}
+}
+
+// withDefaults fills any zero settings from DefaultPublishQueueConfig.
+func (c PublishQueueConfig) withDefaults() PublishQueueConfig {
+	d := DefaultPublishQueueConfig()
+	if c.Size <= 0 {
+		c.Size = d.Size
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = d.Timeout
+	}
+	if c.MinBackoff <= 0 {
+		c.MinBackoff = d.MinBackoff
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = d.MaxBackoff
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = d.MaxAttempts
+	}
+	return c
+}
+
+// backoff is how long to wait before the next try, after attempts tries have already failed.
+func (c PublishQueueConfig) backoff(attempts int) time.Duration {
+	wait := c.MinBackoff
+	for i := 1; i < attempts && wait < c.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > c.MaxBackoff {
+		wait = c.MaxBackoff
+	}
+	return wait
+}
+
+// PublishQueue is a Publisher that hands events to another one in the background, retrying failures with backoff.
+// Publish only queues, so a slow or dead webhook never holds up a debit. Events go out one at a time in order,
+// so a consumer never sees an older balance after a newer one.
+// The queue is in memory, so whatever is still in it when the service stops is lost.
+type PublishQueue struct {
+	next   Publisher
+	cfg    PublishQueueConfig
+	events chan *BalanceEvent
+	sleep  func(ctx context.Context, d time.Duration) // Waits between attempts. Tests swap it out
+}
+
+// NewPublishQueue is the constructor. Nothing is delivered until Run is started.
+func NewPublishQueue(next Publisher, cfg PublishQueueConfig) *PublishQueue {
+	cfg = cfg.withDefaults()
+	return &PublishQueue{
+		next:   next,
+		cfg:    cfg,
+		events: make(chan *BalanceEvent, cfg.Size),
+		sleep:  sleepCtx,
+	}
+}
+
+// Publish queues the event. It returns ErrPublishQueueFull instead of blocking if there's no room.
+func (q *PublishQueue) Publish(ctx context.Context, ev *BalanceEvent) error {
+	select {
+	case q.events <- ev:
+		return nil
+	default:
+		return ErrPublishQueueFull
+	}
+}
+
+// Run delivers queued events until ctx is done.
+func (q *PublishQueue) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-q.events:
+			q.deliver(ctx, ev)
+		}
+	}
+}
+
+// deliver tries one event until it goes through, runs out of attempts or ctx is done.
+func (q *PublishQueue) deliver(ctx context.Context, ev *BalanceEvent) bool {
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, q.cfg.Timeout)
+		err := q.next.Publish(attemptCtx, ev)
+		cancel()
+		if err == nil {
+			return true
+		}
+		if attempt >= q.cfg.MaxAttempts || ctx.Err() != nil {
+			fmt.Printf("WARNING: Gave up on balance event %s for user %s after %d attempts: %v\n", ev.EventID, ev.UserID, attempt, err)
+			return false
+		}
+		wait := q.cfg.backoff(attempt)
+		fmt.Printf("WARNING: Balance event %s for user %s failed on attempt %d, retrying in %s: %v\n", ev.EventID, ev.UserID, attempt, wait, err)
+		q.sleep(ctx, wait)
+	}
+}
+
+// sleepCtx waits for d, or less if ctx is done first.
+func sleepCtx(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
@@ -0,0 +1,94 @@
+package billing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/mock/gomock"
+)
+
+// TestPublishQueue_Retries checks a failed event is retried with backoff until it goes through,
+// and one that never does is given up on after MaxAttempts.
+func TestPublishQueue_Retries(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	next := NewMockPublisher(ctrl)
+	q := NewPublishQueue(next, PublishQueueConfig{MinBackoff: time.Second, MaxBackoff: 3 * time.Second, MaxAttempts: 4})
+	var waits []time.Duration
+	q.sleep = func(_ context.Context, d time.Duration) { waits = append(waits, d) }
+
+	ok := newBalanceEvent("ledger:1", uuid.New(), -1, 2, ReasonDebit)
+	lost := newBalanceEvent("ledger:2", uuid.New(), -1, 1, ReasonDebit)
+	down := errors.New("webhook down")
+	gomock.InOrder(
+		next.EXPECT().Publish(gomock.Any(), ok).Return(down),
+		next.EXPECT().Publish(gomock.Any(), ok).Return(nil),
+		next.EXPECT().Publish(gomock.Any(), lost).Return(down).Times(4),
+	)
+
+	ctx := context.Background()
+	if !q.deliver(ctx, ok) {
+		t.Error("Expected the first event to go through on the retry")
+	}
+	if q.deliver(ctx, lost) {
+		t.Error("Expected the second event to be given up on")
+	}
+	// One wait for the first event, then three for the second, doubling up to the cap.
+	want := []time.Duration{time.Second, time.Second, 2 * time.Second, 3 * time.Second}
+	if len(waits) != len(want) {
+		t.Fatalf("Expected waits %v, got %v", want, waits)
+	}
+	for i := range want {
+		if waits[i] != want[i] {
+			t.Errorf("Wait %d: expected %v, got %v", i, want[i], waits[i])
+		}
+	}
+}
+
+// TestPublishQueue_Full checks Publish doesn't block once the queue is full.
+func TestPublishQueue_Full(t *testing.T) {
+	q := NewPublishQueue(NewNoopPublisher(), PublishQueueConfig{Size: 1})
+	ev := newBalanceEvent("credit:1", uuid.New(), 1, 1, ReasonCredit)
+
+	if err := q.Publish(context.Background(), ev); err != nil {
+		t.Fatalf("Expected the first event to be queued, got %v", err)
+	}
+	if err := q.Publish(context.Background(), ev); !errors.Is(err, ErrPublishQueueFull) {
+		t.Errorf("Expected ErrPublishQueueFull, got %v", err)
+	}
+}
+
+// TestWebhookPublisher checks the event is posted as JSON and a non-2xx is an error.
+func TestWebhookPublisher(t *testing.T) {
+	var got BalanceEvent
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Expected a JSON POST, got %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	pub := NewWebhookPublisher(server.URL)
+	ev := newBalanceEvent("hold:1", uuid.New(), -3, 4, ReasonHold)
+	if err := pub.Publish(context.Background(), ev); err != nil {
+		t.Fatalf("Publish() returned error: %v", err)
+	}
+	if got.Type != EventTokenBalanceChanged || got.EventID != "hold:1" || got.UserID != ev.UserID || got.Delta != -3 || got.NewBalance != 4 || got.Reason != ReasonHold {
+		t.Errorf("Unexpected payload %+v", got)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := pub.Publish(context.Background(), ev); err == nil {
+		t.Error("Expected an error for a 503")
+	}
+}
@@ -0,0 +1,27 @@
+package billing
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// When a user deletes their account, the user service anonymizes their row but keeps it, so their requests and
+// ledger still add up. Whatever tokens they had left are forfeited, so a deleted account can't be left holding
+// a balance, and the ledger says where the tokens went.
+
+// ForfeitBalance takes the user's whole spendable balance and returns how much that was. Calling it again
+// forfeits nothing and returns 0. Returns ErrNotFound for unknown users.
+func (s *service) ForfeitBalance(ctx context.Context, userID uuid.UUID, referenceID string) (int, error) {
+	entry, err := s.repo.ForfeitBalance(ctx, userID, referenceID)
+	s.metrics.debited(opForfeit, err)
+	if err != nil {
+		return 0, err
+	}
+	if entry == nil {
+		return 0, nil
+	}
+	// Not publishDebit: the account is gone, so there's nobody to warn about a low balance.
+	s.publish(ctx, newBalanceEvent("ledger:"+entry.EntryID.String(), userID, -entry.Amount, entry.BalanceAfter, ReasonForfeit))
+	return entry.Amount, nil
+}
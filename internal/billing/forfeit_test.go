@@ -0,0 +1,39 @@
+package billing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"go.uber.org/mock/gomock"
+)
+
+// TestService_ForfeitBalance checks a forfeit sends one event with the forfeit reason, and a repeat with nothing
+// left to take returns 0 without sending another.
+func TestService_ForfeitBalance(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	var events []*BalanceEvent
+	s := NewServiceWithOptions(mockRepo, Options{Publisher: capturePublisher(ctrl, &events), LowBalanceThreshold: 2})
+
+	ctx := context.Background()
+	userID := uuid.New()
+	gomock.InOrder(
+		mockRepo.EXPECT().ForfeitBalance(ctx, userID, "account-deleted").
+			Return(&LedgerEntry{EntryID: uuid.New(), Kind: ledgerKindForfeit, Amount: 6, BalanceAfter: 0}, nil),
+		mockRepo.EXPECT().ForfeitBalance(ctx, userID, "account-deleted").Return(nil, nil),
+	)
+
+	if n, err := s.ForfeitBalance(ctx, userID, "account-deleted"); err != nil || n != 6 {
+		t.Fatalf("Expected 6 forfeited, got %d, %v", n, err)
+	}
+	if n, err := s.ForfeitBalance(ctx, userID, "account-deleted"); err != nil || n != 0 {
+		t.Fatalf("Expected nothing forfeited the second time, got %d, %v", n, err)
+	}
+
+	// No low_balance event, even though the balance went under the threshold.
+	if len(events) != 1 || events[0].Reason != ReasonForfeit || events[0].Delta != -6 || events[0].NewBalance != 0 {
+		t.Errorf("Expected one forfeit event of -6, got %+v", events)
+	}
+}
@@ -0,0 +1,56 @@
+package billing
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TierGrants maps a membership tier to the tokens it gets each month. Tiers that aren't listed get nothing.
+type TierGrants map[string]int
+
+// ParseTierGrants reads the grants from "tier=amount" pairs separated by commas, eg "premium=10,premium_plus=25".
+// An empty string means no tier gets a monthly grant.
+func ParseTierGrants(s string) (TierGrants, error) {
+	grants := TierGrants{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		tier, rawAmount, ok := strings.Cut(pair, "=")
+		tier = strings.TrimSpace(tier)
+		if !ok || tier == "" {
+			return nil, fmt.Errorf("invalid tier grant %q, want tier=amount", pair)
+		}
+		amount, err := strconv.Atoi(strings.TrimSpace(rawAmount))
+		if err != nil || amount <= 0 {
+			return nil, fmt.Errorf("invalid amount for tier %q: %q", tier, rawAmount)
+		}
+		grants[tier] = amount
+	}
+	return grants, nil
+}
+
+// tiers returns the configured tiers in a fixed order, so a cycle always runs them the same way.
+func (g TierGrants) tiers() []string {
+	tiers := make([]string, 0, len(g))
+	for tier := range g {
+		tiers = append(tiers, tier)
+	}
+	sort.Strings(tiers)
+	return tiers
+}
+
+// grantCycle is the ledger reference for the month t falls in, in UTC, eg "2024-05".
+func grantCycle(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// GrantCycleResult is what one run of the monthly grant did.
+type GrantCycleResult struct {
+	Cycle   string         `json:"cycle"`
+	Granted map[string]int `json:"granted"` // Users credited per tier. Users already granted this cycle aren't counted
+}
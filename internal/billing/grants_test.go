@@ -0,0 +1,88 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.uber.org/mock/gomock"
+)
+
+func TestParseTierGrants(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    TierGrants
+		wantErr bool
+	}{
+		{"", TierGrants{}, false},
+		{"premium=10", TierGrants{"premium": 10}, false},
+		{" premium = 10 , premium_plus=25,", TierGrants{"premium": 10, "premium_plus": 25}, false},
+		{"premium", nil, true},
+		{"=10", nil, true},
+		{"premium=ten", nil, true},
+		{"premium=0", nil, true},
+		{"premium=-5", nil, true},
+	}
+	for _, tc := range tests {
+		got, err := ParseTierGrants(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ParseTierGrants(%q) error = %v, wantErr %v", tc.in, err, tc.wantErr)
+			continue
+		}
+		if !tc.wantErr && !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("ParseTierGrants(%q) = %v, want %v", tc.in, got, tc.want)
+		}
+	}
+}
+
+// TestService_GrantMonthlyTokens checks every configured tier is granted its amount for the current month.
+func TestService_GrantMonthlyTokens(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := NewMockRepository(ctrl)
+	s := NewServiceWithOptions(mockRepo, Options{Grants: TierGrants{"premium": 10, "premium_plus": 25}}).(*service)
+	// Late on the last day of the month somewhere east of UTC is still the next month in UTC.
+	s.now = func() time.Time { return time.Date(2024, 6, 1, 1, 0, 0, 0, time.FixedZone("CEST", 2*60*60)) }
+
+	gomock.InOrder(
+		mockRepo.EXPECT().GrantTierTokens(gomock.Any(), "premium", 10, "2024-05").Return(3, nil),
+		mockRepo.EXPECT().GrantTierTokens(gomock.Any(), "premium_plus", 25, "2024-05").Return(1, nil),
+	)
+
+	result, err := s.GrantMonthlyTokens(context.Background())
+	if err != nil {
+		t.Fatalf("GrantMonthlyTokens() returned unexpected error: %v", err)
+	}
+	want := &GrantCycleResult{Cycle: "2024-05", Granted: map[string]int{"premium": 3, "premium_plus": 1}}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("Expected %+v, got %+v", want, result)
+	}
+}
+
+// TestService_GrantMonthlyTokens_Fails checks a failed tier stops the cycle and says which tier it was.
+func TestService_GrantMonthlyTokens_Fails(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := NewMockRepository(ctrl)
+	s := NewServiceWithOptions(mockRepo, Options{Grants: TierGrants{"premium": 10, "premium_plus": 25}})
+
+	dbErr := errors.New("db down")
+	mockRepo.EXPECT().GrantTierTokens(gomock.Any(), "premium", 10, gomock.Any()).Return(0, dbErr)
+
+	result, err := s.GrantMonthlyTokens(context.Background())
+	if !errors.Is(err, dbErr) {
+		t.Fatalf("Expected the repository error, got %v", err)
+	}
+	if len(result.Granted) != 0 {
+		t.Errorf("Expected nothing granted, got %v", result.Granted)
+	}
+
+	// No grants configured is a no-op.
+	if result, err := NewService(mockRepo).GrantMonthlyTokens(context.Background()); err != nil || len(result.Granted) != 0 {
+		t.Errorf("Expected an empty cycle, got %+v, %v", result, err)
+	}
+}
@@ -1,23 +1,39 @@
 package billing
 
 import (
-	"encoding/json"
+	_ "embed"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
+
+	"project-sage/internal/httputil"
+	"project-sage/internal/jsonbody"
+	"project-sage/internal/openapi"
+	"project-sage/internal/ratelimit"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 )
 
+// openAPISpec is the hand-written API contract, served at /openapi.json. Keep it in step with the routes and DTOs below.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
 // Handler is the API layer for the billing service.
 // It holds a reference to the service, which has the business logic.
 type Handler struct {
-	service Service
+	service      Service
+	debitLimiter ratelimit.Store // Per-user limit on POST /token/debit. nil disables it.
 }
 
 // NewHandler is the constructor for the handler.
-func NewHandler(s Service) *Handler {
+func NewHandler(s Service, debitLimiter ratelimit.Store) *Handler {
 	return &Handler{
-		service: s,
+		service:      s,
+		debitLimiter: debitLimiter,
 	}
 }
 
@@ -25,109 +41,709 @@ func NewHandler(s Service) *Handler {
 func (h *Handler) RegisterRoutes(r chi.Router) {
 	r.Post("/token/debit", h.handleDebitToken)
 
+	// Gives back one specific debit, eg for a request that never got created.
+	r.Post("/token/refund", h.handleRefundDebit)
+
+	// Two step spending: hold the tokens, then commit or release them. Holds nobody resolves are released by the HoldSweeper.
+	r.Post("/token/hold", h.handleHoldTokens)
+	r.Post("/token/commit", h.handleCommitHold)
+	r.Post("/token/release", h.handleReleaseHold)
+
 	r.Post("/token/add", h.handleCreditToken)
+
+	// Admin only: campaigns and bulk grants from support (eg an outage apology), with a result per user.
+	r.Post("/token/add-batch", h.handleCreditBatch)
+
+	// The authoritative balance, for other services and the client app.
+	r.Get("/token/balance/{user_id}", h.handleGetBalance)
+
+	// The user's token history, newest first, for the app and for support.
+	r.Get("/token/ledger/{user_id}", h.handleGetLedger)
+
+	// Internal: called by the scheduler to hand out this month's tier grants. Safe to call again.
+	r.Post("/token/grant-cycle", h.handleGrantCycle)
+
+	// Internal: called by the scheduler every night to check balances against the ledger.
+	r.Post("/token/reconcile", h.handleReconcile)
+
+	// Internal: called by the user service when an account is deleted, to take whatever tokens are left.
+	r.Post("/token/forfeit", h.handleForfeitBalance)
+
+	// The API contract, for client generation. Behind the internal token like everything else here.
+	r.Get(openapi.Path, openapi.Handler(openAPISpec))
 }
 
 // --- DTOs ---
 
 type creditRequest struct {
-	UserID string `json:"user_id"`
-	Amount int    `json:"amount"`
+	UserID      string     `json:"user_id"`
+	Amount      int        `json:"amount"`
+	ReferenceID string     `json:"reference_id,omitempty"` // Optional. The same reference is only ever credited once per user
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`   // Optional. The tokens run out then, eg a trial, and are spent first
+	Source      string     `json:"source,omitempty"`       // "purchase", "grant" or "refund", defaulting to "purchase". With expires_at, a label instead, defaulting to "promo"
 }
 
+// defaultLotSource is the source for expiring tokens credited without one.
+const defaultLotSource = "promo"
+
+// defaultCreditSource is the source for other tokens credited without one. Crediting was only ever done for
+// purchases before the balance was split, so callers from then mean a purchase.
+const defaultCreditSource = SourcePurchase
+
+// maxReferenceIDLength keeps references to something that fits comfortably in the ledger's index.
+const maxReferenceIDLength = 255
+
 type creditResponse struct {
 	NewBalance int `json:"new_balance"`
 }
 
-type debitRequest struct {
+// creditBatchRequest is a campaign's credits. reference_id names the campaign, and nobody is credited twice under it.
+type creditBatchRequest struct {
+	ReferenceID string            `json:"reference_id"`
+	Items       []creditBatchItem `json:"items"`
+}
+
+type creditBatchItem struct {
 	UserID string `json:"user_id"`
+	Amount int    `json:"amount"`
+}
+
+type creditBatchResponse struct {
+	ReferenceID string              `json:"reference_id"`
+	Credited    int                 `json:"credited"` // Items credited by this call
+	Failed      int                 `json:"failed"`   // Items the user doesn't have the credit for, eg user_not_found
+	Results     []creditBatchResult `json:"results"`  // One per item, in request order
+}
+
+type creditBatchResult struct {
+	UserID     string `json:"user_id"`
+	Status     string `json:"status"` // "credited", "already_credited" or "user_not_found"
+	NewBalance *int   `json:"new_balance,omitempty"`
 }
 
+type debitRequest struct {
+	UserID      string `json:"user_id"`
+	Amount      *int   `json:"amount,omitempty"`       // Optional, defaults to 1
+	CostType    string `json:"cost_type,omitempty"`    // Optional, instead of amount. Billing prices it from its cost types
+	ReferenceID string `json:"reference_id,omitempty"` // Optional, lets the caller refund this debit by its own id
+}
+
+// maxDebitAmount caps a single debit, so a bad caller can't wipe out a balance in one go.
+const maxDebitAmount = 10
+
 type debitResponse struct {
+	NewBalance int    `json:"new_balance"`
+	EntryID    string `json:"entry_id"` // The ledger entry, for /token/refund
+}
+
+// refundRequest names the debit to give back, by entry_id or reference_id. Exactly one of them.
+type refundRequest struct {
+	UserID      string `json:"user_id"`
+	EntryID     string `json:"entry_id,omitempty"`
+	ReferenceID string `json:"reference_id,omitempty"`
+}
+
+type refundResponse struct {
+	NewBalance int    `json:"new_balance"`
+	EntryID    string `json:"entry_id"`  // The refund's own ledger entry
+	RefundOf   string `json:"refund_of"` // The debit it reversed
+}
+
+// holdRequest is a debit without cost_type. The amount defaults to 1 here too.
+type holdRequest struct {
+	UserID      string `json:"user_id"`
+	Amount      *int   `json:"amount,omitempty"`
+	ReferenceID string `json:"reference_id,omitempty"`
+}
+
+type holdResponse struct {
+	HoldID     string `json:"hold_id"`
+	NewBalance int    `json:"new_balance"` // Spendable balance, with the hold already taken off
+}
+
+// resolveHoldRequest names the hold to commit or release.
+type resolveHoldRequest struct {
+	UserID string `json:"user_id"`
+	HoldID string `json:"hold_id"`
+}
+
+type balanceResponse struct {
+	Balance     int                `json:"balance"`      // Everything the user can spend
+	NonExpiring int                `json:"non_expiring"` // The part of balance that never runs out
+	Expiring    []expiringResponse `json:"expiring"`     // The rest, soonest first
+	Purchased   int                `json:"purchased"`    // The part of balance the user paid for
+	Granted     int                `json:"granted"`      // The part they were given. It's spent first
+}
+
+type expiringResponse struct {
+	ExpiresAt time.Time `json:"expires_at"`
+	Amount    int       `json:"amount"`
+}
+
+type ledgerEntryResponse struct {
+	EntryID      string    `json:"entry_id"`
+	Delta        int       `json:"delta"`               // Negative for a debit or expiry
+	Granted      int       `json:"granted"`             // How much of delta was granted tokens, with the same sign. The rest was purchased
+	Reason       string    `json:"reason"`              // The ledger kind: "debit", "refund", "credit", "monthly_grant", "expired" or "opening"
+	Reference    string    `json:"reference,omitempty"` // The caller's reference, the cycle for a monthly grant, or the lot that expired
+	RefundOf     string    `json:"refund_of,omitempty"` // For a refund, the debit it reversed
+	CostType     string    `json:"cost_type,omitempty"` // For a debit by cost type, which one
+	Source       string    `json:"source,omitempty"`    // For a credit, where it came from: "purchase", "grant", "refund", or an expiring grant's own source
+	BalanceAfter int       `json:"balance_after"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type reconcileResponse struct {
+	Discrepancies []Discrepancy `json:"discrepancies"`
+}
+
+// forfeitRequest is an account deletion's forfeit. reference_id is optional, and only goes in the ledger.
+type forfeitRequest struct {
+	UserID      string `json:"user_id"`
+	ReferenceID string `json:"reference_id,omitempty"`
+}
+
+type forfeitResponse struct {
+	Forfeited  int `json:"forfeited"` // 0 if there was nothing left, eg on a retry
 	NewBalance int `json:"new_balance"`
 }
 
+type ledgerResponse struct {
+	Entries    []ledgerEntryResponse `json:"entries"`
+	NextCursor string                `json:"next_cursor,omitempty"` // Pass back as cursor for the next page. Left out on the last page
+}
+
 // --- Handlers ---
 
 // handleDebitToken is the main handler function for our one endpoint.
 func (h *Handler) handleDebitToken(w http.ResponseWriter, r *http.Request) {
 	// Try to decode the json body into our struct.
 	var req debitRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid request payload")
+	if err := jsonbody.Decode(w, r, &req); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid request payload")
 		return
 	}
 
 	// Validate that the UserID is a real uuid.
 	userID, err := uuid.Parse(req.UserID)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid user_id format")
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid user_id format")
+		return
+	}
+
+	// Every call comes from one of our own services, so the caller's IP says nothing. The limit is on the user
+	// being debited, so a client stuck in a loop can't drain its own balance in seconds.
+	if h.debitLimiter != nil && !ratelimit.Allow(w, r, h.debitLimiter, "user:"+userID.String()) {
+		return
+	}
+
+	// A cost type says what the debit is for and billing says what it costs, so it can't come with an amount too.
+	if req.CostType != "" && req.Amount != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "Only one of amount or cost_type is allowed")
+		return
+	}
+
+	// No amount means the usual single token. An explicit one has to be sensible.
+	amount := 1
+	if req.Amount != nil {
+		amount = *req.Amount
+		if amount <= 0 || amount > maxDebitAmount {
+			httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("Amount must be between 1 and %d", maxDebitAmount))
+			return
+		}
+	}
+
+	if len(req.ReferenceID) > maxReferenceIDLength {
+		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("reference_id must be at most %d characters", maxReferenceIDLength))
 		return
 	}
 
 	// This calls the business logic.
-	newBalance, err := h.service.DebitToken(r.Context(), userID)
+	var entry *LedgerEntry
+	if req.CostType != "" {
+		entry, err = h.service.DebitCost(r.Context(), userID, req.CostType, req.ReferenceID)
+	} else {
+		entry, err = h.service.DebitTokens(r.Context(), userID, amount, req.ReferenceID)
+	}
 	if err != nil {
+		switch {
 		// This is the specific error from the service for "no tokens".
-		if err.Error() == "insufficient funds or user not found" {
-			// Using 409 Conflict to signal this specific business rule failure.
-			writeError(w, http.StatusConflict, "Insufficient funds or user not found")
-			return
-		}
+		// Using 409 Conflict to signal this specific business rule failure.
+		case errors.Is(err, ErrInsufficientFunds):
+			httputil.WriteError(w, http.StatusConflict, "Insufficient funds or user not found")
+		// The request is fine, billing just doesn't know what it costs.
+		case errors.Is(err, ErrUnknownCostType):
+			httputil.WriteError(w, http.StatusUnprocessableEntity, err.Error())
 		// Something else went wrong, probably the database.
-		writeError(w, http.StatusInternalServerError, "Could not process debit")
+		default:
+			httputil.WriteError(w, http.StatusInternalServerError, "Could not process debit")
+		}
 		return
 	}
 
 	// Success. Send back the new balance.
-	writeJSON(w, http.StatusOK, debitResponse{NewBalance: newBalance})
+	httputil.WriteJSON(w, http.StatusOK, debitResponse{NewBalance: entry.BalanceAfter, EntryID: entry.EntryID.String()})
+}
+
+// handleRefundDebit gives back a debit. Unlike /token/add, the refund is tied to the debit in the ledger,
+// so it can't happen twice and it's clear afterwards why the tokens came back.
+func (h *Handler) handleRefundDebit(w http.ResponseWriter, r *http.Request) {
+	var req refundRequest
+	if err := jsonbody.Decode(w, r, &req); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid request payload")
+		return
+	}
+
+	userID, err := uuid.Parse(req.UserID)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid user_id format")
+		return
+	}
+
+	if (req.EntryID == "") == (req.ReferenceID == "") {
+		httputil.WriteError(w, http.StatusBadRequest, "Exactly one of entry_id or reference_id is required")
+		return
+	}
+	var entryID uuid.UUID
+	if req.EntryID != "" {
+		if entryID, err = uuid.Parse(req.EntryID); err != nil {
+			httputil.WriteError(w, http.StatusBadRequest, "Invalid entry_id format")
+			return
+		}
+	}
+
+	refund, err := h.service.RefundDebit(r.Context(), userID, entryID, req.ReferenceID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrDebitNotFound):
+			httputil.WriteError(w, http.StatusConflict, "No such debit to refund")
+		case errors.Is(err, ErrAlreadyRefunded):
+			httputil.WriteError(w, http.StatusConflict, "Debit already refunded")
+		default:
+			httputil.WriteError(w, http.StatusInternalServerError, "Could not process refund")
+		}
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, refundResponse{
+		NewBalance: refund.BalanceAfter,
+		EntryID:    refund.EntryID.String(),
+		RefundOf:   refund.RefundOf.UUID.String(),
+	})
+}
+
+// handleHoldTokens takes tokens off the balance into a hold, for a caller that isn't sure yet it'll go through with it.
+func (h *Handler) handleHoldTokens(w http.ResponseWriter, r *http.Request) {
+	var req holdRequest
+	if err := jsonbody.Decode(w, r, &req); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid request payload")
+		return
+	}
+
+	userID, err := uuid.Parse(req.UserID)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid user_id format")
+		return
+	}
+
+	// Same limits as a debit, since that's what it turns into.
+	amount := 1
+	if req.Amount != nil {
+		amount = *req.Amount
+		if amount <= 0 || amount > maxDebitAmount {
+			httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("Amount must be between 1 and %d", maxDebitAmount))
+			return
+		}
+	}
+
+	if len(req.ReferenceID) > maxReferenceIDLength {
+		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("reference_id must be at most %d characters", maxReferenceIDLength))
+		return
+	}
+
+	hold, err := h.service.HoldTokens(r.Context(), userID, amount, req.ReferenceID)
+	if err != nil {
+		if errors.Is(err, ErrInsufficientFunds) {
+			httputil.WriteError(w, http.StatusConflict, "Insufficient funds or user not found")
+			return
+		}
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not process hold")
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, holdResponse{HoldID: hold.HoldID.String(), NewBalance: hold.BalanceAfter})
+}
+
+// decodeResolveHold reads and validates the body shared by /token/commit and /token/release.
+// It writes the error response itself and returns ok=false if the request is bad.
+func decodeResolveHold(w http.ResponseWriter, r *http.Request) (userID, holdID uuid.UUID, ok bool) {
+	var req resolveHoldRequest
+	if err := jsonbody.Decode(w, r, &req); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid request payload")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	userID, err := uuid.Parse(req.UserID)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid user_id format")
+		return uuid.Nil, uuid.Nil, false
+	}
+	holdID, err = uuid.Parse(req.HoldID)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid hold_id format")
+		return uuid.Nil, uuid.Nil, false
+	}
+	return userID, holdID, true
+}
+
+// handleCommitHold spends a hold. The response is the same as a debit's, since that's what it is now.
+func (h *Handler) handleCommitHold(w http.ResponseWriter, r *http.Request) {
+	userID, holdID, ok := decodeResolveHold(w, r)
+	if !ok {
+		return
+	}
+
+	entry, err := h.service.CommitHold(r.Context(), userID, holdID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrHoldNotFound):
+			httputil.WriteError(w, http.StatusNotFound, "Hold not found")
+		case errors.Is(err, ErrHoldReleased):
+			httputil.WriteError(w, http.StatusConflict, "Hold already released")
+		default:
+			httputil.WriteError(w, http.StatusInternalServerError, "Could not commit hold")
+		}
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, debitResponse{NewBalance: entry.BalanceAfter, EntryID: entry.EntryID.String()})
+}
+
+// handleReleaseHold gives a hold's tokens back.
+func (h *Handler) handleReleaseHold(w http.ResponseWriter, r *http.Request) {
+	userID, holdID, ok := decodeResolveHold(w, r)
+	if !ok {
+		return
+	}
+
+	newBalance, err := h.service.ReleaseHold(r.Context(), userID, holdID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrHoldNotFound):
+			httputil.WriteError(w, http.StatusNotFound, "Hold not found")
+		case errors.Is(err, ErrHoldCommitted):
+			httputil.WriteError(w, http.StatusConflict, "Hold already committed")
+		default:
+			httputil.WriteError(w, http.StatusInternalServerError, "Could not release hold")
+		}
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, creditResponse{NewBalance: newBalance})
 }
 
 // This is called by the PaymentService.
 func (h *Handler) handleCreditToken(w http.ResponseWriter, r *http.Request) {
 	// Try to decode the json body.
 	var req creditRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid request payload")
+	if err := jsonbody.Decode(w, r, &req); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid request payload")
 		return
 	}
 
 	// Validate the UserID.
 	userID, err := uuid.Parse(req.UserID)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid user_id format")
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid user_id format")
 		return
 	}
 
 	// we should only be adding positive amounts.
 	if req.Amount <= 0 {
-		writeError(w, http.StatusBadRequest, "Amount must be positive")
+		httputil.WriteError(w, http.StatusBadRequest, "Amount must be positive")
 		return
 	}
 
-	// Call the business logic layer.
-	newBalance, err := h.service.CreditToken(r.Context(), userID, req.Amount)
+	if len(req.ReferenceID) > maxReferenceIDLength {
+		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("reference_id must be at most %d characters", maxReferenceIDLength))
+		return
+	}
+
+	// Expiring tokens are always granted, so for them the source is only a label for the lot.
+	// For anything else it says which bucket the tokens go in.
+	if req.Source != "" && req.ExpiresAt == nil && !validSource(req.Source) {
+		httputil.WriteError(w, http.StatusBadRequest, "source must be purchase, grant or refund, unless expires_at is set")
+		return
+	}
+	if len(req.Source) > maxLotSourceLength {
+		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("source must be at most %d characters", maxLotSourceLength))
+		return
+	}
+
+	// Call the business logic layer. With a reference, a retry of the same credit is answered without crediting twice.
+	var newBalance int
+	source := req.Source
+	if source == "" && req.ExpiresAt == nil {
+		source = defaultCreditSource
+	}
+	switch {
+	case req.ExpiresAt != nil:
+		if source == "" {
+			source = defaultLotSource
+		}
+		newBalance, err = h.service.CreditExpiringTokens(r.Context(), userID, req.Amount, source, *req.ExpiresAt, req.ReferenceID)
+	case req.ReferenceID != "":
+		newBalance, err = h.service.CreditTokenOnce(r.Context(), userID, req.Amount, source, req.ReferenceID)
+	default:
+		newBalance, err = h.service.CreditToken(r.Context(), userID, req.Amount, source)
+	}
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "Could not process credit")
+		switch {
+		case errors.Is(err, ErrInvalidExpiry):
+			httputil.WriteError(w, http.StatusBadRequest, "expires_at must be in the future")
+		case errors.Is(err, ErrCreditTooLarge):
+			// The error says what the limit is.
+			httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, ErrInvalidAmount):
+			httputil.WriteError(w, http.StatusBadRequest, "Amount must be positive")
+		case errors.Is(err, ErrNotFound):
+			httputil.WriteError(w, http.StatusNotFound, "User not found")
+		case errors.Is(err, ErrBalanceCapExceeded):
+			// Nothing was credited. The PaymentService looks for this status to raise an alert.
+			httputil.WriteError(w, http.StatusUnprocessableEntity, "Credit would exceed the maximum balance")
+		default:
+			httputil.WriteError(w, http.StatusInternalServerError, "Could not process credit")
+		}
 		return
 	}
 
 	// Success. Send back the new balance.
-	writeJSON(w, http.StatusOK, creditResponse{NewBalance: newBalance})
+	httputil.WriteJSON(w, http.StatusOK, creditResponse{NewBalance: newBalance})
+}
+
+// handleCreditBatch credits a list of users in one transaction, eg a marketing campaign.
+// A malformed batch is refused as a whole. Otherwise every item gets its own result, and the status is
+// 207 Multi-Status if any of them didn't end up with the credit.
+func (h *Handler) handleCreditBatch(w http.ResponseWriter, r *http.Request) {
+	var req creditBatchRequest
+	if err := jsonbody.Decode(w, r, &req); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid request payload")
+		return
+	}
+
+	if req.ReferenceID == "" || len(req.ReferenceID) > maxReferenceIDLength {
+		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("reference_id is required, at most %d characters", maxReferenceIDLength))
+		return
+	}
+	if len(req.Items) == 0 || len(req.Items) > maxBatchCreditItems {
+		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("items must have between 1 and %d entries", maxBatchCreditItems))
+		return
+	}
+
+	// Validate every item up front, so a typo doesn't give a half applied campaign.
+	items := make([]BatchCreditItem, 0, len(req.Items))
+	seen := make(map[uuid.UUID]bool, len(req.Items))
+	for i, raw := range req.Items {
+		userID, err := uuid.Parse(raw.UserID)
+		if err != nil {
+			httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("items[%d]: invalid user_id format", i))
+			return
+		}
+		// Same rule as the single credit, only positive amounts.
+		if raw.Amount <= 0 {
+			httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("items[%d]: amount must be positive", i))
+			return
+		}
+		// One credit per user per reference, so a second item for the same user could never apply.
+		if seen[userID] {
+			httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("items[%d]: user_id %s is listed twice", i, userID))
+			return
+		}
+		seen[userID] = true
+		items = append(items, BatchCreditItem{UserID: userID, Amount: raw.Amount})
+	}
+
+	results, err := h.service.CreditBatch(r.Context(), req.ReferenceID, items)
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not process batch credit")
+		return
+	}
+
+	resp := creditBatchResponse{ReferenceID: req.ReferenceID, Results: make([]creditBatchResult, len(results))}
+	for i, result := range results {
+		out := creditBatchResult{UserID: result.UserID.String(), Status: result.Status}
+		if result.Ok() {
+			balance := result.NewBalance
+			out.NewBalance = &balance
+		} else {
+			resp.Failed++
+		}
+		if result.Status == BatchCredited {
+			resp.Credited++
+		}
+		resp.Results[i] = out
+	}
+
+	status := http.StatusOK
+	if resp.Failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	httputil.WriteJSON(w, status, resp)
+}
+
+// handleGetBalance returns a user's current token balance, and how much of it expires when.
+func (h *Handler) handleGetBalance(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "user_id"))
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid user_id format")
+		return
+	}
+
+	balance, err := h.service.GetBalance(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			httputil.WriteError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not read balance")
+		return
+	}
+
+	resp := balanceResponse{
+		Balance:     balance.Total,
+		NonExpiring: balance.NonExpiring,
+		Expiring:    make([]expiringResponse, len(balance.Expiring)),
+		Purchased:   balance.Purchased,
+		Granted:     balance.Granted,
+	}
+	for i, e := range balance.Expiring {
+		resp.Expiring[i] = expiringResponse{ExpiresAt: e.ExpiresAt, Amount: e.Amount}
+	}
+	httputil.WriteJSON(w, http.StatusOK, resp)
 }
 
-// --- Helper Functions ---
+// handleGetLedger returns a page of the user's ledger. The cursor is the next_cursor from the page before.
+func (h *Handler) handleGetLedger(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "user_id"))
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid user_id format")
+		return
+	}
 
-// writeJSON is a helper to send json responses.
-func writeJSON(w http.ResponseWriter, status int, data interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	if data != nil {
-		json.NewEncoder(w).Encode(data)
+	q := r.URL.Query()
+	limit := defaultLedgerPageSize
+	if v := q.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxLedgerPageSize {
+			httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxLedgerPageSize))
+			return
+		}
+	}
+	var after *LedgerCursor
+	if v := q.Get("cursor"); v != "" {
+		if after, err = ParseLedgerCursor(v); err != nil {
+			httputil.WriteError(w, http.StatusBadRequest, "Invalid cursor")
+			return
+		}
+	}
+
+	page, err := h.service.ListLedger(r.Context(), userID, after, limit)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			httputil.WriteError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not read ledger")
+		return
+	}
+
+	resp := ledgerResponse{Entries: make([]ledgerEntryResponse, len(page.Entries))}
+	for i, entry := range page.Entries {
+		out := ledgerEntryResponse{
+			EntryID:      entry.EntryID.String(),
+			Delta:        entry.Delta(),
+			Granted:      entry.GrantedDelta(),
+			Reason:       entry.Kind,
+			Reference:    entry.ReferenceID,
+			CostType:     entry.CostType,
+			Source:       entry.Source,
+			BalanceAfter: entry.BalanceAfter,
+			CreatedAt:    entry.CreatedAt,
+		}
+		if entry.RefundOf.Valid {
+			out.RefundOf = entry.RefundOf.UUID.String()
+		}
+		resp.Entries[i] = out
+	}
+	if page.Next != nil {
+		resp.NextCursor = page.Next.String()
 	}
+	httputil.WriteJSON(w, http.StatusOK, resp)
 }
 
-// writeError is a helper for sending a standard json error message.
-func writeError(w http.ResponseWriter, status int, message string) {
-	writeJSON(w, status, map[string]string{"error": message})
+// handleGrantCycle runs the monthly tier grants for the current month and reports how many users each tier credited.
+func (h *Handler) handleGrantCycle(w http.ResponseWriter, r *http.Request) {
+	result, err := h.service.GrantMonthlyTokens(r.Context())
+	if err != nil {
+		fmt.Printf("WARNING: Monthly token grant failed: %v\n", err)
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not complete the grant cycle")
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, result)
+}
+
+// handleReconcile checks every balance against its ledger and lists the ones that don't match. With fix=true it
+// also sets them to what the ledger says. Each one is already logged and counted by the service.
+func (h *Handler) handleReconcile(w http.ResponseWriter, r *http.Request) {
+	fix := false
+	if v := r.URL.Query().Get("fix"); v != "" {
+		var err error
+		fix, err = strconv.ParseBool(v)
+		if err != nil {
+			httputil.WriteError(w, http.StatusBadRequest, "fix must be true or false")
+			return
+		}
+	}
+
+	reconcile := h.service.Reconcile
+	if fix {
+		reconcile = h.service.ReconcileAndFix
+	}
+	discrepancies, err := reconcile(r.Context())
+	if err != nil {
+		fmt.Printf("WARNING: Reconciling balances failed after %d discrepancies: %v\n", len(discrepancies), err)
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not finish reconciling balances")
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, reconcileResponse{Discrepancies: discrepancies})
+}
+
+// handleForfeitBalance takes a deleted account's remaining tokens. It's safe to call again.
+func (h *Handler) handleForfeitBalance(w http.ResponseWriter, r *http.Request) {
+	var req forfeitRequest
+	if err := jsonbody.Decode(w, r, &req); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid request payload")
+		return
+	}
+
+	userID, err := uuid.Parse(req.UserID)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid user_id format")
+		return
+	}
+	if len(req.ReferenceID) > maxReferenceIDLength {
+		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("reference_id must be at most %d characters", maxReferenceIDLength))
+		return
+	}
+
+	forfeited, err := h.service.ForfeitBalance(r.Context(), userID, req.ReferenceID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			httputil.WriteError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not forfeit balance")
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, forfeitResponse{Forfeited: forfeited, NewBalance: 0})
 }
@@ -0,0 +1,110 @@
+package billing
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Holds are for spending that might not go ahead. Holding takes the tokens off the user's balance straight away,
+// so they can't be spent twice, and then the caller either commits the hold (it becomes a normal debit)
+// or releases it (the tokens come back). Nothing is ever debited and then refunded.
+
+// TokenHold is one row of token_holds.
+type TokenHold struct {
+	HoldID        uuid.UUID
+	UserID        uuid.UUID
+	Amount        int
+	Granted       int           // How much of Amount came out of the granted bucket, and goes back there on release
+	ReferenceID   string        // Empty if the caller didn't give one
+	Status        string        // "held", "committed" or "released"
+	LedgerEntryID uuid.NullUUID // For a committed hold, the debit it became
+	CreatedAt     time.Time
+	BalanceAfter  int // The spendable balance once this was held, or released. For a repeat, the balance now
+}
+
+const (
+	holdStatusHeld      = "held"
+	holdStatusCommitted = "committed"
+	holdStatusReleased  = "released"
+)
+
+// HoldSweeperConfig tunes the sweeper. Zero values are filled from DefaultHoldSweeperConfig.
+type HoldSweeperConfig struct {
+	TTL       time.Duration // How old a hold can get before it's released
+	Interval  time.Duration // How often to look for expired holds
+	BatchSize int           // Most holds released per pass
+}
+
+// DefaultHoldSweeperConfig returns the settings used when none are configured.
+func DefaultHoldSweeperConfig() HoldSweeperConfig {
+	return HoldSweeperConfig{
+		TTL:       10 * time.Minute, // Creating a request takes seconds, even with a slow summary.
+		Interval:  time.Minute,
+		BatchSize: 100,
+	}
+}
+
+// withDefaults fills any zero settings from DefaultHoldSweeperConfig.
+func (c HoldSweeperConfig) withDefaults() HoldSweeperConfig {
+	d := DefaultHoldSweeperConfig()
+	if c.TTL <= 0 {
+		c.TTL = d.TTL
+	}
+	if c.Interval <= 0 {
+		c.Interval = d.Interval
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = d.BatchSize
+	}
+	return c
+}
+
+// HoldSweeper gives back holds that nobody committed or released in time, eg because the caller crashed halfway.
+// Without it those tokens would stay held forever.
+type HoldSweeper struct {
+	repo Repository
+	cfg  HoldSweeperConfig
+	now  func() time.Time
+}
+
+// NewHoldSweeper is the constructor.
+func NewHoldSweeper(r Repository, cfg HoldSweeperConfig) *HoldSweeper {
+	return &HoldSweeper{
+		repo: r,
+		cfg:  cfg.withDefaults(),
+		now:  time.Now,
+	}
+}
+
+// Run sweeps every Interval until ctx is done.
+func (hs *HoldSweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(hs.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := hs.SweepOnce(ctx); err != nil && ctx.Err() == nil {
+			fmt.Printf("WARNING: Hold sweep failed: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SweepOnce releases one batch of holds older than the TTL and returns how many it released.
+func (hs *HoldSweeper) SweepOnce(ctx context.Context) (int, error) {
+	released, err := hs.repo.ReleaseExpiredHolds(ctx, hs.now().UTC().Add(-hs.cfg.TTL), hs.cfg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	if released > 0 {
+		// Worth knowing about. Every one of these is a caller that didn't finish what it started.
+		fmt.Printf("WARNING: Released %d expired token holds\n", released)
+	}
+	return released, nil
+}
@@ -0,0 +1,33 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/mock/gomock"
+)
+
+// TestHoldSweeper_SweepOnce checks holds are released once they're older than the TTL, in batches.
+func TestHoldSweeper_SweepOnce(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := NewMockRepository(ctrl)
+	hs := NewHoldSweeper(mockRepo, HoldSweeperConfig{TTL: 10 * time.Minute, BatchSize: 25})
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	hs.now = func() time.Time { return now }
+
+	gomock.InOrder(
+		mockRepo.EXPECT().ReleaseExpiredHolds(gomock.Any(), now.Add(-10*time.Minute), 25).Return(3, nil),
+		mockRepo.EXPECT().ReleaseExpiredHolds(gomock.Any(), gomock.Any(), 25).Return(0, errors.New("db down")),
+	)
+
+	if n, err := hs.SweepOnce(context.Background()); err != nil || n != 3 {
+		t.Fatalf("Expected 3 released and no error, got %d, %v", n, err)
+	}
+	if _, err := hs.SweepOnce(context.Background()); err == nil {
+		t.Fatal("Expected the repository error")
+	}
+}
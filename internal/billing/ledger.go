@@ -0,0 +1,89 @@
+package billing
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Reading the ledger back, for the user's history and for support. Pages are keyset paginated on
+// (created_at, entry_id), newest first, so entries written while someone pages through don't shift
+// the pages they haven't read yet.
+
+const (
+	defaultLedgerPageSize = 50
+	maxLedgerPageSize     = 200
+)
+
+// LedgerCursor is the last entry of a page. The next page starts with whatever comes after it.
+type LedgerCursor struct {
+	CreatedAt time.Time
+	EntryID   uuid.UUID
+}
+
+// LedgerPage is one page of a user's ledger, newest first.
+type LedgerPage struct {
+	Entries []*LedgerEntry
+	Next    *LedgerCursor // nil on the last page
+}
+
+// Delta is the entry's effect on the balance: negative for a debit, an expiry or a forfeit, positive for anything that gave
+// tokens back or credited them. An opening entry from before the ledger covered everything can be either.
+// ledgerDeltaSQL is the same thing for queries.
+func (e *LedgerEntry) Delta() int {
+	if e.takesTokens() {
+		return -e.Amount
+	}
+	return e.Amount
+}
+
+// GrantedDelta is the granted bucket's part of Delta, with the same sign.
+func (e *LedgerEntry) GrantedDelta() int {
+	if e.takesTokens() {
+		return -e.Granted
+	}
+	return e.Granted
+}
+
+// takesTokens reports whether the entry's kind is one that takes tokens off the balance.
+func (e *LedgerEntry) takesTokens() bool {
+	return e.Kind == ledgerKindDebit || e.Kind == ledgerKindExpired || e.Kind == ledgerKindForfeit
+}
+
+// cursorFor is the cursor pointing at e.
+func cursorFor(e *LedgerEntry) *LedgerCursor {
+	return &LedgerCursor{CreatedAt: e.CreatedAt, EntryID: e.EntryID}
+}
+
+// String encodes the cursor for the API. Clients should treat it as opaque.
+func (c *LedgerCursor) String() string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.EntryID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// errInvalidCursor is for cursors that didn't come from LedgerCursor.String.
+var errInvalidCursor = errors.New("invalid cursor")
+
+// ParseLedgerCursor reads a cursor made by LedgerCursor.String.
+func ParseLedgerCursor(s string) (*LedgerCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	rawTime, rawID, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, errInvalidCursor
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, rawTime)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	entryID, err := uuid.Parse(rawID)
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+	return &LedgerCursor{CreatedAt: createdAt, EntryID: entryID}, nil
+}
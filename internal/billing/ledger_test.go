@@ -0,0 +1,132 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/mock/gomock"
+)
+
+func TestLedgerCursor_RoundTrip(t *testing.T) {
+	c := &LedgerCursor{CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 123456000, time.UTC), EntryID: uuid.New()}
+	got, err := ParseLedgerCursor(c.String())
+	if err != nil {
+		t.Fatalf("ParseLedgerCursor() returned error: %v", err)
+	}
+	if !got.CreatedAt.Equal(c.CreatedAt) || got.EntryID != c.EntryID {
+		t.Errorf("Expected %+v back, got %+v", c, got)
+	}
+
+	for _, bad := range []string{"not base64!", "bm8tc2VwYXJhdG9y", c.String()[:10]} {
+		if _, err := ParseLedgerCursor(bad); err == nil {
+			t.Errorf("Expected an error for cursor %q", bad)
+		}
+	}
+}
+
+func TestLedgerEntry_Delta(t *testing.T) {
+	tests := map[string]int{ledgerKindDebit: -3, ledgerKindRefund: 3, ledgerKindMonthlyGrant: 3, ledgerKindExpired: -3}
+	for kind, want := range tests {
+		if got := (&LedgerEntry{Kind: kind, Amount: 3}).Delta(); got != want {
+			t.Errorf("Delta() for %s = %d, want %d", kind, got, want)
+		}
+	}
+}
+
+// TestService_ListLedger_Empty checks a user with no entries gets an empty last page, and an unknown user ErrNotFound.
+func TestService_ListLedger_Empty(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	s := NewService(mockRepo)
+
+	ctx := context.Background()
+	userID, unknownID := uuid.New(), uuid.New()
+	mockRepo.EXPECT().ListLedger(ctx, userID, nil, defaultLedgerPageSize+1).Return(nil, nil).Times(1)
+	mockRepo.EXPECT().GetBalance(ctx, userID).Return(0, nil).Times(1)
+	mockRepo.EXPECT().ListLedger(ctx, unknownID, nil, defaultLedgerPageSize+1).Return(nil, nil).Times(1)
+	mockRepo.EXPECT().GetBalance(ctx, unknownID).Return(0, ErrNotFound).Times(1)
+
+	page, err := s.ListLedger(ctx, userID, nil, 0)
+	if err != nil {
+		t.Fatalf("ListLedger() returned error: %v", err)
+	}
+	if len(page.Entries) != 0 || page.Next != nil {
+		t.Errorf("Expected an empty last page, got %+v", page)
+	}
+
+	if _, err := s.ListLedger(ctx, unknownID, nil, 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an unknown user, got %v", err)
+	}
+}
+
+// TestService_ListLedger_Pages walks a ledger two entries at a time and checks every entry comes back once, in order.
+func TestService_ListLedger_Pages(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	s := NewService(mockRepo)
+
+	ctx := context.Background()
+	userID := uuid.New()
+
+	// Five entries, newest first. The last two share a created_at, so the entry_id has to break the tie.
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	ledger := make([]*LedgerEntry, 5)
+	for i := range ledger {
+		ledger[i] = &LedgerEntry{EntryID: uuid.New(), UserID: userID, Kind: ledgerKindDebit, Amount: 1, CreatedAt: base.Add(-time.Duration(i) * time.Minute)}
+	}
+	ledger[4].CreatedAt = ledger[3].CreatedAt
+	if ledger[4].EntryID.String() > ledger[3].EntryID.String() {
+		ledger[3], ledger[4] = ledger[4], ledger[3]
+	}
+
+	// The fake repository does the keyset comparison the SQL does.
+	mockRepo.EXPECT().ListLedger(ctx, userID, gomock.Any(), 3).DoAndReturn(
+		func(_ context.Context, _ uuid.UUID, after *LedgerCursor, limit int) ([]*LedgerEntry, error) {
+			var out []*LedgerEntry
+			for _, e := range ledger {
+				if after != nil && !(e.CreatedAt.Before(after.CreatedAt) ||
+					(e.CreatedAt.Equal(after.CreatedAt) && e.EntryID.String() < after.EntryID.String())) {
+					continue
+				}
+				if len(out) == limit {
+					break
+				}
+				out = append(out, e)
+			}
+			return out, nil
+		}).Times(3)
+
+	var seen []*LedgerEntry
+	var after *LedgerCursor
+	for pages := 1; ; pages++ {
+		page, err := s.ListLedger(ctx, userID, after, 2)
+		if err != nil {
+			t.Fatalf("ListLedger() returned error on page %d: %v", pages, err)
+		}
+		seen = append(seen, page.Entries...)
+		if page.Next == nil {
+			if pages != 3 {
+				t.Errorf("Expected 3 pages, got %d", pages)
+			}
+			break
+		}
+		if pages > 3 {
+			t.Fatal("Expected the pages to run out")
+		}
+		after = page.Next
+	}
+
+	if len(seen) != len(ledger) {
+		t.Fatalf("Expected %d entries, got %d", len(ledger), len(seen))
+	}
+	for i := range ledger {
+		if seen[i] != ledger[i] {
+			t.Errorf("Entry %d out of order", i)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package billing
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Lots are for tokens that run out, eg a 30 day trial. Each lot is part of the user's balance until it expires,
+// and anything spent comes out of the soonest expiring lot first, so the user never loses tokens they could have
+// used while paid ones sit there. Tokens that aren't in a lot never expire. Once a lot is past its expiry, the
+// LotSweeper takes what's left of it off the balance with an "expired" ledger entry.
+
+// Balance is what a user can spend, and when it runs out.
+type Balance struct {
+	Total       int              // Everything spendable right now
+	NonExpiring int              // The part of Total that's not in any lot
+	Expiring    []ExpiringTokens // The rest, soonest first
+	Purchased   int              // The part of Total the user paid for
+	Granted     int              // The part of Total they were given. Spent before Purchased
+}
+
+// ExpiringTokens is how many of a user's tokens run out at one moment. Lots expiring together are added up.
+type ExpiringTokens struct {
+	ExpiresAt time.Time
+	Amount    int
+}
+
+// lotTotal is what's left in a user's lots that expire at one moment. Expired is true if that moment has
+// passed but the sweeper hasn't got to them yet.
+type lotTotal struct {
+	ExpiresAt time.Time
+	Amount    int
+	Expired   bool
+}
+
+// summarizeBalance works out a Balance from the balance and granted columns and the user's open lots, soonest first.
+// Lots that have already run out are still in the columns until they're swept, but they can't be spent,
+// so they don't count. Lots are granted tokens, so that's the bucket they come out of.
+func summarizeBalance(balance, granted int, lots []lotTotal) *Balance {
+	b := &Balance{Total: balance, Granted: granted, Expiring: []ExpiringTokens{}}
+	expiring := 0
+	for _, lot := range lots {
+		if lot.Expired {
+			b.Total -= lot.Amount
+			b.Granted = max(b.Granted-lot.Amount, 0)
+			continue
+		}
+		b.Expiring = append(b.Expiring, ExpiringTokens{ExpiresAt: lot.ExpiresAt, Amount: lot.Amount})
+		expiring += lot.Amount
+	}
+	b.NonExpiring = b.Total - expiring
+	b.Purchased = b.Total - b.Granted
+	return b
+}
+
+// maxLotSourceLength keeps a lot's source to a short label.
+const maxLotSourceLength = 64
+
+// LotSweeperConfig tunes the sweeper. Zero values are filled from DefaultLotSweeperConfig.
+type LotSweeperConfig struct {
+	Interval  time.Duration // How often to look for expired lots
+	BatchSize int           // Most lots expired per pass
+}
+
+// DefaultLotSweeperConfig returns the settings used when none are configured.
+func DefaultLotSweeperConfig() LotSweeperConfig {
+	return LotSweeperConfig{
+		Interval:  time.Minute, // Expired tokens can't be spent anyway, this only tidies up the balance.
+		BatchSize: 500,
+	}
+}
+
+// withDefaults fills any zero settings from DefaultLotSweeperConfig.
+func (c LotSweeperConfig) withDefaults() LotSweeperConfig {
+	d := DefaultLotSweeperConfig()
+	if c.Interval <= 0 {
+		c.Interval = d.Interval
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = d.BatchSize
+	}
+	return c
+}
+
+// LotSweeper takes expired lots off users' balances. Until it does, the tokens still count in
+// assistance_token_balance, though nothing will let them be spent.
+type LotSweeper struct {
+	repo Repository
+	cfg  LotSweeperConfig
+	now  func() time.Time
+}
+
+// NewLotSweeper is the constructor.
+func NewLotSweeper(r Repository, cfg LotSweeperConfig) *LotSweeper {
+	return &LotSweeper{
+		repo: r,
+		cfg:  cfg.withDefaults(),
+		now:  time.Now,
+	}
+}
+
+// Run sweeps every Interval until ctx is done.
+func (ls *LotSweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(ls.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := ls.SweepOnce(ctx); err != nil && ctx.Err() == nil {
+			fmt.Printf("WARNING: Lot sweep failed: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SweepOnce expires one batch of lots and returns how many it expired. Unlike expired holds,
+// these are expected, so they aren't logged.
+func (ls *LotSweeper) SweepOnce(ctx context.Context) (int, error) {
+	return ls.repo.ExpireLots(ctx, ls.now().UTC(), ls.cfg.BatchSize)
+}
@@ -0,0 +1,98 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/mock/gomock"
+)
+
+func TestSummarizeBalance(t *testing.T) {
+	soon := time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)
+	later := soon.AddDate(0, 1, 0)
+
+	// 10 in the column, 7 of them granted: 2 already expired but not swept, 3 and 1 still to go, so 4 never expire.
+	b := summarizeBalance(10, 7, []lotTotal{
+		{ExpiresAt: soon.Add(-time.Hour), Amount: 2, Expired: true},
+		{ExpiresAt: soon, Amount: 3},
+		{ExpiresAt: later, Amount: 1},
+	})
+	if b.Total != 8 || b.NonExpiring != 4 {
+		t.Errorf("Expected total 8 with 4 non-expiring, got %+v", b)
+	}
+	// The expired lot was granted tokens, so it leaves 5 granted and the 3 purchased.
+	if b.Granted != 5 || b.Purchased != 3 {
+		t.Errorf("Expected 5 granted and 3 purchased, got %+v", b)
+	}
+	if len(b.Expiring) != 2 || b.Expiring[0] != (ExpiringTokens{ExpiresAt: soon, Amount: 3}) || b.Expiring[1] != (ExpiringTokens{ExpiresAt: later, Amount: 1}) {
+		t.Errorf("Unexpected expiring tokens %+v", b.Expiring)
+	}
+
+	// No lots is all non-expiring, with an empty list rather than nil for the JSON.
+	if b := summarizeBalance(5, 0, nil); b.Total != 5 || b.NonExpiring != 5 || b.Expiring == nil || len(b.Expiring) != 0 {
+		t.Errorf("Expected 5 non-expiring and nothing expiring, got %+v", b)
+	}
+	if b := summarizeBalance(5, 0, nil); b.Purchased != 5 || b.Granted != 0 {
+		t.Errorf("Expected all 5 purchased, got %+v", b)
+	}
+}
+
+// TestLotSweeper_SweepOnce checks the sweeper expires lots as of its clock, in batches.
+func TestLotSweeper_SweepOnce(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockRepo := NewMockRepository(ctrl)
+	ls := NewLotSweeper(mockRepo, LotSweeperConfig{BatchSize: 25})
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	ls.now = func() time.Time { return now }
+
+	gomock.InOrder(
+		mockRepo.EXPECT().ExpireLots(gomock.Any(), now, 25).Return(4, nil),
+		mockRepo.EXPECT().ExpireLots(gomock.Any(), now, 25).Return(0, errors.New("db down")),
+	)
+
+	if n, err := ls.SweepOnce(context.Background()); err != nil || n != 4 {
+		t.Fatalf("Expected 4 expired and no error, got %d, %v", n, err)
+	}
+	if _, err := ls.SweepOnce(context.Background()); err == nil {
+		t.Fatal("Expected the repository error")
+	}
+}
+
+// TestService_CreditExpiringTokens checks expiring credits are validated before the repository,
+// and get the same event id as any other credit under the same reference.
+func TestService_CreditExpiringTokens(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	var events []*BalanceEvent
+	svc := NewServiceWithOptions(mockRepo, Options{Publisher: capturePublisher(ctrl, &events)}).(*service)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	userID := uuid.New()
+	expiresAt := now.AddDate(0, 0, 30)
+	mockRepo.EXPECT().CreditLot(ctx, userID, 5, "trial", expiresAt, "trial:1").Return(8, nil).Times(1)
+
+	if balance, err := svc.CreditExpiringTokens(ctx, userID, 5, "trial", expiresAt, "trial:1"); err != nil || balance != 8 {
+		t.Fatalf("Expected balance 8 and no error, got %d, %v", balance, err)
+	}
+	if _, err := svc.CreditExpiringTokens(ctx, userID, 5, "trial", now, ""); !errors.Is(err, ErrInvalidExpiry) {
+		t.Errorf("Expected ErrInvalidExpiry for an expiry of now, got %v", err)
+	}
+	if _, err := svc.CreditExpiringTokens(ctx, userID, 0, "trial", expiresAt, ""); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("Expected ErrInvalidAmount, got %v", err)
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(events))
+	}
+	if ev := events[0]; ev.EventID != creditEventID(userID, "trial:1") || ev.Delta != 5 || ev.NewBalance != 8 || ev.Reason != ReasonCredit {
+		t.Errorf("Unexpected credit event %+v", ev)
+	}
+}
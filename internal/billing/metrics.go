@@ -0,0 +1,165 @@
+package billing
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Operation labels. Debits are anything that takes tokens off a balance, credits anything that puts them on.
+const (
+	opDebit          = "debit"           // DebitToken, the one token version
+	opDebitTokens    = "debit_tokens"    // DebitTokens
+	opDebitCost      = "debit_cost"      // DebitCost
+	opForfeit        = "forfeit"         // ForfeitBalance
+	opHold           = "hold"            // HoldTokens. Committing later doesn't count again
+	opCredit         = "credit"          // CreditToken
+	opCreditOnce     = "credit_once"     // CreditTokenOnce
+	opBatchCredit    = "batch_credit"    // One per item of a CreditBatch
+	opExpiringCredit = "expiring_credit" // CreditExpiringTokens
+	opMonthlyGrant   = "monthly_grant"   // One per user granted by GrantMonthlyTokens
+	opRefund         = "refund"          // RefundDebit
+	opHoldRelease    = "hold_release"    // ReleaseHold
+)
+
+// Outcome labels.
+const (
+	outcomeSuccess           = "success"
+	outcomeInsufficientFunds = "insufficient_funds"
+	outcomeNotFound          = "not_found"
+	outcomeInvalid           = "invalid"  // Bad amount, expiry, source or cost type, turned away before the database
+	outcomeConflict          = "conflict" // Already refunded, released or committed
+	outcomeDuplicate         = "duplicate"
+	outcomeCapExceeded       = "cap_exceeded" // Would have gone over the maximum balance. Worth an alert
+	outcomeError             = "error"        // Anything else, eg the database being down
+)
+
+// Metrics are the billing service's Prometheus metrics. The service counts debits and credits itself, so every
+// API on top of it (HTTP now, maybe gRPC later) shares the same numbers. The handler only adds its latency.
+type Metrics struct {
+	debits            *prometheus.CounterVec
+	credits           *prometheus.CounterVec
+	insufficientFunds *prometheus.CounterVec
+	discrepancies     *prometheus.CounterVec
+	latency           *prometheus.HistogramVec
+}
+
+// NewMetrics creates the metrics and registers them with reg. It panics if they're already registered there,
+// like the prometheus package does, so each registry gets one.
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		debits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "billing",
+			Name:      "debits_total",
+			Help:      "Debits attempted, by operation and outcome.",
+		}, []string{"operation", "outcome"}),
+		credits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "billing",
+			Name:      "credits_total",
+			Help:      "Credits attempted, by operation and outcome.",
+		}, []string{"operation", "outcome"}),
+		insufficientFunds: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "billing",
+			Name:      "insufficient_funds_total",
+			Help:      "Debits turned down for lack of tokens, by operation.",
+		}, []string{"operation"}),
+		discrepancies: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "billing",
+			Name:      "balance_discrepancies_total",
+			Help:      "Balances found not to match their ledger when reconciling, by whether they were fixed.",
+		}, []string{"fixed"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "billing",
+			Name:      "handler_duration_seconds",
+			Help:      "How long the HTTP handlers take, by route and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation", "outcome"}),
+	}
+	reg.MustRegister(m.debits, m.credits, m.insufficientFunds, m.discrepancies, m.latency)
+	return m
+}
+
+// newUnregisteredMetrics is for a service nobody scrapes, eg in tests. The numbers are kept but go nowhere.
+func newUnregisteredMetrics() *Metrics {
+	return NewMetrics(prometheus.NewRegistry())
+}
+
+// outcomeOf sorts an error from the service into an outcome label.
+func outcomeOf(err error) string {
+	switch {
+	case err == nil:
+		return outcomeSuccess
+	case errors.Is(err, ErrInsufficientFunds):
+		return outcomeInsufficientFunds
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrDebitNotFound), errors.Is(err, ErrHoldNotFound):
+		return outcomeNotFound
+	case errors.Is(err, ErrInvalidAmount), errors.Is(err, ErrInvalidExpiry), errors.Is(err, ErrInvalidSource),
+		errors.Is(err, ErrUnknownCostType):
+		return outcomeInvalid
+	case errors.Is(err, ErrAlreadyRefunded), errors.Is(err, ErrHoldReleased), errors.Is(err, ErrHoldCommitted):
+		return outcomeConflict
+	case errors.Is(err, ErrBalanceCapExceeded):
+		return outcomeCapExceeded
+	}
+	return outcomeError
+}
+
+// debited counts one debit attempt.
+func (m *Metrics) debited(operation string, err error) {
+	outcome := outcomeOf(err)
+	m.debits.WithLabelValues(operation, outcome).Inc()
+	if outcome == outcomeInsufficientFunds {
+		m.insufficientFunds.WithLabelValues(operation).Inc()
+	}
+}
+
+// credited counts n credits with the same outcome. n is more than one for a monthly grant.
+func (m *Metrics) credited(operation string, n int, err error) {
+	m.credits.WithLabelValues(operation, outcomeOf(err)).Add(float64(n))
+}
+
+// creditedBatch counts each item of a batch by how it went.
+func (m *Metrics) creditedBatch(results []BatchCreditResult) {
+	for _, result := range results {
+		outcome := outcomeSuccess
+		switch result.Status {
+		case BatchAlreadyCredited:
+			outcome = outcomeDuplicate
+		case BatchUserNotFound:
+			outcome = outcomeNotFound
+		case BatchCapExceeded:
+			outcome = outcomeCapExceeded
+		}
+		m.credits.WithLabelValues(opBatchCredit, outcome).Inc()
+	}
+}
+
+// discrepancy counts one balance that didn't match its ledger. Any of these is worth an alert.
+func (m *Metrics) discrepancy(d Discrepancy) {
+	m.discrepancies.WithLabelValues(strconv.FormatBool(d.Fixed)).Inc()
+}
+
+// Middleware times each request by its route pattern, eg "POST /token/debit", and status code.
+// The pattern rather than the path, so user ids don't turn into a label each.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK // Nothing written, which net/http sends as a 200
+		}
+		m.latency.WithLabelValues(r.Method+" "+route, strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+	})
+}
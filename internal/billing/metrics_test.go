@@ -0,0 +1,146 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/mock/gomock"
+)
+
+// TestMetrics_Debits checks the service counts debits by outcome, and insufficient funds on their own too.
+func TestMetrics_Debits(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	metrics := NewMetrics(prometheus.NewRegistry())
+	s := NewServiceWithOptions(mockRepo, Options{Metrics: metrics})
+	ctx := context.Background()
+	userID := uuid.New()
+
+	gomock.InOrder(
+		mockRepo.EXPECT().DebitToken(ctx, userID).Return(2, nil),
+		mockRepo.EXPECT().DebitToken(ctx, userID).Return(0, ErrInsufficientFunds),
+		mockRepo.EXPECT().DebitToken(ctx, userID).Return(0, errors.New("db down")),
+	)
+	for i := 0; i < 3; i++ {
+		s.DebitToken(ctx, userID)
+	}
+	// Turned away before the repository, but still counted.
+	s.DebitTokens(ctx, userID, 0, "")
+
+	for _, tc := range []struct {
+		operation, outcome string
+		want               float64
+	}{
+		{opDebit, outcomeSuccess, 1},
+		{opDebit, outcomeInsufficientFunds, 1},
+		{opDebit, outcomeError, 1},
+		{opDebitTokens, outcomeInvalid, 1},
+	} {
+		if got := testutil.ToFloat64(metrics.debits.WithLabelValues(tc.operation, tc.outcome)); got != tc.want {
+			t.Errorf("Expected debits_total{%s,%s} = %v, got %v", tc.operation, tc.outcome, tc.want, got)
+		}
+	}
+	if got := testutil.ToFloat64(metrics.insufficientFunds.WithLabelValues(opDebit)); got != 1 {
+		t.Errorf("Expected insufficient_funds_total{debit} = 1, got %v", got)
+	}
+}
+
+// TestMetrics_Credits checks batch items are counted one by one, and a monthly grant by the users it reached.
+func TestMetrics_Credits(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	metrics := NewMetrics(prometheus.NewRegistry())
+	s := NewServiceWithOptions(mockRepo, Options{Metrics: metrics, Grants: TierGrants{"premium": 10}})
+	ctx := context.Background()
+
+	items := []BatchCreditItem{{UserID: uuid.New(), Amount: 1}, {UserID: uuid.New(), Amount: 1}}
+	mockRepo.EXPECT().CreditBatch(ctx, "campaign", items).Return([]BatchCreditResult{
+		{UserID: items[0].UserID, Status: BatchCredited, NewBalance: 1},
+		{UserID: items[1].UserID, Status: BatchAlreadyCredited, NewBalance: 1},
+	}, nil)
+	mockRepo.EXPECT().GrantTierTokens(ctx, "premium", 10, gomock.Any()).Return(7, nil)
+
+	if _, err := s.CreditBatch(ctx, "campaign", items); err != nil {
+		t.Fatalf("CreditBatch() returned error: %v", err)
+	}
+	if _, err := s.GrantMonthlyTokens(ctx); err != nil {
+		t.Fatalf("GrantMonthlyTokens() returned error: %v", err)
+	}
+
+	for _, tc := range []struct {
+		operation, outcome string
+		want               float64
+	}{
+		{opBatchCredit, outcomeSuccess, 1},
+		{opBatchCredit, outcomeDuplicate, 1},
+		{opMonthlyGrant, outcomeSuccess, 7},
+	} {
+		if got := testutil.ToFloat64(metrics.credits.WithLabelValues(tc.operation, tc.outcome)); got != tc.want {
+			t.Errorf("Expected credits_total{%s,%s} = %v, got %v", tc.operation, tc.outcome, tc.want, got)
+		}
+	}
+}
+
+// TestMetrics_Middleware checks requests are timed by route pattern and status, not by path.
+func TestMetrics_Middleware(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockService := NewMockService(ctrl)
+	reg := prometheus.NewRegistry()
+	metrics := NewMetrics(reg)
+
+	r := chi.NewRouter()
+	r.Use(metrics.Middleware)
+	NewHandler(mockService, nil).RegisterRoutes(r)
+
+	userID := uuid.New()
+	mockService.EXPECT().GetBalance(gomock.Any(), userID).Return(nil, ErrNotFound)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/token/balance/"+userID.String(), nil))
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather() returned error: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "billing_handler_duration_seconds" {
+			continue
+		}
+		if len(family.GetMetric()) != 1 {
+			t.Fatalf("Expected one latency series, got %d", len(family.GetMetric()))
+		}
+		labels := map[string]string{}
+		for _, l := range family.GetMetric()[0].GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		if labels["operation"] != "GET /token/balance/{user_id}" || labels["outcome"] != "404" {
+			t.Errorf("Expected the request under its route pattern and status, got %v", labels)
+		}
+		return
+	}
+	t.Error("Expected billing_handler_duration_seconds to be registered")
+}
+
+// TestOutcomeOf checks errors are sorted into the right outcome labels.
+func TestOutcomeOf(t *testing.T) {
+	for err, want := range map[error]string{
+		ErrInsufficientFunds:  outcomeInsufficientFunds,
+		ErrHoldNotFound:       outcomeNotFound,
+		ErrInvalidExpiry:      outcomeInvalid,
+		ErrAlreadyRefunded:    outcomeConflict,
+		ErrBalanceCapExceeded: outcomeCapExceeded,
+		errors.New("db down"): outcomeError,
+		errors.Join(errors.New("wrapped"), ErrNotFound): outcomeNotFound,
+	} {
+		if got := outcomeOf(err); got != want {
+			t.Errorf("outcomeOf(%v) = %s, want %s", err, got, want)
+		}
+	}
+}
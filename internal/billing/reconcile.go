@@ -0,0 +1,93 @@
+package billing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Reconciling checks every user's balance against their ledger. Every change to a balance writes a ledger row in
+// the same transaction, so a user's ledger always adds up to their balance plus what they have on hold. One that
+// doesn't means a change went in without its row, or someone edited the users table by hand, and either way the
+// balance can't be trusted. A scheduler runs it nightly through POST /token/reconcile.
+
+// defaultReconcileBatchSize is how many users are checked per query.
+const defaultReconcileBatchSize = 500
+
+// LedgerTotal is a user's balance next to what their ledger adds up to.
+type LedgerTotal struct {
+	UserID  uuid.UUID
+	Balance int // Spendable plus held, from the users row
+	Ledger  int // The sum of every ledger entry's Delta
+}
+
+// Discrepancy is a user whose balance and ledger don't agree.
+type Discrepancy struct {
+	UserID  uuid.UUID `json:"user_id"`
+	Balance int       `json:"balance"` // Spendable plus held
+	Ledger  int       `json:"ledger"`  // What the ledger adds up to
+	Fixed   bool      `json:"fixed"`   // The balance was set to what the ledger says
+}
+
+// Difference is how many tokens the balance has that the ledger doesn't account for. Negative if it's short.
+func (d Discrepancy) Difference() int {
+	return d.Balance - d.Ledger
+}
+
+// Reconcile checks every user's balance against their ledger, a batch at a time, and returns the ones that don't
+// agree. Each is counted and logged as it's found, so a run that fails halfway still reports what it saw.
+func (s *service) Reconcile(ctx context.Context) ([]Discrepancy, error) {
+	return s.reconcile(ctx, false)
+}
+
+// ReconcileAndFix is Reconcile, and sets each balance it finds wrong to what the ledger says. One that can't be
+// fixed is logged and left for a person to look at.
+func (s *service) ReconcileAndFix(ctx context.Context) ([]Discrepancy, error) {
+	return s.reconcile(ctx, true)
+}
+
+// reconcile goes through the users in id order, so a batch never sees anyone twice.
+func (s *service) reconcile(ctx context.Context, fix bool) ([]Discrepancy, error) {
+	found := []Discrepancy{}
+	after := uuid.Nil
+	for {
+		totals, err := s.repo.LedgerTotals(ctx, after, s.reconcileBatch)
+		if err != nil {
+			return found, fmt.Errorf("could not reconcile balances: %w", err)
+		}
+		for _, t := range totals {
+			if t.Balance == t.Ledger {
+				continue
+			}
+			d := Discrepancy{UserID: t.UserID, Balance: t.Balance, Ledger: t.Ledger}
+			if fix {
+				d = s.fixDiscrepancy(ctx, d)
+			}
+			s.metrics.discrepancy(d)
+			fmt.Printf("WARNING: Balance discrepancy user_id=%s balance=%d ledger=%d difference=%d fixed=%t\n",
+				d.UserID, d.Balance, d.Ledger, d.Difference(), d.Fixed)
+			found = append(found, d)
+		}
+		if len(totals) < s.reconcileBatch {
+			return found, nil
+		}
+		after = totals[len(totals)-1].UserID
+	}
+}
+
+// fixDiscrepancy snaps the user's balance to their ledger. The repository adds both up again under the user's
+// lock, and that's what's reported, since it's what the fix went by.
+func (s *service) fixDiscrepancy(ctx context.Context, d Discrepancy) Discrepancy {
+	total, err := s.repo.SnapBalanceToLedger(ctx, d.UserID)
+	if err != nil {
+		fmt.Printf("WARNING: Could not fix balance discrepancy for user %s: %v\n", d.UserID, err)
+		return d
+	}
+	return Discrepancy{
+		UserID:  d.UserID,
+		Balance: total.Balance,
+		Ledger:  total.Ledger,
+		Fixed:   total.Balance != total.Ledger,
+	}
+}
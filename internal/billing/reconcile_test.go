@@ -0,0 +1,111 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/mock/gomock"
+)
+
+// TestService_Reconcile checks every batch is read, each one starting after the last user of the one before,
+// and only the users that don't match come back and get counted.
+func TestService_Reconcile(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	metrics := NewMetrics(prometheus.NewRegistry())
+	svc := NewServiceWithOptions(mockRepo, Options{Metrics: metrics}).(*service)
+	svc.reconcileBatch = 2
+
+	ctx := context.Background()
+	a, b, c := uuid.New(), uuid.New(), uuid.New()
+	gomock.InOrder(
+		mockRepo.EXPECT().LedgerTotals(ctx, uuid.Nil, 2).Return([]LedgerTotal{
+			{UserID: a, Balance: 5, Ledger: 5},
+			{UserID: b, Balance: 7, Ledger: 4},
+		}, nil),
+		// A short batch is the last one.
+		mockRepo.EXPECT().LedgerTotals(ctx, b, 2).Return([]LedgerTotal{{UserID: c, Balance: 0, Ledger: 2}}, nil),
+	)
+
+	found, err := svc.Reconcile(ctx)
+	if err != nil {
+		t.Fatalf("Reconcile() returned error: %v", err)
+	}
+	want := []Discrepancy{{UserID: b, Balance: 7, Ledger: 4}, {UserID: c, Balance: 0, Ledger: 2}}
+	if len(found) != len(want) || found[0] != want[0] || found[1] != want[1] {
+		t.Fatalf("Expected %+v, got %+v", want, found)
+	}
+	if found[0].Difference() != 3 || found[1].Difference() != -2 {
+		t.Errorf("Expected differences 3 and -2, got %d and %d", found[0].Difference(), found[1].Difference())
+	}
+	if got := testutil.ToFloat64(metrics.discrepancies.WithLabelValues("false")); got != 2 {
+		t.Errorf("Expected balance_discrepancies_total{fixed=false} = 2, got %v", got)
+	}
+}
+
+// TestService_Reconcile_Error checks a failed batch still hands back what the ones before it found.
+func TestService_Reconcile_Error(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	svc := NewService(mockRepo).(*service)
+	svc.reconcileBatch = 1
+
+	ctx := context.Background()
+	userID := uuid.New()
+	gomock.InOrder(
+		mockRepo.EXPECT().LedgerTotals(ctx, uuid.Nil, 1).Return([]LedgerTotal{{UserID: userID, Balance: 1, Ledger: 0}}, nil),
+		mockRepo.EXPECT().LedgerTotals(ctx, userID, 1).Return(nil, errors.New("db down")),
+	)
+
+	found, err := svc.Reconcile(ctx)
+	if err == nil {
+		t.Fatal("Expected the repository error")
+	}
+	if len(found) != 1 || found[0].UserID != userID {
+		t.Errorf("Expected the first batch's discrepancy, got %+v", found)
+	}
+}
+
+// TestService_ReconcileAndFix checks each discrepancy is snapped to the ledger and reported with the totals the fix
+// went by, and one that can't be fixed is still reported, unfixed.
+func TestService_ReconcileAndFix(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	metrics := NewMetrics(prometheus.NewRegistry())
+	svc := NewServiceWithOptions(mockRepo, Options{Metrics: metrics})
+
+	ctx := context.Background()
+	fixable, stuck := uuid.New(), uuid.New()
+	mockRepo.EXPECT().LedgerTotals(ctx, uuid.Nil, defaultReconcileBatchSize).Return([]LedgerTotal{
+		{UserID: fixable, Balance: 9, Ledger: 6},
+		{UserID: stuck, Balance: 3, Ledger: -1},
+	}, nil)
+	// A debit landed between the batch and the lock, so the fix sees one less on both sides.
+	mockRepo.EXPECT().SnapBalanceToLedger(ctx, fixable).Return(&LedgerTotal{UserID: fixable, Balance: 8, Ledger: 5}, nil)
+	mockRepo.EXPECT().SnapBalanceToLedger(ctx, stuck).Return(nil, errors.New("fewer than the 2 in their lots"))
+
+	found, err := svc.ReconcileAndFix(ctx)
+	if err != nil {
+		t.Fatalf("ReconcileAndFix() returned error: %v", err)
+	}
+	want := []Discrepancy{
+		{UserID: fixable, Balance: 8, Ledger: 5, Fixed: true},
+		{UserID: stuck, Balance: 3, Ledger: -1},
+	}
+	if len(found) != len(want) || found[0] != want[0] || found[1] != want[1] {
+		t.Fatalf("Expected %+v, got %+v", want, found)
+	}
+	if got := testutil.ToFloat64(metrics.discrepancies.WithLabelValues("true")); got != 1 {
+		t.Errorf("Expected balance_discrepancies_total{fixed=true} = 1, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.discrepancies.WithLabelValues("false")); got != 1 {
+		t.Errorf("Expected balance_discrepancies_total{fixed=false} = 1, got %v", got)
+	}
+}
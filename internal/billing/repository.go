@@ -5,7 +5,10 @@ package billing
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"project-sage/internal/database"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -15,69 +18,1436 @@ import (
 type Repository interface {
 	// DebitToken should atomically decrement a user's token balance.
 	DebitToken(ctx context.Context, userID uuid.UUID) (int, error)
-	CreditToken(ctx context.Context, userID uuid.UUID, amount int) (int, error)
+	// DebitTokens atomically takes amount tokens, or none at all if the balance is too low, and records it in the ledger.
+	// A repeated non-empty referenceID returns the original debit without taking tokens again.
+	DebitTokens(ctx context.Context, userID uuid.UUID, amount int, referenceID string) (*LedgerEntry, error)
+	// DebitCost is DebitTokens with the cost type the amount was priced from recorded in the ledger entry.
+	// Unlike DebitTokens, amount can be 0, for something free that should still be in the ledger.
+	DebitCost(ctx context.Context, userID uuid.UUID, amount int, costType, referenceID string) (*LedgerEntry, error)
+	// RefundDebit gives back a debit found by entryID or referenceID, and records the refund against it.
+	// Returns ErrDebitNotFound or ErrAlreadyRefunded if there's nothing to give back.
+	RefundDebit(ctx context.Context, userID, entryID uuid.UUID, referenceID string) (*LedgerEntry, error)
+	// CreditToken credits amount tokens from source, one of the Source constants, and returns the new balance.
+	// Only SourceGrant tokens go in the granted bucket. Returns ErrBalanceCapExceeded, and credits nothing, if the
+	// new balance would be over the maximum. The other credits below check the cap the same way.
+	CreditToken(ctx context.Context, userID uuid.UUID, amount int, source string) (int, error)
+	// CreditTokenOnce credits like CreditToken, but only once per (user, referenceID).
+	// A repeated reference returns the balance from the first time without crediting again.
+	CreditTokenOnce(ctx context.Context, userID uuid.UUID, amount int, source, referenceID string) (int, error)
+	// CreditBatch credits every item in one transaction as granted tokens, each user at most once per referenceID,
+	// and returns a result per item in the same order. A user the credit would take over the maximum isn't credited.
+	CreditBatch(ctx context.Context, referenceID string, items []BatchCreditItem) ([]BatchCreditResult, error)
+	// CreditLot credits amount granted tokens that expire at expiresAt, from source. A non-empty referenceID works like
+	// CreditTokenOnce's. Returns the new balance, or ErrNotFound for unknown users.
+	CreditLot(ctx context.Context, userID uuid.UUID, amount int, source string, expiresAt time.Time, referenceID string) (int, error)
+	// GetBalance reads a user's current spendable token balance. Returns ErrNotFound for unknown users.
+	GetBalance(ctx context.Context, userID uuid.UUID) (int, error)
+	// GetBalanceBreakdown is GetBalance, along with how much of it expires when. Returns ErrNotFound for unknown users.
+	GetBalanceBreakdown(ctx context.Context, userID uuid.UUID) (*Balance, error)
+	// ListLedger returns up to limit of the user's ledger entries, newest first, starting after the entry after points at.
+	// A nil after starts from the newest. Unknown users just have no entries.
+	ListLedger(ctx context.Context, userID uuid.UUID, after *LedgerCursor, limit int) ([]*LedgerEntry, error)
+	// GrantTierTokens credits amount to every user on the tier who hasn't had this cycle's grant yet,
+	// recording a monthly_grant ledger entry for each. Users the grant would take over the maximum balance are
+	// left out, and can still get it in a later run of the same cycle. Returns how many users were credited.
+	GrantTierTokens(ctx context.Context, tier string, amount int, cycle string) (int, error)
+	// HoldTokens moves amount tokens out of the spendable balance into a hold, or none at all if the balance is too low.
+	// A repeated non-empty referenceID returns the existing hold without holding again.
+	HoldTokens(ctx context.Context, userID uuid.UUID, amount int, referenceID string) (*TokenHold, error)
+	// CommitHold turns a hold into a debit in the ledger. Committing it again returns the same debit.
+	// Returns ErrHoldNotFound, or ErrHoldReleased if it was already given back.
+	CommitHold(ctx context.Context, userID, holdID uuid.UUID) (*LedgerEntry, error)
+	// ReleaseHold puts a hold's tokens back and returns the hold, with BalanceAfter the new balance.
+	// Releasing it again changes nothing. Returns ErrHoldNotFound, or ErrHoldCommitted if it was already spent.
+	ReleaseHold(ctx context.Context, userID, holdID uuid.UUID) (*TokenHold, error)
+	// ReleaseExpiredHolds releases up to limit holds created before createdBefore that are still held,
+	// and returns how many it released.
+	ReleaseExpiredHolds(ctx context.Context, createdBefore time.Time, limit int) (int, error)
+	// ExpireLots takes what's left of up to limit lots that expired by now off their users' balances, with an
+	// expired ledger entry for each, and returns how many lots it expired.
+	ExpireLots(ctx context.Context, now time.Time, limit int) (int, error)
+	// LedgerTotals returns up to limit users after the given id, in id order, each with their balance and what their
+	// ledger adds up to. uuid.Nil starts from the first user.
+	LedgerTotals(ctx context.Context, after uuid.UUID, limit int) ([]LedgerTotal, error)
+	// SnapBalanceToLedger sets the user's balance to what their ledger adds up to, and returns the totals from
+	// before. If they already agree nothing changes. Returns ErrNotFound for unknown users.
+	SnapBalanceToLedger(ctx context.Context, userID uuid.UUID) (*LedgerTotal, error)
+	// ForfeitBalance takes the user's whole spendable balance, with a forfeit ledger entry, and returns the entry.
+	// Returns nil and no error if there was nothing to take, and ErrNotFound for unknown users.
+	ForfeitBalance(ctx context.Context, userID uuid.UUID, referenceID string) (*LedgerEntry, error)
+}
+
+// LedgerEntry is one row of the token ledger. Every change to a balance has one, so a user's entries add up to
+// their balance plus whatever they have on hold.
+type LedgerEntry struct {
+	EntryID      uuid.UUID
+	UserID       uuid.UUID
+	Kind         string // "debit", "refund", "credit", "monthly_grant", "expired", "forfeit" or "opening"
+	Amount       int
+	Granted      int           // How much of Amount was granted tokens. The rest was purchased
+	ReferenceID  string        // Empty if the caller didn't give one. For an expiry, the lot
+	RefundOf     uuid.NullUUID // For a refund, the debit it reverses
+	CostType     string        // For a debit priced by cost type, which one. Empty otherwise
+	Source       string        // For a credit, where it came from, eg "purchase" or "grant". Empty otherwise
+	BalanceAfter int
+	CreatedAt    time.Time
+}
+
+const (
+	ledgerKindDebit        = "debit"
+	ledgerKindRefund       = "refund"
+	ledgerKindMonthlyGrant = "monthly_grant"
+	ledgerKindExpired      = "expired"
+	ledgerKindCredit       = "credit"  // Any other credit, purchased or granted
+	ledgerKindOpening      = "opening" // What a user had before the ledger saw it. Written by a trigger on users
+	ledgerKindForfeit      = "forfeit" // A balance taken away when the account was deleted
+)
+
+// ledgerDeltaSQL is LedgerEntry.Delta in SQL, for adding up a user's ledger.
+const ledgerDeltaSQL = `CASE WHEN kind IN ('debit', 'expired', 'forfeit') THEN -amount ELSE amount END`
+
+// Where credited tokens came from. A user's balance is split into purchased and granted tokens, and only
+// grants go in the granted bucket. A refund gives back something the user paid for, so it counts as purchased.
+const (
+	SourcePurchase = "purchase"
+	SourceGrant    = "grant"
+	SourceRefund   = "refund"
+)
+
+// validSource reports whether source is one of the Source constants.
+func validSource(source string) bool {
+	return source == SourcePurchase || source == SourceGrant || source == SourceRefund
+}
+
+// grantedPart is how much of a credit of amount from source goes in the granted bucket.
+func grantedPart(amount int, source string) int {
+	if source == SourceGrant {
+		return amount
+	}
+	return 0
+}
+
+// ledgerColumns is the column list scanLedgerEntry expects, in order.
+const ledgerColumns = `entry_id, user_id, kind, amount, granted_amount, COALESCE(reference_id, ''), refund_of, COALESCE(cost_type, ''), COALESCE(source, ''), balance_after, created_at`
+
+// scanLedgerEntry reads one row selected with ledgerColumns.
+func scanLedgerEntry(row interface{ Scan(...any) error }) (*LedgerEntry, error) {
+	var e LedgerEntry
+	err := row.Scan(&e.EntryID, &e.UserID, &e.Kind, &e.Amount, &e.Granted, &e.ReferenceID, &e.RefundOf, &e.CostType, &e.Source, &e.BalanceAfter, &e.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// holdColumns is the column list scanTokenHold expects, in order.
+const holdColumns = `hold_id, user_id, amount, granted_amount, COALESCE(reference_id, ''), status, ledger_entry_id, created_at`
+
+// scanTokenHold reads one row selected with holdColumns. BalanceAfter is left for the caller.
+func scanTokenHold(row interface{ Scan(...any) error }) (*TokenHold, error) {
+	var h TokenHold
+	err := row.Scan(&h.HoldID, &h.UserID, &h.Amount, &h.Granted, &h.ReferenceID, &h.Status, &h.LedgerEntryID, &h.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// DefaultMaxBalance is the most tokens a balance can be credited up to, unless configured otherwise.
+// It's far above what anyone buys, and only there to stop a runaway credit loop.
+const DefaultMaxBalance = 500
+
+// RepositoryOptions are the repository's optional settings. The zero value has no maximum balance and no
+// query timeout.
+type RepositoryOptions struct {
+	MaxBalance   int           // Credits that would take a balance over this fail with ErrBalanceCapExceeded. 0 means no maximum
+	QueryTimeout time.Duration // How long each repository call gets before it fails with context.DeadlineExceeded. 0 means no limit
 }
 
 // postgresRepository is the concrete implementation of the Repository that uses Postgres.
 type postgresRepository struct {
-	db *sql.DB // database connection pool.
+	db           *sql.DB       // database connection pool.
+	maxBalance   int           // 0 means no maximum
+	queryTimeout time.Duration // 0 means no limit
 }
 
-// NewPostgresRepository is the constructor for the repository.
+// NewPostgresRepository is the constructor for the repository. Balances have no maximum, and each call gets
+// database.DefaultQueryTimeout.
 func NewPostgresRepository(db *sql.DB) Repository {
+	return NewPostgresRepositoryWithOptions(db, RepositoryOptions{QueryTimeout: database.DefaultQueryTimeout})
+}
+
+// NewPostgresRepositoryWithOptions is the same as NewPostgresRepository, with a maximum balance and the query
+// timeout from opts.
+func NewPostgresRepositoryWithOptions(db *sql.DB, opts RepositoryOptions) Repository {
 	return &postgresRepository{
-		db: db,
+		db:           db,
+		maxBalance:   max(opts.MaxBalance, 0),
+		queryTimeout: max(opts.QueryTimeout, 0),
 	}
 }
 
-// DebitToken implements the interface.
+// DebitToken implements the interface. It's the single token case of DebitTokens.
 func (pr *postgresRepository) DebitToken(ctx context.Context, userID uuid.UUID) (int, error) {
+	entry, err := pr.DebitTokens(ctx, userID, 1, "")
+	if err != nil {
+		return 0, err
+	}
+	return entry.BalanceAfter, nil
+}
+
+// DebitTokens implements the interface. It's DebitCost without a cost type.
+func (pr *postgresRepository) DebitTokens(ctx context.Context, userID uuid.UUID, amount int, referenceID string) (*LedgerEntry, error) {
+	return pr.DebitCost(ctx, userID, amount, "", referenceID)
+}
+
+// DebitCost implements the interface. The balance update and the ledger row go in one transaction.
+func (pr *postgresRepository) DebitCost(ctx context.Context, userID uuid.UUID, amount int, costType, referenceID string) (*LedgerEntry, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	var entry *LedgerEntry
+	err := pr.WithTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now()
+		spender, err := lockSpender(ctx, tx, userID, now)
+		if err != nil {
+			return err
+		}
+		// Granted tokens go first, so the user's paid ones last longest. The row is locked, so this is still right
+		// when the update runs.
+		granted := min(spender.granted, amount)
+
+		var newBalance int
+
+		// This query is the core of this service.
+		// Atomic update that only works if the balance covers the whole amount.
+		// This prevents race conditions and overdrafts.
+		query := `
+			UPDATE users
+			SET assistance_token_balance = assistance_token_balance - $2,
+			    granted_token_balance = granted_token_balance - $3
+			WHERE user_id = $1 AND assistance_token_balance >= $2
+			RETURNING assistance_token_balance
+		`
+
+		// I use QueryRowContext().Scan() because the returning clause gives me back the one row and new balance.
+		err = tx.QueryRowContext(ctx, query, userID, amount, granted).Scan(&newBalance)
+		if err != nil {
+			// If no rows were affected (either user not found or balance was 0), Scan() returns ErrNoRows.
+			if err == sql.ErrNoRows {
+				// This returns a specific error that the service layer can check for.
+				return ErrInsufficientFunds
+			}
+			// something else went wrong (eg. connection dropped)
+			return fmt.Errorf("database error during debit: %w", err)
+		}
+
+		// Record it. A reference we've already seen inserts nothing, same as a repeated credit.
+		entry, err = scanLedgerEntry(tx.QueryRowContext(ctx, `
+			INSERT INTO token_ledger (entry_id, user_id, kind, amount, granted_amount, reference_id, cost_type, balance_after)
+			VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), $8)
+			ON CONFLICT (user_id, reference_id) WHERE kind = 'debit' AND reference_id IS NOT NULL DO NOTHING
+			RETURNING `+ledgerColumns,
+			uuid.New(), userID, ledgerKindDebit, amount, granted, referenceID, costType, newBalance))
+		if err == sql.ErrNoRows {
+			return errAlreadyRecorded
+		}
+		if err != nil {
+			return fmt.Errorf("database error recording debit: %w", err)
+		}
+
+		return drawLots(ctx, tx, userID, amount, now, uuid.NullUUID{UUID: entry.EntryID, Valid: true}, uuid.NullUUID{})
+	})
+	if err == errAlreadyRecorded {
+		// Our update was rolled back. Hand back the debit that already happened.
+		return pr.getDebitByReference(ctx, userID, referenceID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// spenderBalance is a locked user's balance, and how much of it is granted tokens.
+type spenderBalance struct {
+	balance int
+	granted int
+}
+
+// lockSpender locks the user's row for a debit or hold, and first takes off anything in their lots that expired
+// by now, so the balance guard only counts tokens that can still be spent. Returns the balances after that.
+// An unknown user is ErrInsufficientFunds, same as a failed guard.
+func lockSpender(ctx context.Context, tx DBTX, userID uuid.UUID, now time.Time) (spenderBalance, error) {
+	var b spenderBalance
+	const query = `SELECT assistance_token_balance, granted_token_balance FROM users WHERE user_id = $1`
+	err := tx.QueryRowContext(ctx, query+` FOR UPDATE`, userID).Scan(&b.balance, &b.granted)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return b, ErrInsufficientFunds
+		}
+		return b, fmt.Errorf("database error locking user: %w", err)
+	}
+
+	expired, err := expireUserLots(ctx, tx, userID, now)
+	if err != nil {
+		return b, err
+	}
+	if expired > 0 {
+		err = tx.QueryRowContext(ctx, query, userID).Scan(&b.balance, &b.granted)
+		if err != nil {
+			return b, fmt.Errorf("database error reading balance after expiry: %w", err)
+		}
+	}
+	return b, nil
+}
+
+// expireUserLots takes what's left in the user's lots that expired by now off their balance, with an expired
+// ledger entry for each lot. The caller must hold the user's row lock. Returns how many lots it expired.
+func expireUserLots(ctx context.Context, tx DBTX, userID uuid.UUID, now time.Time) (int, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT lot_id, remaining
+		FROM token_lots
+		WHERE user_id = $1 AND remaining > 0 AND expires_at <= $2
+		ORDER BY expires_at, lot_id
+	`, userID, now)
+	if err != nil {
+		return 0, fmt.Errorf("database error finding expired lots: %w", err)
+	}
+	type expiredLot struct {
+		lotID     uuid.UUID
+		remaining int
+	}
+	var lots []expiredLot
+	for rows.Next() {
+		var lot expiredLot
+		if err := rows.Scan(&lot.lotID, &lot.remaining); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("could not read expired lot: %w", err)
+		}
+		lots = append(lots, lot)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("database error finding expired lots: %w", err)
+	}
+
+	// One at a time, so each ledger entry has the balance right after its own lot went.
+	// Lots are granted tokens, so they come out of the granted bucket too. GREATEST is only there for a hold from
+	// before the buckets were split, whose release refilled its lots without refilling the granted bucket.
+	for _, lot := range lots {
+		var balance int
+		err := tx.QueryRowContext(ctx, `
+			UPDATE users
+			SET assistance_token_balance = assistance_token_balance - $1,
+			    granted_token_balance = GREATEST(granted_token_balance - $1, 0)
+			WHERE user_id = $2
+			RETURNING assistance_token_balance
+		`, lot.remaining, userID).Scan(&balance)
+		if err != nil {
+			return 0, fmt.Errorf("database error expiring lot %s: %w", lot.lotID, err)
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE token_lots SET remaining = 0 WHERE lot_id = $1`, lot.lotID); err != nil {
+			return 0, fmt.Errorf("database error emptying lot %s: %w", lot.lotID, err)
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO token_ledger (entry_id, user_id, kind, amount, granted_amount, reference_id, balance_after)
+			VALUES ($1, $2, $3, $4, $4, $5, $6)
+		`, uuid.New(), userID, ledgerKindExpired, lot.remaining, lot.lotID.String(), balance)
+		if err != nil {
+			return 0, fmt.Errorf("database error recording expired lot %s: %w", lot.lotID, err)
+		}
+	}
+	return len(lots), nil
+}
+
+// drawLots takes amount tokens out of the user's lots that haven't expired by now, soonest expiring first,
+// and records what came out of each lot against the debit or hold that spent it. Whatever the lots don't cover
+// came out of tokens that never expire. The caller must hold the user's row lock and have taken amount off the
+// balance already.
+func drawLots(ctx context.Context, tx DBTX, userID uuid.UUID, amount int, now time.Time, entryID, holdID uuid.NullUUID) error {
+	// before is how much the sooner lots hold, so a lot gives whatever of amount they didn't cover, up to all of it.
+	_, err := tx.ExecContext(ctx, `
+		WITH open AS (
+			SELECT lot_id, remaining,
+			       (SUM(remaining) OVER (ORDER BY expires_at, lot_id))::int - remaining AS before
+			FROM token_lots
+			WHERE user_id = $1 AND remaining > 0 AND expires_at > $3
+		), drawn AS (
+			UPDATE token_lots
+			SET remaining = token_lots.remaining - LEAST(open.remaining, $2::int - open.before)
+			FROM open
+			WHERE token_lots.lot_id = open.lot_id AND open.before < $2::int
+			RETURNING token_lots.lot_id, LEAST(open.remaining, $2::int - open.before) AS amount
+		)
+		INSERT INTO token_lot_draws (lot_id, ledger_entry_id, hold_id, amount)
+		SELECT lot_id, $4::uuid, $5::uuid, amount FROM drawn
+	`, userID, amount, now, entryID, holdID)
+	if err != nil {
+		return fmt.Errorf("database error drawing from lots: %w", err)
+	}
+	return nil
+}
+
+// Columns of token_lot_draws that returnDraws can find draws by.
+const (
+	drawsByLedgerEntry = "ledger_entry_id"
+	drawsByHold        = "hold_id"
+)
+
+// returnDraws puts what a debit or hold drew from lots back in the same lots. A lot that expired in the meantime
+// gets them back too, and the next sweep or spend takes them off again. The caller must hold the user's row lock.
+func returnDraws(ctx context.Context, tx DBTX, column string, id uuid.UUID) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE token_lots
+		SET remaining = token_lots.remaining + d.amount
+		FROM (SELECT lot_id, SUM(amount)::int AS amount FROM token_lot_draws WHERE `+column+` = $1 GROUP BY lot_id) d
+		WHERE token_lots.lot_id = d.lot_id
+	`, id)
+	if err != nil {
+		return fmt.Errorf("database error returning tokens to lots: %w", err)
+	}
+	return nil
+}
+
+// getDebitByReference reads the debit a reference already belongs to.
+func (pr *postgresRepository) getDebitByReference(ctx context.Context, userID uuid.UUID, referenceID string) (*LedgerEntry, error) {
+	entry, err := scanLedgerEntry(pr.db.QueryRowContext(ctx, `
+		SELECT `+ledgerColumns+`
+		FROM token_ledger
+		WHERE user_id = $1 AND kind = 'debit' AND reference_id = $2
+	`, userID, referenceID))
+	if err != nil {
+		return nil, fmt.Errorf("database error reading previous debit: %w", err)
+	}
+	return entry, nil
+}
+
+// RefundDebit implements the interface. Locking the original debit row means two refunds of the same debit
+// queue up, and the second one then sees the first one's refund row.
+func (pr *postgresRepository) RefundDebit(ctx context.Context, userID, entryID uuid.UUID, referenceID string) (*LedgerEntry, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	tx, err := pr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin refund transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op once committed.
+
+	// Find the debit by whichever handle we were given. It has to be this user's.
+	var debitID uuid.UUID
+	var amount, granted int
+	err = tx.QueryRowContext(ctx, `
+		SELECT entry_id, amount, granted_amount
+		FROM token_ledger
+		WHERE user_id = $1 AND kind = 'debit' AND (entry_id = $2 OR reference_id = NULLIF($3, ''))
+		FOR UPDATE
+	`, userID, entryID, referenceID).Scan(&debitID, &amount, &granted)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrDebitNotFound
+		}
+		return nil, fmt.Errorf("database error finding debit: %w", err)
+	}
+
+	var refunded bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM token_ledger WHERE refund_of = $1)`, debitID).Scan(&refunded)
+	if err != nil {
+		return nil, fmt.Errorf("database error checking for refund: %w", err)
+	}
+	if refunded {
+		return nil, ErrAlreadyRefunded
+	}
+
+	// Each bucket gets back what the debit took from it.
 	var newBalance int
+	err = tx.QueryRowContext(ctx, `
+		UPDATE users
+		SET assistance_token_balance = assistance_token_balance + $1,
+		    granted_token_balance = granted_token_balance + $3
+		WHERE user_id = $2
+		RETURNING assistance_token_balance
+	`, amount, userID, granted).Scan(&newBalance)
+	if err != nil {
+		return nil, fmt.Errorf("database error during refund: %w", err)
+	}
+	if err := returnDraws(ctx, tx, drawsByLedgerEntry, debitID); err != nil {
+		return nil, err
+	}
 
-	// This query is the core of this service.
-	// Atomic update that only works if the balance is > 0.
-	// This prevents race conditions and overdrafts.
-	query := `
+	entry, err := scanLedgerEntry(tx.QueryRowContext(ctx, `
+		INSERT INTO token_ledger (entry_id, user_id, kind, amount, granted_amount, refund_of, balance_after)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING `+ledgerColumns,
+		uuid.New(), userID, ledgerKindRefund, amount, granted, debitID, newBalance))
+	if err != nil {
+		return nil, fmt.Errorf("database error recording refund: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit refund: %w", err)
+	}
+	return entry, nil
+}
+
+func (pr *postgresRepository) CreditToken(ctx context.Context, userID uuid.UUID, amount int, source string) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	// The balance update and the ledger row go in one transaction.
+	var newBalance int
+	err := pr.WithTx(ctx, func(tx *sql.Tx) error {
+		granted := grantedPart(amount, source)
+		var err error
+		newBalance, err = addTokens(ctx, tx, userID, amount, granted, pr.maxBalance)
+		if err != nil {
+			return err
+		}
+		return recordCreditEntry(ctx, tx, userID, amount, granted, source, "", newBalance)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return newBalance, nil
+}
+
+// addTokens puts amount on the user's balance, granted of it in the granted bucket, and returns the new balance.
+// An unknown user is ErrNotFound. If the new balance would be over maxBalance nothing changes and it's
+// ErrBalanceCapExceeded. A maxBalance of 0 means no maximum.
+func addTokens(ctx context.Context, tx DBTX, userID uuid.UUID, amount, granted, maxBalance int) (int, error) {
+	var newBalance int
+	// The cap is in the WHERE, like the debit guard, so two credits at once can't both squeeze under it.
+	err := tx.QueryRowContext(ctx, `
 		UPDATE users
-		SET assistance_token_balance = assistance_token_balance - 1
-		WHERE user_id = $1 AND assistance_token_balance > 0
+		SET assistance_token_balance = assistance_token_balance + $1,
+		    granted_token_balance = granted_token_balance + $3
+		WHERE user_id = $2 AND ($4::int = 0 OR assistance_token_balance + $1 <= $4::int)
 		RETURNING assistance_token_balance
+	`, amount, userID, granted, maxBalance).Scan(&newBalance)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, creditRefused(ctx, tx, userID)
+		}
+		return 0, fmt.Errorf("database error during credit: %w", err)
+	}
+	return newBalance, nil
+}
+
+// creditRefused says why a capped credit's update matched no row: ErrNotFound if the user doesn't exist,
+// otherwise ErrBalanceCapExceeded.
+func creditRefused(ctx context.Context, tx DBTX, userID uuid.UUID) error {
+	var exists bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE user_id = $1)`, userID).Scan(&exists); err != nil {
+		return fmt.Errorf("database error checking user: %w", err)
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	return ErrBalanceCapExceeded
+}
+
+// previousCreditOverCap is for a referenced credit the cap turned away. If it's a retry of one that went through
+// before the balance got this high, the answer is that credit's balance, like any other retry. Otherwise it's err.
+func (pr *postgresRepository) previousCreditOverCap(ctx context.Context, userID uuid.UUID, referenceID string, err error) (int, error) {
+	if previous, prevErr := pr.previousCredit(ctx, userID, referenceID); prevErr == nil {
+		return previous, nil
+	}
+	return 0, err
+}
+
+// CreditTokenOnce writes the ledger row in the same transaction as the balance update,
+// so the primary key on (user_id, reference_id) is what stops a retried credit.
+func (pr *postgresRepository) CreditTokenOnce(ctx context.Context, userID uuid.UUID, amount int, source, referenceID string) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	var newBalance int
+	err := pr.WithTx(ctx, func(tx *sql.Tx) error {
+		// Update first, so an unknown user is ErrNotFound rather than a foreign key error.
+		// This also takes the row lock, so a concurrent retry waits here until we've committed.
+		granted := grantedPart(amount, source)
+		var err error
+		newBalance, err = addTokens(ctx, tx, userID, amount, granted, pr.maxBalance)
+		if err != nil {
+			return err
+		}
+
+		inserted, err := recordCredit(ctx, tx, userID, amount, referenceID, newBalance)
+		if err != nil {
+			return err
+		}
+		if !inserted {
+			return errAlreadyRecorded
+		}
+		return recordCreditEntry(ctx, tx, userID, amount, granted, source, referenceID, newBalance)
+	})
+	if err == errAlreadyRecorded {
+		// Seen this reference before. Our update was rolled back, so answer with what the first credit left.
+		return pr.previousCredit(ctx, userID, referenceID)
+	}
+	if errors.Is(err, ErrBalanceCapExceeded) {
+		return pr.previousCreditOverCap(ctx, userID, referenceID, err)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return newBalance, nil
+}
+
+// recordCredit writes a credit to token_credits. It returns false if the user already has a credit under
+// referenceID, in which case the caller should roll back and use previousCredit.
+func recordCredit(ctx context.Context, tx DBTX, userID uuid.UUID, amount int, referenceID string, newBalance int) (bool, error) {
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO token_credits (user_id, reference_id, amount, balance_after)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, reference_id) DO NOTHING
+	`, userID, referenceID, amount, newBalance)
+	if err != nil {
+		return false, fmt.Errorf("database error recording credit: %w", err)
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("could not get rows affected for credit: %w", err)
+	}
+	return inserted > 0, nil
+}
+
+// recordCreditEntry writes a credit to token_ledger, with the rest of the balance's history. token_credits is only
+// for spotting a repeated reference, and only has the credits that came with one. source says where the credit came from.
+func recordCreditEntry(ctx context.Context, tx DBTX, userID uuid.UUID, amount, granted int, source, referenceID string, newBalance int) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO token_ledger (entry_id, user_id, kind, amount, granted_amount, source, reference_id, balance_after)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), $8)
+	`, uuid.New(), userID, ledgerKindCredit, amount, granted, source, referenceID, newBalance)
+	if err != nil {
+		return fmt.Errorf("database error recording credit in the ledger: %w", err)
+	}
+	return nil
+}
+
+// previousCredit is the balance the user's first credit under referenceID left.
+func (pr *postgresRepository) previousCredit(ctx context.Context, userID uuid.UUID, referenceID string) (int, error) {
+	var previous int
+	err := pr.db.QueryRowContext(ctx,
+		`SELECT balance_after FROM token_credits WHERE user_id = $1 AND reference_id = $2`,
+		userID, referenceID).Scan(&previous)
+	if err != nil {
+		return 0, fmt.Errorf("database error reading previous credit: %w", err)
+	}
+	return previous, nil
+}
+
+// CreditLot implements the interface. It's CreditTokenOnce, with the tokens also put in a new lot
+// so they can run out. The reference is optional here.
+func (pr *postgresRepository) CreditLot(ctx context.Context, userID uuid.UUID, amount int, source string, expiresAt time.Time, referenceID string) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	tx, err := pr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not begin lot credit transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op once committed.
+
+	// The update takes the user's row lock, which every change to their lots needs. Lots are always granted tokens.
+	newBalance, err := addTokens(ctx, tx, userID, amount, amount, pr.maxBalance)
+	if errors.Is(err, ErrBalanceCapExceeded) && referenceID != "" {
+		tx.Rollback()
+		return pr.previousCreditOverCap(ctx, userID, referenceID, err)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if referenceID != "" {
+		inserted, err := recordCredit(ctx, tx, userID, amount, referenceID, newBalance)
+		if err != nil {
+			return 0, err
+		}
+		if !inserted {
+			tx.Rollback()
+			return pr.previousCredit(ctx, userID, referenceID)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO token_lots (lot_id, user_id, source, amount, remaining, expires_at)
+		VALUES ($1, $2, $3, $4, $4, $5)
+	`, uuid.New(), userID, source, amount, expiresAt)
+	if err != nil {
+		return 0, fmt.Errorf("database error recording lot: %w", err)
+	}
+	if err := recordCreditEntry(ctx, tx, userID, amount, amount, source, referenceID, newBalance); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("could not commit lot credit: %w", err)
+	}
+	return newBalance, nil
+}
+
+// CreditBatch is for campaigns and support grants to many users at once. Each credit goes in token_credits under
+// the campaign's reference, like CreditTokenOnce, so sending the same batch again credits nobody twice.
+// The credits and their ledger rows are one statement, then a second query in the same transaction sorts out why
+// anyone wasn't credited. Nobody paid for a campaign's tokens, so they're all granted.
+func (pr *postgresRepository) CreditBatch(ctx context.Context, referenceID string, items []BatchCreditItem) ([]BatchCreditResult, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	// Pass the ids as a text array and cast it in the query so we don't rely on the driver knowing about uuid slices.
+	ids := make([]string, len(items))
+	amounts := make([]int64, len(items))
+	for i, item := range items {
+		ids[i] = item.UserID.String()
+		amounts[i] = int64(item.Amount)
+	}
+
+	tx, err := pr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin batch credit transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op once committed.
+
+	// Users are locked in id order, so two batches touching the same users can't deadlock.
+	// Only users the ledger insert actually took get the credit.
+	rows, err := tx.QueryContext(ctx, `
+		WITH items AS (
+			SELECT * FROM unnest($1::uuid[], $2::int[]) AS t(user_id, amount)
+		), inserted AS (
+			INSERT INTO token_credits (user_id, reference_id, amount, balance_after)
+			SELECT u.user_id, $3, i.amount, u.assistance_token_balance + i.amount
+			FROM items i
+			JOIN users u ON u.user_id = i.user_id
+			WHERE $4::int = 0 OR u.assistance_token_balance + i.amount <= $4::int
+			ORDER BY u.user_id
+			FOR UPDATE OF u
+			ON CONFLICT (user_id, reference_id) DO NOTHING
+			RETURNING user_id, amount
+		), updated AS (
+			UPDATE users
+			SET assistance_token_balance = assistance_token_balance + inserted.amount,
+			    granted_token_balance = granted_token_balance + inserted.amount
+			FROM inserted
+			WHERE users.user_id = inserted.user_id
+			RETURNING users.user_id, users.assistance_token_balance, inserted.amount
+		), ledger AS (
+			INSERT INTO token_ledger (entry_id, user_id, kind, amount, granted_amount, source, reference_id, balance_after)
+			SELECT gen_random_uuid(), user_id, $5, amount, amount, $6, $3, assistance_token_balance
+			FROM updated
+		)
+		SELECT user_id, assistance_token_balance FROM updated
+	`, ids, amounts, referenceID, pr.maxBalance, ledgerKindCredit, SourceGrant)
+	if err != nil {
+		return nil, fmt.Errorf("database error during batch credit: %w", err)
+	}
+	credited := make(map[uuid.UUID]int, len(items))
+	for rows.Next() {
+		var id uuid.UUID
+		var balance int
+		if err := rows.Scan(&id, &balance); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("could not read batch credit: %w", err)
+		}
+		credited[id] = balance
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("database error during batch credit: %w", err)
+	}
+
+	// Everyone else doesn't exist, was credited under this reference before, or was left out by the cap.
+	rows, err = tx.QueryContext(ctx, `
+		SELECT u.user_id, c.balance_after
+		FROM users u
+		LEFT JOIN token_credits c ON c.user_id = u.user_id AND c.reference_id = $2
+		WHERE u.user_id = ANY($1::uuid[])
+	`, ids, referenceID)
+	if err != nil {
+		return nil, fmt.Errorf("database error checking batch credit: %w", err)
+	}
+	previous := make(map[uuid.UUID]int, len(items))
+	exists := make(map[uuid.UUID]bool, len(items))
+	for rows.Next() {
+		var id uuid.UUID
+		var balance sql.NullInt64
+		if err := rows.Scan(&id, &balance); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("could not read batch credit check: %w", err)
+		}
+		exists[id] = true
+		if balance.Valid {
+			previous[id] = int(balance.Int64)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("database error checking batch credit: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit batch credit: %w", err)
+	}
+
+	results := make([]BatchCreditResult, len(items))
+	for i, item := range items {
+		result := BatchCreditResult{UserID: item.UserID}
+		if balance, ok := credited[item.UserID]; ok {
+			result.Status, result.NewBalance = BatchCredited, balance
+		} else if balance, ok := previous[item.UserID]; ok {
+			result.Status, result.NewBalance = BatchAlreadyCredited, balance
+		} else if exists[item.UserID] {
+			result.Status = BatchCapExceeded
+		} else {
+			result.Status = BatchUserNotFound
+		}
+		results[i] = result
+	}
+	return results, nil
+}
+
+// GetBalance reads the balance straight from the users table, so it's never staler than the last debit or credit.
+// Lots that expired but haven't been swept yet are left out, since they can't be spent.
+func (pr *postgresRepository) GetBalance(ctx context.Context, userID uuid.UUID) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	var balance int
+	query := `
+		SELECT u.assistance_token_balance - COALESCE((
+			SELECT SUM(remaining)
+			FROM token_lots l
+			WHERE l.user_id = u.user_id AND l.remaining > 0 AND l.expires_at <= now()
+		), 0)
+		FROM users u
+		WHERE u.user_id = $1
 	`
 
-	// I use QueryRowContext().Scan() because the returning clause gives me back the one row and new balance.
-	err := pr.db.QueryRowContext(ctx, query, userID).Scan(&newBalance)
+	err := pr.db.QueryRowContext(ctx, query, userID).Scan(&balance)
 	if err != nil {
-		// If no rows were affected (either user not found or balance was 0), Scan() returns ErrNoRows.
 		if err == sql.ErrNoRows {
-			// This returns a specific error that the service layer can check for.
-			return 0, fmt.Errorf("insufficient funds or user not found")
+			return 0, ErrNotFound
 		}
-		// something else went wrong (eg. connection dropped)
-		return 0, fmt.Errorf("database error during debit: %w", err)
+		return 0, fmt.Errorf("database error reading balance: %w", err)
 	}
+	return balance, nil
+}
 
-	return newBalance, nil
+// GetBalanceBreakdown implements the interface. It's one statement, so the balance and the lots are from the
+// same moment even with a debit going on.
+func (pr *postgresRepository) GetBalanceBreakdown(ctx context.Context, userID uuid.UUID) (*Balance, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	rows, err := pr.db.QueryContext(ctx, `
+		SELECT u.assistance_token_balance, u.granted_token_balance, l.expires_at, l.remaining, l.expires_at <= now()
+		FROM users u
+		LEFT JOIN LATERAL (
+			SELECT expires_at, SUM(remaining)::int AS remaining
+			FROM token_lots
+			WHERE user_id = u.user_id AND remaining > 0
+			GROUP BY expires_at
+		) l ON true
+		WHERE u.user_id = $1
+		ORDER BY l.expires_at
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("database error reading balance: %w", err)
+	}
+	defer rows.Close()
+
+	// A user with no open lots is one row with the lot columns NULL. No rows at all is no such user.
+	found := false
+	var balance, granted int
+	var lots []lotTotal
+	for rows.Next() {
+		var expiresAt sql.NullTime
+		var remaining sql.NullInt64
+		var expired sql.NullBool
+		if err := rows.Scan(&balance, &granted, &expiresAt, &remaining, &expired); err != nil {
+			return nil, fmt.Errorf("could not read balance: %w", err)
+		}
+		found = true
+		if expiresAt.Valid {
+			lots = append(lots, lotTotal{ExpiresAt: expiresAt.Time, Amount: int(remaining.Int64), Expired: expired.Bool})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("database error reading balance: %w", err)
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+	return summarizeBalance(balance, granted, lots), nil
 }
 
-func (pr *postgresRepository) CreditToken(ctx context.Context, userID uuid.UUID, amount int) (int, error) {
-	var newBalance int
+// ListLedger implements the interface. The row comparison matches the (user_id, created_at DESC, entry_id DESC)
+// index, so a page deep into the history costs the same as the first.
+func (pr *postgresRepository) ListLedger(ctx context.Context, userID uuid.UUID, after *LedgerCursor, limit int) ([]*LedgerEntry, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	query := `SELECT ` + ledgerColumns + `
+		FROM token_ledger
+		WHERE user_id = $1
+		ORDER BY created_at DESC, entry_id DESC
+		LIMIT $2`
+	args := []any{userID, limit}
+	if after != nil {
+		query = `SELECT ` + ledgerColumns + `
+			FROM token_ledger
+			WHERE user_id = $1 AND (created_at, entry_id) < ($3, $4)
+			ORDER BY created_at DESC, entry_id DESC
+			LIMIT $2`
+		args = append(args, after.CreatedAt, after.EntryID)
+	}
+
+	rows, err := pr.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("database error listing ledger: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*LedgerEntry
+	for rows.Next() {
+		entry, err := scanLedgerEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan ledger entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("database error listing ledger: %w", err)
+	}
+	return entries, nil
+}
+
+// GrantTierTokens implements the interface in one statement. The ledger insert only returns the users it
+// actually inserted for, since the unique index on (user_id, reference_id) for monthly_grant skips anyone already
+// granted this cycle, and only those users get the credit. Deleted users are skipped, and so is anyone the grant
+// would take over maxBalance, like in CreditBatch. The users rows are locked while we read their balance, so
+// balance_after and the cap check are right even if a debit comes in at the same time.
+func (pr *postgresRepository) GrantTierTokens(ctx context.Context, tier string, amount int, cycle string) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
 
 	query := `
+		WITH granted AS (
+			INSERT INTO token_ledger (entry_id, user_id, kind, amount, granted_amount, reference_id, balance_after)
+			SELECT gen_random_uuid(), user_id, $4, $2, $2, $3, assistance_token_balance + $2
+			FROM users
+			WHERE membership_tier = $1 AND deleted_at IS NULL
+			  AND ($5::int = 0 OR assistance_token_balance + $2 <= $5::int)
+			FOR UPDATE
+			ON CONFLICT (user_id, reference_id) WHERE kind = 'monthly_grant' DO NOTHING
+			RETURNING user_id
+		)
+		UPDATE users
+		SET assistance_token_balance = assistance_token_balance + $2,
+		    granted_token_balance = granted_token_balance + $2
+		FROM granted
+		WHERE users.user_id = granted.user_id
+	`
+
+	result, err := pr.db.ExecContext(ctx, query, tier, amount, cycle, ledgerKindMonthlyGrant, pr.maxBalance)
+	if err != nil {
+		return 0, fmt.Errorf("database error granting %s tokens: %w", tier, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("could not get rows affected for %s grant: %w", tier, err)
+	}
+	return int(rowsAffected), nil
+}
+
+// HoldTokens implements the interface. The user's row is locked first, so a retry with the same reference
+// waits for the first hold and then finds it, even if the balance couldn't cover a second one.
+func (pr *postgresRepository) HoldTokens(ctx context.Context, userID uuid.UUID, amount int, referenceID string) (*TokenHold, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	tx, err := pr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin hold transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op once committed.
+
+	// Same as a debit, an unknown user just can't pay.
+	now := time.Now()
+	spender, err := lockSpender(ctx, tx, userID, now)
+	if err != nil {
+		return nil, err
+	}
+	balance := spender.balance
+
+	if referenceID != "" {
+		hold, err := scanTokenHold(tx.QueryRowContext(ctx, `
+			SELECT `+holdColumns+`
+			FROM token_holds
+			WHERE user_id = $1 AND reference_id = $2
+		`, userID, referenceID))
+		if err == nil {
+			hold.BalanceAfter = balance
+			return hold, nil
+		}
+		if err != sql.ErrNoRows {
+			return nil, fmt.Errorf("database error reading previous hold: %w", err)
+		}
+	}
+
+	// The same guard as a debit, and granted tokens go first the same way.
+	// The tokens move to held_token_balance rather than disappearing.
+	granted := min(spender.granted, amount)
+	err = tx.QueryRowContext(ctx, `
+		UPDATE users
+		SET assistance_token_balance = assistance_token_balance - $2,
+		    granted_token_balance = granted_token_balance - $3,
+		    held_token_balance = held_token_balance + $2
+		WHERE user_id = $1 AND assistance_token_balance >= $2
+		RETURNING assistance_token_balance
+	`, userID, amount, granted).Scan(&balance)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInsufficientFunds
+		}
+		return nil, fmt.Errorf("database error during hold: %w", err)
+	}
+
+	hold, err := scanTokenHold(tx.QueryRowContext(ctx, `
+		INSERT INTO token_holds (hold_id, user_id, amount, granted_amount, reference_id, status)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6)
+		RETURNING `+holdColumns,
+		uuid.New(), userID, amount, granted, referenceID, holdStatusHeld))
+	if err != nil {
+		return nil, fmt.Errorf("database error recording hold: %w", err)
+	}
+
+	// Held tokens come out of lots like a debit's would. A release puts them back.
+	if err := drawLots(ctx, tx, userID, amount, now, uuid.NullUUID{}, uuid.NullUUID{UUID: hold.HoldID, Valid: true}); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit hold: %w", err)
+	}
+	hold.BalanceAfter = balance
+	return hold, nil
+}
+
+// lockHold reads one of the user's holds and locks it until tx ends, so a commit, a release and the sweeper
+// can't all act on it at once.
+func lockHold(ctx context.Context, tx DBTX, userID, holdID uuid.UUID) (*TokenHold, error) {
+	hold, err := scanTokenHold(tx.QueryRowContext(ctx, `
+		SELECT `+holdColumns+`
+		FROM token_holds
+		WHERE hold_id = $1 AND user_id = $2
+		FOR UPDATE
+	`, holdID, userID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrHoldNotFound
+		}
+		return nil, fmt.Errorf("database error finding hold: %w", err)
+	}
+	return hold, nil
+}
+
+// CommitHold implements the interface. The balance the user sees already went down when the tokens were held,
+// so this only empties the held bucket and writes the debit.
+func (pr *postgresRepository) CommitHold(ctx context.Context, userID, holdID uuid.UUID) (*LedgerEntry, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	tx, err := pr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin commit transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op once committed.
+
+	hold, err := lockHold(ctx, tx, userID, holdID)
+	if err != nil {
+		return nil, err
+	}
+	switch hold.Status {
+	case holdStatusCommitted:
+		// A retried commit. Hand back the debit it already became.
+		entry, err := scanLedgerEntry(tx.QueryRowContext(ctx,
+			`SELECT `+ledgerColumns+` FROM token_ledger WHERE entry_id = $1`, hold.LedgerEntryID.UUID))
+		if err != nil {
+			return nil, fmt.Errorf("database error reading committed hold's debit: %w", err)
+		}
+		return entry, nil
+	case holdStatusReleased:
+		return nil, ErrHoldReleased
+	}
+
+	var balance int
+	err = tx.QueryRowContext(ctx, `
 		UPDATE users
-		SET assistance_token_balance = assistance_token_balance + $1
+		SET held_token_balance = held_token_balance - $1
 		WHERE user_id = $2
 		RETURNING assistance_token_balance
+	`, hold.Amount, userID).Scan(&balance)
+	if err != nil {
+		return nil, fmt.Errorf("database error during commit: %w", err)
+	}
+
+	// The hold's reference and granted part go on the debit, so it can still be refunded by reference later,
+	// and the refund knows which bucket to put the tokens back in.
+	entry, err := scanLedgerEntry(tx.QueryRowContext(ctx, `
+		INSERT INTO token_ledger (entry_id, user_id, kind, amount, granted_amount, reference_id, balance_after)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7)
+		RETURNING `+ledgerColumns,
+		uuid.New(), userID, ledgerKindDebit, hold.Amount, hold.Granted, hold.ReferenceID, balance))
+	if err != nil {
+		return nil, fmt.Errorf("database error recording committed hold: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE token_holds
+		SET status = $2, ledger_entry_id = $3, resolved_at = now()
+		WHERE hold_id = $1
+	`, holdID, holdStatusCommitted, entry.EntryID)
+	if err != nil {
+		return nil, fmt.Errorf("database error marking hold committed: %w", err)
+	}
+
+	// The hold's draws belong to the debit now too, so refunding it puts them back.
+	_, err = tx.ExecContext(ctx, `UPDATE token_lot_draws SET ledger_entry_id = $2 WHERE hold_id = $1`, holdID, entry.EntryID)
+	if err != nil {
+		return nil, fmt.Errorf("database error moving hold's lot draws: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit hold commit: %w", err)
+	}
+	return entry, nil
+}
+
+// ReleaseHold implements the interface.
+func (pr *postgresRepository) ReleaseHold(ctx context.Context, userID, holdID uuid.UUID) (*TokenHold, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	tx, err := pr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin release transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op once committed.
+
+	hold, err := lockHold(ctx, tx, userID, holdID)
+	if err != nil {
+		return nil, err
+	}
+	switch hold.Status {
+	case holdStatusCommitted:
+		return nil, ErrHoldCommitted
+	case holdStatusReleased:
+		// Already back, eg the sweeper got there first. Nothing to do but report the balance.
+		tx.Rollback()
+		balance, err := pr.GetBalance(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		hold.BalanceAfter = balance
+		return hold, nil
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE users
+		SET assistance_token_balance = assistance_token_balance + $1,
+		    granted_token_balance = granted_token_balance + $3,
+		    held_token_balance = held_token_balance - $1
+		WHERE user_id = $2
+		RETURNING assistance_token_balance
+	`, hold.Amount, userID, hold.Granted).Scan(&hold.BalanceAfter)
+	if err != nil {
+		return nil, fmt.Errorf("database error during release: %w", err)
+	}
+	if err := returnDraws(ctx, tx, drawsByHold, holdID); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE token_holds
+		SET status = $2, resolved_at = now()
+		WHERE hold_id = $1
+	`, holdID, holdStatusReleased)
+	if err != nil {
+		return nil, fmt.Errorf("database error marking hold released: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit release: %w", err)
+	}
+	hold.Status = holdStatusReleased
+	return hold, nil
+}
+
+// ReleaseExpiredHolds implements the interface in one statement. SKIP LOCKED leaves alone any hold that's
+// being committed or released right now, and the status check means one that just finished isn't touched.
+// A user with several expired holds gets them back in a single update. Their lot draws go back too, joined
+// through returned so the users rows are locked before any lot is touched, the same order as everywhere else.
+func (pr *postgresRepository) ReleaseExpiredHolds(ctx context.Context, createdBefore time.Time, limit int) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	query := `
+		WITH expired AS (
+			UPDATE token_holds
+			SET status = $3, resolved_at = now()
+			WHERE hold_id IN (
+				SELECT hold_id
+				FROM token_holds
+				WHERE status = $4 AND created_at < $1
+				ORDER BY created_at
+				LIMIT $2
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING hold_id, user_id, amount, granted_amount
+		), returned AS (
+			UPDATE users
+			SET assistance_token_balance = assistance_token_balance + e.amount,
+			    granted_token_balance = granted_token_balance + e.granted,
+			    held_token_balance = held_token_balance - e.amount
+			FROM (SELECT user_id, SUM(amount) AS amount, SUM(granted_amount) AS granted FROM expired GROUP BY user_id) e
+			WHERE users.user_id = e.user_id
+			RETURNING users.user_id
+		), refilled AS (
+			UPDATE token_lots
+			SET remaining = token_lots.remaining + d.amount
+			FROM (
+				SELECT dr.lot_id, SUM(dr.amount)::int AS amount
+				FROM token_lot_draws dr
+				JOIN expired e ON e.hold_id = dr.hold_id
+				JOIN returned r ON r.user_id = e.user_id
+				GROUP BY dr.lot_id
+			) d
+			WHERE token_lots.lot_id = d.lot_id
+		)
+		SELECT count(*) FROM expired
 	`
 
-	// Use QueryRowContext().Scan() because returning gives new balance
-	err := pr.db.QueryRowContext(ctx, query, amount, userID).Scan(&newBalance)
+	var released int
+	err := pr.db.QueryRowContext(ctx, query, createdBefore, limit, holdStatusReleased, holdStatusHeld).Scan(&released)
 	if err != nil {
-		// If the user_id doesn't existreturn sql.ErrNoRows.
-		if err == sql.ErrNoRows {
-			return 0, fmt.Errorf("user not found")
+		return 0, fmt.Errorf("database error releasing expired holds: %w", err)
+	}
+	return released, nil
+}
+
+// ExpireLots implements the interface. It finds the users with the first limit lots to have expired, then
+// expires all of each user's expired lots in a transaction of its own, with their users row locked first like
+// any other change to their lots. Another sweeper that got to a user first just leaves nothing to do.
+// The lookup and each user's transaction get the query timeout separately, so a big batch isn't cut off halfway.
+func (pr *postgresRepository) ExpireLots(ctx context.Context, now time.Time, limit int) (int, error) {
+	queryCtx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	rows, err := pr.db.QueryContext(queryCtx, `
+		SELECT DISTINCT user_id
+		FROM (
+			SELECT user_id
+			FROM token_lots
+			WHERE remaining > 0 AND expires_at <= $1
+			ORDER BY expires_at
+			LIMIT $2
+		) due
+	`, now, limit)
+	if err != nil {
+		return 0, fmt.Errorf("database error finding expired lots: %w", err)
+	}
+	var userIDs []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("could not read expired lot: %w", err)
 		}
-		return 0, fmt.Errorf("database error during credit: %w", err)
+		userIDs = append(userIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("database error finding expired lots: %w", err)
 	}
 
-	return newBalance, nil
+	expired := 0
+	for _, userID := range userIDs {
+		n, err := pr.expireLotsOf(ctx, userID, now)
+		if err != nil {
+			return expired, err
+		}
+		expired += n
+	}
+	return expired, nil
+}
+
+// expireLotsOf expires one user's lots in its own transaction.
+func (pr *postgresRepository) expireLotsOf(ctx context.Context, userID uuid.UUID, now time.Time) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	tx, err := pr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not begin expiry transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op once committed.
+
+	var locked uuid.UUID
+	err = tx.QueryRowContext(ctx, `SELECT user_id FROM users WHERE user_id = $1 FOR UPDATE`, userID).Scan(&locked)
+	if err == sql.ErrNoRows {
+		// Deleted since we looked, and their lots with them.
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("database error locking user for expiry: %w", err)
+	}
+
+	n, err := expireUserLots(ctx, tx, userID, now)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("could not commit expiry: %w", err)
+	}
+	return n, nil
+}
+
+// LedgerTotals implements the interface. It's one statement, so each balance and the ledger it's compared with are
+// from the same moment, even with a debit going on.
+func (pr *postgresRepository) LedgerTotals(ctx context.Context, after uuid.UUID, limit int) ([]LedgerTotal, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	rows, err := pr.db.QueryContext(ctx, `
+		WITH batch AS (
+			SELECT user_id, assistance_token_balance + held_token_balance AS balance
+			FROM users
+			WHERE user_id > $1
+			ORDER BY user_id
+			LIMIT $2
+		)
+		SELECT b.user_id, b.balance, COALESCE(SUM(`+ledgerDeltaSQL+`), 0)::int
+		FROM batch b
+		LEFT JOIN token_ledger l ON l.user_id = b.user_id
+		GROUP BY b.user_id, b.balance
+		ORDER BY b.user_id
+	`, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("database error adding up ledgers: %w", err)
+	}
+	defer rows.Close()
+
+	var totals []LedgerTotal
+	for rows.Next() {
+		var t LedgerTotal
+		if err := rows.Scan(&t.UserID, &t.Balance, &t.Ledger); err != nil {
+			return nil, fmt.Errorf("could not read ledger total: %w", err)
+		}
+		totals = append(totals, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("database error adding up ledgers: %w", err)
+	}
+	return totals, nil
+}
+
+// SnapBalanceToLedger implements the interface. The user's row is locked before the ledger is added up, so nothing
+// can debit or credit them in between. Held tokens stay held, and the spendable balance is whatever's left.
+// The granted bucket is cut down to fit if it has to be. A ledger that leaves less than the user's lots hold
+// can't be right either, so that's an error and nothing changes.
+func (pr *postgresRepository) SnapBalanceToLedger(ctx context.Context, userID uuid.UUID) (*LedgerTotal, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	var total *LedgerTotal
+	err := pr.WithTx(ctx, func(tx *sql.Tx) error {
+		var balance, held int
+		err := tx.QueryRowContext(ctx, `
+			SELECT assistance_token_balance, held_token_balance FROM users WHERE user_id = $1 FOR UPDATE
+		`, userID).Scan(&balance, &held)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrNotFound
+			}
+			return fmt.Errorf("database error locking user: %w", err)
+		}
+
+		var ledger, inLots int
+		err = tx.QueryRowContext(ctx, `
+			SELECT COALESCE((SELECT SUM(`+ledgerDeltaSQL+`) FROM token_ledger WHERE user_id = $1), 0)::int,
+			       COALESCE((SELECT SUM(remaining) FROM token_lots WHERE user_id = $1 AND remaining > 0), 0)::int
+		`, userID).Scan(&ledger, &inLots)
+		if err != nil {
+			return fmt.Errorf("database error adding up ledger: %w", err)
+		}
+		total = &LedgerTotal{UserID: userID, Balance: balance + held, Ledger: ledger}
+		if total.Balance == total.Ledger {
+			return nil
+		}
+
+		spendable := ledger - held
+		if spendable < inLots {
+			return fmt.Errorf("ledger leaves user %s %d tokens to spend, fewer than the %d in their lots", userID, spendable, inLots)
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE users
+			SET assistance_token_balance = $2,
+			    granted_token_balance = LEAST(granted_token_balance, $2)
+			WHERE user_id = $1
+		`, userID, spendable)
+		if err != nil {
+			return fmt.Errorf("database error snapping balance: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return total, nil
+}
+
+// ForfeitBalance implements the interface. Expired lots go first, as for a debit, so the forfeit entry only has
+// what could still have been spent. Held tokens stay held: a request in flight can still commit them, and if one is
+// released instead, forfeiting again takes it.
+func (pr *postgresRepository) ForfeitBalance(ctx context.Context, userID uuid.UUID, referenceID string) (*LedgerEntry, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	var entry *LedgerEntry
+	err := pr.WithTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now()
+		spender, err := lockSpender(ctx, tx, userID, now)
+		if err == ErrInsufficientFunds {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if spender.balance <= 0 {
+			return nil
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE users SET assistance_token_balance = 0, granted_token_balance = 0 WHERE user_id = $1
+		`, userID)
+		if err != nil {
+			return fmt.Errorf("database error forfeiting balance: %w", err)
+		}
+		entry, err = scanLedgerEntry(tx.QueryRowContext(ctx, `
+			INSERT INTO token_ledger (entry_id, user_id, kind, amount, granted_amount, reference_id, balance_after)
+			VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), 0)
+			RETURNING `+ledgerColumns,
+			uuid.New(), userID, ledgerKindForfeit, spender.balance, min(spender.granted, spender.balance), referenceID))
+		if err != nil {
+			return fmt.Errorf("database error recording forfeit: %w", err)
+		}
+
+		// The whole balance is gone, so this empties every lot that hasn't expired.
+		return drawLots(ctx, tx, userID, spender.balance, now, uuid.NullUUID{UUID: entry.EntryID, Valid: true}, uuid.NullUUID{})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
 }
@@ -12,6 +12,7 @@ package billing
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	uuid "github.com/google/uuid"
 	gomock "go.uber.org/mock/gomock"
@@ -41,19 +42,94 @@ func (m *MockRepository) EXPECT() *MockRepositoryMockRecorder {
 	return m.recorder
 }
 
+// CommitHold mocks base method.
+func (m *MockRepository) CommitHold(ctx context.Context, userID, holdID uuid.UUID) (*LedgerEntry, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CommitHold", ctx, userID, holdID)
+	ret0, _ := ret[0].(*LedgerEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CommitHold indicates an expected call of CommitHold.
+func (mr *MockRepositoryMockRecorder) CommitHold(ctx, userID, holdID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CommitHold", reflect.TypeOf((*MockRepository)(nil).CommitHold), ctx, userID, holdID)
+}
+
+// CreditBatch mocks base method.
+func (m *MockRepository) CreditBatch(ctx context.Context, referenceID string, items []BatchCreditItem) ([]BatchCreditResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreditBatch", ctx, referenceID, items)
+	ret0, _ := ret[0].([]BatchCreditResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreditBatch indicates an expected call of CreditBatch.
+func (mr *MockRepositoryMockRecorder) CreditBatch(ctx, referenceID, items any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreditBatch", reflect.TypeOf((*MockRepository)(nil).CreditBatch), ctx, referenceID, items)
+}
+
+// CreditLot mocks base method.
+func (m *MockRepository) CreditLot(ctx context.Context, userID uuid.UUID, amount int, source string, expiresAt time.Time, referenceID string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreditLot", ctx, userID, amount, source, expiresAt, referenceID)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreditLot indicates an expected call of CreditLot.
+func (mr *MockRepositoryMockRecorder) CreditLot(ctx, userID, amount, source, expiresAt, referenceID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreditLot", reflect.TypeOf((*MockRepository)(nil).CreditLot), ctx, userID, amount, source, expiresAt, referenceID)
+}
+
 // CreditToken mocks base method.
-func (m *MockRepository) CreditToken(ctx context.Context, userID uuid.UUID, amount int) (int, error) {
+func (m *MockRepository) CreditToken(ctx context.Context, userID uuid.UUID, amount int, source string) (int, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreditToken", ctx, userID, amount)
+	ret := m.ctrl.Call(m, "CreditToken", ctx, userID, amount, source)
 	ret0, _ := ret[0].(int)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // CreditToken indicates an expected call of CreditToken.
-func (mr *MockRepositoryMockRecorder) CreditToken(ctx, userID, amount any) *gomock.Call {
+func (mr *MockRepositoryMockRecorder) CreditToken(ctx, userID, amount, source any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreditToken", reflect.TypeOf((*MockRepository)(nil).CreditToken), ctx, userID, amount)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreditToken", reflect.TypeOf((*MockRepository)(nil).CreditToken), ctx, userID, amount, source)
+}
+
+// CreditTokenOnce mocks base method.
+func (m *MockRepository) CreditTokenOnce(ctx context.Context, userID uuid.UUID, amount int, source, referenceID string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreditTokenOnce", ctx, userID, amount, source, referenceID)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreditTokenOnce indicates an expected call of CreditTokenOnce.
+func (mr *MockRepositoryMockRecorder) CreditTokenOnce(ctx, userID, amount, source, referenceID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreditTokenOnce", reflect.TypeOf((*MockRepository)(nil).CreditTokenOnce), ctx, userID, amount, source, referenceID)
+}
+
+// DebitCost mocks base method.
+func (m *MockRepository) DebitCost(ctx context.Context, userID uuid.UUID, amount int, costType, referenceID string) (*LedgerEntry, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DebitCost", ctx, userID, amount, costType, referenceID)
+	ret0, _ := ret[0].(*LedgerEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DebitCost indicates an expected call of DebitCost.
+func (mr *MockRepositoryMockRecorder) DebitCost(ctx, userID, amount, costType, referenceID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DebitCost", reflect.TypeOf((*MockRepository)(nil).DebitCost), ctx, userID, amount, costType, referenceID)
 }
 
 // DebitToken mocks base method.
@@ -70,3 +146,198 @@ func (mr *MockRepositoryMockRecorder) DebitToken(ctx, userID any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DebitToken", reflect.TypeOf((*MockRepository)(nil).DebitToken), ctx, userID)
 }
+
+// DebitTokens mocks base method.
+func (m *MockRepository) DebitTokens(ctx context.Context, userID uuid.UUID, amount int, referenceID string) (*LedgerEntry, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DebitTokens", ctx, userID, amount, referenceID)
+	ret0, _ := ret[0].(*LedgerEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DebitTokens indicates an expected call of DebitTokens.
+func (mr *MockRepositoryMockRecorder) DebitTokens(ctx, userID, amount, referenceID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DebitTokens", reflect.TypeOf((*MockRepository)(nil).DebitTokens), ctx, userID, amount, referenceID)
+}
+
+// ExpireLots mocks base method.
+func (m *MockRepository) ExpireLots(ctx context.Context, now time.Time, limit int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExpireLots", ctx, now, limit)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExpireLots indicates an expected call of ExpireLots.
+func (mr *MockRepositoryMockRecorder) ExpireLots(ctx, now, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExpireLots", reflect.TypeOf((*MockRepository)(nil).ExpireLots), ctx, now, limit)
+}
+
+// ForfeitBalance mocks base method.
+func (m *MockRepository) ForfeitBalance(ctx context.Context, userID uuid.UUID, referenceID string) (*LedgerEntry, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ForfeitBalance", ctx, userID, referenceID)
+	ret0, _ := ret[0].(*LedgerEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ForfeitBalance indicates an expected call of ForfeitBalance.
+func (mr *MockRepositoryMockRecorder) ForfeitBalance(ctx, userID, referenceID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ForfeitBalance", reflect.TypeOf((*MockRepository)(nil).ForfeitBalance), ctx, userID, referenceID)
+}
+
+// GetBalance mocks base method.
+func (m *MockRepository) GetBalance(ctx context.Context, userID uuid.UUID) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetBalance", ctx, userID)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetBalance indicates an expected call of GetBalance.
+func (mr *MockRepositoryMockRecorder) GetBalance(ctx, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBalance", reflect.TypeOf((*MockRepository)(nil).GetBalance), ctx, userID)
+}
+
+// GetBalanceBreakdown mocks base method.
+func (m *MockRepository) GetBalanceBreakdown(ctx context.Context, userID uuid.UUID) (*Balance, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetBalanceBreakdown", ctx, userID)
+	ret0, _ := ret[0].(*Balance)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetBalanceBreakdown indicates an expected call of GetBalanceBreakdown.
+func (mr *MockRepositoryMockRecorder) GetBalanceBreakdown(ctx, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBalanceBreakdown", reflect.TypeOf((*MockRepository)(nil).GetBalanceBreakdown), ctx, userID)
+}
+
+// GrantTierTokens mocks base method.
+func (m *MockRepository) GrantTierTokens(ctx context.Context, tier string, amount int, cycle string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GrantTierTokens", ctx, tier, amount, cycle)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GrantTierTokens indicates an expected call of GrantTierTokens.
+func (mr *MockRepositoryMockRecorder) GrantTierTokens(ctx, tier, amount, cycle any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GrantTierTokens", reflect.TypeOf((*MockRepository)(nil).GrantTierTokens), ctx, tier, amount, cycle)
+}
+
+// HoldTokens mocks base method.
+func (m *MockRepository) HoldTokens(ctx context.Context, userID uuid.UUID, amount int, referenceID string) (*TokenHold, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HoldTokens", ctx, userID, amount, referenceID)
+	ret0, _ := ret[0].(*TokenHold)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// HoldTokens indicates an expected call of HoldTokens.
+func (mr *MockRepositoryMockRecorder) HoldTokens(ctx, userID, amount, referenceID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HoldTokens", reflect.TypeOf((*MockRepository)(nil).HoldTokens), ctx, userID, amount, referenceID)
+}
+
+// LedgerTotals mocks base method.
+func (m *MockRepository) LedgerTotals(ctx context.Context, after uuid.UUID, limit int) ([]LedgerTotal, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LedgerTotals", ctx, after, limit)
+	ret0, _ := ret[0].([]LedgerTotal)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LedgerTotals indicates an expected call of LedgerTotals.
+func (mr *MockRepositoryMockRecorder) LedgerTotals(ctx, after, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LedgerTotals", reflect.TypeOf((*MockRepository)(nil).LedgerTotals), ctx, after, limit)
+}
+
+// ListLedger mocks base method.
+func (m *MockRepository) ListLedger(ctx context.Context, userID uuid.UUID, after *LedgerCursor, limit int) ([]*LedgerEntry, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListLedger", ctx, userID, after, limit)
+	ret0, _ := ret[0].([]*LedgerEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListLedger indicates an expected call of ListLedger.
+func (mr *MockRepositoryMockRecorder) ListLedger(ctx, userID, after, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListLedger", reflect.TypeOf((*MockRepository)(nil).ListLedger), ctx, userID, after, limit)
+}
+
+// RefundDebit mocks base method.
+func (m *MockRepository) RefundDebit(ctx context.Context, userID, entryID uuid.UUID, referenceID string) (*LedgerEntry, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RefundDebit", ctx, userID, entryID, referenceID)
+	ret0, _ := ret[0].(*LedgerEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RefundDebit indicates an expected call of RefundDebit.
+func (mr *MockRepositoryMockRecorder) RefundDebit(ctx, userID, entryID, referenceID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RefundDebit", reflect.TypeOf((*MockRepository)(nil).RefundDebit), ctx, userID, entryID, referenceID)
+}
+
+// ReleaseExpiredHolds mocks base method.
+func (m *MockRepository) ReleaseExpiredHolds(ctx context.Context, createdBefore time.Time, limit int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReleaseExpiredHolds", ctx, createdBefore, limit)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReleaseExpiredHolds indicates an expected call of ReleaseExpiredHolds.
+func (mr *MockRepositoryMockRecorder) ReleaseExpiredHolds(ctx, createdBefore, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReleaseExpiredHolds", reflect.TypeOf((*MockRepository)(nil).ReleaseExpiredHolds), ctx, createdBefore, limit)
+}
+
+// ReleaseHold mocks base method.
+func (m *MockRepository) ReleaseHold(ctx context.Context, userID, holdID uuid.UUID) (*TokenHold, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReleaseHold", ctx, userID, holdID)
+	ret0, _ := ret[0].(*TokenHold)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReleaseHold indicates an expected call of ReleaseHold.
+func (mr *MockRepositoryMockRecorder) ReleaseHold(ctx, userID, holdID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReleaseHold", reflect.TypeOf((*MockRepository)(nil).ReleaseHold), ctx, userID, holdID)
+}
+
+// SnapBalanceToLedger mocks base method.
+func (m *MockRepository) SnapBalanceToLedger(ctx context.Context, userID uuid.UUID) (*LedgerTotal, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SnapBalanceToLedger", ctx, userID)
+	ret0, _ := ret[0].(*LedgerTotal)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SnapBalanceToLedger indicates an expected call of SnapBalanceToLedger.
+func (mr *MockRepositoryMockRecorder) SnapBalanceToLedger(ctx, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SnapBalanceToLedger", reflect.TypeOf((*MockRepository)(nil).SnapBalanceToLedger), ctx, userID)
+}
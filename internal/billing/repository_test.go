@@ -3,11 +3,20 @@ package billing
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"project-sage/internal/domain" // Shared domain models
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 )
 
@@ -81,13 +90,20 @@ func setupTestUser() error {
 // cleanTables cleans up only the user this test created.
 func cleanTables() {
 	testDB.Exec("DELETE FROM users WHERE firebase_auth_id = 'fb-billing-test-user'")
+	testDB.Exec("DELETE FROM users WHERE firebase_auth_id LIKE 'fb-billing-batch-%'")
 }
 
-// resetUserTokens is a helper to reset the user's token balance before a test.
+// resetUserTokens is a helper to reset the user's token balance before a test. The tokens are all purchased.
 func resetUserTokens(balance int) error {
-	testUser.AssistanceTokenBalance = balance
-	_, err := testDB.Exec("UPDATE users SET assistance_token_balance = $1 WHERE user_id = $2",
-		balance, testUser.UserID)
+	return resetUserBuckets(balance, 0)
+}
+
+// resetUserBuckets resets the user's balance to purchased plus granted tokens.
+func resetUserBuckets(purchased, granted int) error {
+	testUser.AssistanceTokenBalance = purchased + granted
+	testUser.GrantedTokenBalance = granted
+	_, err := testDB.Exec("UPDATE users SET assistance_token_balance = $1, granted_token_balance = $2 WHERE user_id = $3",
+		purchased+granted, granted, testUser.UserID)
 	return err
 }
 
@@ -133,6 +149,51 @@ func TestDebitToken_Success(t *testing.T) {
 	})
 }
 
+// TestDebitToken_WritesLedger checks every debit leaves its own ledger row with the balance it left, and a credit's
+// row says where the credit came from.
+func TestDebitToken_WritesLedger(t *testing.T) {
+	if err := resetUserTokens(3); err != nil {
+		t.Fatalf("Failed to reset user tokens: %v", err)
+	}
+	ctx := context.Background()
+	var since time.Time
+	if err := testDB.QueryRow("SELECT now()").Scan(&since); err != nil {
+		t.Fatalf("Could not read the time: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := testRepo.DebitToken(ctx, testUser.UserID); err != nil {
+			t.Fatalf("DebitToken() returned unexpected error: %v", err)
+		}
+	}
+	if _, err := testRepo.CreditToken(ctx, testUser.UserID, 4, SourceGrant); err != nil {
+		t.Fatalf("CreditToken() returned unexpected error: %v", err)
+	}
+
+	rows, err := testDB.Query(`
+		SELECT kind, amount, balance_after, COALESCE(source, '') FROM token_ledger
+		WHERE user_id = $1 AND created_at >= $2
+		ORDER BY created_at, balance_after DESC
+	`, testUser.UserID, since)
+	if err != nil {
+		t.Fatalf("Could not read the ledger: %v", err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var kind, source string
+		var amount, balance int
+		if err := rows.Scan(&kind, &amount, &balance, &source); err != nil {
+			t.Fatalf("Could not scan ledger row: %v", err)
+		}
+		got = append(got, fmt.Sprintf("%s %d -> %d %s", kind, amount, balance, source))
+	}
+	want := []string{"debit 1 -> 2 ", "debit 1 -> 1 ", "debit 1 -> 0 ", "credit 4 -> 4 grant"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected ledger rows %q, got %q", want, got)
+	}
+}
+
 // TestDebitToken_InsufficientFunds tests that it fails when the balance is already 0.
 func TestDebitToken_InsufficientFunds(t *testing.T) {
 	// Set the balance to 0.
@@ -149,7 +210,7 @@ func TestDebitToken_InsufficientFunds(t *testing.T) {
 	}
 
 	// Check for the specific error our repository is supposed to return.
-	if err.Error() != "insufficient funds or user not found" {
+	if !errors.Is(err, ErrInsufficientFunds) {
 		t.Fatalf("Expected 'insufficient funds or user not found', got '%v'", err)
 	}
 }
@@ -167,7 +228,1381 @@ func TestDebitToken_UserNotFound(t *testing.T) {
 	}
 
 	// It should return the same error as insufficient funds.
-	if err.Error() != "insufficient funds or user not found" {
+	if !errors.Is(err, ErrInsufficientFunds) {
 		t.Fatalf("Expected 'insufficient funds or user not found', got '%v'", err)
 	}
 }
+
+// TestDebitToken_Concurrent debits one token from 20 goroutines at once against a balance of 5.
+// Exactly 5 may win and the balance must end at 0, never below. Some of the tokens are granted, so the
+// split between the buckets is checked under contention too.
+func TestDebitToken_Concurrent(t *testing.T) {
+	if err := resetUserBuckets(3, 2); err != nil {
+		t.Fatalf("Failed to reset user tokens: %v", err)
+	}
+	// The ledger already has earlier tests' debits in it, so only count from here.
+	var since time.Time
+	if err := testDB.QueryRow("SELECT clock_timestamp()").Scan(&since); err != nil {
+		t.Fatalf("Could not read the database clock: %v", err)
+	}
+
+	const attempts = 20
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	debited, refused := 0, 0
+	start := make(chan struct{})
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start // Line them all up, so they really do hit the row together.
+			_, err := testRepo.DebitToken(context.Background(), testUser.UserID)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				debited++
+			case errors.Is(err, ErrInsufficientFunds):
+				refused++
+			default:
+				t.Errorf("DebitToken() returned unexpected error: %v", err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if debited != 5 || refused != attempts-5 {
+		t.Errorf("Expected 5 debits and %d refusals, got %d and %d", attempts-5, debited, refused)
+	}
+	var balance, granted int
+	err := testDB.QueryRow("SELECT assistance_token_balance, granted_token_balance FROM users WHERE user_id = $1",
+		testUser.UserID).Scan(&balance, &granted)
+	if err != nil {
+		t.Fatalf("Could not read the balance back: %v", err)
+	}
+	if balance != 0 || granted != 0 {
+		t.Errorf("Expected balance 0 with nothing granted left, got %d with %d granted", balance, granted)
+	}
+
+	// Every winner has its ledger row, and between them they took both granted tokens.
+	var entries, grantedSpent int
+	err = testDB.QueryRow(`
+		SELECT count(*), COALESCE(SUM(granted_amount), 0)
+		FROM token_ledger
+		WHERE user_id = $1 AND kind = 'debit' AND created_at >= $2
+	`, testUser.UserID, since).Scan(&entries, &grantedSpent)
+	if err != nil {
+		t.Fatalf("Could not read the ledger: %v", err)
+	}
+	if entries != 5 || grantedSpent != 2 {
+		t.Errorf("Expected 5 debit entries taking 2 granted tokens, got %d taking %d", entries, grantedSpent)
+	}
+}
+
+// TestCreditBatch credits three users and an unknown one, then sends the same batch again
+// and checks nobody is credited twice.
+func TestCreditBatch(t *testing.T) {
+	ctx := context.Background()
+
+	// Three users with different starting balances.
+	startBalances := []int{0, 2, 5}
+	items := make([]BatchCreditItem, 0, len(startBalances)+1)
+	for i, balance := range startBalances {
+		id := uuid.New()
+		_, err := testDB.Exec(`
+			INSERT INTO users (user_id, firebase_auth_id, display_name, membership_tier, assistance_token_balance)
+			VALUES ($1, $2, $3, 'free', $4)
+		`, id, fmt.Sprintf("fb-billing-batch-%d", i), "Batch Test User", balance)
+		if err != nil {
+			t.Fatalf("Failed to insert batch test user: %v", err)
+		}
+		items = append(items, BatchCreditItem{UserID: id, Amount: i + 1})
+	}
+	// Nobody has this one.
+	items = append(items, BatchCreditItem{UserID: uuid.New(), Amount: 4})
+	defer testDB.Exec("DELETE FROM users WHERE firebase_auth_id LIKE 'fb-billing-batch-%'")
+
+	reference := "campaign-" + uuid.NewString()
+	results, err := testRepo.CreditBatch(ctx, reference, items)
+	if err != nil {
+		t.Fatalf("CreditBatch() returned an unexpected error: %v", err)
+	}
+	if len(results) != len(items) {
+		t.Fatalf("Expected %d results, got %d", len(items), len(results))
+	}
+	for i, balance := range startBalances {
+		want := BatchCreditResult{UserID: items[i].UserID, Status: BatchCredited, NewBalance: balance + i + 1}
+		if results[i] != want {
+			t.Errorf("Item %d: expected %+v, got %+v", i, want, results[i])
+		}
+	}
+	if results[3].UserID != items[3].UserID || results[3].Status != BatchUserNotFound {
+		t.Errorf("Expected the unknown user to be user_not_found, got %+v", results[3])
+	}
+
+	// The same batch again credits nobody and reports the balances the first one left.
+	again, err := testRepo.CreditBatch(ctx, reference, items)
+	if err != nil {
+		t.Fatalf("Second CreditBatch() returned an unexpected error: %v", err)
+	}
+	for i, balance := range startBalances {
+		want := BatchCreditResult{UserID: items[i].UserID, Status: BatchAlreadyCredited, NewBalance: balance + i + 1}
+		if again[i] != want {
+			t.Errorf("Item %d on the rerun: expected %+v, got %+v", i, want, again[i])
+		}
+	}
+
+	// Balances only moved once.
+	for i, item := range items[:len(startBalances)] {
+		var balance int
+		if err := testDB.QueryRow("SELECT assistance_token_balance FROM users WHERE user_id = $1", item.UserID).Scan(&balance); err != nil {
+			t.Fatalf("Failed to read balance back: %v", err)
+		}
+		if balance != startBalances[i]+i+1 {
+			t.Errorf("User %d: expected balance %d, got %d", i, startBalances[i]+i+1, balance)
+		}
+	}
+}
+
+// TestBalanceCap credits exactly up to the maximum balance and then one over, through every kind of credit the cap covers.
+func TestBalanceCap(t *testing.T) {
+	if err := resetUserTokens(3); err != nil {
+		t.Fatalf("Failed to reset user tokens: %v", err)
+	}
+	clearLots(t)
+	ctx := context.Background()
+	capped := NewPostgresRepositoryWithOptions(testDB, RepositoryOptions{MaxBalance: 10})
+
+	// 3 + 7 is exactly the cap, which is allowed.
+	reference := "test:cap-" + uuid.NewString()
+	if balance, err := capped.CreditTokenOnce(ctx, testUser.UserID, 7, SourcePurchase, reference); err != nil || balance != 10 {
+		t.Fatalf("Expected crediting to the cap to leave 10, got %d, %v", balance, err)
+	}
+
+	// One over is turned away whole, by every credit.
+	if _, err := capped.CreditToken(ctx, testUser.UserID, 1, SourcePurchase); !errors.Is(err, ErrBalanceCapExceeded) {
+		t.Errorf("CreditToken: expected ErrBalanceCapExceeded, got %v", err)
+	}
+	if _, err := capped.CreditTokenOnce(ctx, testUser.UserID, 1, SourcePurchase, "test:cap-"+uuid.NewString()); !errors.Is(err, ErrBalanceCapExceeded) {
+		t.Errorf("CreditTokenOnce: expected ErrBalanceCapExceeded, got %v", err)
+	}
+	if _, err := capped.CreditLot(ctx, testUser.UserID, 1, "promo", time.Now().Add(time.Hour), ""); !errors.Is(err, ErrBalanceCapExceeded) {
+		t.Errorf("CreditLot: expected ErrBalanceCapExceeded, got %v", err)
+	}
+	results, err := capped.CreditBatch(ctx, "campaign-"+uuid.NewString(), []BatchCreditItem{{UserID: testUser.UserID, Amount: 1}})
+	if err != nil {
+		t.Fatalf("CreditBatch() returned an unexpected error: %v", err)
+	}
+	if results[0].Status != BatchCapExceeded {
+		t.Errorf("CreditBatch: expected %s, got %+v", BatchCapExceeded, results[0])
+	}
+	if balance := rawBalance(t); balance != 10 {
+		t.Errorf("Expected the balance to stay at the cap of 10, got %d", balance)
+	}
+
+	// A retry of the credit that reached the cap still gets its answer rather than an error.
+	if balance, err := capped.CreditTokenOnce(ctx, testUser.UserID, 7, SourcePurchase, reference); err != nil || balance != 10 {
+		t.Errorf("Expected the retry to answer 10, got %d, %v", balance, err)
+	}
+	// Unknown users are still ErrNotFound, not over the cap.
+	if _, err := capped.CreditToken(ctx, uuid.New(), 1, SourcePurchase); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an unknown user, got %v", err)
+	}
+	// Without a cap the same credit goes through.
+	if balance, err := testRepo.CreditToken(ctx, testUser.UserID, 1, SourcePurchase); err != nil || balance != 11 {
+		t.Errorf("Expected the uncapped repository to credit to 11, got %d, %v", balance, err)
+	}
+}
+
+// TestGetBalance reads the balance back after a reset, and checks unknown users get ErrNotFound.
+func TestGetBalance(t *testing.T) {
+	if err := resetUserTokens(5); err != nil {
+		t.Fatalf("Failed to reset user tokens: %v", err)
+	}
+	ctx := context.Background()
+
+	balance, err := testRepo.GetBalance(ctx, testUser.UserID)
+	if err != nil {
+		t.Fatalf("GetBalance() returned error: %v", err)
+	}
+	if balance != 5 {
+		t.Errorf("Expected balance 5, got %d", balance)
+	}
+
+	// A debit shows up straight away.
+	if _, err := testRepo.DebitToken(ctx, testUser.UserID); err != nil {
+		t.Fatalf("DebitToken() returned error: %v", err)
+	}
+	if balance, _ := testRepo.GetBalance(ctx, testUser.UserID); balance != 4 {
+		t.Errorf("Expected balance 4 after a debit, got %d", balance)
+	}
+
+	if _, err := testRepo.GetBalance(ctx, uuid.New()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an unknown user, got %v", err)
+	}
+}
+
+// TestDebitTokens checks a multi-token debit is all or nothing.
+func TestDebitTokens(t *testing.T) {
+	if err := resetUserTokens(3); err != nil {
+		t.Fatalf("Failed to reset user tokens: %v", err)
+	}
+	ctx := context.Background()
+
+	entry, err := testRepo.DebitTokens(ctx, testUser.UserID, 2, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if entry.BalanceAfter != 1 {
+		t.Fatalf("Expected new balance of 1, got %d", entry.BalanceAfter)
+	}
+
+	// 2 more than the 1 left must fail without touching the balance.
+	if _, err := testRepo.DebitTokens(ctx, testUser.UserID, 2, ""); !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("Expected ErrInsufficientFunds, got %v", err)
+	}
+	if balance, _ := testRepo.GetBalance(ctx, testUser.UserID); balance != 1 {
+		t.Errorf("Expected the balance to stay at 1, got %d", balance)
+	}
+}
+
+// TestDebitCost checks the cost type lands in the ledger, and a free one is recorded even on an empty balance.
+func TestDebitCost(t *testing.T) {
+	if err := resetUserTokens(2); err != nil {
+		t.Fatalf("Failed to reset user tokens: %v", err)
+	}
+	ctx := context.Background()
+
+	if _, err := testRepo.DebitCost(ctx, testUser.UserID, 2, CostPriority, ""); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	entry, err := testRepo.DebitCost(ctx, testUser.UserID, 0, CostFollowUp, "")
+	if err != nil {
+		t.Fatalf("Expected a free debit on an empty balance to work, got %v", err)
+	}
+	if entry.Amount != 0 || entry.BalanceAfter != 0 || entry.CostType != CostFollowUp {
+		t.Errorf("Unexpected free entry %+v", entry)
+	}
+
+	entries, err := testRepo.ListLedger(ctx, testUser.UserID, nil, 2)
+	if err != nil {
+		t.Fatalf("ListLedger() returned error: %v", err)
+	}
+	if len(entries) != 2 || entries[0].CostType != CostFollowUp || entries[1].CostType != CostPriority {
+		t.Errorf("Expected the follow_up then priority debits, got %+v", entries)
+	}
+}
+
+// TestCreditTokenOnce posts the same referenced credit twice through the real handler and checks it only counts once.
+func TestCreditTokenOnce(t *testing.T) {
+	if err := resetUserTokens(3); err != nil {
+		t.Fatalf("Failed to reset user tokens: %v", err)
+	}
+	r := chi.NewRouter()
+	NewHandler(NewService(testRepo), nil).RegisterRoutes(r)
+
+	body := `{"user_id":"` + testUser.UserID.String() + `","amount":5,"reference_id":"test:` + uuid.NewString() + `"}`
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("POST", "/token/add", strings.NewReader(body)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Credit %d: expected status 200, got %d", i+1, rr.Code)
+		}
+		var resp creditResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("Credit %d: could not decode response: %v", i+1, err)
+		}
+		// Both answers are the balance after the first credit.
+		if resp.NewBalance != 8 {
+			t.Errorf("Credit %d: expected new_balance 8, got %d", i+1, resp.NewBalance)
+		}
+	}
+
+	if balance, _ := testRepo.GetBalance(context.Background(), testUser.UserID); balance != 8 {
+		t.Errorf("Expected the balance to move once to 8, got %d", balance)
+	}
+
+	// Unknown users are still ErrNotFound, not a ledger error.
+	if _, err := testRepo.CreditTokenOnce(context.Background(), uuid.New(), 5, SourcePurchase, "test:unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an unknown user, got %v", err)
+	}
+}
+
+// failInsertsInto makes any insert into table with a reference starting test:fail raise an error until the test
+// ends, so a transaction can be made to fail after it has already changed the balance.
+func failInsertsInto(t *testing.T, table string) {
+	t.Helper()
+	_, err := testDB.Exec(`
+		CREATE OR REPLACE FUNCTION billing_test_fail_insert() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'forced failure for %', NEW.reference_id;
+		END
+		$$ LANGUAGE plpgsql`)
+	if err != nil {
+		t.Fatalf("Could not create the failing trigger function: %v", err)
+	}
+	_, err = testDB.Exec(`
+		CREATE TRIGGER billing_test_fail_insert BEFORE INSERT ON ` + table + `
+		FOR EACH ROW WHEN (NEW.reference_id LIKE 'test:fail%') EXECUTE FUNCTION billing_test_fail_insert()`)
+	if err != nil {
+		t.Fatalf("Could not create the failing trigger on %s: %v", table, err)
+	}
+	t.Cleanup(func() {
+		testDB.Exec("DROP TRIGGER IF EXISTS billing_test_fail_insert ON " + table)
+	})
+}
+
+// TestWithTx checks a transaction is committed when fn succeeds, and rolled back when it returns an error or panics.
+func TestWithTx(t *testing.T) {
+	if err := resetUserTokens(3); err != nil {
+		t.Fatalf("Failed to reset user tokens: %v", err)
+	}
+	ctx := context.Background()
+	pr := testRepo.(*postgresRepository)
+	addFive := func(tx *sql.Tx) {
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET assistance_token_balance = assistance_token_balance + 5 WHERE user_id = $1", testUser.UserID); err != nil {
+			t.Fatalf("Could not update balance: %v", err)
+		}
+	}
+
+	if err := pr.WithTx(ctx, func(tx *sql.Tx) error { addFive(tx); return nil }); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if balance := rawBalance(t); balance != 8 {
+		t.Fatalf("Expected the commit to leave 8, got %d", balance)
+	}
+
+	// An error halfway through undoes the update and comes back untouched.
+	err := pr.WithTx(ctx, func(tx *sql.Tx) error {
+		addFive(tx)
+		return ErrInsufficientFunds
+	})
+	if err != ErrInsufficientFunds {
+		t.Errorf("Expected fn's error back as is, got %v", err)
+	}
+	if balance := rawBalance(t); balance != 8 {
+		t.Errorf("Expected the error to roll back to 8, got %d", balance)
+	}
+
+	// So does a panic, which still reaches the caller.
+	func() {
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Errorf("Expected the panic to carry on, got %v", p)
+			}
+		}()
+		pr.WithTx(ctx, func(tx *sql.Tx) error {
+			addFive(tx)
+			panic("boom")
+		})
+	}()
+	if balance := rawBalance(t); balance != 8 {
+		t.Errorf("Expected the panic to roll back to 8, got %d", balance)
+	}
+
+	// The row lock went with the rollback, so a debit doesn't wait on it.
+	debitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if _, err := testRepo.DebitToken(debitCtx, testUser.UserID); err != nil {
+		t.Errorf("Expected the debit to go through after the rollbacks, got %v", err)
+	}
+}
+
+// TestDebitTokens_FailsMidTransaction fails the ledger insert after the balance has gone down, and checks the debit
+// is undone as a whole, lots and all.
+func TestDebitTokens_FailsMidTransaction(t *testing.T) {
+	// 1 purchased, plus 2 granted in a lot.
+	if err := resetUserTokens(1); err != nil {
+		t.Fatalf("Failed to reset user tokens: %v", err)
+	}
+	clearLots(t)
+	lotID := addLot(t, 2, time.Now().Add(time.Hour))
+	failInsertsInto(t, "token_ledger")
+	ctx := context.Background()
+
+	if _, err := testRepo.DebitTokens(ctx, testUser.UserID, 2, "test:fail-"+uuid.NewString()); err == nil {
+		t.Fatal("Expected the forced failure, got nil")
+	}
+
+	if balance := rawBalance(t); balance != 3 {
+		t.Errorf("Expected the balance to stay at 3, got %d", balance)
+	}
+	if remaining := lotRemaining(t, lotID); remaining != 2 {
+		t.Errorf("Expected the lot to keep 2, got %d", remaining)
+	}
+	var granted int
+	testDB.QueryRow("SELECT granted_token_balance FROM users WHERE user_id = $1", testUser.UserID).Scan(&granted)
+	if granted != 2 {
+		t.Errorf("Expected granted to stay at 2, got %d", granted)
+	}
+}
+
+// TestCreditTokenOnce_FailsMidTransaction fails the credit record after the balance has gone up, and checks the
+// credit is undone so a retry can still go through.
+func TestCreditTokenOnce_FailsMidTransaction(t *testing.T) {
+	if err := resetUserTokens(3); err != nil {
+		t.Fatalf("Failed to reset user tokens: %v", err)
+	}
+	failInsertsInto(t, "token_credits")
+	ctx := context.Background()
+
+	if _, err := testRepo.CreditTokenOnce(ctx, testUser.UserID, 5, SourcePurchase, "test:fail-"+uuid.NewString()); err == nil {
+		t.Fatal("Expected the forced failure, got nil")
+	}
+	if balance := rawBalance(t); balance != 3 {
+		t.Errorf("Expected the balance to stay at 3, got %d", balance)
+	}
+
+	// A plain credit has nothing to fail halfway, but goes through WithTx all the same.
+	if balance, err := testRepo.CreditToken(ctx, testUser.UserID, 5, SourcePurchase); err != nil || balance != 8 {
+		t.Errorf("Expected the credit to leave 8, got %d, %v", balance, err)
+	}
+}
+
+// TestRefundDebit refunds a debit by entry id and by reference, and checks neither can be refunded twice.
+func TestRefundDebit(t *testing.T) {
+	if err := resetUserTokens(5); err != nil {
+		t.Fatalf("Failed to reset user tokens: %v", err)
+	}
+	ctx := context.Background()
+
+	byID, err := testRepo.DebitTokens(ctx, testUser.UserID, 2, "")
+	if err != nil {
+		t.Fatalf("DebitTokens() returned unexpected error: %v", err)
+	}
+	ref := "test:" + uuid.NewString()
+	if _, err := testRepo.DebitTokens(ctx, testUser.UserID, 1, ref); err != nil {
+		t.Fatalf("DebitTokens() with a reference returned unexpected error: %v", err)
+	}
+	// A repeated reference is the same debit, not a second one.
+	again, err := testRepo.DebitTokens(ctx, testUser.UserID, 1, ref)
+	if err != nil || again.BalanceAfter != 2 {
+		t.Fatalf("Expected the repeated debit to report balance 2, got %+v, %v", again, err)
+	}
+
+	refund, err := testRepo.RefundDebit(ctx, testUser.UserID, byID.EntryID, "")
+	if err != nil {
+		t.Fatalf("RefundDebit() by id returned unexpected error: %v", err)
+	}
+	if refund.Kind != "refund" || refund.RefundOf.UUID != byID.EntryID || refund.Amount != 2 || refund.BalanceAfter != 4 {
+		t.Errorf("Unexpected refund entry: %+v", refund)
+	}
+	if _, err := testRepo.RefundDebit(ctx, testUser.UserID, uuid.Nil, ref); err != nil {
+		t.Fatalf("RefundDebit() by reference returned unexpected error: %v", err)
+	}
+
+	if _, err := testRepo.RefundDebit(ctx, testUser.UserID, byID.EntryID, ""); !errors.Is(err, ErrAlreadyRefunded) {
+		t.Errorf("Expected ErrAlreadyRefunded, got %v", err)
+	}
+	if _, err := testRepo.RefundDebit(ctx, testUser.UserID, uuid.New(), ""); !errors.Is(err, ErrDebitNotFound) {
+		t.Errorf("Expected ErrDebitNotFound, got %v", err)
+	}
+	// A refund is for the user who was debited, nobody else.
+	if _, err := testRepo.RefundDebit(ctx, uuid.New(), uuid.Nil, ref); !errors.Is(err, ErrDebitNotFound) {
+		t.Errorf("Expected ErrDebitNotFound for another user, got %v", err)
+	}
+	if balance, _ := testRepo.GetBalance(ctx, testUser.UserID); balance != 5 {
+		t.Errorf("Expected the balance back at 5, got %d", balance)
+	}
+}
+
+// TestGrantedTokens_SpentFirst checks debits and holds take granted tokens before purchased ones, and a refund
+// or release puts each back in the bucket it came from.
+func TestGrantedTokens_SpentFirst(t *testing.T) {
+	if err := resetUserBuckets(3, 2); err != nil {
+		t.Fatalf("Failed to reset user tokens: %v", err)
+	}
+	ctx := context.Background()
+	buckets := func(wantPurchased, wantGranted int) {
+		t.Helper()
+		b, err := testRepo.GetBalanceBreakdown(ctx, testUser.UserID)
+		if err != nil {
+			t.Fatalf("GetBalanceBreakdown() returned unexpected error: %v", err)
+		}
+		if b.Purchased != wantPurchased || b.Granted != wantGranted || b.Total != wantPurchased+wantGranted {
+			t.Errorf("Expected %d purchased and %d granted, got %+v", wantPurchased, wantGranted, b)
+		}
+	}
+
+	// 3 tokens: both granted ones and one purchased.
+	debit, err := testRepo.DebitTokens(ctx, testUser.UserID, 3, "")
+	if err != nil {
+		t.Fatalf("DebitTokens() returned unexpected error: %v", err)
+	}
+	if debit.Granted != 2 {
+		t.Errorf("Expected the debit to take 2 granted tokens, got %+v", debit)
+	}
+	buckets(2, 0)
+
+	refund, err := testRepo.RefundDebit(ctx, testUser.UserID, debit.EntryID, "")
+	if err != nil {
+		t.Fatalf("RefundDebit() returned unexpected error: %v", err)
+	}
+	if refund.Granted != 2 {
+		t.Errorf("Expected the refund to give back 2 granted tokens, got %+v", refund)
+	}
+	buckets(3, 2)
+
+	// A hold splits the same way, and committing it carries the split onto the debit.
+	hold, err := testRepo.HoldTokens(ctx, testUser.UserID, 1, "")
+	if err != nil || hold.Granted != 1 {
+		t.Fatalf("Expected a hold of 1 granted token, got %+v, %v", hold, err)
+	}
+	buckets(3, 1)
+	if _, err := testRepo.ReleaseHold(ctx, testUser.UserID, hold.HoldID); err != nil {
+		t.Fatalf("ReleaseHold() returned unexpected error: %v", err)
+	}
+	buckets(3, 2)
+	hold, err = testRepo.HoldTokens(ctx, testUser.UserID, 4, "")
+	if err != nil {
+		t.Fatalf("HoldTokens() returned unexpected error: %v", err)
+	}
+	committed, err := testRepo.CommitHold(ctx, testUser.UserID, hold.HoldID)
+	if err != nil || committed.Amount != 4 || committed.Granted != 2 {
+		t.Fatalf("Expected a committed debit of 4 with 2 granted, got %+v, %v", committed, err)
+	}
+	buckets(1, 0)
+
+	// Only grants go in the granted bucket.
+	if _, err := testRepo.CreditToken(ctx, testUser.UserID, 4, SourcePurchase); err != nil {
+		t.Fatalf("CreditToken() purchase returned unexpected error: %v", err)
+	}
+	if _, err := testRepo.CreditToken(ctx, testUser.UserID, 2, SourceRefund); err != nil {
+		t.Fatalf("CreditToken() refund returned unexpected error: %v", err)
+	}
+	if _, err := testRepo.CreditToken(ctx, testUser.UserID, 3, SourceGrant); err != nil {
+		t.Fatalf("CreditToken() grant returned unexpected error: %v", err)
+	}
+	buckets(7, 3)
+}
+
+// TestListLedger walks the test user's whole ledger two entries at a time and checks it matches the table, newest first.
+func TestListLedger(t *testing.T) {
+	if err := resetUserTokens(5); err != nil {
+		t.Fatalf("Failed to reset user tokens: %v", err)
+	}
+	ctx := context.Background()
+
+	// Make sure there's more than one page, whatever the other tests left behind.
+	for i := 0; i < 3; i++ {
+		if _, err := testRepo.DebitTokens(ctx, testUser.UserID, 1, ""); err != nil {
+			t.Fatalf("DebitTokens() returned unexpected error: %v", err)
+		}
+	}
+	var total int
+	if err := testDB.QueryRow("SELECT count(*) FROM token_ledger WHERE user_id = $1", testUser.UserID).Scan(&total); err != nil {
+		t.Fatalf("Could not count ledger entries: %v", err)
+	}
+
+	var seen []*LedgerEntry
+	var after *LedgerCursor
+	for {
+		entries, err := testRepo.ListLedger(ctx, testUser.UserID, after, 2)
+		if err != nil {
+			t.Fatalf("ListLedger() returned error: %v", err)
+		}
+		if len(entries) > 2 {
+			t.Fatalf("Expected at most 2 entries per page, got %d", len(entries))
+		}
+		if len(entries) == 0 {
+			break
+		}
+		seen = append(seen, entries...)
+		after = cursorFor(entries[len(entries)-1])
+	}
+
+	if len(seen) != total {
+		t.Fatalf("Expected %d entries, got %d", total, len(seen))
+	}
+	if seen[0].Kind != "debit" || seen[0].BalanceAfter != 2 {
+		t.Errorf("Expected the newest entry to be the last debit, got %+v", seen[0])
+	}
+	ids := map[uuid.UUID]bool{}
+	for i, e := range seen {
+		if ids[e.EntryID] {
+			t.Errorf("Entry %s came back twice", e.EntryID)
+		}
+		ids[e.EntryID] = true
+		if i > 0 && e.CreatedAt.After(seen[i-1].CreatedAt) {
+			t.Errorf("Entry %d is newer than the one before it", i)
+		}
+	}
+
+	// Nobody else's entries, and no error for a user with none.
+	if entries, err := testRepo.ListLedger(ctx, uuid.New(), nil, 2); err != nil || len(entries) != 0 {
+		t.Errorf("Expected no entries for an unknown user, got %d, %v", len(entries), err)
+	}
+}
+
+// TestGrantTierTokens runs the same cycle twice and checks the user is credited once, then again for the next month.
+func TestGrantTierTokens(t *testing.T) {
+	if err := resetUserTokens(1); err != nil {
+		t.Fatalf("Failed to reset user tokens: %v", err)
+	}
+	ctx := context.Background()
+
+	// A tier nobody else is on, so the test doesn't hand tokens to other users in the database.
+	tier := "test-tier-" + uuid.NewString()
+	if _, err := testDB.Exec("UPDATE users SET membership_tier = $1 WHERE user_id = $2", tier, testUser.UserID); err != nil {
+		t.Fatalf("Failed to set the test tier: %v", err)
+	}
+	defer testDB.Exec("UPDATE users SET membership_tier = 'free' WHERE user_id = $1", testUser.UserID)
+
+	cycle := "test-" + uuid.NewString()[:8]
+	for i, want := range []int{1, 0} {
+		n, err := testRepo.GrantTierTokens(ctx, tier, 10, cycle)
+		if err != nil {
+			t.Fatalf("GrantTierTokens() run %d returned unexpected error: %v", i+1, err)
+		}
+		if n != want {
+			t.Errorf("Run %d: expected %d users granted, got %d", i+1, want, n)
+		}
+	}
+	if balance, _ := testRepo.GetBalance(ctx, testUser.UserID); balance != 11 {
+		t.Errorf("Expected the cycle to apply once, to 11, got %d", balance)
+	}
+
+	// The next month is a new grant.
+	if n, err := testRepo.GrantTierTokens(ctx, tier, 10, cycle+"-next"); err != nil || n != 1 {
+		t.Fatalf("Expected the next cycle to grant 1 user, got %d, %v", n, err)
+	}
+	if balance, _ := testRepo.GetBalance(ctx, testUser.UserID); balance != 21 {
+		t.Errorf("Expected 21 after the next cycle, got %d", balance)
+	}
+}
+
+// TestGrantTierTokens_SkipsDeletedUsers checks a deleted user still on a paid tier isn't granted anything.
+func TestGrantTierTokens_SkipsDeletedUsers(t *testing.T) {
+	if err := resetUserTokens(1); err != nil {
+		t.Fatalf("Failed to reset user tokens: %v", err)
+	}
+	ctx := context.Background()
+
+	tier := "test-tier-" + uuid.NewString()
+	if _, err := testDB.Exec("UPDATE users SET membership_tier = $1, deleted_at = now() WHERE user_id = $2", tier, testUser.UserID); err != nil {
+		t.Fatalf("Failed to delete the test user: %v", err)
+	}
+	defer testDB.Exec("UPDATE users SET membership_tier = 'free', deleted_at = NULL WHERE user_id = $1", testUser.UserID)
+
+	if n, err := testRepo.GrantTierTokens(ctx, tier, 10, "test-"+uuid.NewString()[:8]); err != nil || n != 0 {
+		t.Fatalf("Expected no users granted, got %d, %v", n, err)
+	}
+	if balance, _ := testRepo.GetBalance(ctx, testUser.UserID); balance != 1 {
+		t.Errorf("Expected the balance to stay at 1, got %d", balance)
+	}
+}
+
+// TestGrantTierTokens_BalanceCap checks the grant skips a user it would take over the cap, and gives them the same
+// cycle later once they have room.
+func TestGrantTierTokens_BalanceCap(t *testing.T) {
+	if err := resetUserTokens(5); err != nil {
+		t.Fatalf("Failed to reset user tokens: %v", err)
+	}
+	ctx := context.Background()
+	capped := NewPostgresRepositoryWithOptions(testDB, RepositoryOptions{MaxBalance: 10})
+
+	tier := "test-tier-" + uuid.NewString()
+	if _, err := testDB.Exec("UPDATE users SET membership_tier = $1 WHERE user_id = $2", tier, testUser.UserID); err != nil {
+		t.Fatalf("Failed to set the test tier: %v", err)
+	}
+	defer testDB.Exec("UPDATE users SET membership_tier = 'free' WHERE user_id = $1", testUser.UserID)
+
+	// 5 + 6 is one over the cap.
+	cycle := "test-" + uuid.NewString()[:8]
+	if n, err := capped.GrantTierTokens(ctx, tier, 6, cycle); err != nil || n != 0 {
+		t.Fatalf("Expected no users granted over the cap, got %d, %v", n, err)
+	}
+	if balance := rawBalance(t); balance != 5 {
+		t.Errorf("Expected the balance to stay at 5, got %d", balance)
+	}
+
+	// After spending one the grant fits exactly, and the same cycle goes through.
+	if _, err := capped.DebitTokens(ctx, testUser.UserID, 1, ""); err != nil {
+		t.Fatalf("DebitTokens() returned unexpected error: %v", err)
+	}
+	if n, err := capped.GrantTierTokens(ctx, tier, 6, cycle); err != nil || n != 1 {
+		t.Fatalf("Expected the user to be granted once there's room, got %d, %v", n, err)
+	}
+	if balance := rawBalance(t); balance != 10 {
+		t.Errorf("Expected the grant to fill the balance to the cap of 10, got %d", balance)
+	}
+}
+
+// heldBalance reads the test user's held_token_balance.
+func heldBalance(t *testing.T) int {
+	t.Helper()
+	var held int
+	if err := testDB.QueryRow("SELECT held_token_balance FROM users WHERE user_id = $1", testUser.UserID).Scan(&held); err != nil {
+		t.Fatalf("Could not read held balance: %v", err)
+	}
+	return held
+}
+
+// TestTokenHolds walks holds through commit and release, and checks both are safe to repeat but not to mix.
+func TestTokenHolds(t *testing.T) {
+	if err := resetUserTokens(3); err != nil {
+		t.Fatalf("Failed to reset user tokens: %v", err)
+	}
+	ctx := context.Background()
+
+	ref := "test:" + uuid.NewString()
+	spent, err := testRepo.HoldTokens(ctx, testUser.UserID, 2, ref)
+	if err != nil {
+		t.Fatalf("HoldTokens() returned unexpected error: %v", err)
+	}
+	if spent.BalanceAfter != 1 || spent.Status != "held" || heldBalance(t) != 2 {
+		t.Errorf("Expected balance 1 with 2 held, got %+v with %d held", spent, heldBalance(t))
+	}
+	// A retry with the same reference is the same hold, even though the balance couldn't cover another.
+	again, err := testRepo.HoldTokens(ctx, testUser.UserID, 2, ref)
+	if err != nil || again.HoldID != spent.HoldID {
+		t.Fatalf("Expected the repeated hold to return %s, got %+v, %v", spent.HoldID, again, err)
+	}
+	if _, err := testRepo.HoldTokens(ctx, testUser.UserID, 2, ""); !errors.Is(err, ErrInsufficientFunds) {
+		t.Errorf("Expected ErrInsufficientFunds, got %v", err)
+	}
+
+	entry, err := testRepo.CommitHold(ctx, testUser.UserID, spent.HoldID)
+	if err != nil {
+		t.Fatalf("CommitHold() returned unexpected error: %v", err)
+	}
+	if entry.Kind != "debit" || entry.Amount != 2 || entry.ReferenceID != ref || entry.BalanceAfter != 1 {
+		t.Errorf("Unexpected debit for the committed hold: %+v", entry)
+	}
+	if again, err := testRepo.CommitHold(ctx, testUser.UserID, spent.HoldID); err != nil || again.EntryID != entry.EntryID {
+		t.Errorf("Expected the repeated commit to return debit %s, got %+v, %v", entry.EntryID, again, err)
+	}
+	if _, err := testRepo.ReleaseHold(ctx, testUser.UserID, spent.HoldID); !errors.Is(err, ErrHoldCommitted) {
+		t.Errorf("Expected ErrHoldCommitted, got %v", err)
+	}
+
+	returned, err := testRepo.HoldTokens(ctx, testUser.UserID, 1, "")
+	if err != nil {
+		t.Fatalf("HoldTokens() returned unexpected error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		released, err := testRepo.ReleaseHold(ctx, testUser.UserID, returned.HoldID)
+		if err != nil || released.BalanceAfter != 1 || released.Amount != 1 || released.Status != holdStatusReleased {
+			t.Errorf("Release %d: expected 1 token released to a balance of 1, got %+v, %v", i+1, released, err)
+		}
+	}
+	if _, err := testRepo.CommitHold(ctx, testUser.UserID, returned.HoldID); !errors.Is(err, ErrHoldReleased) {
+		t.Errorf("Expected ErrHoldReleased, got %v", err)
+	}
+
+	// A hold is only the holder's.
+	if _, err := testRepo.ReleaseHold(ctx, uuid.New(), returned.HoldID); !errors.Is(err, ErrHoldNotFound) {
+		t.Errorf("Expected ErrHoldNotFound for another user, got %v", err)
+	}
+	if held := heldBalance(t); held != 0 {
+		t.Errorf("Expected nothing left held, got %d", held)
+	}
+}
+
+// TestHoldTokens_Concurrent holds one token from many goroutines at once. Only as many as the balance covers may win.
+func TestHoldTokens_Concurrent(t *testing.T) {
+	if err := resetUserTokens(3); err != nil {
+		t.Fatalf("Failed to reset user tokens: %v", err)
+	}
+
+	const attempts = 10
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	held, refused := 0, 0
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := testRepo.HoldTokens(context.Background(), testUser.UserID, 1, "")
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				held++
+			case errors.Is(err, ErrInsufficientFunds):
+				refused++
+			default:
+				t.Errorf("HoldTokens() returned unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if held != 3 || refused != attempts-3 {
+		t.Errorf("Expected 3 holds and %d refusals, got %d and %d", attempts-3, held, refused)
+	}
+	if balance, _ := testRepo.GetBalance(context.Background(), testUser.UserID); balance != 0 || heldBalance(t) != 3 {
+		t.Errorf("Expected balance 0 with 3 held, got %d with %d held", balance, heldBalance(t))
+	}
+	releaseOpenHolds(t)
+}
+
+// TestHolds_CommitReleaseRace commits, releases and sweeps the same holds at the same time.
+// Each hold must end up exactly one way, and the tokens must add up.
+func TestHolds_CommitReleaseRace(t *testing.T) {
+	const holds = 5
+	if err := resetUserTokens(holds); err != nil {
+		t.Fatalf("Failed to reset user tokens: %v", err)
+	}
+	ctx := context.Background()
+
+	ids := make([]uuid.UUID, holds)
+	for i := range ids {
+		hold, err := testRepo.HoldTokens(ctx, testUser.UserID, 1, "")
+		if err != nil {
+			t.Fatalf("HoldTokens() returned unexpected error: %v", err)
+		}
+		ids[i] = hold.HoldID
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	commits, releases := 0, 0
+	for _, id := range ids {
+		id := id
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := testRepo.CommitHold(ctx, testUser.UserID, id)
+			if err == nil {
+				mu.Lock()
+				commits++
+				mu.Unlock()
+			} else if !errors.Is(err, ErrHoldReleased) {
+				t.Errorf("CommitHold() returned unexpected error: %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := testRepo.ReleaseHold(ctx, testUser.UserID, id)
+			if err == nil {
+				mu.Lock()
+				releases++
+				mu.Unlock()
+			} else if !errors.Is(err, ErrHoldCommitted) {
+				t.Errorf("ReleaseHold() returned unexpected error: %v", err)
+			}
+		}()
+	}
+	// The sweeper joins in with a cutoff in the future, so every hold is expired as far as it's concerned.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, err := testRepo.ReleaseExpiredHolds(ctx, time.Now().Add(time.Hour), 100); err != nil {
+			t.Errorf("ReleaseExpiredHolds() returned unexpected error: %v", err)
+		}
+	}()
+	wg.Wait()
+
+	// A release that found the sweeper had been first still succeeds, so count what's actually in the table.
+	var committed int
+	testDB.QueryRow("SELECT count(*) FROM token_holds WHERE user_id = $1 AND status = 'committed'", testUser.UserID).Scan(&committed)
+	if committed != commits {
+		t.Errorf("Expected %d committed holds, found %d", commits, committed)
+	}
+	var open int
+	testDB.QueryRow("SELECT count(*) FROM token_holds WHERE user_id = $1 AND status = 'held'", testUser.UserID).Scan(&open)
+	if open != 0 {
+		t.Errorf("Expected every hold resolved, %d still held", open)
+	}
+	if releases < holds-commits {
+		t.Errorf("Expected a successful release for each uncommitted hold, got %d releases and %d commits", releases, commits)
+	}
+	balance, _ := testRepo.GetBalance(ctx, testUser.UserID)
+	if balance != holds-committed || heldBalance(t) != 0 {
+		t.Errorf("Expected balance %d with nothing held, got %d with %d held", holds-committed, balance, heldBalance(t))
+	}
+}
+
+// TestReleaseExpiredHolds checks the sweep only gives back holds older than the cutoff.
+func TestReleaseExpiredHolds(t *testing.T) {
+	if err := resetUserTokens(3); err != nil {
+		t.Fatalf("Failed to reset user tokens: %v", err)
+	}
+	ctx := context.Background()
+
+	old, err := testRepo.HoldTokens(ctx, testUser.UserID, 2, "")
+	if err != nil {
+		t.Fatalf("HoldTokens() returned unexpected error: %v", err)
+	}
+	if _, err := testDB.Exec("UPDATE token_holds SET created_at = now() - interval '1 hour' WHERE hold_id = $1", old.HoldID); err != nil {
+		t.Fatalf("Could not backdate hold: %v", err)
+	}
+	fresh, err := testRepo.HoldTokens(ctx, testUser.UserID, 1, "")
+	if err != nil {
+		t.Fatalf("HoldTokens() returned unexpected error: %v", err)
+	}
+
+	released, err := testRepo.ReleaseExpiredHolds(ctx, time.Now().Add(-10*time.Minute), 100)
+	if err != nil {
+		t.Fatalf("ReleaseExpiredHolds() returned unexpected error: %v", err)
+	}
+	if released != 1 {
+		t.Errorf("Expected 1 hold released, got %d", released)
+	}
+	if balance, _ := testRepo.GetBalance(ctx, testUser.UserID); balance != 2 || heldBalance(t) != 1 {
+		t.Errorf("Expected balance 2 with 1 held, got %d with %d held", balance, heldBalance(t))
+	}
+	if _, err := testRepo.CommitHold(ctx, testUser.UserID, old.HoldID); !errors.Is(err, ErrHoldReleased) {
+		t.Errorf("Expected the swept hold to be released, got %v", err)
+	}
+	if _, err := testRepo.CommitHold(ctx, testUser.UserID, fresh.HoldID); err != nil {
+		t.Errorf("Expected the fresh hold to still commit, got %v", err)
+	}
+}
+
+// releaseOpenHolds gives back whatever the test user still has held, so later tests start from a clean balance.
+func releaseOpenHolds(t *testing.T) {
+	t.Helper()
+	if _, err := testRepo.ReleaseExpiredHolds(context.Background(), time.Now().Add(time.Hour), 1000); err != nil {
+		t.Fatalf("Could not release open holds: %v", err)
+	}
+}
+
+// clearLots removes the test user's lots, so a lot test starts from plain non-expiring tokens and leaves nothing
+// behind for tests that don't expect lots.
+func clearLots(t *testing.T) {
+	t.Helper()
+	if _, err := testDB.Exec("DELETE FROM token_lots WHERE user_id = $1", testUser.UserID); err != nil {
+		t.Fatalf("Could not clear lots: %v", err)
+	}
+}
+
+// addLot credits the test user a lot of amount tokens expiring at expiresAt, which may be in the past,
+// and returns the lot's id.
+func addLot(t *testing.T, amount int, expiresAt time.Time) uuid.UUID {
+	t.Helper()
+	if _, err := testRepo.CreditLot(context.Background(), testUser.UserID, amount, "test", expiresAt, ""); err != nil {
+		t.Fatalf("CreditLot() returned unexpected error: %v", err)
+	}
+	var lotID uuid.UUID
+	err := testDB.QueryRow(`SELECT lot_id FROM token_lots WHERE user_id = $1 ORDER BY created_at DESC LIMIT 1`, testUser.UserID).Scan(&lotID)
+	if err != nil {
+		t.Fatalf("Could not find the new lot: %v", err)
+	}
+	return lotID
+}
+
+// lotRemaining is what's left in one lot.
+func lotRemaining(t *testing.T, lotID uuid.UUID) int {
+	t.Helper()
+	var remaining int
+	if err := testDB.QueryRow("SELECT remaining FROM token_lots WHERE lot_id = $1", lotID).Scan(&remaining); err != nil {
+		t.Fatalf("Could not read lot %s: %v", lotID, err)
+	}
+	return remaining
+}
+
+// rawBalance reads the balance column itself, expired lots and all.
+func rawBalance(t *testing.T) int {
+	t.Helper()
+	var balance int
+	if err := testDB.QueryRow("SELECT assistance_token_balance FROM users WHERE user_id = $1", testUser.UserID).Scan(&balance); err != nil {
+		t.Fatalf("Could not read balance: %v", err)
+	}
+	return balance
+}
+
+// TestLots_SpendSoonestFirst checks debits and holds take from the soonest expiring lot, then the next, then
+// paid tokens, and that refunds and releases put tokens back where they came from.
+func TestLots_SpendSoonestFirst(t *testing.T) {
+	if err := resetUserTokens(3); err != nil {
+		t.Fatalf("Failed to reset user tokens: %v", err)
+	}
+	clearLots(t)
+	defer clearLots(t)
+	ctx := context.Background()
+
+	later := addLot(t, 2, time.Now().Add(48*time.Hour))
+	soon := addLot(t, 2, time.Now().Add(time.Hour))
+
+	// A referenced lot credit only happens once.
+	ref := "test:" + uuid.NewString()
+	for i := 0; i < 2; i++ {
+		balance, err := testRepo.CreditLot(ctx, testUser.UserID, 1, "test", time.Now().Add(72*time.Hour), ref)
+		if err != nil || balance != 8 {
+			t.Fatalf("Lot credit %d: expected balance 8, got %d, %v", i+1, balance, err)
+		}
+	}
+	var lots int
+	testDB.QueryRow("SELECT count(*) FROM token_lots WHERE user_id = $1", testUser.UserID).Scan(&lots)
+	if lots != 3 {
+		t.Fatalf("Expected 3 lots, got %d", lots)
+	}
+
+	debit, err := testRepo.DebitTokens(ctx, testUser.UserID, 3, "")
+	if err != nil {
+		t.Fatalf("DebitTokens() returned unexpected error: %v", err)
+	}
+	if debit.BalanceAfter != 5 || lotRemaining(t, soon) != 0 || lotRemaining(t, later) != 1 {
+		t.Errorf("Expected balance 5 with the soon lot empty and 1 left in the later one, got %d, %d and %d",
+			debit.BalanceAfter, lotRemaining(t, soon), lotRemaining(t, later))
+	}
+
+	breakdown, err := testRepo.GetBalanceBreakdown(ctx, testUser.UserID)
+	if err != nil {
+		t.Fatalf("GetBalanceBreakdown() returned unexpected error: %v", err)
+	}
+	if breakdown.Total != 5 || breakdown.NonExpiring != 3 || len(breakdown.Expiring) != 2 || breakdown.Expiring[0].Amount != 1 {
+		t.Errorf("Expected 5 with 3 non-expiring and 1 then 1 expiring, got %+v", breakdown)
+	}
+
+	if _, err := testRepo.RefundDebit(ctx, testUser.UserID, debit.EntryID, ""); err != nil {
+		t.Fatalf("RefundDebit() returned unexpected error: %v", err)
+	}
+	if lotRemaining(t, soon) != 2 || lotRemaining(t, later) != 2 {
+		t.Errorf("Expected the refund to refill both lots, got %d and %d", lotRemaining(t, soon), lotRemaining(t, later))
+	}
+
+	// A released hold goes back to its lot, and so does the refund of a committed one.
+	hold, err := testRepo.HoldTokens(ctx, testUser.UserID, 2, "")
+	if err != nil {
+		t.Fatalf("HoldTokens() returned unexpected error: %v", err)
+	}
+	if lotRemaining(t, soon) != 0 {
+		t.Errorf("Expected the hold to empty the soon lot, %d left", lotRemaining(t, soon))
+	}
+	if _, err := testRepo.ReleaseHold(ctx, testUser.UserID, hold.HoldID); err != nil {
+		t.Fatalf("ReleaseHold() returned unexpected error: %v", err)
+	}
+	if lotRemaining(t, soon) != 2 {
+		t.Errorf("Expected the release to refill the soon lot, got %d", lotRemaining(t, soon))
+	}
+
+	hold, err = testRepo.HoldTokens(ctx, testUser.UserID, 1, "")
+	if err != nil {
+		t.Fatalf("HoldTokens() returned unexpected error: %v", err)
+	}
+	committed, err := testRepo.CommitHold(ctx, testUser.UserID, hold.HoldID)
+	if err != nil {
+		t.Fatalf("CommitHold() returned unexpected error: %v", err)
+	}
+	if lotRemaining(t, soon) != 1 {
+		t.Errorf("Expected the committed hold to have taken 1 from the soon lot, %d left", lotRemaining(t, soon))
+	}
+	if _, err := testRepo.RefundDebit(ctx, testUser.UserID, committed.EntryID, ""); err != nil {
+		t.Fatalf("RefundDebit() of the committed hold returned unexpected error: %v", err)
+	}
+	if lotRemaining(t, soon) != 2 {
+		t.Errorf("Expected the refund to refill the soon lot, got %d", lotRemaining(t, soon))
+	}
+	if balance, _ := testRepo.GetBalance(ctx, testUser.UserID); balance != 8 {
+		t.Errorf("Expected everything back to a balance of 8, got %d", balance)
+	}
+}
+
+// TestLots_Expiry checks an expired lot can't be spent, doesn't count in the balance, and goes with one expired
+// ledger entry, whether the sweeper or a debit gets to it first.
+func TestLots_Expiry(t *testing.T) {
+	if err := resetUserTokens(1); err != nil {
+		t.Fatalf("Failed to reset user tokens: %v", err)
+	}
+	clearLots(t)
+	defer clearLots(t)
+	ctx := context.Background()
+
+	expired := addLot(t, 2, time.Now().Add(-time.Minute))
+	open := addLot(t, 3, time.Now().Add(time.Hour))
+
+	if balance, _ := testRepo.GetBalance(ctx, testUser.UserID); balance != 4 || rawBalance(t) != 6 {
+		t.Errorf("Expected a balance of 4 before the sweep with 6 in the column, got %d and %d", balance, rawBalance(t))
+	}
+	breakdown, err := testRepo.GetBalanceBreakdown(ctx, testUser.UserID)
+	if err != nil {
+		t.Fatalf("GetBalanceBreakdown() returned unexpected error: %v", err)
+	}
+	if breakdown.Total != 4 || breakdown.NonExpiring != 1 || len(breakdown.Expiring) != 1 || breakdown.Expiring[0].Amount != 3 {
+		t.Errorf("Expected 4 with 1 non-expiring and 3 expiring, got %+v", breakdown)
+	}
+
+	// Sweeping twice must only expire our lot once. Other lots in the table might be due too, so the counts can be higher.
+	for i := 0; i < 2; i++ {
+		n, err := testRepo.ExpireLots(ctx, time.Now(), 100)
+		if err != nil {
+			t.Fatalf("ExpireLots() returned unexpected error: %v", err)
+		}
+		if i == 0 && n < 1 {
+			t.Errorf("Expected at least 1 lot expired, got %d", n)
+		}
+	}
+	if lotRemaining(t, expired) != 0 || rawBalance(t) != 4 || lotRemaining(t, open) != 3 {
+		t.Errorf("Expected the expired lot emptied and the balance at 4, got %d left and %d", lotRemaining(t, expired), rawBalance(t))
+	}
+	var entries int
+	var entry LedgerEntry
+	err = testDB.QueryRow(`
+		SELECT count(*) OVER (), amount, balance_after
+		FROM token_ledger
+		WHERE user_id = $1 AND kind = 'expired' AND reference_id = $2
+	`, testUser.UserID, expired.String()).Scan(&entries, &entry.Amount, &entry.BalanceAfter)
+	if err != nil || entries != 1 || entry.Amount != 2 || entry.BalanceAfter != 4 {
+		t.Errorf("Expected one expired entry of 2 leaving 4, got %d entries, %+v, %v", entries, entry, err)
+	}
+
+	// A debit doesn't wait for the sweeper. The 2 that just expired can't pay for this.
+	late := addLot(t, 2, time.Now().Add(-time.Second))
+	if _, err := testRepo.DebitTokens(ctx, testUser.UserID, 5, ""); !errors.Is(err, ErrInsufficientFunds) {
+		t.Errorf("Expected ErrInsufficientFunds counting the expired lot out, got %v", err)
+	}
+	debit, err := testRepo.DebitTokens(ctx, testUser.UserID, 4, "")
+	if err != nil {
+		t.Fatalf("DebitTokens() returned unexpected error: %v", err)
+	}
+	if debit.BalanceAfter != 0 || lotRemaining(t, late) != 0 || lotRemaining(t, open) != 0 {
+		t.Errorf("Expected everything spent or expired, got balance %d with %d and %d left", debit.BalanceAfter, lotRemaining(t, late), lotRemaining(t, open))
+	}
+	testDB.QueryRow(`SELECT count(*) FROM token_ledger WHERE kind = 'expired' AND reference_id = $1`, late.String()).Scan(&entries)
+	if entries != 1 {
+		t.Errorf("Expected the debit to write the late lot's expired entry, got %d", entries)
+	}
+}
+
+// TestForfeitBalance checks a forfeit takes everything spendable, empties the lots that were still open, and
+// writes one forfeit entry. A second forfeit takes nothing.
+func TestForfeitBalance(t *testing.T) {
+	if err := resetUserBuckets(2, 1); err != nil {
+		t.Fatalf("Failed to reset user tokens: %v", err)
+	}
+	clearLots(t)
+	defer clearLots(t)
+	ctx := context.Background()
+
+	open := addLot(t, 4, time.Now().Add(time.Hour))
+	entry, err := testRepo.ForfeitBalance(ctx, testUser.UserID, "account-deleted")
+	if err != nil {
+		t.Fatalf("ForfeitBalance() returned unexpected error: %v", err)
+	}
+	if entry == nil || entry.Kind != ledgerKindForfeit || entry.Amount != 7 || entry.Granted != 5 || entry.BalanceAfter != 0 {
+		t.Fatalf("Expected a forfeit of 7 with 5 granted leaving 0, got %+v", entry)
+	}
+	if entry.Delta() != -7 || entry.ReferenceID != "account-deleted" {
+		t.Errorf("Expected a delta of -7 with the reference, got %d and %q", entry.Delta(), entry.ReferenceID)
+	}
+	var granted int
+	testDB.QueryRow("SELECT granted_token_balance FROM users WHERE user_id = $1", testUser.UserID).Scan(&granted)
+	if rawBalance(t) != 0 || granted != 0 || lotRemaining(t, open) != 0 {
+		t.Errorf("Expected nothing left anywhere, got balance %d, granted %d and %d in the lot", rawBalance(t), granted, lotRemaining(t, open))
+	}
+
+	again, err := testRepo.ForfeitBalance(ctx, testUser.UserID, "account-deleted")
+	if err != nil || again != nil {
+		t.Errorf("Expected nothing to forfeit the second time, got %+v, %v", again, err)
+	}
+
+	if _, err := testRepo.ForfeitBalance(ctx, uuid.New(), ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an unknown user, got %v", err)
+	}
+}
+
+// TestLots_ConcurrentSpending debits and holds one token at a time from many goroutines while the sweeper runs.
+// Only the tokens that haven't expired may be spent, every lot must end up empty, and the expired one must be
+// expired exactly once.
+func TestLots_ConcurrentSpending(t *testing.T) {
+	const paid = 2
+	if err := resetUserTokens(paid); err != nil {
+		t.Fatalf("Failed to reset user tokens: %v", err)
+	}
+	clearLots(t)
+	defer clearLots(t)
+	ctx := context.Background()
+
+	lots := []uuid.UUID{
+		addLot(t, 3, time.Now().Add(time.Hour)),
+		addLot(t, 3, time.Now().Add(2*time.Hour)),
+	}
+	expired := addLot(t, 2, time.Now().Add(-time.Minute))
+	const spendable = paid + 6
+
+	const attempts = 20
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	debits, holds, refused := 0, 0, 0
+	for i := 0; i < attempts; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var err error
+			if i%2 == 0 {
+				_, err = testRepo.DebitTokens(ctx, testUser.UserID, 1, "")
+			} else {
+				_, err = testRepo.HoldTokens(ctx, testUser.UserID, 1, "")
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil && i%2 == 0:
+				debits++
+			case err == nil:
+				holds++
+			case errors.Is(err, ErrInsufficientFunds):
+				refused++
+			default:
+				t.Errorf("Spend %d returned unexpected error: %v", i, err)
+			}
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := testRepo.ExpireLots(ctx, time.Now(), 100); err != nil {
+				t.Errorf("ExpireLots() returned unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if debits+holds != spendable || refused != attempts-spendable {
+		t.Fatalf("Expected %d spends and %d refusals, got %d debits, %d holds and %d refusals",
+			spendable, attempts-spendable, debits, holds, refused)
+	}
+	if rawBalance(t) != 0 || heldBalance(t) != holds {
+		t.Errorf("Expected balance 0 with %d held, got %d with %d held", holds, rawBalance(t), heldBalance(t))
+	}
+	for _, lot := range append(lots, expired) {
+		if remaining := lotRemaining(t, lot); remaining != 0 {
+			t.Errorf("Expected lot %s empty, %d left", lot, remaining)
+		}
+	}
+	var expiries, drawnFromExpired, drawn int
+	testDB.QueryRow(`SELECT count(*) FROM token_ledger WHERE kind = 'expired' AND reference_id = $1`, expired.String()).Scan(&expiries)
+	testDB.QueryRow(`SELECT COALESCE(SUM(amount), 0) FROM token_lot_draws WHERE lot_id = $1`, expired).Scan(&drawnFromExpired)
+	testDB.QueryRow(`SELECT COALESCE(SUM(amount), 0) FROM token_lot_draws WHERE lot_id = ANY($1::uuid[])`,
+		[]string{lots[0].String(), lots[1].String()}).Scan(&drawn)
+	if expiries != 1 || drawnFromExpired != 0 || drawn != 6 {
+		t.Errorf("Expected 1 expiry, nothing drawn from the expired lot and 6 from the others, got %d, %d and %d", expiries, drawnFromExpired, drawn)
+	}
+
+	// Releasing the holds puts lot tokens back in lots, and never more than came out of them.
+	releaseOpenHolds(t)
+	var refilled int
+	testDB.QueryRow(`SELECT COALESCE(SUM(remaining), 0) FROM token_lots WHERE user_id = $1`, testUser.UserID).Scan(&refilled)
+	if balance := rawBalance(t); balance != holds || refilled > balance || refilled < holds-paid {
+		t.Errorf("Expected balance %d with between %d and %d of it back in lots, got %d with %d", holds, holds-paid, holds, balance, refilled)
+	}
+}
+
+// TestReconcile checks every kind of balance change keeps the ledger adding up to the balance, and that snapping
+// a balance someone edited by hand puts it back to what the ledger says.
+func TestReconcile(t *testing.T) {
+	if err := resetUserTokens(5); err != nil {
+		t.Fatalf("Failed to reset user tokens: %v", err)
+	}
+	clearLots(t)
+	defer clearLots(t)
+	ctx := context.Background()
+
+	// The reset went behind the ledger's back, like the other tests' resets, so open the ledger at whatever is off.
+	_, err := testDB.Exec(`
+		INSERT INTO token_ledger (entry_id, user_id, kind, amount, balance_after)
+		SELECT gen_random_uuid(), user_id,
+		       'opening', assistance_token_balance + held_token_balance - COALESCE((
+		           SELECT SUM(`+ledgerDeltaSQL+`) FROM token_ledger WHERE user_id = $1
+		       ), 0),
+		       assistance_token_balance
+		FROM users WHERE user_id = $1
+	`, testUser.UserID)
+	if err != nil {
+		t.Fatalf("Could not open the ledger: %v", err)
+	}
+	checkLedgerMatches := func(step string) {
+		t.Helper()
+		total, err := testRepo.SnapBalanceToLedger(ctx, testUser.UserID)
+		if err != nil || total.Balance != total.Ledger {
+			t.Fatalf("Expected the ledger to match the balance after %s, got %+v, %v", step, total, err)
+		}
+	}
+	checkLedgerMatches("the reset")
+
+	if _, err := testRepo.CreditToken(ctx, testUser.UserID, 2, SourcePurchase); err != nil {
+		t.Fatalf("CreditToken() returned unexpected error: %v", err)
+	}
+	checkLedgerMatches("a credit")
+	if _, err := testRepo.CreditTokenOnce(ctx, testUser.UserID, 1, SourceGrant, "reconcile-"+uuid.NewString()); err != nil {
+		t.Fatalf("CreditTokenOnce() returned unexpected error: %v", err)
+	}
+	checkLedgerMatches("a referenced credit")
+	if _, err := testRepo.CreditLot(ctx, testUser.UserID, 3, "trial", time.Now().Add(time.Hour), ""); err != nil {
+		t.Fatalf("CreditLot() returned unexpected error: %v", err)
+	}
+	checkLedgerMatches("a lot")
+	debit, err := testRepo.DebitTokens(ctx, testUser.UserID, 4, "")
+	if err != nil {
+		t.Fatalf("DebitTokens() returned unexpected error: %v", err)
+	}
+	checkLedgerMatches("a debit")
+	if _, err := testRepo.RefundDebit(ctx, testUser.UserID, debit.EntryID, ""); err != nil {
+		t.Fatalf("RefundDebit() returned unexpected error: %v", err)
+	}
+	checkLedgerMatches("a refund")
+	held, err := testRepo.HoldTokens(ctx, testUser.UserID, 2, "")
+	if err != nil {
+		t.Fatalf("HoldTokens() returned unexpected error: %v", err)
+	}
+	checkLedgerMatches("a hold")
+	if _, err := testRepo.CommitHold(ctx, testUser.UserID, held.HoldID); err != nil {
+		t.Fatalf("CommitHold() returned unexpected error: %v", err)
+	}
+	released, err := testRepo.HoldTokens(ctx, testUser.UserID, 1, "")
+	if err != nil {
+		t.Fatalf("HoldTokens() returned unexpected error: %v", err)
+	}
+	if _, err := testRepo.ReleaseHold(ctx, testUser.UserID, released.HoldID); err != nil {
+		t.Fatalf("ReleaseHold() returned unexpected error: %v", err)
+	}
+	checkLedgerMatches("committing and releasing holds")
+
+	// 5 + 2 + 1 + 3 - 4 + 4 - 2 = 9. Two more put in by hand are found, and taken back off.
+	if _, err := testDB.Exec(`UPDATE users SET assistance_token_balance = assistance_token_balance + 2 WHERE user_id = $1`, testUser.UserID); err != nil {
+		t.Fatalf("Could not edit the balance: %v", err)
+	}
+	total, err := testRepo.SnapBalanceToLedger(ctx, testUser.UserID)
+	if err != nil {
+		t.Fatalf("SnapBalanceToLedger() returned unexpected error: %v", err)
+	}
+	if total.Balance-total.Ledger != 2 || rawBalance(t) != 9 {
+		t.Errorf("Expected 11 snapped back to 9, got %+v and %d in the column", total, rawBalance(t))
+	}
+
+	if _, err := testRepo.SnapBalanceToLedger(ctx, uuid.New()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an unknown user, got %v", err)
+	}
+}
+
+// TestQueryTimeout checks a debit or hold stuck behind another transaction's lock on the balance gives up at the
+// query timeout, and leaves the balance alone.
+func TestQueryTimeout(t *testing.T) {
+	if err := resetUserTokens(3); err != nil {
+		t.Fatalf("Failed to reset user tokens: %v", err)
+	}
+	ctx := context.Background()
+
+	// Hold the users row in an open transaction, so the calls below wait on the lock until their deadline.
+	tx, err := testDB.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("BeginTx() failed: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, "SELECT user_id FROM users WHERE user_id = $1 FOR UPDATE", testUser.UserID); err != nil {
+		tx.Rollback()
+		t.Fatalf("Could not lock the user: %v", err)
+	}
+
+	repo := NewPostgresRepositoryWithOptions(testDB, RepositoryOptions{QueryTimeout: 100 * time.Millisecond})
+	start := time.Now()
+	if _, err := repo.DebitTokens(ctx, testUser.UserID, 1, ""); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded from DebitTokens, got %v", err)
+	}
+	if _, err := repo.HoldTokens(ctx, testUser.UserID, 1, "timeout-"+uuid.NewString()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded from HoldTokens, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the calls to be cut off, they ran for %s", elapsed)
+	}
+	tx.Rollback()
+
+	if balance := rawBalance(t); balance != 3 {
+		t.Errorf("Expected the balance to stay at 3, got %d", balance)
+	}
+}
@@ -1,7 +1,11 @@
 package billing
 
+//go:generate mockgen -destination=./service_mock_test.go -package=billing -source=service.go Service
+
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -10,19 +14,111 @@ import (
 // It defines the contract for what the service can do.
 type Service interface {
 	DebitToken(ctx context.Context, userID uuid.UUID) (int, error)
-	CreditToken(ctx context.Context, userID uuid.UUID, amount int) (int, error)
+	DebitTokens(ctx context.Context, userID uuid.UUID, amount int, referenceID string) (*LedgerEntry, error)
+	DebitCost(ctx context.Context, userID uuid.UUID, costType, referenceID string) (*LedgerEntry, error)
+	RefundDebit(ctx context.Context, userID, entryID uuid.UUID, referenceID string) (*LedgerEntry, error)
+	CreditToken(ctx context.Context, userID uuid.UUID, amount int, source string) (int, error)
+	CreditTokenOnce(ctx context.Context, userID uuid.UUID, amount int, source, referenceID string) (int, error)
+	CreditBatch(ctx context.Context, referenceID string, items []BatchCreditItem) ([]BatchCreditResult, error)
+	CreditExpiringTokens(ctx context.Context, userID uuid.UUID, amount int, source string, expiresAt time.Time, referenceID string) (int, error)
+	GetBalance(ctx context.Context, userID uuid.UUID) (*Balance, error)
+	ListLedger(ctx context.Context, userID uuid.UUID, after *LedgerCursor, limit int) (*LedgerPage, error)
+	GrantMonthlyTokens(ctx context.Context) (*GrantCycleResult, error)
+	HoldTokens(ctx context.Context, userID uuid.UUID, amount int, referenceID string) (*TokenHold, error)
+	CommitHold(ctx context.Context, userID, holdID uuid.UUID) (*LedgerEntry, error)
+	ReleaseHold(ctx context.Context, userID, holdID uuid.UUID) (int, error)
+	Reconcile(ctx context.Context) ([]Discrepancy, error)
+	ReconcileAndFix(ctx context.Context) ([]Discrepancy, error)
+	ForfeitBalance(ctx context.Context, userID uuid.UUID, referenceID string) (int, error)
+}
+
+// Options are the service's optional settings. The zero value gives no monthly grants and publishes nothing.
+type Options struct {
+	Grants    TierGrants // Monthly tokens per membership tier
+	Publisher Publisher  // Where balance change events go. nil means nowhere
+	Metrics   *Metrics   // Where debits and credits are counted. nil means they're kept but nobody can scrape them
+	// MaxCreditAmount is the most tokens one credit can add. 0 means no limit. Batch credits and grants aren't limited.
+	MaxCreditAmount int
+	// CostTypes prices DebitCost. nil means DefaultCostTypes
+	CostTypes CostTypes
+	// LowBalanceThreshold is the balance at or below which a debit or hold sends a low_balance event. 0 means when it runs out.
+	LowBalanceThreshold int
 }
 
 // service is the concrete implementation of the Service interface.
 type service struct {
-	repo Repository
+	repo       Repository
+	grants     TierGrants
+	publisher  Publisher
+	metrics    *Metrics
+	maxCredit  int
+	lowBalance int
+	costs      CostTypes
+	now        func() time.Time // Picks the grant cycle and checks expiries. Tests swap it out
+	// reconcileBatch is how many users Reconcile checks per query. Tests make it small
+	reconcileBatch int
 }
 
 // NewService is the constructor for the service.
-// It takes the repository as a dependency.
+// It takes the repository as a dependency. No tier gets monthly grants and no events are published.
 func NewService(repo Repository) Service {
+	return NewServiceWithOptions(repo, Options{})
+}
+
+// NewServiceWithOptions is the same as NewService, with grants and a publisher from opts.
+func NewServiceWithOptions(repo Repository, opts Options) Service {
+	if opts.Publisher == nil {
+		opts.Publisher = NewNoopPublisher()
+	}
+	if opts.Metrics == nil {
+		opts.Metrics = newUnregisteredMetrics()
+	}
+	if opts.CostTypes == nil {
+		opts.CostTypes = DefaultCostTypes()
+	}
 	return &service{
-		repo: repo,
+		repo:       repo,
+		grants:     opts.Grants,
+		publisher:  opts.Publisher,
+		metrics:    opts.Metrics,
+		maxCredit:  opts.MaxCreditAmount,
+		lowBalance: opts.LowBalanceThreshold,
+		costs:      opts.CostTypes,
+		now:        time.Now,
+
+		reconcileBatch: defaultReconcileBatchSize,
+	}
+}
+
+// publish sends a balance event. The balance has already changed by now, so a failure is only logged.
+func (s *service) publish(ctx context.Context, ev *BalanceEvent) {
+	if err := s.publisher.Publish(ctx, ev); err != nil {
+		fmt.Printf("WARNING: Could not publish balance event %s for user %s: %v\n", ev.EventID, ev.UserID, err)
+	}
+}
+
+// DefaultMaxCreditAmount is the most tokens one credit can add, unless configured otherwise.
+// The biggest pack is a fraction of it, so anything over is a bug or a typo in a support credit.
+const DefaultMaxCreditAmount = 200
+
+// checkCreditAmount returns ErrInvalidAmount or ErrCreditTooLarge if amount can't be credited in one go.
+func (s *service) checkCreditAmount(amount int) error {
+	if amount <= 0 {
+		return ErrInvalidAmount
+	}
+	if s.maxCredit > 0 && amount > s.maxCredit {
+		return fmt.Errorf("%w of %d", ErrCreditTooLarge, s.maxCredit)
+	}
+	return nil
+}
+
+// publishDebit publishes the balance event for a debit or hold, then a low_balance event too if it's the one that
+// took the balance down to the threshold. Anything already at or below it had its low_balance event when it got there.
+func (s *service) publishDebit(ctx context.Context, ev *BalanceEvent) {
+	s.publish(ctx, ev)
+	before := ev.NewBalance - ev.Delta
+	if before > s.lowBalance && ev.NewBalance <= s.lowBalance {
+		s.publish(ctx, newLowBalanceEvent(ev))
 	}
 }
 
@@ -31,20 +127,215 @@ func (s *service) DebitToken(ctx context.Context, userID uuid.UUID) (int, error)
 
 	// For now, service logic is just a simple pass-through to the repository. The repo's SQL query has all the logic.
 	newBalance, err := s.repo.DebitToken(ctx, userID)
+	s.metrics.debited(opDebit, err)
 	if err != nil {
 		// Just pass the error up (eg "insufficient funds").
 		return 0, err
 	}
 
+	// No reference, so no way to tell a retry apart. Each one gets its own event id.
+	s.publishDebit(ctx, newBalanceEvent("debit:"+uuid.NewString(), userID, -1, newBalance, ReasonDebit))
 	return newBalance, nil
 }
 
-// This is also a simple passthrough to the repository's atomic SQL.
-func (s *service) CreditToken(ctx context.Context, userID uuid.UUID, amount int) (int, error) {
-	newBalance, err := s.repo.CreditToken(ctx, userID, amount)
+// DebitTokens takes amount tokens at once, eg for an urgent request that costs more. It's all or nothing.
+// The ledger entry it returns is what a refund points at later.
+func (s *service) DebitTokens(ctx context.Context, userID uuid.UUID, amount int, referenceID string) (*LedgerEntry, error) {
+	if amount <= 0 {
+		s.metrics.debited(opDebitTokens, ErrInvalidAmount)
+		return nil, ErrInvalidAmount
+	}
+	entry, err := s.repo.DebitTokens(ctx, userID, amount, referenceID)
+	s.metrics.debited(opDebitTokens, err)
+	if err != nil {
+		return nil, err
+	}
+	// A repeated reference gets the original entry back, so the event id repeats too.
+	s.publishDebit(ctx, newBalanceEvent("ledger:"+entry.EntryID.String(), userID, -entry.Amount, entry.BalanceAfter, ReasonDebit))
+	return entry, nil
+}
+
+// HoldTokens sets amount tokens aside for something that might not happen, all or nothing.
+// The hold is then committed or released, or the HoldSweeper releases it once it's too old.
+func (s *service) HoldTokens(ctx context.Context, userID uuid.UUID, amount int, referenceID string) (*TokenHold, error) {
+	if amount <= 0 {
+		s.metrics.debited(opHold, ErrInvalidAmount)
+		return nil, ErrInvalidAmount
+	}
+	hold, err := s.repo.HoldTokens(ctx, userID, amount, referenceID)
+	s.metrics.debited(opHold, err)
+	if err != nil {
+		return nil, err
+	}
+	// The held tokens leave the balance now, so this is the event. Committing later doesn't change the balance again.
+	s.publishDebit(ctx, newBalanceEvent("hold:"+hold.HoldID.String(), userID, -hold.Amount, hold.BalanceAfter, ReasonHold))
+	return hold, nil
+}
+
+// CommitHold spends a hold. It's a passthrough, safe to retry.
+// There's no balance event, since the tokens already left the balance when they were held.
+func (s *service) CommitHold(ctx context.Context, userID, holdID uuid.UUID) (*LedgerEntry, error) {
+	return s.repo.CommitHold(ctx, userID, holdID)
+}
+
+// ReleaseHold gives a hold back and returns the new balance. It's safe to retry.
+func (s *service) ReleaseHold(ctx context.Context, userID, holdID uuid.UUID) (int, error) {
+	hold, err := s.repo.ReleaseHold(ctx, userID, holdID)
+	s.metrics.credited(opHoldRelease, 1, err)
+	if err != nil {
+		return 0, err
+	}
+	s.publish(ctx, newBalanceEvent("hold_released:"+hold.HoldID.String(), userID, hold.Amount, hold.BalanceAfter, ReasonHoldReleased))
+	return hold.BalanceAfter, nil
+}
+
+// RefundDebit reverses one debit, found by its ledger entry id or the caller's reference. Each debit is refunded at most once.
+func (s *service) RefundDebit(ctx context.Context, userID, entryID uuid.UUID, referenceID string) (*LedgerEntry, error) {
+	refund, err := s.repo.RefundDebit(ctx, userID, entryID, referenceID)
+	s.metrics.credited(opRefund, 1, err)
+	if err != nil {
+		return nil, err
+	}
+	s.publish(ctx, newBalanceEvent("ledger:"+refund.EntryID.String(), userID, refund.Amount, refund.BalanceAfter, ReasonRefund))
+	return refund, nil
+}
+
+// This is also a simple passthrough to the repository's atomic SQL, once the source checks out.
+// source is one of the Source constants, and decides whether the tokens count as purchased or granted.
+func (s *service) CreditToken(ctx context.Context, userID uuid.UUID, amount int, source string) (int, error) {
+	if !validSource(source) {
+		s.metrics.credited(opCredit, 1, ErrInvalidSource)
+		return 0, ErrInvalidSource
+	}
+	if err := s.checkCreditAmount(amount); err != nil {
+		s.metrics.credited(opCredit, 1, err)
+		return 0, err
+	}
+	newBalance, err := s.repo.CreditToken(ctx, userID, amount, source)
+	s.metrics.credited(opCredit, 1, err)
 	if err != nil {
 		// Pass up errors like "user not found"
 		return 0, err
 	}
+	s.publish(ctx, newBalanceEvent("credit:"+uuid.NewString(), userID, amount, newBalance, ReasonCredit))
+	return newBalance, nil
+}
+
+// CreditTokenOnce is the passthrough for credits that might be retried, eg from the PaymentService.
+// A reference that was already credited gives back that credit's balance instead of crediting again.
+func (s *service) CreditTokenOnce(ctx context.Context, userID uuid.UUID, amount int, source, referenceID string) (int, error) {
+	if !validSource(source) {
+		s.metrics.credited(opCreditOnce, 1, ErrInvalidSource)
+		return 0, ErrInvalidSource
+	}
+	if err := s.checkCreditAmount(amount); err != nil {
+		s.metrics.credited(opCreditOnce, 1, err)
+		return 0, err
+	}
+	newBalance, err := s.repo.CreditTokenOnce(ctx, userID, amount, source, referenceID)
+	s.metrics.credited(opCreditOnce, 1, err)
+	if err != nil {
+		return 0, err
+	}
+	s.publish(ctx, newBalanceEvent(creditEventID(userID, referenceID), userID, amount, newBalance, ReasonCredit))
+	return newBalance, nil
+}
+
+// creditEventID is the event id for a credit with a reference. It's the same key token_credits is unique on,
+// so single and batch credits under one reference can't be told apart, and don't need to be.
+func creditEventID(userID uuid.UUID, referenceID string) string {
+	return "credit:" + userID.String() + ":" + referenceID
+}
+
+// CreditBatch is the passthrough for campaigns and bulk support grants. There's a result for every item,
+// and a user credited under referenceID before isn't credited again.
+func (s *service) CreditBatch(ctx context.Context, referenceID string, items []BatchCreditItem) ([]BatchCreditResult, error) {
+	results, err := s.repo.CreditBatch(ctx, referenceID, items)
+	if err != nil {
+		s.metrics.credited(opBatchCredit, len(items), err)
+		return nil, err
+	}
+	s.metrics.creditedBatch(results)
+	// Only this call's credits. Anyone already credited had their event the first time.
+	for i, result := range results {
+		if result.Status == BatchCredited {
+			s.publish(ctx, newBalanceEvent(creditEventID(result.UserID, referenceID), result.UserID, items[i].Amount, result.NewBalance, ReasonBatchCredit))
+		}
+	}
+	return results, nil
+}
+
+// CreditExpiringTokens credits tokens that run out at expiresAt, eg a trial. They're spent before any the user
+// paid for. With a reference it's safe to retry, like CreditTokenOnce.
+func (s *service) CreditExpiringTokens(ctx context.Context, userID uuid.UUID, amount int, source string, expiresAt time.Time, referenceID string) (int, error) {
+	if err := s.checkCreditAmount(amount); err != nil {
+		s.metrics.credited(opExpiringCredit, 1, err)
+		return 0, err
+	}
+	if !expiresAt.After(s.now()) {
+		s.metrics.credited(opExpiringCredit, 1, ErrInvalidExpiry)
+		return 0, ErrInvalidExpiry
+	}
+	newBalance, err := s.repo.CreditLot(ctx, userID, amount, source, expiresAt, referenceID)
+	s.metrics.credited(opExpiringCredit, 1, err)
+	if err != nil {
+		return 0, err
+	}
+	eventID := "credit:" + uuid.NewString()
+	if referenceID != "" {
+		eventID = creditEventID(userID, referenceID)
+	}
+	s.publish(ctx, newBalanceEvent(eventID, userID, amount, newBalance, ReasonCredit))
 	return newBalance, nil
 }
+
+// GetBalance is a passthrough to the repository, with the breakdown of what expires when.
+// ErrNotFound comes back for unknown users.
+func (s *service) GetBalance(ctx context.Context, userID uuid.UUID) (*Balance, error) {
+	return s.repo.GetBalanceBreakdown(ctx, userID)
+}
+
+// ListLedger returns one page of the user's ledger, newest first. It reads one entry past the page
+// to know whether there's another one, so the last page never comes with a cursor to an empty page.
+// limit outside 1..maxLedgerPageSize falls back to the default page size.
+func (s *service) ListLedger(ctx context.Context, userID uuid.UUID, after *LedgerCursor, limit int) (*LedgerPage, error) {
+	if limit <= 0 || limit > maxLedgerPageSize {
+		limit = defaultLedgerPageSize
+	}
+	entries, err := s.repo.ListLedger(ctx, userID, after, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &LedgerPage{Entries: entries}
+	if len(entries) > limit {
+		page.Entries = entries[:limit]
+		page.Next = cursorFor(page.Entries[limit-1])
+	}
+
+	// An empty first page is either a user who never spent anything or one who doesn't exist. Support wants to know which.
+	if len(page.Entries) == 0 && after == nil {
+		if _, err := s.repo.GetBalance(ctx, userID); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// GrantMonthlyTokens credits this month's tokens to every user on a tier with a grant. It's meant to be run by a
+// scheduler, and it's safe to run as often as it likes: each user gets each month's grant at most once,
+// so a re-run only picks up users who joined the tier since the last one, or had too many tokens for the last one.
+func (s *service) GrantMonthlyTokens(ctx context.Context) (*GrantCycleResult, error) {
+	result := &GrantCycleResult{Cycle: grantCycle(s.now()), Granted: map[string]int{}}
+	for _, tier := range s.grants.tiers() {
+		n, err := s.repo.GrantTierTokens(ctx, tier, s.grants[tier], result.Cycle)
+		if err != nil {
+			s.metrics.credited(opMonthlyGrant, 1, err) // No idea how many users that was
+			// Tiers already done stay done, and a re-run skips their users.
+			return result, fmt.Errorf("monthly grant for tier %s failed: %w", tier, err)
+		}
+		s.metrics.credited(opMonthlyGrant, n, nil)
+		result.Granted[tier] = n
+	}
+	return result, nil
+}
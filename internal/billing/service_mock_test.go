@@ -0,0 +1,298 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: service.go
+//
+// Generated by this command:
+//
+//	mockgen -destination=./service_mock_test.go -package=billing -source=service.go Service
+//
+
+// Package billing is a generated GoMock package.
+package billing
+
+import (
+	context "context"
+	reflect "reflect"
+	time "time"
+
+	uuid "github.com/google/uuid"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockService is a mock of Service interface.
+type MockService struct {
+	ctrl     *gomock.Controller
+	recorder *MockServiceMockRecorder
+	isgomock struct{}
+}
+
+// MockServiceMockRecorder is the mock recorder for MockService.
+type MockServiceMockRecorder struct {
+	mock *MockService
+}
+
+// NewMockService creates a new mock instance.
+func NewMockService(ctrl *gomock.Controller) *MockService {
+	mock := &MockService{ctrl: ctrl}
+	mock.recorder = &MockServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockService) EXPECT() *MockServiceMockRecorder {
+	return m.recorder
+}
+
+// CommitHold mocks base method.
+func (m *MockService) CommitHold(ctx context.Context, userID, holdID uuid.UUID) (*LedgerEntry, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CommitHold", ctx, userID, holdID)
+	ret0, _ := ret[0].(*LedgerEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CommitHold indicates an expected call of CommitHold.
+func (mr *MockServiceMockRecorder) CommitHold(ctx, userID, holdID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CommitHold", reflect.TypeOf((*MockService)(nil).CommitHold), ctx, userID, holdID)
+}
+
+// CreditBatch mocks base method.
+func (m *MockService) CreditBatch(ctx context.Context, referenceID string, items []BatchCreditItem) ([]BatchCreditResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreditBatch", ctx, referenceID, items)
+	ret0, _ := ret[0].([]BatchCreditResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreditBatch indicates an expected call of CreditBatch.
+func (mr *MockServiceMockRecorder) CreditBatch(ctx, referenceID, items any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreditBatch", reflect.TypeOf((*MockService)(nil).CreditBatch), ctx, referenceID, items)
+}
+
+// CreditExpiringTokens mocks base method.
+func (m *MockService) CreditExpiringTokens(ctx context.Context, userID uuid.UUID, amount int, source string, expiresAt time.Time, referenceID string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreditExpiringTokens", ctx, userID, amount, source, expiresAt, referenceID)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreditExpiringTokens indicates an expected call of CreditExpiringTokens.
+func (mr *MockServiceMockRecorder) CreditExpiringTokens(ctx, userID, amount, source, expiresAt, referenceID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreditExpiringTokens", reflect.TypeOf((*MockService)(nil).CreditExpiringTokens), ctx, userID, amount, source, expiresAt, referenceID)
+}
+
+// CreditToken mocks base method.
+func (m *MockService) CreditToken(ctx context.Context, userID uuid.UUID, amount int, source string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreditToken", ctx, userID, amount, source)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreditToken indicates an expected call of CreditToken.
+func (mr *MockServiceMockRecorder) CreditToken(ctx, userID, amount, source any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreditToken", reflect.TypeOf((*MockService)(nil).CreditToken), ctx, userID, amount, source)
+}
+
+// CreditTokenOnce mocks base method.
+func (m *MockService) CreditTokenOnce(ctx context.Context, userID uuid.UUID, amount int, source, referenceID string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreditTokenOnce", ctx, userID, amount, source, referenceID)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreditTokenOnce indicates an expected call of CreditTokenOnce.
+func (mr *MockServiceMockRecorder) CreditTokenOnce(ctx, userID, amount, source, referenceID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreditTokenOnce", reflect.TypeOf((*MockService)(nil).CreditTokenOnce), ctx, userID, amount, source, referenceID)
+}
+
+// DebitCost mocks base method.
+func (m *MockService) DebitCost(ctx context.Context, userID uuid.UUID, costType, referenceID string) (*LedgerEntry, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DebitCost", ctx, userID, costType, referenceID)
+	ret0, _ := ret[0].(*LedgerEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DebitCost indicates an expected call of DebitCost.
+func (mr *MockServiceMockRecorder) DebitCost(ctx, userID, costType, referenceID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DebitCost", reflect.TypeOf((*MockService)(nil).DebitCost), ctx, userID, costType, referenceID)
+}
+
+// DebitToken mocks base method.
+func (m *MockService) DebitToken(ctx context.Context, userID uuid.UUID) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DebitToken", ctx, userID)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DebitToken indicates an expected call of DebitToken.
+func (mr *MockServiceMockRecorder) DebitToken(ctx, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DebitToken", reflect.TypeOf((*MockService)(nil).DebitToken), ctx, userID)
+}
+
+// DebitTokens mocks base method.
+func (m *MockService) DebitTokens(ctx context.Context, userID uuid.UUID, amount int, referenceID string) (*LedgerEntry, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DebitTokens", ctx, userID, amount, referenceID)
+	ret0, _ := ret[0].(*LedgerEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DebitTokens indicates an expected call of DebitTokens.
+func (mr *MockServiceMockRecorder) DebitTokens(ctx, userID, amount, referenceID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DebitTokens", reflect.TypeOf((*MockService)(nil).DebitTokens), ctx, userID, amount, referenceID)
+}
+
+// ForfeitBalance mocks base method.
+func (m *MockService) ForfeitBalance(ctx context.Context, userID uuid.UUID, referenceID string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ForfeitBalance", ctx, userID, referenceID)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ForfeitBalance indicates an expected call of ForfeitBalance.
+func (mr *MockServiceMockRecorder) ForfeitBalance(ctx, userID, referenceID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ForfeitBalance", reflect.TypeOf((*MockService)(nil).ForfeitBalance), ctx, userID, referenceID)
+}
+
+// GetBalance mocks base method.
+func (m *MockService) GetBalance(ctx context.Context, userID uuid.UUID) (*Balance, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetBalance", ctx, userID)
+	ret0, _ := ret[0].(*Balance)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetBalance indicates an expected call of GetBalance.
+func (mr *MockServiceMockRecorder) GetBalance(ctx, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBalance", reflect.TypeOf((*MockService)(nil).GetBalance), ctx, userID)
+}
+
+// GrantMonthlyTokens mocks base method.
+func (m *MockService) GrantMonthlyTokens(ctx context.Context) (*GrantCycleResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GrantMonthlyTokens", ctx)
+	ret0, _ := ret[0].(*GrantCycleResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GrantMonthlyTokens indicates an expected call of GrantMonthlyTokens.
+func (mr *MockServiceMockRecorder) GrantMonthlyTokens(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GrantMonthlyTokens", reflect.TypeOf((*MockService)(nil).GrantMonthlyTokens), ctx)
+}
+
+// HoldTokens mocks base method.
+func (m *MockService) HoldTokens(ctx context.Context, userID uuid.UUID, amount int, referenceID string) (*TokenHold, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HoldTokens", ctx, userID, amount, referenceID)
+	ret0, _ := ret[0].(*TokenHold)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// HoldTokens indicates an expected call of HoldTokens.
+func (mr *MockServiceMockRecorder) HoldTokens(ctx, userID, amount, referenceID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HoldTokens", reflect.TypeOf((*MockService)(nil).HoldTokens), ctx, userID, amount, referenceID)
+}
+
+// ListLedger mocks base method.
+func (m *MockService) ListLedger(ctx context.Context, userID uuid.UUID, after *LedgerCursor, limit int) (*LedgerPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListLedger", ctx, userID, after, limit)
+	ret0, _ := ret[0].(*LedgerPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListLedger indicates an expected call of ListLedger.
+func (mr *MockServiceMockRecorder) ListLedger(ctx, userID, after, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListLedger", reflect.TypeOf((*MockService)(nil).ListLedger), ctx, userID, after, limit)
+}
+
+// Reconcile mocks base method.
+func (m *MockService) Reconcile(ctx context.Context) ([]Discrepancy, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Reconcile", ctx)
+	ret0, _ := ret[0].([]Discrepancy)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Reconcile indicates an expected call of Reconcile.
+func (mr *MockServiceMockRecorder) Reconcile(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Reconcile", reflect.TypeOf((*MockService)(nil).Reconcile), ctx)
+}
+
+// ReconcileAndFix mocks base method.
+func (m *MockService) ReconcileAndFix(ctx context.Context) ([]Discrepancy, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReconcileAndFix", ctx)
+	ret0, _ := ret[0].([]Discrepancy)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReconcileAndFix indicates an expected call of ReconcileAndFix.
+func (mr *MockServiceMockRecorder) ReconcileAndFix(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReconcileAndFix", reflect.TypeOf((*MockService)(nil).ReconcileAndFix), ctx)
+}
+
+// RefundDebit mocks base method.
+func (m *MockService) RefundDebit(ctx context.Context, userID, entryID uuid.UUID, referenceID string) (*LedgerEntry, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RefundDebit", ctx, userID, entryID, referenceID)
+	ret0, _ := ret[0].(*LedgerEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RefundDebit indicates an expected call of RefundDebit.
+func (mr *MockServiceMockRecorder) RefundDebit(ctx, userID, entryID, referenceID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RefundDebit", reflect.TypeOf((*MockService)(nil).RefundDebit), ctx, userID, entryID, referenceID)
+}
+
+// ReleaseHold mocks base method.
+func (m *MockService) ReleaseHold(ctx context.Context, userID, holdID uuid.UUID) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReleaseHold", ctx, userID, holdID)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReleaseHold indicates an expected call of ReleaseHold.
+func (mr *MockServiceMockRecorder) ReleaseHold(ctx, userID, holdID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReleaseHold", reflect.TypeOf((*MockService)(nil).ReleaseHold), ctx, userID, holdID)
+}
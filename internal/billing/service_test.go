@@ -2,8 +2,9 @@ package billing
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"go.uber.org/mock/gomock" // mock library
@@ -57,7 +58,7 @@ func TestService_DebitToken_InsufficientFunds(t *testing.T) {
 	testUserID := uuid.New()
 
 	// This is the specific error I expect the repo to send.
-	repoError := fmt.Errorf("insufficient funds or user not found")
+	repoError := ErrInsufficientFunds
 
 	// Set up the mock to return my specific error.
 	mockRepo.EXPECT().
@@ -72,7 +73,7 @@ func TestService_DebitToken_InsufficientFunds(t *testing.T) {
 	}
 
 	// Make sure the error is the exact one from the repo.
-	if err.Error() != "insufficient funds or user not found" {
+	if !errors.Is(err, ErrInsufficientFunds) {
 		t.Fatalf("Service returned wrong error: got '%v', want '%v'", err, repoError)
 	}
 }
@@ -92,11 +93,11 @@ func TestService_CreditToken_Success(t *testing.T) {
 	// Expect CreditToken to be called once with 5.
 	// Return the new balance of 8.
 	mockRepo.EXPECT().
-		CreditToken(ctx, testUserID, amountToAdd).
+		CreditToken(ctx, testUserID, amountToAdd, SourcePurchase).
 		Return(expectedNewBalance, nil).
 		Times(1)
 
-	newBalance, err := s.CreditToken(ctx, testUserID, amountToAdd)
+	newBalance, err := s.CreditToken(ctx, testUserID, amountToAdd, SourcePurchase)
 
 	if err != nil {
 		t.Fatalf("Service returned an unexpected error: %v", err)
@@ -106,6 +107,27 @@ func TestService_CreditToken_Success(t *testing.T) {
 	}
 }
 
+// TestService_CreditToken_InvalidSource checks a credit has to say which bucket it's for before it reaches the repo.
+func TestService_CreditToken_InvalidSource(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	s := NewService(mockRepo)
+
+	ctx := context.Background()
+	mockRepo.EXPECT().CreditToken(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	mockRepo.EXPECT().CreditTokenOnce(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	for _, source := range []string{"", "promo", "Purchase"} {
+		if _, err := s.CreditToken(ctx, uuid.New(), 5, source); !errors.Is(err, ErrInvalidSource) {
+			t.Errorf("Expected ErrInvalidSource for %q, got %v", source, err)
+		}
+		if _, err := s.CreditTokenOnce(ctx, uuid.New(), 5, source, "ref"); !errors.Is(err, ErrInvalidSource) {
+			t.Errorf("Expected ErrInvalidSource from CreditTokenOnce for %q, got %v", source, err)
+		}
+	}
+}
+
 func TestService_CreditToken_Error(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
@@ -115,21 +137,225 @@ func TestService_CreditToken_Error(t *testing.T) {
 	ctx := context.Background()
 	testUserID := uuid.New()
 	amountToAdd := 5
-	repoError := fmt.Errorf("user not found") // The repo returns this
+	repoError := ErrNotFound // The repo returns this
 
 	// Expect CreditToken to be called, and return our fake error.
 	mockRepo.EXPECT().
-		CreditToken(ctx, testUserID, amountToAdd).
+		CreditToken(ctx, testUserID, amountToAdd, SourcePurchase).
 		Return(0, repoError).
 		Times(1)
 
-	_, err := s.CreditToken(ctx, testUserID, amountToAdd)
+	_, err := s.CreditToken(ctx, testUserID, amountToAdd, SourcePurchase)
 
 	if err == nil {
 		t.Fatal("Service did not return an error, but one was expected")
 	}
 	// Check that the service passed the error up.
-	if err.Error() != "user not found" {
+	if !errors.Is(err, ErrNotFound) {
 		t.Fatalf("Service returned wrong error: got '%v', want '%v'", err, repoError)
 	}
 }
+
+// TestService_DebitTokens checks debits reach the repository with their reference and bad amounts never do.
+func TestService_DebitTokens(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	s := NewService(mockRepo)
+
+	ctx := context.Background()
+	testUserID := uuid.New()
+
+	mockRepo.EXPECT().DebitTokens(ctx, testUserID, 1, "").Return(&LedgerEntry{BalanceAfter: 4}, nil).Times(1)
+	mockRepo.EXPECT().DebitTokens(ctx, testUserID, 3, "ref-1").Return(&LedgerEntry{BalanceAfter: 1}, nil).Times(1)
+
+	if entry, err := s.DebitTokens(ctx, testUserID, 1, ""); err != nil || entry.BalanceAfter != 4 {
+		t.Errorf("Expected balance 4 and no error, got %+v, %v", entry, err)
+	}
+	if entry, err := s.DebitTokens(ctx, testUserID, 3, "ref-1"); err != nil || entry.BalanceAfter != 1 {
+		t.Errorf("Expected balance 1 and no error, got %+v, %v", entry, err)
+	}
+	if _, err := s.DebitTokens(ctx, testUserID, 0, ""); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("Expected ErrInvalidAmount for 0, got %v", err)
+	}
+}
+
+// TestService_CreditToken_MaxAmount checks a credit over MaxCreditAmount is refused before it reaches the repo,
+// whichever way it's credited, and one at the limit goes through.
+func TestService_CreditToken_MaxAmount(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	s := NewServiceWithOptions(mockRepo, Options{MaxCreditAmount: 100})
+
+	ctx := context.Background()
+	userID := uuid.New()
+	mockRepo.EXPECT().CreditToken(ctx, userID, 100, SourceGrant).Return(103, nil).Times(1)
+
+	if _, err := s.CreditToken(ctx, userID, 100, SourceGrant); err != nil {
+		t.Fatalf("Expected a credit at the limit to go through, got %v", err)
+	}
+	if _, err := s.CreditToken(ctx, userID, 101, SourceGrant); !errors.Is(err, ErrCreditTooLarge) {
+		t.Errorf("CreditToken: expected ErrCreditTooLarge, got %v", err)
+	}
+	if _, err := s.CreditTokenOnce(ctx, userID, 10000000, SourcePurchase, "ref"); !errors.Is(err, ErrCreditTooLarge) {
+		t.Errorf("CreditTokenOnce: expected ErrCreditTooLarge, got %v", err)
+	}
+	if _, err := s.CreditExpiringTokens(ctx, userID, 101, "trial", time.Now().Add(time.Hour), ""); !errors.Is(err, ErrCreditTooLarge) {
+		t.Errorf("CreditExpiringTokens: expected ErrCreditTooLarge, got %v", err)
+	}
+	if _, err := s.CreditToken(ctx, userID, 0, SourceGrant); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("Expected ErrInvalidAmount for 0, got %v", err)
+	}
+}
+
+// capturePublisher returns a mock publisher that appends every event it gets to events.
+func capturePublisher(ctrl *gomock.Controller, events *[]*BalanceEvent) *MockPublisher {
+	pub := NewMockPublisher(ctrl)
+	pub.EXPECT().Publish(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, ev *BalanceEvent) error {
+		*events = append(*events, ev)
+		return nil
+	}).AnyTimes()
+	return pub
+}
+
+// TestService_PublishesBalanceEvents checks the event payload for a debit and a credit, and that a
+// repeated reference gives the same event id so consumers can drop it.
+func TestService_PublishesBalanceEvents(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	var events []*BalanceEvent
+	s := NewServiceWithOptions(mockRepo, Options{Publisher: capturePublisher(ctrl, &events)})
+
+	ctx := context.Background()
+	userID := uuid.New()
+	entryID := uuid.New()
+
+	mockRepo.EXPECT().DebitTokens(ctx, userID, 3, "ref-1").Return(&LedgerEntry{EntryID: entryID, Amount: 3, BalanceAfter: 2}, nil).Times(2)
+	mockRepo.EXPECT().CreditTokenOnce(ctx, userID, 5, SourcePurchase, "apple:abc").Return(7, nil).Times(1)
+	mockRepo.EXPECT().DebitTokens(ctx, userID, 9, "").Return(nil, ErrInsufficientFunds).Times(1)
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.DebitTokens(ctx, userID, 3, "ref-1"); err != nil {
+			t.Fatalf("DebitTokens() returned unexpected error: %v", err)
+		}
+	}
+	if _, err := s.CreditTokenOnce(ctx, userID, 5, SourcePurchase, "apple:abc"); err != nil {
+		t.Fatalf("CreditTokenOnce() returned unexpected error: %v", err)
+	}
+	// A failed debit changes nothing, so there's no event.
+	if _, err := s.DebitTokens(ctx, userID, 9, ""); !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("Expected ErrInsufficientFunds, got %v", err)
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(events))
+	}
+	debit, retry, credit := events[0], events[1], events[2]
+	if debit.Type != EventTokenBalanceChanged || debit.UserID != userID || debit.Delta != -3 || debit.NewBalance != 2 || debit.Reason != ReasonDebit {
+		t.Errorf("Unexpected debit event %+v", debit)
+	}
+	if debit.EventID != "ledger:"+entryID.String() || retry.EventID != debit.EventID {
+		t.Errorf("Expected both debits to have event id ledger:%s, got %q and %q", entryID, debit.EventID, retry.EventID)
+	}
+	if credit.Type != EventTokenBalanceChanged || credit.UserID != userID || credit.Delta != 5 || credit.NewBalance != 7 || credit.Reason != ReasonCredit {
+		t.Errorf("Unexpected credit event %+v", credit)
+	}
+	if credit.EventID != creditEventID(userID, "apple:abc") || credit.OccurredAt.IsZero() {
+		t.Errorf("Unexpected credit event id or time %+v", credit)
+	}
+}
+
+// TestService_LowBalanceEvent checks the 1 to 0 debit sends a low_balance event after its balance event,
+// and the insufficient funds debit after it sends nothing.
+func TestService_LowBalanceEvent(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	var events []*BalanceEvent
+	s := NewServiceWithOptions(mockRepo, Options{Publisher: capturePublisher(ctrl, &events)})
+
+	ctx := context.Background()
+	userID := uuid.New()
+	gomock.InOrder(
+		mockRepo.EXPECT().DebitToken(ctx, userID).Return(1, nil),
+		mockRepo.EXPECT().DebitToken(ctx, userID).Return(0, nil),
+		mockRepo.EXPECT().DebitToken(ctx, userID).Return(0, ErrInsufficientFunds),
+	)
+
+	for _, want := range []int{1, 0} {
+		if balance, err := s.DebitToken(ctx, userID); err != nil || balance != want {
+			t.Fatalf("Expected balance %d and no error, got %d, %v", want, balance, err)
+		}
+	}
+	if _, err := s.DebitToken(ctx, userID); !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("Expected ErrInsufficientFunds, got %v", err)
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("Expected 2 balance events and 1 low_balance event, got %d events", len(events))
+	}
+	debit, low := events[1], events[2]
+	if events[0].Type != EventTokenBalanceChanged || debit.Type != EventTokenBalanceChanged {
+		t.Errorf("Expected the first two events to be balance changes, got %q and %q", events[0].Type, debit.Type)
+	}
+	if low.Type != EventLowBalance || low.UserID != userID || low.NewBalance != 0 || low.EventID != "low_balance:"+debit.EventID {
+		t.Errorf("Unexpected low_balance event %+v", low)
+	}
+}
+
+// TestService_LowBalanceThreshold checks a configured threshold is crossed by a multi-token debit or a hold,
+// and that a debit starting below it doesn't send another event.
+func TestService_LowBalanceThreshold(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	var events []*BalanceEvent
+	s := NewServiceWithOptions(mockRepo, Options{Publisher: capturePublisher(ctrl, &events), LowBalanceThreshold: 2})
+
+	ctx := context.Background()
+	userID := uuid.New()
+	mockRepo.EXPECT().DebitTokens(ctx, userID, 3, "ref-1").Return(&LedgerEntry{EntryID: uuid.New(), Amount: 3, BalanceAfter: 2}, nil)
+	mockRepo.EXPECT().DebitToken(ctx, userID).Return(1, nil)
+	mockRepo.EXPECT().HoldTokens(ctx, userID, 4, "req-1").Return(&TokenHold{HoldID: uuid.New(), Amount: 4, BalanceAfter: 0}, nil)
+
+	if _, err := s.DebitTokens(ctx, userID, 3, "ref-1"); err != nil {
+		t.Fatalf("DebitTokens() returned unexpected error: %v", err)
+	}
+	if _, err := s.DebitToken(ctx, userID); err != nil {
+		t.Fatalf("DebitToken() returned unexpected error: %v", err)
+	}
+	if _, err := s.HoldTokens(ctx, userID, 4, "req-1"); err != nil {
+		t.Fatalf("HoldTokens() returned unexpected error: %v", err)
+	}
+
+	var lows []*BalanceEvent
+	for _, ev := range events {
+		if ev.Type == EventLowBalance {
+			lows = append(lows, ev)
+		}
+	}
+	// 5 to 2 crosses, 2 to 1 is already below, and the hold starts at 4 (a released hold put the tokens back) so it crosses again.
+	if len(lows) != 2 || lows[0].NewBalance != 2 || lows[1].NewBalance != 0 || lows[1].Reason != ReasonHold {
+		t.Errorf("Expected low_balance events at 2 and at 0 from the hold, got %+v", lows)
+	}
+}
+
+// TestService_PublishFailure checks a publisher error doesn't fail the credit it's about.
+func TestService_PublishFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	pub := NewMockPublisher(ctrl)
+	s := NewServiceWithOptions(mockRepo, Options{Publisher: pub})
+
+	ctx := context.Background()
+	userID := uuid.New()
+	mockRepo.EXPECT().CreditToken(ctx, userID, 2, SourceGrant).Return(6, nil).Times(1)
+	pub.EXPECT().Publish(ctx, gomock.Any()).Return(ErrPublishQueueFull).Times(1)
+
+	if balance, err := s.CreditToken(ctx, userID, 2, SourceGrant); err != nil || balance != 6 {
+		t.Errorf("Expected balance 6 and no error, got %d, %v", balance, err)
+	}
+}
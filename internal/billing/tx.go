@@ -0,0 +1,55 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// DBTX is the part of *sql.DB and *sql.Tx the queries use, so a helper can run on either one.
+// Helpers that lock rows still need a transaction to hold the lock in, and say so.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+var (
+	_ DBTX = (*sql.DB)(nil)
+	_ DBTX = (*sql.Tx)(nil)
+)
+
+// errAlreadyRecorded is what a WithTx func returns to roll back because the reference was seen before.
+// The caller then answers with what the first call did, outside the transaction.
+var errAlreadyRecorded = errors.New("reference already recorded")
+
+// WithTx runs fn in a transaction and commits it if fn returns nil. Anything else rolls it back, and fn's error
+// comes back as is, so callers can still check for ErrInsufficientFunds and friends. A panic in fn rolls back too
+// and then carries on panicking, so a bug can't leave a half done transaction holding row locks.
+func (pr *postgresRepository) WithTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := pr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		// ErrTxDone is a cancelled ctx, which database/sql has rolled back for us.
+		if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+			return errors.Join(err, fmt.Errorf("could not roll back: %w", rbErr))
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return nil
+}
@@ -3,15 +3,23 @@ package chat
 //go:generate mockgen -destination=./clients_mock_test.go -package=chat -source=clients.go
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"project-sage/internal/auth"
+	"project-sage/internal/httpclient"
+	"sync"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 // TwilioClient defines the contract for an external client that interacts with the Twilio conversations API.
 type TwilioClient interface {
-	// GenerateToken creates an access token for a user/expert identity.
-	GenerateToken(ctx context.Context, identity string) (string, error)
+	// GenerateToken creates an access token for a user/expert identity that lasts ttl.
+	GenerateToken(ctx context.Context, identity string, ttl time.Duration) (*AccessToken, error)
 
 	// CreateConversation creates a new chat session.
 	CreateConversation(ctx context.Context, friendlyName string) (string, error)
@@ -22,20 +30,36 @@ type TwilioClient interface {
 	// RemoveParticipant removes a participant (eg. the llm).
 	RemoveParticipant(ctx context.Context, conversationSID, participantSID string) error
 
-	// GetConversationHistory fetches all messages from a conversation.
-	GetConversationHistory(ctx context.Context, conversationSID string) ([]*Message, error)
+	// ListParticipants returns everyone currently in a conversation.
+	ListParticipants(ctx context.Context, conversationSID string) ([]*Participant, error)
+
+	// GetConversationHistory fetches the messages from a conversation that match q, oldest first.
+	// Twilio pages messages itself, so the real client should push the limit and order into the API call.
+	GetConversationHistory(ctx context.Context, conversationSID string, q HistoryQuery) ([]*Message, error)
+
+	// SendMessage posts a message into a conversation as the given author (eg the bot).
+	SendMessage(ctx context.Context, conversationSID, author, body string) (*Message, error)
 }
 
-type stubTwilioClient struct{}
+// stubTwilioClient keeps sent messages and participants in memory so the history and participant list reflect them.
+type stubTwilioClient struct {
+	mu           sync.Mutex
+	sent         map[string][]*Message     // conversation SID -> messages posted with SendMessage
+	participants map[string][]*Participant // conversation SID -> participants added and not removed since
+	added        int                       // Participants ever added, to number their SIDs
+}
 
 // NewStubTwilioClient is the constructor for the fake client.
 func NewStubTwilioClient() TwilioClient {
-	return &stubTwilioClient{}
+	return &stubTwilioClient{
+		sent:         make(map[string][]*Message),
+		participants: make(map[string][]*Participant),
+	}
 }
 
-func (s *stubTwilioClient) GenerateToken(ctx context.Context, identity string) (string, error) {
+func (s *stubTwilioClient) GenerateToken(ctx context.Context, identity string, ttl time.Duration) (*AccessToken, error) {
 	// Return a fake, static token.
-	return fmt.Sprintf("fake-twilio-token-for-%s", identity), nil
+	return &AccessToken{Token: fmt.Sprintf("fake-twilio-token-for-%s", identity), ExpiresAt: time.Now().Add(ttl)}, nil
 }
 
 func (s *stubTwilioClient) CreateConversation(ctx context.Context, friendlyName string) (string, error) {
@@ -44,20 +68,70 @@ func (s *stubTwilioClient) CreateConversation(ctx context.Context, friendlyName
 }
 
 func (s *stubTwilioClient) AddParticipant(ctx context.Context, conversationSID, identity string) error {
-	// Log what we're doing and return nil.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.added++
+	s.participants[conversationSID] = append(s.participants[conversationSID], &Participant{
+		SID:      fmt.Sprintf("MB_FAKE_%d", s.added),
+		Identity: identity,
+	})
 	fmt.Printf("STUB: Added participant %s to %s\n", identity, conversationSID)
 	return nil
 }
 
 func (s *stubTwilioClient) RemoveParticipant(ctx context.Context, conversationSID, participantSID string) error {
-	// Log what we're doing and return nil.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The service removes by identity, so match either.
+	kept := s.participants[conversationSID][:0]
+	for _, p := range s.participants[conversationSID] {
+		if p.SID != participantSID && p.Identity != participantSID {
+			kept = append(kept, p)
+		}
+	}
+	s.participants[conversationSID] = kept
 	fmt.Printf("STUB: Removed participant %s from %s\n", participantSID, conversationSID)
 	return nil
 }
 
-func (s *stubTwilioClient) GetConversationHistory(ctx context.Context, conversationSID string) ([]*Message, error) {
-	// Return a static hardcoded history.
-	return []*Message{
+func (s *stubTwilioClient) ListParticipants(ctx context.Context, conversationSID string) ([]*Participant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A conversation this stub didn't create gets the same static user and bot as the static history.
+	participants, ok := s.participants[conversationSID]
+	if !ok {
+		return []*Participant{
+			{SID: "MB_FAKE_USER", Identity: "user-uuid"},
+			{SID: "MB_FAKE_BOT", Identity: DefaultBotIdentity},
+		}, nil
+	}
+	return append([]*Participant(nil), participants...), nil
+}
+
+func (s *stubTwilioClient) SendMessage(ctx context.Context, conversationSID, author, body string) (*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg := &Message{
+		SID:       fmt.Sprintf("MSG_FAKE_SENT_%d", len(s.sent[conversationSID])+1),
+		Author:    author,
+		Content:   body,
+		Timestamp: time.Now(),
+	}
+	s.sent[conversationSID] = append(s.sent[conversationSID], msg)
+	fmt.Printf("STUB: %s sent a message to %s\n", author, conversationSID)
+	return msg, nil
+}
+
+func (s *stubTwilioClient) GetConversationHistory(ctx context.Context, conversationSID string, q HistoryQuery) ([]*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Return a static hardcoded history, plus anything sent to this conversation.
+	history := []*Message{
 		{
 			SID:       "MSG_FAKE_1",
 			Author:    "user-uuid",
@@ -66,9 +140,125 @@ func (s *stubTwilioClient) GetConversationHistory(ctx context.Context, conversat
 		},
 		{
 			SID:       "MSG_FAKE_2",
-			Author:    "LLM_BOT_IDENTITY",
+			Author:    DefaultBotIdentity,
 			Content:   "I see. Have you tried turning it off and on again?",
 			Timestamp: time.Now().Add(-4 * time.Minute),
 		},
-	}, nil
+	}
+	return q.Apply(append(history, s.sent[conversationSID]...)), nil
+}
+
+// RequestClient is the client for the RequestService, for what the webhook sees in chats.
+type RequestClient interface {
+	// Escalate opens an assistance request for the user's conversation, as if they'd tapped "get an expert".
+	// A request that's already open counts as success.
+	Escalate(ctx context.Context, twilioSID string, userID uuid.UUID) error
+	// ReportMessage tells the RequestService someone wrote in the conversation, so it can time the expert's first response.
+	ReportMessage(ctx context.Context, twilioSID, author string, sentAt time.Time) error
+}
+
+// stubRequestClient only logs, for running without a RequestService.
+type stubRequestClient struct{}
+
+// NewStubRequestClient is the constructor for the fake client.
+func NewStubRequestClient() RequestClient {
+	return &stubRequestClient{}
+}
+
+func (s *stubRequestClient) Escalate(ctx context.Context, twilioSID string, userID uuid.UUID) error {
+	fmt.Printf("STUB: Escalating %s for user %s\n", twilioSID, userID)
+	return nil
+}
+
+func (s *stubRequestClient) ReportMessage(ctx context.Context, twilioSID, author string, sentAt time.Time) error {
+	return nil
+}
+
+// httpRequestClient is the real implementation of RequestClient.
+type httpRequestClient struct {
+	httpClient    *http.Client
+	baseURL       string
+	internalToken string // Sent as X-Internal-Token, the RequestService's internal routes are closed without it
+}
+
+// DefaultRequestClientTimeout is the RequestClient's whole-call timeout when it's constructed with 0.
+// Escalating debits a token and summarizes, so it's longer than our other clients'.
+const DefaultRequestClientTimeout = 10 * time.Second
+
+// NewHTTPRequestClient is the constructor for the real client. internalToken is the shared secret the RequestService
+// expects, timeout 0 means DefaultRequestClientTimeout.
+func NewHTTPRequestClient(baseURL, internalToken string, timeout time.Duration) RequestClient {
+	if timeout <= 0 {
+		timeout = DefaultRequestClientTimeout
+	}
+	return &httpRequestClient{
+		httpClient:    httpclient.New(timeout),
+		baseURL:       baseURL,
+		internalToken: internalToken,
+	}
+}
+
+// escalateRequest must match the RequestService's POST /internal/request/escalate DTO.
+type escalateRequest struct {
+	TwilioConversationSID string `json:"twilio_conversation_sid"`
+	UserID                string `json:"user_id"`
+}
+
+// Escalate makes an http call to the RequestService. 409 means the request is already open, which is what we wanted.
+func (c *httpRequestClient) Escalate(ctx context.Context, twilioSID string, userID uuid.UUID) error {
+	status, err := c.post(ctx, "/internal/request/escalate", escalateRequest{
+		TwilioConversationSID: twilioSID,
+		UserID:                userID.String(),
+	})
+	if err != nil {
+		return fmt.Errorf("escalate request failed: %w", err)
+	}
+	if status != http.StatusCreated && status != http.StatusConflict {
+		return fmt.Errorf("request service (escalate) returned status: %d", status)
+	}
+	return nil
+}
+
+// firstResponseRequest must match the RequestService's POST /internal/request/first-response DTO.
+type firstResponseRequest struct {
+	TwilioConversationSID string     `json:"twilio_conversation_sid"`
+	Author                string     `json:"author"`
+	SentAt                *time.Time `json:"sent_at,omitempty"` // Left out when Twilio didn't say, the RequestService uses now
+}
+
+// ReportMessage makes an http call to the RequestService. 404 just means the chat has no open request.
+func (c *httpRequestClient) ReportMessage(ctx context.Context, twilioSID, author string, sentAt time.Time) error {
+	body := firstResponseRequest{TwilioConversationSID: twilioSID, Author: author}
+	if !sentAt.IsZero() {
+		body.SentAt = &sentAt
+	}
+	status, err := c.post(ctx, "/internal/request/first-response", body)
+	if err != nil {
+		return fmt.Errorf("first-response request failed: %w", err)
+	}
+	if status != http.StatusOK && status != http.StatusNotFound {
+		return fmt.Errorf("request service (first-response) returned status: %d", status)
+	}
+	return nil
+}
+
+// post sends body as JSON to path and returns the response status.
+func (c *httpRequestClient) post(ctx context.Context, path string, body any) (int, error) {
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		return 0, fmt.Errorf("could not marshal body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+path, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return 0, fmt.Errorf("could not create http request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(auth.InternalTokenHeader, c.internalToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode, nil
 }
@@ -12,7 +12,9 @@ package chat
 import (
 	context "context"
 	reflect "reflect"
+	time "time"
 
+	uuid "github.com/google/uuid"
 	gomock "go.uber.org/mock/gomock"
 )
 
@@ -70,33 +72,48 @@ func (mr *MockTwilioClientMockRecorder) CreateConversation(ctx, friendlyName any
 }
 
 // GenerateToken mocks base method.
-func (m *MockTwilioClient) GenerateToken(ctx context.Context, identity string) (string, error) {
+func (m *MockTwilioClient) GenerateToken(ctx context.Context, identity string, ttl time.Duration) (*AccessToken, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GenerateToken", ctx, identity)
-	ret0, _ := ret[0].(string)
+	ret := m.ctrl.Call(m, "GenerateToken", ctx, identity, ttl)
+	ret0, _ := ret[0].(*AccessToken)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GenerateToken indicates an expected call of GenerateToken.
-func (mr *MockTwilioClientMockRecorder) GenerateToken(ctx, identity any) *gomock.Call {
+func (mr *MockTwilioClientMockRecorder) GenerateToken(ctx, identity, ttl any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GenerateToken", reflect.TypeOf((*MockTwilioClient)(nil).GenerateToken), ctx, identity)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GenerateToken", reflect.TypeOf((*MockTwilioClient)(nil).GenerateToken), ctx, identity, ttl)
 }
 
 // GetConversationHistory mocks base method.
-func (m *MockTwilioClient) GetConversationHistory(ctx context.Context, conversationSID string) ([]*Message, error) {
+func (m *MockTwilioClient) GetConversationHistory(ctx context.Context, conversationSID string, q HistoryQuery) ([]*Message, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetConversationHistory", ctx, conversationSID)
+	ret := m.ctrl.Call(m, "GetConversationHistory", ctx, conversationSID, q)
 	ret0, _ := ret[0].([]*Message)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetConversationHistory indicates an expected call of GetConversationHistory.
-func (mr *MockTwilioClientMockRecorder) GetConversationHistory(ctx, conversationSID any) *gomock.Call {
+func (mr *MockTwilioClientMockRecorder) GetConversationHistory(ctx, conversationSID, q any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetConversationHistory", reflect.TypeOf((*MockTwilioClient)(nil).GetConversationHistory), ctx, conversationSID, q)
+}
+
+// ListParticipants mocks base method.
+func (m *MockTwilioClient) ListParticipants(ctx context.Context, conversationSID string) ([]*Participant, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListParticipants", ctx, conversationSID)
+	ret0, _ := ret[0].([]*Participant)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListParticipants indicates an expected call of ListParticipants.
+func (mr *MockTwilioClientMockRecorder) ListParticipants(ctx, conversationSID any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetConversationHistory", reflect.TypeOf((*MockTwilioClient)(nil).GetConversationHistory), ctx, conversationSID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListParticipants", reflect.TypeOf((*MockTwilioClient)(nil).ListParticipants), ctx, conversationSID)
 }
 
 // RemoveParticipant mocks base method.
@@ -112,3 +129,70 @@ func (mr *MockTwilioClientMockRecorder) RemoveParticipant(ctx, conversationSID,
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveParticipant", reflect.TypeOf((*MockTwilioClient)(nil).RemoveParticipant), ctx, conversationSID, participantSID)
 }
+
+// SendMessage mocks base method.
+func (m *MockTwilioClient) SendMessage(ctx context.Context, conversationSID, author, body string) (*Message, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SendMessage", ctx, conversationSID, author, body)
+	ret0, _ := ret[0].(*Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SendMessage indicates an expected call of SendMessage.
+func (mr *MockTwilioClientMockRecorder) SendMessage(ctx, conversationSID, author, body any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendMessage", reflect.TypeOf((*MockTwilioClient)(nil).SendMessage), ctx, conversationSID, author, body)
+}
+
+// MockRequestClient is a mock of RequestClient interface.
+type MockRequestClient struct {
+	ctrl     *gomock.Controller
+	recorder *MockRequestClientMockRecorder
+	isgomock struct{}
+}
+
+// MockRequestClientMockRecorder is the mock recorder for MockRequestClient.
+type MockRequestClientMockRecorder struct {
+	mock *MockRequestClient
+}
+
+// NewMockRequestClient creates a new mock instance.
+func NewMockRequestClient(ctrl *gomock.Controller) *MockRequestClient {
+	mock := &MockRequestClient{ctrl: ctrl}
+	mock.recorder = &MockRequestClientMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRequestClient) EXPECT() *MockRequestClientMockRecorder {
+	return m.recorder
+}
+
+// Escalate mocks base method.
+func (m *MockRequestClient) Escalate(ctx context.Context, twilioSID string, userID uuid.UUID) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Escalate", ctx, twilioSID, userID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Escalate indicates an expected call of Escalate.
+func (mr *MockRequestClientMockRecorder) Escalate(ctx, twilioSID, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Escalate", reflect.TypeOf((*MockRequestClient)(nil).Escalate), ctx, twilioSID, userID)
+}
+
+// ReportMessage mocks base method.
+func (m *MockRequestClient) ReportMessage(ctx context.Context, twilioSID, author string, sentAt time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReportMessage", ctx, twilioSID, author, sentAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReportMessage indicates an expected call of ReportMessage.
+func (mr *MockRequestClientMockRecorder) ReportMessage(ctx, twilioSID, author, sentAt any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReportMessage", reflect.TypeOf((*MockRequestClient)(nil).ReportMessage), ctx, twilioSID, author, sentAt)
+}
@@ -0,0 +1,38 @@
+package chat
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"project-sage/internal/auth"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TestRequestClient_SendsInternalToken checks escalations and message reports carry the token the RequestService's
+// internal routes require.
+func TestRequestClient_SendsInternalToken(t *testing.T) {
+	tokens := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens[r.URL.Path] = r.Header.Get(auth.InternalTokenHeader)
+		if r.URL.Path == "/internal/request/escalate" {
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer server.Close()
+
+	client := NewHTTPRequestClient(server.URL, "internal-secret", 0)
+	if err := client.Escalate(context.Background(), "CH123", uuid.New()); err != nil {
+		t.Fatalf("Escalate() returned unexpected error: %v", err)
+	}
+	if err := client.ReportMessage(context.Background(), "CH123", "expert", time.Now()); err != nil {
+		t.Fatalf("ReportMessage() returned unexpected error: %v", err)
+	}
+	for _, path := range []string{"/internal/request/escalate", "/internal/request/first-response"} {
+		if got := tokens[path]; got != "internal-secret" {
+			t.Errorf("%s: expected the internal token to be sent, got %q", path, got)
+		}
+	}
+}
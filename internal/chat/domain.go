@@ -1,6 +1,10 @@
 package chat
 
-import "time"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 // Message represents a single message from a Twilio conversation.
 type Message struct {
@@ -13,3 +17,48 @@ type Message struct {
 	// Timestamp is when the message was sent
 	Timestamp time.Time `json:"timestamp"`
 }
+
+// Participant is someone in a Twilio conversation: the user, the bot or an expert.
+type Participant struct {
+	// SID is Twilio's ID for this participant in this conversation
+	SID string `json:"sid"`
+	// Identity is who they are, the same string messages are authored as (eg. UserID, ExpertID or the bot)
+	Identity string `json:"identity"`
+}
+
+// HistoryQuery narrows a history fetch. The zero value means the whole conversation.
+type HistoryQuery struct {
+	// Limit keeps only the newest Limit messages. 0 means no limit.
+	Limit int
+	// Before and After only keep messages sent strictly before/after these times. Zero means unbounded.
+	Before time.Time
+	After  time.Time
+}
+
+// Apply filters msgs (oldest first) by the query. The result is still oldest first,
+// so with a limit it's the tail of the conversation, which is what a summary wants.
+func (q HistoryQuery) Apply(msgs []*Message) []*Message {
+	filtered := make([]*Message, 0, len(msgs))
+	for _, m := range msgs {
+		if !q.Before.IsZero() && !m.Timestamp.Before(q.Before) {
+			continue
+		}
+		if !q.After.IsZero() && !m.Timestamp.After(q.After) {
+			continue
+		}
+		filtered = append(filtered, m)
+	}
+	if q.Limit > 0 && len(filtered) > q.Limit {
+		filtered = filtered[len(filtered)-q.Limit:]
+	}
+	return filtered
+}
+
+// Conversation maps a user to the Twilio conversation created for them.
+type Conversation struct {
+	ConversationID        uuid.UUID `json:"conversation_id" db:"conversation_id"`
+	UserID                uuid.UUID `json:"user_id" db:"user_id"`
+	TwilioConversationSID string    `json:"twilio_conversation_sid" db:"twilio_conversation_sid"`
+	Status                string    `json:"status" db:"status"` // 'active' or 'closed'
+	CreatedAt             time.Time `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,11 @@
+package chat
+
+import "errors"
+
+// ErrNotFound is returned by the repository when a user has no active conversation.
+// The handler maps it to a 404 with errors.Is.
+var ErrNotFound = errors.New("conversation not found")
+
+// ErrAuthorNotAllowed is returned by PostMessage when asked to post as anyone but the bot.
+// The handler maps it to a 403.
+var ErrAuthorNotAllowed = errors.New("can only post as the bot")
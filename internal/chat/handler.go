@@ -1,25 +1,49 @@
 package chat
 
 import (
-	"encoding/json"
+	_ "embed"
+	"errors"
+	"fmt"
 	"net/http"
 	"project-sage/internal/domain"
+	"project-sage/internal/httputil"
+	"project-sage/internal/jsonbody"
+	"project-sage/internal/openapi"
+	"strconv"
+	"time"
+
+	"project-sage/internal/auth"
 
-	// "project-sage/internal/auth" // We'll need this for real auth
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 )
 
+// openAPISpec is the hand-written API contract, served at /openapi.json. Keep it in step with the routes and DTOs below.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
 // Handler is the HTTP API layer for the ChatGatewayService.
 type Handler struct {
-	service Service
+	service       Service
+	webhook       WebhookConfig
+	internalToken string // What the routes other services call expect in X-Internal-Token
 	// We also need a UserService client here to fetch user/expert profiles userSvcClient auth.UserServiceClient // (or similar)
 }
 
+// WebhookConfig is what we need to check Twilio's webhook signatures.
+// Without an AuthToken the webhook refuses every call, since an unsigned webhook could open requests on anyone's tokens.
+type WebhookConfig struct {
+	AuthToken string // The Twilio account's auth token
+	URL       string // The exact public URL the webhook is configured with in Twilio
+}
+
 // NewHandler creates a new handler.
-func NewHandler(s Service) *Handler {
+func NewHandler(s Service, webhook WebhookConfig, internalToken string) *Handler {
 	return &Handler{
-		service: s,
+		service:       s,
+		webhook:       webhook,
+		internalToken: internalToken,
 	}
 }
 
@@ -30,19 +54,36 @@ func (h *Handler) RegisterRoutes(r chi.Router) {
 	// The auth middleware will tell us which one they are.
 	r.Post("/chat/token", h.handleGenerateToken)
 
-	// Called by RequestService
-	r.Post("/chat/remove-bot", h.handleRemoveBot)
-	r.Post("/chat/add-expert", h.handleAddExpert)
+	// Lets the user app find its current conversation.
+	r.Get("/chat/conversations/active", h.handleGetActiveConversation)
+
+	// Service to service only, so they need the internal token.
+	r.Group(func(r chi.Router) {
+		r.Use(auth.RequireInternalToken(h.internalToken))
+
+		// Called by RequestService
+		r.Post("/chat/remove-bot", h.handleRemoveBot)
+		r.Post("/chat/add-expert", h.handleAddExpert)
+		r.Post("/chat/remove-expert", h.handleRemoveExpert)
+		r.Get("/chat/participants/{sid}", h.handleListParticipants)
 
-	// Called by LLMGatewayService
-	r.Get("/chat/history/{sid}", h.handleGetChatHistory)
+		// Called by LLMGatewayService
+		r.Get("/chat/history/{sid}", h.handleGetChatHistory)
+		r.Post("/chat/message", h.handlePostMessage)
+	})
 
+	// Called by Twilio for conversation events. Authenticated by signature, not by a user session.
+	r.Post("/chat/webhook", h.handleWebhook)
+
+	// The API contract, for client generation.
+	r.Get(openapi.Path, openapi.Handler(openAPISpec))
 }
 
 // --- DTOs ---
 
 type tokenResponse struct {
-	Token string `json:"token"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"` // So the app can fetch a new token before this one stops working
 }
 
 type addExpertRequest struct {
@@ -54,6 +95,12 @@ type removeBotRequest struct {
 	TwilioConversationSID string `json:"twilio_conversation_sid"`
 }
 
+type postMessageRequest struct {
+	TwilioConversationSID string `json:"twilio_conversation_sid"`
+	Author                string `json:"author"` // Optional, defaults to the bot, which is the only author allowed
+	Body                  string `json:"body"`
+}
+
 // handleGenerateToken generates a Twilio token for the authenticated user
 func (h *Handler) handleGenerateToken(w http.ResponseWriter, r *http.Request) {
 	// userID, userErr := auth.GetUserID(r.Context())
@@ -65,7 +112,7 @@ func (h *Handler) handleGenerateToken(w http.ResponseWriter, r *http.Request) {
 	expertID_str := r.URL.Query().Get("expert_id")
 	// --- End placeholder ---
 
-	var token string
+	var token *AccessToken
 	var err error
 
 	if userID_str != "" {
@@ -84,84 +131,236 @@ func (h *Handler) handleGenerateToken(w http.ResponseWriter, r *http.Request) {
 		token, err = h.service.GenerateExpertToken(r.Context(), fakeExpert)
 
 	} else {
-		writeError(w, http.StatusUnauthorized, "Not authorized")
+		httputil.WriteError(w, http.StatusUnauthorized, "Not authorized")
+		return
+	}
+
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not generate token")
 		return
 	}
 
+	httputil.WriteJSON(w, http.StatusOK, tokenResponse{Token: token.Token, ExpiresAt: token.ExpiresAt})
+}
+
+// handleGetActiveConversation returns the authenticated user's active conversation.
+func (h *Handler) handleGetActiveConversation(w http.ResponseWriter, r *http.Request) {
+	// userID, err := auth.GetUserID(r.Context())
+
+	// --- This is a placeholder for auth, same as the token endpoint ---
+	userID, err := uuid.Parse(r.URL.Query().Get("user_id"))
+	if err != nil {
+		httputil.WriteError(w, http.StatusUnauthorized, "Not authorized")
+		return
+	}
+	// --- End placeholder ---
+
+	convo, err := h.service.GetActiveConversation(r.Context(), userID)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "Could not generate token")
+		if errors.Is(err, ErrNotFound) {
+			httputil.WriteError(w, http.StatusNotFound, "No active conversation")
+			return
+		}
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not fetch conversation")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, tokenResponse{Token: token})
+	httputil.WriteJSON(w, http.StatusOK, convo)
 }
 
 // handleRemoveBot is an internal endpoint to remove the bot.
 func (h *Handler) handleRemoveBot(w http.ResponseWriter, r *http.Request) {
 	var req removeBotRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid request payload")
+	if err := jsonbody.Decode(w, r, &req); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid request payload")
 		return
 	}
 
 	err := h.service.RemoveBot(r.Context(), req.TwilioConversationSID)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "Could not remove bot")
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not remove bot")
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]string{"status": "bot_removed"})
+	httputil.WriteJSON(w, http.StatusOK, map[string]string{"status": "bot_removed"})
 }
 
 // handleAddExpert is an internal endpoint to add an expert.
 func (h *Handler) handleAddExpert(w http.ResponseWriter, r *http.Request) {
 	var req addExpertRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid request payload")
+	if err := jsonbody.Decode(w, r, &req); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid request payload")
 		return
 	}
 
 	expertID, err := uuid.Parse(req.ExpertID)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid expert_id format")
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid expert_id format")
 		return
 	}
 
 	err = h.service.AddExpert(r.Context(), req.TwilioConversationSID, expertID)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "Could not add expert")
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not add expert")
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]string{"status": "expert_added"})
+	httputil.WriteJSON(w, http.StatusOK, map[string]string{"status": "expert_added"})
 }
 
+// handleRemoveExpert is an internal endpoint to remove an expert, eg after a transfer. Same body as add-expert.
+func (h *Handler) handleRemoveExpert(w http.ResponseWriter, r *http.Request) {
+	var req addExpertRequest
+	if err := jsonbody.Decode(w, r, &req); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid request payload")
+		return
+	}
+
+	expertID, err := uuid.Parse(req.ExpertID)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid expert_id format")
+		return
+	}
+
+	if err := h.service.RemoveExpert(r.Context(), req.TwilioConversationSID, expertID); err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not remove expert")
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, map[string]string{"status": "expert_removed"})
+}
+
+// handleListParticipants is an internal endpoint listing who is in a conversation, eg to check an expert isn't
+// already in it before adding them.
+func (h *Handler) handleListParticipants(w http.ResponseWriter, r *http.Request) {
+	sid := chi.URLParam(r, "sid")
+	if sid == "" {
+		httputil.WriteError(w, http.StatusBadRequest, "Missing conversation SID")
+		return
+	}
+
+	participants, err := h.service.ListParticipants(r.Context(), sid)
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not fetch participants")
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, participants)
+}
+
+// maxHistoryLimit caps the limit query param, so one call can't ask Twilio for an unbounded page.
+const maxHistoryLimit = 1000
+
 // handleGetChatHistory is an internal endpoint for the LLMGatewayService.
+// Optional query params: limit (newest N messages), and before/after as RFC 3339 timestamps.
 func (h *Handler) handleGetChatHistory(w http.ResponseWriter, r *http.Request) {
 	// We get the SID from the URL path, eg /chat/history/CH123
 	sid := chi.URLParam(r, "sid")
 	if sid == "" {
-		writeError(w, http.StatusBadRequest, "Missing conversation SID")
+		httputil.WriteError(w, http.StatusBadRequest, "Missing conversation SID")
 		return
 	}
 
-	history, err := h.service.GetChatHistory(r.Context(), sid)
+	q, err := parseHistoryQuery(r)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "Could not fetch history")
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	writeJSON(w, http.StatusOK, history)
+	history, err := h.service.GetChatHistory(r.Context(), sid, q)
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not fetch history")
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, history)
 }
 
-// writeJSON is a helper function for sending json responses.
-func writeJSON(w http.ResponseWriter, status int, data interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	if data != nil {
-		json.NewEncoder(w).Encode(data)
+// parseHistoryQuery reads limit, before and after from the query string. Missing params stay zero.
+func parseHistoryQuery(r *http.Request) (HistoryQuery, error) {
+	var q HistoryQuery
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxHistoryLimit {
+			return q, fmt.Errorf("invalid 'limit', use a number from 1 to %d", maxHistoryLimit)
+		}
+		q.Limit = n
+	}
+	for name, dst := range map[string]*time.Time{"before": &q.Before, "after": &q.After} {
+		if v := r.URL.Query().Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return q, fmt.Errorf("invalid '%s', use an RFC 3339 timestamp", name)
+			}
+			*dst = t
+		}
+	}
+	if !q.Before.IsZero() && !q.After.IsZero() && !q.After.Before(q.Before) {
+		return q, errors.New("'after' must be before 'before'")
+	}
+	return q, nil
+}
+
+// maxWebhookBytes caps the webhook's form body. A message event is a few KB at most.
+const maxWebhookBytes = 64 << 10
+
+// handleWebhook receives Twilio Conversations events. Twilio only needs a 200 back, the JSON is for our logs and tests.
+func (h *Handler) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	if h.webhook.AuthToken == "" || h.webhook.URL == "" {
+		httputil.WriteError(w, http.StatusServiceUnavailable, "Webhook not configured")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxWebhookBytes)
+	if err := r.ParseForm(); err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid form body")
+		return
+	}
+	// PostForm is only the body, which is what Twilio signs. The query string is already part of the URL.
+	if !ValidateTwilioSignature(h.webhook.AuthToken, h.webhook.URL, r.PostForm, r.Header.Get("X-Twilio-Signature")) {
+		httputil.WriteError(w, http.StatusForbidden, "Invalid signature")
+		return
+	}
+
+	// We subscribe to more events than we handle, the rest are acknowledged and dropped.
+	if r.PostForm.Get("EventType") != EventMessageAdded {
+		httputil.WriteJSON(w, http.StatusOK, map[string]bool{"escalated": false})
+		return
+	}
+	ev, err := parseMessageAddedEvent(r.PostForm)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
 	}
+
+	escalated, err := h.service.HandleMessageAdded(r.Context(), ev)
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not handle event")
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, map[string]bool{"escalated": escalated})
 }
 
-// writeError is a helper for sending a standardized json error.
-func writeError(w http.ResponseWriter, status int, message string) {
-	writeJSON(w, status, map[string]string{"error": message})
+// handlePostMessage is an internal endpoint for injecting bot/system messages into a conversation.
+func (h *Handler) handlePostMessage(w http.ResponseWriter, r *http.Request) {
+	var req postMessageRequest
+	if err := jsonbody.Decode(w, r, &req); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid request payload")
+		return
+	}
+
+	if req.TwilioConversationSID == "" || req.Body == "" {
+		httputil.WriteError(w, http.StatusBadRequest, "twilio_conversation_sid and body are required")
+		return
+	}
+
+	// An empty author posts as the bot. The service knows the bot's identity.
+	msg, err := h.service.PostMessage(r.Context(), req.TwilioConversationSID, req.Author, req.Body)
+	if errors.Is(err, ErrAuthorNotAllowed) {
+		httputil.WriteError(w, http.StatusForbidden, "Messages can only be posted as the bot")
+		return
+	}
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not post message")
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusCreated, msg)
 }
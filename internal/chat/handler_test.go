@@ -3,20 +3,40 @@ package chat
 import (
 	"bytes"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"project-sage/internal/auth"
+	"project-sage/internal/openapi"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 	"go.uber.org/mock/gomock"
 )
 
+// testWebhook is the webhook config the handler tests sign their Twilio events with.
+var testWebhook = WebhookConfig{AuthToken: "test-auth-token", URL: "https://chat.example.com/chat/webhook"}
+
+// testInternalToken is the secret the handler under test expects on the service to service routes.
+const testInternalToken = "internal-secret"
+
+// newInternalRequest is httptest.NewRequest from another service, with the internal token.
+func newInternalRequest(method, target string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set(auth.InternalTokenHeader, testInternalToken)
+	return req
+}
+
 // setupHandlerTest initializes a router, mock service, and handler for testing.
 func setupHandlerTest(t *testing.T) (*chi.Mux, *MockService, *gomock.Controller) {
 	ctrl := gomock.NewController(t)
 	mockService := NewMockService(ctrl)
 
-	handler := NewHandler(mockService)
+	handler := NewHandler(mockService, testWebhook, testInternalToken)
 
 	r := chi.NewRouter()
 	handler.RegisterRoutes(r)
@@ -29,11 +49,12 @@ func TestHandleGenerateToken_UserSuccess(t *testing.T) {
 	defer ctrl.Finish()
 
 	expectedToken := "fake-user-token"
+	expiresAt := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
 
 	// expect the service's GenerateUserToken to be called
 	mockService.EXPECT().
 		GenerateUserToken(gomock.Any(), gomock.Any()).
-		Return(expectedToken, nil).
+		Return(&AccessToken{Token: expectedToken, ExpiresAt: expiresAt}, nil).
 		Times(1)
 
 	// Using the fake query param auth
@@ -51,6 +72,9 @@ func TestHandleGenerateToken_UserSuccess(t *testing.T) {
 	if respBody.Token != expectedToken {
 		t.Errorf("Expected token '%s', got '%s'", expectedToken, respBody.Token)
 	}
+	if !respBody.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("Expected expires_at %s, got %s", expiresAt, respBody.ExpiresAt)
+	}
 }
 
 func TestHandleAddExpert_Success(t *testing.T) {
@@ -69,7 +93,7 @@ func TestHandleAddExpert_Success(t *testing.T) {
 		Times(1)
 
 	bodyBytes, _ := json.Marshal(reqBody)
-	req := httptest.NewRequest("POST", "/chat/add-expert", bytes.NewBuffer(bodyBytes))
+	req := newInternalRequest("POST", "/chat/add-expert", bytes.NewBuffer(bodyBytes))
 	rr := httptest.NewRecorder()
 
 	r.ServeHTTP(rr, req)
@@ -79,6 +103,31 @@ func TestHandleAddExpert_Success(t *testing.T) {
 	}
 }
 
+func TestHandleListParticipants_Success(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	mockService.EXPECT().
+		ListParticipants(gomock.Any(), "CH123").
+		Return([]*Participant{{SID: "MB1", Identity: "user-1"}, {SID: "MB2", Identity: "LLM_BOT_IDENTITY"}}, nil).
+		Times(1)
+
+	req := newInternalRequest("GET", "/chat/participants/CH123", nil)
+	rr := httptest.NewRecorder()
+
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	var respBody []*Participant
+	json.NewDecoder(rr.Body).Decode(&respBody)
+	if len(respBody) != 2 || respBody[0].Identity != "user-1" || respBody[1].SID != "MB2" {
+		t.Errorf("Unexpected participants response: %+v", respBody)
+	}
+}
+
 func TestHandleGetChatHistory_Success(t *testing.T) {
 	r, mockService, ctrl := setupHandlerTest(t)
 	defer ctrl.Finish()
@@ -88,11 +137,11 @@ func TestHandleGetChatHistory_Success(t *testing.T) {
 
 	// Expect GetChatHistory to be called with the SID from the URL
 	mockService.EXPECT().
-		GetChatHistory(gomock.Any(), sid).
+		GetChatHistory(gomock.Any(), sid, HistoryQuery{}).
 		Return(expectedHistory, nil).
 		Times(1)
 
-	req := httptest.NewRequest("GET", "/chat/history/"+sid, nil)
+	req := newInternalRequest("GET", "/chat/history/"+sid, nil)
 	rr := httptest.NewRecorder()
 
 	r.ServeHTTP(rr, req)
@@ -107,3 +156,220 @@ func TestHandleGetChatHistory_Success(t *testing.T) {
 		t.Errorf("Unexpected history response")
 	}
 }
+
+func TestHandleGetChatHistory_Query(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	after := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	want := HistoryQuery{Limit: 20, After: after}
+	mockService.EXPECT().
+		GetChatHistory(gomock.Any(), "CH123", want).
+		Return([]*Message{}, nil).
+		Times(1)
+
+	req := newInternalRequest("GET", "/chat/history/CH123?limit=20&after=2024-05-01T10:00:00Z", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	// Bad params never reach the service.
+	for _, query := range []string{"limit=0", "limit=lots", "limit=100000", "before=yesterday", "after=2024-05-02T00:00:00Z&before=2024-05-01T00:00:00Z"} {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, newInternalRequest("GET", "/chat/history/CH123?"+query, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %q, got %d", query, rr.Code)
+		}
+	}
+}
+
+func TestHandlePostMessage_DefaultsToBot(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	reqBody := postMessageRequest{
+		TwilioConversationSID: "CH123",
+		Body:                  "Hello from the bot",
+	}
+
+	// No author in the payload. It's passed on empty and the service posts it as the bot.
+	mockService.EXPECT().
+		PostMessage(gomock.Any(), "CH123", "", "Hello from the bot").
+		Return(&Message{SID: "MSG1", Author: "LLM_BOT_IDENTITY", Content: "Hello from the bot"}, nil).
+		Times(1)
+
+	bodyBytes, _ := json.Marshal(reqBody)
+	req := newInternalRequest("POST", "/chat/message", bytes.NewBuffer(bodyBytes))
+	rr := httptest.NewRecorder()
+
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Errorf("Expected status %d, got %d", http.StatusCreated, rr.Code)
+	}
+
+	var respBody Message
+	json.NewDecoder(rr.Body).Decode(&respBody)
+	if respBody.SID != "MSG1" {
+		t.Errorf("Expected message SID 'MSG1', got '%s'", respBody.SID)
+	}
+}
+
+func TestHandlePostMessage_OtherAuthor(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	mockService.EXPECT().
+		PostMessage(gomock.Any(), "CH123", "some-user", "Hello").
+		Return(nil, ErrAuthorNotAllowed).
+		Times(1)
+
+	bodyBytes, _ := json.Marshal(postMessageRequest{TwilioConversationSID: "CH123", Author: "some-user", Body: "Hello"})
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, newInternalRequest("POST", "/chat/message", bytes.NewBuffer(bodyBytes)))
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, rr.Code)
+	}
+}
+
+func TestHandleInternalRoutes_RequireToken(t *testing.T) {
+	r, _, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	routes := []struct{ method, path string }{
+		{"POST", "/chat/remove-bot"},
+		{"POST", "/chat/add-expert"},
+		{"POST", "/chat/remove-expert"},
+		{"GET", "/chat/participants/CH123"},
+		{"GET", "/chat/history/CH123"},
+		{"POST", "/chat/message"},
+	}
+	for _, rt := range routes {
+		for _, token := range []string{"", "wrong"} {
+			req := httptest.NewRequest(rt.method, rt.path, strings.NewReader(`{}`))
+			if token != "" {
+				req.Header.Set(auth.InternalTokenHeader, token)
+			}
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+			if rr.Code != http.StatusUnauthorized {
+				t.Errorf("%s %s with token %q: expected status 401, got %d", rt.method, rt.path, token, rr.Code)
+			}
+		}
+	}
+}
+
+func TestHandlePostMessage_MissingBody(t *testing.T) {
+	r, _, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	bodyBytes, _ := json.Marshal(postMessageRequest{TwilioConversationSID: "CH123"})
+	req := newInternalRequest("POST", "/chat/message", bytes.NewBuffer(bodyBytes))
+	rr := httptest.NewRecorder()
+
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, rr.Code)
+	}
+}
+
+// postWebhook posts form as a Twilio event, signed with signingToken.
+func postWebhook(r http.Handler, form url.Values, signingToken string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/chat/webhook", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Twilio-Signature", twilioSignature(signingToken, testWebhook.URL, form))
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+// TestHandleWebhook_Escalates posts a signed onMessageAdded event with the trigger phrase through the real service,
+// and checks the RequestService is asked to escalate.
+func TestHandleWebhook_Escalates(t *testing.T) {
+	_, mockTwilio, mockRepo, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+	mockRequests := NewMockRequestClient(ctrl)
+
+	userID := uuid.New()
+	sid := "CH123"
+	sentAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	mockRequests.EXPECT().ReportMessage(gomock.Any(), sid, userID.String(), sentAt).Return(nil).Times(1)
+	mockRepo.EXPECT().GetConversationByUser(gomock.Any(), userID).Return(&Conversation{UserID: userID, TwilioConversationSID: sid}, nil).Times(1)
+	mockRequests.EXPECT().Escalate(gomock.Any(), sid, userID).Return(nil).Times(1)
+
+	handler := NewHandler(NewService(mockTwilio, mockRepo, mockRequests, "", "", 0), testWebhook, testInternalToken)
+	r := chi.NewRouter()
+	handler.RegisterRoutes(r)
+
+	form := url.Values{
+		"EventType":       {"onMessageAdded"},
+		"ConversationSid": {sid},
+		"MessageSid":      {"IM123"},
+		"Author":          {userID.String()},
+		"Body":            {"This isn't working, can I TALK TO A  HUMAN please?"},
+		"DateCreated":     {"2024-05-01T10:00:00Z"},
+	}
+	rr := postWebhook(r, form, testWebhook.AuthToken)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), `"escalated":true`) {
+		t.Errorf("Expected escalated=true, got %s", rr.Body.String())
+	}
+}
+
+// TestHandleWebhook_Rejected checks unsigned or badly signed calls never reach the service.
+func TestHandleWebhook_Rejected(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+	mockService.EXPECT().HandleMessageAdded(gomock.Any(), gomock.Any()).Times(0)
+
+	form := url.Values{"EventType": {"onMessageAdded"}, "ConversationSid": {"CH123"}, "Author": {"someone"}, "Body": {"talk to a human"}}
+	if rr := postWebhook(r, form, "wrong-token"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a bad signature, got %d", rr.Code)
+	}
+
+	// A body changed after signing doesn't match either.
+	req := httptest.NewRequest("POST", "/chat/webhook", strings.NewReader(form.Encode()+"&Extra=1"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Twilio-Signature", twilioSignature(testWebhook.AuthToken, testWebhook.URL, form))
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a tampered body, got %d", rr.Code)
+	}
+
+	// Without a configured token every call is refused.
+	unconfigured := chi.NewRouter()
+	NewHandler(mockService, WebhookConfig{}, testInternalToken).RegisterRoutes(unconfigured)
+	if rr := postWebhook(unconfigured, form, ""); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 without a webhook config, got %d", rr.Code)
+	}
+}
+
+// TestOpenAPISpec checks the served spec is valid OpenAPI and describes exactly the routes the handler registers.
+func TestOpenAPISpec(t *testing.T) {
+	r, _, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/openapi.json", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	if err := openapi.Validate(rr.Body.Bytes()); err != nil {
+		t.Fatalf("Served spec is not valid: %v", err)
+	}
+	missing, extra, err := openapi.Diff(r, rr.Body.Bytes())
+	if err != nil {
+		t.Fatalf("Diff() returned error: %v", err)
+	}
+	if len(missing) > 0 || len(extra) > 0 {
+		t.Errorf("Spec is out of step with the routes. Undocumented: %v. Documented but not routed: %v", missing, extra)
+	}
+}
@@ -0,0 +1,106 @@
+package chat
+
+//go:generate mockgen -destination=./repository_mock_test.go -package=chat -source=repository.go Repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"project-sage/internal/database"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Repository is the interface for the conversations table, which this service owns.
+type Repository interface {
+	// CreateConversation records a new user -> Twilio conversation mapping.
+	CreateConversation(ctx context.Context, convo *Conversation) error
+	// GetConversationByUser returns the user's most recent active conversation.
+	GetConversationByUser(ctx context.Context, userID uuid.UUID) (*Conversation, error)
+}
+
+// RepositoryOptions are the repository's optional settings. The zero value has no query timeout.
+type RepositoryOptions struct {
+	QueryTimeout time.Duration // How long each repository call gets before it fails with context.DeadlineExceeded. 0 means no limit
+}
+
+// postgresRepository is the concrete implementation of the Repository that uses Postgres.
+type postgresRepository struct {
+	db           *sql.DB       // database connection pool.
+	queryTimeout time.Duration // 0 means no limit
+}
+
+// NewPostgresRepository is the constructor for the repository. Each call gets database.DefaultQueryTimeout.
+func NewPostgresRepository(db *sql.DB) Repository {
+	return NewPostgresRepositoryWithOptions(db, RepositoryOptions{QueryTimeout: database.DefaultQueryTimeout})
+}
+
+// NewPostgresRepositoryWithOptions is the same as NewPostgresRepository, with the query timeout from opts.
+func NewPostgresRepositoryWithOptions(db *sql.DB, opts RepositoryOptions) Repository {
+	return &postgresRepository{
+		db:           db,
+		queryTimeout: max(opts.QueryTimeout, 0),
+	}
+}
+
+// CreateConversation inserts a new conversations row.
+func (pr *postgresRepository) CreateConversation(ctx context.Context, convo *Conversation) error {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	// Set the server side fields before insert.
+	convo.ConversationID = uuid.New()
+	convo.Status = "active"
+	convo.CreatedAt = time.Now().UTC()
+
+	query := `
+		INSERT INTO conversations
+			(conversation_id, user_id, twilio_conversation_sid, status, created_at)
+		VALUES
+			($1, $2, $3, $4, $5)
+	`
+	_, err := pr.db.ExecContext(ctx, query,
+		convo.ConversationID,
+		convo.UserID,
+		convo.TwilioConversationSID,
+		convo.Status,
+		convo.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("could not insert conversation: %w", err)
+	}
+
+	return nil
+}
+
+// GetConversationByUser fetches the newest active conversation for a user.
+func (pr *postgresRepository) GetConversationByUser(ctx context.Context, userID uuid.UUID) (*Conversation, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	var convo Conversation
+	query := `
+		SELECT conversation_id, user_id, twilio_conversation_sid, status, created_at
+		FROM conversations
+		WHERE user_id = $1 AND status = 'active'
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+
+	err := pr.db.QueryRowContext(ctx, query, userID).Scan(
+		&convo.ConversationID,
+		&convo.UserID,
+		&convo.TwilioConversationSID,
+		&convo.Status,
+		&convo.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("could not get conversation: %w", err)
+	}
+
+	return &convo, nil
+}
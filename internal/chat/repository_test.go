@@ -0,0 +1,120 @@
+package chat
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Shared connection for the conversations integration tests.
+// Unlike the other services, this package also has plain unit tests, so a missing TEST_DB_URL
+// only skips the tests in this file instead of exiting the whole run.
+var (
+	testDB     *sql.DB
+	testRepo   Repository
+	testUserID uuid.UUID // The user the conversations belong to.
+)
+
+// TestMain connects to the test database if one is configured, then runs every test in the package.
+func TestMain(m *testing.M) {
+	connStr := os.Getenv("TEST_DB_URL")
+	if connStr == "" {
+		log.Println("TEST_DB_URL not set. Skipping chat integration tests.")
+		os.Exit(m.Run())
+	}
+
+	var err error
+	testDB, err = sql.Open("pgx", connStr)
+	if err != nil {
+		log.Fatalf("Could not connect to test database: %v", err)
+	}
+
+	testRepo = NewPostgresRepository(testDB)
+
+	// conversations.user_id is a foreign key, so a user has to exist first.
+	if err := setupTestUser(); err != nil {
+		log.Fatalf("Could not set up test user: %v", err)
+	}
+
+	code := m.Run()
+
+	cleanTables()
+	testDB.Close()
+	os.Exit(code)
+}
+
+// setupTestUser creates the user that owns the test conversations.
+func setupTestUser() error {
+	cleanTables()
+	testUserID = uuid.New()
+	_, err := testDB.Exec(`
+		INSERT INTO users (user_id, firebase_auth_id, display_name, membership_tier, assistance_token_balance)
+		VALUES ($1, 'fb-chat-test-user', 'Chat Test User', 'free', 0)
+	`, testUserID)
+	return err
+}
+
+// cleanTables removes everything the tests created.
+func cleanTables() {
+	testDB.Exec("DELETE FROM conversations WHERE user_id IN (SELECT user_id FROM users WHERE firebase_auth_id = 'fb-chat-test-user')")
+	testDB.Exec("DELETE FROM users WHERE firebase_auth_id = 'fb-chat-test-user'")
+}
+
+// requireDB skips the test when there's no database.
+func requireDB(t *testing.T) {
+	t.Helper()
+	if testDB == nil {
+		t.Skip("TEST_DB_URL not set")
+	}
+}
+
+// TestCreateAndGetConversationByUser verifies the newest active conversation is returned.
+func TestCreateAndGetConversationByUser(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+	testDB.Exec("DELETE FROM conversations WHERE user_id = $1", testUserID)
+
+	older := &Conversation{UserID: testUserID, TwilioConversationSID: "CH-chat-test-old"}
+	if err := testRepo.CreateConversation(ctx, older); err != nil {
+		t.Fatalf("CreateConversation() returned error: %v", err)
+	}
+	if older.ConversationID == uuid.Nil {
+		t.Error("CreateConversation() did not assign a ConversationID")
+	}
+
+	// Make sure created_at is different.
+	time.Sleep(10 * time.Millisecond)
+
+	newer := &Conversation{UserID: testUserID, TwilioConversationSID: "CH-chat-test-new"}
+	if err := testRepo.CreateConversation(ctx, newer); err != nil {
+		t.Fatalf("CreateConversation() returned error: %v", err)
+	}
+
+	convo, err := testRepo.GetConversationByUser(ctx, testUserID)
+	if err != nil {
+		t.Fatalf("GetConversationByUser() returned error: %v", err)
+	}
+	if convo.TwilioConversationSID != "CH-chat-test-new" {
+		t.Errorf("Expected the newest conversation 'CH-chat-test-new', got '%s'", convo.TwilioConversationSID)
+	}
+	if convo.Status != "active" {
+		t.Errorf("Expected status 'active', got '%s'", convo.Status)
+	}
+}
+
+// TestGetConversationByUser_NotFound verifies the not found case.
+func TestGetConversationByUser_NotFound(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+
+	_, err := testRepo.GetConversationByUser(ctx, uuid.New())
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got: %v", err)
+	}
+}
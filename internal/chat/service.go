@@ -4,8 +4,10 @@ package chat
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"project-sage/internal/domain"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -13,10 +15,10 @@ import (
 // Service defines the business logic for the ChatGatewayService.
 type Service interface {
 	// Generates a Twilio token for a standard user.
-	GenerateUserToken(ctx context.Context, user *domain.User) (string, error)
+	GenerateUserToken(ctx context.Context, user *domain.User) (*AccessToken, error)
 
 	// Generates a Twilio token for an expert user.
-	GenerateExpertToken(ctx context.Context, expert *domain.Expert) (string, error)
+	GenerateExpertToken(ctx context.Context, expert *domain.Expert) (*AccessToken, error)
 
 	// Creates a new chat conversation and adds the user and bot.
 	CreateConversation(ctx context.Context, user *domain.User) (string, error)
@@ -24,43 +26,87 @@ type Service interface {
 	// Adds an expert to a conversation (called on accept).
 	AddExpert(ctx context.Context, twilioSID string, expertID uuid.UUID) error
 
+	// Removes an expert from a conversation (called when a request is transferred away from them).
+	RemoveExpert(ctx context.Context, twilioSID string, expertID uuid.UUID) error
+
 	// Removes the bot from a conversation (called on handoff).
 	RemoveBot(ctx context.Context, twilioSID string) error
 
-	// Fetches the chat history (called by LLMGatewayService).
-	GetChatHistory(ctx context.Context, twilioSID string) ([]*Message, error)
+	// Lists who is in a conversation (called before adding an expert, and for debugging).
+	ListParticipants(ctx context.Context, twilioSID string) ([]*Participant, error)
+
+	// Fetches the chat history (called by LLMGatewayService), narrowed by q.
+	GetChatHistory(ctx context.Context, twilioSID string, q HistoryQuery) ([]*Message, error)
+
+	// Posts a message into a conversation (called by LLMGatewayService for bot replies). An empty author posts as the bot, any author but the bot is ErrAuthorNotAllowed.
+	PostMessage(ctx context.Context, twilioSID, author, body string) (*Message, error)
+
+	// Returns the user's current conversation.
+	GetActiveConversation(ctx context.Context, userID uuid.UUID) (*Conversation, error)
+
+	// Handles a message reported by the Twilio webhook. Returns true if it escalated the chat to an expert.
+	HandleMessageAdded(ctx context.Context, ev *MessageAddedEvent) (bool, error)
 }
 
+// DefaultBotIdentity is the Twilio identity the LLM bot joins conversations as, unless configured otherwise.
+// The LLMGatewayService has to use the same identity to tell bot messages apart from the user's.
+const DefaultBotIdentity = "LLM_BOT_IDENTITY"
+
 // service is the concrete implementation of the Service interface.
 type service struct {
-	twilio TwilioClient
+	twilio        TwilioClient
+	repo          Repository    // Stores which conversation belongs to which user
+	requests      RequestClient // Client for the RequestService, for webhook escalations
+	botIdentity   string        // Twilio identity of the LLM bot
+	triggerPhrase string        // What the user types to be handed to an expert
+	tokenTTL      time.Duration // How long access tokens last
 }
 
 // NewService is the constructor for the ChatGatewayService.
-func NewService(twilio TwilioClient) Service {
+// botIdentity is the bot's Twilio identity; empty means DefaultBotIdentity.
+// triggerPhrase is the handoff phrase the webhook looks for; empty means DefaultTriggerPhrase.
+// tokenTTL is how long access tokens last; 0 or less means DefaultTokenTTL, and it's capped at MaxTokenTTL.
+func NewService(twilio TwilioClient, repo Repository, requests RequestClient, botIdentity, triggerPhrase string, tokenTTL time.Duration) Service {
+	if botIdentity == "" {
+		botIdentity = DefaultBotIdentity
+	}
+	if triggerPhrase == "" {
+		triggerPhrase = DefaultTriggerPhrase
+	}
+	if tokenTTL <= 0 {
+		tokenTTL = DefaultTokenTTL
+	} else if tokenTTL > MaxTokenTTL {
+		fmt.Printf("WARNING: token TTL %s is over Twilio's %s, using %s\n", tokenTTL, MaxTokenTTL, MaxTokenTTL)
+		tokenTTL = MaxTokenTTL
+	}
 	return &service{
-		twilio: twilio,
+		twilio:        twilio,
+		repo:          repo,
+		requests:      requests,
+		botIdentity:   botIdentity,
+		triggerPhrase: triggerPhrase,
+		tokenTTL:      tokenTTL,
 	}
 }
 
 // GenerateUserToken creates a token for a user.
 // The identity for Twilio will be the user's UUID.
-func (s *service) GenerateUserToken(ctx context.Context, user *domain.User) (string, error) {
+func (s *service) GenerateUserToken(ctx context.Context, user *domain.User) (*AccessToken, error) {
 	identity := user.UserID.String()
-	token, err := s.twilio.GenerateToken(ctx, identity)
+	token, err := s.twilio.GenerateToken(ctx, identity, s.tokenTTL)
 	if err != nil {
-		return "", fmt.Errorf("could not generate user token: %w", err)
+		return nil, fmt.Errorf("could not generate user token: %w", err)
 	}
 	return token, nil
 }
 
 // GenerateExpertToken creates a token for an expert.
 // The identity for Twilio will be the expert's UUID.
-func (s *service) GenerateExpertToken(ctx context.Context, expert *domain.Expert) (string, error) {
+func (s *service) GenerateExpertToken(ctx context.Context, expert *domain.Expert) (*AccessToken, error) {
 	identity := expert.ExpertID.String()
-	token, err := s.twilio.GenerateToken(ctx, identity)
+	token, err := s.twilio.GenerateToken(ctx, identity, s.tokenTTL)
 	if err != nil {
-		return "", fmt.Errorf("could not generate expert token: %w", err)
+		return nil, fmt.Errorf("could not generate expert token: %w", err)
 	}
 	return token, nil
 }
@@ -80,11 +126,17 @@ func (s *service) CreateConversation(ctx context.Context, user *domain.User) (st
 	}
 
 	// Add the llm as the second participant
-	if err := s.twilio.AddParticipant(ctx, convoSID, "LLM_BOT_IDENTITY"); err != nil {
+	if err := s.twilio.AddParticipant(ctx, convoSID, s.botIdentity); err != nil {
 		// Log this as a non fatal error for now, as the chat can proceed.
 		fmt.Printf("WARNING: Failed to add bot to new conversation %s: %v\n", convoSID, err)
 	}
 
+	// Remember the mapping so we can answer "which conversation belongs to this user" later.
+	convo := &Conversation{UserID: user.UserID, TwilioConversationSID: convoSID}
+	if err := s.repo.CreateConversation(ctx, convo); err != nil {
+		return "", fmt.Errorf("could not save conversation: %w", err)
+	}
+
 	return convoSID, nil
 }
 
@@ -93,14 +145,88 @@ func (s *service) AddExpert(ctx context.Context, twilioSID string, expertID uuid
 	return s.twilio.AddParticipant(ctx, twilioSID, expertID.String())
 }
 
+// RemoveExpert takes an expert out of a conversation. Experts join under their UUID, so that's the identity to remove.
+func (s *service) RemoveExpert(ctx context.Context, twilioSID string, expertID uuid.UUID) error {
+	return s.twilio.RemoveParticipant(ctx, twilioSID, expertID.String())
+}
+
 // RemoveBot removes the bot from the conversation.
 func (s *service) RemoveBot(ctx context.Context, twilioSID string) error {
-	// "LLM_BOT_IDENTITY" is the static identity we use for the bot.
-	// In a real implementation, this will come from config.
-	return s.twilio.RemoveParticipant(ctx, twilioSID, "LLM_BOT_IDENTITY")
+	return s.twilio.RemoveParticipant(ctx, twilioSID, s.botIdentity)
+}
+
+// ListParticipants fetches the conversation's participants from Twilio.
+func (s *service) ListParticipants(ctx context.Context, twilioSID string) ([]*Participant, error) {
+	participants, err := s.twilio.ListParticipants(ctx, twilioSID)
+	if err != nil {
+		return nil, fmt.Errorf("could not list participants: %w", err)
+	}
+	return participants, nil
 }
 
 // GetChatHistory fetches messages from Twilio.
-func (s *service) GetChatHistory(ctx context.Context, twilioSID string) ([]*Message, error) {
-	return s.twilio.GetConversationHistory(ctx, twilioSID)
+func (s *service) GetChatHistory(ctx context.Context, twilioSID string, q HistoryQuery) ([]*Message, error) {
+	return s.twilio.GetConversationHistory(ctx, twilioSID, q)
+}
+
+// PostMessage sends a message into the conversation. The bot's replies come through here.
+func (s *service) PostMessage(ctx context.Context, twilioSID, author, body string) (*Message, error) {
+	// Most callers are posting as the bot, so no author means the bot.
+	if author == "" {
+		author = s.botIdentity
+	}
+	// Posting as a user or expert would put words in their mouth, and the webhook would take them as theirs.
+	if author != s.botIdentity {
+		return nil, ErrAuthorNotAllowed
+	}
+	msg, err := s.twilio.SendMessage(ctx, twilioSID, author, body)
+	if err != nil {
+		return nil, fmt.Errorf("could not send message: %w", err)
+	}
+	return msg, nil
+}
+
+// GetActiveConversation is a passthrough to the repository.
+func (s *service) GetActiveConversation(ctx context.Context, userID uuid.UUID) (*Conversation, error) {
+	return s.repo.GetConversationByUser(ctx, userID)
+}
+
+// HandleMessageAdded looks at each new message in a chat. Every message that isn't the bot's is reported to the
+// RequestService for the first-response timer, and a message from the conversation's user containing the trigger
+// phrase escalates the chat to an expert.
+func (s *service) HandleMessageAdded(ctx context.Context, ev *MessageAddedEvent) (bool, error) {
+	if ev.Author == s.botIdentity {
+		return false, nil
+	}
+
+	// Best effort, a missed report only makes the SLA numbers a bit worse.
+	if err := s.requests.ReportMessage(ctx, ev.ConversationSID, ev.Author, ev.DateCreated); err != nil {
+		fmt.Printf("WARNING: Failed to report message %s in %s: %v\n", ev.MessageSID, ev.ConversationSID, err)
+	}
+
+	if !containsTriggerPhrase(ev.Body, s.triggerPhrase) {
+		return false, nil
+	}
+
+	// Only the user who owns the conversation can ask for an expert. The expert typing the phrase,
+	// or someone quoting it back, shouldn't open a request and charge the user a token.
+	userID, err := uuid.Parse(ev.Author)
+	if err != nil {
+		return false, nil
+	}
+	convo, err := s.repo.GetConversationByUser(ctx, userID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("could not look up conversation owner: %w", err)
+	}
+	if convo.TwilioConversationSID != ev.ConversationSID {
+		return false, nil
+	}
+
+	if err := s.requests.Escalate(ctx, ev.ConversationSID, userID); err != nil {
+		return false, fmt.Errorf("could not escalate conversation: %w", err)
+	}
+	return true, nil
 }
@@ -72,10 +72,10 @@ func (mr *MockServiceMockRecorder) CreateConversation(ctx, user any) *gomock.Cal
 }
 
 // GenerateExpertToken mocks base method.
-func (m *MockService) GenerateExpertToken(ctx context.Context, expert *domain.Expert) (string, error) {
+func (m *MockService) GenerateExpertToken(ctx context.Context, expert *domain.Expert) (*AccessToken, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GenerateExpertToken", ctx, expert)
-	ret0, _ := ret[0].(string)
+	ret0, _ := ret[0].(*AccessToken)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
@@ -87,10 +87,10 @@ func (mr *MockServiceMockRecorder) GenerateExpertToken(ctx, expert any) *gomock.
 }
 
 // GenerateUserToken mocks base method.
-func (m *MockService) GenerateUserToken(ctx context.Context, user *domain.User) (string, error) {
+func (m *MockService) GenerateUserToken(ctx context.Context, user *domain.User) (*AccessToken, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GenerateUserToken", ctx, user)
-	ret0, _ := ret[0].(string)
+	ret0, _ := ret[0].(*AccessToken)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
@@ -101,19 +101,79 @@ func (mr *MockServiceMockRecorder) GenerateUserToken(ctx, user any) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GenerateUserToken", reflect.TypeOf((*MockService)(nil).GenerateUserToken), ctx, user)
 }
 
+// GetActiveConversation mocks base method.
+func (m *MockService) GetActiveConversation(ctx context.Context, userID uuid.UUID) (*Conversation, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetActiveConversation", ctx, userID)
+	ret0, _ := ret[0].(*Conversation)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetActiveConversation indicates an expected call of GetActiveConversation.
+func (mr *MockServiceMockRecorder) GetActiveConversation(ctx, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetActiveConversation", reflect.TypeOf((*MockService)(nil).GetActiveConversation), ctx, userID)
+}
+
 // GetChatHistory mocks base method.
-func (m *MockService) GetChatHistory(ctx context.Context, twilioSID string) ([]*Message, error) {
+func (m *MockService) GetChatHistory(ctx context.Context, twilioSID string, q HistoryQuery) ([]*Message, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetChatHistory", ctx, twilioSID)
+	ret := m.ctrl.Call(m, "GetChatHistory", ctx, twilioSID, q)
 	ret0, _ := ret[0].([]*Message)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetChatHistory indicates an expected call of GetChatHistory.
-func (mr *MockServiceMockRecorder) GetChatHistory(ctx, twilioSID any) *gomock.Call {
+func (mr *MockServiceMockRecorder) GetChatHistory(ctx, twilioSID, q any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetChatHistory", reflect.TypeOf((*MockService)(nil).GetChatHistory), ctx, twilioSID, q)
+}
+
+// HandleMessageAdded mocks base method.
+func (m *MockService) HandleMessageAdded(ctx context.Context, ev *MessageAddedEvent) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HandleMessageAdded", ctx, ev)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// HandleMessageAdded indicates an expected call of HandleMessageAdded.
+func (mr *MockServiceMockRecorder) HandleMessageAdded(ctx, ev any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HandleMessageAdded", reflect.TypeOf((*MockService)(nil).HandleMessageAdded), ctx, ev)
+}
+
+// ListParticipants mocks base method.
+func (m *MockService) ListParticipants(ctx context.Context, twilioSID string) ([]*Participant, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListParticipants", ctx, twilioSID)
+	ret0, _ := ret[0].([]*Participant)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListParticipants indicates an expected call of ListParticipants.
+func (mr *MockServiceMockRecorder) ListParticipants(ctx, twilioSID any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetChatHistory", reflect.TypeOf((*MockService)(nil).GetChatHistory), ctx, twilioSID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListParticipants", reflect.TypeOf((*MockService)(nil).ListParticipants), ctx, twilioSID)
+}
+
+// PostMessage mocks base method.
+func (m *MockService) PostMessage(ctx context.Context, twilioSID, author, body string) (*Message, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PostMessage", ctx, twilioSID, author, body)
+	ret0, _ := ret[0].(*Message)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PostMessage indicates an expected call of PostMessage.
+func (mr *MockServiceMockRecorder) PostMessage(ctx, twilioSID, author, body any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PostMessage", reflect.TypeOf((*MockService)(nil).PostMessage), ctx, twilioSID, author, body)
 }
 
 // RemoveBot mocks base method.
@@ -129,3 +189,17 @@ func (mr *MockServiceMockRecorder) RemoveBot(ctx, twilioSID any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveBot", reflect.TypeOf((*MockService)(nil).RemoveBot), ctx, twilioSID)
 }
+
+// RemoveExpert mocks base method.
+func (m *MockService) RemoveExpert(ctx context.Context, twilioSID string, expertID uuid.UUID) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveExpert", ctx, twilioSID, expertID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveExpert indicates an expected call of RemoveExpert.
+func (mr *MockServiceMockRecorder) RemoveExpert(ctx, twilioSID, expertID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveExpert", reflect.TypeOf((*MockService)(nil).RemoveExpert), ctx, twilioSID, expertID)
+}
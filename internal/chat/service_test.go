@@ -2,45 +2,64 @@ package chat
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"project-sage/internal/domain"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"go.uber.org/mock/gomock"
 )
 
-func setupMocks(t *testing.T) (context.Context, *MockTwilioClient, *gomock.Controller) {
+func setupMocks(t *testing.T) (context.Context, *MockTwilioClient, *MockRepository, *gomock.Controller) {
 	ctrl := gomock.NewController(t)
-	return context.Background(), NewMockTwilioClient(ctrl), ctrl
+	return context.Background(), NewMockTwilioClient(ctrl), NewMockRepository(ctrl), ctrl
 }
 
 func TestService_GenerateUserToken_Success(t *testing.T) {
-	ctx, mockTwilio, ctrl := setupMocks(t)
+	ctx, mockTwilio, mockRepo, ctrl := setupMocks(t)
 	defer ctrl.Finish()
 
 	user := &domain.User{UserID: uuid.New()}
 	identity := user.UserID.String()
-	expectedToken := "ey...token"
+	expectedToken := &AccessToken{Token: "ey...token", ExpiresAt: time.Now().Add(DefaultTokenTTL)}
 
-	// Expect GenerateToken to be called with the user's uuid string
+	// Expect GenerateToken to be called with the user's uuid string and, with no TTL configured, the default
 	mockTwilio.EXPECT().
-		GenerateToken(ctx, identity).
+		GenerateToken(ctx, identity, DefaultTokenTTL).
 		Return(expectedToken, nil).
 		Times(1)
 
-	s := NewService(mockTwilio)
+	s := NewService(mockTwilio, mockRepo, nil, "", "", 0)
 	token, err := s.GenerateUserToken(ctx, user)
 
 	if err != nil {
 		t.Fatalf("GenerateUserToken() returned unexpected error: %v", err)
 	}
 	if token != expectedToken {
-		t.Errorf("want token '%s', got '%s'", expectedToken, token)
+		t.Errorf("want token %+v, got %+v", expectedToken, token)
+	}
+}
+
+// TestService_GenerateExpertToken_TTL checks the configured TTL goes to the client, capped at what Twilio allows.
+func TestService_GenerateExpertToken_TTL(t *testing.T) {
+	ctx, mockTwilio, mockRepo, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	expert := &domain.Expert{ExpertID: uuid.New()}
+	mockTwilio.EXPECT().GenerateToken(ctx, expert.ExpertID.String(), 15*time.Minute).Return(&AccessToken{}, nil)
+	mockTwilio.EXPECT().GenerateToken(ctx, expert.ExpertID.String(), MaxTokenTTL).Return(&AccessToken{}, nil)
+
+	for _, ttl := range []time.Duration{15 * time.Minute, 48 * time.Hour} {
+		if _, err := NewService(mockTwilio, mockRepo, nil, "", "", ttl).GenerateExpertToken(ctx, expert); err != nil {
+			t.Fatalf("GenerateExpertToken() returned unexpected error: %v", err)
+		}
 	}
 }
 
 func TestService_CreateConversation_Success(t *testing.T) {
-	ctx, mockTwilio, ctrl := setupMocks(t)
+	ctx, mockTwilio, mockRepo, ctrl := setupMocks(t)
 	defer ctrl.Finish()
 
 	user := &domain.User{UserID: uuid.New(), DisplayName: "Test User"}
@@ -66,9 +85,20 @@ func TestService_CreateConversation_Success(t *testing.T) {
 			AddParticipant(ctx, convoSID, "LLM_BOT_IDENTITY").
 			Return(nil).
 			Times(1),
+
+		// Save the user -> conversation mapping
+		mockRepo.EXPECT().
+			CreateConversation(ctx, gomock.Any()).
+			DoAndReturn(func(ctx context.Context, convo *Conversation) error {
+				if convo.UserID != user.UserID || convo.TwilioConversationSID != convoSID {
+					t.Errorf("CreateConversation got the wrong mapping: %+v", convo)
+				}
+				return nil
+			}).
+			Times(1),
 	)
 
-	s := NewService(mockTwilio)
+	s := NewService(mockTwilio, mockRepo, nil, "", "", 0)
 	sid, err := s.CreateConversation(ctx, user)
 
 	if err != nil {
@@ -80,7 +110,7 @@ func TestService_CreateConversation_Success(t *testing.T) {
 }
 
 func TestService_RemoveBot_Success(t *testing.T) {
-	ctx, mockTwilio, ctrl := setupMocks(t)
+	ctx, mockTwilio, mockRepo, ctrl := setupMocks(t)
 	defer ctrl.Finish()
 
 	convoSID := "CH-123"
@@ -92,7 +122,7 @@ func TestService_RemoveBot_Success(t *testing.T) {
 		Return(nil).
 		Times(1)
 
-	s := NewService(mockTwilio)
+	s := NewService(mockTwilio, mockRepo, nil, "", "", 0)
 	err := s.RemoveBot(ctx, convoSID)
 
 	if err != nil {
@@ -100,8 +130,107 @@ func TestService_RemoveBot_Success(t *testing.T) {
 	}
 }
 
+func TestService_CustomBotIdentity(t *testing.T) {
+	ctx, mockTwilio, mockRepo, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	convoSID := "CH-123"
+	botIdentity := "SAGE_BOT_2"
+
+	// Both removing the bot and posting without an author use the configured identity.
+	mockTwilio.EXPECT().
+		RemoveParticipant(ctx, convoSID, botIdentity).
+		Return(nil).
+		Times(1)
+	mockTwilio.EXPECT().
+		SendMessage(ctx, convoSID, botIdentity, "Hi").
+		Return(&Message{SID: "MSG1", Author: botIdentity, Content: "Hi"}, nil).
+		Times(1)
+
+	s := NewService(mockTwilio, mockRepo, nil, botIdentity, "", 0)
+	if err := s.RemoveBot(ctx, convoSID); err != nil {
+		t.Fatalf("RemoveBot() returned unexpected error: %v", err)
+	}
+	if _, err := s.PostMessage(ctx, convoSID, "", "Hi"); err != nil {
+		t.Fatalf("PostMessage() returned unexpected error: %v", err)
+	}
+}
+
+func TestService_RemoveExpert_Success(t *testing.T) {
+	ctx, mockTwilio, mockRepo, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	convoSID := "CH-123"
+	expertID := uuid.New()
+
+	// Experts join under their UUID, so that's the identity removed.
+	mockTwilio.EXPECT().
+		RemoveParticipant(ctx, convoSID, expertID.String()).
+		Return(nil).
+		Times(1)
+
+	s := NewService(mockTwilio, mockRepo, nil, "", "", 0)
+	if err := s.RemoveExpert(ctx, convoSID, expertID); err != nil {
+		t.Fatalf("RemoveExpert() returned unexpected error: %v", err)
+	}
+}
+
+func TestService_ListParticipants_Success(t *testing.T) {
+	ctx, mockTwilio, mockRepo, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	expected := []*Participant{{SID: "MB-1", Identity: "user-1"}, {SID: "MB-2", Identity: DefaultBotIdentity}}
+	mockTwilio.EXPECT().
+		ListParticipants(ctx, "CH-123").
+		Return(expected, nil).
+		Times(1)
+
+	s := NewService(mockTwilio, mockRepo, nil, "", "", 0)
+	participants, err := s.ListParticipants(ctx, "CH-123")
+
+	if err != nil {
+		t.Fatalf("ListParticipants() returned unexpected error: %v", err)
+	}
+	if len(participants) != 2 || participants[1].Identity != DefaultBotIdentity {
+		t.Errorf("Unexpected participants returned: %+v", participants)
+	}
+}
+
+func TestStubTwilioClient_ListParticipants(t *testing.T) {
+	ctx := context.Background()
+	stub := NewStubTwilioClient()
+	s := NewService(stub, nil, nil, "", "", 0)
+
+	// CreateConversation would need a repository, so add the participants directly.
+	user := uuid.New().String()
+	expert := uuid.New()
+	stub.AddParticipant(ctx, "CH-stub", user)
+	stub.AddParticipant(ctx, "CH-stub", DefaultBotIdentity)
+	stub.AddParticipant(ctx, "CH-stub", expert.String())
+	if err := s.RemoveBot(ctx, "CH-stub"); err != nil {
+		t.Fatalf("RemoveBot() returned unexpected error: %v", err)
+	}
+
+	participants, err := s.ListParticipants(ctx, "CH-stub")
+	if err != nil {
+		t.Fatalf("ListParticipants() returned unexpected error: %v", err)
+	}
+	if len(participants) != 2 || participants[0].Identity != user || participants[1].Identity != expert.String() {
+		t.Errorf("Expected the user and the expert, got %+v", participants)
+	}
+
+	// A conversation the stub never saw gets a static user and the bot.
+	participants, err = s.ListParticipants(ctx, "CH-unknown")
+	if err != nil {
+		t.Fatalf("ListParticipants() returned unexpected error: %v", err)
+	}
+	if len(participants) != 2 || participants[1].Identity != DefaultBotIdentity {
+		t.Errorf("Expected a user and the bot, got %+v", participants)
+	}
+}
+
 func TestService_GetChatHistory_Success(t *testing.T) {
-	ctx, mockTwilio, ctrl := setupMocks(t)
+	ctx, mockTwilio, mockRepo, ctrl := setupMocks(t)
 	defer ctrl.Finish()
 
 	convoSID := "CH-123"
@@ -109,12 +238,12 @@ func TestService_GetChatHistory_Success(t *testing.T) {
 
 	// Expect GetConversationHistory to be called
 	mockTwilio.EXPECT().
-		GetConversationHistory(ctx, convoSID).
+		GetConversationHistory(ctx, convoSID, HistoryQuery{}).
 		Return(expectedHistory, nil).
 		Times(1)
 
-	s := NewService(mockTwilio)
-	history, err := s.GetChatHistory(ctx, convoSID)
+	s := NewService(mockTwilio, mockRepo, nil, "", "", 0)
+	history, err := s.GetChatHistory(ctx, convoSID, HistoryQuery{})
 
 	if err != nil {
 		t.Fatalf("GetChatHistory() returned unexpected error: %v", err)
@@ -123,3 +252,138 @@ func TestService_GetChatHistory_Success(t *testing.T) {
 		t.Errorf("Unexpected history returned")
 	}
 }
+
+func TestService_GetChatHistory_PassesLimit(t *testing.T) {
+	ctx, mockTwilio, mockRepo, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	// The query goes to Twilio as is, so the paging happens there and not after fetching everything.
+	q := HistoryQuery{Limit: 50, Before: time.Now().UTC()}
+	mockTwilio.EXPECT().
+		GetConversationHistory(ctx, "CH-123", q).
+		Return([]*Message{}, nil).
+		Times(1)
+
+	s := NewService(mockTwilio, mockRepo, nil, "", "", 0)
+	if _, err := s.GetChatHistory(ctx, "CH-123", q); err != nil {
+		t.Fatalf("GetChatHistory() returned unexpected error: %v", err)
+	}
+}
+
+func TestService_PostMessage_Success(t *testing.T) {
+	ctx, mockTwilio, mockRepo, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	sent := &Message{SID: "MSG-1", Author: "LLM_BOT_IDENTITY", Content: "Try restarting the router."}
+
+	// Expect the message to go straight to Twilio
+	mockTwilio.EXPECT().
+		SendMessage(ctx, "CH-123", "LLM_BOT_IDENTITY", "Try restarting the router.").
+		Return(sent, nil).
+		Times(1)
+
+	s := NewService(mockTwilio, mockRepo, nil, "", "", 0)
+	msg, err := s.PostMessage(ctx, "CH-123", "LLM_BOT_IDENTITY", "Try restarting the router.")
+
+	if err != nil {
+		t.Fatalf("PostMessage() returned unexpected error: %v", err)
+	}
+	if msg.SID != "MSG-1" {
+		t.Errorf("want SID 'MSG-1', got '%s'", msg.SID)
+	}
+}
+
+func TestService_PostMessage_OtherAuthor(t *testing.T) {
+	ctx, mockTwilio, mockRepo, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	// Nothing reaches Twilio when the author isn't the bot.
+	mockTwilio.EXPECT().SendMessage(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	s := NewService(mockTwilio, mockRepo, nil, "", "", 0)
+	_, err := s.PostMessage(ctx, "CH-123", uuid.New().String(), "I take it all back.")
+	if !errors.Is(err, ErrAuthorNotAllowed) {
+		t.Fatalf("want ErrAuthorNotAllowed, got %v", err)
+	}
+}
+
+func TestStubTwilioClient_SendMessageAppearsInHistory(t *testing.T) {
+	ctx := context.Background()
+	stub := NewStubTwilioClient()
+
+	if _, err := stub.SendMessage(ctx, "CH-stub", "LLM_BOT_IDENTITY", "Hi there"); err != nil {
+		t.Fatalf("SendMessage() returned unexpected error: %v", err)
+	}
+
+	history, err := stub.GetConversationHistory(ctx, "CH-stub", HistoryQuery{})
+	if err != nil {
+		t.Fatalf("GetConversationHistory() returned unexpected error: %v", err)
+	}
+	last := history[len(history)-1]
+	if last.Content != "Hi there" || last.Author != "LLM_BOT_IDENTITY" {
+		t.Errorf("Expected the sent message at the end of the history, got %+v", last)
+	}
+}
+
+func TestHistoryQuery_Apply(t *testing.T) {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	var msgs []*Message
+	for i := 0; i < 5; i++ {
+		msgs = append(msgs, &Message{SID: fmt.Sprintf("MSG-%d", i), Timestamp: start.Add(time.Duration(i) * time.Minute)})
+	}
+
+	tests := []struct {
+		name string
+		q    HistoryQuery
+		want []string
+	}{
+		{"everything", HistoryQuery{}, []string{"MSG-0", "MSG-1", "MSG-2", "MSG-3", "MSG-4"}},
+		{"newest two", HistoryQuery{Limit: 2}, []string{"MSG-3", "MSG-4"}},
+		{"window", HistoryQuery{After: start, Before: start.Add(3 * time.Minute)}, []string{"MSG-1", "MSG-2"}},
+		{"window and limit", HistoryQuery{Limit: 1, Before: start.Add(3 * time.Minute)}, []string{"MSG-2"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.q.Apply(msgs)
+			if len(got) != len(tc.want) {
+				t.Fatalf("Expected %d messages, got %d", len(tc.want), len(got))
+			}
+			for i, m := range got {
+				if m.SID != tc.want[i] {
+					t.Errorf("Expected %s at %d, got %s", tc.want[i], i, m.SID)
+				}
+			}
+		})
+	}
+}
+
+// TestService_HandleMessageAdded_NoEscalation checks the bot, and anyone but the conversation's user, can't escalate.
+func TestService_HandleMessageAdded_NoEscalation(t *testing.T) {
+	ctx, mockTwilio, mockRepo, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+	mockRequests := NewMockRequestClient(ctrl)
+	s := NewService(mockTwilio, mockRepo, mockRequests, "", "get me an expert", 0)
+
+	expertID := uuid.New()
+	mockRequests.EXPECT().Escalate(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	// The bot isn't even reported.
+	mockRequests.EXPECT().ReportMessage(gomock.Any(), gomock.Any(), DefaultBotIdentity, gomock.Any()).Times(0)
+	if escalated, err := s.HandleMessageAdded(ctx, &MessageAddedEvent{ConversationSID: "CH-1", Author: DefaultBotIdentity, Body: "Say 'get me an expert' any time"}); err != nil || escalated {
+		t.Errorf("Expected the bot's message to be ignored, got escalated=%v err=%v", escalated, err)
+	}
+
+	// The expert is reported for the response timer, but has no conversation of their own, so no escalation.
+	mockRequests.EXPECT().ReportMessage(ctx, "CH-1", expertID.String(), time.Time{}).Return(nil).Times(1)
+	mockRepo.EXPECT().GetConversationByUser(ctx, expertID).Return(nil, ErrNotFound).Times(1)
+	if escalated, err := s.HandleMessageAdded(ctx, &MessageAddedEvent{ConversationSID: "CH-1", Author: expertID.String(), Body: "Get me an expert? I am one!"}); err != nil || escalated {
+		t.Errorf("Expected the expert's message not to escalate, got escalated=%v err=%v", escalated, err)
+	}
+
+	// The default phrase doesn't count once another is configured.
+	userID := uuid.New()
+	mockRequests.EXPECT().ReportMessage(ctx, "CH-1", userID.String(), time.Time{}).Return(nil).Times(1)
+	if escalated, err := s.HandleMessageAdded(ctx, &MessageAddedEvent{ConversationSID: "CH-1", Author: userID.String(), Body: "talk to a human"}); err != nil || escalated {
+		t.Errorf("Expected no escalation without the configured phrase, got escalated=%v err=%v", escalated, err)
+	}
+}
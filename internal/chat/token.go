@@ -0,0 +1,112 @@
+package chat
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// A Twilio access token is a JWT we sign ourselves with an API key's secret; Twilio is never called to make one.
+// Its grants say what the holder can do. Ours is a chat grant scoped to our Conversations service, so an app
+// token can't be used against any other service on the account.
+
+// DefaultTokenTTL is how long an access token lasts unless configured otherwise. The app refreshes it before expires_at.
+const DefaultTokenTTL = time.Hour
+
+// MaxTokenTTL is the longest lifetime Twilio accepts for an access token.
+const MaxTokenTTL = 24 * time.Hour
+
+// AccessToken is a Twilio access token and when it stops working.
+type AccessToken struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+// TokenConfig is the Twilio credentials access tokens are signed with.
+type TokenConfig struct {
+	AccountSID string // AC..., the token's subject
+	APIKey     string // SK..., the token's issuer
+	APISecret  string // The API key's secret, which signs the token
+	ServiceSID string // IS..., the Conversations service the chat grant is scoped to. Empty means the account's default
+}
+
+// accessTokenClient signs real access tokens and leaves everything else to the TwilioClient it wraps.
+type accessTokenClient struct {
+	TwilioClient
+	cfg TokenConfig
+	now func() time.Time // Swappable for tests.
+}
+
+// NewAccessTokenClient returns a TwilioClient whose GenerateToken signs a real access token with cfg,
+// with the rest of the calls going to next. That way the app can connect to Twilio with the stub still used for the REST calls.
+func NewAccessTokenClient(cfg TokenConfig, next TwilioClient) TwilioClient {
+	return &accessTokenClient{TwilioClient: next, cfg: cfg, now: time.Now}
+}
+
+// tokenClaims is the payload of a Twilio access token.
+type tokenClaims struct {
+	JTI    string      `json:"jti"`
+	Issuer string      `json:"iss"`
+	Sub    string      `json:"sub"`
+	Iat    int64       `json:"iat"`
+	Exp    int64       `json:"exp"`
+	Grants tokenGrants `json:"grants"`
+}
+
+type tokenGrants struct {
+	Identity string     `json:"identity"`
+	Chat     *chatGrant `json:"chat,omitempty"`
+}
+
+// chatGrant is the grant the Conversations SDK needs. It's still called chat, from before Conversations.
+type chatGrant struct {
+	ServiceSID string `json:"service_sid,omitempty"`
+}
+
+// GenerateToken signs a token for identity that lasts ttl.
+func (c *accessTokenClient) GenerateToken(ctx context.Context, identity string, ttl time.Duration) (*AccessToken, error) {
+	if c.cfg.AccountSID == "" || c.cfg.APIKey == "" || c.cfg.APISecret == "" {
+		return nil, errors.New("twilio account sid, api key and api secret are all required to sign a token")
+	}
+	now := c.now()
+	expires := now.Add(ttl)
+	claims := tokenClaims{
+		// Twilio wants the jti to start with the API key.
+		JTI:    fmt.Sprintf("%s-%d", c.cfg.APIKey, now.Unix()),
+		Issuer: c.cfg.APIKey,
+		Sub:    c.cfg.AccountSID,
+		Iat:    now.Unix(),
+		Exp:    expires.Unix(),
+		Grants: tokenGrants{
+			Identity: identity,
+			Chat:     &chatGrant{ServiceSID: c.cfg.ServiceSID},
+		},
+	}
+	token, err := signToken(c.cfg.APISecret, claims)
+	if err != nil {
+		return nil, err
+	}
+	return &AccessToken{Token: token, ExpiresAt: time.Unix(claims.Exp, 0)}, nil
+}
+
+// signToken encodes claims as an HS256 JWT signed with secret, with the content type Twilio looks for.
+func signToken(secret string, claims tokenClaims) (string, error) {
+	header, err := json.Marshal(map[string]string{"typ": "JWT", "alg": "HS256", "cty": "twilio-fpa;v=1"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("could not encode token claims: %w", err)
+	}
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + enc.EncodeToString(mac.Sum(nil)), nil
+}
@@ -0,0 +1,65 @@
+package chat
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAccessTokenClient_GenerateToken checks the token is signed with the API secret, lasts the TTL it was asked for,
+// and carries the identity and a chat grant for the configured service.
+func TestAccessTokenClient_GenerateToken(t *testing.T) {
+	cfg := TokenConfig{AccountSID: "AC123", APIKey: "SK123", APISecret: "secret", ServiceSID: "IS123"}
+	c := NewAccessTokenClient(cfg, nil).(*accessTokenClient)
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	tok, err := c.GenerateToken(context.Background(), "user-1", 15*time.Minute)
+	if err != nil {
+		t.Fatalf("GenerateToken() returned unexpected error: %v", err)
+	}
+	if want := now.Add(15 * time.Minute); !tok.ExpiresAt.Equal(want) {
+		t.Errorf("Expected expiry %s, got %s", want, tok.ExpiresAt)
+	}
+
+	parts := strings.Split(tok.Token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("Expected a JWT with 3 parts, got %d", len(parts))
+	}
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if parts[2] != base64.RawURLEncoding.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("Signature doesn't match the API secret")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatalf("Could not decode payload: %v", err)
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatalf("Could not decode claims: %v", err)
+	}
+	if claims.Exp-claims.Iat != int64((15 * time.Minute).Seconds()) {
+		t.Errorf("Expected the token to last 900s, got iat %d and exp %d", claims.Iat, claims.Exp)
+	}
+	if claims.Issuer != "SK123" || claims.Sub != "AC123" || !strings.HasPrefix(claims.JTI, "SK123-") {
+		t.Errorf("Unexpected issuer, subject or jti: %+v", claims)
+	}
+	if claims.Grants.Identity != "user-1" || claims.Grants.Chat == nil || claims.Grants.Chat.ServiceSID != "IS123" {
+		t.Errorf("Unexpected grants: %+v", claims.Grants)
+	}
+}
+
+// TestAccessTokenClient_MissingCredentials checks nothing is signed without the full set of credentials.
+func TestAccessTokenClient_MissingCredentials(t *testing.T) {
+	c := NewAccessTokenClient(TokenConfig{AccountSID: "AC123", APIKey: "SK123"}, nil)
+	if _, err := c.GenerateToken(context.Background(), "user-1", time.Hour); err == nil {
+		t.Error("Expected an error without an API secret")
+	}
+}
@@ -0,0 +1,93 @@
+package chat
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Twilio Conversations posts events to our webhook as form data, signed with the account's auth token.
+// We only care about onMessageAdded, which is how we see what's said in a chat without the client app telling us.
+
+// EventMessageAdded is the Twilio EventType for a new message in a conversation.
+const EventMessageAdded = "onMessageAdded"
+
+// DefaultTriggerPhrase is the handoff phrase unless configured otherwise. Matching is case insensitive.
+const DefaultTriggerPhrase = "talk to a human"
+
+// MessageAddedEvent is the part of an onMessageAdded webhook we use.
+type MessageAddedEvent struct {
+	ConversationSID string
+	MessageSID      string
+	Author          string // Twilio identity of the sender, the same UUIDs and bot identity we add participants as
+	Body            string
+	DateCreated     time.Time // Zero if Twilio didn't send it
+}
+
+// parseMessageAddedEvent reads an onMessageAdded event from the webhook's form values.
+func parseMessageAddedEvent(form url.Values) (*MessageAddedEvent, error) {
+	ev := &MessageAddedEvent{
+		ConversationSID: form.Get("ConversationSid"),
+		MessageSID:      form.Get("MessageSid"),
+		Author:          form.Get("Author"),
+		Body:            form.Get("Body"),
+	}
+	if ev.ConversationSID == "" || ev.Author == "" {
+		return nil, fmt.Errorf("onMessageAdded event is missing ConversationSid or Author")
+	}
+	if v := form.Get("DateCreated"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DateCreated %q: %w", v, err)
+		}
+		ev.DateCreated = t
+	}
+	return ev, nil
+}
+
+// twilioSignature computes the X-Twilio-Signature for a form POST: base64(HMAC-SHA1(authToken, url + params)),
+// where the params are appended as key then value, sorted by key.
+func twilioSignature(authToken, fullURL string, params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(fullURL)
+	for _, k := range keys {
+		values := append([]string(nil), params[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			b.WriteString(k)
+			b.WriteString(v)
+		}
+	}
+
+	mac := hmac.New(sha1.New, []byte(authToken))
+	mac.Write([]byte(b.String()))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ValidateTwilioSignature reports whether signature is Twilio's signature for this webhook call.
+// fullURL must be the exact public URL configured in Twilio, query string included, since that's what was signed.
+func ValidateTwilioSignature(authToken, fullURL string, params url.Values, signature string) bool {
+	if authToken == "" || signature == "" {
+		return false
+	}
+	expected := twilioSignature(authToken, fullURL, params)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// containsTriggerPhrase reports whether body contains the phrase, ignoring case and extra whitespace.
+func containsTriggerPhrase(body, phrase string) bool {
+	normalize := func(s string) string { return strings.Join(strings.Fields(strings.ToLower(s)), " ") }
+	p := normalize(phrase)
+	return p != "" && strings.Contains(normalize(body), p)
+}
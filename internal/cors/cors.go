@@ -0,0 +1,94 @@
+package cors
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// This package adds CORS headers so the web client can call the services cross-origin.
+// Every service wires it in the same way from the ALLOWED_ORIGINS env var.
+
+// Config controls which cross-origin requests are allowed.
+type Config struct {
+	AllowedOrigins   []string // Exact origins, e.g. "https://app.projectsage.com". "*" allows any origin.
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool // Lets the browser send cookies and auth headers. Ignored for "*".
+	MaxAge           int  // Seconds a browser may cache a preflight answer.
+}
+
+// DefaultConfig returns the methods and headers our APIs use, with no origins allowed.
+func DefaultConfig() Config {
+	return Config{
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", "Idempotency-Key"},
+		AllowCredentials: true,
+		MaxAge:           600,
+	}
+}
+
+// FromEnv builds the config from ALLOWED_ORIGINS, a comma separated list of origins.
+// If it's unset no origin is allowed, which is the safe default.
+func FromEnv() Config {
+	cfg := DefaultConfig()
+	for _, origin := range strings.Split(os.Getenv("ALLOWED_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			cfg.AllowedOrigins = append(cfg.AllowedOrigins, origin)
+		}
+	}
+	return cfg
+}
+
+// Middleware returns chi-compatible middleware that sets the CORS headers for allowed origins.
+// Preflight OPTIONS requests are answered with 204 right here and never reach the router.
+func Middleware(cfg Config) func(http.Handler) http.Handler {
+	allowAny := false
+	allowed := make(map[string]bool, len(cfg.AllowedOrigins))
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			allowAny = true
+		}
+		allowed[origin] = true
+	}
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	maxAge := strconv.Itoa(cfg.MaxAge)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			if origin != "" {
+				// The answer depends on the Origin, so caches must not share it across origins.
+				w.Header().Add("Vary", "Origin")
+
+				if allowAny || allowed[origin] {
+					if allowAny && !allowed[origin] {
+						// Browsers reject credentials with a wildcard, so "*" means no credentials.
+						w.Header().Set("Access-Control-Allow-Origin", "*")
+					} else {
+						w.Header().Set("Access-Control-Allow-Origin", origin)
+						if cfg.AllowCredentials {
+							w.Header().Set("Access-Control-Allow-Credentials", "true")
+						}
+					}
+					if preflight {
+						w.Header().Set("Access-Control-Allow-Methods", methods)
+						w.Header().Set("Access-Control-Allow-Headers", headers)
+						w.Header().Set("Access-Control-Max-Age", maxAge)
+					}
+				}
+			}
+
+			// A preflight is answered here either way. For a disallowed origin the missing headers make the browser block it.
+			if preflight {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// setupRouter builds a router with the CORS middleware and one GET route that always returns 200.
+func setupRouter(origins ...string) *chi.Mux {
+	cfg := DefaultConfig()
+	cfg.AllowedOrigins = origins
+
+	r := chi.NewRouter()
+	r.Use(Middleware(cfg))
+	r.Get("/thing", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return r
+}
+
+// get sends a GET /thing from the given origin.
+func get(r http.Handler, origin string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/thing", nil)
+	req.Header.Set("Origin", origin)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestMiddleware_AllowedOrigin(t *testing.T) {
+	r := setupRouter("https://app.example.com")
+
+	rr := get(r, "https://app.example.com")
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Expected the configured origin, got %q", got)
+	}
+	if rr.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Error("Expected credentials to be allowed")
+	}
+}
+
+func TestMiddleware_DisallowedOrigin(t *testing.T) {
+	r := setupRouter("https://app.example.com")
+
+	rr := get(r, "https://evil.example.com")
+
+	// The request itself still goes through, the browser is what blocks the response.
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Expected no Access-Control-Allow-Origin, got %q", got)
+	}
+}
+
+func TestMiddleware_Preflight(t *testing.T) {
+	r := setupRouter("https://app.example.com")
+
+	// There is no OPTIONS route, so without the short circuit chi would answer 405.
+	req := httptest.NewRequest("OPTIONS", "/thing", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", rr.Code)
+	}
+	if rr.Header().Get("Access-Control-Allow-Methods") == "" {
+		t.Error("Expected Access-Control-Allow-Methods on the preflight")
+	}
+}
+
+func TestMiddleware_Wildcard(t *testing.T) {
+	r := setupRouter("*")
+
+	rr := get(r, "https://anywhere.example.com")
+
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Expected '*', got %q", got)
+	}
+	if rr.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Error("Credentials must not be allowed with a wildcard origin")
+	}
+}
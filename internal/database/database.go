@@ -0,0 +1,118 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// This package opens the Postgres connection every service starts with. In a container deploy the database
+// often comes up after the services do, so Connect keeps pinging for a while instead of failing on the first try.
+// It also has the per-query deadline repositories put on their calls, so a hung query gives its connection back.
+
+const (
+	// DefaultConnectAttempts is how many pings Connect makes before giving up.
+	DefaultConnectAttempts = 10
+	// DefaultConnectDelay is the wait after the first failed ping. It doubles after each one, up to MaxConnectDelay.
+	DefaultConnectDelay = time.Second
+	// MaxConnectDelay caps the wait between pings.
+	MaxConnectDelay = 30 * time.Second
+)
+
+// RetryConfig is how hard Connect tries to reach the database.
+type RetryConfig struct {
+	Attempts int           // Pings before giving up. Anything below 1 means 1
+	Delay    time.Duration // Wait after the first failure, doubled after each one after that
+}
+
+// DefaultRetryConfig gives up after about a minute and a half.
+func DefaultRetryConfig() RetryConfig {
+	return RetryConfig{Attempts: DefaultConnectAttempts, Delay: DefaultConnectDelay}
+}
+
+// RetryConfigFromEnv reads DB_CONNECT_ATTEMPTS and DB_CONNECT_RETRY_DELAY (a Go duration such as "2s"),
+// falling back to the defaults for anything unset or invalid.
+func RetryConfigFromEnv() RetryConfig {
+	cfg := DefaultRetryConfig()
+	if v := os.Getenv("DB_CONNECT_ATTEMPTS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.Attempts = n
+		} else {
+			log.Printf("WARNING: invalid DB_CONNECT_ATTEMPTS=%q, using default %d", v, cfg.Attempts)
+		}
+	}
+	if v := os.Getenv("DB_CONNECT_RETRY_DELAY"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			cfg.Delay = d
+		} else {
+			log.Printf("WARNING: invalid DB_CONNECT_RETRY_DELAY=%q, using default %s", v, cfg.Delay)
+		}
+	}
+	return cfg
+}
+
+// Connect opens the database and pings it until it answers or cfg.Attempts pings have failed.
+// The error from the last ping is returned with the *sql.DB closed.
+func Connect(driverName, connStr string, cfg RetryConfig) (*sql.DB, error) {
+	db, err := sql.Open(driverName, connStr)
+	if err != nil {
+		return nil, err
+	}
+	attempts := max(cfg.Attempts, 1)
+	delay := cfg.Delay
+	for attempt := 1; ; attempt++ {
+		err = db.Ping()
+		if err == nil {
+			return db, nil
+		}
+		if attempt == attempts {
+			break
+		}
+		log.Printf("Database not ready (attempt %d of %d): %v. Retrying in %s", attempt, attempts, err, delay)
+		time.Sleep(delay)
+		delay = min(delay*2, MaxConnectDelay)
+	}
+	db.Close()
+	return nil, fmt.Errorf("database unreachable after %d attempts: %w", attempts, err)
+}
+
+// IsUniqueViolation reports whether err is a Postgres unique_violation (SQLSTATE 23505).
+// Both pgx and lib/pq errors expose SQLState(), so this doesn't tie us to one driver.
+func IsUniqueViolation(err error) bool {
+	var pgErr interface{ SQLState() string }
+	return errors.As(err, &pgErr) && pgErr.SQLState() == "23505"
+}
+
+// DefaultQueryTimeout is how long a repository call gets unless configured otherwise. Every query we run is
+// a lookup or a small write, so anything near this is stuck rather than slow.
+const DefaultQueryTimeout = 5 * time.Second
+
+// QueryTimeoutFromEnv reads DB_QUERY_TIMEOUT (a Go duration such as "3s"), falling back to DefaultQueryTimeout
+// if it's unset or invalid. "0" turns the timeout off.
+func QueryTimeoutFromEnv() time.Duration {
+	v := os.Getenv("DB_QUERY_TIMEOUT")
+	if v == "" {
+		return DefaultQueryTimeout
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("WARNING: invalid DB_QUERY_TIMEOUT=%q, using default %s", v, DefaultQueryTimeout)
+		return DefaultQueryTimeout
+	}
+	return d
+}
+
+// WithQueryTimeout returns ctx with a deadline timeout from now, for a repository to run its queries under.
+// A deadline already on ctx that's sooner still wins, and a timeout of 0 or less leaves ctx as it is.
+// The query fails with an error wrapping context.DeadlineExceeded once the deadline passes.
+func WithQueryTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
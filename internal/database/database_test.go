@@ -0,0 +1,150 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// flakyDriver refuses connections until it has been asked failures times, like a database that's still starting.
+type flakyDriver struct {
+	failures int
+	opens    int
+}
+
+func (d *flakyDriver) Open(name string) (driver.Conn, error) {
+	d.opens++
+	if d.opens <= d.failures {
+		return nil, errors.New("connection refused")
+	}
+	return flakyConn{}, nil
+}
+
+type flakyConn struct{}
+
+func (flakyConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not implemented")
+}
+func (flakyConn) Close() error              { return nil }
+func (flakyConn) Begin() (driver.Tx, error) { return nil, errors.New("not implemented") }
+
+// registerFlaky registers a fresh driver under the test's name, since sql.Register panics on a name it has seen.
+func registerFlaky(t *testing.T, failures int) (string, *flakyDriver) {
+	d := &flakyDriver{failures: failures}
+	sql.Register(t.Name(), d)
+	return t.Name(), d
+}
+
+func TestConnect_RetriesUntilReady(t *testing.T) {
+	name, d := registerFlaky(t, 3)
+	db, err := Connect(name, "", RetryConfig{Attempts: 5, Delay: time.Millisecond})
+	if err != nil {
+		t.Fatalf("Connect() returned unexpected error: %v", err)
+	}
+	defer db.Close()
+	if d.opens != 4 {
+		t.Errorf("Expected 4 connection attempts, got %d", d.opens)
+	}
+}
+
+func TestConnect_GivesUp(t *testing.T) {
+	name, d := registerFlaky(t, 10)
+	db, err := Connect(name, "", RetryConfig{Attempts: 3, Delay: time.Millisecond})
+	if err == nil {
+		db.Close()
+		t.Fatal("Expected an error once the attempts ran out")
+	}
+	if d.opens != 3 {
+		t.Errorf("Expected 3 connection attempts, got %d", d.opens)
+	}
+}
+
+// sqlStateError stands in for a driver error, which is all IsUniqueViolation looks at.
+type sqlStateError string
+
+func (e sqlStateError) Error() string    { return "sqlstate " + string(e) }
+func (e sqlStateError) SQLState() string { return string(e) }
+
+func TestIsUniqueViolation(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{sqlStateError("23505"), true},
+		{fmt.Errorf("insert failed: %w", sqlStateError("23505")), true},
+		{sqlStateError("23503"), false}, // foreign_key_violation
+		{errors.New("connection reset"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := IsUniqueViolation(tt.err); got != tt.want {
+			t.Errorf("IsUniqueViolation(%v) = %t, want %t", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRetryConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		attempts, delay string
+		want            RetryConfig
+	}{
+		{"", "", DefaultRetryConfig()},
+		{"5", "250ms", RetryConfig{Attempts: 5, Delay: 250 * time.Millisecond}},
+		{"0", "2", DefaultRetryConfig()}, // Too few attempts, and no unit on the delay
+	}
+	for _, tt := range tests {
+		t.Setenv("DB_CONNECT_ATTEMPTS", tt.attempts)
+		t.Setenv("DB_CONNECT_RETRY_DELAY", tt.delay)
+		if got := RetryConfigFromEnv(); got != tt.want {
+			t.Errorf("RetryConfigFromEnv() with %q, %q = %+v, want %+v", tt.attempts, tt.delay, got, tt.want)
+		}
+	}
+}
+
+func TestQueryTimeoutFromEnv(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", DefaultQueryTimeout},
+		{"750ms", 750 * time.Millisecond},
+		{"0", 0},
+		{"-1s", DefaultQueryTimeout},
+		{"3", DefaultQueryTimeout}, // No unit
+	}
+	for _, tt := range tests {
+		t.Setenv("DB_QUERY_TIMEOUT", tt.value)
+		if got := QueryTimeoutFromEnv(); got != tt.want {
+			t.Errorf("QueryTimeoutFromEnv() with %q = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestWithQueryTimeout(t *testing.T) {
+	ctx, cancel := WithQueryTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > time.Minute {
+		t.Errorf("Expected a deadline within a minute, got %s (set %t)", deadline, ok)
+	}
+
+	// A sooner deadline the caller set is kept.
+	parent, cancelParent := context.WithTimeout(context.Background(), time.Second)
+	defer cancelParent()
+	want, _ := parent.Deadline()
+	ctx, cancel = WithQueryTimeout(parent, time.Minute)
+	defer cancel()
+	if got, _ := ctx.Deadline(); !got.Equal(want) {
+		t.Errorf("Expected the caller's deadline %s to be kept, got %s", want, got)
+	}
+
+	// No timeout leaves the context alone.
+	ctx, cancel = WithQueryTimeout(context.Background(), 0)
+	defer cancel()
+	if _, ok := ctx.Deadline(); ok {
+		t.Error("Expected no deadline with a timeout of 0")
+	}
+}
@@ -14,8 +14,13 @@ type User struct {
 	ProfileImageURL        string    `json:"profile_image_url" db:"profile_image_url"`
 	MembershipTier         string    `json:"membership_tier" db:"membership_tier"`
 	AssistanceTokenBalance int       `json:"assistance_token_balance" db:"assistance_token_balance"`
+	GrantedTokenBalance    int       `json:"granted_token_balance" db:"granted_token_balance"` // The part of the balance the user was given, eg starter or monthly tokens
+	PurchasedTokenBalance  int       `json:"purchased_token_balance" db:"-"`                   // The part they paid for: the balance less the granted part
 	Role                   string    `json:"role" db:"role"`
 	StripeCustomerID       string    `json:"-" db:"stripe_customer_id"`
+	Version                int       `json:"version" db:"version"`       // Optimistic concurrency, bumped on every profile write
+	CreatedAt              time.Time `json:"created_at" db:"created_at"` // When they registered
+	UpdatedAt              time.Time `json:"updated_at" db:"updated_at"` // When the UserService last wrote the row
 }
 
 type Expert struct {
@@ -36,6 +41,7 @@ type Product struct {
 	StripePriceID   string `json:"-" db:"stripe_price_id"`
 	AppleProductID  string `json:"apple_product_id" db:"apple_product_id"`
 	GoogleProductID string `json:"google_product_id" db:"google_product_id"`
+	IsActive        bool   `json:"is_active" db:"is_active"` // Inactive products are hidden from the store
 }
 
 type Subscription struct {
@@ -68,6 +74,8 @@ type AssistanceRequest struct {
 	CreatedAt             time.Time     `json:"created_at" db:"created_at"`
 	AcceptedAt            sql.NullTime  `json:"accepted_at,omitempty" db:"accepted_at"` // Use sql.NullTime
 	ResolvedAt            sql.NullTime  `json:"resolved_at,omitempty" db:"resolved_at"` // Use sql.NullTime
+	FirstResponseAt       sql.NullTime  `json:"first_response_at,omitempty" db:"first_response_at"`
+	LastActivityAt        sql.NullTime  `json:"last_activity_at,omitempty" db:"last_activity_at"` // Last message, accept, transfer or reopen while active
 }
 
 // ExpertRating stores the 1-5 star rating
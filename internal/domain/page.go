@@ -0,0 +1,62 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// Page is one page of a list, in the same shape for every endpoint that pages, so the app can page through
+// any of them the same way. Pages go by offset: ask for the next one with offset=next_offset.
+type Page[T any] struct {
+	Items      []T  `json:"items"` // Never null, an empty page is []
+	Total      int  `json:"total"` // Everything in the list, across all pages
+	Limit      int  `json:"limit"`
+	Offset     int  `json:"offset"`
+	NextOffset *int `json:"next_offset,omitempty"` // Nil on the last page
+}
+
+// NewPage wraps items, the page starting at offset of a list total long. A nil items becomes empty.
+func NewPage[T any](items []T, total, limit, offset int) *Page[T] {
+	if items == nil {
+		items = []T{}
+	}
+	p := &Page[T]{Items: items, Total: total, Limit: limit, Offset: offset}
+	if next := offset + len(items); len(items) > 0 && next < total {
+		p.NextOffset = &next
+	}
+	return p
+}
+
+// Paginate cuts the page at offset out of all, for lists that are built in memory, eg a reordered queue.
+// A limit of 0 or less means the rest of the list.
+func Paginate[T any](all []T, limit, offset int) *Page[T] {
+	start := min(max(offset, 0), len(all))
+	end := len(all)
+	if limit > 0 {
+		end = min(start+limit, len(all))
+	}
+	return NewPage(all[start:end], len(all), limit, offset)
+}
+
+// ParsePage reads the limit and offset query params of a paged list. limit defaults to def and can't be over max.
+// The error says which param is wrong, for the 400.
+func ParsePage(q url.Values, def, max int) (limit, offset int, err error) {
+	limit = def
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > max {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", max)
+		}
+		limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, errors.New("offset must be a non-negative number")
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
@@ -0,0 +1,66 @@
+package domain
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+)
+
+// TestPaginate checks the pages of a list of five, two at a time, and past the end.
+func TestPaginate(t *testing.T) {
+	all := []int{1, 2, 3, 4, 5}
+	tests := []struct {
+		limit, offset int
+		want          string
+	}{
+		{2, 0, `{"items":[1,2],"total":5,"limit":2,"offset":0,"next_offset":2}`},
+		{2, 4, `{"items":[5],"total":5,"limit":2,"offset":4}`},
+		{2, 9, `{"items":[],"total":5,"limit":2,"offset":9}`},
+		{0, 1, `{"items":[2,3,4,5],"total":5,"limit":0,"offset":1}`},
+	}
+	for _, tt := range tests {
+		got, err := json.Marshal(Paginate(all, tt.limit, tt.offset))
+		if err != nil {
+			t.Fatalf("Marshal() returned error: %v", err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("Paginate(limit=%d, offset=%d) = %s, want %s", tt.limit, tt.offset, got, tt.want)
+		}
+	}
+}
+
+// TestNewPage_Empty checks an empty list is [] rather than null, so clients can range over it.
+func TestNewPage_Empty(t *testing.T) {
+	got, _ := json.Marshal(NewPage[string](nil, 0, 50, 0))
+	if want := `{"items":[],"total":0,"limit":50,"offset":0}`; string(got) != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+// TestParsePage checks the defaults, the cap on limit and the bad values that are a 400.
+func TestParsePage(t *testing.T) {
+	tests := []struct {
+		query                 string
+		wantLimit, wantOffset int
+		wantErr               bool
+	}{
+		{"", 20, 0, false},
+		{"limit=5&offset=10", 5, 10, false},
+		{"limit=50", 50, 0, false},
+		{"limit=51", 0, 0, true},
+		{"limit=0", 0, 0, true},
+		{"limit=abc", 0, 0, true},
+		{"offset=-1", 0, 0, true},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		limit, offset, err := ParsePage(q, 20, 50)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePage(%q) error = %v, wantErr %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if limit != tt.wantLimit || offset != tt.wantOffset {
+			t.Errorf("ParsePage(%q) = %d, %d, want %d, %d", tt.query, limit, offset, tt.wantLimit, tt.wantOffset)
+		}
+	}
+}
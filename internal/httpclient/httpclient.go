@@ -0,0 +1,52 @@
+package httpclient
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// This package builds the http.Clients our service clients use, so their timeouts are set in one place.
+// Every client shares one Transport, which pools connections and gives up on a host that won't connect
+// or finish a TLS handshake long before the whole-call timeout would.
+
+const (
+	// DialTimeout is how long opening a TCP connection may take.
+	DialTimeout = 5 * time.Second
+	// TLSHandshakeTimeout is how long the TLS handshake may take once connected.
+	TLSHandshakeTimeout = 5 * time.Second
+)
+
+// Transport is the transport every client from New uses.
+var Transport = NewTransport(DialTimeout, TLSHandshakeTimeout)
+
+// NewTransport returns a copy of http.DefaultTransport with the given connect and TLS handshake timeouts.
+func NewTransport(dialTimeout, tlsHandshakeTimeout time.Duration) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.DialContext = (&net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	t.TLSHandshakeTimeout = tlsHandshakeTimeout
+	return t
+}
+
+// New returns a client on the shared Transport. timeout covers the whole call, reading the body included.
+// 0 means no whole-call timeout, for clients that put the deadline on the request ctx instead.
+func New(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: Transport}
+}
+
+// TimeoutFromEnv reads a Go duration such as "5s" or "1m30s" from the environment variable name,
+// falling back to def if it's unset or invalid.
+func TimeoutFromEnv(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("WARNING: invalid %s=%q, using default %s", name, v, def)
+		return def
+	}
+	return d
+}
@@ -0,0 +1,39 @@
+package httpclient
+
+import (
+	"testing"
+	"time"
+)
+
+// TestNew checks the client gets the timeout it was built with and the shared transport.
+func TestNew(t *testing.T) {
+	c := New(3 * time.Second)
+	if c.Timeout != 3*time.Second {
+		t.Errorf("Expected timeout 3s, got %s", c.Timeout)
+	}
+	if c.Transport != Transport {
+		t.Error("Expected the shared transport")
+	}
+	if Transport.TLSHandshakeTimeout != TLSHandshakeTimeout {
+		t.Errorf("Expected TLS handshake timeout %s, got %s", TLSHandshakeTimeout, Transport.TLSHandshakeTimeout)
+	}
+}
+
+func TestTimeoutFromEnv(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 5 * time.Second},
+		{"750ms", 750 * time.Millisecond},
+		{"1m30s", 90 * time.Second},
+		{"10", 5 * time.Second}, // No unit, so not a duration
+		{"-1s", 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Setenv("TEST_CLIENT_TIMEOUT", tt.value)
+		if got := TimeoutFromEnv("TEST_CLIENT_TIMEOUT", 5*time.Second); got != tt.want {
+			t.Errorf("TimeoutFromEnv(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package httputil
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// This package is the one place responses get written, so every service answers the same way.
+// Errors are RFC 7807 problem details, sent as application/problem+json. Our problems are all "about:blank",
+// which means the status code says what kind of problem it is, and detail says what happened this time.
+
+// ProblemContentType is the media type of a problem details body.
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details body.
+type Problem struct {
+	Type   string `json:"type"`             // What kind of problem. "about:blank" means the status is all there is to know
+	Title  string `json:"title"`            // A short summary of the type. For about:blank, the status text
+	Status int    `json:"status"`           // The HTTP status code, repeated for anyone who only has the body
+	Detail string `json:"detail,omitempty"` // What went wrong this time, for a person to read
+	// Error is the same as Detail. Clients from before problem details read {"error": "..."}, so it stays for them.
+	Error string `json:"error"`
+	// Fields is what's wrong with each field of a request that failed validation, by its JSON name.
+	Fields map[string]string `json:"fields,omitempty"`
+	// Existing is, for a conflict, whatever is already there, so the client can carry on with it instead of retrying.
+	Existing any `json:"existing,omitempty"`
+}
+
+// NewProblem returns an about:blank problem for status, with detail as what went wrong.
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Error:  detail,
+	}
+}
+
+// WriteJSON sends data as JSON with the given status. A nil data sends no body.
+func WriteJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if data != nil {
+		json.NewEncoder(w).Encode(data)
+	}
+}
+
+// WriteProblem sends p with its own status.
+func WriteProblem(w http.ResponseWriter, p *Problem) {
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// WriteError sends an about:blank problem for status, with message as its detail.
+func WriteError(w http.ResponseWriter, status int, message string) {
+	WriteProblem(w, NewProblem(status, message))
+}
+
+// NotFound is for the router's NotFound, so an unknown route gets a problem too rather than a plain text 404.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, http.StatusNotFound, "Not found")
+}
+
+// MethodNotAllowed is for the router's MethodNotAllowed, which has already set the Allow header.
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, http.StatusMethodNotAllowed, "Method not allowed")
+}
+
+// Recoverer is middleware that turns a panic into a 500 problem, after logging it with its stack.
+// Like chi's, it leaves http.ErrAbortHandler alone, since that's net/http's way of dropping a connection on purpose.
+func Recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			log.Printf("PANIC serving %s %s: %v\n%s", r.Method, r.URL.Path, p, debug.Stack())
+			// If the handler had already sent its status this can't change it, but the log still says what happened.
+			WriteError(w, http.StatusInternalServerError, "Internal server error")
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,96 @@
+package httputil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestWriteError checks the problem's shape: the RFC 7807 members, plus error for older clients.
+func TestWriteError(t *testing.T) {
+	rr := httptest.NewRecorder()
+	WriteError(rr, http.StatusNotFound, "User not found")
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != ProblemContentType {
+		t.Errorf("Expected Content-Type %q, got %q", ProblemContentType, ct)
+	}
+	var body map[string]any
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatalf("Could not decode response: %v", err)
+	}
+	want := map[string]any{
+		"type":   "about:blank",
+		"title":  "Not Found",
+		"status": float64(404),
+		"detail": "User not found",
+		"error":  "User not found",
+	}
+	if len(body) != len(want) {
+		t.Errorf("Expected exactly %v, got %v", want, body)
+	}
+	for k, v := range want {
+		if body[k] != v {
+			t.Errorf("Expected %s = %v, got %v", k, v, body[k])
+		}
+	}
+}
+
+// TestWriteProblem checks fields only shows up when there are some.
+func TestWriteProblem(t *testing.T) {
+	p := NewProblem(http.StatusBadRequest, "validation failed")
+	p.Fields = map[string]string{"score": "must be 1-5"}
+	rr := httptest.NewRecorder()
+	WriteProblem(rr, p)
+
+	var got Problem
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("Could not decode response: %v", err)
+	}
+	if rr.Code != http.StatusBadRequest || got.Status != http.StatusBadRequest || got.Title != "Bad Request" {
+		t.Errorf("Expected a 400 Bad Request problem, got %d %+v", rr.Code, got)
+	}
+	if got.Fields["score"] != "must be 1-5" {
+		t.Errorf("Unexpected fields %v", got.Fields)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	rr := httptest.NewRecorder()
+	WriteJSON(rr, http.StatusCreated, map[string]int{"n": 1})
+	if rr.Code != http.StatusCreated || rr.Header().Get("Content-Type") != "application/json" || rr.Body.String() != "{\"n\":1}\n" {
+		t.Errorf("Unexpected response %d %q %q", rr.Code, rr.Header().Get("Content-Type"), rr.Body.String())
+	}
+
+	// No data, no body.
+	rr = httptest.NewRecorder()
+	WriteJSON(rr, http.StatusOK, nil)
+	if rr.Body.Len() != 0 {
+		t.Errorf("Expected no body, got %q", rr.Body.String())
+	}
+}
+
+// TestRecoverer checks a panic becomes a 500 problem, and ErrAbortHandler is passed on.
+func TestRecoverer(t *testing.T) {
+	h := Recoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusInternalServerError || rr.Header().Get("Content-Type") != ProblemContentType {
+		t.Errorf("Expected a 500 problem, got %d %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+
+	aborting := Recoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Errorf("Expected ErrAbortHandler to be re-raised, got %v", p)
+		}
+	}()
+	aborting.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
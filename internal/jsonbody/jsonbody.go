@@ -0,0 +1,98 @@
+package jsonbody
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// This package is the one place handlers decode JSON request bodies.
+// It caps the body size so a client can't make us buffer gigabytes, and it rejects
+// fields we don't know about so typos like "reqest_id" fail loudly instead of being ignored.
+
+// DefaultMaxBytes is the body limit when none is configured. Our payloads are all tiny, 1MB is plenty.
+const DefaultMaxBytes int64 = 1 << 20
+
+// ErrTooLarge means the body went over the limit. Handlers should answer 413.
+var ErrTooLarge = errors.New("request body too large")
+
+type contextKey string
+
+const maxBytesKey contextKey = "jsonBodyMaxBytes"
+
+// Limit is middleware that sets the body limit Decode uses for every request under it.
+func Limit(maxBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), maxBytesKey, maxBytes)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// MaxBytesFromEnv reads MAX_REQUEST_BODY_BYTES, falling back to DefaultMaxBytes if it's unset or invalid.
+func MaxBytesFromEnv() int64 {
+	v := os.Getenv("MAX_REQUEST_BODY_BYTES")
+	if v == "" {
+		return DefaultMaxBytes
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 {
+		log.Printf("WARNING: invalid MAX_REQUEST_BODY_BYTES=%q, using default %d", v, DefaultMaxBytes)
+		return DefaultMaxBytes
+	}
+	return n
+}
+
+// maxBytes returns the limit set by Limit, or the default if the route isn't wrapped.
+func maxBytes(r *http.Request) int64 {
+	if n, ok := r.Context().Value(maxBytesKey).(int64); ok && n > 0 {
+		return n
+	}
+	return DefaultMaxBytes
+}
+
+// Decode reads exactly one JSON value from the body into dst.
+// It returns ErrTooLarge if the body is over the limit, and a plain error for bad JSON,
+// unknown fields or trailing data. Use StatusCode to turn the error into a response code.
+func Decode(w http.ResponseWriter, r *http.Request, dst any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes(r))
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return decodeError(err)
+	}
+
+	// Anything after the first value, eg `{...}{...}`, is a malformed request too.
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		if err == nil {
+			return errors.New("invalid JSON body: body must contain a single JSON value")
+		}
+		return decodeError(err)
+	}
+	return nil
+}
+
+// decodeError maps the size limit to ErrTooLarge and wraps everything else.
+func decodeError(err error) error {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return ErrTooLarge
+	}
+	return fmt.Errorf("invalid JSON body: %w", err)
+}
+
+// StatusCode is the response code for an error from Decode: 413 if the body was too large, otherwise 400.
+func StatusCode(err error) int {
+	if errors.Is(err, ErrTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,99 @@
+package jsonbody
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type testPayload struct {
+	Name string `json:"name"`
+}
+
+// setupRouter builds a router with one POST route that decodes a testPayload and echoes the status.
+// A limit of 0 leaves the Limit middleware off, so the default applies.
+func setupRouter(limit int64) *chi.Mux {
+	r := chi.NewRouter()
+	if limit > 0 {
+		r.Use(Limit(limit))
+	}
+	r.Post("/thing", func(w http.ResponseWriter, r *http.Request) {
+		var p testPayload
+		if err := Decode(w, r, &p); err != nil {
+			w.WriteHeader(StatusCode(err))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	return r
+}
+
+// post sends body to POST /thing.
+func post(r http.Handler, body []byte) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("POST", "/thing", bytes.NewReader(body)))
+	return rr
+}
+
+func TestDecode_Valid(t *testing.T) {
+	if rr := post(setupRouter(0), []byte(`{"name":"sage"}`)); rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", rr.Code)
+	}
+}
+
+func TestDecode_TooLarge(t *testing.T) {
+	// A 10MB string field, well over the 1MB default.
+	body := []byte(`{"name":"` + strings.Repeat("a", 10<<20) + `"}`)
+
+	if rr := post(setupRouter(0), body); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status 413, got %d", rr.Code)
+	}
+}
+
+func TestDecode_CustomLimit(t *testing.T) {
+	r := setupRouter(16)
+
+	if rr := post(r, []byte(`{"name":"a"}`)); rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 under the limit, got %d", rr.Code)
+	}
+	if rr := post(r, []byte(`{"name":"this is longer than sixteen bytes"}`)); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status 413 over the limit, got %d", rr.Code)
+	}
+}
+
+func TestDecode_Rejected(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"unknown field", `{"name":"sage","nmae":"typo"}`},
+		{"malformed", `{"name":`},
+		{"empty", ``},
+		{"trailing value", `{"name":"a"}{"name":"b"}`},
+	}
+
+	r := setupRouter(0)
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if rr := post(r, []byte(tc.body)); rr.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400, got %d", rr.Code)
+			}
+		})
+	}
+}
+
+func TestMaxBytesFromEnv(t *testing.T) {
+	t.Setenv("MAX_REQUEST_BODY_BYTES", "2048")
+	if got := MaxBytesFromEnv(); got != 2048 {
+		t.Errorf("Expected 2048, got %d", got)
+	}
+
+	t.Setenv("MAX_REQUEST_BODY_BYTES", "lots")
+	if got := MaxBytesFromEnv(); got != DefaultMaxBytes {
+		t.Errorf("Expected the default for an invalid value, got %d", got)
+	}
+}
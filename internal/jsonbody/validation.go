@@ -0,0 +1,63 @@
+package jsonbody
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"project-sage/internal/httputil"
+)
+
+// ValidationError collects what's wrong with each field of a payload that decoded fine but doesn't make sense,
+// so the client gets every problem in one response instead of fixing them one 400 at a time.
+// The zero value is ready to use. Add to it while checking, then return Err.
+type ValidationError struct {
+	Fields map[string]string // Field name as it is in the JSON, to what's wrong with it
+}
+
+// Add records msg against field. The first message for a field wins, since the checks usually go from
+// "is required" to the finer ones.
+func (v *ValidationError) Add(field, msg string) {
+	if v.Fields == nil {
+		v.Fields = make(map[string]string)
+	}
+	if _, ok := v.Fields[field]; !ok {
+		v.Fields[field] = msg
+	}
+}
+
+// Err returns v if any field was added and nil otherwise, so a Validate method can end with return v.Err().
+func (v *ValidationError) Err() error {
+	if len(v.Fields) == 0 {
+		return nil
+	}
+	return v
+}
+
+// Error lists the fields in order, eg "validation failed: receipt_data is required, score must be 1-5".
+func (v *ValidationError) Error() string {
+	names := make([]string, 0, len(v.Fields))
+	for name := range v.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + " " + v.Fields[name]
+	}
+	return "validation failed: " + strings.Join(parts, ", ")
+}
+
+// WriteValidationError answers 400 with a problem whose detail is "validation failed" for a ValidationError,
+// with what's wrong with each field in fields. Any other error still gets a 400 problem, with its message as the detail.
+func WriteValidationError(w http.ResponseWriter, err error) {
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		p := httputil.NewProblem(http.StatusBadRequest, "validation failed")
+		p.Fields = verr.Fields
+		httputil.WriteProblem(w, p)
+		return
+	}
+	httputil.WriteError(w, http.StatusBadRequest, err.Error())
+}
@@ -0,0 +1,63 @@
+package jsonbody
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidationError(t *testing.T) {
+	var v ValidationError
+	if err := v.Err(); err != nil {
+		t.Fatalf("Expected nil with no fields, got %v", err)
+	}
+
+	v.Add("score", "is required")
+	v.Add("score", "must be 1-5") // The first message stays.
+	v.Add("expert_id", "must be a uuid")
+
+	err := v.Err()
+	if err == nil {
+		t.Fatal("Expected an error with fields added")
+	}
+	if got := err.Error(); got != "validation failed: expert_id must be a uuid, score is required" {
+		t.Errorf("Unexpected message %q", got)
+	}
+}
+
+func TestWriteValidationError(t *testing.T) {
+	var v ValidationError
+	v.Add("score", "must be 1-5")
+	v.Add("request_id", "must be a uuid")
+
+	rr := httptest.NewRecorder()
+	WriteValidationError(rr, v.Err())
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", rr.Code)
+	}
+	var body struct {
+		Error  string            `json:"error"`
+		Fields map[string]string `json:"fields"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatalf("Could not decode response: %v", err)
+	}
+	if body.Error != "validation failed" {
+		t.Errorf("Expected error 'validation failed', got %q", body.Error)
+	}
+	if len(body.Fields) != 2 || body.Fields["score"] != "must be 1-5" || body.Fields["request_id"] != "must be a uuid" {
+		t.Errorf("Unexpected fields %v", body.Fields)
+	}
+
+	// Anything else keeps the single message shape.
+	rr = httptest.NewRecorder()
+	WriteValidationError(rr, errors.New("something else"))
+	var plain map[string]any
+	json.NewDecoder(rr.Body).Decode(&plain)
+	if rr.Code != http.StatusBadRequest || plain["error"] != "something else" || plain["fields"] != nil {
+		t.Errorf("Expected a plain 400 error, got %d %v", rr.Code, plain)
+	}
+}
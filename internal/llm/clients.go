@@ -3,10 +3,13 @@ package llm
 //go:generate mockgen -destination=./clients_mock_test.go -package=llm -source=clients.go
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"project-sage/internal/auth"
+	"project-sage/internal/httpclient"
 	"time"
 )
 
@@ -20,7 +23,10 @@ type GeminiClient interface {
 
 // ChatGatewayClient defines the contract the client that talks to the ChatGatewayService.
 type ChatGatewayClient interface {
-	GetChatHistory(ctx context.Context, twilioSID string) ([]*ChatMessage, error)
+	// GetChatHistory fetches the newest limit messages of a conversation, oldest first. 0 means all of them.
+	GetChatHistory(ctx context.Context, twilioSID string, limit int) ([]*ChatMessage, error)
+	// PostMessage posts the bot's reply into the conversation.
+	PostMessage(ctx context.Context, twilioSID, content string) error
 }
 
 // stubGeminiClient is a fake GeminiClient.
@@ -44,6 +50,133 @@ func (s *stubGeminiClient) Summarize(ctx context.Context, history []*ChatMessage
 	return "User needs help with their Wi-Fi.", nil
 }
 
+// GeminiConfig configures the real Gemini client.
+type GeminiConfig struct {
+	APIKey  string
+	Model   string        // e.g. "gemini-1.5-flash"
+	BaseURL string        // Defaults to the public API. Tests point this at an httptest server.
+	Timeout time.Duration // Per-call cap on top of whatever deadline ctx already has. Zero means 60s.
+}
+
+// httpGeminiClient is the real GeminiClient, talking to the generateContent REST endpoint.
+type httpGeminiClient struct {
+	httpClient *http.Client
+	cfg        GeminiConfig
+}
+
+// NewHTTPGeminiClient is the constructor for the real Gemini client.
+func NewHTTPGeminiClient(cfg GeminiConfig) GeminiClient {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = "https://generativelanguage.googleapis.com"
+	}
+	if cfg.Model == "" {
+		cfg.Model = "gemini-1.5-flash"
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 60 * time.Second
+	}
+	return &httpGeminiClient{
+		// The timeout lives on the ctx, not here, so a cancelled caller aborts the call right away.
+		httpClient: httpclient.New(0),
+		cfg:        cfg,
+	}
+}
+
+// DTOs for the Gemini generateContent API.
+type geminiPart struct {
+	Text string `json:"text"`
+}
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+type geminiRequest struct {
+	SystemInstruction *geminiContent  `json:"systemInstruction,omitempty"`
+	Contents          []geminiContent `json:"contents"`
+}
+type geminiResponse struct {
+	Candidates []struct {
+		Content geminiContent `json:"content"`
+	} `json:"candidates"`
+}
+
+// summarizeInstruction tells the model what kind of summary the expert queue needs.
+const summarizeInstruction = "Summarize the user's problem from this support conversation in one or two sentences for the human expert who will take it over."
+
+// GenerateContent sends the history to Gemini and returns the model's reply.
+func (c *httpGeminiClient) GenerateContent(ctx context.Context, history []*ChatMessage) (*ChatMessage, error) {
+	contents := make([]geminiContent, len(history))
+	for i, msg := range history {
+		contents[i] = geminiContent{Role: msg.Role, Parts: []geminiPart{{Text: msg.Content}}}
+	}
+
+	text, err := c.generate(ctx, geminiRequest{Contents: contents})
+	if err != nil {
+		return nil, err
+	}
+	return &ChatMessage{Role: "model", Content: text}, nil
+}
+
+// Summarize flattens the history into one transcript and asks Gemini to summarize it.
+func (c *httpGeminiClient) Summarize(ctx context.Context, history []*ChatMessage) (string, error) {
+	var transcript bytes.Buffer
+	for _, msg := range history {
+		fmt.Fprintf(&transcript, "%s: %s\n", msg.Role, msg.Content)
+	}
+
+	return c.generate(ctx, geminiRequest{
+		SystemInstruction: &geminiContent{Parts: []geminiPart{{Text: summarizeInstruction}}},
+		Contents:          []geminiContent{{Role: "user", Parts: []geminiPart{{Text: transcript.String()}}}},
+	})
+}
+
+// generate makes the generateContent call and returns the text of the first candidate.
+func (c *httpGeminiClient) generate(ctx context.Context, body geminiRequest) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+
+	reqBody, err := json.Marshal(body)
+	if err != nil {
+		return "", fmt.Errorf("could not marshal gemini request: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/v1beta/models/%s:generateContent", c.cfg.BaseURL, c.cfg.Model)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return "", fmt.Errorf("could not create gemini http request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-goog-api-key", c.cfg.APIKey)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// Surface the ctx error directly so callers can tell a disconnect or deadline from an upstream failure.
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", fmt.Errorf("gemini request aborted: %w", ctxErr)
+		}
+		return "", fmt.Errorf("gemini request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("gemini returned non-200 status: %d", resp.StatusCode)
+	}
+
+	var genResp geminiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&genResp); err != nil {
+		return "", fmt.Errorf("could not decode gemini response: %w", err)
+	}
+	if len(genResp.Candidates) == 0 || len(genResp.Candidates[0].Content.Parts) == 0 {
+		return "", fmt.Errorf("gemini returned no candidates")
+	}
+
+	var text bytes.Buffer
+	for _, part := range genResp.Candidates[0].Content.Parts {
+		text.WriteString(part.Text)
+	}
+	return text.String(), nil
+}
+
 // stubChatGatewayClient is a fake ChatGatewayClient.
 type stubChatGatewayClient struct{}
 
@@ -52,30 +185,57 @@ func NewStubChatGatewayClient() ChatGatewayClient {
 	return &stubChatGatewayClient{}
 }
 
-func (s *stubChatGatewayClient) GetChatHistory(ctx context.Context, twilioSID string) ([]*ChatMessage, error) {
+func (s *stubChatGatewayClient) GetChatHistory(ctx context.Context, twilioSID string, limit int) ([]*ChatMessage, error) {
 	// Return a mock chat history.
 	if twilioSID == "" {
 		return nil, fmt.Errorf("twilioSID cannot be empty")
 	}
 
-	return []*ChatMessage{
+	history := []*ChatMessage{
 		{Role: "user", Content: "Hello, my Wi-Fi isn't working."},
 		{Role: "model", Content: "I see. Have you tried turning it off and on again?"},
 		{Role: "user", Content: "Yes, I tried that, and it's still broken."},
-	}, nil
+	}
+	if limit > 0 && len(history) > limit {
+		history = history[len(history)-limit:]
+	}
+	return history, nil
+}
+
+func (s *stubChatGatewayClient) PostMessage(ctx context.Context, twilioSID, content string) error {
+	fmt.Printf("STUB: Bot posted to %s: %s\n", twilioSID, content)
+	return nil
 }
 
+// DefaultBotIdentity is the bot's Twilio identity unless configured otherwise.
+// It must match the identity the ChatGatewayService adds the bot under (chat.DefaultBotIdentity).
+const DefaultBotIdentity = "LLM_BOT_IDENTITY"
+
 // httpChatGatewayClient is the real implementation for the ChatGatewayClient.
 type httpChatGatewayClient struct {
-	httpClient *http.Client
-	baseURL    string
+	httpClient    *http.Client
+	baseURL       string
+	botIdentity   string // Messages from this author are the model's own, everything else is the user
+	internalToken string // Sent as X-Internal-Token, the chat routes are internal only
 }
 
-// NewHTTPChatGatewayClient is the constructor for the real client
-func NewHTTPChatGatewayClient(baseURL string) ChatGatewayClient {
+// DefaultChatGatewayTimeout is the ChatGatewayClient's whole-call timeout when it's constructed with 0.
+const DefaultChatGatewayTimeout = 5 * time.Second
+
+// NewHTTPChatGatewayClient is the constructor for the real client.
+// botIdentity is the bot's Twilio identity; empty means DefaultBotIdentity. timeout 0 means DefaultChatGatewayTimeout.
+func NewHTTPChatGatewayClient(baseURL, botIdentity, internalToken string, timeout time.Duration) ChatGatewayClient {
+	if botIdentity == "" {
+		botIdentity = DefaultBotIdentity
+	}
+	if timeout <= 0 {
+		timeout = DefaultChatGatewayTimeout
+	}
 	return &httpChatGatewayClient{
-		httpClient: &http.Client{Timeout: 5 * time.Second},
-		baseURL:    baseURL,
+		httpClient:    httpclient.New(timeout),
+		baseURL:       baseURL,
+		botIdentity:   botIdentity,
+		internalToken: internalToken,
 	}
 }
 
@@ -88,13 +248,17 @@ type chatServiceMessage struct {
 }
 
 // GetChatHistory makes http call to the ChatGatewayService.
-func (c *httpChatGatewayClient) GetChatHistory(ctx context.Context, twilioSID string) ([]*ChatMessage, error) {
-	// This matches the ChatGatewayService handler: /chat/history/{sid}
+func (c *httpChatGatewayClient) GetChatHistory(ctx context.Context, twilioSID string, limit int) ([]*ChatMessage, error) {
+	// This matches the ChatGatewayService handler: /chat/history/{sid}?limit=N
 	url := fmt.Sprintf("%s/chat/history/%s", c.baseURL, twilioSID)
+	if limit > 0 {
+		url += fmt.Sprintf("?limit=%d", limit)
+	}
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("could not create get-history http request: %w", err)
 	}
+	req.Header.Set(auth.InternalTokenHeader, c.internalToken)
 
 	// Make the call
 	resp, err := c.httpClient.Do(req)
@@ -120,7 +284,7 @@ func (c *httpChatGatewayClient) GetChatHistory(ctx context.Context, twilioSID st
 
 		// Map the author to either "user" or "model" (for the llm)
 		var role string
-		if msg.Author == "LLM_BOT_IDENTITY" {
+		if msg.Author == c.botIdentity {
 			role = "model"
 		} else {
 			// For now, treat all other participants as the user
@@ -135,3 +299,42 @@ func (c *httpChatGatewayClient) GetChatHistory(ctx context.Context, twilioSID st
 
 	return llmHistory, nil
 }
+
+// postMessageRequest must match the ChatGatewayService's POST /chat/message DTO.
+type postMessageRequest struct {
+	TwilioConversationSID string `json:"twilio_conversation_sid"`
+	Author                string `json:"author"`
+	Body                  string `json:"body"`
+}
+
+// PostMessage makes an http call to the ChatGatewayService to post as the bot.
+func (c *httpChatGatewayClient) PostMessage(ctx context.Context, twilioSID, content string) error {
+	reqBody, err := json.Marshal(postMessageRequest{
+		TwilioConversationSID: twilioSID,
+		Author:                c.botIdentity, // The same identity GetChatHistory maps to "model"
+		Body:                  content,
+	})
+	if err != nil {
+		return fmt.Errorf("could not marshal post-message request: %w", err)
+	}
+
+	url := c.baseURL + "/chat/message"
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return fmt.Errorf("could not create post-message http request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(auth.InternalTokenHeader, c.internalToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("post-message request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("chat service (post-message) returned non-201 status: %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -95,16 +95,30 @@ func (m *MockChatGatewayClient) EXPECT() *MockChatGatewayClientMockRecorder {
 }
 
 // GetChatHistory mocks base method.
-func (m *MockChatGatewayClient) GetChatHistory(ctx context.Context, twilioSID string) ([]*ChatMessage, error) {
+func (m *MockChatGatewayClient) GetChatHistory(ctx context.Context, twilioSID string, limit int) ([]*ChatMessage, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetChatHistory", ctx, twilioSID)
+	ret := m.ctrl.Call(m, "GetChatHistory", ctx, twilioSID, limit)
 	ret0, _ := ret[0].([]*ChatMessage)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetChatHistory indicates an expected call of GetChatHistory.
-func (mr *MockChatGatewayClientMockRecorder) GetChatHistory(ctx, twilioSID any) *gomock.Call {
+func (mr *MockChatGatewayClientMockRecorder) GetChatHistory(ctx, twilioSID, limit any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetChatHistory", reflect.TypeOf((*MockChatGatewayClient)(nil).GetChatHistory), ctx, twilioSID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetChatHistory", reflect.TypeOf((*MockChatGatewayClient)(nil).GetChatHistory), ctx, twilioSID, limit)
+}
+
+// PostMessage mocks base method.
+func (m *MockChatGatewayClient) PostMessage(ctx context.Context, twilioSID, content string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PostMessage", ctx, twilioSID, content)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PostMessage indicates an expected call of PostMessage.
+func (mr *MockChatGatewayClientMockRecorder) PostMessage(ctx, twilioSID, content any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PostMessage", reflect.TypeOf((*MockChatGatewayClient)(nil).PostMessage), ctx, twilioSID, content)
 }
@@ -0,0 +1,159 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"project-sage/internal/auth"
+	"testing"
+	"time"
+)
+
+// TestGeminiClient_ContextCancelled checks that cancelling ctx aborts an in-flight Gemini call
+// instead of waiting for the upstream to answer.
+func TestGeminiClient_ContextCancelled(t *testing.T) {
+	// This server never answers until the test is over.
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := NewHTTPGeminiClient(GeminiConfig{APIKey: "test-key", BaseURL: server.URL, Timeout: time.Minute})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := client.Summarize(ctx, []*ChatMessage{{Role: "user", Content: "Hello"}})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("call took %v after cancel, expected it to return promptly", elapsed)
+	}
+}
+
+// TestGeminiClient_GenerateContent_Success checks the request and response mapping.
+func TestGeminiClient_GenerateContent_Success(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1beta/models/test-model:generateContent" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if r.Header.Get("x-goog-api-key") != "test-key" {
+			t.Errorf("api key header not set")
+		}
+		w.Write([]byte(`{"candidates":[{"content":{"role":"model","parts":[{"text":"Hi "},{"text":"there!"}]}}]}`))
+	}))
+	defer server.Close()
+
+	client := NewHTTPGeminiClient(GeminiConfig{APIKey: "test-key", Model: "test-model", BaseURL: server.URL})
+
+	msg, err := client.GenerateContent(context.Background(), []*ChatMessage{{Role: "user", Content: "Hello"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if msg.Role != "model" || msg.Content != "Hi there!" {
+		t.Errorf("unexpected message: %+v", msg)
+	}
+}
+
+// TestChatGatewayClient_CustomBotIdentity checks the configured bot identity is used both ways:
+// its messages in the history are the model's, and replies are posted under it.
+func TestChatGatewayClient_CustomBotIdentity(t *testing.T) {
+	var postedAuthor string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			var body postMessageRequest
+			json.NewDecoder(r.Body).Decode(&body)
+			postedAuthor = body.Author
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		json.NewEncoder(w).Encode([]chatServiceMessage{
+			{Author: "user-uuid", Content: "Help"},
+			{Author: "SAGE_BOT_2", Content: "Sure"},
+			{Author: DefaultBotIdentity, Content: "Not our bot anymore"},
+		})
+	}))
+	defer server.Close()
+
+	client := NewHTTPChatGatewayClient(server.URL, "SAGE_BOT_2", "", 0)
+
+	history, err := client.GetChatHistory(context.Background(), "CH123", 0)
+	if err != nil {
+		t.Fatalf("GetChatHistory() returned error: %v", err)
+	}
+	wantRoles := []string{"user", "model", "user"}
+	for i, msg := range history {
+		if msg.Role != wantRoles[i] {
+			t.Errorf("Message %d: expected role %s, got %s", i, wantRoles[i], msg.Role)
+		}
+	}
+
+	if err := client.PostMessage(context.Background(), "CH123", "Reply"); err != nil {
+		t.Fatalf("PostMessage() returned error: %v", err)
+	}
+	if postedAuthor != "SAGE_BOT_2" {
+		t.Errorf("Expected the reply posted as SAGE_BOT_2, got %q", postedAuthor)
+	}
+}
+
+// TestChatGatewayClient_HistoryLimit checks the limit goes to the chat gateway as a query param, and is left off for 0.
+func TestChatGatewayClient_HistoryLimit(t *testing.T) {
+	var gotQuery string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery = r.URL.RawQuery
+		json.NewEncoder(w).Encode([]chatServiceMessage{})
+	}))
+	defer server.Close()
+
+	client := NewHTTPChatGatewayClient(server.URL, "", "", 0)
+
+	if _, err := client.GetChatHistory(context.Background(), "CH123", 25); err != nil {
+		t.Fatalf("GetChatHistory() returned error: %v", err)
+	}
+	if gotQuery != "limit=25" {
+		t.Errorf("Expected query limit=25, got %q", gotQuery)
+	}
+
+	if _, err := client.GetChatHistory(context.Background(), "CH123", 0); err != nil {
+		t.Fatalf("GetChatHistory() returned error: %v", err)
+	}
+	if gotQuery != "" {
+		t.Errorf("Expected no query without a limit, got %q", gotQuery)
+	}
+}
+
+// TestChatGatewayClient_SendsInternalToken checks both calls carry the internal token the chat routes require.
+func TestChatGatewayClient_SendsInternalToken(t *testing.T) {
+	var gotTokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTokens = append(gotTokens, r.Header.Get(auth.InternalTokenHeader))
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		json.NewEncoder(w).Encode([]chatServiceMessage{})
+	}))
+	defer server.Close()
+
+	client := NewHTTPChatGatewayClient(server.URL, "", "internal-secret", 0)
+
+	if _, err := client.GetChatHistory(context.Background(), "CH123", 0); err != nil {
+		t.Fatalf("GetChatHistory() returned error: %v", err)
+	}
+	if err := client.PostMessage(context.Background(), "CH123", "Reply"); err != nil {
+		t.Fatalf("PostMessage() returned error: %v", err)
+	}
+	if len(gotTokens) != 2 || gotTokens[0] != "internal-secret" || gotTokens[1] != "internal-secret" {
+		t.Errorf("Expected the internal token on both calls, got %q", gotTokens)
+	}
+}
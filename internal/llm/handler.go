@@ -1,23 +1,58 @@
 package llm
 
 import (
-	"encoding/json"
+	_ "embed"
+	"fmt"
 	"net/http"
+	"strings"
+	"unicode/utf8"
 
+	"project-sage/internal/httputil"
+	"project-sage/internal/jsonbody"
+	"project-sage/internal/openapi"
 	// "project-sage/internal/auth" // Placeholder for auth middleware
 
 	"github.com/go-chi/chi/v5"
 )
 
+// openAPISpec is the hand-written API contract, served at /openapi.json. Keep it in step with the routes and DTOs below.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
 // Handler is the http api layer for the LLMGatewayService.
 type Handler struct {
 	service Service
+	limits  ChatLimits
+}
+
+// ChatLimits caps what a social chat can send to Gemini. The client sends the whole history every turn,
+// so without a cap one long session, or one crafted body, runs up the token bill.
+type ChatLimits struct {
+	MaxMessages int // How many messages one history can have
+	MaxChars    int // How many characters all the messages can have together
 }
 
-// NewHandler creates a new handler injecting the service.
-func NewHandler(s Service) *Handler {
+// DefaultChatLimits returns the limits used unless configured otherwise.
+func DefaultChatLimits() ChatLimits {
+	return ChatLimits{
+		MaxMessages: 50,
+		MaxChars:    20000,
+	}
+}
+
+// NewHandler creates a new handler injecting the service. A limit of 0 or less takes its DefaultChatLimits value.
+func NewHandler(s Service, limits ChatLimits) *Handler {
+	def := DefaultChatLimits()
+	if limits.MaxMessages <= 0 {
+		limits.MaxMessages = def.MaxMessages
+	}
+	if limits.MaxChars <= 0 {
+		limits.MaxChars = def.MaxChars
+	}
 	return &Handler{
 		service: s,
+		limits:  limits,
 	}
 }
 
@@ -28,6 +63,9 @@ func (h *Handler) RegisterRoutes(r chi.Router) {
 
 	// Internal endpoint for summarization
 	r.Post("/chat/summarize", h.handleSummarizeChat)
+
+	// The API contract, for client generation.
+	r.Get(openapi.Path, openapi.Handler(openAPISpec))
 }
 
 // --- DTOs ---
@@ -35,6 +73,41 @@ func (h *Handler) RegisterRoutes(r chi.Router) {
 // socialChatRequest is the DTO for what the client app sends.
 type socialChatRequest struct {
 	History []*ChatMessage `json:"history"`
+	// Optional. When set, the reply is also posted into this conversation.
+	TwilioConversationSID string `json:"twilio_conversation_sid,omitempty"`
+}
+
+// trim strips the whitespace around each message, so padding neither counts against the limits nor goes to Gemini.
+func (p *socialChatRequest) trim() {
+	for _, m := range p.History {
+		if m != nil {
+			m.Content = strings.TrimSpace(m.Content)
+		}
+	}
+}
+
+// validate checks the history against limits. Call trim first. The error is a *jsonbody.ValidationError.
+func (p socialChatRequest) validate(limits ChatLimits) error {
+	var v jsonbody.ValidationError
+	if len(p.History) == 0 {
+		v.Add("history", "is required")
+		return v.Err()
+	}
+	if len(p.History) > limits.MaxMessages {
+		v.Add("history", fmt.Sprintf("must have at most %d messages", limits.MaxMessages))
+	}
+	chars := 0
+	for _, m := range p.History {
+		if m == nil || m.Content == "" {
+			v.Add("history", "must not have empty messages")
+			continue
+		}
+		chars += utf8.RuneCountInString(m.Content)
+	}
+	if chars > limits.MaxChars {
+		v.Add("history", fmt.Sprintf("must be at most %d characters in total", limits.MaxChars))
+	}
+	return v.Err()
 }
 
 // summarizeRequest is the DTO for what the RequestService sends.
@@ -54,25 +127,30 @@ func (h *Handler) handleSocialChat(w http.ResponseWriter, r *http.Request) {
 	// TODO: Add auth middleware to get UserID
 	// _, err := auth.GetUserID(r.Context())
 	// if err != nil {
-	// 	writeError(w, http.StatusUnauthorized, "Not authorized")
+	// 	httputil.WriteError(w, http.StatusUnauthorized, "Not authorized")
 	// 	return
 	// }
 
 	var req socialChatRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid request payload")
+	if err := jsonbody.Decode(w, r, &req); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid request payload")
+		return
+	}
+	req.trim()
+	if err := req.validate(h.limits); err != nil {
+		jsonbody.WriteValidationError(w, err)
 		return
 	}
 
 	// Call the service with the provided history
-	response, err := h.service.SocialChat(r.Context(), req.History)
+	response, err := h.service.SocialChat(r.Context(), req.TwilioConversationSID, req.History)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "Could not process chat")
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not process chat")
 		return
 	}
 
 	// Send back the single new message from the model
-	writeJSON(w, http.StatusOK, response)
+	httputil.WriteJSON(w, http.StatusOK, response)
 }
 
 // handleSummarizeChat handles internal requests to summarize a chat
@@ -80,31 +158,17 @@ func (h *Handler) handleSummarizeChat(w http.ResponseWriter, r *http.Request) {
 	// This is an internal service-to-service endpoint so it does not use user-facing auth middleware
 
 	var req summarizeRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid request payload")
+	if err := jsonbody.Decode(w, r, &req); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid request payload")
 		return
 	}
 
 	summary, err := h.service.SummarizeChatHistory(r.Context(), req.TwilioConversationSID)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "Could not summarize chat history")
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not summarize chat history")
 		return
 	}
 
 	// Send back the summary string
-	writeJSON(w, http.StatusOK, summarizeResponse{Summary: summary})
-}
-
-// writeJSON is a helper function for sending json responses.
-func writeJSON(w http.ResponseWriter, status int, data interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	if data != nil {
-		json.NewEncoder(w).Encode(data)
-	}
-}
-
-// writeError is a helper for sending a standardized json error.
-func writeError(w http.ResponseWriter, status int, message string) {
-	writeJSON(w, status, map[string]string{"error": message})
+	httputil.WriteJSON(w, http.StatusOK, summarizeResponse{Summary: summary})
 }
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"project-sage/internal/openapi"
+	"strings"
 	"testing"
 
 	"github.com/go-chi/chi/v5"
@@ -17,7 +19,7 @@ func setupHandlerTest(t *testing.T) (*chi.Mux, *MockService, *gomock.Controller)
 	ctrl := gomock.NewController(t)
 	mockService := NewMockService(ctrl)
 
-	handler := NewHandler(mockService)
+	handler := NewHandler(mockService, ChatLimits{MaxMessages: 3, MaxChars: 20})
 
 	r := chi.NewRouter()
 	handler.RegisterRoutes(r)
@@ -37,7 +39,7 @@ func TestHandleSocialChat_Success(t *testing.T) {
 
 	// Set up the service mock
 	mockService.EXPECT().
-		SocialChat(gomock.Any(), gomock.Any()). // We could be more specific with the matcher
+		SocialChat(gomock.Any(), gomock.Any(), gomock.Any()). // We could be more specific with the matcher
 		Return(respMsg, nil).
 		Times(1)
 
@@ -63,6 +65,61 @@ func TestHandleSocialChat_Success(t *testing.T) {
 	}
 }
 
+// TestHandleSocialChat_Validation checks an empty or over-limit history is a 400 naming history, before the service
+// is called. The limits here are 3 messages and 20 characters.
+func TestHandleSocialChat_Validation(t *testing.T) {
+	r, _, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"no history", `{}`, "is required"},
+		{"empty history", `{"history": []}`, "is required"},
+		{"blank message", `{"history": [{"role": "user", "content": "  "}]}`, "must not have empty messages"},
+		{"too many messages", `{"history": [{"role": "user", "content": "a"}, {"role": "model", "content": "b"},
+			{"role": "user", "content": "c"}, {"role": "model", "content": "d"}]}`, "must have at most 3 messages"},
+		{"too long", `{"history": [{"role": "user", "content": "` + strings.Repeat("a", 21) + `"}]}`, "must be at most 20 characters in total"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, httptest.NewRequest("POST", "/chat/social", strings.NewReader(tt.body)))
+
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("Expected status 400, got %d", rr.Code)
+			}
+			var body struct {
+				Fields map[string]string `json:"fields"`
+			}
+			json.NewDecoder(rr.Body).Decode(&body)
+			if body.Fields["history"] != tt.want {
+				t.Errorf("Expected history %q, got %v", tt.want, body.Fields)
+			}
+		})
+	}
+}
+
+// TestHandleSocialChat_Trims checks the padding around content is gone before the limits and the service see it.
+func TestHandleSocialChat_Trims(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	mockService.EXPECT().
+		SocialChat(gomock.Any(), "", []*ChatMessage{{Role: "user", Content: "Hello"}}).
+		Return(&ChatMessage{Role: "model", Content: "Hi!"}, nil)
+
+	// 25 characters with the padding, 5 without.
+	body := `{"history": [{"role": "user", "content": "          Hello          "}]}`
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("POST", "/chat/social", strings.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", rr.Code)
+	}
+}
+
 func TestHandleSummarizeChat_Success(t *testing.T) {
 	r, mockService, ctrl := setupHandlerTest(t)
 	defer ctrl.Finish()
@@ -108,7 +165,7 @@ func TestHandleSocialChat_ServiceError(t *testing.T) {
 
 	// Set up mock to return an error
 	mockService.EXPECT().
-		SocialChat(gomock.Any(), gomock.Any()).
+		SocialChat(gomock.Any(), gomock.Any(), gomock.Any()).
 		Return(nil, fmt.Errorf("model is down")).
 		Times(1)
 
@@ -130,3 +187,25 @@ func TestHandleSocialChat_ServiceError(t *testing.T) {
 		t.Errorf("Expected error '%s', got '%s'", "Could not process chat", errBody["error"])
 	}
 }
+
+// TestOpenAPISpec checks the served spec is valid OpenAPI and describes exactly the routes the handler registers.
+func TestOpenAPISpec(t *testing.T) {
+	r, _, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/openapi.json", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	if err := openapi.Validate(rr.Body.Bytes()); err != nil {
+		t.Fatalf("Served spec is not valid: %v", err)
+	}
+	missing, extra, err := openapi.Diff(r, rr.Body.Bytes())
+	if err != nil {
+		t.Fatalf("Diff() returned error: %v", err)
+	}
+	if len(missing) > 0 || len(extra) > 0 {
+		t.Errorf("Spec is out of step with the routes. Undocumented: %v. Documented but not routed: %v", missing, extra)
+	}
+}
@@ -9,34 +9,54 @@ import (
 
 // Service defines the business logic for the llm Gateway.
 type Service interface {
-	// SocialChat sends a list of messages to the llm for response
-	SocialChat(ctx context.Context, history []*ChatMessage) (*ChatMessage, error)
+	// SocialChat sends a list of messages to the llm for response.
+	// If twilioSID is set, the reply is also posted into that conversation. Pass "" for the stateless version.
+	SocialChat(ctx context.Context, twilioSID string, history []*ChatMessage) (*ChatMessage, error)
 
 	// SummarizeChatHistory fetches history from a Twilio SID and summarizes it.
 	SummarizeChatHistory(ctx context.Context, twilioSID string) (string, error)
 }
 
+// DefaultSummaryHistoryLimit is how many of the newest messages go into a summary unless configured otherwise.
+// The expert needs the recent problem, not the whole session, and long prompts are slow.
+const DefaultSummaryHistoryLimit = 100
+
 // service is the concrete implementation of the Service interface.
 type service struct {
-	gemini GeminiClient      // client for the external Gemini API
-	chat   ChatGatewayClient // Client for the internal ChatGatewayService
+	gemini       GeminiClient      // client for the external Gemini API
+	chat         ChatGatewayClient // Client for the internal ChatGatewayService
+	historyLimit int               // Newest messages fetched for a summary
 }
 
 // NewService is the constructor for the LLMGatewayService.
-func NewService(gemini GeminiClient, chat ChatGatewayClient) Service {
+// historyLimit is how many messages to summarize; 0 or less means DefaultSummaryHistoryLimit.
+func NewService(gemini GeminiClient, chat ChatGatewayClient, historyLimit int) Service {
+	if historyLimit <= 0 {
+		historyLimit = DefaultSummaryHistoryLimit
+	}
 	return &service{
-		gemini: gemini,
-		chat:   chat,
+		gemini:       gemini,
+		chat:         chat,
+		historyLimit: historyLimit,
 	}
 }
 
 // SocialChat implements the Service interface.
-func (s *service) SocialChat(ctx context.Context, history []*ChatMessage) (*ChatMessage, error) {
+func (s *service) SocialChat(ctx context.Context, twilioSID string, history []*ChatMessage) (*ChatMessage, error) {
 	// For social chat we pass the history directly to the gemini client.
 	response, err := s.gemini.GenerateContent(ctx, history)
 	if err != nil {
 		return nil, fmt.Errorf("gemini client failed: %w", err)
 	}
+
+	// Post the reply into the conversation so it's in the transcript when we summarize later.
+	if twilioSID != "" {
+		if err := s.chat.PostMessage(ctx, twilioSID, response.Content); err != nil {
+			// The caller still gets the reply, it just won't be in the transcript.
+			fmt.Printf("WARNING: Failed to post bot reply to %s: %v\n", twilioSID, err)
+		}
+	}
+
 	return response, nil
 }
 
@@ -44,8 +64,8 @@ func (s *service) SocialChat(ctx context.Context, history []*ChatMessage) (*Chat
 func (s *service) SummarizeChatHistory(ctx context.Context, twilioSID string) (string, error) {
 	// This is the key orchestration flow for summarization.
 
-	// Fetch the chat history using Twilio SID.
-	history, err := s.chat.GetChatHistory(ctx, twilioSID)
+	// Fetch the tail of the chat history using Twilio SID.
+	history, err := s.chat.GetChatHistory(ctx, twilioSID, s.historyLimit)
 	if err != nil {
 		return "", fmt.Errorf("could not fetch chat history from ChatGateway: %w", err)
 	}
@@ -41,18 +41,18 @@ func (m *MockService) EXPECT() *MockServiceMockRecorder {
 }
 
 // SocialChat mocks base method.
-func (m *MockService) SocialChat(ctx context.Context, history []*ChatMessage) (*ChatMessage, error) {
+func (m *MockService) SocialChat(ctx context.Context, twilioSID string, history []*ChatMessage) (*ChatMessage, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SocialChat", ctx, history)
+	ret := m.ctrl.Call(m, "SocialChat", ctx, twilioSID, history)
 	ret0, _ := ret[0].(*ChatMessage)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // SocialChat indicates an expected call of SocialChat.
-func (mr *MockServiceMockRecorder) SocialChat(ctx, history any) *gomock.Call {
+func (mr *MockServiceMockRecorder) SocialChat(ctx, twilioSID, history any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SocialChat", reflect.TypeOf((*MockService)(nil).SocialChat), ctx, history)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SocialChat", reflect.TypeOf((*MockService)(nil).SocialChat), ctx, twilioSID, history)
 }
 
 // SummarizeChatHistory mocks base method.
@@ -32,11 +32,43 @@ func TestService_SocialChat_Success(t *testing.T) {
 		Times(1)
 
 	// We don't expect the ChatGatewayClient to be called
-	mockChat.EXPECT().GetChatHistory(gomock.Any(), gomock.Any()).Times(0)
+	mockChat.EXPECT().GetChatHistory(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	mockChat.EXPECT().PostMessage(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
 
-	// Call the service
-	s := NewService(mockGemini, mockChat)
-	resp, err := s.SocialChat(ctx, history)
+	// Call the service, stateless
+	s := NewService(mockGemini, mockChat, 0)
+	resp, err := s.SocialChat(ctx, "", history)
+
+	if err != nil {
+		t.Fatalf("SocialChat() returned unexpected error: %v", err)
+	}
+	if resp.Content != expectedResponse.Content {
+		t.Errorf("want response '%s', got '%s'", expectedResponse.Content, resp.Content)
+	}
+}
+
+// TestService_SocialChat_PostsToConversation tests that the reply lands in the transcript when a SID is given.
+func TestService_SocialChat_PostsToConversation(t *testing.T) {
+	ctx, mockGemini, mockChat, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	history := []*ChatMessage{{Role: "user", Content: "Hello"}}
+	expectedResponse := &ChatMessage{Role: "model", Content: "Hi there!"}
+
+	// Generate first, then post the model's content
+	gomock.InOrder(
+		mockGemini.EXPECT().
+			GenerateContent(ctx, history).
+			Return(expectedResponse, nil).
+			Times(1),
+		mockChat.EXPECT().
+			PostMessage(ctx, "CH-social-1", "Hi there!").
+			Return(nil).
+			Times(1),
+	)
+
+	s := NewService(mockGemini, mockChat, 0)
+	resp, err := s.SocialChat(ctx, "CH-social-1", history)
 
 	if err != nil {
 		t.Fatalf("SocialChat() returned unexpected error: %v", err)
@@ -59,7 +91,7 @@ func TestService_SummarizeChatHistory_Success(t *testing.T) {
 	gomock.InOrder(
 		// The service must call the ChatGatewayClient first.
 		mockChat.EXPECT().
-			GetChatHistory(ctx, twilioSID).
+			GetChatHistory(ctx, twilioSID, DefaultSummaryHistoryLimit).
 			Return(mockHistory, nil).
 			Times(1),
 
@@ -74,7 +106,7 @@ func TestService_SummarizeChatHistory_Success(t *testing.T) {
 	mockGemini.EXPECT().GenerateContent(gomock.Any(), gomock.Any()).Times(0)
 
 	// Call the service
-	s := NewService(mockGemini, mockChat)
+	s := NewService(mockGemini, mockChat, 0)
 	summary, err := s.SummarizeChatHistory(ctx, twilioSID)
 
 	if err != nil {
@@ -95,7 +127,7 @@ func TestService_SummarizeChatHistory_ChatGatewayError(t *testing.T) {
 
 	// The ChatGatewayClient fails.
 	mockChat.EXPECT().
-		GetChatHistory(ctx, twilioSID).
+		GetChatHistory(ctx, twilioSID, DefaultSummaryHistoryLimit).
 		Return(nil, expectedErr).
 		Times(1)
 
@@ -103,7 +135,7 @@ func TestService_SummarizeChatHistory_ChatGatewayError(t *testing.T) {
 	mockGemini.EXPECT().Summarize(gomock.Any(), gomock.Any()).Times(0)
 
 	// Call the service
-	s := NewService(mockGemini, mockChat)
+	s := NewService(mockGemini, mockChat, 0)
 	_, err := s.SummarizeChatHistory(ctx, twilioSID)
 
 	if err == nil {
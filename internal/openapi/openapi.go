@@ -0,0 +1,243 @@
+// Package openapi serves and checks the hand-written OpenAPI 3 documents our services publish.
+//
+// Each service keeps its spec as openapi.json next to its handler, embeds it, and serves it at GET /openapi.json.
+// It's written by hand rather than generated from annotations, so the handler tests call Validate and Diff
+// to catch a spec that's broken or has drifted from the router.
+package openapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Path is where every service serves its spec.
+const Path = "/openapi.json"
+
+// Handler serves spec as-is. The spec is checked by the service's tests, not here.
+func Handler(spec []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(spec)
+	}
+}
+
+// document is the part of an OpenAPI document Validate looks at.
+type document struct {
+	OpenAPI string `json:"openapi"`
+	Info    struct {
+		Title   string `json:"title"`
+		Version string `json:"version"`
+	} `json:"info"`
+	Paths map[string]map[string]json.RawMessage `json:"paths"`
+}
+
+// operation is the part of an operation Validate looks at.
+type operation struct {
+	Parameters []parameter                `json:"parameters"`
+	Responses  map[string]json.RawMessage `json:"responses"`
+}
+
+type parameter struct {
+	Ref  string `json:"$ref"`
+	Name string `json:"name"`
+	In   string `json:"in"`
+}
+
+// methods are the operation keys a path item can have. Anything else under a path is a mistake.
+var methods = map[string]bool{
+	"get": true, "put": true, "post": true, "delete": true, "options": true, "head": true, "patch": true, "trace": true,
+}
+
+// pathParam matches a {name} template in a path.
+var pathParam = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// Validate checks spec is an OpenAPI 3 document a client generator will accept: it parses, has a title and version,
+// every operation has responses with real status codes, every {param} in a path is declared as a path parameter,
+// and every $ref points at something in the document.
+// It isn't a full schema validator, just the mistakes that are easy to make editing JSON by hand.
+func Validate(spec []byte) error {
+	var doc document
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return fmt.Errorf("spec is not valid JSON: %w", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return fmt.Errorf("openapi version must be 3.x, got %q", doc.OpenAPI)
+	}
+	if doc.Info.Title == "" || doc.Info.Version == "" {
+		return errors.New("info.title and info.version are required")
+	}
+	if len(doc.Paths) == 0 {
+		return errors.New("spec has no paths")
+	}
+
+	var root any
+	if err := json.Unmarshal(spec, &root); err != nil {
+		return err
+	}
+	if err := checkRefs(root, root, "#"); err != nil {
+		return err
+	}
+
+	for path, item := range doc.Paths {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("path %q must start with /", path)
+		}
+		var shared []parameter
+		if raw, ok := item["parameters"]; ok {
+			if err := json.Unmarshal(raw, &shared); err != nil {
+				return fmt.Errorf("%s: invalid parameters: %w", path, err)
+			}
+		}
+		for key, raw := range item {
+			if key == "parameters" || key == "summary" || key == "description" {
+				continue
+			}
+			if !methods[key] {
+				return fmt.Errorf("%s: %q is not an HTTP method", path, key)
+			}
+			var op operation
+			if err := json.Unmarshal(raw, &op); err != nil {
+				return fmt.Errorf("%s %s: invalid operation: %w", strings.ToUpper(key), path, err)
+			}
+			if err := checkOperation(root, path, op, shared); err != nil {
+				return fmt.Errorf("%s %s: %w", strings.ToUpper(key), path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkOperation checks one operation's responses and path parameters.
+func checkOperation(root any, path string, op operation, shared []parameter) error {
+	if len(op.Responses) == 0 {
+		return errors.New("no responses")
+	}
+	for code := range op.Responses {
+		if code == "default" {
+			continue
+		}
+		if n, err := strconv.Atoi(code); err != nil || n < 100 || n > 599 {
+			return fmt.Errorf("%q is not a status code", code)
+		}
+	}
+
+	declared := map[string]bool{}
+	for _, p := range append(shared, op.Parameters...) {
+		if p.Ref != "" {
+			// Already known to resolve, from checkRefs.
+			target, _ := resolve(root, p.Ref)
+			b, _ := json.Marshal(target)
+			if err := json.Unmarshal(b, &p); err != nil {
+				return fmt.Errorf("invalid parameter %s: %w", p.Ref, err)
+			}
+		}
+		if p.In == "path" {
+			declared[p.Name] = true
+		}
+	}
+	for _, m := range pathParam.FindAllStringSubmatch(path, -1) {
+		if !declared[m[1]] {
+			return fmt.Errorf("path parameter %q is not declared", m[1])
+		}
+	}
+	return nil
+}
+
+// checkRefs walks the document and makes sure every $ref resolves.
+func checkRefs(root, node any, at string) error {
+	switch v := node.(type) {
+	case map[string]any:
+		if ref, ok := v["$ref"]; ok {
+			s, _ := ref.(string)
+			if _, err := resolve(root, s); err != nil {
+				return fmt.Errorf("%s: %w", at, err)
+			}
+		}
+		for k, child := range v {
+			if err := checkRefs(root, child, at+"/"+k); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for i, child := range v {
+			if err := checkRefs(root, child, at+"/"+strconv.Itoa(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolve follows a local $ref like #/components/schemas/Error. Refs to other files aren't used, so they're an error.
+func resolve(root any, ref string) (any, error) {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("$ref %q must point inside the document", ref)
+	}
+	node := root
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		m, ok := node.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("$ref %q does not resolve", ref)
+		}
+		if node, ok = m[part]; !ok {
+			return nil, fmt.Errorf("$ref %q does not resolve", ref)
+		}
+	}
+	return node, nil
+}
+
+// Diff compares the routes on r with the operations in spec, as "METHOD /path" lists, sorted.
+// missing is routes the spec doesn't describe, extra is operations in the spec with no route behind them.
+// Route patterns use the same {param} syntax as OpenAPI, so they're compared as they are.
+// The spec endpoint itself, and anything in skip (eg "GET /health", which main registers), are left out of both.
+func Diff(r chi.Routes, spec []byte, skip ...string) (missing, extra []string, err error) {
+	var doc document
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return nil, nil, fmt.Errorf("spec is not valid JSON: %w", err)
+	}
+	ignore := map[string]bool{"GET " + Path: true}
+	for _, s := range skip {
+		ignore[s] = true
+	}
+
+	documented := map[string]bool{}
+	for path, item := range doc.Paths {
+		for key := range item {
+			if methods[key] {
+				documented[strings.ToUpper(key)+" "+path] = true
+			}
+		}
+	}
+
+	routed := map[string]bool{}
+	err = chi.Walk(r, func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		routed[method+" "+route] = true
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for key := range routed {
+		if !documented[key] && !ignore[key] {
+			missing = append(missing, key)
+		}
+	}
+	for key := range documented {
+		if !routed[key] && !ignore[key] {
+			extra = append(extra, key)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(extra)
+	return missing, extra, nil
+}
@@ -0,0 +1,96 @@
+package openapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// testSpec is a small valid spec. The broken cases below are edits of it.
+const testSpec = `{
+  "openapi": "3.0.3",
+  "info": {"title": "Test", "version": "1.0.0"},
+  "paths": {
+    "/things/{id}": {
+      "get": {
+        "parameters": [{"$ref": "#/components/parameters/ThingID"}],
+        "responses": {
+          "200": {"description": "OK", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Thing"}}}},
+          "404": {"description": "Not found"}
+        }
+      }
+    },
+    "/things": {
+      "post": {"responses": {"201": {"description": "Created"}}}
+    }
+  },
+  "components": {
+    "parameters": {"ThingID": {"name": "id", "in": "path", "required": true, "schema": {"type": "string"}}},
+    "schemas": {"Thing": {"type": "object"}}
+  }
+}`
+
+func TestValidate(t *testing.T) {
+	if err := Validate([]byte(testSpec)); err != nil {
+		t.Fatalf("Expected the test spec to be valid, got %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		old, new string
+		wantErr  string
+	}{
+		{"not json", `"openapi"`, `openapi`, "not valid JSON"},
+		{"swagger 2", `"3.0.3"`, `"2.0"`, "must be 3.x"},
+		{"no title", `"title": "Test"`, `"title": ""`, "info.title"},
+		{"dangling ref", `#/components/schemas/Thing"`, `#/components/schemas/Widget"`, "does not resolve"},
+		{"external ref", `#/components/schemas/Thing"`, `other.json#/Thing"`, "inside the document"},
+		{"undeclared path param", `"name": "id"`, `"name": "thing_id"`, `"id" is not declared`},
+		{"bad status", `"404"`, `"4xx"`, "not a status code"},
+		{"no responses", `"post": {"responses": {"201": {"description": "Created"}}}`, `"post": {"responses": {}}`, "no responses"},
+		{"not a method", `"post": {`, `"submit": {`, "not an HTTP method"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := strings.Replace(testSpec, tt.old, tt.new, 1)
+			if spec == testSpec {
+				t.Fatalf("Replacing %q changed nothing", tt.old)
+			}
+			err := Validate([]byte(spec))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+	r := chi.NewRouter()
+	r.Get("/things/{id}", noop)
+	r.Get("/health", noop)
+	r.Delete("/things/{id}", noop)
+	r.Get(Path, Handler([]byte(testSpec)))
+
+	missing, extra, err := Diff(r, []byte(testSpec), "GET /health")
+	if err != nil {
+		t.Fatalf("Diff() returned error: %v", err)
+	}
+	if strings.Join(missing, ",") != "DELETE /things/{id}" {
+		t.Errorf("Expected only DELETE /things/{id} missing, got %v", missing)
+	}
+	if strings.Join(extra, ",") != "POST /things" {
+		t.Errorf("Expected only POST /things extra, got %v", extra)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+	Handler([]byte(testSpec)).ServeHTTP(rr, httptest.NewRequest("GET", Path, nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/json" || rr.Body.String() != testSpec {
+		t.Errorf("Expected the spec back as JSON, got %d %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+}
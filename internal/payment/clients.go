@@ -8,18 +8,25 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"project-sage/internal/auth"
 	"project-sage/internal/domain"
+	"project-sage/internal/httpclient"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
 )
 
 // --- Client Interfaces ---
 
 // BillingClient is the client for the internal BillingService.
 type BillingClient interface {
-	// alls POST /token/add
-	CreditToken(ctx context.Context, userID uuid.UUID, amount int) (int, error)
+	// alls POST /token/add. The BillingService credits each referenceID only once, so a retry can't double credit.
+	CreditToken(ctx context.Context, userID uuid.UUID, amount int, referenceID string) (int, error)
 }
 
 // UserClient is the client for the internal UserService.
@@ -28,48 +35,108 @@ type UserClient interface {
 	GetUserProfile(ctx context.Context, userID uuid.UUID) (*domain.User, error)
 }
 
+// StripeCustomerStore saves a user's Stripe customer in the UserService. The HTTP UserClient is one, but it's its own
+// interface so a profile cache can still stand in for the UserClient.
+type StripeCustomerStore interface {
+	// SetStripeCustomerID stores customerID unless the user already has a customer, and returns the one they have now.
+	SetStripeCustomerID(ctx context.Context, userID uuid.UUID, customerID string) (string, error)
+}
+
 // AppleClient is for Apple's IAP verification API.
 type AppleClient interface {
-	VerifyReceipt(ctx context.Context, receipt string) (string, error) // Returns (productID, error)
+	// VerifyReceipt returns the purchase, or ErrInvalidReceipt if Apple rejects the receipt.
+	VerifyReceipt(ctx context.Context, receipt string) (*StorePurchase, error)
 }
 
 // GoogleClient is for Google's IAP verification API.
 type GoogleClient interface {
-	VerifyReceipt(ctx context.Context, receipt string) (string, error) // Returns (productID, error)
+	// VerifyReceipt returns the purchase, or ErrInvalidReceipt if Google rejects the receipt.
+	VerifyReceipt(ctx context.Context, receipt string) (*StorePurchase, error)
+}
+
+// StorePurchase is a purchase a store has vouched for.
+type StorePurchase struct {
+	ProductID string // The store's id for the product
+	// TransactionID is the store's id for this one purchase. Unlike the receipt it doesn't change with how the app
+	// encodes it, or with which account sends it, so it's what a purchase is credited once by.
+	TransactionID string
 }
 
 // StripeClient is for Stripe.
 type StripeClient interface {
-	CreateIntent(ctx context.Context, userID uuid.UUID, productID string) (string, error)
+	// EnsureCustomer returns a Stripe customer for the user, creating one if Stripe doesn't have one for them yet.
+	EnsureCustomer(ctx context.Context, user *domain.User) (string, error)
+	// CreateIntent creates a PaymentIntent and returns its client secret. An intent with an IdempotencyKey that Stripe
+	// has already seen returns the first intent's secret rather than creating another.
+	CreateIntent(ctx context.Context, intent *StripeIntent) (string, error)
 	HandleEvent(ctx context.Context, payload []byte) error
 }
 
+// StripeIntent is what a PaymentIntent is created with. The amount is always our catalog price, never the app's.
+type StripeIntent struct {
+	CustomerID  string
+	AmountCents int
+	Currency    string
+	// Metadata is copied onto the intent. Stripe sends it back in the webhook, which is how we know what was bought and by whom.
+	Metadata map[string]string
+	// IdempotencyKey is sent as Stripe's Idempotency-Key header. Empty means none.
+	IdempotencyKey string
+}
+
+// Metadata keys set on every intent.
+const (
+	stripeMetadataUserID    = "user_id"
+	stripeMetadataProductID = "product_id"
+)
+
+// Whole-call timeouts for the clients below, used when a constructor is given 0.
+const (
+	// DefaultClientTimeout is for our own services, the BillingService and the UserService.
+	DefaultClientTimeout = 5 * time.Second
+	// DefaultStoreClientTimeout is for Apple and Google, which can be slow to verify a purchase.
+	DefaultStoreClientTimeout = 10 * time.Second
+)
+
+// newHTTPClient returns a client on the shared transport with the given timeout, or def for 0.
+func newHTTPClient(timeout, def time.Duration) *http.Client {
+	if timeout <= 0 {
+		timeout = def
+	}
+	return httpclient.New(timeout)
+}
+
 // --- BillingClient Implementation ---
 
 type httpBillingClient struct {
-	httpClient *http.Client
-	baseURL    string
+	httpClient    *http.Client
+	baseURL       string
+	internalToken string // Sent as X-Internal-Token, /token/add is closed without it
 }
 
-func NewHTTPBillingClient(baseURL string) BillingClient {
+func NewHTTPBillingClient(baseURL, internalToken string, timeout time.Duration) BillingClient {
 	return &httpBillingClient{
-		httpClient: &http.Client{Timeout: 5 * time.Second},
-		baseURL:    baseURL,
+		httpClient:    newHTTPClient(timeout, DefaultClientTimeout),
+		baseURL:       baseURL,
+		internalToken: internalToken,
 	}
 }
 
 type creditRequest struct {
-	UserID string `json:"user_id"`
-	Amount int    `json:"amount"`
+	UserID      string `json:"user_id"`
+	Amount      int    `json:"amount"`
+	ReferenceID string `json:"reference_id,omitempty"`
+	Source      string `json:"source"` // Always "purchase" from here, so the tokens count as paid for
 }
 type creditResponse struct {
 	NewBalance int `json:"new_balance"`
 }
 
-func (c *httpBillingClient) CreditToken(ctx context.Context, userID uuid.UUID, amount int) (int, error) {
+func (c *httpBillingClient) CreditToken(ctx context.Context, userID uuid.UUID, amount int, referenceID string) (int, error) {
 	reqBody, err := json.Marshal(creditRequest{
-		UserID: userID.String(),
-		Amount: amount,
+		UserID:      userID.String(),
+		Amount:      amount,
+		ReferenceID: referenceID,
+		Source:      "purchase",
 	})
 	if err != nil {
 		return 0, fmt.Errorf("could not marshal credit request: %w", err)
@@ -81,6 +148,7 @@ func (c *httpBillingClient) CreditToken(ctx context.Context, userID uuid.UUID, a
 		return 0, fmt.Errorf("could not create credit http request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(auth.InternalTokenHeader, c.internalToken)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -89,6 +157,10 @@ func (c *httpBillingClient) CreditToken(ctx context.Context, userID uuid.UUID, a
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
+		// 422 is the only way the BillingService turns down a well formed credit.
+		if resp.StatusCode == http.StatusUnprocessableEntity {
+			return 0, ErrBalanceCapExceeded
+		}
 		return 0, fmt.Errorf("billing service returned non-200 status: %d", resp.StatusCode)
 	}
 
@@ -102,17 +174,35 @@ func (c *httpBillingClient) CreditToken(ctx context.Context, userID uuid.UUID, a
 // --- UserClient Implementation ---
 
 type httpUserClient struct {
-	httpClient *http.Client
-	baseURL    string
+	httpClient    *http.Client
+	baseURL       string
+	internalToken string // Sent as X-Internal-Token, the UserService's internal routes are closed without it
+}
+
+func NewHTTPUserClient(baseURL, internalToken string, timeout time.Duration) UserClient {
+	return &httpUserClient{
+		httpClient:    newHTTPClient(timeout, DefaultClientTimeout),
+		baseURL:       baseURL,
+		internalToken: internalToken,
+	}
 }
 
-func NewHTTPUserClient(baseURL string) UserClient {
+// NewHTTPStripeCustomerStore saves Stripe customers through the same UserService API.
+func NewHTTPStripeCustomerStore(baseURL, internalToken string, timeout time.Duration) StripeCustomerStore {
 	return &httpUserClient{
-		httpClient: &http.Client{Timeout: 5 * time.Second},
-		baseURL:    baseURL,
+		httpClient:    newHTTPClient(timeout, DefaultClientTimeout),
+		baseURL:       baseURL,
+		internalToken: internalToken,
 	}
 }
 
+// internalUserResponse is what /users/internal/{id} sends. domain.User doesn't decode stripe_customer_id,
+// since it's kept out of the app's JSON, so it's picked up here.
+type internalUserResponse struct {
+	domain.User
+	StripeCustomerID string `json:"stripe_customer_id"`
+}
+
 // GetUserProfile fetches a user by their internal UUID.
 func (c *httpUserClient) GetUserProfile(ctx context.Context, userID uuid.UUID) (*domain.User, error) {
 	url := fmt.Sprintf("%s/users/internal/%s", c.baseURL, userID.String())
@@ -120,6 +210,7 @@ func (c *httpUserClient) GetUserProfile(ctx context.Context, userID uuid.UUID) (
 	if err != nil {
 		return nil, fmt.Errorf("could not create get-user http request: %w", err)
 	}
+	req.Header.Set(auth.InternalTokenHeader, c.internalToken)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -131,13 +222,364 @@ func (c *httpUserClient) GetUserProfile(ctx context.Context, userID uuid.UUID) (
 		return nil, fmt.Errorf("user service returned non-200 status: %d", resp.StatusCode)
 	}
 
-	var user domain.User
-	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+	var body internalUserResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
 		return nil, fmt.Errorf("could not decode user profile: %w", err)
 	}
+	user := body.User
+	user.StripeCustomerID = body.StripeCustomerID
 	return &user, nil
 }
 
+type stripeCustomerBody struct {
+	StripeCustomerID string `json:"stripe_customer_id"`
+}
+
+// SetStripeCustomerID calls PUT /users/internal/{id}/stripe-customer.
+func (c *httpUserClient) SetStripeCustomerID(ctx context.Context, userID uuid.UUID, customerID string) (string, error) {
+	reqBody, err := json.Marshal(stripeCustomerBody{StripeCustomerID: customerID})
+	if err != nil {
+		return "", fmt.Errorf("could not marshal stripe customer request: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/users/internal/%s/stripe-customer", c.baseURL, userID.String())
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return "", fmt.Errorf("could not create set-stripe-customer http request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(auth.InternalTokenHeader, c.internalToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("set-stripe-customer request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("user service returned non-200 status: %d", resp.StatusCode)
+	}
+
+	var body stripeCustomerBody
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("could not decode stripe customer response: %w", err)
+	}
+	return body.StripeCustomerID, nil
+}
+
+// --- AppleClient Implementation ---
+
+// Apple's verifyReceipt endpoints.
+const (
+	appleProductionURL = "https://buy.itunes.apple.com/verifyReceipt"
+	appleSandboxURL    = "https://sandbox.itunes.apple.com/verifyReceipt"
+)
+
+// Apple verifyReceipt status codes we act on. Everything else non-zero means the receipt is bad.
+const (
+	appleStatusOK              = 0
+	appleStatusServerError     = 21005 // Apple's receipt server is temporarily down
+	appleStatusSandboxReceipt  = 21007 // Sandbox receipt sent to production
+	appleStatusProductionOnSbx = 21008 // Production receipt sent to sandbox
+)
+
+type realAppleClient struct {
+	httpClient    *http.Client
+	sharedSecret  string
+	bundleID      string
+	sandbox       bool
+	productionURL string
+	sandboxURL    string
+}
+
+// NewRealAppleClient creates a client for Apple's verifyReceipt API. Only receipts from the app with bundleID are
+// accepted. sandbox picks which environment is tried first. Either way, a receipt from the other environment is
+// retried there, which is what Apple recommends so that TestFlight and App Review purchases work against production.
+func NewRealAppleClient(sharedSecret, bundleID string, sandbox bool, timeout time.Duration) AppleClient {
+	return &realAppleClient{
+		httpClient:    newHTTPClient(timeout, DefaultStoreClientTimeout),
+		sharedSecret:  sharedSecret,
+		bundleID:      bundleID,
+		sandbox:       sandbox,
+		productionURL: appleProductionURL,
+		sandboxURL:    appleSandboxURL,
+	}
+}
+
+type appleVerifyRequest struct {
+	ReceiptData            string `json:"receipt-data"`
+	Password               string `json:"password"`
+	ExcludeOldTransactions bool   `json:"exclude-old-transactions"`
+}
+
+type appleInApp struct {
+	ProductID      string `json:"product_id"`
+	TransactionID  string `json:"transaction_id"`
+	PurchaseDateMs string `json:"purchase_date_ms"`
+}
+
+type appleVerifyResponse struct {
+	Status            int          `json:"status"`
+	LatestReceiptInfo []appleInApp `json:"latest_receipt_info"`
+	Receipt           struct {
+		BundleID string       `json:"bundle_id"`
+		InApp    []appleInApp `json:"in_app"`
+	} `json:"receipt"`
+}
+
+// VerifyReceipt sends the base64 receipt to Apple and returns the latest purchase in it, with its transaction_id.
+// A receipt is refreshed with every purchase and lists the earlier ones too, so the transaction id is what tells
+// one purchase from the next.
+func (c *realAppleClient) VerifyReceipt(ctx context.Context, receipt string) (*StorePurchase, error) {
+	first, other := c.productionURL, c.sandboxURL
+	if c.sandbox {
+		first, other = c.sandboxURL, c.productionURL
+	}
+
+	resp, err := c.verify(ctx, first, receipt)
+	if err != nil {
+		return nil, err
+	}
+	// The receipt belongs to the other environment, so ask that one instead.
+	if resp.Status == appleStatusSandboxReceipt || resp.Status == appleStatusProductionOnSbx {
+		resp, err = c.verify(ctx, other, receipt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case resp.Status == appleStatusOK:
+	case resp.Status == appleStatusServerError || (resp.Status >= 21100 && resp.Status <= 21199):
+		// These are Apple's own failures. The receipt may be fine, so don't tell the user it's invalid.
+		return nil, fmt.Errorf("apple verifyReceipt unavailable, status %d", resp.Status)
+	default:
+		return nil, fmt.Errorf("%w: apple status %d", ErrInvalidReceipt, resp.Status)
+	}
+
+	// A purchase made in some other app can't pay for ours.
+	if resp.Receipt.BundleID != c.bundleID {
+		return nil, fmt.Errorf("%w: receipt is for bundle %q", ErrInvalidReceipt, resp.Receipt.BundleID)
+	}
+
+	latest := latestPurchase(resp.LatestReceiptInfo)
+	if latest == nil {
+		// Receipts without subscriptions only list purchases under receipt.in_app.
+		latest = latestPurchase(resp.Receipt.InApp)
+	}
+	if latest == nil || latest.ProductID == "" || latest.TransactionID == "" {
+		return nil, fmt.Errorf("%w: no purchases in receipt", ErrInvalidReceipt)
+	}
+	return &StorePurchase{ProductID: latest.ProductID, TransactionID: latest.TransactionID}, nil
+}
+
+// verify makes one verifyReceipt call against the given URL.
+func (c *realAppleClient) verify(ctx context.Context, url, receipt string) (*appleVerifyResponse, error) {
+	reqBody, err := json.Marshal(appleVerifyRequest{
+		ReceiptData:            receipt,
+		Password:               c.sharedSecret,
+		ExcludeOldTransactions: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal apple verify request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("could not create apple verify http request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("apple verify request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Apple answers 200 even for bad receipts; the real result is in the status field.
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("apple verifyReceipt returned non-200 status: %d", resp.StatusCode)
+	}
+
+	var verifyResp appleVerifyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&verifyResp); err != nil {
+		return nil, fmt.Errorf("could not decode apple verify response: %w", err)
+	}
+	return &verifyResp, nil
+}
+
+// latestPurchase returns the most recent purchase in the list, or nil if it's empty.
+func latestPurchase(purchases []appleInApp) *appleInApp {
+	var latest *appleInApp
+	var latestMs int64 = -1
+	for i, p := range purchases {
+		ms, err := strconv.ParseInt(p.PurchaseDateMs, 10, 64)
+		if err != nil {
+			ms = 0
+		}
+		if ms > latestMs {
+			latest, latestMs = &purchases[i], ms
+		}
+	}
+	return latest
+}
+
+// --- GoogleClient Implementation ---
+
+// androidPublisherURL is the base of the Google Play Developer (Android Publisher) API.
+const androidPublisherURL = "https://androidpublisher.googleapis.com/androidpublisher/v3"
+
+// Google Play purchase states.
+const (
+	googlePurchaseStatePurchased = 0
+	googlePurchaseStateCanceled  = 1
+	googlePurchaseStatePending   = 2
+)
+
+type realGoogleClient struct {
+	httpClient  *http.Client // Already authorized with the service account
+	packageName string
+	baseURL     string
+}
+
+// NewRealGoogleClient creates a client for the Android Publisher API using a service account key.
+// It fails if the key can't be parsed, so a bad deploy is caught at startup rather than on the first purchase.
+func NewRealGoogleClient(serviceAccountJSON []byte, packageName string, timeout time.Duration) (GoogleClient, error) {
+	conf, err := google.JWTConfigFromJSON(serviceAccountJSON, "https://www.googleapis.com/auth/androidpublisher")
+	if err != nil {
+		return nil, fmt.Errorf("could not parse google service account: %w", err)
+	}
+
+	// The token source refreshes the access token by itself. The context is only used for those token fetches,
+	// and carries the client they're made with so they get the same timeouts.
+	baseClient := newHTTPClient(timeout, DefaultStoreClientTimeout)
+	tokenCtx := context.WithValue(context.Background(), oauth2.HTTPClient, baseClient)
+	httpClient := oauth2.NewClient(tokenCtx, conf.TokenSource(tokenCtx))
+	httpClient.Timeout = baseClient.Timeout
+
+	return newRealGoogleClient(httpClient, packageName, androidPublisherURL), nil
+}
+
+// newRealGoogleClient takes an already authorized http client. Tests pass one with a fake transport.
+func newRealGoogleClient(httpClient *http.Client, packageName, baseURL string) *realGoogleClient {
+	return &realGoogleClient{
+		httpClient:  httpClient,
+		packageName: packageName,
+		baseURL:     baseURL,
+	}
+}
+
+// googleReceipt is what the app sends as receipt_data: the original JSON of the Play Billing Purchase.
+type googleReceipt struct {
+	PackageName   string   `json:"packageName"`
+	ProductID     string   `json:"productId"`
+	ProductIDs    []string `json:"productIds"` // Newer Billing Library versions use this instead of productId
+	PurchaseToken string   `json:"purchaseToken"`
+}
+
+// googleProductPurchase is the part of purchases.products.get we use.
+type googleProductPurchase struct {
+	PurchaseState        int    `json:"purchaseState"`
+	AcknowledgementState int    `json:"acknowledgementState"`
+	OrderID              string `json:"orderId"`
+}
+
+// VerifyReceipt checks the purchase token with Google and acknowledges it, then returns the product id with the
+// purchase token as the transaction id. The token is there for every purchase, where the order id isn't for test
+// purchases and promo codes. Purchases that aren't acknowledged within three days are refunded by Google automatically.
+func (c *realGoogleClient) VerifyReceipt(ctx context.Context, receipt string) (*StorePurchase, error) {
+	var gr googleReceipt
+	if err := json.Unmarshal([]byte(receipt), &gr); err != nil {
+		return nil, fmt.Errorf("%w: receipt is not a Play purchase: %v", ErrInvalidReceipt, err)
+	}
+	productID := gr.ProductID
+	if productID == "" && len(gr.ProductIDs) > 0 {
+		productID = gr.ProductIDs[0]
+	}
+	if productID == "" || gr.PurchaseToken == "" {
+		return nil, fmt.Errorf("%w: receipt is missing the product id or purchase token", ErrInvalidReceipt)
+	}
+	// A purchase made in some other app can't pay for ours.
+	if gr.PackageName != "" && gr.PackageName != c.packageName {
+		return nil, fmt.Errorf("%w: receipt is for package %s", ErrInvalidReceipt, gr.PackageName)
+	}
+
+	purchaseURL := fmt.Sprintf("%s/applications/%s/purchases/products/%s/tokens/%s",
+		c.baseURL, url.PathEscape(c.packageName), url.PathEscape(productID), url.PathEscape(gr.PurchaseToken))
+
+	purchase, err := c.getPurchase(ctx, purchaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch purchase.PurchaseState {
+	case googlePurchaseStatePurchased:
+	case googlePurchaseStatePending:
+		// Not paid yet (e.g. cash at a store). The app can retry once the payment clears.
+		return nil, fmt.Errorf("%w: purchase %s is still pending", ErrInvalidReceipt, purchase.OrderID)
+	default:
+		return nil, fmt.Errorf("%w: purchase %s is in state %d", ErrInvalidReceipt, purchase.OrderID, purchase.PurchaseState)
+	}
+
+	// Acknowledging twice is an error on Google's side, so only do it once.
+	if purchase.AcknowledgementState == 0 {
+		if err := c.acknowledge(ctx, purchaseURL); err != nil {
+			return nil, err
+		}
+	}
+
+	return &StorePurchase{ProductID: productID, TransactionID: gr.PurchaseToken}, nil
+}
+
+// getPurchase calls purchases.products.get.
+func (c *realGoogleClient) getPurchase(ctx context.Context, purchaseURL string) (*googleProductPurchase, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", purchaseURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create google purchase http request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("google purchase request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusGone:
+		// Google's answers for a token it doesn't know or that has expired.
+		return nil, fmt.Errorf("%w: google returned status %d", ErrInvalidReceipt, resp.StatusCode)
+	default:
+		return nil, fmt.Errorf("google purchase api returned non-200 status: %d", resp.StatusCode)
+	}
+
+	var purchase googleProductPurchase
+	if err := json.NewDecoder(resp.Body).Decode(&purchase); err != nil {
+		return nil, fmt.Errorf("could not decode google purchase response: %w", err)
+	}
+	return &purchase, nil
+}
+
+// acknowledge calls purchases.products.acknowledge.
+func (c *realGoogleClient) acknowledge(ctx context.Context, purchaseURL string) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", purchaseURL+":acknowledge", bytes.NewBufferString("{}"))
+	if err != nil {
+		return fmt.Errorf("could not create google acknowledge http request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("google acknowledge request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("google acknowledge returned non-200 status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
 // --- Stub Implementations for External APIs ---
 
 type stubAppleClient struct{}
@@ -145,9 +587,13 @@ type stubAppleClient struct{}
 func NewStubAppleClient() AppleClient {
 	return &stubAppleClient{}
 }
-func (s *stubAppleClient) VerifyReceipt(ctx context.Context, receipt string) (string, error) {
+func (s *stubAppleClient) VerifyReceipt(ctx context.Context, receipt string) (*StorePurchase, error) {
 	fmt.Printf("STUB: Verifying Apple receipt: %s\n", receipt)
-	return "pack_5_tokens", nil
+	if receipt == "invalid" {
+		return nil, ErrInvalidReceipt // Lets the app exercise the rejected receipt path.
+	}
+	// Each made up receipt is its own purchase.
+	return &StorePurchase{ProductID: "pack_5_tokens", TransactionID: receipt}, nil
 }
 
 type stubGoogleClient struct{}
@@ -155,19 +601,41 @@ type stubGoogleClient struct{}
 func NewStubGoogleClient() GoogleClient {
 	return &stubGoogleClient{}
 }
-func (s *stubGoogleClient) VerifyReceipt(ctx context.Context, receipt string) (string, error) {
+func (s *stubGoogleClient) VerifyReceipt(ctx context.Context, receipt string) (*StorePurchase, error) {
 	fmt.Printf("STUB: Verifying Google receipt: %s\n", receipt)
-	return "pack_5_tokens", nil
+	if receipt == "invalid" {
+		return nil, ErrInvalidReceipt
+	}
+	// Each made up receipt is its own purchase.
+	return &StorePurchase{ProductID: "pack_5_tokens", TransactionID: receipt}, nil
 }
 
-type stubStripeClient struct{}
+// stubStripeClient remembers its idempotency keys the way Stripe does, so retries behave the same in development.
+type stubStripeClient struct {
+	mu      sync.Mutex
+	secrets map[string]string
+}
 
 func NewStubStripeClient() StripeClient {
-	return &stubStripeClient{}
+	return &stubStripeClient{secrets: make(map[string]string)}
+}
+func (s *stubStripeClient) EnsureCustomer(ctx context.Context, user *domain.User) (string, error) {
+	fmt.Printf("STUB: Ensuring Stripe customer for user %s\n", user.UserID)
+	return "cus_stub_" + user.UserID.String(), nil
 }
-func (s *stubStripeClient) CreateIntent(ctx context.Context, userID uuid.UUID, productID string) (string, error) {
-	fmt.Printf("STUB: Creating Stripe intent for user %s, product %s\n", userID, productID)
-	return "fake_client_secret_for_stripe", nil
+func (s *stubStripeClient) CreateIntent(ctx context.Context, intent *StripeIntent) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if secret, ok := s.secrets[intent.IdempotencyKey]; ok && intent.IdempotencyKey != "" {
+		fmt.Printf("STUB: Returning the Stripe intent already created for idempotency key %s\n", intent.IdempotencyKey)
+		return secret, nil
+	}
+	fmt.Printf("STUB: Creating Stripe intent for %d %s (customer %s), metadata %v\n", intent.AmountCents, intent.Currency, intent.CustomerID, intent.Metadata)
+	secret := "fake_client_secret_for_stripe_" + uuid.NewString()
+	if intent.IdempotencyKey != "" {
+		s.secrets[intent.IdempotencyKey] = secret
+	}
+	return secret, nil
 }
 func (s *stubStripeClient) HandleEvent(ctx context.Context, payload []byte) error {
 	fmt.Printf("STUB: Handling Stripe webhook event\n")
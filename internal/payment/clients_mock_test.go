@@ -0,0 +1,282 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: clients.go
+//
+// Generated by this command:
+//
+//	mockgen -destination=./clients_mock_test.go -package=payment -source=clients.go
+//
+
+// Package payment is a generated GoMock package.
+package payment
+
+import (
+	context "context"
+	domain "project-sage/internal/domain"
+	reflect "reflect"
+
+	uuid "github.com/google/uuid"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockBillingClient is a mock of BillingClient interface.
+type MockBillingClient struct {
+	ctrl     *gomock.Controller
+	recorder *MockBillingClientMockRecorder
+	isgomock struct{}
+}
+
+// MockBillingClientMockRecorder is the mock recorder for MockBillingClient.
+type MockBillingClientMockRecorder struct {
+	mock *MockBillingClient
+}
+
+// NewMockBillingClient creates a new mock instance.
+func NewMockBillingClient(ctrl *gomock.Controller) *MockBillingClient {
+	mock := &MockBillingClient{ctrl: ctrl}
+	mock.recorder = &MockBillingClientMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockBillingClient) EXPECT() *MockBillingClientMockRecorder {
+	return m.recorder
+}
+
+// CreditToken mocks base method.
+func (m *MockBillingClient) CreditToken(ctx context.Context, userID uuid.UUID, amount int, referenceID string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreditToken", ctx, userID, amount, referenceID)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreditToken indicates an expected call of CreditToken.
+func (mr *MockBillingClientMockRecorder) CreditToken(ctx, userID, amount, referenceID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreditToken", reflect.TypeOf((*MockBillingClient)(nil).CreditToken), ctx, userID, amount, referenceID)
+}
+
+// MockUserClient is a mock of UserClient interface.
+type MockUserClient struct {
+	ctrl     *gomock.Controller
+	recorder *MockUserClientMockRecorder
+	isgomock struct{}
+}
+
+// MockUserClientMockRecorder is the mock recorder for MockUserClient.
+type MockUserClientMockRecorder struct {
+	mock *MockUserClient
+}
+
+// NewMockUserClient creates a new mock instance.
+func NewMockUserClient(ctrl *gomock.Controller) *MockUserClient {
+	mock := &MockUserClient{ctrl: ctrl}
+	mock.recorder = &MockUserClientMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockUserClient) EXPECT() *MockUserClientMockRecorder {
+	return m.recorder
+}
+
+// GetUserProfile mocks base method.
+func (m *MockUserClient) GetUserProfile(ctx context.Context, userID uuid.UUID) (*domain.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserProfile", ctx, userID)
+	ret0, _ := ret[0].(*domain.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserProfile indicates an expected call of GetUserProfile.
+func (mr *MockUserClientMockRecorder) GetUserProfile(ctx, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserProfile", reflect.TypeOf((*MockUserClient)(nil).GetUserProfile), ctx, userID)
+}
+
+// MockStripeCustomerStore is a mock of StripeCustomerStore interface.
+type MockStripeCustomerStore struct {
+	ctrl     *gomock.Controller
+	recorder *MockStripeCustomerStoreMockRecorder
+	isgomock struct{}
+}
+
+// MockStripeCustomerStoreMockRecorder is the mock recorder for MockStripeCustomerStore.
+type MockStripeCustomerStoreMockRecorder struct {
+	mock *MockStripeCustomerStore
+}
+
+// NewMockStripeCustomerStore creates a new mock instance.
+func NewMockStripeCustomerStore(ctrl *gomock.Controller) *MockStripeCustomerStore {
+	mock := &MockStripeCustomerStore{ctrl: ctrl}
+	mock.recorder = &MockStripeCustomerStoreMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockStripeCustomerStore) EXPECT() *MockStripeCustomerStoreMockRecorder {
+	return m.recorder
+}
+
+// SetStripeCustomerID mocks base method.
+func (m *MockStripeCustomerStore) SetStripeCustomerID(ctx context.Context, userID uuid.UUID, customerID string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetStripeCustomerID", ctx, userID, customerID)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetStripeCustomerID indicates an expected call of SetStripeCustomerID.
+func (mr *MockStripeCustomerStoreMockRecorder) SetStripeCustomerID(ctx, userID, customerID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetStripeCustomerID", reflect.TypeOf((*MockStripeCustomerStore)(nil).SetStripeCustomerID), ctx, userID, customerID)
+}
+
+// MockAppleClient is a mock of AppleClient interface.
+type MockAppleClient struct {
+	ctrl     *gomock.Controller
+	recorder *MockAppleClientMockRecorder
+	isgomock struct{}
+}
+
+// MockAppleClientMockRecorder is the mock recorder for MockAppleClient.
+type MockAppleClientMockRecorder struct {
+	mock *MockAppleClient
+}
+
+// NewMockAppleClient creates a new mock instance.
+func NewMockAppleClient(ctrl *gomock.Controller) *MockAppleClient {
+	mock := &MockAppleClient{ctrl: ctrl}
+	mock.recorder = &MockAppleClientMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAppleClient) EXPECT() *MockAppleClientMockRecorder {
+	return m.recorder
+}
+
+// VerifyReceipt mocks base method.
+func (m *MockAppleClient) VerifyReceipt(ctx context.Context, receipt string) (*StorePurchase, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "VerifyReceipt", ctx, receipt)
+	ret0, _ := ret[0].(*StorePurchase)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// VerifyReceipt indicates an expected call of VerifyReceipt.
+func (mr *MockAppleClientMockRecorder) VerifyReceipt(ctx, receipt any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VerifyReceipt", reflect.TypeOf((*MockAppleClient)(nil).VerifyReceipt), ctx, receipt)
+}
+
+// MockGoogleClient is a mock of GoogleClient interface.
+type MockGoogleClient struct {
+	ctrl     *gomock.Controller
+	recorder *MockGoogleClientMockRecorder
+	isgomock struct{}
+}
+
+// MockGoogleClientMockRecorder is the mock recorder for MockGoogleClient.
+type MockGoogleClientMockRecorder struct {
+	mock *MockGoogleClient
+}
+
+// NewMockGoogleClient creates a new mock instance.
+func NewMockGoogleClient(ctrl *gomock.Controller) *MockGoogleClient {
+	mock := &MockGoogleClient{ctrl: ctrl}
+	mock.recorder = &MockGoogleClientMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockGoogleClient) EXPECT() *MockGoogleClientMockRecorder {
+	return m.recorder
+}
+
+// VerifyReceipt mocks base method.
+func (m *MockGoogleClient) VerifyReceipt(ctx context.Context, receipt string) (*StorePurchase, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "VerifyReceipt", ctx, receipt)
+	ret0, _ := ret[0].(*StorePurchase)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// VerifyReceipt indicates an expected call of VerifyReceipt.
+func (mr *MockGoogleClientMockRecorder) VerifyReceipt(ctx, receipt any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VerifyReceipt", reflect.TypeOf((*MockGoogleClient)(nil).VerifyReceipt), ctx, receipt)
+}
+
+// MockStripeClient is a mock of StripeClient interface.
+type MockStripeClient struct {
+	ctrl     *gomock.Controller
+	recorder *MockStripeClientMockRecorder
+	isgomock struct{}
+}
+
+// MockStripeClientMockRecorder is the mock recorder for MockStripeClient.
+type MockStripeClientMockRecorder struct {
+	mock *MockStripeClient
+}
+
+// NewMockStripeClient creates a new mock instance.
+func NewMockStripeClient(ctrl *gomock.Controller) *MockStripeClient {
+	mock := &MockStripeClient{ctrl: ctrl}
+	mock.recorder = &MockStripeClientMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockStripeClient) EXPECT() *MockStripeClientMockRecorder {
+	return m.recorder
+}
+
+// CreateIntent mocks base method.
+func (m *MockStripeClient) CreateIntent(ctx context.Context, intent *StripeIntent) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateIntent", ctx, intent)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateIntent indicates an expected call of CreateIntent.
+func (mr *MockStripeClientMockRecorder) CreateIntent(ctx, intent any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateIntent", reflect.TypeOf((*MockStripeClient)(nil).CreateIntent), ctx, intent)
+}
+
+// EnsureCustomer mocks base method.
+func (m *MockStripeClient) EnsureCustomer(ctx context.Context, user *domain.User) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EnsureCustomer", ctx, user)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// EnsureCustomer indicates an expected call of EnsureCustomer.
+func (mr *MockStripeClientMockRecorder) EnsureCustomer(ctx, user any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnsureCustomer", reflect.TypeOf((*MockStripeClient)(nil).EnsureCustomer), ctx, user)
+}
+
+// HandleEvent mocks base method.
+func (m *MockStripeClient) HandleEvent(ctx context.Context, payload []byte) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HandleEvent", ctx, payload)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// HandleEvent indicates an expected call of HandleEvent.
+func (mr *MockStripeClientMockRecorder) HandleEvent(ctx, payload any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HandleEvent", reflect.TypeOf((*MockStripeClient)(nil).HandleEvent), ctx, payload)
+}
@@ -0,0 +1,310 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"project-sage/internal/auth"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// fakeAppleServer mimics one verifyReceipt environment. It answers with the given body and counts the calls.
+func fakeAppleServer(t *testing.T, body string, calls *int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		var req appleVerifyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Could not decode verify request: %v", err)
+		}
+		if req.Password != "secret" || req.ReceiptData != "base64-receipt" {
+			t.Errorf("Unexpected verify request: %+v", req)
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newTestAppleClient points a real client at the fake production and sandbox servers.
+func newTestAppleClient(productionURL, sandboxURL string, sandbox bool) AppleClient {
+	c := NewRealAppleClient("secret", "com.sage.app", sandbox, 0).(*realAppleClient)
+	c.productionURL = productionURL
+	c.sandboxURL = sandboxURL
+	return c
+}
+
+// TestRealAppleClient_SandboxFallback checks that a 21007 from production is retried against the sandbox.
+func TestRealAppleClient_SandboxFallback(t *testing.T) {
+	var prodCalls, sandboxCalls int
+	prod := fakeAppleServer(t, `{"status": 21007}`, &prodCalls)
+	sandbox := fakeAppleServer(t, `{
+		"status": 0,
+		"receipt": {"bundle_id": "com.sage.app"},
+		"latest_receipt_info": [
+			{"product_id": "pack_5_tokens", "transaction_id": "1000001", "purchase_date_ms": "1700000000000"},
+			{"product_id": "pack_20_tokens", "transaction_id": "1000002", "purchase_date_ms": "1710000000000"}
+		]
+	}`, &sandboxCalls)
+
+	client := newTestAppleClient(prod.URL, sandbox.URL, false)
+
+	purchase, err := client.VerifyReceipt(context.Background(), "base64-receipt")
+	if err != nil {
+		t.Fatalf("VerifyReceipt() returned error: %v", err)
+	}
+	if purchase.ProductID != "pack_20_tokens" || purchase.TransactionID != "1000002" {
+		t.Errorf("Expected the latest purchase, pack_20_tokens in 1000002, got %+v", purchase)
+	}
+	if prodCalls != 1 || sandboxCalls != 1 {
+		t.Errorf("Expected one call to each environment, got prod=%d sandbox=%d", prodCalls, sandboxCalls)
+	}
+}
+
+// TestRealAppleClient_InAppFallback checks a receipt without latest_receipt_info uses receipt.in_app.
+func TestRealAppleClient_InAppFallback(t *testing.T) {
+	var calls, unused int
+	prod := fakeAppleServer(t, `{"status": 0, "receipt": {"bundle_id": "com.sage.app",
+		"in_app": [{"product_id": "pack_5_tokens", "transaction_id": "1000001", "purchase_date_ms": "1"}]}}`, &calls)
+	sandbox := fakeAppleServer(t, `{"status": 0}`, &unused)
+
+	client := newTestAppleClient(prod.URL, sandbox.URL, false)
+
+	purchase, err := client.VerifyReceipt(context.Background(), "base64-receipt")
+	if err != nil {
+		t.Fatalf("VerifyReceipt() returned error: %v", err)
+	}
+	if purchase.ProductID != "pack_5_tokens" || purchase.TransactionID != "1000001" {
+		t.Errorf("Expected pack_5_tokens in 1000001, got %+v", purchase)
+	}
+	if unused != 0 {
+		t.Error("Sandbox should not be called for a production receipt")
+	}
+}
+
+// TestRealAppleClient_InvalidReceipt checks non-zero statuses map to ErrInvalidReceipt, except Apple's own outages.
+func TestRealAppleClient_InvalidReceipt(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantInvalid bool
+	}{
+		{"malformed receipt", `{"status": 21002}`, true},
+		{"no purchases", `{"status": 0, "receipt": {"bundle_id": "com.sage.app"}}`, true},
+		{"no transaction id", `{"status": 0, "receipt": {"bundle_id": "com.sage.app", "in_app": [{"product_id": "pack_5_tokens"}]}}`, true},
+		{"other app", `{"status": 0, "receipt": {"bundle_id": "com.other",
+			"in_app": [{"product_id": "pack_5_tokens", "transaction_id": "1000001"}]}}`, true},
+		{"apple server down", `{"status": 21005}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls, unused int
+			prod := fakeAppleServer(t, tt.body, &calls)
+			sandbox := fakeAppleServer(t, `{"status": 0}`, &unused)
+
+			_, err := newTestAppleClient(prod.URL, sandbox.URL, false).VerifyReceipt(context.Background(), "base64-receipt")
+			if err == nil {
+				t.Fatal("Expected an error")
+			}
+			if errors.Is(err, ErrInvalidReceipt) != tt.wantInvalid {
+				t.Errorf("errors.Is(err, ErrInvalidReceipt) = %v, want %v (err: %v)", !tt.wantInvalid, tt.wantInvalid, err)
+			}
+		})
+	}
+}
+
+// fakeGoogleTransport stands in for the Android Publisher API. It records the calls it gets.
+type fakeGoogleTransport struct {
+	getStatus int
+	purchase  string
+	calls     []string
+}
+
+func (f *fakeGoogleTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.calls = append(f.calls, req.Method+" "+req.URL.Path)
+
+	status, body := f.getStatus, f.purchase
+	if req.Method == "POST" {
+		status, body = http.StatusNoContent, ""
+	}
+	return &http.Response{
+		StatusCode: status,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Header:     make(http.Header),
+		Request:    req,
+	}, nil
+}
+
+// playReceipt is the original JSON of a Play Billing purchase, as the app sends it.
+const playReceipt = `{"packageName": "com.sage.app", "productId": "pack_5_tokens", "purchaseToken": "tok-123"}`
+
+func newTestGoogleClient(ft *fakeGoogleTransport) GoogleClient {
+	return newRealGoogleClient(&http.Client{Transport: ft}, "com.sage.app", "https://publisher.test/v3")
+}
+
+// TestRealGoogleClient_VerifiesAndAcknowledges checks a fresh purchase is verified and then acknowledged.
+func TestRealGoogleClient_VerifiesAndAcknowledges(t *testing.T) {
+	ft := &fakeGoogleTransport{getStatus: http.StatusOK, purchase: `{"purchaseState": 0, "acknowledgementState": 0, "orderId": "GPA.1"}`}
+
+	purchase, err := newTestGoogleClient(ft).VerifyReceipt(context.Background(), playReceipt)
+	if err != nil {
+		t.Fatalf("VerifyReceipt() returned error: %v", err)
+	}
+	if purchase.ProductID != "pack_5_tokens" || purchase.TransactionID != "tok-123" {
+		t.Errorf("Expected pack_5_tokens bought with tok-123, got %+v", purchase)
+	}
+
+	purchasePath := "/v3/applications/com.sage.app/purchases/products/pack_5_tokens/tokens/tok-123"
+	want := []string{"GET " + purchasePath, "POST " + purchasePath + ":acknowledge"}
+	if strings.Join(ft.calls, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected calls %v, got %v", want, ft.calls)
+	}
+}
+
+// TestRealGoogleClient_AlreadyAcknowledged checks an acknowledged purchase isn't acknowledged again.
+func TestRealGoogleClient_AlreadyAcknowledged(t *testing.T) {
+	ft := &fakeGoogleTransport{getStatus: http.StatusOK, purchase: `{"purchaseState": 0, "acknowledgementState": 1}`}
+
+	if _, err := newTestGoogleClient(ft).VerifyReceipt(context.Background(), playReceipt); err != nil {
+		t.Fatalf("VerifyReceipt() returned error: %v", err)
+	}
+	if len(ft.calls) != 1 {
+		t.Errorf("Expected only the GET, got %v", ft.calls)
+	}
+}
+
+// TestRealGoogleClient_InvalidReceipt checks every way a receipt can be bad maps to ErrInvalidReceipt without acknowledging.
+func TestRealGoogleClient_InvalidReceipt(t *testing.T) {
+	tests := []struct {
+		name    string
+		receipt string
+		ft      *fakeGoogleTransport
+	}{
+		{"unknown token", playReceipt, &fakeGoogleTransport{getStatus: http.StatusNotFound}},
+		{"canceled purchase", playReceipt, &fakeGoogleTransport{getStatus: http.StatusOK, purchase: `{"purchaseState": 1}`}},
+		{"pending purchase", playReceipt, &fakeGoogleTransport{getStatus: http.StatusOK, purchase: `{"purchaseState": 2}`}},
+		{"not json", "garbage", &fakeGoogleTransport{}},
+		{"other app", `{"packageName": "com.other", "productId": "p", "purchaseToken": "t"}`, &fakeGoogleTransport{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newTestGoogleClient(tt.ft).VerifyReceipt(context.Background(), tt.receipt)
+			if !errors.Is(err, ErrInvalidReceipt) {
+				t.Errorf("Expected ErrInvalidReceipt, got: %v", err)
+			}
+			for _, call := range tt.ft.calls {
+				if strings.HasPrefix(call, "POST") {
+					t.Error("An invalid purchase must not be acknowledged")
+				}
+			}
+		})
+	}
+}
+
+// TestBillingClient_CreditToken checks the credit goes to /token/add as a purchase, with the internal token attached.
+func TestBillingClient_CreditToken(t *testing.T) {
+	userID := uuid.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(auth.InternalTokenHeader) != "internal-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var body creditRequest
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.URL.Path != "/token/add" || body.UserID != userID.String() || body.Amount != 5 || body.ReferenceID != "ref-1" || body.Source != "purchase" {
+			t.Errorf("Unexpected credit request %s %+v", r.URL.Path, body)
+		}
+		json.NewEncoder(w).Encode(creditResponse{NewBalance: 7})
+	}))
+	defer server.Close()
+
+	balance, err := NewHTTPBillingClient(server.URL, "internal-secret", 0).CreditToken(context.Background(), userID, 5, "ref-1")
+	if err != nil {
+		t.Fatalf("CreditToken() returned error: %v", err)
+	}
+	if balance != 7 {
+		t.Errorf("Expected balance 7, got %d", balance)
+	}
+	if _, err := NewHTTPBillingClient(server.URL, "wrong", 0).CreditToken(context.Background(), userID, 5, "ref-1"); err == nil {
+		t.Error("Expected an error when the billing service answers 401")
+	}
+}
+
+// TestBillingClient_CreditToken_BalanceCap checks the BillingService's 422 comes back as ErrBalanceCapExceeded.
+func TestBillingClient_CreditToken_BalanceCap(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}))
+	defer server.Close()
+
+	_, err := NewHTTPBillingClient(server.URL, "internal-secret", 0).CreditToken(context.Background(), uuid.New(), 5, "ref-1")
+	if !errors.Is(err, ErrBalanceCapExceeded) {
+		t.Errorf("Expected ErrBalanceCapExceeded, got %v", err)
+	}
+}
+
+// TestUserClient_StripeCustomer checks the customer id round trips through the UserService's internal routes,
+// even though domain.User leaves it out of its JSON, and both calls carry the internal token.
+func TestUserClient_StripeCustomer(t *testing.T) {
+	userID := uuid.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get(auth.InternalTokenHeader); got != "internal-secret" {
+			t.Errorf("%s %s: expected the internal token to be sent, got %q", r.Method, r.URL.Path, got)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.Method + " " + r.URL.Path {
+		case "GET /users/internal/" + userID.String():
+			w.Write([]byte(`{"user_id": "` + userID.String() + `", "stripe_customer_id": "cus_123"}`))
+		case "PUT /users/internal/" + userID.String() + "/stripe-customer":
+			var body stripeCustomerBody
+			json.NewDecoder(r.Body).Decode(&body)
+			if body.StripeCustomerID != "cus_456" {
+				t.Errorf("Expected cus_456 to be sent, got %q", body.StripeCustomerID)
+			}
+			// The user already had one, so that's what comes back.
+			json.NewEncoder(w).Encode(stripeCustomerBody{StripeCustomerID: "cus_123"})
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	user, err := NewHTTPUserClient(server.URL, "internal-secret", 0).GetUserProfile(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("GetUserProfile() returned error: %v", err)
+	}
+	if user.UserID != userID || user.StripeCustomerID != "cus_123" {
+		t.Errorf("Expected user %s with customer cus_123, got %s with %q", userID, user.UserID, user.StripeCustomerID)
+	}
+
+	stored, err := NewHTTPStripeCustomerStore(server.URL, "internal-secret", 0).SetStripeCustomerID(context.Background(), userID, "cus_456")
+	if err != nil {
+		t.Fatalf("SetStripeCustomerID() returned error: %v", err)
+	}
+	if stored != "cus_123" {
+		t.Errorf("Expected the stored customer cus_123, got %q", stored)
+	}
+}
+
+// TestStubStripeClient_IdempotencyKey checks the stub hands back the same secret for a repeated key, like Stripe.
+func TestStubStripeClient_IdempotencyKey(t *testing.T) {
+	stub := NewStubStripeClient()
+	ctx := context.Background()
+
+	first, _ := stub.CreateIntent(ctx, &StripeIntent{AmountCents: 499, IdempotencyKey: "k1"})
+	retry, _ := stub.CreateIntent(ctx, &StripeIntent{AmountCents: 499, IdempotencyKey: "k1"})
+	other, _ := stub.CreateIntent(ctx, &StripeIntent{AmountCents: 499})
+	if first != retry {
+		t.Errorf("Expected the same secret for a repeated key, got %q and %q", first, retry)
+	}
+	if other == first {
+		t.Errorf("Expected a new secret without a key, got %q again", other)
+	}
+}
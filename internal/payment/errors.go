@@ -0,0 +1,24 @@
+package payment
+
+import "errors"
+
+var (
+	// ErrNotFound is returned by the repository when no product matches the lookup.
+	ErrNotFound = errors.New("product not found")
+	// ErrProductExists is returned when creating a product whose id is already taken.
+	ErrProductExists = errors.New("product already exists")
+	// ErrInvalidReceipt is returned by the Apple/Google clients when the store says the receipt isn't valid.
+	// It's the user's receipt that's bad, not our service, so it must not be reported as a 500.
+	ErrInvalidReceipt = errors.New("invalid receipt")
+	// ErrSpendingCapExceeded is returned when a purchase would take the user over their rolling spending cap.
+	ErrSpendingCapExceeded = errors.New("spending cap exceeded")
+	// ErrIdempotencyKeyReused is returned when an Idempotency-Key comes back with a different product than it was
+	// first used for. A retry has to be the same request.
+	ErrIdempotencyKeyReused = errors.New("idempotency key reused for a different product")
+	// ErrBalanceCapExceeded is returned when the BillingService refused a credit because it would take the user over
+	// the maximum token balance. The user has paid by then, so it needs someone to look at it.
+	ErrBalanceCapExceeded = errors.New("token balance cap exceeded")
+	// ErrPurchaseClaimed is returned when a store purchase was already credited to a different user, eg a receipt
+	// passed around between accounts.
+	ErrPurchaseClaimed = errors.New("purchase already claimed by another user")
+)
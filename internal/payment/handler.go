@@ -1,16 +1,25 @@
 package payment
 
 import (
-	"encoding/json"
+	_ "embed"
+	"errors"
+	"fmt"
 	"net/http"
 
+	"project-sage/internal/auth"
 	"project-sage/internal/domain"
-	// "project-sage/internal/auth"
+	"project-sage/internal/httputil"
+	"project-sage/internal/jsonbody"
+	"project-sage/internal/openapi"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/google/uuid"
 )
 
+// openAPISpec is the hand-written API contract, served at /openapi.json. Keep it in step with the routes and DTOs below.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
 // Handler is the HTTP API layer for the PaymentService.
 type Handler struct {
 	service Service
@@ -24,6 +33,8 @@ func NewHandler(s Service) *Handler {
 }
 
 // RegisterRoutes attaches all the service's http endpoints to the router.
+// The router must run auth.Middleware first, as the UserService and RequestService mains do: purchases are credited
+// to the caller it puts in the context, and a request without one gets 401.
 func (h *Handler) RegisterRoutes(r chi.Router) {
 	// ---client-facing Endpoints ---
 
@@ -44,6 +55,31 @@ func (h *Handler) RegisterRoutes(r chi.Router) {
 	// POST /payment/webhook-stripe:
 	// Listens for successful payment events from Stripe.
 	r.Post("/payment/webhook-stripe", h.handleStripeWebhook)
+
+	// --- Admin Endpoints ---
+	// Only superadmins manage the catalog.
+	r.Group(func(r chi.Router) {
+		r.Use(auth.RequireRole(auth.RoleSuperadmin))
+
+		// GET /payment/admin/products:
+		// Lists every product, including inactive ones.
+		r.Get("/payment/admin/products", h.handleAdminListProducts)
+
+		// POST /payment/admin/products:
+		// Adds a new product.
+		r.Post("/payment/admin/products", h.handleAdminCreateProduct)
+
+		// PUT /payment/admin/products/{id}:
+		// Replaces a product's fields. Setting is_active here is how a product is reactivated.
+		r.Put("/payment/admin/products/{id}", h.handleAdminUpdateProduct)
+
+		// POST /payment/admin/products/{id}/deactivate:
+		// Hides a product from the store.
+		r.Post("/payment/admin/products/{id}/deactivate", h.handleAdminDeactivateProduct)
+	})
+
+	// The API contract, for client generation.
+	r.Get(openapi.Path, openapi.Handler(openAPISpec))
 }
 
 // --- DTOs (Data Transfer Objects) ---
@@ -61,6 +97,83 @@ type verifyIAPRequest struct {
 	Receipt  string `json:"receipt_data"`
 }
 
+// Validate checks the payload before we call out to Apple or Google. The error is a *jsonbody.ValidationError.
+func (p verifyIAPRequest) Validate() error {
+	var v jsonbody.ValidationError
+	if p.Provider != "apple" && p.Provider != "google" {
+		v.Add("provider", "must be 'apple' or 'google'")
+	}
+	if p.Receipt == "" {
+		v.Add("receipt_data", "is required")
+	}
+	return v.Err()
+}
+
+// productPayload is the admin view of a product. Unlike domain.Product it includes the Stripe price id.
+type productPayload struct {
+	ProductID       string `json:"product_id"`
+	Name            string `json:"name"`
+	Description     string `json:"description"`
+	PriceCents      int    `json:"price_cents"`
+	TokenCredit     int    `json:"token_credit"`
+	IsSubscription  bool   `json:"is_subscription"`
+	StripePriceID   string `json:"stripe_price_id"`
+	AppleProductID  string `json:"apple_product_id"`
+	GoogleProductID string `json:"google_product_id"`
+	IsActive        *bool  `json:"is_active"` // Defaults to true when left out
+}
+
+// Validate checks the fields an admin must get right. It returns a message naming the bad field.
+func (p productPayload) Validate() error {
+	switch {
+	case p.ProductID == "":
+		return errors.New("product_id is required")
+	case p.Name == "":
+		return errors.New("name is required")
+	case p.PriceCents <= 0:
+		return errors.New("price_cents must be positive")
+	case p.TokenCredit < 0:
+		return errors.New("token_credit can't be negative")
+	}
+	return nil
+}
+
+// toProduct maps the payload onto the domain model.
+func (p productPayload) toProduct() *domain.Product {
+	active := true
+	if p.IsActive != nil {
+		active = *p.IsActive
+	}
+	return &domain.Product{
+		ProductID:       p.ProductID,
+		Name:            p.Name,
+		Description:     p.Description,
+		PriceCents:      p.PriceCents,
+		TokenCredit:     p.TokenCredit,
+		IsSubscription:  p.IsSubscription,
+		StripePriceID:   p.StripePriceID,
+		AppleProductID:  p.AppleProductID,
+		GoogleProductID: p.GoogleProductID,
+		IsActive:        active,
+	}
+}
+
+// newProductPayload maps a product to the admin view.
+func newProductPayload(p *domain.Product) productPayload {
+	return productPayload{
+		ProductID:       p.ProductID,
+		Name:            p.Name,
+		Description:     p.Description,
+		PriceCents:      p.PriceCents,
+		TokenCredit:     p.TokenCredit,
+		IsSubscription:  p.IsSubscription,
+		StripePriceID:   p.StripePriceID,
+		AppleProductID:  p.AppleProductID,
+		GoogleProductID: p.GoogleProductID,
+		IsActive:        &p.IsActive,
+	}
+}
+
 // --- Handler Functions ---
 
 // handleGetProducts fetches the list of all purchasable items.
@@ -68,81 +181,101 @@ func (h *Handler) handleGetProducts(w http.ResponseWriter, r *http.Request) {
 	// TODO: Add auth middleware
 	// _, err := auth.GetUserID(r.Context())
 	// if err != nil {
-	// 	writeError(w, http.StatusUnauthorized, "Not authorized")
+	// 	httputil.WriteError(w, http.StatusUnauthorized, "Not authorized")
 	// 	return
 	// }
 
 	products, err := h.service.GetAvailableProducts(r.Context())
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "Could not fetch products")
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not fetch products")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, products)
+	httputil.WriteJSON(w, http.StatusOK, products)
 }
 
 // handleVerifyIAP receives a receipt from the client app and sends it to the service to be verified and to credit tokens.
 func (h *Handler) handleVerifyIAP(w http.ResponseWriter, r *http.Request) {
-	// TODO: Add auth middleware
-	// userID, err := auth.GetUserID(r.Context())
-	// if err != nil {
-	// 	writeError(w, http.StatusUnauthorized, "Not authorized")
-	// 	return
-	// }
-	// Faking userID for now
-	userID := uuid.New()
+	userID, err := auth.GetUserID(r.Context())
+	if err != nil {
+		httputil.WriteError(w, http.StatusUnauthorized, "Not authorized")
+		return
+	}
 
 	var req verifyIAPRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid request payload")
+	if err := jsonbody.Decode(w, r, &req); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid request payload")
 		return
 	}
 
-	var updatedUser *domain.User
-	var err error
+	if err := req.Validate(); err != nil {
+		jsonbody.WriteValidationError(w, err)
+		return
+	}
 
+	var updatedUser *domain.User
 	if req.Provider == "apple" {
 		updatedUser, err = h.service.VerifyAppleIAP(r.Context(), userID, req.Receipt)
-	} else if req.Provider == "google" {
-		updatedUser, err = h.service.VerifyGoogleIAP(r.Context(), userID, req.Receipt)
 	} else {
-		writeError(w, http.StatusBadRequest, "Invalid provider, must be 'apple' or 'google'")
-		return
+		updatedUser, err = h.service.VerifyGoogleIAP(r.Context(), userID, req.Receipt)
 	}
 
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "Could not verify purchase")
+		// The store rejected the receipt. The request was well formed, so this is a 422 and not our failure.
+		if errors.Is(err, ErrInvalidReceipt) {
+			httputil.WriteError(w, http.StatusUnprocessableEntity, "Receipt could not be verified")
+			return
+		}
+		if errors.Is(err, ErrPurchaseClaimed) {
+			httputil.WriteError(w, http.StatusConflict, "Purchase belongs to another account")
+			return
+		}
+		// No spending cap check here: the store has charged already, so the purchase is credited either way.
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not verify purchase")
 		return
 	}
 
 	// On success, return the user's updated profile (or just the new token balance)
-	writeJSON(w, http.StatusOK, updatedUser)
+	httputil.WriteJSON(w, http.StatusOK, updatedUser)
 }
 
 // handleCreateStripeIntent creates a Stripe PaymentIntent for credit card payments.
 func (h *Handler) handleCreateStripeIntent(w http.ResponseWriter, r *http.Request) {
-	// TODO: Add auth middleware
-	// userID, err := auth.GetUserID(r.Context())
-	// if err != nil {
-	// 	writeError(w, http.StatusUnauthorized, "Not authorized")
-	// 	return
-	// }
-	// Faking userID for now
-	userID := uuid.New()
+	userID, err := auth.GetUserID(r.Context())
+	if err != nil {
+		httputil.WriteError(w, http.StatusUnauthorized, "Not authorized")
+		return
+	}
 
 	var req createIntentRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid request payload")
+	if err := jsonbody.Decode(w, r, &req); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid request payload")
+		return
+	}
+
+	// Optional. The app sends the same key on every retry of one purchase, and gets the same intent back.
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength))
 		return
 	}
 
-	clientSecret, err := h.service.CreateStripeIntent(r.Context(), userID, req.ProductID)
+	clientSecret, err := h.service.CreateStripeIntent(r.Context(), userID, req.ProductID, idempotencyKey)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "Could not create payment intent")
+		switch {
+		case errors.Is(err, ErrNotFound):
+			httputil.WriteError(w, http.StatusNotFound, "Product not found")
+		case errors.Is(err, ErrIdempotencyKeyReused):
+			httputil.WriteError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different product")
+		case errors.Is(err, ErrSpendingCapExceeded):
+			httputil.WriteError(w, http.StatusTooManyRequests, "Spending limit reached, please try again later")
+		default:
+			httputil.WriteError(w, http.StatusInternalServerError, "Could not create payment intent")
+		}
 		return
 	}
 
-	writeJSON(w, http.StatusOK, createIntentResponse{ClientSecret: clientSecret})
+	httputil.WriteJSON(w, http.StatusOK, createIntentResponse{ClientSecret: clientSecret})
 }
 
 // handleStripeWebhook is the endpoint Stripe sends events to.
@@ -150,25 +283,92 @@ func (h *Handler) handleStripeWebhook(w http.ResponseWriter, r *http.Request) {
 
 	// err := h.service.HandleStripeEvent(r.Body)
 	// if err != nil {
-	// 	writeError(w, http.StatusBadRequest, "Failed to process webhook")
+	// 	httputil.WriteError(w, http.StatusBadRequest, "Failed to process webhook")
 	// 	return
 	// }
 
-	writeJSON(w, http.StatusOK, map[string]string{"status": "received"})
+	httputil.WriteJSON(w, http.StatusOK, map[string]string{"status": "received"})
+}
+
+// handleAdminListProducts returns the whole catalog, active or not.
+func (h *Handler) handleAdminListProducts(w http.ResponseWriter, r *http.Request) {
+	products, err := h.service.GetAllProducts(r.Context())
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not fetch products")
+		return
+	}
+
+	resp := make([]productPayload, len(products))
+	for i, p := range products {
+		resp[i] = newProductPayload(p)
+	}
+	httputil.WriteJSON(w, http.StatusOK, resp)
+}
+
+// handleAdminCreateProduct adds a new product to the catalog.
+func (h *Handler) handleAdminCreateProduct(w http.ResponseWriter, r *http.Request) {
+	var req productPayload
+	if err := jsonbody.Decode(w, r, &req); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid request payload")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	product := req.toProduct()
+	if err := h.service.CreateProduct(r.Context(), product); err != nil {
+		if errors.Is(err, ErrProductExists) {
+			httputil.WriteError(w, http.StatusConflict, "A product with that id already exists")
+			return
+		}
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not create product")
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusCreated, newProductPayload(product))
 }
 
-// --- Helper Functions ---
+// handleAdminUpdateProduct replaces an existing product. The id comes from the path, not the body.
+func (h *Handler) handleAdminUpdateProduct(w http.ResponseWriter, r *http.Request) {
+	var req productPayload
+	if err := jsonbody.Decode(w, r, &req); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid request payload")
+		return
+	}
+	req.ProductID = chi.URLParam(r, "id")
+	if err := req.Validate(); err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-// writeJSON is a helper function for sending json responses.
-func writeJSON(w http.ResponseWriter, status int, data interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	if data != nil {
-		json.NewEncoder(w).Encode(data)
+	product := req.toProduct()
+	if err := h.service.UpdateProduct(r.Context(), product); err != nil {
+		switch {
+		case errors.Is(err, ErrNotFound):
+			httputil.WriteError(w, http.StatusNotFound, "Product not found")
+		case errors.Is(err, ErrProductExists):
+			httputil.WriteError(w, http.StatusConflict, "Another product already uses that store id")
+		default:
+			httputil.WriteError(w, http.StatusInternalServerError, "Could not update product")
+		}
+		return
 	}
+
+	httputil.WriteJSON(w, http.StatusOK, newProductPayload(product))
 }
 
-// writeError is a helper for sending a standardized json error.
-func writeError(w http.ResponseWriter, status int, message string) {
-	writeJSON(w, status, map[string]string{"error": message})
+// handleAdminDeactivateProduct hides a product from the public product list.
+func (h *Handler) handleAdminDeactivateProduct(w http.ResponseWriter, r *http.Request) {
+	if err := h.service.DeactivateProduct(r.Context(), chi.URLParam(r, "id")); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			httputil.WriteError(w, http.StatusNotFound, "Product not found")
+			return
+		}
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not deactivate product")
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, map[string]string{"status": "deactivated"})
 }
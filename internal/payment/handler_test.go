@@ -0,0 +1,249 @@
+package payment
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"project-sage/internal/auth"
+	"project-sage/internal/domain"
+	"project-sage/internal/openapi"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"go.uber.org/mock/gomock"
+)
+
+// testUserID is who the purchase requests are signed in as, the way the auth middleware would.
+var testUserID = uuid.New()
+
+// setupHandlerTest initializes a router, mock service, and handler for testing.
+func setupHandlerTest(t *testing.T) (*chi.Mux, *MockService, *gomock.Controller) {
+	ctrl := gomock.NewController(t)
+	mockService := NewMockService(ctrl)
+
+	r := chi.NewRouter()
+	NewHandler(mockService).RegisterRoutes(r)
+
+	return r, mockService, ctrl
+}
+
+// postVerifyIAP sends a verify-iap request and returns the recorder.
+func postVerifyIAP(r http.Handler, provider, receipt string) *httptest.ResponseRecorder {
+	bodyBytes, _ := json.Marshal(verifyIAPRequest{Provider: provider, Receipt: receipt})
+	req := auth.SetUserID(httptest.NewRequest("POST", "/payment/verify-iap", bytes.NewBuffer(bodyBytes)), testUserID)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestHandleVerifyIAP_Success(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	mockService.EXPECT().
+		VerifyAppleIAP(gomock.Any(), testUserID, "receipt").
+		Return(&domain.User{AssistanceTokenBalance: 8}, nil)
+
+	rr := postVerifyIAP(r, "apple", "receipt")
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", rr.Code)
+	}
+}
+
+func TestHandleVerifyIAP_BadPayload(t *testing.T) {
+	r, _, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	// The service must not be called for any of these.
+	tests := []struct {
+		name       string
+		provider   string
+		receipt    string
+		wantFields map[string]string
+	}{
+		{"empty receipt", "apple", "", map[string]string{"receipt_data": "is required"}},
+		{"unknown provider", "amazon", "receipt", map[string]string{"provider": "must be 'apple' or 'google'"}},
+		{"missing provider", "", "receipt", map[string]string{"provider": "must be 'apple' or 'google'"}},
+		{"both", "", "", map[string]string{"provider": "must be 'apple' or 'google'", "receipt_data": "is required"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := postVerifyIAP(r, tt.provider, tt.receipt)
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400, got %d", rr.Code)
+			}
+			var body struct {
+				Error  string            `json:"error"`
+				Fields map[string]string `json:"fields"`
+			}
+			json.NewDecoder(rr.Body).Decode(&body)
+			if body.Error != "validation failed" || !reflect.DeepEqual(body.Fields, tt.wantFields) {
+				t.Errorf("Expected validation failed with %v, got %q with %v", tt.wantFields, body.Error, body.Fields)
+			}
+		})
+	}
+}
+
+func TestHandleVerifyIAP_InvalidReceipt(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	// The service wraps the client error, so the handler has to look through the chain.
+	mockService.EXPECT().
+		VerifyGoogleIAP(gomock.Any(), testUserID, "forged").
+		Return(nil, fmt.Errorf("google receipt verification failed: %w", ErrInvalidReceipt))
+
+	rr := postVerifyIAP(r, "google", "forged")
+
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422, got %d", rr.Code)
+	}
+}
+
+// TestHandleVerifyIAP_PurchaseClaimed checks a purchase credited to another account is a 409, not a 500.
+func TestHandleVerifyIAP_PurchaseClaimed(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	mockService.EXPECT().
+		VerifyGoogleIAP(gomock.Any(), testUserID, "shared").
+		Return(nil, ErrPurchaseClaimed)
+
+	rr := postVerifyIAP(r, "google", "shared")
+
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected status 409, got %d", rr.Code)
+	}
+}
+
+func TestHandleCreateStripeIntent_UnknownProduct(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	mockService.EXPECT().
+		CreateStripeIntent(gomock.Any(), testUserID, "no_such_pack", "").
+		Return("", fmt.Errorf("could not find product no_such_pack: %w", ErrNotFound))
+
+	rr := postCreateIntent(r, "no_such_pack", "")
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", rr.Code)
+	}
+}
+
+// postCreateIntent calls POST /payment/create-intent for productID, with an Idempotency-Key if key isn't empty.
+func postCreateIntent(r http.Handler, productID, key string) *httptest.ResponseRecorder {
+	bodyBytes, _ := json.Marshal(createIntentRequest{ProductID: productID})
+	req := auth.SetUserID(httptest.NewRequest("POST", "/payment/create-intent", bytes.NewBuffer(bodyBytes)), testUserID)
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+// TestHandleCreateStripeIntent_IdempotencyKey sends the same key twice and checks both get the same secret,
+// that the header reaches the service for the same user both times, and that a key reused for another product is a 422.
+func TestHandleCreateStripeIntent_IdempotencyKey(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	gomock.InOrder(
+		mockService.EXPECT().CreateStripeIntent(gomock.Any(), testUserID, "pack_5_tokens", "retry-key-1").Return("secret_1", nil).Times(2),
+		mockService.EXPECT().CreateStripeIntent(gomock.Any(), testUserID, "pack_20_tokens", "retry-key-1").Return("", ErrIdempotencyKeyReused),
+	)
+
+	for i := 0; i < 2; i++ {
+		rr := postCreateIntent(r, "pack_5_tokens", "retry-key-1")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Attempt %d: expected status 200, got %d", i+1, rr.Code)
+		}
+		var resp createIntentResponse
+		json.NewDecoder(rr.Body).Decode(&resp)
+		if resp.ClientSecret != "secret_1" {
+			t.Errorf("Attempt %d: expected client secret 'secret_1', got %q", i+1, resp.ClientSecret)
+		}
+	}
+	if rr := postCreateIntent(r, "pack_20_tokens", "retry-key-1"); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422 for a reused key, got %d", rr.Code)
+	}
+	// Too long never reaches the service.
+	if rr := postCreateIntent(r, "pack_5_tokens", strings.Repeat("k", 201)); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a long key, got %d", rr.Code)
+	}
+}
+
+// TestHandlePurchases_NoCaller checks nothing is bought or credited for a request the auth middleware didn't sign in.
+func TestHandlePurchases_NoCaller(t *testing.T) {
+	r, _, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	for _, tc := range []struct{ path, body string }{
+		{"/payment/verify-iap", `{"provider": "apple", "receipt_data": "receipt"}`},
+		{"/payment/create-intent", `{"product_id": "pack_5_tokens"}`},
+	} {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("POST", tc.path, strings.NewReader(tc.body)))
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("POST %s: expected status 401, got %d", tc.path, rr.Code)
+		}
+	}
+}
+
+// TestOpenAPISpec checks the served spec is valid OpenAPI and describes exactly the routes the handler registers.
+func TestOpenAPISpec(t *testing.T) {
+	r, _, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/openapi.json", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	if err := openapi.Validate(rr.Body.Bytes()); err != nil {
+		t.Fatalf("Served spec is not valid: %v", err)
+	}
+	missing, extra, err := openapi.Diff(r, rr.Body.Bytes())
+	if err != nil {
+		t.Fatalf("Diff() returned error: %v", err)
+	}
+	if len(missing) > 0 || len(extra) > 0 {
+		t.Errorf("Spec is out of step with the routes. Undocumented: %v. Documented but not routed: %v", missing, extra)
+	}
+}
+
+// TestHandleAdminProducts_Roles checks the catalog admin routes are for superadmins only.
+func TestHandleAdminProducts_Roles(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	mockService.EXPECT().GetAllProducts(gomock.Any()).Return([]*domain.Product{}, nil).Times(1)
+
+	for _, tc := range []struct {
+		method, path, role string
+		want               int
+	}{
+		{"GET", "/payment/admin/products", auth.RoleSuperadmin, http.StatusOK},
+		{"GET", "/payment/admin/products", auth.RoleUser, http.StatusForbidden},
+		{"POST", "/payment/admin/products", "", http.StatusUnauthorized},
+		{"PUT", "/payment/admin/products/pack-5", auth.RoleExpert, http.StatusForbidden},
+		{"POST", "/payment/admin/products/pack-5/deactivate", "", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader("{}"))
+		if tc.role != "" {
+			req = auth.SetRole(req, tc.role)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if rr.Code != tc.want {
+			t.Errorf("%s %s as %q: expected status %d, got %d", tc.method, tc.path, tc.role, tc.want, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package payment
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Apps retry /payment/create-intent on a flaky network, and without help every retry is another PaymentIntent.
+// A client sends an Idempotency-Key header with the request, the same on every retry. We keep the client secret
+// we got for each key, so a retry gets the first intent's secret back without going to Stripe again. The key also
+// goes to Stripe, which dedupes it on its side, so a retry that lands on another instance still gets the same intent.
+
+// DefaultIdempotencyKeyTTL is how long a key is remembered. It's as long as Stripe keeps its own.
+const DefaultIdempotencyKeyTTL = 24 * time.Hour
+
+// maxIdempotencyKeyLength leaves room for the user id we put in front of the key before it goes to Stripe,
+// which allows 255 characters.
+const maxIdempotencyKeyLength = 200
+
+// maxIntentKeys is how many keys we keep before pruning the expired ones.
+const maxIntentKeys = 10000
+
+type intentKey struct {
+	userID uuid.UUID
+	key    string
+}
+
+type intentKeyEntry struct {
+	productID    string
+	clientSecret string
+	expires      time.Time
+}
+
+// intentKeyStore remembers the client secret created for each user's idempotency key, for a TTL.
+// Keys are per user, so one user's key can never hand back another's secret. Each instance has its own.
+type intentKeyStore struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[intentKey]intentKeyEntry
+	now     func() time.Time // Swappable for tests.
+}
+
+func newIntentKeyStore(ttl time.Duration) *intentKeyStore {
+	return &intentKeyStore{
+		ttl:     ttl,
+		entries: make(map[intentKey]intentKeyEntry),
+		now:     time.Now,
+	}
+}
+
+// get returns what the user's key was used for, if it was used within the TTL.
+func (s *intentKeyStore) get(userID uuid.UUID, key string) (intentKeyEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[intentKey{userID, key}]
+	if !ok || !s.now().Before(e.expires) {
+		return intentKeyEntry{}, false
+	}
+	return e, true
+}
+
+// put records the intent created for the user's key.
+func (s *intentKeyStore) put(userID uuid.UUID, key, productID, clientSecret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if len(s.entries) >= maxIntentKeys {
+		for k, e := range s.entries {
+			if !now.Before(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+	}
+	s.entries[intentKey{userID, key}] = intentKeyEntry{
+		productID:    productID,
+		clientSecret: clientSecret,
+		expires:      now.Add(s.ttl),
+	}
+}
+
+// stripeIdempotencyKey is the key sent to Stripe. Stripe's keys are per account, so the user goes in front.
+func stripeIdempotencyKey(userID uuid.UUID, key string) string {
+	return "intent:" + userID.String() + ":" + key
+}
@@ -6,43 +6,115 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"project-sage/internal/database"
 	"project-sage/internal/domain"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 // Repository defines the database operations for the payment service.
 type Repository interface {
-	// GetProducts fetches all products from the products table
+	// GetProducts fetches all active products from the products table
 	GetProducts(ctx context.Context) ([]*domain.Product, error)
+	// GetAllProducts fetches every product, including inactive ones, for the admin list.
+	GetAllProducts(ctx context.Context) ([]*domain.Product, error)
+	// CreateProduct inserts a new product. Returns ErrProductExists if the id is taken.
+	CreateProduct(ctx context.Context, p *domain.Product) error
+	// UpdateProduct overwrites all editable fields of an existing product, including is_active.
+	UpdateProduct(ctx context.Context, p *domain.Product) error
+	// DeactivateProduct hides a product from the store without deleting it, since old transactions reference it.
+	DeactivateProduct(ctx context.Context, productID string) error
 	// GetProductByID fetches a single product by its ID or Apple/Google ID.
 	GetProductByID(ctx context.Context, productID string) (*domain.Product, error)
-	// CreateTransaction logs a successful purchase
-	CreateTransaction(ctx context.Context, tx *domain.PaymentTransaction) error
+	// GetProductByProviderID fetches a single product by the store's own ID for it, eg the Apple product ID for "apple".
+	GetProductByProviderID(ctx context.Context, provider, providerProductID string) (*domain.Product, error)
+	// GetOrCreateTransaction logs a successful purchase, unless the store's transaction id was logged before, by any
+	// user. Then tx is overwritten with the row that's there and created is false.
+	GetOrCreateTransaction(ctx context.Context, tx *domain.PaymentTransaction) (created bool, err error)
+	// GetRecentTransactions fetches a user's successful purchases since the given time.
+	GetRecentTransactions(ctx context.Context, userID uuid.UUID, since time.Time) ([]*domain.PaymentTransaction, error)
+}
+
+// RepositoryOptions are the repository's optional settings. The zero value has no query timeout.
+type RepositoryOptions struct {
+	QueryTimeout time.Duration // How long each repository call gets before it fails with context.DeadlineExceeded. 0 means no limit
 }
 
 // postgresRepository is the concrete implementation.
 type postgresRepository struct {
-	db *sql.DB
+	db           *sql.DB
+	queryTimeout time.Duration // 0 means no limit
 }
 
-// NewPostgresRepository is the constructor for the repository
+// NewPostgresRepository is the constructor for the repository. Each call gets database.DefaultQueryTimeout.
 func NewPostgresRepository(db *sql.DB) Repository {
+	return NewPostgresRepositoryWithOptions(db, RepositoryOptions{QueryTimeout: database.DefaultQueryTimeout})
+}
+
+// NewPostgresRepositoryWithOptions is the same as NewPostgresRepository, with the query timeout from opts.
+func NewPostgresRepositoryWithOptions(db *sql.DB, opts RepositoryOptions) Repository {
 	return &postgresRepository{
-		db: db,
+		db:           db,
+		queryTimeout: max(opts.QueryTimeout, 0),
+	}
+}
+
+// productColumns is the column list every product query selects. It must match scanProduct.
+const productColumns = `
+	product_id, name, description, price_cents,
+	token_credit, is_subscription, stripe_price_id,
+	apple_product_id, google_product_id, is_active
+`
+
+// scanProduct scans one row selected with productColumns.
+func scanProduct(row interface{ Scan(...any) error }) (*domain.Product, error) {
+	var p domain.Product
+	err := row.Scan(
+		&p.ProductID,
+		&p.Name,
+		&p.Description,
+		&p.PriceCents,
+		&p.TokenCredit,
+		&p.IsSubscription,
+		&p.StripePriceID,
+		&p.AppleProductID,
+		&p.GoogleProductID,
+		&p.IsActive,
+	)
+	if err != nil {
+		return nil, err
 	}
+	return &p, nil
 }
 
 // GetProducts fetches all purchasable products from the database.
 func (pr *postgresRepository) GetProducts(ctx context.Context) ([]*domain.Product, error) {
-	query := `
-		SELECT 
-			product_id, name, description, price_cents, 
-			token_credit, is_subscription, stripe_price_id, 
-			apple_product_id, google_product_id
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	query := `SELECT ` + productColumns + `
 		FROM products
 		WHERE is_active = true
 		ORDER BY price_cents ASC
 	`
+	return pr.queryProducts(ctx, query)
+}
+
+// GetAllProducts fetches every product, active or not. Admin only.
+func (pr *postgresRepository) GetAllProducts(ctx context.Context) ([]*domain.Product, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	query := `SELECT ` + productColumns + `
+		FROM products
+		ORDER BY is_active DESC, price_cents ASC
+	`
+	return pr.queryProducts(ctx, query)
+}
 
+// queryProducts runs a product list query and scans all the rows.
+func (pr *postgresRepository) queryProducts(ctx context.Context, query string) ([]*domain.Product, error) {
 	rows, err := pr.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("could not query products: %w", err)
@@ -51,69 +123,202 @@ func (pr *postgresRepository) GetProducts(ctx context.Context) ([]*domain.Produc
 
 	var products []*domain.Product
 	for rows.Next() {
-		var p domain.Product
-		if err := rows.Scan(
-			&p.ProductID,
-			&p.Name,
-			&p.Description,
-			&p.PriceCents,
-			&p.TokenCredit,
-			&p.IsSubscription,
-			&p.StripePriceID,
-			&p.AppleProductID,
-			&p.GoogleProductID,
-		); err != nil {
+		p, err := scanProduct(rows)
+		if err != nil {
 			return nil, fmt.Errorf("could not scan product: %w", err)
 		}
-		products = append(products, &p)
+		products = append(products, p)
 	}
-	return products, nil
+	return products, rows.Err()
 }
 
 // GetProductByID fetches a single product
 func (pr *postgresRepository) GetProductByID(ctx context.Context, productID string) (*domain.Product, error) {
-	query := `
-		SELECT 
-			product_id, name, description, price_cents, 
-			token_credit, is_subscription, stripe_price_id, 
-			apple_product_id, google_product_id
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	query := `SELECT ` + productColumns + `
 		FROM products
 		WHERE product_id = $1 
 			OR apple_product_id = $1 
 			OR google_product_id = $1
 	`
 
-	var p domain.Product
-	err := pr.db.QueryRowContext(ctx, query, productID).Scan(
-		&p.ProductID,
-		&p.Name,
-		&p.Description,
-		&p.PriceCents,
-		&p.TokenCredit,
-		&p.IsSubscription,
-		&p.StripePriceID,
-		&p.AppleProductID,
-		&p.GoogleProductID,
-	)
+	p, err := scanProduct(pr.db.QueryRowContext(ctx, query, productID))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("product not found")
+			return nil, ErrNotFound
 		}
 		return nil, fmt.Errorf("could not get product: %w", err)
 	}
-	return &p, nil
+	return p, nil
+}
+
+// providerProductColumns is the products column holding each store's ID for a product.
+var providerProductColumns = map[string]string{
+	"apple":  "apple_product_id",
+	"google": "google_product_id",
 }
 
-// CreateTransaction inserts a new row into payment_transactions.
-func (pr *postgresRepository) CreateTransaction(ctx context.Context, tx *domain.PaymentTransaction) error {
+// GetProductByProviderID fetches a single product by the ID the given store uses for it.
+// Unlike GetProductByID it only looks at that store's column, so an ID from one store (or our own product_id)
+// can't be passed off as a purchase from the other.
+func (pr *postgresRepository) GetProductByProviderID(ctx context.Context, provider, providerProductID string) (*domain.Product, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	column, ok := providerProductColumns[provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q", provider)
+	}
+	// The column comes from the map above, never from the caller.
+	query := `SELECT ` + productColumns + `
+		FROM products
+		WHERE ` + column + ` = $1
+	`
+
+	p, err := scanProduct(pr.db.QueryRowContext(ctx, query, providerProductID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("could not get product: %w", err)
+	}
+	return p, nil
+}
+
+// CreateProduct inserts a new row into products.
+func (pr *postgresRepository) CreateProduct(ctx context.Context, p *domain.Product) error {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	query := `
+		INSERT INTO products
+			(product_id, name, description, price_cents, token_credit, is_subscription,
+			 stripe_price_id, apple_product_id, google_product_id, is_active)
+		VALUES
+			($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+	_, err := pr.db.ExecContext(ctx, query,
+		p.ProductID,
+		p.Name,
+		p.Description,
+		p.PriceCents,
+		p.TokenCredit,
+		p.IsSubscription,
+		p.StripePriceID,
+		p.AppleProductID,
+		p.GoogleProductID,
+		p.IsActive,
+	)
+	if err != nil {
+		if database.IsUniqueViolation(err) {
+			return ErrProductExists
+		}
+		return fmt.Errorf("could not insert product: %w", err)
+	}
+	return nil
+}
+
+// UpdateProduct overwrites an existing product by its product_id.
+func (pr *postgresRepository) UpdateProduct(ctx context.Context, p *domain.Product) error {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	query := `
+		UPDATE products
+		SET name = $2, description = $3, price_cents = $4, token_credit = $5, is_subscription = $6,
+			stripe_price_id = $7, apple_product_id = $8, google_product_id = $9, is_active = $10
+		WHERE product_id = $1
+	`
+	res, err := pr.db.ExecContext(ctx, query,
+		p.ProductID,
+		p.Name,
+		p.Description,
+		p.PriceCents,
+		p.TokenCredit,
+		p.IsSubscription,
+		p.StripePriceID,
+		p.AppleProductID,
+		p.GoogleProductID,
+		p.IsActive,
+	)
+	if err != nil {
+		// The store ids are unique too, so an update can collide with another product.
+		if database.IsUniqueViolation(err) {
+			return ErrProductExists
+		}
+		return fmt.Errorf("could not update product: %w", err)
+	}
+	return checkProductUpdated(res)
+}
+
+// DeactivateProduct sets is_active to false. Deactivating an inactive product is not an error.
+func (pr *postgresRepository) DeactivateProduct(ctx context.Context, productID string) error {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	query := `UPDATE products SET is_active = false WHERE product_id = $1`
+	res, err := pr.db.ExecContext(ctx, query, productID)
+	if err != nil {
+		return fmt.Errorf("could not deactivate product: %w", err)
+	}
+	return checkProductUpdated(res)
+}
+
+// checkProductUpdated turns "no rows affected" into ErrNotFound.
+func checkProductUpdated(res sql.Result) error {
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// transactionColumns is the column list every transaction query selects. It must match scanTransaction.
+const transactionColumns = `
+	transaction_id, user_id, product_id, amount_cents,
+	provider, provider_transaction_id, status, created_at
+`
+
+// scanTransaction scans one row selected with transactionColumns.
+func scanTransaction(row interface{ Scan(...any) error }) (*domain.PaymentTransaction, error) {
+	var tx domain.PaymentTransaction
+	err := row.Scan(
+		&tx.TransactionID,
+		&tx.UserID,
+		&tx.ProductID,
+		&tx.AmountCents,
+		&tx.Provider,
+		&tx.ProviderTransactionID,
+		&tx.Status,
+		&tx.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+// GetOrCreateTransaction implements the interface like the UserService's GetOrCreateUser. The unique index on
+// (provider, provider_transaction_id) makes the insert do nothing for a purchase that's already logged, and then
+// the row that's there is read back in a separate statement so it sees a concurrent insert once committed.
+func (pr *postgresRepository) GetOrCreateTransaction(ctx context.Context, tx *domain.PaymentTransaction) (bool, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
 	query := `
 		INSERT INTO payment_transactions
 			(transaction_id, user_id, product_id, amount_cents, 
 			 provider, provider_transaction_id, status, created_at)
 		VALUES
 			($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (provider, provider_transaction_id) DO NOTHING
 	`
-	_, err := pr.db.ExecContext(ctx, query,
+	res, err := pr.db.ExecContext(ctx, query,
 		tx.TransactionID,
 		tx.UserID,
 		tx.ProductID,
@@ -124,7 +329,60 @@ func (pr *postgresRepository) CreateTransaction(ctx context.Context, tx *domain.
 		tx.CreatedAt,
 	)
 	if err != nil {
-		return fmt.Errorf("could not insert transaction: %w", err)
+		return false, fmt.Errorf("could not insert transaction: %w", err)
 	}
-	return nil
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("could not check rows affected: %w", err)
+	}
+	if inserted == 1 {
+		return true, nil
+	}
+
+	// Already there, so hand back the existing row.
+	row := pr.db.QueryRowContext(ctx, `
+		SELECT `+transactionColumns+`
+		FROM payment_transactions
+		WHERE provider = $1 AND provider_transaction_id = $2
+	`, tx.Provider, tx.ProviderTransactionID)
+	existing, err := scanTransaction(row)
+	if err != nil {
+		return false, fmt.Errorf("could not read existing transaction: %w", err)
+	}
+	*tx = *existing
+	return false, nil
+}
+
+// GetRecentTransactions fetches a user's succeeded transactions created at or after since, newest first.
+func (pr *postgresRepository) GetRecentTransactions(ctx context.Context, userID uuid.UUID, since time.Time) ([]*domain.PaymentTransaction, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + transactionColumns + `
+		FROM payment_transactions
+		WHERE user_id = $1
+			AND status = 'succeeded'
+			AND created_at >= $2
+		ORDER BY created_at DESC
+	`
+
+	rows, err := pr.db.QueryContext(ctx, query, userID, since)
+	if err != nil {
+		return nil, fmt.Errorf("could not query recent transactions: %w", err)
+	}
+	defer rows.Close()
+
+	var txs []*domain.PaymentTransaction
+	for rows.Next() {
+		tx, err := scanTransaction(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan transaction: %w", err)
+		}
+		txs = append(txs, tx)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not query recent transactions: %w", err)
+	}
+	return txs, nil
 }
@@ -0,0 +1,176 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: repository.go
+//
+// Generated by this command:
+//
+//	mockgen -destination=./repository_mock_test.go -package=payment -source=repository.go Repository
+//
+
+// Package payment is a generated GoMock package.
+package payment
+
+import (
+	context "context"
+	domain "project-sage/internal/domain"
+	reflect "reflect"
+	time "time"
+
+	uuid "github.com/google/uuid"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockRepository is a mock of Repository interface.
+type MockRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockRepositoryMockRecorder
+	isgomock struct{}
+}
+
+// MockRepositoryMockRecorder is the mock recorder for MockRepository.
+type MockRepositoryMockRecorder struct {
+	mock *MockRepository
+}
+
+// NewMockRepository creates a new mock instance.
+func NewMockRepository(ctrl *gomock.Controller) *MockRepository {
+	mock := &MockRepository{ctrl: ctrl}
+	mock.recorder = &MockRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRepository) EXPECT() *MockRepositoryMockRecorder {
+	return m.recorder
+}
+
+// CreateProduct mocks base method.
+func (m *MockRepository) CreateProduct(ctx context.Context, p *domain.Product) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateProduct", ctx, p)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateProduct indicates an expected call of CreateProduct.
+func (mr *MockRepositoryMockRecorder) CreateProduct(ctx, p any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateProduct", reflect.TypeOf((*MockRepository)(nil).CreateProduct), ctx, p)
+}
+
+// DeactivateProduct mocks base method.
+func (m *MockRepository) DeactivateProduct(ctx context.Context, productID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeactivateProduct", ctx, productID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeactivateProduct indicates an expected call of DeactivateProduct.
+func (mr *MockRepositoryMockRecorder) DeactivateProduct(ctx, productID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeactivateProduct", reflect.TypeOf((*MockRepository)(nil).DeactivateProduct), ctx, productID)
+}
+
+// GetAllProducts mocks base method.
+func (m *MockRepository) GetAllProducts(ctx context.Context) ([]*domain.Product, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAllProducts", ctx)
+	ret0, _ := ret[0].([]*domain.Product)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAllProducts indicates an expected call of GetAllProducts.
+func (mr *MockRepositoryMockRecorder) GetAllProducts(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllProducts", reflect.TypeOf((*MockRepository)(nil).GetAllProducts), ctx)
+}
+
+// GetOrCreateTransaction mocks base method.
+func (m *MockRepository) GetOrCreateTransaction(ctx context.Context, tx *domain.PaymentTransaction) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOrCreateTransaction", ctx, tx)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOrCreateTransaction indicates an expected call of GetOrCreateTransaction.
+func (mr *MockRepositoryMockRecorder) GetOrCreateTransaction(ctx, tx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrCreateTransaction", reflect.TypeOf((*MockRepository)(nil).GetOrCreateTransaction), ctx, tx)
+}
+
+// GetProductByID mocks base method.
+func (m *MockRepository) GetProductByID(ctx context.Context, productID string) (*domain.Product, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetProductByID", ctx, productID)
+	ret0, _ := ret[0].(*domain.Product)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetProductByID indicates an expected call of GetProductByID.
+func (mr *MockRepositoryMockRecorder) GetProductByID(ctx, productID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetProductByID", reflect.TypeOf((*MockRepository)(nil).GetProductByID), ctx, productID)
+}
+
+// GetProductByProviderID mocks base method.
+func (m *MockRepository) GetProductByProviderID(ctx context.Context, provider, providerProductID string) (*domain.Product, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetProductByProviderID", ctx, provider, providerProductID)
+	ret0, _ := ret[0].(*domain.Product)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetProductByProviderID indicates an expected call of GetProductByProviderID.
+func (mr *MockRepositoryMockRecorder) GetProductByProviderID(ctx, provider, providerProductID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetProductByProviderID", reflect.TypeOf((*MockRepository)(nil).GetProductByProviderID), ctx, provider, providerProductID)
+}
+
+// GetProducts mocks base method.
+func (m *MockRepository) GetProducts(ctx context.Context) ([]*domain.Product, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetProducts", ctx)
+	ret0, _ := ret[0].([]*domain.Product)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetProducts indicates an expected call of GetProducts.
+func (mr *MockRepositoryMockRecorder) GetProducts(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetProducts", reflect.TypeOf((*MockRepository)(nil).GetProducts), ctx)
+}
+
+// GetRecentTransactions mocks base method.
+func (m *MockRepository) GetRecentTransactions(ctx context.Context, userID uuid.UUID, since time.Time) ([]*domain.PaymentTransaction, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRecentTransactions", ctx, userID, since)
+	ret0, _ := ret[0].([]*domain.PaymentTransaction)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRecentTransactions indicates an expected call of GetRecentTransactions.
+func (mr *MockRepositoryMockRecorder) GetRecentTransactions(ctx, userID, since any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRecentTransactions", reflect.TypeOf((*MockRepository)(nil).GetRecentTransactions), ctx, userID, since)
+}
+
+// UpdateProduct mocks base method.
+func (m *MockRepository) UpdateProduct(ctx context.Context, p *domain.Product) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateProduct", ctx, p)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateProduct indicates an expected call of UpdateProduct.
+func (mr *MockRepositoryMockRecorder) UpdateProduct(ctx, p any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateProduct", reflect.TypeOf((*MockRepository)(nil).UpdateProduct), ctx, p)
+}
@@ -0,0 +1,212 @@
+package payment
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"os"
+	"project-sage/internal/domain"
+	"testing"
+)
+
+// Shared connection for the product catalog integration tests.
+// This package also has plain unit tests, so a missing TEST_DB_URL only skips the tests in this file.
+var (
+	testDB   *sql.DB
+	testRepo Repository
+)
+
+// TestMain connects to the test database if one is configured, then runs every test in the package.
+func TestMain(m *testing.M) {
+	connStr := os.Getenv("TEST_DB_URL")
+	if connStr == "" {
+		log.Println("TEST_DB_URL not set. Skipping payment integration tests.")
+		os.Exit(m.Run())
+	}
+
+	var err error
+	testDB, err = sql.Open("pgx", connStr)
+	if err != nil {
+		log.Fatalf("Could not connect to test database: %v", err)
+	}
+
+	testRepo = NewPostgresRepository(testDB)
+
+	cleanProducts()
+	code := m.Run()
+
+	cleanProducts()
+	testDB.Close()
+	os.Exit(code)
+}
+
+// cleanProducts removes the products the tests created. They all use the 'test-admin-' prefix.
+func cleanProducts() {
+	testDB.Exec("DELETE FROM products WHERE product_id LIKE 'test-admin-%'")
+}
+
+// requireDB skips the test when there's no database.
+func requireDB(t *testing.T) {
+	t.Helper()
+	if testDB == nil {
+		t.Skip("TEST_DB_URL not set")
+	}
+}
+
+// newTestProduct returns an active token pack with the given id.
+func newTestProduct(id string) *domain.Product {
+	return &domain.Product{
+		ProductID:       id,
+		Name:            "Test Pack",
+		Description:     "Three tokens",
+		PriceCents:      499,
+		TokenCredit:     3,
+		StripePriceID:   "price_" + id,
+		AppleProductID:  "apple." + id,
+		GoogleProductID: "google." + id,
+		IsActive:        true,
+	}
+}
+
+// containsProduct reports whether the list has a product with the given id.
+func containsProduct(products []*domain.Product, id string) bool {
+	for _, p := range products {
+		if p.ProductID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// TestCreateThenDeactivateProduct verifies a deactivated product drops out of the public list but stays in the admin one.
+func TestCreateThenDeactivateProduct(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+	cleanProducts()
+
+	product := newTestProduct("test-admin-pack")
+	if err := testRepo.CreateProduct(ctx, product); err != nil {
+		t.Fatalf("CreateProduct() returned error: %v", err)
+	}
+
+	public, err := testRepo.GetProducts(ctx)
+	if err != nil {
+		t.Fatalf("GetProducts() returned error: %v", err)
+	}
+	if !containsProduct(public, product.ProductID) {
+		t.Fatal("New product is missing from the public list")
+	}
+
+	if err := testRepo.DeactivateProduct(ctx, product.ProductID); err != nil {
+		t.Fatalf("DeactivateProduct() returned error: %v", err)
+	}
+
+	public, err = testRepo.GetProducts(ctx)
+	if err != nil {
+		t.Fatalf("GetProducts() returned error: %v", err)
+	}
+	if containsProduct(public, product.ProductID) {
+		t.Error("Deactivated product is still in the public list")
+	}
+
+	all, err := testRepo.GetAllProducts(ctx)
+	if err != nil {
+		t.Fatalf("GetAllProducts() returned error: %v", err)
+	}
+	if !containsProduct(all, product.ProductID) {
+		t.Error("Deactivated product is missing from the admin list")
+	}
+
+	// It can still be looked up, so receipts for old purchases keep resolving.
+	got, err := testRepo.GetProductByProviderID(ctx, "apple", product.AppleProductID)
+	if err != nil {
+		t.Fatalf("GetProductByProviderID() returned error: %v", err)
+	}
+	if got.IsActive {
+		t.Error("Expected the product to be inactive")
+	}
+}
+
+// TestUpdateProduct verifies an update replaces the fields and can reactivate a product.
+func TestUpdateProduct(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+	cleanProducts()
+
+	product := newTestProduct("test-admin-update")
+	product.IsActive = false
+	if err := testRepo.CreateProduct(ctx, product); err != nil {
+		t.Fatalf("CreateProduct() returned error: %v", err)
+	}
+
+	product.PriceCents = 999
+	product.IsActive = true
+	if err := testRepo.UpdateProduct(ctx, product); err != nil {
+		t.Fatalf("UpdateProduct() returned error: %v", err)
+	}
+
+	got, err := testRepo.GetProductByID(ctx, product.ProductID)
+	if err != nil {
+		t.Fatalf("GetProductByID() returned error: %v", err)
+	}
+	if got.PriceCents != 999 || !got.IsActive {
+		t.Errorf("Update did not stick: %+v", got)
+	}
+}
+
+// TestProductErrors verifies the sentinel errors for a duplicate id and an unknown product.
+func TestProductErrors(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+	cleanProducts()
+
+	product := newTestProduct("test-admin-dup")
+	if err := testRepo.CreateProduct(ctx, product); err != nil {
+		t.Fatalf("CreateProduct() returned error: %v", err)
+	}
+	if err := testRepo.CreateProduct(ctx, newTestProduct("test-admin-dup")); !errors.Is(err, ErrProductExists) {
+		t.Errorf("Expected ErrProductExists, got: %v", err)
+	}
+
+	if err := testRepo.DeactivateProduct(ctx, "test-admin-missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound from DeactivateProduct, got: %v", err)
+	}
+	if err := testRepo.UpdateProduct(ctx, newTestProduct("test-admin-missing")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound from UpdateProduct, got: %v", err)
+	}
+}
+
+// TestGetProductByProviderID verifies a store ID only resolves for its own store,
+// so an Apple product ID can't be redeemed through a Google verification.
+func TestGetProductByProviderID(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+	cleanProducts()
+
+	product := newTestProduct("test-admin-provider")
+	if err := testRepo.CreateProduct(ctx, product); err != nil {
+		t.Fatalf("CreateProduct() returned error: %v", err)
+	}
+
+	got, err := testRepo.GetProductByProviderID(ctx, "google", product.GoogleProductID)
+	if err != nil {
+		t.Fatalf("GetProductByProviderID() returned error: %v", err)
+	}
+	if got.ProductID != product.ProductID {
+		t.Errorf("Expected product %s, got %s", product.ProductID, got.ProductID)
+	}
+
+	// The Apple ID and our own ID mean nothing to Google.
+	for _, id := range []string{product.AppleProductID, product.ProductID} {
+		if _, err := testRepo.GetProductByProviderID(ctx, "google", id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for %q during a google verification, got: %v", id, err)
+		}
+	}
+	if _, err := testRepo.GetProductByProviderID(ctx, "apple", product.GoogleProductID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for the google ID during an apple verification, got: %v", err)
+	}
+	if _, err := testRepo.GetProductByProviderID(ctx, "stripe", product.StripePriceID); err == nil {
+		t.Error("Expected an error for an unknown provider")
+	}
+}
@@ -1,9 +1,15 @@
 package payment
 
+//go:generate mockgen -destination=./service_mock_test.go -package=payment -source=service.go Service
+
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"project-sage/internal/domain"
+	"project-sage/internal/usercache"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,8 +20,37 @@ type Service interface {
 	GetAvailableProducts(ctx context.Context) ([]*domain.Product, error)
 	VerifyAppleIAP(ctx context.Context, userID uuid.UUID, receipt string) (*domain.User, error)
 	VerifyGoogleIAP(ctx context.Context, userID uuid.UUID, receipt string) (*domain.User, error)
-	CreateStripeIntent(ctx context.Context, userID uuid.UUID, productID string) (string, error)
+	CreateStripeIntent(ctx context.Context, userID uuid.UUID, productID, idempotencyKey string) (string, error)
 	HandleStripeEvent(ctx context.Context, payload []byte) error
+
+	// Admin operations for managing the product catalog
+	GetAllProducts(ctx context.Context) ([]*domain.Product, error)
+	CreateProduct(ctx context.Context, p *domain.Product) error
+	UpdateProduct(ctx context.Context, p *domain.Product) error
+	DeactivateProduct(ctx context.Context, productID string) error
+}
+
+// ServiceConfig holds the tunable business rules for the payment service.
+type ServiceConfig struct {
+	// SpendingCapCents is the most a user may spend within SpendingWindow. 0 disables the cap.
+	SpendingCapCents int
+	// SpendingWindow is the rolling window the cap applies to.
+	SpendingWindow time.Duration
+	// Currency is the ISO currency code Stripe charges product prices in, eg "usd".
+	Currency string
+	// IdempotencyKeyTTL is how long a create-intent Idempotency-Key returns the same client secret. 0 means
+	// DefaultIdempotencyKeyTTL.
+	IdempotencyKeyTTL time.Duration
+}
+
+// DefaultServiceConfig returns the rules used when nothing is configured: $100 per rolling 24 hours, charged in USD.
+func DefaultServiceConfig() ServiceConfig {
+	return ServiceConfig{
+		SpendingCapCents:  10000,
+		SpendingWindow:    24 * time.Hour,
+		Currency:          "usd",
+		IdempotencyKeyTTL: DefaultIdempotencyKeyTTL,
+	}
 }
 
 // service is the concrete implementation.
@@ -26,6 +61,9 @@ type service struct {
 	appleClient   AppleClient
 	googleClient  GoogleClient
 	stripeClient  StripeClient
+	customers     StripeCustomerStore
+	cfg           ServiceConfig
+	intentKeys    *intentKeyStore
 }
 
 // NewService is the constructor. It injects all required dependencies.
@@ -36,7 +74,12 @@ func NewService(
 	ac AppleClient,
 	gc GoogleClient,
 	sc StripeClient,
+	cs StripeCustomerStore,
+	cfg ServiceConfig,
 ) Service {
+	if cfg.IdempotencyKeyTTL <= 0 {
+		cfg.IdempotencyKeyTTL = DefaultIdempotencyKeyTTL
+	}
 	return &service{
 		repo:          r,
 		billingClient: bc,
@@ -44,6 +87,9 @@ func NewService(
 		appleClient:   ac,
 		googleClient:  gc,
 		stripeClient:  sc,
+		customers:     cs,
+		cfg:           cfg,
+		intentKeys:    newIntentKeyStore(cfg.IdempotencyKeyTTL),
 	}
 }
 
@@ -54,61 +100,118 @@ func (s *service) GetAvailableProducts(ctx context.Context) ([]*domain.Product,
 	return s.repo.GetProducts(ctx)
 }
 
+// GetAllProducts is a pass through to the repository. Unlike GetAvailableProducts it includes inactive products.
+func (s *service) GetAllProducts(ctx context.Context) ([]*domain.Product, error) {
+	return s.repo.GetAllProducts(ctx)
+}
+
+// CreateProduct is a pass through to the repository. The handler has already validated the fields.
+func (s *service) CreateProduct(ctx context.Context, p *domain.Product) error {
+	return s.repo.CreateProduct(ctx, p)
+}
+
+// UpdateProduct is a pass through to the repository.
+func (s *service) UpdateProduct(ctx context.Context, p *domain.Product) error {
+	return s.repo.UpdateProduct(ctx, p)
+}
+
+// DeactivateProduct is a pass through to the repository.
+func (s *service) DeactivateProduct(ctx context.Context, productID string) error {
+	return s.repo.DeactivateProduct(ctx, productID)
+}
+
 // VerifyAppleIAP orchestrates the Apple purchase verification.
 func (s *service) VerifyAppleIAP(ctx context.Context, userID uuid.UUID, receipt string) (*domain.User, error) {
 	// Call external Apple API to verify receipt
-	productID, err := s.appleClient.VerifyReceipt(ctx, receipt)
+	purchase, err := s.appleClient.VerifyReceipt(ctx, receipt)
 	if err != nil {
 		return nil, fmt.Errorf("apple receipt verification failed: %w", err)
 	}
 
 	// eceipt is valid, complete the purchase flow
-	return s.completePurchase(ctx, userID, productID, "apple", receipt)
+	return s.completePurchase(ctx, userID, "apple", purchase)
 }
 
 // VerifyGoogleIAP orchestrates the Google purchase verification.
 func (s *service) VerifyGoogleIAP(ctx context.Context, userID uuid.UUID, receipt string) (*domain.User, error) {
 	// Call external Google api to verify receipt
-	productID, err := s.googleClient.VerifyReceipt(ctx, receipt)
+	purchase, err := s.googleClient.VerifyReceipt(ctx, receipt)
 	if err != nil {
 		return nil, fmt.Errorf("google receipt verification failed: %w", err)
 	}
 
 	// Receipt is valid so we complete the purchase flow
-	return s.completePurchase(ctx, userID, productID, "google", receipt)
+	return s.completePurchase(ctx, userID, "google", purchase)
 }
 
-// completePurchase is a private helper to handle the common logic after a receipt has been successfully verified by its provider.
-func (s *service) completePurchase(ctx context.Context, userID uuid.UUID, productID, provider, txID string) (*domain.User, error) {
-	// Get product details from our DB
-	product, err := s.repo.GetProductByID(ctx, productID)
-	if err != nil {
-		return nil, fmt.Errorf("purchase failed: could not find product %s: %w", productID, err)
-	}
+// purchaseReference is the billing reference for a verified purchase, from the store's transaction id. Google's
+// purchase tokens run to a few hundred characters, so it's a hash of the id rather than the id itself.
+func purchaseReference(provider, txID string) string {
+	sum := sha256.Sum256([]byte(txID))
+	return provider + ":" + hex.EncodeToString(sum[:])
+}
 
-	// Call BillingService to credit tokens
-	_, err = s.billingClient.CreditToken(ctx, userID, product.TokenCredit)
+// completePurchase is a private helper to handle the common logic after a receipt has been successfully verified by its provider.
+// The purchase is claimed for the user before it's credited. The transaction id is unique across all users, so
+// the same purchase sent again, however it's encoded, credits nobody new: a retry by the same user gets the same
+// credit back, and anyone else gets ErrPurchaseClaimed.
+func (s *service) completePurchase(ctx context.Context, userID uuid.UUID, provider string, purchase *StorePurchase) (*domain.User, error) {
+	// Get product details from our DB. Only the verifying store's IDs count, so a Google ID can't buy through Apple.
+	product, err := s.repo.GetProductByProviderID(ctx, provider, purchase.ProductID)
 	if err != nil {
-		return nil, fmt.Errorf("purchase failed: could not credit tokens: %w", err)
+		return nil, fmt.Errorf("purchase failed: could not find product %s: %w", purchase.ProductID, err)
 	}
 
-	// klog the transaction in our payment_transactions table
+	// klog the transaction in our payment_transactions table. The store has charged, so it's succeeded whether or
+	// not the credit below goes through, and a retry finds it here.
 	tx := &domain.PaymentTransaction{
 		TransactionID:         uuid.New(),
 		UserID:                userID,
 		ProductID:             product.ProductID,
 		AmountCents:           product.PriceCents,
 		Provider:              provider,
-		ProviderTransactionID: txID,
+		ProviderTransactionID: purchase.TransactionID,
 		Status:                "succeeded",
 		CreatedAt:             time.Now().UTC(),
 	}
-	if err := s.repo.CreateTransaction(ctx, tx); err != nil {
-		// non-fatal error logged for reference
-		fmt.Printf("WARNING: Failed to log transaction %s for user %s\n", tx.TransactionID, userID)
+	created, err := s.repo.GetOrCreateTransaction(ctx, tx)
+	if err != nil {
+		return nil, fmt.Errorf("purchase failed: could not log transaction: %w", err)
+	}
+	if !created && tx.UserID != userID {
+		fmt.Printf("WARNING: User %s sent a %s purchase already claimed by user %s\n", userID, provider, tx.UserID)
+		return nil, ErrPurchaseClaimed
 	}
 
-	// Get the updated user profile to return to the app
+	// Call BillingService to credit tokens. The reference makes this safe to retry.
+	_, err = s.billingClient.CreditToken(ctx, userID, product.TokenCredit, purchaseReference(provider, purchase.TransactionID))
+	if err != nil {
+		if errors.Is(err, ErrBalanceCapExceeded) {
+			// The store has taken the money and we can't give the tokens. Either the cap is too low or something
+			// upstream is crediting in a loop, and both need a person.
+			fmt.Printf("ALERT: Purchase of %s by user %s (%s) was paid but not credited, the balance cap was hit\n", product.ProductID, userID, provider)
+		}
+		return nil, fmt.Errorf("purchase failed: could not credit tokens: %w", err)
+	}
+
+	// The store charged before the app sent us the receipt, so refusing the credit now would only keep tokens the
+	// user paid for. The spending cap is enforced before a Stripe intent instead, and a purchase here that goes
+	// over it is credited and alerted on for a person to look at. A retry was checked the first time.
+	if created && s.cfg.SpendingCapCents > 0 {
+		// The sum includes the row just logged.
+		spent, err := s.recentSpend(ctx, userID)
+		if err != nil {
+			fmt.Printf("WARNING: Could not check the spending cap after a purchase by user %s: %v\n", userID, err)
+		} else if spent > s.cfg.SpendingCapCents {
+			fmt.Printf("ALERT: Purchase of %s by user %s (%s) took them over the spending cap, it was credited since the store already charged\n", product.ProductID, userID, provider)
+		}
+	}
+
+	// Get the updated user profile to return to the app. If the client caches profiles, the cached one
+	// has the old balance, so drop it first.
+	if cache, ok := s.userClient.(usercache.Invalidator); ok {
+		cache.Invalidate(userID)
+	}
 	updatedUser, err := s.userClient.GetUserProfile(ctx, userID)
 	if err != nil {
 		// The purchase succeeded but we cant get the new profile
@@ -118,9 +221,111 @@ func (s *service) completePurchase(ctx context.Context, userID uuid.UUID, produc
 	return updatedUser, nil
 }
 
-// CreateStripeIntent uses the Stripe client.
-func (s *service) CreateStripeIntent(ctx context.Context, userID uuid.UUID, productID string) (string, error) {
-	return s.stripeClient.CreateIntent(ctx, userID, productID)
+// CreateStripeIntent charges the product's catalog price to the user's Stripe customer.
+// It returns ErrNotFound if productID isn't one of ours. A non-empty idempotencyKey the user already created an
+// intent with returns that intent's client secret, or ErrIdempotencyKeyReused if it was for another product.
+func (s *service) CreateStripeIntent(ctx context.Context, userID uuid.UUID, productID, idempotencyKey string) (string, error) {
+	if idempotencyKey != "" {
+		if seen, ok := s.intentKeys.get(userID, idempotencyKey); ok {
+			if seen.productID != productID {
+				return "", ErrIdempotencyKeyReused
+			}
+			return seen.clientSecret, nil
+		}
+	}
+
+	// The price comes from our catalog, and the cap is checked before we ever ask Stripe for an intent.
+	product, err := s.repo.GetProductByID(ctx, productID)
+	if err != nil {
+		return "", fmt.Errorf("could not find product %s: %w", productID, err)
+	}
+	if err := s.checkSpendingCap(ctx, userID, product.PriceCents); err != nil {
+		return "", err
+	}
+
+	customerID, err := s.stripeCustomer(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	intent := &StripeIntent{
+		CustomerID:  customerID,
+		AmountCents: product.PriceCents,
+		Currency:    s.cfg.Currency,
+		Metadata: map[string]string{
+			stripeMetadataUserID: userID.String(),
+			// The app may have sent a store's ID for the product, so use ours.
+			stripeMetadataProductID: product.ProductID,
+		},
+	}
+	if idempotencyKey != "" {
+		intent.IdempotencyKey = stripeIdempotencyKey(userID, idempotencyKey)
+	}
+	clientSecret, err := s.stripeClient.CreateIntent(ctx, intent)
+	if err != nil {
+		return "", err
+	}
+	if idempotencyKey != "" {
+		// Under the id the app sent, so a retry with the store's id for the same product still matches.
+		s.intentKeys.put(userID, idempotencyKey, productID, clientSecret)
+	}
+	return clientSecret, nil
+}
+
+// stripeCustomer returns the user's Stripe customer, creating one and saving it to the UserService the first time
+// they pay by card. Every intent then goes to the same customer, so Stripe keeps one history per user.
+func (s *service) stripeCustomer(ctx context.Context, userID uuid.UUID) (string, error) {
+	user, err := s.userClient.GetUserProfile(ctx, userID)
+	if err != nil {
+		return "", fmt.Errorf("could not get user %s: %w", userID, err)
+	}
+	if user.StripeCustomerID != "" {
+		return user.StripeCustomerID, nil
+	}
+
+	customerID, err := s.stripeClient.EnsureCustomer(ctx, user)
+	if err != nil {
+		return "", fmt.Errorf("could not create stripe customer for user %s: %w", userID, err)
+	}
+	// If another intent got there first the UserService keeps its customer, and that's the one to use.
+	stored, err := s.customers.SetStripeCustomerID(ctx, userID, customerID)
+	if err != nil {
+		return "", fmt.Errorf("could not save stripe customer for user %s: %w", userID, err)
+	}
+	// A cached profile would still say there's no customer.
+	if cache, ok := s.userClient.(usercache.Invalidator); ok {
+		cache.Invalidate(userID)
+	}
+	return stored, nil
+}
+
+// checkSpendingCap sums the user's recent spend from the transaction history and returns ErrSpendingCapExceeded if adding priceCents would go over the cap.
+func (s *service) checkSpendingCap(ctx context.Context, userID uuid.UUID, priceCents int) error {
+	if s.cfg.SpendingCapCents <= 0 {
+		return nil // Cap disabled.
+	}
+
+	spent, err := s.recentSpend(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("could not check spending cap: %w", err)
+	}
+	if spent+priceCents > s.cfg.SpendingCapCents {
+		return ErrSpendingCapExceeded
+	}
+	return nil
+}
+
+// recentSpend sums the user's succeeded transactions within the spending window.
+func (s *service) recentSpend(ctx context.Context, userID uuid.UUID) (int, error) {
+	since := time.Now().UTC().Add(-s.cfg.SpendingWindow)
+	recent, err := s.repo.GetRecentTransactions(ctx, userID, since)
+	if err != nil {
+		return 0, err
+	}
+	spent := 0
+	for _, tx := range recent {
+		spent += tx.AmountCents
+	}
+	return spent, nil
 }
 
 // HandleStripeEvent is called by the webhook handler.
@@ -0,0 +1,174 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: service.go
+//
+// Generated by this command:
+//
+//	mockgen -destination=./service_mock_test.go -package=payment -source=service.go Service
+//
+
+// Package payment is a generated GoMock package.
+package payment
+
+import (
+	context "context"
+	domain "project-sage/internal/domain"
+	reflect "reflect"
+
+	uuid "github.com/google/uuid"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockService is a mock of Service interface.
+type MockService struct {
+	ctrl     *gomock.Controller
+	recorder *MockServiceMockRecorder
+	isgomock struct{}
+}
+
+// MockServiceMockRecorder is the mock recorder for MockService.
+type MockServiceMockRecorder struct {
+	mock *MockService
+}
+
+// NewMockService creates a new mock instance.
+func NewMockService(ctrl *gomock.Controller) *MockService {
+	mock := &MockService{ctrl: ctrl}
+	mock.recorder = &MockServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockService) EXPECT() *MockServiceMockRecorder {
+	return m.recorder
+}
+
+// CreateProduct mocks base method.
+func (m *MockService) CreateProduct(ctx context.Context, p *domain.Product) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateProduct", ctx, p)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateProduct indicates an expected call of CreateProduct.
+func (mr *MockServiceMockRecorder) CreateProduct(ctx, p any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateProduct", reflect.TypeOf((*MockService)(nil).CreateProduct), ctx, p)
+}
+
+// CreateStripeIntent mocks base method.
+func (m *MockService) CreateStripeIntent(ctx context.Context, userID uuid.UUID, productID, idempotencyKey string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateStripeIntent", ctx, userID, productID, idempotencyKey)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateStripeIntent indicates an expected call of CreateStripeIntent.
+func (mr *MockServiceMockRecorder) CreateStripeIntent(ctx, userID, productID, idempotencyKey any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateStripeIntent", reflect.TypeOf((*MockService)(nil).CreateStripeIntent), ctx, userID, productID, idempotencyKey)
+}
+
+// DeactivateProduct mocks base method.
+func (m *MockService) DeactivateProduct(ctx context.Context, productID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeactivateProduct", ctx, productID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeactivateProduct indicates an expected call of DeactivateProduct.
+func (mr *MockServiceMockRecorder) DeactivateProduct(ctx, productID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeactivateProduct", reflect.TypeOf((*MockService)(nil).DeactivateProduct), ctx, productID)
+}
+
+// GetAllProducts mocks base method.
+func (m *MockService) GetAllProducts(ctx context.Context) ([]*domain.Product, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAllProducts", ctx)
+	ret0, _ := ret[0].([]*domain.Product)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAllProducts indicates an expected call of GetAllProducts.
+func (mr *MockServiceMockRecorder) GetAllProducts(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllProducts", reflect.TypeOf((*MockService)(nil).GetAllProducts), ctx)
+}
+
+// GetAvailableProducts mocks base method.
+func (m *MockService) GetAvailableProducts(ctx context.Context) ([]*domain.Product, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAvailableProducts", ctx)
+	ret0, _ := ret[0].([]*domain.Product)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAvailableProducts indicates an expected call of GetAvailableProducts.
+func (mr *MockServiceMockRecorder) GetAvailableProducts(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAvailableProducts", reflect.TypeOf((*MockService)(nil).GetAvailableProducts), ctx)
+}
+
+// HandleStripeEvent mocks base method.
+func (m *MockService) HandleStripeEvent(ctx context.Context, payload []byte) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HandleStripeEvent", ctx, payload)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// HandleStripeEvent indicates an expected call of HandleStripeEvent.
+func (mr *MockServiceMockRecorder) HandleStripeEvent(ctx, payload any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HandleStripeEvent", reflect.TypeOf((*MockService)(nil).HandleStripeEvent), ctx, payload)
+}
+
+// UpdateProduct mocks base method.
+func (m *MockService) UpdateProduct(ctx context.Context, p *domain.Product) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateProduct", ctx, p)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateProduct indicates an expected call of UpdateProduct.
+func (mr *MockServiceMockRecorder) UpdateProduct(ctx, p any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateProduct", reflect.TypeOf((*MockService)(nil).UpdateProduct), ctx, p)
+}
+
+// VerifyAppleIAP mocks base method.
+func (m *MockService) VerifyAppleIAP(ctx context.Context, userID uuid.UUID, receipt string) (*domain.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "VerifyAppleIAP", ctx, userID, receipt)
+	ret0, _ := ret[0].(*domain.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// VerifyAppleIAP indicates an expected call of VerifyAppleIAP.
+func (mr *MockServiceMockRecorder) VerifyAppleIAP(ctx, userID, receipt any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VerifyAppleIAP", reflect.TypeOf((*MockService)(nil).VerifyAppleIAP), ctx, userID, receipt)
+}
+
+// VerifyGoogleIAP mocks base method.
+func (m *MockService) VerifyGoogleIAP(ctx context.Context, userID uuid.UUID, receipt string) (*domain.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "VerifyGoogleIAP", ctx, userID, receipt)
+	ret0, _ := ret[0].(*domain.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// VerifyGoogleIAP indicates an expected call of VerifyGoogleIAP.
+func (mr *MockServiceMockRecorder) VerifyGoogleIAP(ctx, userID, receipt any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "VerifyGoogleIAP", reflect.TypeOf((*MockService)(nil).VerifyGoogleIAP), ctx, userID, receipt)
+}
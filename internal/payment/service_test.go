@@ -0,0 +1,493 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"project-sage/internal/domain"
+	"project-sage/internal/usercache"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/mock/gomock"
+)
+
+// This is the unit test for the payment service. All clients and the repository are mocked.
+
+// testMocks bundles every mock the payment service needs.
+type testMocks struct {
+	repo      *MockRepository
+	billing   *MockBillingClient
+	user      *MockUserClient
+	apple     *MockAppleClient
+	google    *MockGoogleClient
+	stripe    *MockStripeClient
+	customers *MockStripeCustomerStore
+}
+
+// setupMocks is a helper to create all the mocks and a service using them.
+func setupMocks(t *testing.T, cfg ServiceConfig) (context.Context, *testMocks, Service, *gomock.Controller) {
+	ctrl := gomock.NewController(t)
+	m := &testMocks{
+		repo:      NewMockRepository(ctrl),
+		billing:   NewMockBillingClient(ctrl),
+		user:      NewMockUserClient(ctrl),
+		apple:     NewMockAppleClient(ctrl),
+		google:    NewMockGoogleClient(ctrl),
+		stripe:    NewMockStripeClient(ctrl),
+		customers: NewMockStripeCustomerStore(ctrl),
+	}
+	s := NewService(m.repo, m.billing, m.user, m.apple, m.google, m.stripe, m.customers, cfg)
+	return context.Background(), m, s, ctrl
+}
+
+// testCapConfig is a $50 per day cap.
+var testCapConfig = ServiceConfig{SpendingCapCents: 5000, SpendingWindow: 24 * time.Hour}
+
+// TestService_VerifyAppleIAP_UnderCap tests that a purchase under the cap goes through.
+func TestService_VerifyAppleIAP_UnderCap(t *testing.T) {
+	ctx, m, s, ctrl := setupMocks(t, testCapConfig)
+	defer ctrl.Finish()
+
+	userID := uuid.New()
+	product := &domain.Product{ProductID: "pack_5_tokens", PriceCents: 499, TokenCredit: 5}
+	// $30 spent before, and this purchase.
+	recent := []*domain.PaymentTransaction{{AmountCents: 1000}, {AmountCents: 2000}, {AmountCents: 499}}
+
+	gomock.InOrder(
+		m.apple.EXPECT().VerifyReceipt(ctx, "receipt").Return(&StorePurchase{ProductID: "pack_5_tokens", TransactionID: "1000001"}, nil).Times(1),
+		m.repo.EXPECT().GetProductByProviderID(ctx, "apple", "pack_5_tokens").Return(product, nil).Times(1),
+		m.repo.EXPECT().GetOrCreateTransaction(ctx, gomock.Any()).Return(true, nil).Times(1),
+		m.billing.EXPECT().CreditToken(ctx, userID, 5, purchaseReference("apple", "1000001")).Return(8, nil).Times(1),
+		m.repo.EXPECT().GetRecentTransactions(ctx, userID, gomock.Any()).Return(recent, nil).Times(1),
+		m.user.EXPECT().GetUserProfile(ctx, userID).Return(&domain.User{UserID: userID, AssistanceTokenBalance: 8}, nil).Times(1),
+	)
+
+	user, err := s.VerifyAppleIAP(ctx, userID, "receipt")
+	if err != nil {
+		t.Fatalf("VerifyAppleIAP() returned unexpected error: %v", err)
+	}
+	if user.AssistanceTokenBalance != 8 {
+		t.Errorf("Expected balance 8, got %d", user.AssistanceTokenBalance)
+	}
+}
+
+// TestService_VerifyAppleIAP_CachedProfile tests a cached profile is dropped after the credit,
+// so the app gets the new balance and not the one from before the purchase.
+func TestService_VerifyAppleIAP_CachedProfile(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	ctx := context.Background()
+
+	m := &testMocks{
+		repo:    NewMockRepository(ctrl),
+		billing: NewMockBillingClient(ctrl),
+		user:    NewMockUserClient(ctrl),
+		apple:   NewMockAppleClient(ctrl),
+	}
+	cache := usercache.New(m.user, time.Minute)
+	s := NewService(m.repo, m.billing, cache, m.apple, nil, nil, nil, ServiceConfig{})
+
+	userID := uuid.New()
+	product := &domain.Product{ProductID: "pack_5_tokens", TokenCredit: 5}
+	gomock.InOrder(
+		m.user.EXPECT().GetUserProfile(gomock.Any(), userID).Return(&domain.User{UserID: userID, AssistanceTokenBalance: 3}, nil),
+		m.user.EXPECT().GetUserProfile(gomock.Any(), userID).Return(&domain.User{UserID: userID, AssistanceTokenBalance: 8}, nil),
+	)
+	m.apple.EXPECT().VerifyReceipt(ctx, "receipt").Return(&StorePurchase{ProductID: "pack_5_tokens", TransactionID: "1000001"}, nil)
+	m.repo.EXPECT().GetProductByProviderID(ctx, "apple", "pack_5_tokens").Return(product, nil)
+	m.repo.EXPECT().GetOrCreateTransaction(ctx, gomock.Any()).Return(true, nil)
+	m.billing.EXPECT().CreditToken(ctx, userID, 5, gomock.Any()).Return(8, nil)
+
+	// Someone looked the user up before the purchase.
+	if _, err := cache.GetUserProfile(ctx, userID); err != nil {
+		t.Fatalf("GetUserProfile() returned error: %v", err)
+	}
+
+	user, err := s.VerifyAppleIAP(ctx, userID, "receipt")
+	if err != nil {
+		t.Fatalf("VerifyAppleIAP() returned unexpected error: %v", err)
+	}
+	if user.AssistanceTokenBalance != 8 {
+		t.Errorf("Expected the new balance 8, got %d", user.AssistanceTokenBalance)
+	}
+}
+
+// TestService_VerifyAppleIAP_BalanceCap checks a credit the BillingService turns away for the balance cap comes
+// back as ErrBalanceCapExceeded. The purchase stays logged, since the store has charged for it.
+func TestService_VerifyAppleIAP_BalanceCap(t *testing.T) {
+	ctx, m, s, ctrl := setupMocks(t, ServiceConfig{})
+	defer ctrl.Finish()
+
+	userID := uuid.New()
+	product := &domain.Product{ProductID: "pack_5_tokens", PriceCents: 499, TokenCredit: 5}
+	m.apple.EXPECT().VerifyReceipt(ctx, "receipt").Return(&StorePurchase{ProductID: "pack_5_tokens", TransactionID: "1000001"}, nil)
+	m.repo.EXPECT().GetProductByProviderID(ctx, "apple", "pack_5_tokens").Return(product, nil)
+	m.repo.EXPECT().GetOrCreateTransaction(ctx, gomock.Any()).Return(true, nil)
+	m.billing.EXPECT().CreditToken(ctx, userID, 5, gomock.Any()).Return(0, ErrBalanceCapExceeded)
+
+	_, err := s.VerifyAppleIAP(ctx, userID, "receipt")
+	if !errors.Is(err, ErrBalanceCapExceeded) {
+		t.Fatalf("Expected ErrBalanceCapExceeded, got: %v", err)
+	}
+}
+
+// TestService_VerifyAppleIAP_OverCap tests that a purchase over the cap is still credited and logged, since the
+// store has already charged for it by the time we see the receipt.
+func TestService_VerifyAppleIAP_OverCap(t *testing.T) {
+	ctx, m, s, ctrl := setupMocks(t, testCapConfig)
+	defer ctrl.Finish()
+
+	userID := uuid.New()
+	product := &domain.Product{ProductID: "pack_5_tokens", PriceCents: 499, TokenCredit: 5}
+	// $48 spent before, so this $4.99 took them over $50.
+	recent := []*domain.PaymentTransaction{{AmountCents: 4800}, {AmountCents: 499}}
+
+	gomock.InOrder(
+		m.apple.EXPECT().VerifyReceipt(ctx, "receipt").Return(&StorePurchase{ProductID: "pack_5_tokens", TransactionID: "1000001"}, nil).Times(1),
+		m.repo.EXPECT().GetProductByProviderID(ctx, "apple", "pack_5_tokens").Return(product, nil).Times(1),
+		m.repo.EXPECT().GetOrCreateTransaction(ctx, gomock.Any()).Return(true, nil).Times(1),
+		m.billing.EXPECT().CreditToken(ctx, userID, 5, purchaseReference("apple", "1000001")).Return(8, nil).Times(1),
+		m.repo.EXPECT().GetRecentTransactions(ctx, userID, gomock.Any()).Return(recent, nil).Times(1),
+		m.user.EXPECT().GetUserProfile(ctx, userID).Return(&domain.User{UserID: userID, AssistanceTokenBalance: 8}, nil).Times(1),
+	)
+
+	if _, err := s.VerifyAppleIAP(ctx, userID, "receipt"); err != nil {
+		t.Fatalf("Expected the paid purchase to be credited, got: %v", err)
+	}
+}
+
+// TestService_VerifyAppleIAP_Retry tests a retried receipt whose transaction is already logged is credited again
+// under the same reference, for the BillingService to answer from the first credit, and isn't checked against the
+// cap a second time.
+func TestService_VerifyAppleIAP_Retry(t *testing.T) {
+	ctx, m, s, ctrl := setupMocks(t, testCapConfig)
+	defer ctrl.Finish()
+
+	userID := uuid.New()
+	product := &domain.Product{ProductID: "pack_48", PriceCents: 4800, TokenCredit: 50}
+	// The first try was credited and logged, then the app lost the response.
+	recent := []*domain.PaymentTransaction{{UserID: userID, AmountCents: 4800, Provider: "apple", ProviderTransactionID: "1000001"}}
+
+	gomock.InOrder(
+		m.apple.EXPECT().VerifyReceipt(ctx, "receipt").Return(&StorePurchase{ProductID: "pack_48", TransactionID: "1000001"}, nil).Times(1),
+		m.repo.EXPECT().GetProductByProviderID(ctx, "apple", "pack_48").Return(product, nil).Times(1),
+		m.repo.EXPECT().GetOrCreateTransaction(ctx, gomock.Any()).DoAndReturn(
+			func(_ context.Context, tx *domain.PaymentTransaction) (bool, error) {
+				*tx = *recent[0]
+				return false, nil
+			}).Times(1),
+		m.billing.EXPECT().CreditToken(ctx, userID, 50, purchaseReference("apple", "1000001")).Return(50, nil).Times(1),
+		m.user.EXPECT().GetUserProfile(ctx, userID).Return(&domain.User{UserID: userID, AssistanceTokenBalance: 50}, nil).Times(1),
+	)
+
+	if _, err := s.VerifyAppleIAP(ctx, userID, "receipt"); err != nil {
+		t.Fatalf("VerifyAppleIAP() returned unexpected error: %v", err)
+	}
+
+	// The row does count for a different purchase, so a Stripe intent for the same pack is over the cap.
+	m.repo.EXPECT().GetProductByID(ctx, "pack_48").Return(product, nil)
+	m.repo.EXPECT().GetRecentTransactions(ctx, userID, gomock.Any()).Return(recent, nil)
+	if _, err := s.CreateStripeIntent(ctx, userID, "pack_48", ""); !errors.Is(err, ErrSpendingCapExceeded) {
+		t.Errorf("Expected ErrSpendingCapExceeded, got: %v", err)
+	}
+}
+
+// expectClaims has the repository remember logged purchases the way the unique index on the store's transaction id
+// does, and returns what it logged.
+func expectClaims(repo *MockRepository) map[string]domain.PaymentTransaction {
+	claims := map[string]domain.PaymentTransaction{}
+	repo.EXPECT().GetOrCreateTransaction(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(_ context.Context, tx *domain.PaymentTransaction) (bool, error) {
+			key := tx.Provider + "/" + tx.ProviderTransactionID
+			if existing, ok := claims[key]; ok {
+				*tx = existing
+				return false, nil
+			}
+			claims[key] = *tx
+			return true, nil
+		}).AnyTimes()
+	return claims
+}
+
+// TestService_VerifyGoogleIAP_Replay sends one Play purchase three times: once, again with its JSON laid out
+// differently, and then from another account. The real Google client reads the purchase token out of each, and
+// the repository remembers claims the way the unique index does. The owner's replay gets the first credit's
+// reference, so the BillingService answers it from that credit, and the other account gets nothing.
+func TestService_VerifyGoogleIAP_Replay(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	ctx := context.Background()
+
+	repo := NewMockRepository(ctrl)
+	billing := NewMockBillingClient(ctrl)
+	users := NewMockUserClient(ctrl)
+	ft := &fakeGoogleTransport{getStatus: http.StatusOK, purchase: `{"purchaseState": 0, "acknowledgementState": 1}`}
+	s := NewService(repo, billing, users, nil, newTestGoogleClient(ft), nil, nil, ServiceConfig{})
+
+	owner, other := uuid.New(), uuid.New()
+	product := &domain.Product{ProductID: "pack_5_tokens", PriceCents: 499, TokenCredit: 5}
+	repo.EXPECT().GetProductByProviderID(ctx, "google", "pack_5_tokens").Return(product, nil).AnyTimes()
+	claims := expectClaims(repo)
+	billing.EXPECT().CreditToken(ctx, owner, 5, purchaseReference("google", "tok-123")).Return(8, nil).Times(2)
+	users.EXPECT().GetUserProfile(ctx, owner).Return(&domain.User{UserID: owner, AssistanceTokenBalance: 8}, nil).Times(2)
+
+	if _, err := s.VerifyGoogleIAP(ctx, owner, playReceipt); err != nil {
+		t.Fatalf("VerifyGoogleIAP() returned unexpected error: %v", err)
+	}
+	reordered := `{ "purchaseToken":"tok-123",  "productId":"pack_5_tokens", "packageName":"com.sage.app" }`
+	if _, err := s.VerifyGoogleIAP(ctx, owner, reordered); err != nil {
+		t.Fatalf("VerifyGoogleIAP() of the reformatted receipt returned unexpected error: %v", err)
+	}
+	if _, err := s.VerifyGoogleIAP(ctx, other, reordered); !errors.Is(err, ErrPurchaseClaimed) {
+		t.Errorf("Expected ErrPurchaseClaimed for another account, got: %v", err)
+	}
+	if len(claims) != 1 {
+		t.Errorf("Expected one logged purchase, got %d", len(claims))
+	}
+}
+
+// TestService_VerifyAppleIAP_RefreshedReceipt checks a receipt Apple refreshed after the purchase, and so doesn't
+// match the first one, is still the same purchase by its transaction id. Only its first sender gets the tokens.
+func TestService_VerifyAppleIAP_RefreshedReceipt(t *testing.T) {
+	ctx, m, s, ctrl := setupMocks(t, ServiceConfig{})
+	defer ctrl.Finish()
+
+	owner, other := uuid.New(), uuid.New()
+	purchase := &StorePurchase{ProductID: "pack_5_tokens", TransactionID: "1000001"}
+	m.apple.EXPECT().VerifyReceipt(ctx, gomock.Any()).Return(purchase, nil).Times(3)
+	m.repo.EXPECT().GetProductByProviderID(ctx, "apple", "pack_5_tokens").Return(&domain.Product{ProductID: "pack_5_tokens", TokenCredit: 5}, nil).Times(3)
+	claims := expectClaims(m.repo)
+	m.billing.EXPECT().CreditToken(ctx, owner, 5, purchaseReference("apple", "1000001")).Return(8, nil).Times(2)
+	m.user.EXPECT().GetUserProfile(ctx, owner).Return(&domain.User{UserID: owner, AssistanceTokenBalance: 8}, nil).Times(2)
+
+	for _, receipt := range []string{"receipt-at-purchase", "receipt-refreshed"} {
+		if _, err := s.VerifyAppleIAP(ctx, owner, receipt); err != nil {
+			t.Fatalf("VerifyAppleIAP(%s) returned unexpected error: %v", receipt, err)
+		}
+	}
+	if _, err := s.VerifyAppleIAP(ctx, other, "receipt-refreshed"); !errors.Is(err, ErrPurchaseClaimed) {
+		t.Errorf("Expected ErrPurchaseClaimed for another account, got: %v", err)
+	}
+	if len(claims) != 1 {
+		t.Errorf("Expected one logged purchase, got %d", len(claims))
+	}
+}
+
+// TestService_CreateStripeIntent_OverCap tests that no Stripe intent is created once the cap is reached.
+func TestService_CreateStripeIntent_OverCap(t *testing.T) {
+	ctx, m, s, ctrl := setupMocks(t, testCapConfig)
+	defer ctrl.Finish()
+
+	userID := uuid.New()
+	product := &domain.Product{ProductID: "pack_20_tokens", PriceCents: 1999}
+	recent := []*domain.PaymentTransaction{{AmountCents: 4000}}
+
+	m.repo.EXPECT().GetProductByID(ctx, "pack_20_tokens").Return(product, nil).Times(1)
+	m.repo.EXPECT().GetRecentTransactions(ctx, userID, gomock.Any()).Return(recent, nil).Times(1)
+	m.stripe.EXPECT().CreateIntent(gomock.Any(), gomock.Any()).Times(0)
+
+	_, err := s.CreateStripeIntent(ctx, userID, "pack_20_tokens", "")
+	if !errors.Is(err, ErrSpendingCapExceeded) {
+		t.Fatalf("Expected ErrSpendingCapExceeded, got: %v", err)
+	}
+}
+
+// intentForCustomer matches a StripeIntent charged to customerID.
+func intentForCustomer(customerID string) gomock.Matcher {
+	return gomock.Cond(func(intent *StripeIntent) bool { return intent.CustomerID == customerID })
+}
+
+// TestService_CreateStripeIntent_PricedFromCatalog tests the product is looked up before the intent is created,
+// and the intent is for the catalog price in the configured currency with our product id in its metadata.
+func TestService_CreateStripeIntent_PricedFromCatalog(t *testing.T) {
+	ctx, m, s, ctrl := setupMocks(t, ServiceConfig{Currency: "eur"})
+	defer ctrl.Finish()
+
+	userID := uuid.New()
+	product := &domain.Product{ProductID: "pack_5_tokens", PriceCents: 499}
+	want := &StripeIntent{
+		CustomerID:  "cus_existing",
+		AmountCents: 499,
+		Currency:    "eur",
+		Metadata:    map[string]string{"user_id": userID.String(), "product_id": "pack_5_tokens"},
+	}
+	gomock.InOrder(
+		// The app sent the Apple id, the metadata still gets ours.
+		m.repo.EXPECT().GetProductByID(ctx, "com.sage.pack5").Return(product, nil),
+		m.user.EXPECT().GetUserProfile(ctx, userID).Return(&domain.User{UserID: userID, StripeCustomerID: "cus_existing"}, nil),
+		m.stripe.EXPECT().CreateIntent(ctx, want).Return("secret", nil),
+	)
+
+	if _, err := s.CreateStripeIntent(ctx, userID, "com.sage.pack5", ""); err != nil {
+		t.Fatalf("CreateStripeIntent() returned unexpected error: %v", err)
+	}
+}
+
+// TestService_CreateStripeIntent_UnknownProduct tests an unknown product keeps ErrNotFound in the chain
+// and never reaches Stripe.
+func TestService_CreateStripeIntent_UnknownProduct(t *testing.T) {
+	ctx, m, s, ctrl := setupMocks(t, ServiceConfig{Currency: "usd"})
+	defer ctrl.Finish()
+
+	m.repo.EXPECT().GetProductByID(ctx, "no_such_pack").Return(nil, ErrNotFound)
+	m.user.EXPECT().GetUserProfile(gomock.Any(), gomock.Any()).Times(0)
+	m.stripe.EXPECT().CreateIntent(gomock.Any(), gomock.Any()).Times(0)
+
+	_, err := s.CreateStripeIntent(ctx, uuid.New(), "no_such_pack", "")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got: %v", err)
+	}
+}
+
+// TestService_CreateStripeIntent_NewCustomer tests a user's first card purchase creates a Stripe customer,
+// saves it to the UserService and charges the intent to it.
+func TestService_CreateStripeIntent_NewCustomer(t *testing.T) {
+	ctx, m, s, ctrl := setupMocks(t, ServiceConfig{})
+	defer ctrl.Finish()
+
+	userID := uuid.New()
+	user := &domain.User{UserID: userID}
+	gomock.InOrder(
+		m.repo.EXPECT().GetProductByID(ctx, "pack_5_tokens").Return(&domain.Product{ProductID: "pack_5_tokens", PriceCents: 499}, nil),
+		m.user.EXPECT().GetUserProfile(ctx, userID).Return(user, nil),
+		m.stripe.EXPECT().EnsureCustomer(ctx, user).Return("cus_new", nil),
+		m.customers.EXPECT().SetStripeCustomerID(ctx, userID, "cus_new").Return("cus_new", nil),
+		m.stripe.EXPECT().CreateIntent(ctx, intentForCustomer("cus_new")).Return("secret", nil),
+	)
+
+	secret, err := s.CreateStripeIntent(ctx, userID, "pack_5_tokens", "")
+	if err != nil {
+		t.Fatalf("CreateStripeIntent() returned unexpected error: %v", err)
+	}
+	if secret != "secret" {
+		t.Errorf("Expected client secret 'secret', got %q", secret)
+	}
+}
+
+// TestService_CreateStripeIntent_IdempotencyKey tests a retry with the same key gets the first secret back without
+// going to Stripe again, the key goes to Stripe scoped to the user, and reusing it for another product is refused.
+func TestService_CreateStripeIntent_IdempotencyKey(t *testing.T) {
+	ctx, m, s, ctrl := setupMocks(t, ServiceConfig{})
+	defer ctrl.Finish()
+
+	userID, otherID := uuid.New(), uuid.New()
+	product := &domain.Product{ProductID: "pack_5_tokens", PriceCents: 499}
+	m.repo.EXPECT().GetProductByID(ctx, "pack_5_tokens").Return(product, nil).Times(2)
+	m.user.EXPECT().GetUserProfile(ctx, gomock.Any()).DoAndReturn(func(_ context.Context, id uuid.UUID) (*domain.User, error) {
+		return &domain.User{UserID: id, StripeCustomerID: "cus_" + id.String()}, nil
+	}).Times(2)
+	gomock.InOrder(
+		m.stripe.EXPECT().CreateIntent(ctx, gomock.Cond(func(intent *StripeIntent) bool {
+			return intent.IdempotencyKey == stripeIdempotencyKey(userID, "key-1")
+		})).Return("secret_1", nil),
+		// Another user's key is their own, even if it's the same string.
+		m.stripe.EXPECT().CreateIntent(ctx, gomock.Cond(func(intent *StripeIntent) bool {
+			return intent.IdempotencyKey == stripeIdempotencyKey(otherID, "key-1")
+		})).Return("secret_2", nil),
+	)
+
+	for i := 0; i < 2; i++ {
+		secret, err := s.CreateStripeIntent(ctx, userID, "pack_5_tokens", "key-1")
+		if err != nil {
+			t.Fatalf("Attempt %d: CreateStripeIntent() returned unexpected error: %v", i+1, err)
+		}
+		if secret != "secret_1" {
+			t.Errorf("Attempt %d: expected client secret 'secret_1', got %q", i+1, secret)
+		}
+	}
+	if secret, err := s.CreateStripeIntent(ctx, otherID, "pack_5_tokens", "key-1"); err != nil || secret != "secret_2" {
+		t.Errorf("Expected the other user's own intent, got %q, %v", secret, err)
+	}
+	if _, err := s.CreateStripeIntent(ctx, userID, "pack_20_tokens", "key-1"); !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Errorf("Expected ErrIdempotencyKeyReused for another product, got: %v", err)
+	}
+}
+
+// TestIntentKeyStore_Expiry tests a key is forgotten once its TTL is up.
+func TestIntentKeyStore_Expiry(t *testing.T) {
+	store := newIntentKeyStore(time.Hour)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	userID := uuid.New()
+
+	store.put(userID, "key-1", "pack_5_tokens", "secret_1")
+	if e, ok := store.get(userID, "key-1"); !ok || e.clientSecret != "secret_1" {
+		t.Fatalf("Expected secret_1, got %+v, %v", e, ok)
+	}
+	now = now.Add(time.Hour)
+	if _, ok := store.get(userID, "key-1"); ok {
+		t.Error("Expected the key to have expired")
+	}
+}
+
+// TestService_CreateStripeIntent_NewCustomerRace tests that when another intent saved a customer first,
+// the intent goes to the saved one rather than the one just created.
+func TestService_CreateStripeIntent_NewCustomerRace(t *testing.T) {
+	ctx, m, s, ctrl := setupMocks(t, ServiceConfig{})
+	defer ctrl.Finish()
+
+	userID := uuid.New()
+	m.repo.EXPECT().GetProductByID(ctx, "pack_5_tokens").Return(&domain.Product{ProductID: "pack_5_tokens"}, nil)
+	m.user.EXPECT().GetUserProfile(ctx, userID).Return(&domain.User{UserID: userID}, nil)
+	m.stripe.EXPECT().EnsureCustomer(ctx, gomock.Any()).Return("cus_late", nil)
+	m.customers.EXPECT().SetStripeCustomerID(ctx, userID, "cus_late").Return("cus_first", nil)
+	m.stripe.EXPECT().CreateIntent(ctx, intentForCustomer("cus_first")).Return("secret", nil)
+
+	if _, err := s.CreateStripeIntent(ctx, userID, "pack_5_tokens", ""); err != nil {
+		t.Fatalf("CreateStripeIntent() returned unexpected error: %v", err)
+	}
+}
+
+// TestService_CreateStripeIntent_ReturningCustomer tests a user who already has a customer keeps it,
+// without asking Stripe for another or writing to the UserService.
+func TestService_CreateStripeIntent_ReturningCustomer(t *testing.T) {
+	ctx, m, s, ctrl := setupMocks(t, ServiceConfig{})
+	defer ctrl.Finish()
+
+	userID := uuid.New()
+	m.repo.EXPECT().GetProductByID(ctx, "pack_5_tokens").Return(&domain.Product{ProductID: "pack_5_tokens"}, nil)
+	m.user.EXPECT().GetUserProfile(ctx, userID).Return(&domain.User{UserID: userID, StripeCustomerID: "cus_existing"}, nil)
+	m.stripe.EXPECT().EnsureCustomer(gomock.Any(), gomock.Any()).Times(0)
+	m.customers.EXPECT().SetStripeCustomerID(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	m.stripe.EXPECT().CreateIntent(ctx, intentForCustomer("cus_existing")).Return("secret", nil)
+
+	if _, err := s.CreateStripeIntent(ctx, userID, "pack_5_tokens", ""); err != nil {
+		t.Fatalf("CreateStripeIntent() returned unexpected error: %v", err)
+	}
+}
+
+// TestService_CreateStripeIntent_SaveFails tests no intent is created if the new customer can't be saved,
+// since the next purchase would make yet another customer.
+func TestService_CreateStripeIntent_SaveFails(t *testing.T) {
+	ctx, m, s, ctrl := setupMocks(t, ServiceConfig{})
+	defer ctrl.Finish()
+
+	userID := uuid.New()
+	saveErr := errors.New("user service down")
+	m.repo.EXPECT().GetProductByID(ctx, "pack_5_tokens").Return(&domain.Product{ProductID: "pack_5_tokens"}, nil)
+	m.user.EXPECT().GetUserProfile(ctx, userID).Return(&domain.User{UserID: userID}, nil)
+	m.stripe.EXPECT().EnsureCustomer(ctx, gomock.Any()).Return("cus_new", nil)
+	m.customers.EXPECT().SetStripeCustomerID(ctx, userID, "cus_new").Return("", saveErr)
+	m.stripe.EXPECT().CreateIntent(gomock.Any(), gomock.Any()).Times(0)
+
+	_, err := s.CreateStripeIntent(ctx, userID, "pack_5_tokens", "")
+	if !errors.Is(err, saveErr) {
+		t.Fatalf("Expected the save error, got: %v", err)
+	}
+}
+
+// TestService_VerifyGoogleIAP_InvalidReceipt tests that a rejected receipt keeps ErrInvalidReceipt in the chain and credits nothing.
+func TestService_VerifyGoogleIAP_InvalidReceipt(t *testing.T) {
+	ctx, m, s, ctrl := setupMocks(t, testCapConfig)
+	defer ctrl.Finish()
+
+	m.google.EXPECT().VerifyReceipt(ctx, "bad-receipt").Return(nil, ErrInvalidReceipt).Times(1)
+	m.billing.EXPECT().CreditToken(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	_, err := s.VerifyGoogleIAP(ctx, uuid.New(), "bad-receipt")
+	if !errors.Is(err, ErrInvalidReceipt) {
+		t.Fatalf("Expected ErrInvalidReceipt, got: %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	"project-sage/internal/auth"
+	"project-sage/internal/httputil"
+)
+
+// KeyFunc picks the bucket key for a request.
+type KeyFunc func(r *http.Request) string
+
+// UserKey keys by the authenticated user ID, which auth.Middleware puts in the context, so it must run first.
+// Without a user we fall back to the client IP so the route is still protected. Behind the gateway that's the
+// gateway's address, so every anonymous caller shares one bucket, but the routes we limit answer them 401 anyway.
+func UserKey(r *http.Request) string {
+	if userID, err := auth.GetUserID(r.Context()); err == nil {
+		return "user:" + userID.String()
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// Middleware returns chi-compatible middleware that rejects requests over the limit with a 429 and a Retry-After header.
+func Middleware(store Store, keyFn KeyFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if Allow(w, r, store, keyFn(r)) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// Allow is the middleware's check for handlers that only know the key once they've read the body.
+// It takes a token from key's bucket and returns true if the request can go on. Otherwise it has already
+// written the 429 with a Retry-After header, and the handler should just return.
+func Allow(w http.ResponseWriter, r *http.Request, store Store, key string) bool {
+	allowed, retryAfter, err := store.Allow(r.Context(), key)
+	if err != nil {
+		// If the store is down we let the request through rather than locking everyone out.
+		log.Printf("WARNING: rate limit store error: %v", err)
+		return true
+	}
+	if !allowed {
+		seconds := int(math.Ceil(retryAfter.Seconds()))
+		if seconds < 1 {
+			seconds = 1
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+		httputil.WriteError(w, http.StatusTooManyRequests, "Too many requests")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// This package provides a token-bucket rate limiter keyed by an arbitrary string (usually a user ID).
+// The bucket storage sits behind the Store interface so it can be moved to Redis later without touching callers.
+
+// Store holds the token buckets.
+type Store interface {
+	// Allow takes one token from key's bucket.
+	// If the bucket is empty it returns false and how long until the next token is available.
+	Allow(ctx context.Context, key string) (bool, time.Duration, error)
+}
+
+// Config controls how fast buckets refill and how big they are.
+type Config struct {
+	Rate  float64 // Tokens added per second.
+	Burst int     // Maximum tokens a bucket can hold.
+}
+
+// bucket is the state for one key.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// memoryStore is the default in-process Store.
+// It's fine for a single instance; multiple instances each get their own buckets.
+type memoryStore struct {
+	mu      sync.Mutex
+	cfg     Config
+	buckets map[string]*bucket
+	now     func() time.Time // Swappable for tests.
+}
+
+// maxIdleBuckets is how many buckets we keep before pruning the full ones.
+const maxIdleBuckets = 10000
+
+// NewMemoryStore is the constructor for the in-memory store.
+func NewMemoryStore(cfg Config) Store {
+	return newMemoryStore(cfg, time.Now)
+}
+
+func newMemoryStore(cfg Config, now func() time.Time) *memoryStore {
+	if cfg.Burst < 1 {
+		cfg.Burst = 1
+	}
+	return &memoryStore{
+		cfg:     cfg,
+		buckets: make(map[string]*bucket),
+		now:     now,
+	}
+}
+
+// Allow implements the Store interface.
+func (m *memoryStore) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	b, ok := m.buckets[key]
+	if !ok {
+		if len(m.buckets) >= maxIdleBuckets {
+			m.prune(now)
+		}
+		// New keys start with a full bucket.
+		b = &bucket{tokens: float64(m.cfg.Burst), last: now}
+		m.buckets[key] = b
+	}
+
+	// Refill based on the time since we last looked at this bucket.
+	elapsed := now.Sub(b.last).Seconds()
+	b.tokens = math.Min(float64(m.cfg.Burst), b.tokens+elapsed*m.cfg.Rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+
+	// Not enough tokens. Work out when the next one arrives.
+	if m.cfg.Rate <= 0 {
+		return false, time.Hour, nil // A zero rate never refills, so just say "a long time".
+	}
+	wait := time.Duration((1 - b.tokens) / m.cfg.Rate * float64(time.Second))
+	return false, wait, nil
+}
+
+// prune drops buckets that have refilled completely, since they're the same as a new bucket.
+// Must be called with the lock held.
+func (m *memoryStore) prune(now time.Time) {
+	for key, b := range m.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*m.cfg.Rate >= float64(m.cfg.Burst) {
+			delete(m.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"project-sage/internal/auth"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// setupLimitedRouter builds a router with one rate limited route that always returns 200.
+func setupLimitedRouter(store Store) *chi.Mux {
+	r := chi.NewRouter()
+	r.With(Middleware(store, UserKey)).Post("/limited", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	return r
+}
+
+// TestMiddleware_RejectsOverBurst fires N+1 requests for one user and expects the last one to get a 429.
+func TestMiddleware_RejectsOverBurst(t *testing.T) {
+	burst := 3
+	store := NewMemoryStore(Config{Rate: 0.1, Burst: burst}) // Slow refill so nothing comes back mid-test.
+	r := setupLimitedRouter(store)
+	userID := uuid.New()
+
+	for i := 0; i < burst; i++ {
+		req := auth.SetUserID(httptest.NewRequest("POST", "/limited", nil), userID)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected status %d, got %d", i+1, http.StatusOK, rr.Code)
+		}
+	}
+
+	// This one is over the burst.
+	req := auth.SetUserID(httptest.NewRequest("POST", "/limited", nil), userID)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status %d, got %d", http.StatusTooManyRequests, rr.Code)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("Expected a Retry-After header on the 429 response")
+	}
+}
+
+// TestMiddleware_KeysPerUser makes sure one user's limit doesn't affect another user.
+func TestMiddleware_KeysPerUser(t *testing.T) {
+	store := NewMemoryStore(Config{Rate: 0.1, Burst: 1})
+	r := setupLimitedRouter(store)
+
+	first := auth.SetUserID(httptest.NewRequest("POST", "/limited", nil), uuid.New())
+	r.ServeHTTP(httptest.NewRecorder(), first)
+
+	// A different user still has a full bucket.
+	second := auth.SetUserID(httptest.NewRequest("POST", "/limited", nil), uuid.New())
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, second)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status %d for a different user, got %d", http.StatusOK, rr.Code)
+	}
+}
+
+// TestMemoryStore_Refills checks that a bucket refills over time.
+func TestMemoryStore_Refills(t *testing.T) {
+	now := time.Now()
+	store := newMemoryStore(Config{Rate: 1, Burst: 1}, func() time.Time { return now })
+	ctx := context.Background()
+
+	if ok, _, _ := store.Allow(ctx, "k"); !ok {
+		t.Fatal("Expected the first call to be allowed")
+	}
+	ok, wait, _ := store.Allow(ctx, "k")
+	if ok {
+		t.Fatal("Expected the second call to be rejected")
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("Expected a wait between 0 and 1s, got %v", wait)
+	}
+
+	// One second later a token is back.
+	now = now.Add(time.Second)
+	if ok, _, _ := store.Allow(ctx, "k"); !ok {
+		t.Error("Expected the call to be allowed after the bucket refilled")
+	}
+}
@@ -0,0 +1,86 @@
+package request
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// IdleResolverConfig tunes the idle resolver. Zero values are filled from DefaultIdleResolverConfig.
+type IdleResolverConfig struct {
+	TTL       time.Duration // How long an active request can go without activity before it's resolved
+	Interval  time.Duration // How often to look for idle requests
+	BatchSize int           // Most requests resolved per pass
+}
+
+// DefaultIdleResolverConfig returns the settings used when none are configured.
+func DefaultIdleResolverConfig() IdleResolverConfig {
+	return IdleResolverConfig{
+		TTL:       24 * time.Hour, // Long enough that a slow back and forth over a day isn't cut off.
+		Interval:  5 * time.Minute,
+		BatchSize: 100,
+	}
+}
+
+// withDefaults fills any zero settings from DefaultIdleResolverConfig.
+func (c IdleResolverConfig) withDefaults() IdleResolverConfig {
+	d := DefaultIdleResolverConfig()
+	if c.TTL <= 0 {
+		c.TTL = d.TTL
+	}
+	if c.Interval <= 0 {
+		c.Interval = d.Interval
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = d.BatchSize
+	}
+	return c
+}
+
+// IdleResolver resolves active requests nobody has touched for TTL, eg when the expert helped and then
+// forgot to press resolve. Activity is accepting, a transfer, a reopen, or any message in the conversation.
+// The update in ResolveIdleRequests is atomic, so it's safe to run on every instance at once.
+type IdleResolver struct {
+	repo Repository
+	cfg  IdleResolverConfig
+	now  func() time.Time
+}
+
+// NewIdleResolver is the constructor.
+func NewIdleResolver(r Repository, cfg IdleResolverConfig) *IdleResolver {
+	return &IdleResolver{
+		repo: r,
+		cfg:  cfg.withDefaults(),
+		now:  time.Now,
+	}
+}
+
+// Run resolves idle requests every Interval until ctx is done.
+func (ir *IdleResolver) Run(ctx context.Context) {
+	ticker := time.NewTicker(ir.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := ir.ResolveOnce(ctx); err != nil && ctx.Err() == nil {
+			fmt.Printf("WARNING: Idle request resolve failed: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ResolveOnce resolves one batch of idle requests and returns how many it resolved.
+// The count is logged, since each one is an expert who didn't finish up.
+func (ir *IdleResolver) ResolveOnce(ctx context.Context) (int, error) {
+	n, err := ir.repo.ResolveIdleRequests(ctx, ir.now().UTC().Add(-ir.cfg.TTL), ir.cfg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		fmt.Printf("Resolved %d requests with no activity for %s\n", n, ir.cfg.TTL)
+	}
+	return n, nil
+}
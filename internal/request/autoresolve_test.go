@@ -0,0 +1,42 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/mock/gomock"
+)
+
+// TestIdleResolver_ResolveOnce checks the cutoff is TTL before now, and a failure is passed up.
+func TestIdleResolver_ResolveOnce(t *testing.T) {
+	_, mockRepo, _, _, _, _, _, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+	ctx := context.Background()
+
+	ir := NewIdleResolver(mockRepo, IdleResolverConfig{TTL: 2 * time.Hour, BatchSize: 10})
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	ir.now = func() time.Time { return now }
+
+	gomock.InOrder(
+		mockRepo.EXPECT().ResolveIdleRequests(gomock.Any(), now.Add(-2*time.Hour), 10).Return(3, nil),
+		mockRepo.EXPECT().ResolveIdleRequests(gomock.Any(), gomock.Any(), gomock.Any()).Return(0, errors.New("db down")),
+	)
+
+	if n, err := ir.ResolveOnce(ctx); err != nil || n != 3 {
+		t.Errorf("Expected 3 resolved, got n=%d err=%v", n, err)
+	}
+	if _, err := ir.ResolveOnce(ctx); err == nil {
+		t.Error("Expected the repository error to be returned")
+	}
+}
+
+// TestIdleResolverConfig_Defaults checks zero settings are filled in.
+func TestIdleResolverConfig_Defaults(t *testing.T) {
+	cfg := IdleResolverConfig{TTL: time.Hour}.withDefaults()
+	d := DefaultIdleResolverConfig()
+	if cfg.TTL != time.Hour || cfg.Interval != d.Interval || cfg.BatchSize != d.BatchSize {
+		t.Errorf("Expected TTL kept and the rest defaulted, got %+v", cfg)
+	}
+}
@@ -1,6 +1,6 @@
 package request
 
-//go:generate mockgen -destination=./clients_mock_test.go -package=request -source=clients.go BillingClient,LLMClient,ChatClient,UserClient
+//go:generate mockgen -destination=./clients_mock_test.go -package=request -source=clients.go BillingClient,LLMClient,ChatClient,UserClient,ExpertClient,NotificationClient
 
 import (
 	"bytes"
@@ -8,7 +8,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"project-sage/internal/auth"
 	"project-sage/internal/domain"
+	"project-sage/internal/httpclient"
 	"time"
 
 	"github.com/google/uuid"
@@ -18,8 +20,15 @@ import (
 
 // BillingClient is the contract for talking to the BillingService.
 type BillingClient interface {
-	// DebitToken returns nil on success or an error.
-	DebitToken(ctx context.Context, userID uuid.UUID) error
+	// HoldToken takes amount tokens off the balance into a hold (all or nothing) and returns the hold's id
+	// and the balance left to spend. referenceID is ours to pick, and ends up on the debit once the hold is committed.
+	HoldToken(ctx context.Context, userID uuid.UUID, amount int, referenceID string) (holdID uuid.UUID, newBalance int, err error)
+	// CommitHold spends a hold, once what it paid for exists.
+	CommitHold(ctx context.Context, userID, holdID uuid.UUID) error
+	// ReleaseHold gives a hold back, eg for a request that was never created.
+	ReleaseHold(ctx context.Context, userID, holdID uuid.UUID) error
+	// GetBalance reads the user's spendable balance without changing it. ErrUserNotFound for unknown users.
+	GetBalance(ctx context.Context, userID uuid.UUID) (int, error)
 }
 
 // LLMClient is what we use to talk to the LLM gateway.
@@ -31,6 +40,7 @@ type LLMClient interface {
 type ChatClient interface {
 	RemoveBot(ctx context.Context, twilioSID string) error
 	AddExpert(ctx context.Context, twilioSID string, expertID uuid.UUID) error
+	RemoveExpert(ctx context.Context, twilioSID string, expertID uuid.UUID) error
 }
 
 // UserClient is the contract for talking to the UserService [NEW v1.1]
@@ -38,64 +48,211 @@ type UserClient interface {
 	GetUserProfile(ctx context.Context, userID uuid.UUID) (*domain.User, error)
 }
 
+// ExpertClient is for fetching expert profiles (eg to check IsActive) from the UserService.
+type ExpertClient interface {
+	GetExpertProfile(ctx context.Context, expertID uuid.UUID) (*domain.Expert, error)
+}
+
+// NotificationClient pushes events to the user's and experts' apps (push notification, email or webhook).
+type NotificationClient interface {
+	// NotifyUser sends a human readable event about one of the user's requests.
+	NotifyUser(ctx context.Context, userID, requestID uuid.UUID, event string) error
+	// NotifyExperts tells the active experts a new request is waiting in the queue, so they don't have to poll.
+	NotifyExperts(ctx context.Context, req *domain.AssistanceRequest) error
+}
+
+// DefaultClientTimeout is the whole-call timeout of the billing, chat, user, expert and notification clients
+// when the constructor is given 0. The LLM client has its own, see LLMClientConfig.
+const DefaultClientTimeout = 5 * time.Second
+
+// newHTTPClient returns a client on the shared transport with the given timeout, or DefaultClientTimeout for 0.
+func newHTTPClient(timeout time.Duration) *http.Client {
+	if timeout <= 0 {
+		timeout = DefaultClientTimeout
+	}
+	return httpclient.New(timeout)
+}
+
 // httpBillingClient is the implementation for the BillingClient.
 type httpBillingClient struct {
-	httpClient *http.Client
-	baseURL    string
+	httpClient    *http.Client
+	baseURL       string
+	internalToken string // Sent as X-Internal-Token. The BillingService turns away anything without it
 }
 
-// NewHTTPBillingClient is the constructor
-func NewHTTPBillingClient(baseURL string) BillingClient {
+// NewHTTPBillingClient is the constructor. internalToken is the shared secret the BillingService expects.
+func NewHTTPBillingClient(baseURL, internalToken string, timeout time.Duration) BillingClient {
 	return &httpBillingClient{
-		httpClient: &http.Client{Timeout: 5 * time.Second},
-		baseURL:    baseURL,
+		httpClient:    newHTTPClient(timeout),
+		baseURL:       baseURL,
+		internalToken: internalToken,
 	}
 }
 
-type debitRequest struct {
-	UserID string `json:"user_id"`
+// DTO for the BillingService's /token/hold endpoint
+type holdRequest struct {
+	UserID      string `json:"user_id"`
+	Amount      int    `json:"amount"`
+	ReferenceID string `json:"reference_id,omitempty"`
 }
 
-func (c *httpBillingClient) DebitToken(ctx context.Context, userID uuid.UUID) error {
-	reqBody, err := json.Marshal(debitRequest{UserID: userID.String()})
+type holdResponse struct {
+	HoldID     string `json:"hold_id"`
+	NewBalance int    `json:"new_balance"`
+}
+
+// HoldToken sets the tokens aside through the BillingService's /token/hold and returns the hold's id and the new balance.
+func (c *httpBillingClient) HoldToken(ctx context.Context, userID uuid.UUID, amount int, referenceID string) (uuid.UUID, int, error) {
+	reqBody, err := json.Marshal(holdRequest{UserID: userID.String(), Amount: amount, ReferenceID: referenceID})
 	if err != nil {
-		return fmt.Errorf("could not marshal debit request: %w", err)
+		return uuid.Nil, 0, fmt.Errorf("could not marshal hold request: %w", err)
 	}
 
-	url := c.baseURL + "/token/debit"
+	url := c.baseURL + "/token/hold"
 	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqBody))
 	if err != nil {
-		return fmt.Errorf("could not create debit http request: %w", err)
+		return uuid.Nil, 0, fmt.Errorf("could not create hold http request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(auth.InternalTokenHeader, c.internalToken)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("debit request failed: %w", err)
+		return uuid.Nil, 0, fmt.Errorf("hold request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		if resp.StatusCode == http.StatusConflict { //
-			return fmt.Errorf("insufficient funds")
+		if resp.StatusCode == http.StatusConflict {
+			return uuid.Nil, 0, ErrInsufficientFunds
 		}
-		return fmt.Errorf("billing service returned non-200 status: %d", resp.StatusCode)
+		return uuid.Nil, 0, fmt.Errorf("billing service (hold) returned non-200 status: %d", resp.StatusCode)
+	}
+
+	var body holdResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return uuid.Nil, 0, fmt.Errorf("could not decode hold response: %w", err)
+	}
+	holdID, err := uuid.Parse(body.HoldID)
+	if err != nil {
+		return uuid.Nil, 0, fmt.Errorf("billing service returned an invalid hold_id %q: %w", body.HoldID, err)
 	}
+	return holdID, body.NewBalance, nil
+}
+
+// DTO for the BillingService's /token/commit and /token/release endpoints
+type resolveHoldRequest struct {
+	UserID string `json:"user_id"`
+	HoldID string `json:"hold_id"`
+}
+
+// CommitHold spends the hold through the BillingService's /token/commit.
+func (c *httpBillingClient) CommitHold(ctx context.Context, userID, holdID uuid.UUID) error {
+	return c.resolveHold(ctx, "/token/commit", userID, holdID)
+}
 
+// ReleaseHold gives the hold back through the BillingService's /token/release.
+func (c *httpBillingClient) ReleaseHold(ctx context.Context, userID, holdID uuid.UUID) error {
+	return c.resolveHold(ctx, "/token/release", userID, holdID)
+}
+
+// resolveHold posts a hold to /token/commit or /token/release. Both are safe to repeat, so there's nothing to read back.
+func (c *httpBillingClient) resolveHold(ctx context.Context, path string, userID, holdID uuid.UUID) error {
+	reqBody, err := json.Marshal(resolveHoldRequest{UserID: userID.String(), HoldID: holdID.String()})
+	if err != nil {
+		return fmt.Errorf("could not marshal %s request: %w", path, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+path, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return fmt.Errorf("could not create %s http request: %w", path, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(auth.InternalTokenHeader, c.internalToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s request failed: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("billing service (%s) returned non-200 status: %d", path, resp.StatusCode)
+	}
 	return nil
 }
 
+// balanceResponse is the part of the BillingService's /token/balance response we need.
+type balanceResponse struct {
+	Balance int `json:"balance"`
+}
+
+// GetBalance reads the balance from the BillingService's /token/balance. It's the source of truth,
+// unlike the balance on the user's profile, which can be stale.
+func (c *httpBillingClient) GetBalance(ctx context.Context, userID uuid.UUID) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/token/balance/"+userID.String(), nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not create balance http request: %w", err)
+	}
+	req.Header.Set(auth.InternalTokenHeader, c.internalToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("balance request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusNotFound {
+			return 0, ErrUserNotFound
+		}
+		return 0, fmt.Errorf("billing service (balance) returned non-200 status: %d", resp.StatusCode)
+	}
+
+	var body balanceResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, fmt.Errorf("could not decode balance response: %w", err)
+	}
+	return body.Balance, nil
+}
+
 type httpLLMClient struct {
 	httpClient *http.Client
 	baseURL    string
+	cfg        LLMClientConfig
+}
+
+// defaultLLMTimeout is used when LLMClientConfig.Timeout is not set.
+const defaultLLMTimeout = 15 * time.Second
+
+// LLMClientConfig configures the http LLM client.
+type LLMClientConfig struct {
+	// Timeout caps each call. Zero means defaultLLMTimeout.
+	Timeout time.Duration
+	// HonorParentDeadline lets a deadline already on the caller's ctx replace Timeout,
+	// so the caller decides how long a long summary is allowed to take.
+	HonorParentDeadline bool
 }
 
 // NewHTTPLLMClient is the constructor for the llm client.
-func NewHTTPLLMClient(baseURL string) LLMClient {
+func NewHTTPLLMClient(baseURL string, cfg LLMClientConfig) LLMClient {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultLLMTimeout
+	}
 	return &httpLLMClient{
-		httpClient: &http.Client{Timeout: 15 * time.Second}, // Longer timeout for LLM
+		// No client-level Timeout here. The deadline is put on the request ctx instead so it can come from the caller.
+		httpClient: httpclient.New(0),
 		baseURL:    baseURL,
+		cfg:        cfg,
+	}
+}
+
+// callContext returns the ctx a single call should run under.
+func (c *httpLLMClient) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok && c.cfg.HonorParentDeadline {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, c.cfg.Timeout)
 }
 
 // DTOs for LLMGatewayService
@@ -108,6 +265,9 @@ type summarizeResponse struct {
 
 // Summarize makes an http call to the LLMGatewayService.
 func (c *httpLLMClient) Summarize(ctx context.Context, twilioSID string) (string, error) {
+	ctx, cancel := c.callContext(ctx)
+	defer cancel()
+
 	// Create the request body
 	reqBody, err := json.Marshal(summarizeRequest{TwilioConversationSID: twilioSID})
 	if err != nil {
@@ -144,15 +304,18 @@ func (c *httpLLMClient) Summarize(ctx context.Context, twilioSID string) (string
 }
 
 type httpChatClient struct {
-	httpClient *http.Client
-	baseURL    string
+	httpClient    *http.Client
+	baseURL       string
+	internalToken string // Sent as X-Internal-Token. The ChatGatewayService turns away anything without it
 }
 
-// NewHTTPChatClient is the constructor for the real Chat client.
-func NewHTTPChatClient(baseURL string) ChatClient {
+// NewHTTPChatClient is the constructor for the real Chat client. internalToken is the shared secret the
+// ChatGatewayService expects.
+func NewHTTPChatClient(baseURL, internalToken string, timeout time.Duration) ChatClient {
 	return &httpChatClient{
-		httpClient: &http.Client{Timeout: 5 * time.Second},
-		baseURL:    baseURL,
+		httpClient:    newHTTPClient(timeout),
+		baseURL:       baseURL,
+		internalToken: internalToken,
 	}
 }
 
@@ -178,6 +341,7 @@ func (c *httpChatClient) RemoveBot(ctx context.Context, twilioSID string) error
 		return fmt.Errorf("could not create remove-bot http request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(auth.InternalTokenHeader, c.internalToken)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -208,6 +372,7 @@ func (c *httpChatClient) AddExpert(ctx context.Context, twilioSID string, expert
 		return fmt.Errorf("could not create add-expert http request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(auth.InternalTokenHeader, c.internalToken)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -222,32 +387,64 @@ func (c *httpChatClient) AddExpert(ctx context.Context, twilioSID string, expert
 	return nil
 }
 
+// RemoveExpert makes an http call to the ChatGatewayService. It takes the same body as add-expert.
+func (c *httpChatClient) RemoveExpert(ctx context.Context, twilioSID string, expertID uuid.UUID) error {
+	reqBody, err := json.Marshal(addExpertRequest{
+		TwilioConversationSID: twilioSID,
+		ExpertID:              expertID.String(),
+	})
+	if err != nil {
+		return fmt.Errorf("could not marshal remove-expert request: %w", err)
+	}
+
+	url := c.baseURL + "/chat/remove-expert"
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return fmt.Errorf("could not create remove-expert http request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(auth.InternalTokenHeader, c.internalToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("remove-expert request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("chat service (remove-expert) returned non-200 status: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
 // --- UserClient Implementation ---
 
 // httpUserClient is the implementation for the UserClient.
 type httpUserClient struct {
-	httpClient *http.Client
-	baseURL    string
+	httpClient    *http.Client
+	baseURL       string
+	internalToken string // Sent as X-Internal-Token, the UserService's internal routes are closed without it
 }
 
-// NewHTTPUserClient is the constructor for the real User client.
-func NewHTTPUserClient(baseURL string) UserClient {
+// NewHTTPUserClient is the constructor for the real User client. internalToken is the shared secret the UserService expects.
+func NewHTTPUserClient(baseURL, internalToken string, timeout time.Duration) UserClient {
 	return &httpUserClient{
-		httpClient: &http.Client{Timeout: 5 * time.Second},
-		baseURL:    baseURL,
+		httpClient:    newHTTPClient(timeout),
+		baseURL:       baseURL,
+		internalToken: internalToken,
 	}
 }
 
 // GetUserProfile makes an http call to the UserService.
 func (c *httpUserClient) GetUserProfile(ctx context.Context, userID uuid.UUID) (*domain.User, error) {
-	// This endpoint path is an assumption for internal service-to-service comms.
-	// need to add this route to the UserService's handler.
 	url := fmt.Sprintf("%s/users/internal/%s", c.baseURL, userID.String())
 
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("could not create get-user http request: %w", err)
 	}
+	req.Header.Set(auth.InternalTokenHeader, c.internalToken)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -256,6 +453,9 @@ func (c *httpUserClient) GetUserProfile(ctx context.Context, userID uuid.UUID) (
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, ErrUserNotFound
+		}
 		return nil, fmt.Errorf("user service returned non-200 status: %d", resp.StatusCode)
 	}
 
@@ -267,3 +467,176 @@ func (c *httpUserClient) GetUserProfile(ctx context.Context, userID uuid.UUID) (
 
 	return &user, nil
 }
+
+// --- ExpertClient Implementation ---
+
+// httpExpertClient is the implementation for the ExpertClient. Experts live in the UserService too.
+type httpExpertClient struct {
+	httpClient    *http.Client
+	baseURL       string
+	internalToken string // Sent as X-Internal-Token, like the user client's
+}
+
+// NewHTTPExpertClient is the constructor for the real Expert client.
+func NewHTTPExpertClient(baseURL, internalToken string, timeout time.Duration) ExpertClient {
+	return &httpExpertClient{
+		httpClient:    newHTTPClient(timeout),
+		baseURL:       baseURL,
+		internalToken: internalToken,
+	}
+}
+
+// GetExpertProfile makes an http call to the UserService to fetch an expert by UUID.
+func (c *httpExpertClient) GetExpertProfile(ctx context.Context, expertID uuid.UUID) (*domain.Expert, error) {
+	url := fmt.Sprintf("%s/experts/internal/%s", c.baseURL, expertID.String())
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create get-expert http request: %w", err)
+	}
+	req.Header.Set(auth.InternalTokenHeader, c.internalToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("get-expert request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrExpertNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("user service (get-expert) returned non-200 status: %d", resp.StatusCode)
+	}
+
+	var expert domain.Expert
+	if err := json.NewDecoder(resp.Body).Decode(&expert); err != nil {
+		return nil, fmt.Errorf("could not decode expert profile: %w", err)
+	}
+
+	return &expert, nil
+}
+
+// --- NotificationClient Implementation ---
+
+// httpNotificationClient posts events to a notification webhook, which fans them out as push notifications.
+type httpNotificationClient struct {
+	httpClient *http.Client
+	baseURL    string
+}
+
+// NewHTTPNotificationClient is the constructor for the webhook notification client.
+func NewHTTPNotificationClient(baseURL string, timeout time.Duration) NotificationClient {
+	return &httpNotificationClient{
+		httpClient: newHTTPClient(timeout),
+		baseURL:    baseURL,
+	}
+}
+
+// DTO for the notification webhook
+type notifyUserRequest struct {
+	UserID    string `json:"user_id"`
+	RequestID string `json:"request_id"`
+	Event     string `json:"event"`
+}
+
+// NotifyUser makes an http call to the notification webhook.
+func (c *httpNotificationClient) NotifyUser(ctx context.Context, userID, requestID uuid.UUID, event string) error {
+	reqBody, err := json.Marshal(notifyUserRequest{
+		UserID:    userID.String(),
+		RequestID: requestID.String(),
+		Event:     event,
+	})
+	if err != nil {
+		return fmt.Errorf("could not marshal notify request: %w", err)
+	}
+
+	url := c.baseURL + "/notify/user"
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return fmt.Errorf("could not create notify http request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("notify request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("notification service returned non-2xx status: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// DTO for the notification webhook's expert fan-out. The webhook knows who's active and how to reach them.
+type notifyExpertsRequest struct {
+	RequestID  string `json:"request_id"`
+	LLMSummary string `json:"llm_summary"`
+}
+
+// NotifyExperts makes an http call to the notification webhook.
+func (c *httpNotificationClient) NotifyExperts(ctx context.Context, pending *domain.AssistanceRequest) error {
+	reqBody, err := json.Marshal(notifyExpertsRequest{
+		RequestID:  pending.RequestID.String(),
+		LLMSummary: pending.LLMSummary,
+	})
+	if err != nil {
+		return fmt.Errorf("could not marshal notify-experts request: %w", err)
+	}
+
+	url := c.baseURL + "/notify/experts"
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return fmt.Errorf("could not create notify-experts http request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("notify-experts request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("notification service (notify-experts) returned non-2xx status: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// stubNotificationClient just logs. It's used when no notification webhook is configured.
+type stubNotificationClient struct{}
+
+// NewStubNotificationClient is the constructor for the fake client.
+func NewStubNotificationClient() NotificationClient {
+	return &stubNotificationClient{}
+}
+
+func (s *stubNotificationClient) NotifyUser(ctx context.Context, userID, requestID uuid.UUID, event string) error {
+	fmt.Printf("STUB: Notify user %s about request %s: %s\n", userID, requestID, event)
+	return nil
+}
+
+func (s *stubNotificationClient) NotifyExperts(ctx context.Context, req *domain.AssistanceRequest) error {
+	fmt.Printf("STUB: Notify experts about new request %s\n", req.RequestID)
+	return nil
+}
+
+// noopNotificationClient drops every notification. It's the default when the service is built without one.
+type noopNotificationClient struct{}
+
+// NewNoopNotificationClient is the constructor for the silent client.
+func NewNoopNotificationClient() NotificationClient {
+	return noopNotificationClient{}
+}
+
+func (noopNotificationClient) NotifyUser(ctx context.Context, userID, requestID uuid.UUID, event string) error {
+	return nil
+}
+
+func (noopNotificationClient) NotifyExperts(ctx context.Context, req *domain.AssistanceRequest) error {
+	return nil
+}
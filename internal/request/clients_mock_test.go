@@ -3,7 +3,7 @@
 //
 // Generated by this command:
 //
-//	mockgen -destination=./clients_mock_test.go -package=request -source=clients.go BillingClient,LLMClient,ChatClient,UserClient
+//	mockgen -destination=./clients_mock_test.go -package=request -source=clients.go BillingClient,LLMClient,ChatClient,UserClient,ExpertClient,NotificationClient
 //
 
 // Package request is a generated GoMock package.
@@ -42,18 +42,63 @@ func (m *MockBillingClient) EXPECT() *MockBillingClientMockRecorder {
 	return m.recorder
 }
 
-// DebitToken mocks base method.
-func (m *MockBillingClient) DebitToken(ctx context.Context, userID uuid.UUID) error {
+// CommitHold mocks base method.
+func (m *MockBillingClient) CommitHold(ctx context.Context, userID, holdID uuid.UUID) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DebitToken", ctx, userID)
+	ret := m.ctrl.Call(m, "CommitHold", ctx, userID, holdID)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// DebitToken indicates an expected call of DebitToken.
-func (mr *MockBillingClientMockRecorder) DebitToken(ctx, userID any) *gomock.Call {
+// CommitHold indicates an expected call of CommitHold.
+func (mr *MockBillingClientMockRecorder) CommitHold(ctx, userID, holdID any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DebitToken", reflect.TypeOf((*MockBillingClient)(nil).DebitToken), ctx, userID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CommitHold", reflect.TypeOf((*MockBillingClient)(nil).CommitHold), ctx, userID, holdID)
+}
+
+// GetBalance mocks base method.
+func (m *MockBillingClient) GetBalance(ctx context.Context, userID uuid.UUID) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetBalance", ctx, userID)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetBalance indicates an expected call of GetBalance.
+func (mr *MockBillingClientMockRecorder) GetBalance(ctx, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBalance", reflect.TypeOf((*MockBillingClient)(nil).GetBalance), ctx, userID)
+}
+
+// HoldToken mocks base method.
+func (m *MockBillingClient) HoldToken(ctx context.Context, userID uuid.UUID, amount int, referenceID string) (uuid.UUID, int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HoldToken", ctx, userID, amount, referenceID)
+	ret0, _ := ret[0].(uuid.UUID)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// HoldToken indicates an expected call of HoldToken.
+func (mr *MockBillingClientMockRecorder) HoldToken(ctx, userID, amount, referenceID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HoldToken", reflect.TypeOf((*MockBillingClient)(nil).HoldToken), ctx, userID, amount, referenceID)
+}
+
+// ReleaseHold mocks base method.
+func (m *MockBillingClient) ReleaseHold(ctx context.Context, userID, holdID uuid.UUID) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReleaseHold", ctx, userID, holdID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReleaseHold indicates an expected call of ReleaseHold.
+func (mr *MockBillingClientMockRecorder) ReleaseHold(ctx, userID, holdID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReleaseHold", reflect.TypeOf((*MockBillingClient)(nil).ReleaseHold), ctx, userID, holdID)
 }
 
 // MockLLMClient is a mock of LLMClient interface.
@@ -147,6 +192,20 @@ func (mr *MockChatClientMockRecorder) RemoveBot(ctx, twilioSID any) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveBot", reflect.TypeOf((*MockChatClient)(nil).RemoveBot), ctx, twilioSID)
 }
 
+// RemoveExpert mocks base method.
+func (m *MockChatClient) RemoveExpert(ctx context.Context, twilioSID string, expertID uuid.UUID) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveExpert", ctx, twilioSID, expertID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveExpert indicates an expected call of RemoveExpert.
+func (mr *MockChatClientMockRecorder) RemoveExpert(ctx, twilioSID, expertID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveExpert", reflect.TypeOf((*MockChatClient)(nil).RemoveExpert), ctx, twilioSID, expertID)
+}
+
 // MockUserClient is a mock of UserClient interface.
 type MockUserClient struct {
 	ctrl     *gomock.Controller
@@ -185,3 +244,94 @@ func (mr *MockUserClientMockRecorder) GetUserProfile(ctx, userID any) *gomock.Ca
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserProfile", reflect.TypeOf((*MockUserClient)(nil).GetUserProfile), ctx, userID)
 }
+
+// MockExpertClient is a mock of ExpertClient interface.
+type MockExpertClient struct {
+	ctrl     *gomock.Controller
+	recorder *MockExpertClientMockRecorder
+	isgomock struct{}
+}
+
+// MockExpertClientMockRecorder is the mock recorder for MockExpertClient.
+type MockExpertClientMockRecorder struct {
+	mock *MockExpertClient
+}
+
+// NewMockExpertClient creates a new mock instance.
+func NewMockExpertClient(ctrl *gomock.Controller) *MockExpertClient {
+	mock := &MockExpertClient{ctrl: ctrl}
+	mock.recorder = &MockExpertClientMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockExpertClient) EXPECT() *MockExpertClientMockRecorder {
+	return m.recorder
+}
+
+// GetExpertProfile mocks base method.
+func (m *MockExpertClient) GetExpertProfile(ctx context.Context, expertID uuid.UUID) (*domain.Expert, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetExpertProfile", ctx, expertID)
+	ret0, _ := ret[0].(*domain.Expert)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetExpertProfile indicates an expected call of GetExpertProfile.
+func (mr *MockExpertClientMockRecorder) GetExpertProfile(ctx, expertID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetExpertProfile", reflect.TypeOf((*MockExpertClient)(nil).GetExpertProfile), ctx, expertID)
+}
+
+// MockNotificationClient is a mock of NotificationClient interface.
+type MockNotificationClient struct {
+	ctrl     *gomock.Controller
+	recorder *MockNotificationClientMockRecorder
+	isgomock struct{}
+}
+
+// MockNotificationClientMockRecorder is the mock recorder for MockNotificationClient.
+type MockNotificationClientMockRecorder struct {
+	mock *MockNotificationClient
+}
+
+// NewMockNotificationClient creates a new mock instance.
+func NewMockNotificationClient(ctrl *gomock.Controller) *MockNotificationClient {
+	mock := &MockNotificationClient{ctrl: ctrl}
+	mock.recorder = &MockNotificationClientMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockNotificationClient) EXPECT() *MockNotificationClientMockRecorder {
+	return m.recorder
+}
+
+// NotifyExperts mocks base method.
+func (m *MockNotificationClient) NotifyExperts(ctx context.Context, req *domain.AssistanceRequest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NotifyExperts", ctx, req)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// NotifyExperts indicates an expected call of NotifyExperts.
+func (mr *MockNotificationClientMockRecorder) NotifyExperts(ctx, req any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NotifyExperts", reflect.TypeOf((*MockNotificationClient)(nil).NotifyExperts), ctx, req)
+}
+
+// NotifyUser mocks base method.
+func (m *MockNotificationClient) NotifyUser(ctx context.Context, userID, requestID uuid.UUID, event string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NotifyUser", ctx, userID, requestID, event)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// NotifyUser indicates an expected call of NotifyUser.
+func (mr *MockNotificationClientMockRecorder) NotifyUser(ctx, userID, requestID, event any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NotifyUser", reflect.TypeOf((*MockNotificationClient)(nil).NotifyUser), ctx, userID, requestID, event)
+}
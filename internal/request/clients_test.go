@@ -0,0 +1,280 @@
+package request
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"project-sage/internal/auth"
+	"project-sage/internal/httpclient"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// slowServer returns a server that doesn't answer until the client goes away or the test ends.
+func slowServer(t *testing.T) *httptest.Server {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(func() {
+		close(release)
+		server.Close()
+	})
+	return server
+}
+
+// TestLLMClient_Summarize_ContextCancelled checks that a cancelled caller aborts the summarize call promptly.
+func TestLLMClient_Summarize_ContextCancelled(t *testing.T) {
+	client := NewHTTPLLMClient(slowServer(t).URL, LLMClientConfig{Timeout: time.Minute})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := client.Summarize(ctx, "CH123")
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("call took %v after cancel, expected it to return promptly", elapsed)
+	}
+}
+
+// TestLLMClient_Summarize_Timeout checks the configured timeout applies when the parent ctx has no deadline.
+func TestLLMClient_Summarize_Timeout(t *testing.T) {
+	client := NewHTTPLLMClient(slowServer(t).URL, LLMClientConfig{Timeout: 50 * time.Millisecond, HonorParentDeadline: true})
+
+	_, err := client.Summarize(context.Background(), "CH123")
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+// TestLLMClient_Summarize_HonorsParentDeadline checks a longer parent deadline wins over the configured timeout.
+func TestLLMClient_Summarize_HonorsParentDeadline(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond) // Longer than Timeout, shorter than the parent deadline.
+		w.Write([]byte(`{"summary":"Wi-Fi is down."}`))
+	}))
+	defer server.Close()
+
+	client := NewHTTPLLMClient(server.URL, LLMClientConfig{Timeout: 20 * time.Millisecond, HonorParentDeadline: true})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	summary, err := client.Summarize(ctx, "CH123")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if summary != "Wi-Fi is down." {
+		t.Errorf("unexpected summary %q", summary)
+	}
+}
+
+// TestClientTimeouts checks each client gets the timeout it's constructed with, DefaultClientTimeout for 0,
+// and the shared transport with its connect and TLS handshake timeouts.
+func TestClientTimeouts(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		want    time.Duration
+	}{
+		{"configured", 2 * time.Second, 2 * time.Second},
+		{"default", 0, DefaultClientTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clients := map[string]*http.Client{
+				"billing":      NewHTTPBillingClient("http://billing", "secret", tt.timeout).(*httpBillingClient).httpClient,
+				"chat":         NewHTTPChatClient("http://chat", "secret", tt.timeout).(*httpChatClient).httpClient,
+				"user":         NewHTTPUserClient("http://user", "secret", tt.timeout).(*httpUserClient).httpClient,
+				"expert":       NewHTTPExpertClient("http://user", "secret", tt.timeout).(*httpExpertClient).httpClient,
+				"notification": NewHTTPNotificationClient("http://notify", tt.timeout).(*httpNotificationClient).httpClient,
+			}
+			for name, c := range clients {
+				if c.Timeout != tt.want {
+					t.Errorf("%s: expected timeout %s, got %s", name, tt.want, c.Timeout)
+				}
+				if c.Transport != httpclient.Transport {
+					t.Errorf("%s: expected the shared transport", name)
+				}
+			}
+		})
+	}
+}
+
+func TestExpertClient_GetExpertProfile_NotFound(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	client := NewHTTPExpertClient(server.URL, "internal-secret", 0)
+	_, err := client.GetExpertProfile(context.Background(), uuid.New())
+
+	if !errors.Is(err, ErrExpertNotFound) {
+		t.Fatalf("Expected ErrExpertNotFound, got: %v", err)
+	}
+}
+
+// TestUserClients_SendInternalToken checks the user and expert lookups carry the token the UserService's
+// internal routes require.
+func TestUserClients_SendInternalToken(t *testing.T) {
+	var tokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens = append(tokens, r.Header.Get(auth.InternalTokenHeader))
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	if _, err := NewHTTPUserClient(server.URL, "internal-secret", 0).GetUserProfile(context.Background(), uuid.New()); err != nil {
+		t.Fatalf("GetUserProfile() returned unexpected error: %v", err)
+	}
+	if _, err := NewHTTPExpertClient(server.URL, "internal-secret", 0).GetExpertProfile(context.Background(), uuid.New()); err != nil {
+		t.Fatalf("GetExpertProfile() returned unexpected error: %v", err)
+	}
+	for i, got := range tokens {
+		if got != "internal-secret" {
+			t.Errorf("call %d: expected the internal token to be sent, got %q", i, got)
+		}
+	}
+}
+
+// TestChatClient_SendsInternalToken checks the participant changes carry the token the ChatGatewayService's
+// routes require.
+func TestChatClient_SendsInternalToken(t *testing.T) {
+	var tokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens = append(tokens, r.Header.Get(auth.InternalTokenHeader))
+	}))
+	defer server.Close()
+
+	client := NewHTTPChatClient(server.URL, "internal-secret", 0)
+	if err := client.RemoveBot(context.Background(), "CH123"); err != nil {
+		t.Fatalf("RemoveBot() returned unexpected error: %v", err)
+	}
+	if err := client.AddExpert(context.Background(), "CH123", uuid.New()); err != nil {
+		t.Fatalf("AddExpert() returned unexpected error: %v", err)
+	}
+	if err := client.RemoveExpert(context.Background(), "CH123", uuid.New()); err != nil {
+		t.Fatalf("RemoveExpert() returned unexpected error: %v", err)
+	}
+	if len(tokens) != 3 {
+		t.Fatalf("Expected 3 calls, got %d", len(tokens))
+	}
+	for i, got := range tokens {
+		if got != "internal-secret" {
+			t.Errorf("call %d: expected the internal token to be sent, got %q", i, got)
+		}
+	}
+}
+
+// TestBillingClient_HoldToken checks the amount goes to /token/hold, the hold id and balance come back, and a 409 is ErrInsufficientFunds.
+func TestBillingClient_HoldToken(t *testing.T) {
+	holdID := uuid.New()
+	var got holdRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/token/hold" {
+			t.Errorf("Expected /token/hold, got %s", r.URL.Path)
+		}
+		if got := r.Header.Get(auth.InternalTokenHeader); got != "internal-secret" {
+			t.Errorf("Expected the internal token to be sent, got %q", got)
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		if got.Amount > 2 {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		json.NewEncoder(w).Encode(holdResponse{HoldID: holdID.String(), NewBalance: 3})
+	}))
+	defer server.Close()
+
+	client := NewHTTPBillingClient(server.URL, "internal-secret", 0)
+	id, balance, err := client.HoldToken(context.Background(), uuid.New(), 2, "ref-2")
+	if err != nil {
+		t.Fatalf("HoldToken() returned error: %v", err)
+	}
+	if id != holdID || balance != 3 {
+		t.Errorf("Expected hold %s with balance 3, got %s with %d", holdID, id, balance)
+	}
+	if got.Amount != 2 || got.ReferenceID != "ref-2" {
+		t.Errorf("Expected amount 2 and reference ref-2 in the body, got %+v", got)
+	}
+	if _, _, err := client.HoldToken(context.Background(), uuid.New(), 5, "ref-5"); !errors.Is(err, ErrInsufficientFunds) {
+		t.Errorf("Expected ErrInsufficientFunds, got %v", err)
+	}
+}
+
+// TestBillingClient_ResolveHold checks commit and release post the hold to their own endpoints.
+func TestBillingClient_ResolveHold(t *testing.T) {
+	userID, holdID := uuid.New(), uuid.New()
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body resolveHoldRequest
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.UserID != userID.String() || body.HoldID != holdID.String() {
+			t.Errorf("Unexpected body %+v", body)
+		}
+		if got := r.Header.Get(auth.InternalTokenHeader); got != "internal-secret" {
+			t.Errorf("Expected the internal token to be sent, got %q", got)
+		}
+		paths = append(paths, r.URL.Path)
+		if len(paths) > 2 {
+			w.WriteHeader(http.StatusConflict)
+		}
+	}))
+	defer server.Close()
+
+	client := NewHTTPBillingClient(server.URL, "internal-secret", 0)
+	if err := client.CommitHold(context.Background(), userID, holdID); err != nil {
+		t.Fatalf("CommitHold() returned error: %v", err)
+	}
+	if err := client.ReleaseHold(context.Background(), userID, holdID); err != nil {
+		t.Fatalf("ReleaseHold() returned error: %v", err)
+	}
+	if len(paths) != 2 || paths[0] != "/token/commit" || paths[1] != "/token/release" {
+		t.Errorf("Expected /token/commit then /token/release, got %v", paths)
+	}
+	if err := client.ReleaseHold(context.Background(), userID, holdID); err == nil {
+		t.Error("Expected an error for a 409")
+	}
+}
+
+// TestBillingClient_GetBalance checks the balance is read from /token/balance/{id} and a 404 is ErrUserNotFound.
+func TestBillingClient_GetBalance(t *testing.T) {
+	known := uuid.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("Expected a GET, got %s", r.Method)
+		}
+		if got := r.Header.Get(auth.InternalTokenHeader); got != "internal-secret" {
+			t.Errorf("Expected the internal token to be sent, got %q", got)
+		}
+		if r.URL.Path != "/token/balance/"+known.String() {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(balanceResponse{Balance: 7})
+	}))
+	defer server.Close()
+
+	client := NewHTTPBillingClient(server.URL, "internal-secret", 0)
+	balance, err := client.GetBalance(context.Background(), known)
+	if err != nil {
+		t.Fatalf("GetBalance() returned error: %v", err)
+	}
+	if balance != 7 {
+		t.Errorf("Expected balance 7, got %d", balance)
+	}
+	if _, err := client.GetBalance(context.Background(), uuid.New()); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"project-sage/internal/domain"
+)
+
+// Sentinel errors shared by the repository, clients, service and handler.
+// Callers should check for these with errors.Is, never by comparing strings.
+var (
+	// ErrInsufficientFunds means the BillingService refused the debit.
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	// ErrRequestAlreadyAccepted means the request was not pending when an expert tried to accept it.
+	ErrRequestAlreadyAccepted = errors.New("request not found or was already accepted")
+	// ErrQueueEmpty means there was no pending request left to claim.
+	ErrQueueEmpty = errors.New("no pending requests")
+	// ErrNotFound means the request does not exist.
+	ErrNotFound = errors.New("request not found")
+	// ErrRequestNotOpen means the request is no longer pending or active (eg it was resolved).
+	ErrRequestNotOpen = errors.New("request is not pending or active")
+	// ErrExpertNotFound means the UserService has no expert with that id.
+	ErrExpertNotFound = errors.New("expert not found")
+	// ErrExpertNotActive means the expert's account is switched off, so they can't take requests.
+	ErrExpertNotActive = errors.New("expert is not active")
+	// ErrDuplicateRequest means the conversation already has a pending or active request.
+	ErrDuplicateRequest = errors.New("an open request already exists for this conversation")
+	// ErrRequestNotActive means the request has no expert working on it right now, so there's nothing to transfer.
+	ErrRequestNotActive = errors.New("request is not active")
+	// ErrForbidden means the caller isn't allowed to change this request, eg they aren't its expert or an admin.
+	ErrForbidden = errors.New("not allowed to change this request")
+	// ErrInvalidTransferTarget means the request can't be transferred to that expert, eg it's already theirs.
+	ErrInvalidTransferTarget = errors.New("invalid transfer target")
+	// ErrRequestAlreadyOpen means the user already has a pending or active request, on any conversation.
+	ErrRequestAlreadyOpen = errors.New("user already has an open request")
+	// ErrRequestNotResolved means a reopen was attempted on a request that isn't resolved.
+	ErrRequestNotResolved = errors.New("request is not resolved")
+	// ErrReopenWindowExpired means the request was resolved too long ago to be reopened.
+	ErrReopenWindowExpired = errors.New("request was resolved too long ago to reopen")
+	// ErrRequestReserved means another expert has the pending request reserved for now.
+	ErrRequestReserved = errors.New("request is reserved by another expert")
+	// ErrUnknownRequestType means CreateRequest was given a request type with no configured token cost.
+	ErrUnknownRequestType = errors.New("unknown request type")
+	// ErrUserNotFound means the UserService or BillingService has no user with that id.
+	ErrUserNotFound = errors.New("user not found")
+)
+
+// DuplicateRequestError is returned by CreateRequest when the conversation already has an open request.
+// Existing is that request, so the client can carry on with it instead of making a new one.
+type DuplicateRequestError struct {
+	Existing *domain.AssistanceRequest
+}
+
+func (e *DuplicateRequestError) Error() string {
+	return ErrDuplicateRequest.Error()
+}
+
+// Unwrap lets errors.Is(err, ErrDuplicateRequest) work.
+func (e *DuplicateRequestError) Unwrap() error {
+	return ErrDuplicateRequest
+}
+
+// AlreadyAcceptedError is returned by AcceptRequest when another expert got there first.
+// Current is the request as it is now, so the client can say who took it and when.
+type AlreadyAcceptedError struct {
+	Current *domain.AssistanceRequest
+}
+
+func (e *AlreadyAcceptedError) Error() string {
+	return ErrRequestAlreadyAccepted.Error()
+}
+
+// Unwrap lets errors.Is(err, ErrRequestAlreadyAccepted) work.
+func (e *AlreadyAcceptedError) Unwrap() error {
+	return ErrRequestAlreadyAccepted
+}
+
+// OpenRequestError is returned by CreateRequest, before any token is debited, when the user already has an open request.
+// Existing is that request if it could be fetched, so the client can take the user back to it.
+type OpenRequestError struct {
+	Existing *domain.AssistanceRequest
+}
+
+func (e *OpenRequestError) Error() string {
+	return ErrRequestAlreadyOpen.Error()
+}
+
+// Unwrap lets errors.Is(err, ErrRequestAlreadyOpen) work.
+func (e *OpenRequestError) Unwrap() error {
+	return ErrRequestAlreadyOpen
+}
+
+// StepTimeoutError is returned when one downstream call in the orchestration runs past its own deadline.
+// Step names the call that timed out (eg "Summarize") so the caller can tell which dependency was slow.
+type StepTimeoutError struct {
+	Step string
+	Err  error
+}
+
+func (e *StepTimeoutError) Error() string {
+	return fmt.Sprintf("deadline exceeded calling %s", e.Step)
+}
+
+// Unwrap lets errors.Is(err, context.DeadlineExceeded) keep working.
+func (e *StepTimeoutError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,155 @@
+package request
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"project-sage/internal/auth"
+	"project-sage/internal/httputil"
+
+	"github.com/google/uuid"
+)
+
+// exportFlushEvery is how many rows are written between flushes to the client.
+const exportFlushEvery = 100
+
+// exportCSVHeader is the first line of the CSV export. It must match csvRecord.
+var exportCSVHeader = []string{"request_id", "status", "created_at", "accepted_at", "resolved_at", "expert_display_name", "rating"}
+
+// handleExportRequests streams the authenticated user's whole request history as CSV or JSON.
+func (h *Handler) handleExportRequests(w http.ResponseWriter, r *http.Request) {
+	// This export hands over a user's records, so no placeholder id here. No user means no export.
+	userID, err := auth.GetUserID(r.Context())
+	if err != nil {
+		httputil.WriteError(w, http.StatusUnauthorized, "Not authorized")
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "csv":
+		h.exportCSV(w, r, userID)
+	case "json", "":
+		h.exportJSON(w, r, userID)
+	default:
+		httputil.WriteError(w, http.StatusBadRequest, "format must be csv or json")
+	}
+}
+
+// exportCSV writes the export as a CSV download, one row at a time.
+func (h *Handler) exportCSV(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	ew := &exportWriter{ResponseWriter: w}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="requests.csv"`)
+
+	cw := csv.NewWriter(ew)
+	cw.Write(exportCSVHeader)
+
+	rows := 0
+	err := h.service.ExportRequests(r.Context(), userID, func(row *RequestExportRow) error {
+		if err := cw.Write(csvRecord(row)); err != nil {
+			return err
+		}
+		rows++
+		if rows%exportFlushEvery == 0 {
+			cw.Flush()
+			ew.flush()
+		}
+		return cw.Error()
+	})
+	if err == nil {
+		cw.Flush()
+		err = cw.Error()
+	}
+	ew.finish(userID, err)
+}
+
+// exportJSON writes the export as a JSON array, encoding each row as it comes instead of building a slice.
+func (h *Handler) exportJSON(w http.ResponseWriter, r *http.Request, userID uuid.UUID) {
+	ew := &exportWriter{ResponseWriter: w}
+	w.Header().Set("Content-Type", "application/json")
+
+	bw := bufio.NewWriter(ew)
+	enc := json.NewEncoder(bw)
+	bw.WriteString("[")
+
+	rows := 0
+	err := h.service.ExportRequests(r.Context(), userID, func(row *RequestExportRow) error {
+		if rows > 0 {
+			bw.WriteString(",")
+		}
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+		rows++
+		if rows%exportFlushEvery == 0 {
+			if err := bw.Flush(); err != nil {
+				return err
+			}
+			ew.flush()
+		}
+		return nil
+	})
+	if err == nil {
+		bw.WriteString("]\n")
+		err = bw.Flush()
+	}
+	ew.finish(userID, err)
+}
+
+// csvRecord turns a row into CSV fields. Missing values are empty cells.
+func csvRecord(row *RequestExportRow) []string {
+	record := []string{row.RequestID.String(), row.Status, row.CreatedAt.Format(time.RFC3339), "", "", "", ""}
+	if row.AcceptedAt != nil {
+		record[3] = row.AcceptedAt.Format(time.RFC3339)
+	}
+	if row.ResolvedAt != nil {
+		record[4] = row.ResolvedAt.Format(time.RFC3339)
+	}
+	if row.ExpertDisplayName != nil {
+		record[5] = *row.ExpertDisplayName
+	}
+	if row.Rating != nil {
+		record[6] = strconv.Itoa(*row.Rating)
+	}
+	return record
+}
+
+// exportWriter remembers whether anything has reached the client yet,
+// so a failure before the first flush can still become a proper 500.
+type exportWriter struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (ew *exportWriter) Write(p []byte) (int, error) {
+	ew.wrote = true
+	return ew.ResponseWriter.Write(p)
+}
+
+// flush pushes what's been written so far out to the client.
+func (ew *exportWriter) flush() {
+	if f, ok := ew.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish handles the end of an export. Once part of the body is out, the status can't change anymore,
+// so the connection is aborted instead, and the client sees a failed download rather than a silently short file.
+func (ew *exportWriter) finish(userID uuid.UUID, err error) {
+	if err == nil {
+		ew.flush()
+		return
+	}
+	fmt.Printf("WARNING: Export for user %s failed: %v\n", userID, err)
+	if !ew.wrote {
+		ew.Header().Del("Content-Disposition")
+		httputil.WriteError(ew.ResponseWriter, http.StatusInternalServerError, "Could not export requests")
+		return
+	}
+	panic(http.ErrAbortHandler)
+}
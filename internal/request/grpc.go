@@ -0,0 +1,178 @@
+package request
+
+//go:generate protoc -I ../../proto --go_out=../.. --go_opt=module=project-sage --go-grpc_out=../.. --go-grpc_opt=module=project-sage request/v1/request.proto
+
+import (
+	"context"
+	"errors"
+	"project-sage/internal/auth"
+	"project-sage/internal/domain"
+	"project-sage/internal/request/requestpb"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// GRPCServer is the gRPC API layer for internal service-to-service calls.
+// Like the HTTP Handler it only translates; all business logic stays in the Service.
+type GRPCServer struct {
+	requestpb.UnimplementedRequestServiceServer
+	service Service
+}
+
+// NewGRPCServer creates a new GRPCServer, injecting the service.
+func NewGRPCServer(s Service) *GRPCServer {
+	return &GRPCServer{
+		service: s,
+	}
+}
+
+// InternalTokenMetadataKey is the gRPC metadata key for the shared secret, X-Internal-Token's counterpart.
+// gRPC metadata keys are lower case.
+const InternalTokenMetadataKey = "x-internal-token"
+
+// InternalTokenInterceptor turns away every unary call whose x-internal-token metadata isn't secret, with
+// codes.Unauthenticated, before it reaches the GRPCServer. The gRPC API is for our own services only, like the
+// HTTP /internal routes.
+func InternalTokenInterceptor(secret string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		var got string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if vals := md.Get(InternalTokenMetadataKey); len(vals) > 0 {
+				got = vals[0]
+			}
+		}
+		if !auth.ValidInternalToken(secret, got) {
+			return nil, status.Error(codes.Unauthenticated, "missing or invalid internal token")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// CreateRequest implements requestpb.RequestServiceServer.
+func (g *GRPCServer) CreateRequest(ctx context.Context, in *requestpb.CreateRequestRequest) (*requestpb.AssistanceRequest, error) {
+	userID, err := uuid.Parse(in.GetUserId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid user_id format")
+	}
+
+	// The proto has no request type yet, so gRPC callers always create standard requests.
+	req, err := g.service.CreateRequest(ctx, userID, in.GetTwilioConversationSid(), RequestTypeStandard)
+	if err != nil {
+		return nil, toGRPCError(err, "could not create request")
+	}
+	// The proto has no field for the remaining balance either. Clients that want it use the REST endpoint.
+	return toProtoRequest(req.AssistanceRequest), nil
+}
+
+// GetPendingRequests implements requestpb.RequestServiceServer.
+func (g *GRPCServer) GetPendingRequests(ctx context.Context, in *requestpb.GetPendingRequestsRequest) (*requestpb.GetPendingRequestsResponse, error) {
+	// The proto has no expert id, so this is the plain queue nobody has reserved.
+	// Nor any paging, so it gets the whole queue on one page.
+	page, err := g.service.GetPendingRequests(ctx, QueueOptions{})
+	if err != nil {
+		return nil, toGRPCError(err, "could not fetch pending requests")
+	}
+
+	resp := &requestpb.GetPendingRequestsResponse{
+		Requests: make([]*requestpb.AssistanceRequest, len(page.Items)),
+	}
+	for i, req := range page.Items {
+		resp.Requests[i] = toProtoRequest(req.AssistanceRequest)
+	}
+	return resp, nil
+}
+
+// AcceptRequest implements requestpb.RequestServiceServer.
+func (g *GRPCServer) AcceptRequest(ctx context.Context, in *requestpb.AcceptRequestRequest) (*requestpb.AssistanceRequest, error) {
+	reqID, err := uuid.Parse(in.GetRequestId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid request_id format")
+	}
+	expertID, err := uuid.Parse(in.GetExpertId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid expert_id format")
+	}
+
+	req, err := g.service.AcceptRequest(ctx, reqID, expertID)
+	if err != nil {
+		return nil, toGRPCError(err, "could not accept request")
+	}
+	return toProtoRequest(req), nil
+}
+
+// ResolveRequest implements requestpb.RequestServiceServer.
+func (g *GRPCServer) ResolveRequest(ctx context.Context, in *requestpb.ResolveRequestRequest) (*requestpb.ResolveRequestResponse, error) {
+	reqID, err := uuid.Parse(in.GetRequestId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid request_id format")
+	}
+	expertID, err := uuid.Parse(in.GetExpertId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid expert_id format")
+	}
+
+	if err := g.service.ResolveRequest(ctx, reqID, expertID); err != nil {
+		return nil, toGRPCError(err, "could not resolve request")
+	}
+	return &requestpb.ResolveRequestResponse{}, nil
+}
+
+// toGRPCError maps the package's sentinel errors to gRPC status codes, the same way the HTTP handler maps them to status codes.
+func toGRPCError(err error, message string) error {
+	// A step timeout wraps whatever the call returned, so it goes first.
+	var stepErr *StepTimeoutError
+	if errors.As(err, &stepErr) {
+		return status.Errorf(codes.DeadlineExceeded, "deadline exceeded calling %s", stepErr.Step)
+	}
+	switch {
+	case errors.Is(err, ErrUnknownRequestType):
+		return status.Error(codes.InvalidArgument, "unknown request type")
+	case errors.Is(err, ErrInsufficientFunds):
+		return status.Error(codes.FailedPrecondition, "insufficient assistance tokens")
+	case errors.Is(err, ErrRequestAlreadyAccepted):
+		return status.Error(codes.Aborted, "request already accepted")
+	case errors.Is(err, ErrNotFound):
+		return status.Error(codes.NotFound, "request not found")
+	case errors.Is(err, ErrDuplicateRequest):
+		return status.Error(codes.AlreadyExists, "a request is already open for this conversation")
+	case errors.Is(err, ErrRequestAlreadyOpen):
+		return status.Error(codes.AlreadyExists, "user already has an open request")
+	case errors.Is(err, ErrExpertNotActive):
+		return status.Error(codes.PermissionDenied, "expert is not active")
+	case errors.Is(err, ErrRequestNotActive):
+		return status.Error(codes.FailedPrecondition, "request is not active")
+	case errors.Is(err, ErrRequestReserved):
+		return status.Error(codes.Aborted, "request is reserved by another expert")
+	case errors.Is(err, ErrForbidden):
+		return status.Error(codes.PermissionDenied, "not allowed to change this request")
+	default:
+		return status.Error(codes.Internal, message)
+	}
+}
+
+// toProtoRequest converts the domain model to its protobuf form.
+func toProtoRequest(req *domain.AssistanceRequest) *requestpb.AssistanceRequest {
+	out := &requestpb.AssistanceRequest{
+		RequestId:             req.RequestID.String(),
+		UserId:                req.UserID.String(),
+		Status:                req.Status,
+		LlmSummary:            req.LLMSummary,
+		TwilioConversationSid: req.TwilioConversationSID,
+		CreatedAt:             timestamppb.New(req.CreatedAt),
+	}
+	if req.ExpertID.Valid {
+		out.ExpertId = req.ExpertID.UUID.String()
+	}
+	if req.AcceptedAt.Valid {
+		out.AcceptedAt = timestamppb.New(req.AcceptedAt.Time)
+	}
+	if req.ResolvedAt.Valid {
+		out.ResolvedAt = timestamppb.New(req.ResolvedAt.Time)
+	}
+	return out
+}
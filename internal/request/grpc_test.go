@@ -0,0 +1,187 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"net"
+	"project-sage/internal/domain"
+	"project-sage/internal/request/requestpb"
+	"testing"
+
+	"github.com/google/uuid"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// This test runs the real service behind a real gRPC server over an in-memory connection.
+// Only the repository and clients are mocked.
+
+// setupGRPCTest starts a gRPC server for the given service and returns a client connected to it,
+// which sends the internal token the server expects.
+func setupGRPCTest(t *testing.T, s Service) requestpb.RequestServiceClient {
+	return setupGRPCTestWithToken(t, s, testInternalToken)
+}
+
+// setupGRPCTestWithToken is setupGRPCTest with a client that sends token instead, or no token if it's empty.
+func setupGRPCTestWithToken(t *testing.T, s Service, token string) requestpb.RequestServiceClient {
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer(grpc.UnaryInterceptor(InternalTokenInterceptor(testInternalToken)))
+	requestpb.RegisterRequestServiceServer(srv, NewGRPCServer(s))
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			if token != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, InternalTokenMetadataKey, token)
+			}
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("Could not dial bufconn: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return requestpb.NewRequestServiceClient(conn)
+}
+
+// TestGRPC_AcceptRequest_Success tests the accept flow end to end over gRPC.
+func TestGRPC_AcceptRequest_Success(t *testing.T) {
+	_, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	reqID := uuid.New()
+	expertID := uuid.New()
+	twilioSID := "CH-grpc-123"
+	mockRequest := &domain.AssistanceRequest{
+		RequestID:             reqID,
+		UserID:                uuid.New(),
+		ExpertID:              uuid.NullUUID{UUID: expertID, Valid: true},
+		TwilioConversationSID: twilioSID,
+		Status:                "active",
+	}
+
+	// The context crosses the wire, so it can't be matched exactly.
+	gomock.InOrder(
+		mockExpert.EXPECT().GetExpertProfile(gomock.Any(), expertID).Return(&domain.Expert{DisplayName: "Joe", IsActive: true}, nil).Times(1),
+		mockRepo.EXPECT().AcceptRequest(gomock.Any(), reqID, expertID).Return(mockRequest, nil).Times(1),
+		mockChat.EXPECT().AddExpert(gomock.Any(), twilioSID, expertID).Return(nil).Times(1),
+	)
+
+	client := setupGRPCTest(t, NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert))
+
+	resp, err := client.AcceptRequest(context.Background(), &requestpb.AcceptRequestRequest{
+		RequestId: reqID.String(),
+		ExpertId:  expertID.String(),
+	})
+	if err != nil {
+		t.Fatalf("AcceptRequest() returned unexpected error: %v", err)
+	}
+	if resp.GetStatus() != "active" {
+		t.Errorf("Expected status 'active', got '%s'", resp.GetStatus())
+	}
+	if resp.GetExpertId() != expertID.String() {
+		t.Errorf("Expected expert_id %s, got %s", expertID, resp.GetExpertId())
+	}
+}
+
+// TestGRPC_AcceptRequest_AlreadyAccepted tests that the race condition error comes back as Aborted.
+func TestGRPC_AcceptRequest_AlreadyAccepted(t *testing.T) {
+	_, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	reqID := uuid.New()
+	expertID := uuid.New()
+
+	mockExpert.EXPECT().GetExpertProfile(gomock.Any(), expertID).Return(&domain.Expert{IsActive: true}, nil).Times(1)
+	mockRepo.EXPECT().AcceptRequest(gomock.Any(), reqID, expertID).Return(nil, ErrRequestAlreadyAccepted).Times(1)
+	// The service looks the request up again to see who won.
+	mockRepo.EXPECT().GetRequestByID(gomock.Any(), reqID).
+		Return(&domain.AssistanceRequest{RequestID: reqID, Status: "active", ExpertID: uuid.NullUUID{UUID: uuid.New(), Valid: true}}, nil).Times(1)
+	mockChat.EXPECT().AddExpert(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	client := setupGRPCTest(t, NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert))
+
+	_, err := client.AcceptRequest(context.Background(), &requestpb.AcceptRequestRequest{
+		RequestId: reqID.String(),
+		ExpertId:  expertID.String(),
+	})
+	if status.Code(err) != codes.Aborted {
+		t.Fatalf("Expected code Aborted, got %v (%v)", status.Code(err), err)
+	}
+}
+
+// TestGRPC_RequiresInternalToken checks a call without the right token is Unauthenticated and never reaches the service.
+func TestGRPC_RequiresInternalToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	// No calls are expected on the service.
+	svc := NewMockService(ctrl)
+
+	for _, token := range []string{"", "wrong"} {
+		client := setupGRPCTestWithToken(t, svc, token)
+		_, err := client.GetPendingRequests(context.Background(), &requestpb.GetPendingRequestsRequest{})
+		if status.Code(err) != codes.Unauthenticated {
+			t.Errorf("token %q: expected code Unauthenticated, got %v (%v)", token, status.Code(err), err)
+		}
+	}
+}
+
+// TestGRPC_ResolveRequest_OtherExpert checks resolving someone else's request comes back as PermissionDenied.
+func TestGRPC_ResolveRequest_OtherExpert(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	svc := NewMockService(ctrl)
+
+	reqID, expertID := uuid.New(), uuid.New()
+	svc.EXPECT().ResolveRequest(gomock.Any(), reqID, expertID).Return(ErrForbidden).Times(1)
+
+	client := setupGRPCTest(t, svc)
+	_, err := client.ResolveRequest(context.Background(), &requestpb.ResolveRequestRequest{
+		RequestId: reqID.String(),
+		ExpertId:  expertID.String(),
+	})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected code PermissionDenied, got %v (%v)", status.Code(err), err)
+	}
+}
+
+// TestToGRPCError checks each service error comes out with the code callers branch on.
+func TestToGRPCError(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+		code codes.Code
+	}{
+		{"Insufficient funds", ErrInsufficientFunds, codes.FailedPrecondition},
+		{"Already accepted", ErrRequestAlreadyAccepted, codes.Aborted},
+		{"Not found", ErrNotFound, codes.NotFound},
+		{"Duplicate", &DuplicateRequestError{}, codes.AlreadyExists},
+		{"Open request", &OpenRequestError{Existing: &domain.AssistanceRequest{}}, codes.AlreadyExists},
+		{"Unknown request type", ErrUnknownRequestType, codes.InvalidArgument},
+		{"Expert not active", ErrExpertNotActive, codes.PermissionDenied},
+		{"Request not active", ErrRequestNotActive, codes.FailedPrecondition},
+		{"Reserved", ErrRequestReserved, codes.Aborted},
+		{"Forbidden", ErrForbidden, codes.PermissionDenied},
+		{"Step timeout", &StepTimeoutError{Step: "Summarize", Err: context.DeadlineExceeded}, codes.DeadlineExceeded},
+		{"Other", errors.New("boom"), codes.Internal},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if code := status.Code(toGRPCError(tc.err, "failed")); code != tc.code {
+				t.Errorf("Expected code %v, got %v", tc.code, code)
+			}
+		})
+	}
+}
@@ -1,25 +1,46 @@
 package request
 
 import (
-	"encoding/json"
+	_ "embed"
+	"errors"
+	"fmt"
 	"net/http"
-
-	// "project-sage/internal/auth" // I'll need this when I add real auth.
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"project-sage/internal/auth"
+	"project-sage/internal/domain"
+	"project-sage/internal/httputil"
+	"project-sage/internal/jsonbody"
+	"project-sage/internal/openapi"
+	"project-sage/internal/ratelimit"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 )
 
+// openAPISpec is the hand-written API contract, served at /openapi.json. Keep it in step with the routes and DTOs below.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
 // Handler is the HTTP API layer for the RequestService.
 // It holds a dependency on the business logic service.
 type Handler struct {
-	service Service
+	service       Service
+	createLimiter ratelimit.Store // Per-user limit on POST /request/create. nil disables it.
+	internalToken string          // What the /internal/request routes expect in X-Internal-Token
 }
 
-// NewHandler creates a new Handler, injecting the service.
-func NewHandler(s Service) *Handler {
+// NewHandler creates a new Handler, injecting the service, the rate limit store for request creation
+// and the shared secret other services send to the internal routes.
+func NewHandler(s Service, createLimiter ratelimit.Store, internalToken string) *Handler {
 	return &Handler{
-		service: s,
+		service:       s,
+		createLimiter: createLimiter,
+		internalToken: internalToken,
 	}
 }
 
@@ -27,18 +48,80 @@ func NewHandler(s Service) *Handler {
 // This includes both user facing and expert-facing routes.
 func (h *Handler) RegisterRoutes(r chi.Router) {
 	// User facing routes
-	r.Post("/request/create", h.handleCreateRequest)
+	// Creating a request costs a token and an LLM call, so it's rate limited per user.
+	r.With(h.limitCreate).Post("/request/create", h.handleCreateRequest)
+	r.Get("/request/can-create", h.handleCanCreateRequest)
 	r.Post("/request/rate", h.handleRateRequest)
+	r.Get("/request/export", h.handleExportRequests)
+	r.Post("/request/reopen", h.handleReopenRequest)
+
+	// Expert facing routes. Only experts work the queue.
+	r.Group(func(r chi.Router) {
+		r.Use(auth.RequireRole(auth.RoleExpert))
+		r.Get("/request/pending", h.handleGetPendingRequests)
+		r.Get("/request/pending/watch", h.handleWatchPendingRequests)
+		r.Post("/request/reserve", h.handleReserveRequest)
+		r.Post("/request/accept", h.handleAcceptRequest)
+		r.Post("/request/claim-next", h.handleClaimNextRequest)
+		r.Post("/request/resolve", h.handleResolveRequest)
+	})
+	// Refreshing a summary is for whoever is reading it, an expert or an admin.
+	r.With(auth.RequireAnyRole(auth.RoleExpert, auth.RoleSuperadmin)).Post("/request/{id}/resummarize", h.handleResummarizeRequest)
+	// A superadmin can transfer a request too, so the service checks the caller.
+	r.Post("/request/transfer", h.handleTransferRequest)
+	// Experts read a request in full before accepting it. Its user can read it too, so the service checks the caller.
+	r.Get("/request/{id}", h.handleGetRequest)
+
+	// Internal routes for other services, e.g. the chat gateway's webhooks which only know the conversation SID.
+	// Escalate takes the user from the body, so nothing but our own services may call these.
+	r.Group(func(r chi.Router) {
+		r.Use(auth.RequireInternalToken(h.internalToken))
+		r.Get("/internal/request/by-conversation/{sid}", h.handleGetRequestByConversation)
+		r.Post("/internal/request/first-response", h.handleRecordFirstResponse)
+		r.Post("/internal/request/escalate", h.handleEscalateRequest)
+	})
+
+	// Admin routes
+	r.Group(func(r chi.Router) {
+		r.Use(auth.RequireRole(auth.RoleSuperadmin))
+		r.Get("/request/admin/stats", h.handleGetRequestStats)
+		r.Get("/request/admin/search", h.handleSearchRequests)
+	})
+
+	// The API contract, for client generation.
+	r.Get(openapi.Path, openapi.Handler(openAPISpec))
+}
 
-	// Expert facing routes
-	r.Get("/request/pending", h.handleGetPendingRequests)
-	r.Post("/request/accept", h.handleAcceptRequest)
-	r.Post("/request/resolve", h.handleResolveRequest)
+// limitCreate applies the per-user rate limit to request creation, if one is configured.
+func (h *Handler) limitCreate(next http.Handler) http.Handler {
+	if h.createLimiter == nil {
+		return next
+	}
+	return ratelimit.Middleware(h.createLimiter, ratelimit.UserKey)(next)
 }
 
 // CreateRequestPayload is the DTO for the POST /request/create endpoint.
 type CreateRequestPayload struct {
 	TwilioConversationSID string `json:"twilio_conversation_sid"`
+	RequestType           string `json:"request_type,omitempty"` // "standard" (the default) or "priority", which decides the token cost
+}
+
+// maxTwilioSIDLength is the length of a Twilio SID: a 2 letter prefix plus 32 hex characters.
+const maxTwilioSIDLength = 34
+
+// Validate checks the payload before anything is debited. It returns a message naming the bad field.
+func (p CreateRequestPayload) Validate() error {
+	sid := p.TwilioConversationSID
+	switch {
+	case sid == "":
+		return errors.New("twilio_conversation_sid is required")
+	case len(sid) > maxTwilioSIDLength:
+		return errors.New("twilio_conversation_sid is too long")
+	case !strings.HasPrefix(sid, "CH"):
+		// Twilio conversation SIDs always start with CH.
+		return errors.New("twilio_conversation_sid must start with CH")
+	}
+	return nil
 }
 
 // RateRequestPayload is the DTO for the POST /request/rate endpoint.
@@ -48,145 +131,754 @@ type RateRequestPayload struct {
 	Score     int    `json:"score"`
 }
 
+// Validate checks every field, so a client with several wrong gets told about all of them at once.
+// The error is a *jsonbody.ValidationError.
+func (p RateRequestPayload) Validate() error {
+	var v jsonbody.ValidationError
+	if _, err := uuid.Parse(p.RequestID); err != nil {
+		v.Add("request_id", "must be a uuid")
+	}
+	if _, err := uuid.Parse(p.ExpertID); err != nil {
+		v.Add("expert_id", "must be a uuid")
+	}
+	if p.Score < 1 || p.Score > 5 {
+		v.Add("score", "must be 1-5")
+	}
+	return v.Err()
+}
+
+// ReserveRequestPayload is the DTO for the POST /request/reserve endpoint.
+type ReserveRequestPayload struct {
+	RequestID  string `json:"request_id"`
+	TTLSeconds *int   `json:"ttl_seconds,omitempty"` // How long to hold it. Defaults to DefaultReservationTTL
+}
+
+// ReserveResponse says how long the expert has the request to themselves.
+type ReserveResponse struct {
+	RequestID     uuid.UUID `json:"request_id"`
+	ReservedUntil time.Time `json:"reserved_until"`
+}
+
 // AcceptRequestPayload is the DTO for the POST /request/accept endpoint.
 type AcceptRequestPayload struct {
 	RequestID string `json:"request_id"`
 }
 
+// AcceptConflictResponse is the 409 body when another expert accepted the request first.
+// ExpertID and AcceptedAt are left out if the request was resolved or reopened without an expert since.
+type AcceptConflictResponse struct {
+	Error      string     `json:"error"`
+	Status     string     `json:"status"`
+	ExpertID   *uuid.UUID `json:"expert_id,omitempty"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+}
+
 // ResolveRequestPayload is the DTO for the POST /request/resolve endpoint.
 type ResolveRequestPayload struct {
 	RequestID string `json:"request_id"`
 }
 
+// TransferRequestPayload is the JSON body for POST /request/transfer.
+type TransferRequestPayload struct {
+	RequestID      string `json:"request_id"`
+	TargetExpertID string `json:"target_expert_id"`
+}
+
+// ReopenRequestPayload is the JSON body for POST /request/reopen.
+type ReopenRequestPayload struct {
+	RequestID string `json:"request_id"`
+}
+
+// EscalateRequestPayload is the JSON body for POST /internal/request/escalate.
+type EscalateRequestPayload struct {
+	TwilioConversationSID string `json:"twilio_conversation_sid"`
+	UserID                string `json:"user_id"`
+}
+
+// FirstResponsePayload is the JSON body for POST /internal/request/first-response.
+// Author is the Twilio identity of whoever wrote the message, sent_at is an optional RFC 3339 timestamp.
+type FirstResponsePayload struct {
+	TwilioConversationSID string     `json:"twilio_conversation_sid"`
+	Author                string     `json:"author"`
+	SentAt                *time.Time `json:"sent_at,omitempty"`
+}
+
 // handleCreateRequest is the handler for the user-facing request creation endpoint.
 func (h *Handler) handleCreateRequest(w http.ResponseWriter, r *http.Request) {
-	// Need to replace this placeholder with real auth middleware
-	userID := uuid.New() // Placeholder
-	// userID, err := auth.GetUserID(r.Context())
-	// if err != nil {
-	// 	writeError(w, http.StatusUnauthorized, "Not authorized")
-	// 	return
-	// }
+	userID, err := auth.GetUserID(r.Context())
+	if err != nil {
+		httputil.WriteError(w, http.StatusUnauthorized, "Not authorized")
+		return
+	}
 
 	// Decode the incoming json payload.
 	var payload CreateRequestPayload
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid request payload")
+	if err := jsonbody.Decode(w, r, &payload); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid request payload")
+		return
+	}
+
+	// Catch a bad SID here, before a token is debited for a request that can't work.
+	if err := payload.Validate(); err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
 	// Call the core business logic in the service.
-	req, err := h.service.CreateRequest(r.Context(), userID, payload.TwilioConversationSID)
+	req, err := h.service.CreateRequest(r.Context(), userID, payload.TwilioConversationSID, payload.RequestType)
 	if err != nil {
-		// This is a specific business error.
-		if err.Error() == "token debit failed: insufficient funds" {
-			// Return 402 Payment Required.
-			writeError(w, http.StatusPaymentRequired, "Insufficient assistance tokens")
+		writeCreateError(w, err)
+		return
+	}
+
+	// Respond with the new request object.
+	httputil.WriteJSON(w, http.StatusCreated, req)
+}
+
+// handleCanCreateRequest answers whether the caller could create a request of ?request_type= right now,
+// without holding or debiting anything. A no is still a 200, with the reason in the body.
+func (h *Handler) handleCanCreateRequest(w http.ResponseWriter, r *http.Request) {
+	userID, err := auth.GetUserID(r.Context())
+	if err != nil {
+		httputil.WriteError(w, http.StatusUnauthorized, "Not authorized")
+		return
+	}
+
+	check, err := h.service.CheckCreateRequest(r.Context(), userID, r.URL.Query().Get("request_type"))
+	if err != nil {
+		if errors.Is(err, ErrUnknownRequestType) {
+			httputil.WriteError(w, http.StatusBadRequest, "Unknown request_type")
 			return
 		}
-		// Something else went wrong.
-		writeError(w, http.StatusInternalServerError, "Could not create request")
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not check request creation")
 		return
 	}
+	httputil.WriteJSON(w, http.StatusOK, check)
+}
 
-	// Respond with the new request object.
-	writeJSON(w, http.StatusCreated, req)
+// writeCreateError maps a CreateRequest error to a response. Shared by the user endpoint and the chat gateway's escalation.
+func writeCreateError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrUnknownRequestType) {
+		httputil.WriteError(w, http.StatusBadRequest, "Unknown request_type")
+		return
+	}
+	// This is a specific business error.
+	if errors.Is(err, ErrInsufficientFunds) {
+		// Return 402 Payment Required.
+		httputil.WriteError(w, http.StatusPaymentRequired, "Insufficient assistance tokens")
+		return
+	}
+	// The user already has an open request somewhere. Same as below, hand it back so the client can go to it.
+	var openErr *OpenRequestError
+	if errors.As(err, &openErr) {
+		if openErr.Existing != nil {
+			httputil.WriteJSON(w, http.StatusConflict, openErr.Existing)
+			return
+		}
+		httputil.WriteError(w, http.StatusConflict, "You already have an open request")
+		return
+	}
+	// The conversation already has an open request. Hand that one back so the client can use it.
+	var dupErr *DuplicateRequestError
+	if errors.As(err, &dupErr) {
+		if dupErr.Existing != nil {
+			httputil.WriteJSON(w, http.StatusConflict, dupErr.Existing)
+			return
+		}
+		httputil.WriteError(w, http.StatusConflict, "A request is already open for this conversation")
+		return
+	}
+	// Something else went wrong.
+	httputil.WriteError(w, http.StatusInternalServerError, "Could not create request")
+}
+
+// handleEscalateRequest creates a request on behalf of a user, for the chat gateway when it spots
+// the handoff phrase in the chat. It's the same flow as /request/create, token debit included,
+// but the user comes from the body since the gateway has no user session.
+func (h *Handler) handleEscalateRequest(w http.ResponseWriter, r *http.Request) {
+	var payload EscalateRequestPayload
+	if err := jsonbody.Decode(w, r, &payload); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid request payload")
+		return
+	}
+	userID, err := uuid.Parse(payload.UserID)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid user_id")
+		return
+	}
+	create := CreateRequestPayload{TwilioConversationSID: payload.TwilioConversationSID}
+	if err := create.Validate(); err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// The handoff phrase is always a standard request.
+	req, err := h.service.CreateRequest(r.Context(), userID, payload.TwilioConversationSID, RequestTypeStandard)
+	if err != nil {
+		writeCreateError(w, err)
+		return
+	}
+	httputil.WriteJSON(w, http.StatusCreated, req)
 }
 
 // handleRateRequest allows a user to submit a rating for a completed request.
 func (h *Handler) handleRateRequest(w http.ResponseWriter, r *http.Request) {
-	userID := uuid.New() // Placeholder
-	// userID, err := auth.GetUserID(r.Context()) ...
+	userID, err := auth.GetUserID(r.Context())
+	if err != nil {
+		httputil.WriteError(w, http.StatusUnauthorized, "Not authorized")
+		return
+	}
 
 	var payload RateRequestPayload
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid payload")
+	if err := jsonbody.Decode(w, r, &payload); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid payload")
 		return
 	}
 
+	if err := payload.Validate(); err != nil {
+		jsonbody.WriteValidationError(w, err)
+		return
+	}
+	// Validate already checked both parse.
 	reqID, _ := uuid.Parse(payload.RequestID)
 	expertID, _ := uuid.Parse(payload.ExpertID)
-	// TODO: I need to add proper error handling for bad UUIDs here.
 
-	err := h.service.SubmitRating(r.Context(), reqID, userID, expertID, payload.Score)
+	if err := h.service.SubmitRating(r.Context(), reqID, userID, expertID, payload.Score); err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not submit rating")
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, map[string]string{"status": "rating received"})
+}
+
+// handleReopenRequest lets the user reopen a request they think was resolved too soon.
+func (h *Handler) handleReopenRequest(w http.ResponseWriter, r *http.Request) {
+	// Reopening checks the request belongs to the caller.
+	userID, err := auth.GetUserID(r.Context())
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "Could not submit rating")
+		httputil.WriteError(w, http.StatusUnauthorized, "Not authorized")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]string{"status": "rating received"})
+	var payload ReopenRequestPayload
+	if err := jsonbody.Decode(w, r, &payload); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid payload")
+		return
+	}
+	reqID, err := uuid.Parse(payload.RequestID)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid request id")
+		return
+	}
+
+	req, err := h.service.ReopenRequest(r.Context(), reqID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNotFound):
+			httputil.WriteError(w, http.StatusNotFound, "Request not found")
+		case errors.Is(err, ErrReopenWindowExpired):
+			httputil.WriteError(w, http.StatusGone, "Request can no longer be reopened")
+		case errors.Is(err, ErrRequestNotResolved):
+			httputil.WriteError(w, http.StatusConflict, "Request is not resolved")
+		case errors.Is(err, ErrDuplicateRequest):
+			httputil.WriteError(w, http.StatusConflict, "Conversation already has an open request")
+		default:
+			httputil.WriteError(w, http.StatusInternalServerError, "Could not reopen request")
+		}
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, req)
 }
 
 // handleGetPendingRequests is the expert facing endpoint to fetch the queue.
 func (h *Handler) handleGetPendingRequests(w http.ResponseWriter, r *http.Request) {
-	// _ , err := auth.GetExpertID(r.Context()) ...
+	expertID, err := auth.GetExpertID(r.Context())
+	if err != nil {
+		httputil.WriteError(w, http.StatusUnauthorized, "Not authorized")
+		return
+	}
+
+	// ?balanced=true shuffles requests of about the same age, so experts looking at once don't all pick the same one.
+	opts := QueueOptions{ExpertID: expertID}
+	if v := r.URL.Query().Get("balanced"); v != "" {
+		balanced, err := strconv.ParseBool(v)
+		if err != nil {
+			httputil.WriteError(w, http.StatusBadRequest, "balanced must be true or false")
+			return
+		}
+		opts.Balanced = balanced
+	}
+
+	limit, offset, err := domain.ParsePage(r.URL.Query(), defaultQueueLimit, maxQueueLimit)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts.Limit, opts.Offset = limit, offset
 
-	requests, err := h.service.GetPendingRequests(r.Context())
+	page, err := h.service.GetPendingRequests(r.Context(), opts)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "Could not fetch pending requests")
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not fetch pending requests")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, requests)
+	// An empty queue isn't a 404, it's just nothing to do. The page's items are [] then.
+	httputil.WriteJSON(w, http.StatusOK, page)
+}
+
+// handleReserveRequest holds a pending request for the expert for a little while, hidden from everyone else,
+// so they can read it before accepting without another expert taking it from under them.
+func (h *Handler) handleReserveRequest(w http.ResponseWriter, r *http.Request) {
+	expertID, err := auth.GetExpertID(r.Context())
+	if err != nil {
+		httputil.WriteError(w, http.StatusUnauthorized, "Not authorized")
+		return
+	}
+
+	var payload ReserveRequestPayload
+	if err := jsonbody.Decode(w, r, &payload); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid payload")
+		return
+	}
+	reqID, err := uuid.Parse(payload.RequestID)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid request id")
+		return
+	}
+	var ttl time.Duration
+	if payload.TTLSeconds != nil {
+		maxSeconds := int(MaxReservationTTL / time.Second)
+		if *payload.TTLSeconds < 1 || *payload.TTLSeconds > maxSeconds {
+			httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("ttl_seconds must be between 1 and %d", maxSeconds))
+			return
+		}
+		ttl = time.Duration(*payload.TTLSeconds) * time.Second
+	}
+
+	until, err := h.service.ReserveRequest(r.Context(), reqID, expertID, ttl)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNotFound):
+			httputil.WriteError(w, http.StatusNotFound, "Request not found")
+		case errors.Is(err, ErrRequestReserved):
+			httputil.WriteError(w, http.StatusConflict, "Request is reserved by another expert")
+		case errors.Is(err, ErrRequestAlreadyAccepted):
+			httputil.WriteError(w, http.StatusConflict, "Request already accepted")
+		case errors.Is(err, ErrExpertNotActive):
+			httputil.WriteError(w, http.StatusForbidden, "Expert is not active")
+		default:
+			httputil.WriteError(w, http.StatusInternalServerError, "Could not reserve request")
+		}
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, ReserveResponse{RequestID: reqID, ReservedUntil: until})
 }
 
 // handleAcceptRequest allows an expert to accept a pending request.
 func (h *Handler) handleAcceptRequest(w http.ResponseWriter, r *http.Request) {
-	expertID := uuid.New() // Placeholder
-	// expertID, err := auth.GetExpertID(r.Context()) ...
+	expertID, err := auth.GetExpertID(r.Context())
+	if err != nil {
+		httputil.WriteError(w, http.StatusUnauthorized, "Not authorized")
+		return
+	}
 
 	var payload AcceptRequestPayload
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid payload")
+	if err := jsonbody.Decode(w, r, &payload); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid payload")
+		return
+	}
+	reqID, err := uuid.Parse(payload.RequestID)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid request id")
 		return
 	}
-	reqID, _ := uuid.Parse(payload.RequestID) // TODO: Handle parse error.
 
 	req, err := h.service.AcceptRequest(r.Context(), reqID, expertID)
 	if err != nil {
-		// Handle the specific concurrency error.
-		if err.Error() == "could not accept request: request not found or was already accepted" {
-			writeError(w, http.StatusConflict, "Request already accepted")
+		// Handle the specific concurrency error. If we know who won, tell the client.
+		var acceptedErr *AlreadyAcceptedError
+		if errors.As(err, &acceptedErr) {
+			httputil.WriteJSON(w, http.StatusConflict, newAcceptConflictResponse(acceptedErr.Current))
+			return
+		}
+		if errors.Is(err, ErrRequestAlreadyAccepted) {
+			httputil.WriteError(w, http.StatusConflict, "Request already accepted")
+			return
+		}
+		// Still pending, but another expert is holding it for now.
+		if errors.Is(err, ErrRequestReserved) {
+			httputil.WriteError(w, http.StatusConflict, "Request is reserved by another expert")
+			return
+		}
+		if errors.Is(err, ErrNotFound) {
+			httputil.WriteError(w, http.StatusNotFound, "Request not found")
+			return
+		}
+		// Deactivated experts can't take new requests.
+		if errors.Is(err, ErrExpertNotActive) {
+			httputil.WriteError(w, http.StatusForbidden, "Expert is not active")
 			return
 		}
-		writeError(w, http.StatusInternalServerError, "Could not accept request")
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not accept request")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, req)
+	httputil.WriteJSON(w, http.StatusOK, req)
+}
+
+// newAcceptConflictResponse builds the 409 body from the request as it is now.
+func newAcceptConflictResponse(current *domain.AssistanceRequest) AcceptConflictResponse {
+	resp := AcceptConflictResponse{Error: "Request already accepted", Status: current.Status}
+	if current.ExpertID.Valid {
+		resp.ExpertID = &current.ExpertID.UUID
+	}
+	if current.AcceptedAt.Valid {
+		resp.AcceptedAt = &current.AcceptedAt.Time
+	}
+	return resp
+}
+
+// handleClaimNextRequest gives the calling expert the oldest pending request, or 204 if the queue is empty.
+func (h *Handler) handleClaimNextRequest(w http.ResponseWriter, r *http.Request) {
+	expertID, err := auth.GetExpertID(r.Context())
+	if err != nil {
+		httputil.WriteError(w, http.StatusUnauthorized, "Not authorized")
+		return
+	}
+
+	req, err := h.service.ClaimNextRequest(r.Context(), expertID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrQueueEmpty):
+			w.WriteHeader(http.StatusNoContent)
+		case errors.Is(err, ErrExpertNotActive):
+			httputil.WriteError(w, http.StatusForbidden, "Expert is not active")
+		default:
+			httputil.WriteError(w, http.StatusInternalServerError, "Could not claim request")
+		}
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, req)
 }
 
 // handleResolveRequest allows an expert to mark a request as resolved.
 func (h *Handler) handleResolveRequest(w http.ResponseWriter, r *http.Request) {
-	expertID := uuid.New() // Placeholder
-	// expertID, err := auth.GetExpertID(r.Context()) ...
+	expertID, err := auth.GetExpertID(r.Context())
+	if err != nil {
+		httputil.WriteError(w, http.StatusUnauthorized, "Not authorized")
+		return
+	}
 
 	var payload ResolveRequestPayload
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid payload")
+	if err := jsonbody.Decode(w, r, &payload); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid payload")
 		return
 	}
-	reqID, _ := uuid.Parse(payload.RequestID) // TODO: Handle parse error.
+	reqID, err := uuid.Parse(payload.RequestID)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid request id")
+		return
+	}
+
+	if err := h.service.ResolveRequest(r.Context(), reqID, expertID); err != nil {
+		switch {
+		case errors.Is(err, ErrNotFound):
+			httputil.WriteError(w, http.StatusNotFound, "Request not found")
+		case errors.Is(err, ErrForbidden):
+			httputil.WriteError(w, http.StatusForbidden, "Request is assigned to another expert")
+		case errors.Is(err, ErrRequestNotActive):
+			// Still pending, or already resolved.
+			httputil.WriteError(w, http.StatusConflict, "Request is not active")
+		default:
+			httputil.WriteError(w, http.StatusInternalServerError, "Could not resolve request")
+		}
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, map[string]string{"status": "resolved"})
+}
 
-	err := h.service.ResolveRequest(r.Context(), reqID, expertID)
+// handleTransferRequest lets the assigned expert, or an admin, hand an active request to another expert.
+func (h *Handler) handleTransferRequest(w http.ResponseWriter, r *http.Request) {
+	// Who's asking matters here. Experts are checked first since this is mostly their action.
+	var caller Caller
+	if expertID, err := auth.GetExpertID(r.Context()); err == nil {
+		caller.ExpertID = expertID
+	} else if userID, err := auth.GetUserID(r.Context()); err == nil {
+		caller.UserID = userID
+	} else {
+		httputil.WriteError(w, http.StatusUnauthorized, "Not authorized")
+		return
+	}
+
+	var payload TransferRequestPayload
+	if err := jsonbody.Decode(w, r, &payload); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid payload")
+		return
+	}
+	reqID, err := uuid.Parse(payload.RequestID)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid request id")
+		return
+	}
+	targetID, err := uuid.Parse(payload.TargetExpertID)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "Could not resolve request")
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid target_expert_id")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, map[string]string{"status": "resolved"})
+	req, err := h.service.TransferRequest(r.Context(), reqID, targetID, caller)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNotFound):
+			httputil.WriteError(w, http.StatusNotFound, "Request not found")
+		case errors.Is(err, ErrForbidden):
+			httputil.WriteError(w, http.StatusForbidden, "Only the assigned expert or an admin can transfer this request")
+		case errors.Is(err, ErrRequestNotActive):
+			httputil.WriteError(w, http.StatusConflict, "Request is not active")
+		case errors.Is(err, ErrExpertNotFound), errors.Is(err, ErrExpertNotActive), errors.Is(err, ErrInvalidTransferTarget):
+			httputil.WriteError(w, http.StatusUnprocessableEntity, "Cannot transfer to that expert")
+		default:
+			httputil.WriteError(w, http.StatusInternalServerError, "Could not transfer request")
+		}
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, req)
+}
+
+// handleGetRequest returns a request with its llm_summary, for an expert deciding whether to accept it.
+func (h *Handler) handleGetRequest(w http.ResponseWriter, r *http.Request) {
+	// Experts first, like transfer. A user has to be the request's owner.
+	var caller Caller
+	if expertID, err := auth.GetExpertID(r.Context()); err == nil {
+		caller.ExpertID = expertID
+	} else if userID, err := auth.GetUserID(r.Context()); err == nil {
+		caller.UserID = userID
+	} else {
+		httputil.WriteError(w, http.StatusUnauthorized, "Not authorized")
+		return
+	}
+
+	reqID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid request id")
+		return
+	}
+
+	req, err := h.service.GetRequest(r.Context(), reqID, caller)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			httputil.WriteError(w, http.StatusNotFound, "Request not found")
+			return
+		}
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not fetch request")
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, req)
 }
 
-// writeJSON is a helper function for sending json responses.
-func writeJSON(w http.ResponseWriter, status int, data interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	if data != nil {
-		json.NewEncoder(w).Encode(data)
+// handleResummarizeRequest lets an expert refresh the summary of a request before accepting it.
+func (h *Handler) handleResummarizeRequest(w http.ResponseWriter, r *http.Request) {
+	reqID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid request id")
+		return
 	}
+
+	req, err := h.service.ResummarizeRequest(r.Context(), reqID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNotFound):
+			httputil.WriteError(w, http.StatusNotFound, "Request not found")
+		case errors.Is(err, ErrRequestNotOpen):
+			httputil.WriteError(w, http.StatusConflict, "Request is no longer pending or active")
+		default:
+			httputil.WriteError(w, http.StatusInternalServerError, "Could not resummarize request")
+		}
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, req)
 }
 
-// writeError is a helper for sending a standardized json error.
-func writeError(w http.ResponseWriter, status int, message string) {
-	writeJSON(w, status, map[string]string{"error": message})
+// handleGetRequestByConversation returns the most recent request for a Twilio conversation.
+func (h *Handler) handleGetRequestByConversation(w http.ResponseWriter, r *http.Request) {
+	req, err := h.service.GetRequestByTwilioSID(r.Context(), chi.URLParam(r, "sid"))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			httputil.WriteError(w, http.StatusNotFound, "No request for this conversation")
+			return
+		}
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not fetch request")
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, req)
+}
+
+// handleRecordFirstResponse is called by the chat gateway when a message is posted to a conversation.
+// It's safe to call for every message, only the assigned expert's first one is recorded.
+func (h *Handler) handleRecordFirstResponse(w http.ResponseWriter, r *http.Request) {
+	var payload FirstResponsePayload
+	if err := jsonbody.Decode(w, r, &payload); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid payload")
+		return
+	}
+	if payload.TwilioConversationSID == "" {
+		httputil.WriteError(w, http.StatusBadRequest, "twilio_conversation_sid is required")
+		return
+	}
+
+	// Users and experts join the chat under their UUIDs. Anything else, like the bot, can't be the expert.
+	author, err := uuid.Parse(payload.Author)
+	if err != nil {
+		httputil.WriteJSON(w, http.StatusOK, map[string]bool{"recorded": false})
+		return
+	}
+	var sentAt time.Time
+	if payload.SentAt != nil {
+		sentAt = *payload.SentAt
+	}
+
+	recorded, err := h.service.RecordFirstResponse(r.Context(), payload.TwilioConversationSID, author, sentAt)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			httputil.WriteError(w, http.StatusNotFound, "No open request for this conversation")
+			return
+		}
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not record first response")
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, map[string]bool{"recorded": recorded})
+}
+
+// defaultStatsWindow is used when the stats query leaves out from.
+const defaultStatsWindow = 30 * 24 * time.Hour
+
+// handleGetRequestStats returns request counts and latencies for dashboards.
+// from and to are RFC 3339 timestamps or plain dates. to defaults to now and from to 30 days before to.
+func (h *Handler) handleGetRequestStats(w http.ResponseWriter, r *http.Request) {
+	to := time.Now().UTC()
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, err := parseStatsTime(v)
+		if err != nil {
+			httputil.WriteError(w, http.StatusBadRequest, "Invalid 'to' date, use RFC 3339 or YYYY-MM-DD")
+			return
+		}
+		to = t
+	}
+	from := to.Add(-defaultStatsWindow)
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := parseStatsTime(v)
+		if err != nil {
+			httputil.WriteError(w, http.StatusBadRequest, "Invalid 'from' date, use RFC 3339 or YYYY-MM-DD")
+			return
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		httputil.WriteError(w, http.StatusBadRequest, "'from' must be before 'to'")
+		return
+	}
+
+	stats, err := h.service.GetRequestStats(r.Context(), from, to)
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not compute request stats")
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, stats)
+}
+
+// Page sizes for /request/admin/search and /request/pending.
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 200
+	defaultQueueLimit  = 50
+	maxQueueLimit      = 200
+)
+
+// searchStatuses are the statuses a search can filter on, so a typo is a 400 instead of an empty page.
+var searchStatuses = map[string]bool{"pending": true, "active": true, "resolved": true, "expired": true}
+
+// handleSearchRequests lets support find requests by status, user_id, expert_id and a created from/to range,
+// one page at a time with limit and offset. Superadmin only, which the service checks.
+func (h *Handler) handleSearchRequests(w http.ResponseWriter, r *http.Request) {
+	callerID, err := auth.GetUserID(r.Context())
+	if err != nil {
+		httputil.WriteError(w, http.StatusUnauthorized, "Not authorized")
+		return
+	}
+
+	filter, err := parseSearchFilter(r.URL.Query())
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	result, err := h.service.SearchRequests(r.Context(), callerID, filter)
+	if err != nil {
+		if errors.Is(err, ErrForbidden) {
+			httputil.WriteError(w, http.StatusForbidden, "Only admins can search requests")
+			return
+		}
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not search requests")
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, result)
+}
+
+// parseSearchFilter reads the search query params. Any that are left out don't filter.
+func parseSearchFilter(q url.Values) (SearchFilter, error) {
+	var filter SearchFilter
+
+	if v := q.Get("status"); v != "" {
+		if !searchStatuses[v] {
+			return filter, fmt.Errorf("invalid status %q", v)
+		}
+		filter.Status = v
+	}
+	for name, dst := range map[string]*uuid.UUID{"user_id": &filter.UserID, "expert_id": &filter.ExpertID} {
+		if v := q.Get(name); v != "" {
+			id, err := uuid.Parse(v)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s", name)
+			}
+			*dst = id
+		}
+	}
+	for name, dst := range map[string]*time.Time{"from": &filter.CreatedFrom, "to": &filter.CreatedTo} {
+		if v := q.Get(name); v != "" {
+			t, err := parseStatsTime(v)
+			if err != nil {
+				return filter, fmt.Errorf("invalid '%s' date, use RFC 3339 or YYYY-MM-DD", name)
+			}
+			*dst = t
+		}
+	}
+	if !filter.CreatedFrom.IsZero() && !filter.CreatedTo.IsZero() && !filter.CreatedFrom.Before(filter.CreatedTo) {
+		return filter, errors.New("'from' must be before 'to'")
+	}
+
+	limit, offset, err := domain.ParsePage(q, defaultSearchLimit, maxSearchLimit)
+	if err != nil {
+		return filter, err
+	}
+	filter.Limit, filter.Offset = limit, offset
+	return filter, nil
+}
+
+// parseStatsTime accepts a full RFC 3339 timestamp or a date, which means midnight UTC.
+func parseStatsTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", v)
 }
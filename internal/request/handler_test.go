@@ -0,0 +1,1258 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"project-sage/internal/auth"
+	"project-sage/internal/domain"
+	"project-sage/internal/openapi"
+	"project-sage/internal/ratelimit"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"go.uber.org/mock/gomock"
+)
+
+// setupHandlerTest initializes a router, mock service, and handler for testing. Rate limiting is off.
+func setupHandlerTest(t *testing.T) (*chi.Mux, *MockService, *gomock.Controller) {
+	ctrl := gomock.NewController(t)
+	mockService := NewMockService(ctrl)
+
+	handler := NewHandler(mockService, nil, testInternalToken)
+
+	r := chi.NewRouter()
+	handler.RegisterRoutes(r)
+
+	return r, mockService, ctrl
+}
+
+// testInternalToken is the secret the handler under test expects on the /internal/request routes.
+const testInternalToken = "internal-secret"
+
+// newInternalRequest is httptest.NewRequest from another service, with the internal token.
+func newInternalRequest(method, target string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set(auth.InternalTokenHeader, testInternalToken)
+	return req
+}
+
+// testUserID and testExpertID are who withRole signs requests in as, unless the test put an id there itself.
+var (
+	testUserID   = uuid.New()
+	testExpertID = uuid.New()
+)
+
+// withRole sets role on every request before h sees it, with the expert or user id that goes with it,
+// the way the auth middleware would.
+func withRole(role string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = auth.SetRole(r, role)
+		if role == auth.RoleExpert {
+			if _, err := auth.GetExpertID(r.Context()); err != nil {
+				r = auth.SetExpertID(r, testExpertID)
+			}
+		} else if _, err := auth.GetUserID(r.Context()); err != nil {
+			r = auth.SetUserID(r, testUserID)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// postCreate sends a create request with the given SID and returns the recorder.
+func postCreate(r http.Handler, sid string) *httptest.ResponseRecorder {
+	bodyBytes, _ := json.Marshal(CreateRequestPayload{TwilioConversationSID: sid})
+	req := httptest.NewRequest("POST", "/request/create", bytes.NewBuffer(bodyBytes))
+	rr := httptest.NewRecorder()
+	withRole(auth.RoleUser, r).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestHandleCreateRequest_Success(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	sid := "CH0123456789abcdef0123456789abcdef"
+	remaining := 4
+	mockService.EXPECT().
+		CreateRequest(gomock.Any(), testUserID, sid, "").
+		Return(&CreatedRequest{
+			AssistanceRequest: &domain.AssistanceRequest{RequestID: uuid.New(), TwilioConversationSID: sid, Status: "pending"},
+			RemainingBalance:  &remaining,
+		}, nil).
+		Times(1)
+
+	rr := postCreate(r, sid)
+
+	if rr.Code != http.StatusCreated {
+		t.Errorf("Expected status %d, got %d", http.StatusCreated, rr.Code)
+	}
+	// The request's own fields, with the balance alongside.
+	var respBody struct {
+		TwilioConversationSID string `json:"twilio_conversation_sid"`
+		RemainingBalance      *int   `json:"remaining_balance"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&respBody); err != nil {
+		t.Fatalf("Could not decode response: %v", err)
+	}
+	if respBody.TwilioConversationSID != sid || respBody.RemainingBalance == nil || *respBody.RemainingBalance != 4 {
+		t.Errorf("Expected the request with remaining_balance 4, got %+v", respBody)
+	}
+}
+
+func TestHandleCreateRequest_InvalidSID(t *testing.T) {
+	tests := []struct {
+		name    string
+		sid     string
+		wantMsg string
+	}{
+		{"empty", "", "twilio_conversation_sid is required"},
+		{"too long", "CH" + strings.Repeat("a", 40), "twilio_conversation_sid is too long"},
+		{"wrong prefix", "MG0123456789abcdef0123456789abcdef", "twilio_conversation_sid must start with CH"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, mockService, ctrl := setupHandlerTest(t)
+			defer ctrl.Finish()
+
+			// Nothing should reach the service, so no token gets debited.
+			mockService.EXPECT().CreateRequest(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+			rr := postCreate(r, tt.sid)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("Expected status %d, got %d", http.StatusBadRequest, rr.Code)
+			}
+			var respBody map[string]string
+			json.NewDecoder(rr.Body).Decode(&respBody)
+			if respBody["error"] != tt.wantMsg {
+				t.Errorf("Expected error '%s', got '%s'", tt.wantMsg, respBody["error"])
+			}
+		})
+	}
+}
+
+// TestHandleCreateRequest_RequestType checks the type is passed to the service and an unknown one is a 400.
+func TestHandleCreateRequest_RequestType(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	sid := "CH0123456789abcdef0123456789abcdef"
+	gomock.InOrder(
+		mockService.EXPECT().CreateRequest(gomock.Any(), gomock.Any(), sid, RequestTypePriority).
+			Return(&CreatedRequest{AssistanceRequest: &domain.AssistanceRequest{RequestID: uuid.New(), TwilioConversationSID: sid, Status: "pending"}}, nil),
+		mockService.EXPECT().CreateRequest(gomock.Any(), gomock.Any(), sid, "urgentest").
+			Return(nil, fmt.Errorf("%w: %q", ErrUnknownRequestType, "urgentest")),
+	)
+
+	for _, tc := range []struct {
+		requestType string
+		want        int
+	}{
+		{RequestTypePriority, http.StatusCreated},
+		{"urgentest", http.StatusBadRequest},
+	} {
+		body, _ := json.Marshal(CreateRequestPayload{TwilioConversationSID: sid, RequestType: tc.requestType})
+		rr := httptest.NewRecorder()
+		withRole(auth.RoleUser, r).ServeHTTP(rr, httptest.NewRequest("POST", "/request/create", bytes.NewBuffer(body)))
+		if rr.Code != tc.want {
+			t.Errorf("Expected status %d for %q, got %d", tc.want, tc.requestType, rr.Code)
+		}
+	}
+}
+
+func TestHandleCreateRequest_Duplicate(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	sid := "CH0123456789abcdef0123456789abcdef"
+	existing := &domain.AssistanceRequest{RequestID: uuid.New(), TwilioConversationSID: sid, Status: "pending"}
+	mockService.EXPECT().
+		CreateRequest(gomock.Any(), gomock.Any(), sid, "").
+		Return(nil, &DuplicateRequestError{Existing: existing}).
+		Times(1)
+
+	rr := postCreate(r, sid)
+
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected status %d, got %d", http.StatusConflict, rr.Code)
+	}
+	var respBody domain.AssistanceRequest
+	json.NewDecoder(rr.Body).Decode(&respBody)
+	if respBody.RequestID != existing.RequestID {
+		t.Errorf("Expected the existing request %v in the body, got %v", existing.RequestID, respBody.RequestID)
+	}
+}
+
+func TestHandleCreateRequest_BadBody(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"10MB body", `{"twilio_conversation_sid":"` + strings.Repeat("a", 10<<20) + `"}`, http.StatusRequestEntityTooLarge},
+		{"unknown field", `{"twilio_conversation_sid":"CH0123456789abcdef0123456789abcdef","priority":"high"}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, mockService, ctrl := setupHandlerTest(t)
+			defer ctrl.Finish()
+
+			mockService.EXPECT().CreateRequest(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+			rr := httptest.NewRecorder()
+			withRole(auth.RoleUser, r).ServeHTTP(rr, httptest.NewRequest("POST", "/request/create", strings.NewReader(tt.body)))
+
+			if rr.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, rr.Code)
+			}
+		})
+	}
+}
+
+func TestHandleCreateRequest_AlreadyOpen(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	existing := &domain.AssistanceRequest{RequestID: uuid.New(), TwilioConversationSID: "CHfirst", Status: "active"}
+	mockService.EXPECT().
+		CreateRequest(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(nil, &OpenRequestError{Existing: existing}).
+		Times(1)
+
+	rr := postCreate(r, "CH0123456789abcdef0123456789abcdef")
+
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected status %d, got %d", http.StatusConflict, rr.Code)
+	}
+	var respBody domain.AssistanceRequest
+	json.NewDecoder(rr.Body).Decode(&respBody)
+	if respBody.RequestID != existing.RequestID {
+		t.Errorf("Expected the open request %v in the body, got %v", existing.RequestID, respBody.RequestID)
+	}
+}
+
+// identityStub resolves Firebase ids from a map, standing in for the UserService behind auth.Middleware.
+type identityStub map[string]*auth.Identity
+
+func (m identityStub) ResolveIdentity(ctx context.Context, firebaseID string) (*auth.Identity, error) {
+	if id, ok := m[firebaseID]; ok {
+		return id, nil
+	}
+	return nil, auth.ErrUnknownIdentity
+}
+
+// TestHandleCreateRequest_RateLimitPerUser runs create through auth.Middleware like main.go does, with every call
+// from the gateway's address, and checks one user going over the limit doesn't hold up another.
+func TestHandleCreateRequest_RateLimitPerUser(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockService := NewMockService(ctrl)
+
+	alice, bob := uuid.New(), uuid.New()
+	r := chi.NewRouter()
+	r.Use(auth.Middleware(identityStub{
+		"fb-alice": {UserID: alice, Role: auth.RoleUser},
+		"fb-bob":   {UserID: bob, Role: auth.RoleUser},
+	}))
+	NewHandler(mockService, ratelimit.NewMemoryStore(ratelimit.Config{Rate: 0.01, Burst: 1}), testInternalToken).RegisterRoutes(r)
+
+	created := &CreatedRequest{AssistanceRequest: &domain.AssistanceRequest{RequestID: uuid.New(), Status: "pending"}}
+	mockService.EXPECT().CreateRequest(gomock.Any(), alice, gomock.Any(), gomock.Any()).Return(created, nil).Times(1)
+	mockService.EXPECT().CreateRequest(gomock.Any(), bob, gomock.Any(), gomock.Any()).Return(created, nil).Times(1)
+
+	post := func(firebaseID string) int {
+		bodyBytes, _ := json.Marshal(CreateRequestPayload{TwilioConversationSID: "CH0123456789abcdef0123456789abcdef"})
+		req := httptest.NewRequest("POST", "/request/create", bytes.NewBuffer(bodyBytes))
+		req.RemoteAddr = "10.0.0.1:4000" // The gateway, for everyone
+		req.Header.Set(auth.FirebaseIDHeader, firebaseID)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	for i, tt := range []struct {
+		firebaseID string
+		want       int
+	}{
+		{"fb-alice", http.StatusCreated},
+		{"fb-alice", http.StatusTooManyRequests},
+		{"fb-bob", http.StatusCreated},
+	} {
+		if got := post(tt.firebaseID); got != tt.want {
+			t.Errorf("Call %d as %s: expected status %d, got %d", i+1, tt.firebaseID, tt.want, got)
+		}
+	}
+}
+
+// getCanCreate calls the can-create endpoint as the given user. A nil user sends no auth at all.
+func getCanCreate(r http.Handler, userID *uuid.UUID, requestType string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/request/can-create?request_type="+requestType, nil)
+	if userID != nil {
+		req = auth.SetUserID(req, *userID)
+	}
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+// TestHandleCanCreateRequest_InsufficientBalance checks a user who can't afford the request gets a 200 saying no,
+// with the reason, the cost and their balance, rather than an error.
+func TestHandleCanCreateRequest_InsufficientBalance(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	userID := uuid.New()
+	balance := 1
+	mockService.EXPECT().
+		CheckCreateRequest(gomock.Any(), userID, RequestTypePriority).
+		Return(&CreateCheck{Reason: CreateDeniedInsufficientTokens, TokenCost: 3, Balance: &balance}, nil).
+		Times(1)
+
+	rr := getCanCreate(r, &userID, RequestTypePriority)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	var respBody map[string]any
+	if err := json.NewDecoder(rr.Body).Decode(&respBody); err != nil {
+		t.Fatalf("Could not decode response: %v", err)
+	}
+	if respBody["allowed"] != false || respBody["reason"] != "insufficient_tokens" {
+		t.Errorf("Expected allowed false for insufficient_tokens, got %v", respBody)
+	}
+	if respBody["token_cost"] != float64(3) || respBody["balance"] != float64(1) {
+		t.Errorf("Expected token_cost 3 and balance 1, got %v", respBody)
+	}
+}
+
+func TestHandleCanCreateRequest_Errors(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	userID := uuid.New()
+	gomock.InOrder(
+		mockService.EXPECT().CheckCreateRequest(gomock.Any(), userID, "bogus").Return(nil, ErrUnknownRequestType),
+		mockService.EXPECT().CheckCreateRequest(gomock.Any(), userID, "").Return(nil, errors.New("user service down")),
+	)
+
+	if rr := getCanCreate(r, &userID, "bogus"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown type, got %d", rr.Code)
+	}
+	if rr := getCanCreate(r, &userID, ""); rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status 500 when the check fails, got %d", rr.Code)
+	}
+	// No auth never reaches the service.
+	if rr := getCanCreate(r, nil, ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without a user, got %d", rr.Code)
+	}
+}
+
+// TestHandleRateRequest_Validation checks a bad rating is a 400 naming each bad field, and never reaches the service.
+func TestHandleRateRequest_Validation(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	mockService.EXPECT().SubmitRating(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	tests := []struct {
+		name       string
+		payload    RateRequestPayload
+		wantFields map[string]string
+	}{
+		{"score too high", RateRequestPayload{RequestID: uuid.NewString(), ExpertID: uuid.NewString(), Score: 6}, map[string]string{"score": "must be 1-5"}},
+		{"score missing", RateRequestPayload{RequestID: uuid.NewString(), ExpertID: uuid.NewString()}, map[string]string{"score": "must be 1-5"}},
+		{"everything wrong", RateRequestPayload{RequestID: "nope", ExpertID: "", Score: -1}, map[string]string{
+			"request_id": "must be a uuid",
+			"expert_id":  "must be a uuid",
+			"score":      "must be 1-5",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bodyBytes, _ := json.Marshal(tt.payload)
+			rr := httptest.NewRecorder()
+			withRole(auth.RoleUser, r).ServeHTTP(rr, httptest.NewRequest("POST", "/request/rate", bytes.NewBuffer(bodyBytes)))
+
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("Expected status 400, got %d", rr.Code)
+			}
+			var body struct {
+				Error  string            `json:"error"`
+				Fields map[string]string `json:"fields"`
+			}
+			json.NewDecoder(rr.Body).Decode(&body)
+			if body.Error != "validation failed" || !reflect.DeepEqual(body.Fields, tt.wantFields) {
+				t.Errorf("Expected validation failed with %v, got %q with %v", tt.wantFields, body.Error, body.Fields)
+			}
+		})
+	}
+}
+
+// getExport calls the export endpoint as the given user. A nil user sends no auth at all.
+func getExport(r http.Handler, userID *uuid.UUID, format string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/request/export?format="+format, nil)
+	if userID != nil {
+		req = auth.SetUserID(req, *userID)
+	}
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+// exportRows returns a resolved+rated request and a pending one, like a real history would have.
+func exportRows() []*RequestExportRow {
+	created := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	accepted := created.Add(5 * time.Minute)
+	resolved := created.Add(30 * time.Minute)
+	name := "Expert Joe"
+	rating := 5
+	return []*RequestExportRow{
+		{RequestID: uuid.New(), Status: "resolved", CreatedAt: created, AcceptedAt: &accepted, ResolvedAt: &resolved, ExpertDisplayName: &name, Rating: &rating},
+		{RequestID: uuid.New(), Status: "pending", CreatedAt: created.Add(time.Hour)},
+	}
+}
+
+// expectExport makes the mock service feed rows to the handler's callback.
+func expectExport(mockService *MockService, userID uuid.UUID, rows []*RequestExportRow) {
+	mockService.EXPECT().
+		ExportRequests(gomock.Any(), userID, gomock.Any()).
+		DoAndReturn(func(ctx context.Context, id uuid.UUID, fn func(*RequestExportRow) error) error {
+			for _, row := range rows {
+				if err := fn(row); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+}
+
+func TestHandleExportRequests_CSV(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	userID := uuid.New()
+	rows := exportRows()
+	expectExport(mockService, userID, rows)
+
+	rr := getExport(r, &userID, "csv")
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	if cd := rr.Header().Get("Content-Disposition"); !strings.Contains(cd, "attachment") {
+		t.Errorf("Expected an attachment Content-Disposition, got %q", cd)
+	}
+
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Could not parse CSV: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Expected header plus 2 rows, got %d lines", len(records))
+	}
+	if records[0][0] != "request_id" {
+		t.Errorf("Expected header row first, got %v", records[0])
+	}
+	if records[1][5] != "Expert Joe" || records[1][6] != "5" {
+		t.Errorf("Unexpected rated row: %v", records[1])
+	}
+	// The pending request has no expert or rating, so those cells are empty.
+	if records[2][1] != "pending" || records[2][5] != "" || records[2][6] != "" {
+		t.Errorf("Unexpected pending row: %v", records[2])
+	}
+}
+
+func TestHandleExportRequests_JSON(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	userID := uuid.New()
+	rows := exportRows()
+	expectExport(mockService, userID, rows)
+
+	rr := getExport(r, &userID, "json")
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	var got []RequestExportRow
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Could not parse JSON: %v", err)
+	}
+	if len(got) != 2 || got[0].RequestID != rows[0].RequestID || got[1].Rating != nil {
+		t.Errorf("Unexpected export: %+v", got)
+	}
+}
+
+func TestHandleExportRequests_Empty(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	userID := uuid.New()
+	expectExport(mockService, userID, nil)
+
+	rr := getExport(r, &userID, "json")
+
+	// No requests is still a valid, empty array.
+	if strings.TrimSpace(rr.Body.String()) != "[]" {
+		t.Errorf("Expected an empty array, got %q", rr.Body.String())
+	}
+}
+
+func TestHandleExportRequests_Errors(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	userID := uuid.New()
+
+	// No user in the context.
+	if rr := getExport(r, nil, "csv"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without auth, got %d", rr.Code)
+	}
+
+	// Unknown format.
+	if rr := getExport(r, &userID, "xml"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a bad format, got %d", rr.Code)
+	}
+
+	// The query fails before any row is written, so it can still be a clean 500.
+	mockService.EXPECT().
+		ExportRequests(gomock.Any(), userID, gomock.Any()).
+		Return(errors.New("db down"))
+	rr := getExport(r, &userID, "csv")
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500, got %d", rr.Code)
+	}
+	if rr.Header().Get("Content-Disposition") != "" {
+		t.Error("Expected no attachment header on an error response")
+	}
+}
+
+func TestHandleGetRequestStats_Success(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+	mockService.EXPECT().
+		GetRequestStats(gomock.Any(), from, to).
+		Return(&RequestStats{From: from, To: to, TotalCreated: 4}, nil)
+
+	req := httptest.NewRequest("GET", "/request/admin/stats?from=2024-05-01&to=2024-05-02T12:00:00Z", nil)
+	rr := httptest.NewRecorder()
+	withRole(auth.RoleSuperadmin, r).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	var stats RequestStats
+	json.Unmarshal(rr.Body.Bytes(), &stats)
+	if stats.TotalCreated != 4 {
+		t.Errorf("Expected total_created 4, got %d", stats.TotalCreated)
+	}
+}
+
+func TestHandleGetRequestStats_BadDates(t *testing.T) {
+	r, _, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	// None of these should reach the service.
+	for _, query := range []string{
+		"from=yesterday",
+		"to=2024-13-01",
+		"from=2024-05-02&to=2024-05-01",
+	} {
+		req := httptest.NewRequest("GET", "/request/admin/stats?"+query, nil)
+		rr := httptest.NewRecorder()
+		withRole(auth.RoleSuperadmin, r).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, rr.Code)
+		}
+	}
+}
+
+// getSearch calls the admin search as the given user with the given role. A nil user sends no auth at all.
+func getSearch(r http.Handler, userID *uuid.UUID, role, query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/request/admin/search?"+query, nil)
+	if userID != nil {
+		req = auth.SetRole(auth.SetUserID(req, *userID), role)
+	}
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+// TestHandleSearchRequests checks the query params become the filter, and the auth and forbidden cases.
+func TestHandleSearchRequests(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	adminID := uuid.New()
+	userID := uuid.New()
+	expertID := uuid.New()
+	want := SearchFilter{
+		Status:      "resolved",
+		UserID:      userID,
+		ExpertID:    expertID,
+		CreatedFrom: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		CreatedTo:   time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+		Limit:       10,
+		Offset:      20,
+	}
+	next := 30
+	gomock.InOrder(
+		mockService.EXPECT().SearchRequests(gomock.Any(), adminID, want).
+			Return(&SearchResult{Requests: []*domain.AssistanceRequest{{RequestID: uuid.New()}}, Limit: 10, Offset: 20, NextOffset: &next}, nil),
+		// No params is every request, first page.
+		mockService.EXPECT().SearchRequests(gomock.Any(), adminID, SearchFilter{Limit: defaultSearchLimit}).
+			Return(&SearchResult{Limit: defaultSearchLimit}, nil),
+		// The role in the token can be stale, so the service checks again.
+		mockService.EXPECT().SearchRequests(gomock.Any(), userID, gomock.Any()).Return(nil, ErrForbidden),
+	)
+
+	query := "status=resolved&user_id=" + userID.String() + "&expert_id=" + expertID.String() + "&from=2024-05-01&to=2024-06-01&limit=10&offset=20"
+	rr := getSearch(r, &adminID, auth.RoleSuperadmin, query)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	var result SearchResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil || len(result.Requests) != 1 || result.NextOffset == nil || *result.NextOffset != 30 {
+		t.Errorf("Unexpected search result %+v (%v)", result, err)
+	}
+
+	if rr := getSearch(r, &adminID, auth.RoleSuperadmin, ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 with no filters, got %d", rr.Code)
+	}
+	if rr := getSearch(r, &userID, auth.RoleSuperadmin, "status=pending"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 when the service says no, got %d", rr.Code)
+	}
+	if rr := getSearch(r, &userID, auth.RoleUser, "status=pending"); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a non-admin, got %d", rr.Code)
+	}
+	if rr := getSearch(r, nil, "", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without auth, got %d", rr.Code)
+	}
+
+	// None of these should reach the service.
+	for _, bad := range []string{
+		"status=done",
+		"user_id=nope",
+		"expert_id=123",
+		"from=yesterday",
+		"from=2024-06-01&to=2024-05-01",
+		"limit=0",
+		"limit=201",
+		"offset=-1",
+	} {
+		if rr := getSearch(r, &adminID, auth.RoleSuperadmin, bad); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", bad, rr.Code)
+		}
+	}
+}
+
+func TestHandleGetRequestByConversation(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	existing := &domain.AssistanceRequest{RequestID: uuid.New(), TwilioConversationSID: "CH123", Status: "active"}
+	mockService.EXPECT().GetRequestByTwilioSID(gomock.Any(), "CH123").Return(existing, nil)
+	mockService.EXPECT().GetRequestByTwilioSID(gomock.Any(), "CH404").Return(nil, ErrNotFound)
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, newInternalRequest("GET", "/internal/request/by-conversation/CH123", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	var got domain.AssistanceRequest
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if got.RequestID != existing.RequestID {
+		t.Errorf("Expected request %s, got %s", existing.RequestID, got.RequestID)
+	}
+
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, newInternalRequest("GET", "/internal/request/by-conversation/CH404", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", rr.Code)
+	}
+}
+
+func TestHandleClaimNextRequest(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	claimed := &domain.AssistanceRequest{RequestID: uuid.New(), Status: "active"}
+	expert := withRole(auth.RoleExpert, r)
+	gomock.InOrder(
+		mockService.EXPECT().ClaimNextRequest(gomock.Any(), testExpertID).Return(claimed, nil),
+		mockService.EXPECT().ClaimNextRequest(gomock.Any(), gomock.Any()).Return(nil, fmt.Errorf("could not claim request: %w", ErrQueueEmpty)),
+	)
+
+	rr := httptest.NewRecorder()
+	expert.ServeHTTP(rr, httptest.NewRequest("POST", "/request/claim-next", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", rr.Code)
+	}
+
+	// Nothing left in the queue.
+	rr = httptest.NewRecorder()
+	expert.ServeHTTP(rr, httptest.NewRequest("POST", "/request/claim-next", nil))
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", rr.Code)
+	}
+}
+
+func TestHandleGetPendingRequests(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	plain := gomock.Cond(func(opts QueueOptions) bool {
+		return !opts.Balanced && opts.ExpertID != uuid.Nil && opts.Limit == defaultQueueLimit && opts.Offset == 0
+	})
+	balanced := gomock.Cond(func(opts QueueOptions) bool { return opts.Balanced && opts.Limit == 1 && opts.Offset == 3 })
+	queued := &QueuedRequest{AssistanceRequest: &domain.AssistanceRequest{RequestID: uuid.New(), Status: "pending"}, Priority: 42}
+	expert := withRole(auth.RoleExpert, r)
+	gomock.InOrder(
+		mockService.EXPECT().GetPendingRequests(gomock.Any(), plain).Return(domain.Paginate[*QueuedRequest](nil, defaultQueueLimit, 0), nil),
+		mockService.EXPECT().GetPendingRequests(gomock.Any(), plain).Return(nil, errors.New("db down")),
+		mockService.EXPECT().GetPendingRequests(gomock.Any(), balanced).Return(domain.NewPage([]*QueuedRequest{queued}, 5, 1, 3), nil),
+	)
+
+	// An empty queue is a 200 with an empty page, not a 404.
+	rr := httptest.NewRecorder()
+	expert.ServeHTTP(rr, httptest.NewRequest("GET", "/request/pending", nil))
+	if want := `{"items":[],"total":0,"limit":50,"offset":0}`; rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != want {
+		t.Errorf("Expected status 200 with %s, got %d %q", want, rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	expert.ServeHTTP(rr, httptest.NewRequest("GET", "/request/pending", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status 500, got %d", rr.Code)
+	}
+
+	// The balanced view has the request's own fields and the priority side by side, in the page envelope.
+	rr = httptest.NewRecorder()
+	expert.ServeHTTP(rr, httptest.NewRequest("GET", "/request/pending?balanced=true&limit=1&offset=3", nil))
+	var body struct {
+		Items      []map[string]any `json:"items"`
+		Total      int              `json:"total"`
+		Limit      int              `json:"limit"`
+		Offset     int              `json:"offset"`
+		NextOffset *int             `json:"next_offset"`
+	}
+	json.NewDecoder(rr.Body).Decode(&body)
+	if rr.Code != http.StatusOK || len(body.Items) != 1 || body.Items[0]["request_id"] != queued.RequestID.String() || body.Items[0]["priority"] != 42.0 {
+		t.Errorf("Expected status 200 with the queued request, got %d %v", rr.Code, body)
+	}
+	if body.Total != 5 || body.Limit != 1 || body.Offset != 3 || body.NextOffset == nil || *body.NextOffset != 4 {
+		t.Errorf("Expected the 4th of 5 with next_offset 4, got %+v", body)
+	}
+
+	for _, query := range []string{"balanced=maybe", "limit=0", "limit=201", "offset=-1"} {
+		rr = httptest.NewRecorder()
+		expert.ServeHTTP(rr, httptest.NewRequest("GET", "/request/pending?"+query, nil))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", query, rr.Code)
+		}
+	}
+}
+
+// TestRoleProtectedRoutes checks the queue is for experts and the admin routes for superadmins, before
+// anything reaches the service.
+func TestRoleProtectedRoutes(t *testing.T) {
+	r, _, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	for _, tc := range []struct {
+		method, path, role string
+		want               int
+	}{
+		{"GET", "/request/pending", auth.RoleUser, http.StatusForbidden},
+		{"POST", "/request/accept", auth.RoleSuperadmin, http.StatusForbidden},
+		{"POST", "/request/claim-next", "", http.StatusUnauthorized},
+		{"POST", "/request/create", "", http.StatusUnauthorized},
+		{"GET", "/request/admin/search", auth.RoleExpert, http.StatusForbidden},
+		{"GET", "/request/admin/stats", auth.RoleUser, http.StatusForbidden},
+	} {
+		var h http.Handler = r
+		if tc.role != "" {
+			h = withRole(tc.role, r)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, nil))
+		if rr.Code != tc.want {
+			t.Errorf("%s %s as %q: expected status %d, got %d", tc.method, tc.path, tc.role, tc.want, rr.Code)
+		}
+	}
+}
+
+// TestHandleResummarizeRequest_Roles checks experts and superadmins can refresh a summary and users can't.
+func TestHandleResummarizeRequest_Roles(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	reqID := uuid.New()
+	mockService.EXPECT().ResummarizeRequest(gomock.Any(), reqID).
+		Return(&domain.AssistanceRequest{RequestID: reqID, Status: "active", LLMSummary: "Fresh summary."}, nil).Times(2)
+
+	for _, tc := range []struct {
+		role string
+		want int
+	}{
+		{auth.RoleExpert, http.StatusOK},
+		{auth.RoleSuperadmin, http.StatusOK},
+		{auth.RoleUser, http.StatusForbidden},
+	} {
+		rr := httptest.NewRecorder()
+		withRole(tc.role, r).ServeHTTP(rr, httptest.NewRequest("POST", "/request/"+reqID.String()+"/resummarize", nil))
+		if rr.Code != tc.want {
+			t.Errorf("As %q: expected status %d, got %d", tc.role, tc.want, rr.Code)
+		}
+	}
+}
+
+func TestHandleReserveRequest(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	reqID := uuid.New()
+	until := time.Date(2024, 5, 1, 12, 1, 0, 0, time.UTC)
+	gomock.InOrder(
+		mockService.EXPECT().ReserveRequest(gomock.Any(), reqID, gomock.Any(), time.Duration(0)).Return(until, nil),
+		mockService.EXPECT().ReserveRequest(gomock.Any(), reqID, gomock.Any(), 90*time.Second).Return(until, nil),
+		mockService.EXPECT().ReserveRequest(gomock.Any(), reqID, gomock.Any(), time.Duration(0)).Return(time.Time{}, ErrNotFound),
+		mockService.EXPECT().ReserveRequest(gomock.Any(), reqID, gomock.Any(), time.Duration(0)).Return(time.Time{}, ErrRequestReserved),
+		mockService.EXPECT().ReserveRequest(gomock.Any(), reqID, gomock.Any(), time.Duration(0)).Return(time.Time{}, &AlreadyAcceptedError{}),
+		mockService.EXPECT().ReserveRequest(gomock.Any(), reqID, gomock.Any(), time.Duration(0)).Return(time.Time{}, ErrExpertNotActive),
+		mockService.EXPECT().ReserveRequest(gomock.Any(), reqID, gomock.Any(), time.Duration(0)).Return(time.Time{}, errors.New("db down")),
+	)
+
+	rr := postRequestID(r, "/request/reserve", reqID.String())
+	var body ReserveResponse
+	json.NewDecoder(rr.Body).Decode(&body)
+	if rr.Code != http.StatusOK || body.RequestID != reqID || !body.ReservedUntil.Equal(until) {
+		t.Errorf("Expected status 200 with the reservation, got %d %+v", rr.Code, body)
+	}
+
+	reserve := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		withRole(auth.RoleExpert, r).ServeHTTP(rr, httptest.NewRequest("POST", "/request/reserve", bytes.NewBufferString(body)))
+		return rr
+	}
+	if rr := reserve(`{"request_id":"` + reqID.String() + `","ttl_seconds":90}`); rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 with a ttl, got %d", rr.Code)
+	}
+
+	for _, want := range []int{http.StatusNotFound, http.StatusConflict, http.StatusConflict, http.StatusForbidden, http.StatusInternalServerError} {
+		if rr := postRequestID(r, "/request/reserve", reqID.String()); rr.Code != want {
+			t.Errorf("Expected status %d, got %d", want, rr.Code)
+		}
+	}
+
+	// None of these reach the service.
+	for _, body := range []string{
+		`{"request_id":"not-a-uuid"}`,
+		`{"request_id":"` + reqID.String() + `","ttl_seconds":0}`,
+		`{"request_id":"` + reqID.String() + `","ttl_seconds":301}`,
+	} {
+		if rr := reserve(body); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", body, rr.Code)
+		}
+	}
+}
+
+// postRequestID posts {"request_id": ...} to path as an expert.
+func postRequestID(r http.Handler, path, requestID string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	withRole(auth.RoleExpert, r).ServeHTTP(rr, httptest.NewRequest("POST", path, bytes.NewBufferString(`{"request_id":"`+requestID+`"}`)))
+	return rr
+}
+
+func TestHandleAcceptRequest_Statuses(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	reqID := uuid.New()
+	current := &domain.AssistanceRequest{RequestID: reqID, Status: "active"}
+	gomock.InOrder(
+		mockService.EXPECT().AcceptRequest(gomock.Any(), reqID, gomock.Any()).Return(current, nil),
+		mockService.EXPECT().AcceptRequest(gomock.Any(), reqID, gomock.Any()).Return(nil, ErrNotFound),
+		mockService.EXPECT().AcceptRequest(gomock.Any(), reqID, gomock.Any()).Return(nil, &AlreadyAcceptedError{Current: current}),
+		mockService.EXPECT().AcceptRequest(gomock.Any(), reqID, gomock.Any()).Return(nil, ErrRequestReserved),
+		mockService.EXPECT().AcceptRequest(gomock.Any(), reqID, gomock.Any()).Return(nil, errors.New("db down")),
+	)
+
+	for _, want := range []int{http.StatusOK, http.StatusNotFound, http.StatusConflict, http.StatusConflict, http.StatusInternalServerError} {
+		if rr := postRequestID(r, "/request/accept", reqID.String()); rr.Code != want {
+			t.Errorf("Expected status %d, got %d", want, rr.Code)
+		}
+	}
+
+	// A bad id never reaches the service.
+	if rr := postRequestID(r, "/request/accept", "not-a-uuid"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a bad id, got %d", rr.Code)
+	}
+}
+
+func TestHandleResolveRequest(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	reqID := uuid.New()
+	gomock.InOrder(
+		mockService.EXPECT().ResolveRequest(gomock.Any(), reqID, gomock.Any()).Return(nil),
+		mockService.EXPECT().ResolveRequest(gomock.Any(), reqID, gomock.Any()).Return(ErrNotFound),
+		mockService.EXPECT().ResolveRequest(gomock.Any(), reqID, gomock.Any()).Return(ErrRequestNotActive),
+		mockService.EXPECT().ResolveRequest(gomock.Any(), reqID, gomock.Any()).Return(ErrForbidden),
+		mockService.EXPECT().ResolveRequest(gomock.Any(), reqID, gomock.Any()).Return(errors.New("db down")),
+	)
+
+	for _, want := range []int{http.StatusOK, http.StatusNotFound, http.StatusConflict, http.StatusForbidden, http.StatusInternalServerError} {
+		if rr := postRequestID(r, "/request/resolve", reqID.String()); rr.Code != want {
+			t.Errorf("Expected status %d, got %d", want, rr.Code)
+		}
+	}
+
+	// A bad id never reaches the service.
+	if rr := postRequestID(r, "/request/resolve", "not-a-uuid"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a bad id, got %d", rr.Code)
+	}
+}
+
+// postReopen calls the reopen endpoint as the given user. A nil user sends no auth at all.
+func postReopen(r http.Handler, userID *uuid.UUID, requestID string) *httptest.ResponseRecorder {
+	bodyBytes, _ := json.Marshal(ReopenRequestPayload{RequestID: requestID})
+	req := httptest.NewRequest("POST", "/request/reopen", bytes.NewBuffer(bodyBytes))
+	if userID != nil {
+		req = auth.SetUserID(req, *userID)
+	}
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestHandleReopenRequest(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	userID := uuid.New()
+	reqID := uuid.New()
+	reopened := &domain.AssistanceRequest{RequestID: reqID, UserID: userID, Status: "active"}
+
+	gomock.InOrder(
+		mockService.EXPECT().ReopenRequest(gomock.Any(), reqID, userID).Return(reopened, nil),
+		mockService.EXPECT().ReopenRequest(gomock.Any(), reqID, userID).Return(nil, ErrReopenWindowExpired),
+		mockService.EXPECT().ReopenRequest(gomock.Any(), reqID, userID).Return(nil, ErrRequestNotResolved),
+		mockService.EXPECT().ReopenRequest(gomock.Any(), reqID, userID).Return(nil, fmt.Errorf("could not get request: %w", ErrNotFound)),
+	)
+
+	for _, want := range []int{http.StatusOK, http.StatusGone, http.StatusConflict, http.StatusNotFound} {
+		if rr := postReopen(r, &userID, reqID.String()); rr.Code != want {
+			t.Errorf("Expected status %d, got %d", want, rr.Code)
+		}
+	}
+
+	// No auth and bad ids never reach the service.
+	if rr := postReopen(r, nil, reqID.String()); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without a user, got %d", rr.Code)
+	}
+	if rr := postReopen(r, &userID, "not-a-uuid"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a bad id, got %d", rr.Code)
+	}
+}
+
+// postTransfer calls the transfer endpoint as the given expert. A nil expert sends no auth at all.
+func postTransfer(r http.Handler, expertID *uuid.UUID, requestID, targetID string) *httptest.ResponseRecorder {
+	bodyBytes, _ := json.Marshal(TransferRequestPayload{RequestID: requestID, TargetExpertID: targetID})
+	req := httptest.NewRequest("POST", "/request/transfer", bytes.NewBuffer(bodyBytes))
+	if expertID != nil {
+		req = auth.SetExpertID(req, *expertID)
+	}
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestHandleTransferRequest(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	expertID := uuid.New()
+	reqID := uuid.New()
+	targetID := uuid.New()
+	caller := Caller{ExpertID: expertID}
+	transferred := &domain.AssistanceRequest{RequestID: reqID, Status: "active", ExpertID: uuid.NullUUID{UUID: targetID, Valid: true}}
+
+	gomock.InOrder(
+		mockService.EXPECT().TransferRequest(gomock.Any(), reqID, targetID, caller).Return(transferred, nil),
+		mockService.EXPECT().TransferRequest(gomock.Any(), reqID, targetID, caller).Return(nil, ErrForbidden),
+		mockService.EXPECT().TransferRequest(gomock.Any(), reqID, targetID, caller).Return(nil, ErrRequestNotActive),
+		mockService.EXPECT().TransferRequest(gomock.Any(), reqID, targetID, caller).Return(nil, ErrExpertNotActive),
+		mockService.EXPECT().TransferRequest(gomock.Any(), reqID, targetID, caller).Return(nil, fmt.Errorf("could not fetch expert profile: %w", ErrExpertNotFound)),
+	)
+
+	for _, want := range []int{http.StatusOK, http.StatusForbidden, http.StatusConflict, http.StatusUnprocessableEntity, http.StatusUnprocessableEntity} {
+		if rr := postTransfer(r, &expertID, reqID.String(), targetID.String()); rr.Code != want {
+			t.Errorf("Expected status %d, got %d", want, rr.Code)
+		}
+	}
+
+	// No auth and bad ids never reach the service.
+	if rr := postTransfer(r, nil, reqID.String(), targetID.String()); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without a caller, got %d", rr.Code)
+	}
+	if rr := postTransfer(r, &expertID, reqID.String(), "not-a-uuid"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a bad target id, got %d", rr.Code)
+	}
+}
+
+// postFirstResponse sends a first-response report for the conversation and author.
+func postFirstResponse(r http.Handler, sid, author string) *httptest.ResponseRecorder {
+	bodyBytes, _ := json.Marshal(FirstResponsePayload{TwilioConversationSID: sid, Author: author})
+	req := newInternalRequest("POST", "/internal/request/first-response", bytes.NewBuffer(bodyBytes))
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestHandleRecordFirstResponse(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	sid := "CH0123456789abcdef0123456789abcdef"
+	expertID := uuid.New()
+
+	gomock.InOrder(
+		mockService.EXPECT().RecordFirstResponse(gomock.Any(), sid, expertID, time.Time{}).Return(true, nil),
+		mockService.EXPECT().RecordFirstResponse(gomock.Any(), sid, expertID, time.Time{}).Return(false, ErrNotFound),
+	)
+
+	rr := postFirstResponse(r, sid, expertID.String())
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"recorded":true`) {
+		t.Errorf("Expected 200 with recorded=true, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := postFirstResponse(r, sid, expertID.String()); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 without an open request, got %d", rr.Code)
+	}
+
+	// The bot's identity isn't a UUID, so it's answered without asking the service.
+	rr = postFirstResponse(r, sid, "LLM_BOT_IDENTITY")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"recorded":false`) {
+		t.Errorf("Expected 200 with recorded=false for the bot, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := postFirstResponse(r, "", expertID.String()); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without a SID, got %d", rr.Code)
+	}
+}
+
+func TestHandleEscalateRequest(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	sid := "CH0123456789abcdef0123456789abcdef"
+	userID := uuid.New()
+	existing := &domain.AssistanceRequest{RequestID: uuid.New(), TwilioConversationSID: sid, Status: "pending"}
+
+	gomock.InOrder(
+		mockService.EXPECT().CreateRequest(gomock.Any(), userID, sid, RequestTypeStandard).Return(&CreatedRequest{AssistanceRequest: existing}, nil),
+		mockService.EXPECT().CreateRequest(gomock.Any(), userID, sid, RequestTypeStandard).Return(nil, &DuplicateRequestError{Existing: existing}),
+	)
+
+	post := func(body EscalateRequestPayload) *httptest.ResponseRecorder {
+		bodyBytes, _ := json.Marshal(body)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, newInternalRequest("POST", "/internal/request/escalate", bytes.NewBuffer(bodyBytes)))
+		return rr
+	}
+
+	// The user comes from the body, not a placeholder.
+	if rr := post(EscalateRequestPayload{TwilioConversationSID: sid, UserID: userID.String()}); rr.Code != http.StatusCreated {
+		t.Errorf("Expected status 201, got %d", rr.Code)
+	}
+	if rr := post(EscalateRequestPayload{TwilioConversationSID: sid, UserID: userID.String()}); rr.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for a duplicate, got %d", rr.Code)
+	}
+	if rr := post(EscalateRequestPayload{TwilioConversationSID: sid, UserID: "nobody"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a bad user_id, got %d", rr.Code)
+	}
+}
+
+// TestHandleInternalRoutes_RequireToken checks the internal routes turn away calls without the right token,
+// before the service is asked anything.
+func TestHandleInternalRoutes_RequireToken(t *testing.T) {
+	r, _, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	routes := []struct{ method, path string }{
+		{"GET", "/internal/request/by-conversation/CH123"},
+		{"POST", "/internal/request/first-response"},
+		{"POST", "/internal/request/escalate"},
+	}
+	for _, rt := range routes {
+		for _, token := range []string{"", "wrong"} {
+			req := httptest.NewRequest(rt.method, rt.path, strings.NewReader(`{}`))
+			if token != "" {
+				req.Header.Set(auth.InternalTokenHeader, token)
+			}
+			rr := httptest.NewRecorder()
+			// Even a signed in user can't call them.
+			withRole(auth.RoleUser, r).ServeHTTP(rr, req)
+			if rr.Code != http.StatusUnauthorized {
+				t.Errorf("%s %s with token %q: expected status 401, got %d", rt.method, rt.path, token, rr.Code)
+			}
+		}
+	}
+}
+
+func TestHandleWatchPendingRequests(t *testing.T) {
+	// A real service behind a real server, so the create goes through the same broker the stream is reading.
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	userID := uuid.New()
+	requestID := uuid.New()
+	sid := "CH0123456789abcdef0123456789abcdef"
+
+	mockUserClient.EXPECT().GetUserProfile(gomock.Any(), userID).Return(&domain.User{UserID: userID, Role: "superadmin"}, nil)
+	mockLLM.EXPECT().Summarize(gomock.Any(), sid).Return("User needs help.", nil)
+	mockRepo.EXPECT().CreateRequest(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, req *domain.AssistanceRequest, _ []string) error {
+		req.RequestID = requestID
+		req.Status = "pending"
+		return nil
+	})
+
+	svc := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+	r := chi.NewRouter()
+	NewHandler(svc, nil, testInternalToken).RegisterRoutes(r)
+	server := httptest.NewServer(withRole(auth.RoleExpert, r))
+	defer server.Close()
+
+	watchCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(watchCtx, "GET", server.URL+"/request/pending/watch", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Could not open the stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected text/event-stream, got %q", ct)
+	}
+
+	lines := bufio.NewScanner(resp.Body)
+	// The opening comment means we're subscribed, so the create below can't be missed.
+	if !lines.Scan() || lines.Text() != ": watching" {
+		t.Fatalf("Expected the opening comment, got %q (%v)", lines.Text(), lines.Err())
+	}
+
+	if _, err := svc.CreateRequest(ctx, userID, sid, ""); err != nil {
+		t.Fatalf("CreateRequest() returned unexpected error: %v", err)
+	}
+
+	var event, data string
+	for data == "" && lines.Scan() {
+		line := lines.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	if event != "request_pending" {
+		t.Errorf("Expected a request_pending event, got %q", event)
+	}
+	var got domain.AssistanceRequest
+	if err := json.Unmarshal([]byte(data), &got); err != nil {
+		t.Fatalf("Could not decode event data %q: %v", data, err)
+	}
+	if got.RequestID != requestID || got.TwilioConversationSID != sid {
+		t.Errorf("Expected request %s for %s, got %s for %s", requestID, sid, got.RequestID, got.TwilioConversationSID)
+	}
+
+	// Hanging up should drop the subscription.
+	cancel()
+	broker := svc.(*service).pending
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		broker.mu.Lock()
+		n := len(broker.subs)
+		broker.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the subscription to be removed after disconnect, still have %d", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestOpenAPISpec checks the served spec is valid OpenAPI and describes exactly the routes the handler registers.
+func TestOpenAPISpec(t *testing.T) {
+	r, _, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/openapi.json", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	if err := openapi.Validate(rr.Body.Bytes()); err != nil {
+		t.Fatalf("Served spec is not valid: %v", err)
+	}
+	missing, extra, err := openapi.Diff(r, rr.Body.Bytes())
+	if err != nil {
+		t.Fatalf("Diff() returned error: %v", err)
+	}
+	if len(missing) > 0 || len(extra) > 0 {
+		t.Errorf("Spec is out of step with the routes. Undocumented: %v. Documented but not routed: %v", missing, extra)
+	}
+}
+
+// TestHandleGetRequest checks an expert gets the whole request, summary included, and a caller with no id is a 401.
+func TestHandleGetRequest(t *testing.T) {
+	r, mockService, ctrl := setupHandlerTest(t)
+	defer ctrl.Finish()
+
+	expertID := uuid.New()
+	want := &domain.AssistanceRequest{
+		RequestID:  uuid.New(),
+		UserID:     uuid.New(),
+		Status:     "pending",
+		LLMSummary: "User's Wi-Fi drops every evening. Router already restarted.",
+	}
+	mockService.EXPECT().GetRequest(gomock.Any(), want.RequestID, Caller{ExpertID: expertID}).Return(want, nil)
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, auth.SetExpertID(httptest.NewRequest("GET", "/request/"+want.RequestID.String(), nil), expertID))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	var got domain.AssistanceRequest
+	json.NewDecoder(rr.Body).Decode(&got)
+	if got.RequestID != want.RequestID || got.LLMSummary != want.LLMSummary {
+		t.Errorf("Expected request %s with its summary, got %+v", want.RequestID, got)
+	}
+
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/request/"+want.RequestID.String(), nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without a caller, got %d", rr.Code)
+	}
+}
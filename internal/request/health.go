@@ -0,0 +1,121 @@
+package request
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"project-sage/internal/httpclient"
+	"project-sage/internal/httputil"
+	"sync"
+	"time"
+)
+
+// Deep health check limits. Each check gets checkTimeout, and the whole endpoint never runs past deepHealthTimeout
+// so the load balancer's probe doesn't time out on us first.
+const (
+	checkTimeout      = 1500 * time.Millisecond
+	deepHealthTimeout = 2 * time.Second
+)
+
+// DBPinger is the part of *sql.DB the health check needs.
+type DBPinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// HealthChecker reports whether the RequestService and everything it depends on is reachable.
+type HealthChecker struct {
+	db          DBPinger
+	downstreams map[string]string // component name -> base URL
+	httpClient  *http.Client
+}
+
+// NewHealthChecker creates the checker. Downstreams with an empty URL aren't configured and are left out.
+func NewHealthChecker(db DBPinger, downstreams map[string]string) *HealthChecker {
+	configured := make(map[string]string, len(downstreams))
+	for name, url := range downstreams {
+		if url != "" {
+			configured[name] = url
+		}
+	}
+	return &HealthChecker{
+		db:          db,
+		downstreams: configured,
+		httpClient:  httpclient.New(checkTimeout),
+	}
+}
+
+// deepHealthResponse is the body of GET /health/deep.
+type deepHealthResponse struct {
+	Status     string            `json:"status"`     // "ok" or "degraded"
+	Components map[string]string `json:"components"` // component -> "ok" or the error
+}
+
+// HandleDeepHealth pings the database and GETs /health on every downstream at the same time.
+// It answers 200 if everything is ok and 503 otherwise.
+func (hc *HealthChecker) HandleDeepHealth(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), deepHealthTimeout)
+	defer cancel()
+
+	var (
+		mu         sync.Mutex
+		wg         sync.WaitGroup
+		components = make(map[string]string, len(hc.downstreams)+1)
+	)
+	record := func(name string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			components[name] = err.Error()
+			return
+		}
+		components[name] = "ok"
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+		defer cancel()
+		record("postgres", hc.db.PingContext(checkCtx))
+	}()
+
+	for name, baseURL := range hc.downstreams {
+		wg.Add(1)
+		go func(name, baseURL string) {
+			defer wg.Done()
+			record(name, hc.checkDownstream(ctx, baseURL))
+		}(name, baseURL)
+	}
+
+	wg.Wait()
+
+	resp := deepHealthResponse{Status: "ok", Components: components}
+	status := http.StatusOK
+	for _, result := range components {
+		if result != "ok" {
+			resp.Status = "degraded"
+			status = http.StatusServiceUnavailable
+			break
+		}
+	}
+	httputil.WriteJSON(w, status, resp)
+}
+
+// checkDownstream does a cheap GET /health against one service.
+func (hc *HealthChecker) checkDownstream(ctx context.Context, baseURL string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL+"/health", nil)
+	if err != nil {
+		return fmt.Errorf("bad url: %w", err)
+	}
+
+	resp, err := hc.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("returned status %d", resp.StatusCode)
+	}
+	return nil
+}
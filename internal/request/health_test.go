@@ -0,0 +1,103 @@
+package request
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakePinger stands in for the database.
+type fakePinger struct{ err error }
+
+func (f fakePinger) PingContext(ctx context.Context) error { return f.err }
+
+// healthServer returns a downstream whose /health answers with the given status, after an optional delay.
+func healthServer(t *testing.T, status int, delay time.Duration) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// getDeepHealth calls the handler and decodes the response.
+func getDeepHealth(t *testing.T, hc *HealthChecker) (int, deepHealthResponse) {
+	rr := httptest.NewRecorder()
+	hc.HandleDeepHealth(rr, httptest.NewRequest("GET", "/health/deep", nil))
+
+	var resp deepHealthResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Could not decode response: %v", err)
+	}
+	return rr.Code, resp
+}
+
+func TestDeepHealth_AllOK(t *testing.T) {
+	hc := NewHealthChecker(fakePinger{}, map[string]string{
+		"billing":     healthServer(t, http.StatusOK, 0).URL,
+		"llm_gateway": healthServer(t, http.StatusOK, 0).URL,
+		"user":        "", // Not configured, so not checked.
+	})
+
+	code, resp := getDeepHealth(t, hc)
+
+	if code != http.StatusOK || resp.Status != "ok" {
+		t.Errorf("Expected 200/ok, got %d/%s", code, resp.Status)
+	}
+	if len(resp.Components) != 3 {
+		t.Errorf("Expected postgres plus 2 downstreams, got %v", resp.Components)
+	}
+	if _, ok := resp.Components["user"]; ok {
+		t.Error("Unconfigured downstream should not be reported")
+	}
+}
+
+func TestDeepHealth_Degraded(t *testing.T) {
+	hc := NewHealthChecker(fakePinger{err: errors.New("connection refused")}, map[string]string{
+		"billing":      healthServer(t, http.StatusOK, 0).URL,
+		"chat_gateway": healthServer(t, http.StatusInternalServerError, 0).URL,
+	})
+
+	code, resp := getDeepHealth(t, hc)
+
+	if code != http.StatusServiceUnavailable || resp.Status != "degraded" {
+		t.Errorf("Expected 503/degraded, got %d/%s", code, resp.Status)
+	}
+	if resp.Components["billing"] != "ok" {
+		t.Errorf("Expected billing ok, got %q", resp.Components["billing"])
+	}
+	if resp.Components["postgres"] == "ok" || resp.Components["chat_gateway"] == "ok" {
+		t.Errorf("Expected postgres and chat_gateway to fail, got %v", resp.Components)
+	}
+}
+
+func TestDeepHealth_SlowDownstreamIsBounded(t *testing.T) {
+	// Both hang far longer than the limit. Since the checks run concurrently the endpoint still answers in time.
+	hc := NewHealthChecker(fakePinger{}, map[string]string{
+		"billing": healthServer(t, http.StatusOK, 10*time.Second).URL,
+		"user":    healthServer(t, http.StatusOK, 10*time.Second).URL,
+	})
+
+	start := time.Now()
+	code, resp := getDeepHealth(t, hc)
+	elapsed := time.Since(start)
+
+	if elapsed > deepHealthTimeout+500*time.Millisecond {
+		t.Errorf("Deep health took %v, expected it to stay around %v", elapsed, deepHealthTimeout)
+	}
+	if code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503, got %d", code)
+	}
+	if resp.Components["billing"] == "ok" || resp.Components["user"] == "ok" {
+		t.Errorf("Expected the slow downstreams to fail, got %v", resp.Components)
+	}
+}
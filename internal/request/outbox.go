@@ -0,0 +1,207 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"project-sage/internal/domain"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Outbox event types. Each one names a side effect of saving a request that the dispatcher carries out.
+const (
+	OutboxRemoveBot     = "remove_bot"     // Take the bot out of the request's conversation
+	OutboxNotifyExperts = "notify_experts" // Tell the experts there's a new request in the queue
+	OutboxNotifyUser    = "notify_user"    // Tell the user an expert has joined their chat
+)
+
+// OutboxEvent is a side effect waiting in outbox_events to be delivered.
+type OutboxEvent struct {
+	EventID   uuid.UUID
+	EventType string
+	RequestID uuid.UUID
+	Attempts  int    // Failed or successful tries so far
+	LastError string // Empty until an attempt fails
+	CreatedAt time.Time
+}
+
+// OutboxConfig tunes the dispatcher. Zero values are filled from DefaultOutboxConfig.
+type OutboxConfig struct {
+	Interval   time.Duration // How often to look for due events
+	BatchSize  int           // Most events handled per pass
+	Lease      time.Duration // How long a claimed event is held before another dispatcher may take it
+	Timeout    time.Duration // Per-event deadline for the downstream call
+	MinBackoff time.Duration // Wait after the first failure. It doubles per attempt
+	MaxBackoff time.Duration // Cap on the wait between attempts
+}
+
+// DefaultOutboxConfig returns the settings used when none are configured.
+func DefaultOutboxConfig() OutboxConfig {
+	return OutboxConfig{
+		Interval:   time.Second, // Short, since the bot stays in the chat until remove_bot goes out.
+		BatchSize:  50,
+		Lease:      time.Minute,
+		Timeout:    5 * time.Second,
+		MinBackoff: 2 * time.Second,
+		MaxBackoff: 5 * time.Minute,
+	}
+}
+
+// withDefaults fills any zero settings from DefaultOutboxConfig.
+func (c OutboxConfig) withDefaults() OutboxConfig {
+	d := DefaultOutboxConfig()
+	if c.Interval <= 0 {
+		c.Interval = d.Interval
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = d.BatchSize
+	}
+	if c.Lease <= 0 {
+		c.Lease = d.Lease
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = d.Timeout
+	}
+	if c.MinBackoff <= 0 {
+		c.MinBackoff = d.MinBackoff
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = d.MaxBackoff
+	}
+	return c
+}
+
+// backoff is how long to wait before the next try, after attempts tries have already failed.
+func (c OutboxConfig) backoff(attempts int) time.Duration {
+	wait := c.MinBackoff
+	for i := 1; i < attempts && wait < c.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > c.MaxBackoff {
+		wait = c.MaxBackoff
+	}
+	return wait
+}
+
+// OutboxDispatcher delivers outbox events in the background and marks them sent.
+// Anything that fails stays in the outbox and is retried with backoff, so it's at least once:
+// the downstream calls have to cope with a repeat (removing a bot twice is a no-op, a second push is just noise).
+type OutboxDispatcher struct {
+	repo     Repository
+	chat     ChatClient
+	notifier NotificationClient
+	experts  ExpertClient // For the name in the user's "expert joined" notification
+	cfg      OutboxConfig
+}
+
+// NewOutboxDispatcher is the constructor. A nil NotificationClient means nobody is notified.
+func NewOutboxDispatcher(r Repository, cc ChatClient, nc NotificationClient, ec ExpertClient, cfg OutboxConfig) *OutboxDispatcher {
+	if nc == nil {
+		nc = NewNoopNotificationClient()
+	}
+	return &OutboxDispatcher{
+		repo:     r,
+		chat:     cc,
+		notifier: nc,
+		experts:  ec,
+		cfg:      cfg.withDefaults(),
+	}
+}
+
+// Run dispatches due events every Interval until ctx is done.
+func (d *OutboxDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := d.DispatchOnce(ctx); err != nil && ctx.Err() == nil {
+			fmt.Printf("WARNING: Outbox dispatch failed: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DispatchOnce claims one batch of due events and delivers them. It returns how many were delivered.
+// A failed event doesn't stop the batch, it's rescheduled and the error is only logged.
+func (d *OutboxDispatcher) DispatchOnce(ctx context.Context) (int, error) {
+	events, err := d.repo.ClaimOutboxEvents(ctx, d.cfg.BatchSize, d.cfg.Lease)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, ev := range events {
+		if err := d.deliver(ctx, ev); err != nil {
+			attempts := ev.Attempts + 1
+			retryAt := time.Now().UTC().Add(d.cfg.backoff(attempts))
+			fmt.Printf("WARNING: Outbox event %s (%s for request %s) failed on attempt %d, retrying at %s: %v\n",
+				ev.EventID, ev.EventType, ev.RequestID, attempts, retryAt.Format(time.RFC3339), err)
+			if err := d.repo.MarkOutboxEventFailed(ctx, ev.EventID, err.Error(), retryAt); err != nil {
+				// The lease runs out eventually, so it'll be picked up again either way.
+				fmt.Printf("WARNING: Could not reschedule outbox event %s: %v\n", ev.EventID, err)
+			}
+			continue
+		}
+		if err := d.repo.MarkOutboxEventSent(ctx, ev.EventID); err != nil {
+			// Delivered but not marked, so it'll go out again after the lease. That's the at least once part.
+			fmt.Printf("WARNING: Could not mark outbox event %s sent: %v\n", ev.EventID, err)
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// errUnknownOutboxEvent means the event type isn't one this dispatcher knows, eg written by a newer version.
+var errUnknownOutboxEvent = errors.New("unknown outbox event type")
+
+// deliver carries out one event under its own deadline.
+func (d *OutboxDispatcher) deliver(ctx context.Context, ev *OutboxEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.Timeout)
+	defer cancel()
+
+	// Every event needs the request as it is now, eg the summary for the notification.
+	req, err := d.repo.GetRequestByID(ctx, ev.RequestID)
+	if err != nil {
+		return fmt.Errorf("could not get request: %w", err)
+	}
+
+	switch ev.EventType {
+	case OutboxRemoveBot:
+		return d.chat.RemoveBot(ctx, req.TwilioConversationSID)
+	case OutboxNotifyExperts:
+		// Only worth a push if it's still waiting for someone.
+		if req.Status != "pending" {
+			return nil
+		}
+		return d.notifier.NotifyExperts(ctx, req)
+	case OutboxNotifyUser:
+		// An accept that was reverted drops its event, but one that's been resolved since has nothing to say.
+		if req.Status != "active" || !req.ExpertID.Valid {
+			return nil
+		}
+		return d.notifyExpertJoined(ctx, req)
+	default:
+		return fmt.Errorf("%w: %q", errUnknownOutboxEvent, ev.EventType)
+	}
+}
+
+// notifyExpertJoined tells the requesting user that an expert joined their chat, eg "Joe has joined the chat".
+// It names whoever has the request now, which after a transfer is the expert they were handed to.
+func (d *OutboxDispatcher) notifyExpertJoined(ctx context.Context, req *domain.AssistanceRequest) error {
+	expert, err := d.experts.GetExpertProfile(ctx, req.ExpertID.UUID)
+	if err != nil {
+		return fmt.Errorf("could not get expert: %w", err)
+	}
+	name := "An expert"
+	if expert.DisplayName != "" {
+		name = expert.DisplayName
+	}
+	return d.notifier.NotifyUser(ctx, req.UserID, req.RequestID, fmt.Sprintf("%s has joined the chat", name))
+}
@@ -0,0 +1,180 @@
+package request
+
+import (
+	"context"
+	"errors"
+	"project-sage/internal/domain"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/mock/gomock"
+)
+
+// testOutboxConfig uses a known backoff so the retry time can be checked.
+var testOutboxConfig = OutboxConfig{MinBackoff: 2 * time.Second, MaxBackoff: time.Minute}
+
+// TestOutboxDispatcher_RetriesUnsentEvent checks a failed RemoveBot stays in the outbox with backoff,
+// then goes out and is marked sent on the next pass.
+func TestOutboxDispatcher_RetriesUnsentEvent(t *testing.T) {
+	_, mockRepo, _, _, mockChat, _, mockExpert, mockNotify, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+	ctx := context.Background()
+
+	req := &domain.AssistanceRequest{RequestID: uuid.New(), TwilioConversationSID: "CH-outbox", Status: "pending"}
+	ev := &OutboxEvent{EventID: uuid.New(), EventType: OutboxRemoveBot, RequestID: req.RequestID}
+	mockRepo.EXPECT().GetRequestByID(gomock.Any(), req.RequestID).Return(req, nil).Times(2)
+
+	// First pass: the chat gateway is down.
+	gomock.InOrder(
+		mockRepo.EXPECT().ClaimOutboxEvents(gomock.Any(), gomock.Any(), gomock.Any()).Return([]*OutboxEvent{ev}, nil),
+		mockChat.EXPECT().RemoveBot(gomock.Any(), "CH-outbox").Return(errors.New("chat gateway down")),
+		mockRepo.EXPECT().MarkOutboxEventFailed(gomock.Any(), ev.EventID, "chat gateway down", gomock.Any()).
+			DoAndReturn(func(_ context.Context, _ uuid.UUID, _ string, retryAt time.Time) error {
+				// First failure waits MinBackoff.
+				if wait := time.Until(retryAt); wait < time.Second || wait > 2*time.Second {
+					t.Errorf("Expected a retry in about 2s, got %v", wait)
+				}
+				return nil
+			}),
+	)
+	mockRepo.EXPECT().MarkOutboxEventSent(gomock.Any(), gomock.Any()).Times(0)
+
+	d := NewOutboxDispatcher(mockRepo, mockChat, mockNotify, mockExpert, testOutboxConfig)
+	sent, err := d.DispatchOnce(ctx)
+	if err != nil || sent != 0 {
+		t.Fatalf("Expected nothing sent and no error, got %d, %v", sent, err)
+	}
+
+	// Second pass: the same event comes back with one attempt behind it, and goes through.
+	retry := *ev
+	retry.Attempts = 1
+	retry.LastError = "chat gateway down"
+	gomock.InOrder(
+		mockRepo.EXPECT().ClaimOutboxEvents(gomock.Any(), gomock.Any(), gomock.Any()).Return([]*OutboxEvent{&retry}, nil),
+		mockChat.EXPECT().RemoveBot(gomock.Any(), "CH-outbox").Return(nil),
+		mockRepo.EXPECT().MarkOutboxEventSent(gomock.Any(), ev.EventID).Return(nil),
+	)
+
+	sent, err = d.DispatchOnce(ctx)
+	if err != nil || sent != 1 {
+		t.Fatalf("Expected 1 sent and no error, got %d, %v", sent, err)
+	}
+}
+
+// TestOutboxDispatcher_NotifyExperts checks notifications go out for pending requests and are skipped once it's taken.
+func TestOutboxDispatcher_NotifyExperts(t *testing.T) {
+	_, mockRepo, _, _, mockChat, _, mockExpert, mockNotify, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	pending := &domain.AssistanceRequest{RequestID: uuid.New(), Status: "pending"}
+	taken := &domain.AssistanceRequest{RequestID: uuid.New(), Status: "active"}
+	events := []*OutboxEvent{
+		{EventID: uuid.New(), EventType: OutboxNotifyExperts, RequestID: pending.RequestID},
+		{EventID: uuid.New(), EventType: OutboxNotifyExperts, RequestID: taken.RequestID},
+	}
+
+	mockRepo.EXPECT().ClaimOutboxEvents(gomock.Any(), gomock.Any(), gomock.Any()).Return(events, nil)
+	mockRepo.EXPECT().GetRequestByID(gomock.Any(), pending.RequestID).Return(pending, nil)
+	mockRepo.EXPECT().GetRequestByID(gomock.Any(), taken.RequestID).Return(taken, nil)
+	mockNotify.EXPECT().NotifyExperts(gomock.Any(), pending).Return(nil).Times(1)
+	// Both are done with, even though only one needed a push.
+	mockRepo.EXPECT().MarkOutboxEventSent(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+
+	d := NewOutboxDispatcher(mockRepo, mockChat, mockNotify, mockExpert, testOutboxConfig)
+	if sent, err := d.DispatchOnce(context.Background()); err != nil || sent != 2 {
+		t.Fatalf("Expected 2 sent and no error, got %d, %v", sent, err)
+	}
+}
+
+// TestOutboxDispatcher_NotifyUserRetried checks the user's "expert joined" notification names the assigned expert,
+// stays in the outbox when the notifier fails, and goes out on the next pass.
+func TestOutboxDispatcher_NotifyUserRetried(t *testing.T) {
+	_, mockRepo, _, _, mockChat, _, mockExpert, mockNotify, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+	ctx := context.Background()
+
+	expertID := uuid.New()
+	req := &domain.AssistanceRequest{
+		RequestID: uuid.New(),
+		UserID:    uuid.New(),
+		ExpertID:  uuid.NullUUID{UUID: expertID, Valid: true},
+		Status:    "active",
+	}
+	ev := &OutboxEvent{EventID: uuid.New(), EventType: OutboxNotifyUser, RequestID: req.RequestID}
+	mockRepo.EXPECT().GetRequestByID(gomock.Any(), req.RequestID).Return(req, nil).Times(2)
+	mockExpert.EXPECT().GetExpertProfile(gomock.Any(), expertID).Return(&domain.Expert{ExpertID: expertID, DisplayName: "Joe"}, nil).Times(2)
+
+	// First pass: the notifier is down.
+	gomock.InOrder(
+		mockRepo.EXPECT().ClaimOutboxEvents(gomock.Any(), gomock.Any(), gomock.Any()).Return([]*OutboxEvent{ev}, nil),
+		mockNotify.EXPECT().NotifyUser(gomock.Any(), req.UserID, req.RequestID, "Joe has joined the chat").Return(errors.New("webhook down")),
+		mockRepo.EXPECT().MarkOutboxEventFailed(gomock.Any(), ev.EventID, "webhook down", gomock.Any()).Return(nil),
+	)
+	mockRepo.EXPECT().MarkOutboxEventSent(gomock.Any(), gomock.Any()).Times(0)
+
+	d := NewOutboxDispatcher(mockRepo, mockChat, mockNotify, mockExpert, testOutboxConfig)
+	if sent, err := d.DispatchOnce(ctx); err != nil || sent != 0 {
+		t.Fatalf("Expected nothing sent and no error, got %d, %v", sent, err)
+	}
+
+	// Second pass: it goes through and is marked sent.
+	retry := *ev
+	retry.Attempts = 1
+	retry.LastError = "webhook down"
+	gomock.InOrder(
+		mockRepo.EXPECT().ClaimOutboxEvents(gomock.Any(), gomock.Any(), gomock.Any()).Return([]*OutboxEvent{&retry}, nil),
+		mockNotify.EXPECT().NotifyUser(gomock.Any(), req.UserID, req.RequestID, "Joe has joined the chat").Return(nil),
+		mockRepo.EXPECT().MarkOutboxEventSent(gomock.Any(), ev.EventID).Return(nil),
+	)
+
+	if sent, err := d.DispatchOnce(ctx); err != nil || sent != 1 {
+		t.Fatalf("Expected 1 sent and no error, got %d, %v", sent, err)
+	}
+}
+
+// TestOutboxDispatcher_NotifyUserSkipped checks nobody is told an expert joined a request that's no longer active,
+// and an expert with no display name is "An expert".
+func TestOutboxDispatcher_NotifyUserSkipped(t *testing.T) {
+	_, mockRepo, _, _, mockChat, _, mockExpert, mockNotify, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	expertID := uuid.New()
+	active := &domain.AssistanceRequest{RequestID: uuid.New(), UserID: uuid.New(), ExpertID: uuid.NullUUID{UUID: expertID, Valid: true}, Status: "active"}
+	resolved := &domain.AssistanceRequest{RequestID: uuid.New(), UserID: uuid.New(), ExpertID: uuid.NullUUID{UUID: expertID, Valid: true}, Status: "resolved"}
+	events := []*OutboxEvent{
+		{EventID: uuid.New(), EventType: OutboxNotifyUser, RequestID: active.RequestID},
+		{EventID: uuid.New(), EventType: OutboxNotifyUser, RequestID: resolved.RequestID},
+	}
+
+	mockRepo.EXPECT().ClaimOutboxEvents(gomock.Any(), gomock.Any(), gomock.Any()).Return(events, nil)
+	mockRepo.EXPECT().GetRequestByID(gomock.Any(), active.RequestID).Return(active, nil)
+	mockRepo.EXPECT().GetRequestByID(gomock.Any(), resolved.RequestID).Return(resolved, nil)
+	mockExpert.EXPECT().GetExpertProfile(gomock.Any(), expertID).Return(&domain.Expert{ExpertID: expertID}, nil).Times(1)
+	mockNotify.EXPECT().NotifyUser(gomock.Any(), active.UserID, active.RequestID, "An expert has joined the chat").Return(nil).Times(1)
+	mockRepo.EXPECT().MarkOutboxEventSent(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+
+	d := NewOutboxDispatcher(mockRepo, mockChat, mockNotify, mockExpert, testOutboxConfig)
+	if sent, err := d.DispatchOnce(context.Background()); err != nil || sent != 2 {
+		t.Fatalf("Expected 2 sent and no error, got %d, %v", sent, err)
+	}
+}
+
+func TestOutboxConfig_Backoff(t *testing.T) {
+	cfg := OutboxConfig{MinBackoff: 2 * time.Second, MaxBackoff: 10 * time.Second}.withDefaults()
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{3, 8 * time.Second},
+		{4, 10 * time.Second}, // Capped
+		{50, 10 * time.Second},
+	}
+	for _, tc := range tests {
+		if got := cfg.backoff(tc.attempts); got != tc.want {
+			t.Errorf("backoff(%d) = %v, want %v", tc.attempts, got, tc.want)
+		}
+	}
+}
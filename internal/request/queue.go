@@ -0,0 +1,69 @@
+package request
+
+import (
+	"sort"
+	"time"
+
+	"project-sage/internal/domain"
+
+	"github.com/google/uuid"
+)
+
+// Without any help every expert opening the queue goes for the oldest request, and all but one of them
+// get a 409. Two things spread them out: a balanced view that shuffles requests of about the same age
+// differently for each look, and soft reservations that hide a request from everyone else for a short
+// while once an expert has picked it. A reservation is only a hint. It runs out on its own if the expert
+// never accepts, and nothing has to clean it up.
+
+const (
+	// DefaultReservationTTL is how long a reservation lasts when the expert doesn't ask for a length.
+	DefaultReservationTTL = time.Minute
+	// MaxReservationTTL caps a reservation, so an expert who wanders off doesn't hide a request for long.
+	MaxReservationTTL = 5 * time.Minute
+	// queueJitter is the most a balanced view moves a request ahead of older ones.
+	// Requests further apart than this keep their order, so the oldest still go first.
+	queueJitter = 30 * time.Second
+)
+
+// QueueOptions picks how GetPendingRequests builds an expert's view of the queue.
+// The zero value is every unreserved pending request, oldest first, on one page.
+type QueueOptions struct {
+	ExpertID uuid.UUID // Who's looking. Their own reservations stay in the view, everyone else's are left out
+	Balanced bool      // Order by Priority instead of age
+	Limit    int       // Page size. 0 means the whole queue
+	Offset   int       // Requests to skip, for the pages after the first
+}
+
+// QueuedRequest is a pending request as one expert sees it in the queue.
+type QueuedRequest struct {
+	*domain.AssistanceRequest
+	Priority      float64    `json:"priority,omitempty"`       // Only set in a balanced view. Higher goes first
+	ReservedUntil *time.Time `json:"reserved_until,omitempty"` // Set if the expert looking has it reserved
+}
+
+// clampReservationTTL fills in the default for a missing TTL and caps a long one.
+func clampReservationTTL(ttl time.Duration) time.Duration {
+	switch {
+	case ttl <= 0:
+		return DefaultReservationTTL
+	case ttl > MaxReservationTTL:
+		return MaxReservationTTL
+	}
+	return ttl
+}
+
+// balanceQueue gives each request a priority of its age in seconds plus up to queueJitter of noise from
+// jitter (which returns values in [0, 1)), then sorts the queue by it, highest first. The expert's own
+// reservations go on top whatever their age, since they've already picked those.
+func balanceQueue(queue []*QueuedRequest, now time.Time, jitter func() float64) {
+	for _, q := range queue {
+		q.Priority = now.Sub(q.CreatedAt).Seconds() + jitter()*queueJitter.Seconds()
+	}
+	sort.SliceStable(queue, func(i, j int) bool {
+		iMine, jMine := queue[i].ReservedUntil != nil, queue[j].ReservedUntil != nil
+		if iMine != jMine {
+			return iMine
+		}
+		return queue[i].Priority > queue[j].Priority
+	})
+}
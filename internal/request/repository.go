@@ -6,7 +6,9 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"project-sage/internal/database"
 	"project-sage/internal/domain" // shared domain models
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,39 +16,197 @@ import (
 
 // Repository defines the contract for all database operations related to assistance requests and ratings.
 type Repository interface {
-	// CreateRequest inserts a new pending request
-	CreateRequest(ctx context.Context, req *domain.AssistanceRequest) error
-	// GetPendingRequests fetches all requests withpending status for the expert queue
-	GetPendingRequests(ctx context.Context) ([]*domain.AssistanceRequest, error)
-	// AcceptRequest assigns an expert and marks the request active.
-	AcceptRequest(ctx context.Context, requestID, expertID uuid.UUID) error
-	// ResolveRequest marks a request as resolved.
-	ResolveRequest(ctx context.Context, requestID uuid.UUID) error
+	// CreateRequest inserts a new pending request, plus one outbox event per entry in events, in one transaction.
+	// Returns ErrDuplicateRequest if the conversation already has an open one.
+	CreateRequest(ctx context.Context, req *domain.AssistanceRequest, events []string) error
+	// GetOpenRequestBySID fetches the pending or active request for a conversation.
+	GetOpenRequestBySID(ctx context.Context, twilioSID string) (*domain.AssistanceRequest, error)
+	// HasOpenRequest reports whether the user has any pending or active request.
+	HasOpenRequest(ctx context.Context, userID uuid.UUID) (bool, error)
+	// GetOpenRequestByUser fetches the user's most recent pending or active request.
+	GetOpenRequestByUser(ctx context.Context, userID uuid.UUID) (*domain.AssistanceRequest, error)
+	// GetRequestByTwilioSID fetches the most recent request for a conversation, whatever its status.
+	GetRequestByTwilioSID(ctx context.Context, twilioSID string) (*domain.AssistanceRequest, error)
+	// GetPendingRequests fetches the pending requests expertID can see at now, oldest first: everything that isn't
+	// reserved by another expert. Their own live reservations come back with ReservedUntil set.
+	GetPendingRequests(ctx context.Context, expertID uuid.UUID, now time.Time) ([]*QueuedRequest, error)
+	// ReserveRequest holds a pending request for expertID until until, if nobody else holds it at now.
+	// Reserving again extends the expert's own reservation. Returns ErrRequestReserved if it isn't pending
+	// or someone else holds it, whether or not it exists.
+	ReserveRequest(ctx context.Context, requestID, expertID uuid.UUID, now, until time.Time) error
+	// AcceptRequest assigns an expert, marks the request active and returns the updated row. An OutboxNotifyUser
+	// event is written in the same transaction. A request reserved by another expert isn't accepted, and comes back as ErrRequestAlreadyAccepted.
+	AcceptRequest(ctx context.Context, requestID, expertID uuid.UUID) (*domain.AssistanceRequest, error)
+	// RevertToPending undoes an accept: it moves a request that's active with expertID back to pending, unassigned,
+	// and drops its unsent OutboxNotifyUser events. Returns ErrRequestNotActive if it's no longer active with that expert.
+	RevertToPending(ctx context.Context, requestID, expertID uuid.UUID) error
+	// ClaimNextRequest assigns the oldest pending request nobody else has reserved to the expert, with an
+	// OutboxNotifyUser event like AcceptRequest. Returns ErrQueueEmpty if there is none.
+	ClaimNextRequest(ctx context.Context, expertID uuid.UUID) (*domain.AssistanceRequest, error)
+	// ResolveRequest marks a request expertID is working on as resolved. Returns ErrRequestNotActive if there's
+	// no active request with that id assigned to them, whether or not it exists.
+	ResolveRequest(ctx context.Context, requestID, expertID uuid.UUID) error
+	// SetFirstResponse sets first_response_at to t if the request is active and it's still NULL.
+	// Returns false if it was already set (or the request isn't active), which callers treat as a no-op.
+	SetFirstResponse(ctx context.Context, requestID uuid.UUID, t time.Time) (bool, error)
+	// TouchRequestActivity sets last_activity_at to now if the request is active.
+	TouchRequestActivity(ctx context.Context, requestID uuid.UUID) error
+	// ResolveIdleRequests resolves up to limit active requests with no activity since idleSince, and returns how many.
+	ResolveIdleRequests(ctx context.Context, idleSince time.Time, limit int) (int, error)
+	// TransferRequest moves an active request from fromExpertID to toExpertID and records a 'transferred' event.
+	// Returns ErrRequestNotActive if it's no longer active with fromExpertID.
+	TransferRequest(ctx context.Context, requestID, fromExpertID, toExpertID uuid.UUID, actorID uuid.UUID, actorRole string) (*domain.AssistanceRequest, error)
+	// ReopenRequest moves a request resolved at or after resolvedAfter back to active (keepExpert) or pending.
+	// Returns ErrRequestNotResolved if it wasn't resolved or fell outside the window.
+	ReopenRequest(ctx context.Context, requestID uuid.UUID, resolvedAfter time.Time, keepExpert bool) (*domain.AssistanceRequest, error)
 	// GetRequestByID fetches a single request (to check status, etc.).
 	GetRequestByID(ctx context.Context, requestID uuid.UUID) (*domain.AssistanceRequest, error)
 	// CreateRating inserts a new expert rating.
 	CreateRating(ctx context.Context, rating *domain.ExpertRating) error
+	// UpdateSummary replaces the LLM summary of a pending or active request.
+	UpdateSummary(ctx context.Context, requestID uuid.UUID, summary string) error
+	// StreamRequestsByUser calls fn for each of the user's requests, oldest first, without loading them all at once.
+	StreamRequestsByUser(ctx context.Context, userID uuid.UUID, fn func(*RequestExportRow) error) error
+	// GetRequestStats aggregates counts and latencies for requests created in [from, to).
+	GetRequestStats(ctx context.Context, from, to time.Time) (*RequestStats, error)
+	// SearchRequests returns one page of the requests matching filter, newest first.
+	SearchRequests(ctx context.Context, filter SearchFilter) (*SearchResult, error)
+
+	// ClaimOutboxEvents takes up to limit unsent events that are due, and holds them for lease
+	// so another dispatcher doesn't pick them up at the same time.
+	ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]*OutboxEvent, error)
+	// MarkOutboxEventSent records that the event was delivered.
+	MarkOutboxEventSent(ctx context.Context, eventID uuid.UUID) error
+	// MarkOutboxEventFailed records a failed attempt and when to try again.
+	MarkOutboxEventFailed(ctx context.Context, eventID uuid.UUID, lastError string, retryAt time.Time) error
+}
+
+// RequestStats is the dashboard summary of requests created in a time window. Durations are in seconds.
+type RequestStats struct {
+	From                    time.Time `json:"from"`
+	To                      time.Time `json:"to"`
+	TotalCreated            int       `json:"total_created"`
+	Accepted                int       `json:"accepted"`
+	Resolved                int       `json:"resolved"`
+	Expired                 int       `json:"expired"`
+	AvgTimeToAcceptSeconds  float64   `json:"avg_time_to_accept_seconds"`
+	P95TimeToAcceptSeconds  float64   `json:"p95_time_to_accept_seconds"`
+	AvgTimeToResolveSeconds float64   `json:"avg_time_to_resolve_seconds"`
+	// Time to first response is from acceptance to the expert's first message, so it doesn't double count queue time.
+	Responded                     int     `json:"responded"`
+	AvgTimeToFirstResponseSeconds float64 `json:"avg_time_to_first_response_seconds"`
+	P95TimeToFirstResponseSeconds float64 `json:"p95_time_to_first_response_seconds"`
+}
+
+// RequestExportRow is one request in a user's history export, joined with the expert and the user's rating.
+type RequestExportRow struct {
+	RequestID         uuid.UUID  `json:"request_id"`
+	Status            string     `json:"status"`
+	CreatedAt         time.Time  `json:"created_at"`
+	AcceptedAt        *time.Time `json:"accepted_at"`
+	ResolvedAt        *time.Time `json:"resolved_at"`
+	ExpertDisplayName *string    `json:"expert_display_name"`
+	Rating            *int       `json:"rating"`
+}
+
+// SearchFilter narrows an admin search. Zero fields don't filter, so the zero filter matches every request.
+type SearchFilter struct {
+	Status      string
+	UserID      uuid.UUID
+	ExpertID    uuid.UUID
+	CreatedFrom time.Time // Inclusive
+	CreatedTo   time.Time // Exclusive
+	Limit       int       // Page size. Must be positive
+	Offset      int       // Rows to skip, for the pages after the first
+}
+
+// SearchResult is one page of an admin search.
+type SearchResult struct {
+	Requests   []*domain.AssistanceRequest `json:"requests"`
+	Limit      int                         `json:"limit"`
+	Offset     int                         `json:"offset"`
+	NextOffset *int                        `json:"next_offset,omitempty"` // Nil on the last page
+}
+
+// RepositoryOptions are the repository's optional settings. The zero value has no query timeout.
+type RepositoryOptions struct {
+	QueryTimeout time.Duration // How long each repository call gets before it fails with context.DeadlineExceeded. 0 means no limit
 }
 
 // postgresRepository is the concrete implementation of the repo using a Postgres database.
 type postgresRepository struct {
-	db *sql.DB // The database connection pool.
+	db           *sql.DB       // The database connection pool.
+	queryTimeout time.Duration // 0 means no limit
 }
 
-// NewPostgresRepository is the constructor for the repository.
+// NewPostgresRepository is the constructor for the repository. Each call gets database.DefaultQueryTimeout.
 func NewPostgresRepository(db *sql.DB) Repository {
+	return NewPostgresRepositoryWithOptions(db, RepositoryOptions{QueryTimeout: database.DefaultQueryTimeout})
+}
+
+// NewPostgresRepositoryWithOptions is the same as NewPostgresRepository, with the query timeout from opts.
+func NewPostgresRepositoryWithOptions(db *sql.DB, opts RepositoryOptions) Repository {
 	return &postgresRepository{
-		db: db,
+		db:           db,
+		queryTimeout: max(opts.QueryTimeout, 0),
 	}
 }
 
+// requestColumns is the column list every full request query selects or returns. It must match scanRequest.
+const requestColumns = `
+	request_id, user_id, expert_id, status, llm_summary, twilio_conversation_sid,
+	created_at, accepted_at, resolved_at, first_response_at, last_activity_at
+`
+
+// scanRequest scans one row selected with requestColumns.
+// The nullable columns (expert_id and the timestamps) land in the uuid.NullUUID/sql.NullTime fields of the domain.
+func scanRequest(row interface{ Scan(...any) error }) (*domain.AssistanceRequest, error) {
+	var req domain.AssistanceRequest
+	err := row.Scan(
+		&req.RequestID,
+		&req.UserID,
+		&req.ExpertID,
+		&req.Status,
+		&req.LLMSummary,
+		&req.TwilioConversationSID,
+		&req.CreatedAt,
+		&req.AcceptedAt,
+		&req.ResolvedAt,
+		&req.FirstResponseAt,
+		&req.LastActivityAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+// scanFunc adapts a function to the Scan interface, eg to scan extra columns after requestColumns.
+type scanFunc func(dest ...any) error
+
+func (f scanFunc) Scan(dest ...any) error { return f(dest...) }
+
+// reservationFree is the WHERE clause for "nobody but $1 holds a reservation at $2".
+const reservationFree = `(reserved_until IS NULL OR reserved_until <= $2 OR reserved_by = $1)`
+
 // CreateRequest inserts a new assistance_requests record.
-func (pr *postgresRepository) CreateRequest(ctx context.Context, req *domain.AssistanceRequest) error {
+func (pr *postgresRepository) CreateRequest(ctx context.Context, req *domain.AssistanceRequest, events []string) error {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
 	// Set server-side fields before insert.
 	req.RequestID = uuid.New()
 	req.Status = "pending" // all new requests start as pending.
 	req.CreatedAt = time.Now().UTC()
 
+	// The request and its outbox events commit together, so the dispatcher never misses a side effect
+	// and never acts on a request that didn't get saved.
+	tx, err := pr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("could not begin create transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op once committed.
+
 	query := `
 		INSERT INTO assistance_requests
 			(request_id, user_id, status, llm_summary, twilio_conversation_sid, created_at)
@@ -54,7 +214,7 @@ func (pr *postgresRepository) CreateRequest(ctx context.Context, req *domain.Ass
 			($1, $2, $3, $4, $5, $6)
 	`
 	// Execute the insert query.
-	_, err := pr.db.ExecContext(ctx, query,
+	_, err = tx.ExecContext(ctx, query,
 		req.RequestID,
 		req.UserID,
 		req.Status,
@@ -63,75 +223,341 @@ func (pr *postgresRepository) CreateRequest(ctx context.Context, req *domain.Ass
 		req.CreatedAt,
 	)
 	if err != nil {
+		// The partial unique index only allows one open request per conversation.
+		if database.IsUniqueViolation(err) {
+			return ErrDuplicateRequest
+		}
 		return fmt.Errorf("could not insert request: %w", err)
 	}
+
+	for _, eventType := range events {
+		if err := insertOutboxEvent(ctx, tx, eventType, req.RequestID, req.CreatedAt); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit request: %w", err)
+	}
+	return nil
+}
+
+// insertOutboxEvent queues one event for the dispatcher, due straight away, as part of tx.
+func insertOutboxEvent(ctx context.Context, tx *sql.Tx, eventType string, requestID uuid.UUID, at time.Time) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO outbox_events (event_id, event_type, request_id, created_at, next_attempt_at)
+		VALUES ($1, $2, $3, $4, $4)
+	`, uuid.New(), eventType, requestID, at)
+	if err != nil {
+		return fmt.Errorf("could not insert %s outbox event: %w", eventType, err)
+	}
 	return nil
 }
 
-// GetPendingRequests fetches all requests with status='pending', ordered by creation time for the queue.
-func (pr *postgresRepository) GetPendingRequests(ctx context.Context) ([]*domain.AssistanceRequest, error) {
+// GetOpenRequestBySID fetches the one pending or active request for a conversation, if there is one.
+func (pr *postgresRepository) GetOpenRequestBySID(ctx context.Context, twilioSID string) (*domain.AssistanceRequest, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + requestColumns + `
+		FROM assistance_requests
+		WHERE twilio_conversation_sid = $1 AND status IN ('pending', 'active')
+	`
+
+	req, err := scanRequest(pr.db.QueryRowContext(ctx, query, twilioSID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("could not get open request: %w", err)
+	}
+
+	return req, nil
+}
+
+// HasOpenRequest checks for any pending or active request by the user. EXISTS stops at the first match.
+func (pr *postgresRepository) HasOpenRequest(ctx context.Context, userID uuid.UUID) (bool, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	var exists bool
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM assistance_requests
+			WHERE user_id = $1 AND status IN ('pending', 'active')
+		)
+	`
+	if err := pr.db.QueryRowContext(ctx, query, userID).Scan(&exists); err != nil {
+		return false, fmt.Errorf("could not check for open requests: %w", err)
+	}
+	return exists, nil
+}
+
+// GetOpenRequestByUser fetches the user's newest pending or active request.
+func (pr *postgresRepository) GetOpenRequestByUser(ctx context.Context, userID uuid.UUID) (*domain.AssistanceRequest, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + requestColumns + `
+		FROM assistance_requests
+		WHERE user_id = $1 AND status IN ('pending', 'active')
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+
+	req, err := scanRequest(pr.db.QueryRowContext(ctx, query, userID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("could not get open request for user: %w", err)
+	}
+
+	return req, nil
+}
+
+// GetRequestByTwilioSID fetches the newest request for a conversation.
+// A conversation can have old resolved requests besides the one open request 0003 allows, so order by created_at.
+// If there's an open request it is always the newest, since a new one can't be created while it's open.
+func (pr *postgresRepository) GetRequestByTwilioSID(ctx context.Context, twilioSID string) (*domain.AssistanceRequest, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	query := `
+		SELECT ` + requestColumns + `
+		FROM assistance_requests
+		WHERE twilio_conversation_sid = $1
+		ORDER BY created_at DESC
+		LIMIT 1
+	`
+
+	req, err := scanRequest(pr.db.QueryRowContext(ctx, query, twilioSID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("could not get request by conversation: %w", err)
+	}
+
+	return req, nil
+}
+
+// GetPendingRequests fetches the queue as expertID sees it, ordered by creation time.
+// Other experts' reservations are filtered out here rather than in the service, so an expired one
+// is back in the queue without anything having to clear it.
+func (pr *postgresRepository) GetPendingRequests(ctx context.Context, expertID uuid.UUID, now time.Time) ([]*QueuedRequest, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
 	query := `
-		SELECT request_id, user_id, twilio_conversation_sid, created_at
+		SELECT ` + requestColumns + `,
+			CASE WHEN reserved_by = $1 AND reserved_until > $2 THEN reserved_until END
 		FROM assistance_requests
-		WHERE status = 'pending'
+		WHERE status = 'pending' AND ` + reservationFree + `
 		ORDER BY created_at ASC
 	` // ORDER BY ASC ensures the oldest requests are first.
 
-	rows, err := pr.db.QueryContext(ctx, query)
+	rows, err := pr.db.QueryContext(ctx, query, expertID, now)
 	if err != nil {
 		return nil, fmt.Errorf("could not query pending requests: %w", err)
 	}
 	defer rows.Close()
 
 	// Iterate over the rows and scan them into a slice.
-	var requests []*domain.AssistanceRequest
+	var queue []*QueuedRequest
 	for rows.Next() {
-		var req domain.AssistanceRequest
-		// Note - This only scans the fields needed for the queue view.
-		if err := rows.Scan(&req.RequestID, &req.UserID, &req.TwilioConversationSID, &req.CreatedAt); err != nil {
+		var reservedUntil sql.NullTime
+		req, err := scanRequest(scanFunc(func(dest ...any) error {
+			return rows.Scan(append(dest, &reservedUntil)...)
+		}))
+		if err != nil {
 			return nil, fmt.Errorf("could not scan pending request: %w", err)
 		}
-		requests = append(requests, &req)
+		q := &QueuedRequest{AssistanceRequest: req}
+		if reservedUntil.Valid {
+			q.ReservedUntil = &reservedUntil.Time
+		}
+		queue = append(queue, q)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not query pending requests: %w", err)
 	}
-	return requests, nil
+	return queue, nil
+}
+
+// ReserveRequest sets the reservation in one conditional UPDATE, so two experts reserving at once can't both win.
+func (pr *postgresRepository) ReserveRequest(ctx context.Context, requestID, expertID uuid.UUID, now, until time.Time) error {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	query := `
+		UPDATE assistance_requests
+		SET reserved_by = $1, reserved_until = $3
+		WHERE request_id = $4 AND status = 'pending' AND ` + reservationFree
+	res, err := pr.db.ExecContext(ctx, query, expertID, now, until, requestID)
+	if err != nil {
+		return fmt.Errorf("database error reserving request: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not check reserve result: %w", err)
+	}
+	if n == 0 {
+		return ErrRequestReserved
+	}
+	return nil
 }
 
 // AcceptRequest atomically updates a request's status from pendin to active
-func (pr *postgresRepository) AcceptRequest(ctx context.Context, requestID, expertID uuid.UUID) error {
+// and returns the updated row in the same statement, so there's no second read for another writer to race.
+// The user's "expert joined" notification is queued in the same transaction.
+func (pr *postgresRepository) AcceptRequest(ctx context.Context, requestID, expertID uuid.UUID) (*domain.AssistanceRequest, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	tx, err := pr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin accept transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op once committed.
+
 	// This query is atomic. The where clause ensures we only update a request that is still pending.
+	// Another expert's live reservation blocks it too. The reservation is done with once it's accepted.
 	query := `
 		UPDATE assistance_requests
-		SET status = 'active', expert_id = $1, accepted_at = $2
-		WHERE request_id = $3 AND status = 'pending'
+		SET status = 'active', expert_id = $1, accepted_at = $2, last_activity_at = $2, reserved_by = NULL, reserved_until = NULL
+		WHERE request_id = $3 AND status = 'pending' AND ` + reservationFree + `
+		RETURNING ` + requestColumns + `
 	`
 
-	res, err := pr.db.ExecContext(ctx, query, expertID, time.Now().UTC(), requestID)
+	now := time.Now().UTC()
+	req, err := scanRequest(tx.QueryRowContext(ctx, query, expertID, now, requestID))
 	if err != nil {
-		return fmt.Errorf("database error accepting request: %w", err)
+		// No row came back, so the request was not pending or didn't exist.
+		if err == sql.ErrNoRows {
+			return nil, ErrRequestAlreadyAccepted
+		}
+		return nil, fmt.Errorf("database error accepting request: %w", err)
 	}
 
-	// Check if a row was actually affected.
-	rowsAffected, err := res.RowsAffected()
+	if err := insertOutboxEvent(ctx, tx, OutboxNotifyUser, req.RequestID, now); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit accept: %w", err)
+	}
+	return req, nil
+}
+
+// RevertToPending clears what AcceptRequest set. The expert is in the where clause, so a revert that comes late
+// can't take the request off someone it was transferred to since. The accept's notification is dropped too,
+// if it hasn't gone out yet, since nobody joined.
+func (pr *postgresRepository) RevertToPending(ctx context.Context, requestID, expertID uuid.UUID) error {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	tx, err := pr.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("could not check rows affected: %w", err)
+		return fmt.Errorf("could not begin revert transaction: %w", err)
 	}
-	// If 0 rows, it means the request was not pending or didn't exist
-	if rowsAffected == 0 {
-		return fmt.Errorf("request not found or was already accepted")
+	defer tx.Rollback() // No-op once committed.
+
+	query := `
+		UPDATE assistance_requests
+		SET status = 'pending', expert_id = NULL, accepted_at = NULL, first_response_at = NULL, last_activity_at = NULL
+		WHERE request_id = $1 AND status = 'active' AND expert_id = $2
+	`
+
+	result, err := tx.ExecContext(ctx, query, requestID, expertID)
+	if err != nil {
+		return fmt.Errorf("database error reverting request: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not check reverted rows: %w", err)
+	}
+	if n == 0 {
+		return ErrRequestNotActive
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM outbox_events WHERE request_id = $1 AND event_type = $2 AND sent_at IS NULL
+	`, requestID, OutboxNotifyUser)
+	if err != nil {
+		return fmt.Errorf("could not drop notify_user outbox events: %w", err)
 	}
 
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit revert: %w", err)
+	}
 	return nil
 }
 
+// ClaimNextRequest picks and assigns the oldest pending request in one transaction.
+// FOR UPDATE SKIP LOCKED means experts claiming at the same time each lock a different row
+// instead of queueing up behind the same one and then finding it taken.
+func (pr *postgresRepository) ClaimNextRequest(ctx context.Context, expertID uuid.UUID) (*domain.AssistanceRequest, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	tx, err := pr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin claim transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op once committed.
+
+	// Requests other experts have reserved are skipped, like rows another claim has locked.
+	now := time.Now().UTC()
+	var requestID uuid.UUID
+	err = tx.QueryRowContext(ctx, `
+		SELECT request_id
+		FROM assistance_requests
+		WHERE status = 'pending' AND `+reservationFree+`
+		ORDER BY created_at ASC
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`, expertID, now).Scan(&requestID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrQueueEmpty
+		}
+		return nil, fmt.Errorf("could not select next request: %w", err)
+	}
+
+	// The row is locked by us, so this update can't lose a race.
+	req, err := scanRequest(tx.QueryRowContext(ctx, `
+		UPDATE assistance_requests
+		SET status = 'active', expert_id = $1, accepted_at = $2, last_activity_at = $2, reserved_by = NULL, reserved_until = NULL
+		WHERE request_id = $3
+		RETURNING `+requestColumns+`
+	`, expertID, now, requestID))
+	if err != nil {
+		return nil, fmt.Errorf("could not claim request: %w", err)
+	}
+	if err := insertOutboxEvent(ctx, tx, OutboxNotifyUser, req.RequestID, now); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit claim: %w", err)
+	}
+	return req, nil
+}
+
 // ResolveRequest marks an active request as resolved.
-func (pr *postgresRepository) ResolveRequest(ctx context.Context, requestID uuid.UUID) error {
-	// This query is also atomic.
+func (pr *postgresRepository) ResolveRequest(ctx context.Context, requestID, expertID uuid.UUID) error {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	// This query is also atomic. The expert is in the where clause, so one it was transferred away from can't resolve it.
 	query := `
 		UPDATE assistance_requests
 		SET status = 'resolved', resolved_at = $1
-		WHERE request_id = $2 AND status = 'active'
+		WHERE request_id = $2 AND status = 'active' AND expert_id = $3
 	`
-	res, err := pr.db.ExecContext(ctx, query, time.Now().UTC(), requestID)
+	res, err := pr.db.ExecContext(ctx, query, time.Now().UTC(), requestID, expertID)
 	if err != nil {
 		return fmt.Errorf("database error resolving request: %w", err)
 	}
@@ -142,14 +568,169 @@ func (pr *postgresRepository) ResolveRequest(ctx context.Context, requestID uuid
 		return fmt.Errorf("could not check rows affected: %w", err)
 	}
 	if rowsAffected == 0 {
-		return fmt.Errorf("request not found or was not active")
+		// Missing, not active and someone else's look the same from here. The service tells them apart.
+		return ErrRequestNotActive
+	}
+
+	return nil
+}
+
+// SetFirstResponse stamps first_response_at on an active request, but only the first time.
+// The IS NULL check is in the where clause, so two messages racing can't both write and the earliest call wins.
+// It reports whether this call was the one that set it.
+func (pr *postgresRepository) SetFirstResponse(ctx context.Context, requestID uuid.UUID, t time.Time) (bool, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	query := `
+		UPDATE assistance_requests
+		SET first_response_at = $1
+		WHERE request_id = $2 AND status = 'active' AND first_response_at IS NULL
+	`
+	res, err := pr.db.ExecContext(ctx, query, t.UTC(), requestID)
+	if err != nil {
+		return false, fmt.Errorf("database error setting first response: %w", err)
 	}
 
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("could not check rows affected: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
+
+// TouchRequestActivity records activity on an active request. It's our clock, not the message's, since the
+// idle TTL is measured against it. GREATEST keeps it from going backwards if two instances' clocks disagree,
+// and anything not active is left alone.
+func (pr *postgresRepository) TouchRequestActivity(ctx context.Context, requestID uuid.UUID) error {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	query := `
+		UPDATE assistance_requests
+		SET last_activity_at = GREATEST(last_activity_at, $1)
+		WHERE request_id = $2 AND status = 'active'
+	`
+	if _, err := pr.db.ExecContext(ctx, query, time.Now().UTC(), requestID); err != nil {
+		return fmt.Errorf("database error touching request: %w", err)
+	}
 	return nil
 }
 
+// ResolveIdleRequests resolves the quietest active requests in one statement. Requests from before
+// last_activity_at existed go by accepted_at. SKIP LOCKED lets resolvers on other instances take a different
+// batch instead of waiting, and status = 'active' is checked again on the update, so a request an expert
+// resolves or transfers at the same moment is never resolved twice or resolved out from under them.
+func (pr *postgresRepository) ResolveIdleRequests(ctx context.Context, idleSince time.Time, limit int) (int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	query := `
+		UPDATE assistance_requests
+		SET status = 'resolved', resolved_at = $1
+		WHERE status = 'active' AND request_id IN (
+			SELECT request_id
+			FROM assistance_requests
+			WHERE status = 'active' AND COALESCE(last_activity_at, accepted_at) < $2
+			ORDER BY COALESCE(last_activity_at, accepted_at)
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+	`
+	res, err := pr.db.ExecContext(ctx, query, time.Now().UTC(), idleSince.UTC(), limit)
+	if err != nil {
+		return 0, fmt.Errorf("database error resolving idle requests: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("could not check rows affected: %w", err)
+	}
+	return int(n), nil
+}
+
+// TransferRequest swaps the expert on an active request and writes the audit row in one transaction,
+// so there's never a transfer without its event or the other way round.
+// The where clause pins the old expert, so two transfers racing can't both win.
+func (pr *postgresRepository) TransferRequest(ctx context.Context, requestID, fromExpertID, toExpertID uuid.UUID, actorID uuid.UUID, actorRole string) (*domain.AssistanceRequest, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	tx, err := pr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin transfer transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op once committed.
+
+	// The new expert starts with a clean slate, so the request doesn't go idle on them straight away.
+	now := time.Now().UTC()
+	req, err := scanRequest(tx.QueryRowContext(ctx, `
+		UPDATE assistance_requests
+		SET expert_id = $1, last_activity_at = $4
+		WHERE request_id = $2 AND status = 'active' AND expert_id = $3
+		RETURNING `+requestColumns+`
+	`, toExpertID, requestID, fromExpertID, now))
+	if err != nil {
+		// Resolved or already transferred by someone else since the caller looked.
+		if err == sql.ErrNoRows {
+			return nil, ErrRequestNotActive
+		}
+		return nil, fmt.Errorf("database error transferring request: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO request_events
+			(event_id, request_id, event_type, actor_id, actor_role, from_expert_id, to_expert_id, created_at)
+		VALUES
+			($1, $2, 'transferred', $3, $4, $5, $6, $7)
+	`, uuid.New(), requestID, actorID, actorRole, fromExpertID, toExpertID, now)
+	if err != nil {
+		return nil, fmt.Errorf("could not record transfer event: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transfer: %w", err)
+	}
+	return req, nil
+}
+
+// ReopenRequest atomically moves a recently resolved request back to active, or to pending with
+// the expert cleared when they can't take it back. The status and window are both in the where clause.
+func (pr *postgresRepository) ReopenRequest(ctx context.Context, requestID uuid.UUID, resolvedAfter time.Time, keepExpert bool) (*domain.AssistanceRequest, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	query := `
+		UPDATE assistance_requests
+		SET status = CASE WHEN $1 THEN 'active' ELSE 'pending' END,
+			expert_id = CASE WHEN $1 THEN expert_id ELSE NULL END,
+			accepted_at = CASE WHEN $1 THEN accepted_at ELSE NULL END,
+			first_response_at = CASE WHEN $1 THEN first_response_at ELSE NULL END,
+			last_activity_at = CASE WHEN $1 THEN $4::timestamptz ELSE NULL END,
+			resolved_at = NULL
+		WHERE request_id = $2 AND status = 'resolved' AND resolved_at >= $3
+		RETURNING ` + requestColumns + `
+	`
+
+	req, err := scanRequest(pr.db.QueryRowContext(ctx, query, keepExpert, requestID, resolvedAfter, time.Now().UTC()))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrRequestNotResolved
+		}
+		// The user already opened a new request on this conversation, and only one can be open.
+		if database.IsUniqueViolation(err) {
+			return nil, ErrDuplicateRequest
+		}
+		return nil, fmt.Errorf("database error reopening request: %w", err)
+	}
+
+	return req, nil
+}
+
 // CreateRating inserts a new expert_ratings record.
 func (pr *postgresRepository) CreateRating(ctx context.Context, rating *domain.ExpertRating) error {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
 	rating.RatingID = uuid.New() // Set the primary key.
 	query := `
 		INSERT INTO expert_ratings
@@ -172,31 +753,283 @@ func (pr *postgresRepository) CreateRating(ctx context.Context, rating *domain.E
 
 // GetRequestByID fetches a single complete request by its primary key.
 func (pr *postgresRepository) GetRequestByID(ctx context.Context, requestID uuid.UUID) (*domain.AssistanceRequest, error) {
-	var req domain.AssistanceRequest
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
 	query := `
-		SELECT request_id, user_id, expert_id, status, llm_summary, twilio_conversation_sid, created_at, accepted_at, resolved_at
+		SELECT ` + requestColumns + `
 		FROM assistance_requests
 		WHERE request_id = $1
 	`
 
-	// This Scan call must match the query order and handle all the nullable fields (expert_id, accepted_at, resolved_at) which are defined as sql.NullTime/uuid.NullUUID in the domain.
-	err := pr.db.QueryRowContext(ctx, query, requestID).Scan(
-		&req.RequestID,
-		&req.UserID,
-		&req.ExpertID,
-		&req.Status,
-		&req.LLMSummary,
-		&req.TwilioConversationSID,
-		&req.CreatedAt,
-		&req.AcceptedAt,
-		&req.ResolvedAt,
-	)
+	req, err := scanRequest(pr.db.QueryRowContext(ctx, query, requestID))
 	if err != nil {
 		// Handle the case where no row was found
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("request not found")
+			return nil, ErrNotFound
 		}
 		return nil, fmt.Errorf("could not get request: %w", err)
 	}
-	return &req, nil
+	return req, nil
+}
+
+// UpdateSummary overwrites the llm_summary of a request that is still pending or active.
+func (pr *postgresRepository) UpdateSummary(ctx context.Context, requestID uuid.UUID, summary string) error {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	// The status check is in the where clause so a request resolved in the meantime isn't touched.
+	query := `
+		UPDATE assistance_requests
+		SET llm_summary = $1
+		WHERE request_id = $2 AND status IN ('pending', 'active')
+	`
+	res, err := pr.db.ExecContext(ctx, query, summary, requestID)
+	if err != nil {
+		return fmt.Errorf("database error updating summary: %w", err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrRequestNotOpen
+	}
+
+	return nil
+}
+
+// StreamRequestsByUser reads a user's full request history row by row and hands each one to fn.
+// If fn returns an error the iteration stops and that error is returned.
+// It doesn't get the query timeout: a long history takes as long as fn needs, and the caller's ctx still ends it.
+func (pr *postgresRepository) StreamRequestsByUser(ctx context.Context, userID uuid.UUID, fn func(*RequestExportRow) error) error {
+	// Both joins are LEFT joins since pending requests have no expert and most requests are never rated.
+	// The lateral join picks a single rating, so a request rated twice still only shows up once.
+	query := `
+		SELECT ar.request_id, ar.status, ar.created_at, ar.accepted_at, ar.resolved_at, e.display_name, er.score
+		FROM assistance_requests ar
+		LEFT JOIN experts e ON e.expert_id = ar.expert_id
+		LEFT JOIN LATERAL (
+			SELECT score FROM expert_ratings
+			WHERE request_id = ar.request_id AND user_id = ar.user_id
+			LIMIT 1
+		) er ON true
+		WHERE ar.user_id = $1
+		ORDER BY ar.created_at ASC
+	`
+
+	rows, err := pr.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return fmt.Errorf("could not query user requests: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			row        RequestExportRow
+			acceptedAt sql.NullTime
+			resolvedAt sql.NullTime
+			expertName sql.NullString
+			score      sql.NullInt32
+		)
+		if err := rows.Scan(&row.RequestID, &row.Status, &row.CreatedAt, &acceptedAt, &resolvedAt, &expertName, &score); err != nil {
+			return fmt.Errorf("could not scan user request: %w", err)
+		}
+
+		// The export uses nil instead of the sql.Null types so it reads cleanly as JSON and CSV.
+		if acceptedAt.Valid {
+			row.AcceptedAt = &acceptedAt.Time
+		}
+		if resolvedAt.Valid {
+			row.ResolvedAt = &resolvedAt.Time
+		}
+		if expertName.Valid {
+			row.ExpertDisplayName = &expertName.String
+		}
+		if score.Valid {
+			rating := int(score.Int32)
+			row.Rating = &rating
+		}
+
+		if err := fn(&row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// GetRequestStats computes everything in one aggregate query over the requests created in the window.
+// Time to resolve is measured from creation, since that's what the user waited.
+func (pr *postgresRepository) GetRequestStats(ctx context.Context, from, to time.Time) (*RequestStats, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	// The aggregates ignore NULLs, so unaccepted requests don't drag the averages down.
+	// COALESCE turns an empty window into zeros instead of NULLs.
+	query := `
+		SELECT
+			COUNT(*),
+			COUNT(accepted_at),
+			COUNT(resolved_at),
+			COUNT(*) FILTER (WHERE status = 'expired'),
+			COALESCE(AVG(EXTRACT(EPOCH FROM accepted_at - created_at)), 0)::float8,
+			COALESCE(percentile_cont(0.95) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM accepted_at - created_at)), 0)::float8,
+			COALESCE(AVG(EXTRACT(EPOCH FROM resolved_at - created_at)), 0)::float8,
+			COUNT(first_response_at),
+			COALESCE(AVG(EXTRACT(EPOCH FROM first_response_at - accepted_at)), 0)::float8,
+			COALESCE(percentile_cont(0.95) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM first_response_at - accepted_at)), 0)::float8
+		FROM assistance_requests
+		WHERE created_at >= $1 AND created_at < $2
+	`
+
+	stats := RequestStats{From: from, To: to}
+	err := pr.db.QueryRowContext(ctx, query, from, to).Scan(
+		&stats.TotalCreated,
+		&stats.Accepted,
+		&stats.Resolved,
+		&stats.Expired,
+		&stats.AvgTimeToAcceptSeconds,
+		&stats.P95TimeToAcceptSeconds,
+		&stats.AvgTimeToResolveSeconds,
+		&stats.Responded,
+		&stats.AvgTimeToFirstResponseSeconds,
+		&stats.P95TimeToFirstResponseSeconds,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not compute request stats: %w", err)
+	}
+	return &stats, nil
+}
+
+// SearchRequests builds the WHERE clause from whichever filters are set, always with placeholders, never by
+// pasting values into the SQL. It fetches one row more than the page to know whether there's another page,
+// which is cheaper than counting every match.
+func (pr *postgresRepository) SearchRequests(ctx context.Context, filter SearchFilter) (*SearchResult, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	var conditions []string
+	var args []any
+	where := func(cond string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(cond, len(args)))
+	}
+	if filter.Status != "" {
+		where("status = $%d", filter.Status)
+	}
+	if filter.UserID != uuid.Nil {
+		where("user_id = $%d", filter.UserID)
+	}
+	if filter.ExpertID != uuid.Nil {
+		where("expert_id = $%d", filter.ExpertID)
+	}
+	if !filter.CreatedFrom.IsZero() {
+		where("created_at >= $%d", filter.CreatedFrom)
+	}
+	if !filter.CreatedTo.IsZero() {
+		where("created_at < $%d", filter.CreatedTo)
+	}
+
+	query := `SELECT ` + requestColumns + ` FROM assistance_requests`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	// request_id breaks ties, so rows created in the same instant don't move between pages.
+	args = append(args, filter.Limit+1, filter.Offset)
+	query += fmt.Sprintf(" ORDER BY created_at DESC, request_id DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := pr.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not search requests: %w", err)
+	}
+	defer rows.Close()
+
+	result := &SearchResult{Requests: []*domain.AssistanceRequest{}, Limit: filter.Limit, Offset: filter.Offset}
+	for rows.Next() {
+		req, err := scanRequest(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan searched request: %w", err)
+		}
+		result.Requests = append(result.Requests, req)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not search requests: %w", err)
+	}
+
+	if len(result.Requests) > filter.Limit {
+		result.Requests = result.Requests[:filter.Limit]
+		next := filter.Offset + filter.Limit
+		result.NextOffset = &next
+	}
+	return result, nil
+}
+
+// ClaimOutboxEvents implements the interface. Claiming pushes next_attempt_at out by the lease in the same statement,
+// and SKIP LOCKED keeps two dispatchers from claiming the same rows.
+// If a dispatcher dies mid-batch, its events just become due again once the lease runs out.
+func (pr *postgresRepository) ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]*OutboxEvent, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	now := time.Now().UTC()
+	query := `
+		UPDATE outbox_events
+		SET next_attempt_at = $3
+		WHERE event_id IN (
+			SELECT event_id FROM outbox_events
+			WHERE sent_at IS NULL AND next_attempt_at <= $1
+			ORDER BY next_attempt_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING event_id, event_type, request_id, attempts, COALESCE(last_error, ''), created_at
+	`
+
+	rows, err := pr.db.QueryContext(ctx, query, now, limit, now.Add(lease))
+	if err != nil {
+		return nil, fmt.Errorf("could not claim outbox events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*OutboxEvent
+	for rows.Next() {
+		var ev OutboxEvent
+		if err := rows.Scan(&ev.EventID, &ev.EventType, &ev.RequestID, &ev.Attempts, &ev.LastError, &ev.CreatedAt); err != nil {
+			return nil, fmt.Errorf("could not scan outbox event: %w", err)
+		}
+		events = append(events, &ev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading outbox events: %w", err)
+	}
+	return events, nil
+}
+
+// MarkOutboxEventSent implements the interface.
+func (pr *postgresRepository) MarkOutboxEventSent(ctx context.Context, eventID uuid.UUID) error {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	query := `UPDATE outbox_events SET sent_at = $1, attempts = attempts + 1 WHERE event_id = $2`
+	if _, err := pr.db.ExecContext(ctx, query, time.Now().UTC(), eventID); err != nil {
+		return fmt.Errorf("could not mark outbox event sent: %w", err)
+	}
+	return nil
+}
+
+// MarkOutboxEventFailed implements the interface.
+func (pr *postgresRepository) MarkOutboxEventFailed(ctx context.Context, eventID uuid.UUID, lastError string, retryAt time.Time) error {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	query := `
+		UPDATE outbox_events
+		SET attempts = attempts + 1, last_error = $1, next_attempt_at = $2
+		WHERE event_id = $3
+	`
+	if _, err := pr.db.ExecContext(ctx, query, lastError, retryAt, eventID); err != nil {
+		return fmt.Errorf("could not mark outbox event failed: %w", err)
+	}
+	return nil
 }
@@ -13,6 +13,7 @@ import (
 	context "context"
 	domain "project-sage/internal/domain"
 	reflect "reflect"
+	time "time"
 
 	uuid "github.com/google/uuid"
 	gomock "go.uber.org/mock/gomock"
@@ -43,11 +44,12 @@ func (m *MockRepository) EXPECT() *MockRepositoryMockRecorder {
 }
 
 // AcceptRequest mocks base method.
-func (m *MockRepository) AcceptRequest(ctx context.Context, requestID, expertID uuid.UUID) error {
+func (m *MockRepository) AcceptRequest(ctx context.Context, requestID, expertID uuid.UUID) (*domain.AssistanceRequest, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "AcceptRequest", ctx, requestID, expertID)
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret0, _ := ret[0].(*domain.AssistanceRequest)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
 // AcceptRequest indicates an expected call of AcceptRequest.
@@ -56,6 +58,36 @@ func (mr *MockRepositoryMockRecorder) AcceptRequest(ctx, requestID, expertID any
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AcceptRequest", reflect.TypeOf((*MockRepository)(nil).AcceptRequest), ctx, requestID, expertID)
 }
 
+// ClaimNextRequest mocks base method.
+func (m *MockRepository) ClaimNextRequest(ctx context.Context, expertID uuid.UUID) (*domain.AssistanceRequest, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimNextRequest", ctx, expertID)
+	ret0, _ := ret[0].(*domain.AssistanceRequest)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimNextRequest indicates an expected call of ClaimNextRequest.
+func (mr *MockRepositoryMockRecorder) ClaimNextRequest(ctx, expertID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimNextRequest", reflect.TypeOf((*MockRepository)(nil).ClaimNextRequest), ctx, expertID)
+}
+
+// ClaimOutboxEvents mocks base method.
+func (m *MockRepository) ClaimOutboxEvents(ctx context.Context, limit int, lease time.Duration) ([]*OutboxEvent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimOutboxEvents", ctx, limit, lease)
+	ret0, _ := ret[0].([]*OutboxEvent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimOutboxEvents indicates an expected call of ClaimOutboxEvents.
+func (mr *MockRepositoryMockRecorder) ClaimOutboxEvents(ctx, limit, lease any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimOutboxEvents", reflect.TypeOf((*MockRepository)(nil).ClaimOutboxEvents), ctx, limit, lease)
+}
+
 // CreateRating mocks base method.
 func (m *MockRepository) CreateRating(ctx context.Context, rating *domain.ExpertRating) error {
 	m.ctrl.T.Helper()
@@ -71,32 +103,62 @@ func (mr *MockRepositoryMockRecorder) CreateRating(ctx, rating any) *gomock.Call
 }
 
 // CreateRequest mocks base method.
-func (m *MockRepository) CreateRequest(ctx context.Context, req *domain.AssistanceRequest) error {
+func (m *MockRepository) CreateRequest(ctx context.Context, req *domain.AssistanceRequest, events []string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateRequest", ctx, req)
+	ret := m.ctrl.Call(m, "CreateRequest", ctx, req, events)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // CreateRequest indicates an expected call of CreateRequest.
-func (mr *MockRepositoryMockRecorder) CreateRequest(ctx, req any) *gomock.Call {
+func (mr *MockRepositoryMockRecorder) CreateRequest(ctx, req, events any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateRequest", reflect.TypeOf((*MockRepository)(nil).CreateRequest), ctx, req, events)
+}
+
+// GetOpenRequestBySID mocks base method.
+func (m *MockRepository) GetOpenRequestBySID(ctx context.Context, twilioSID string) (*domain.AssistanceRequest, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOpenRequestBySID", ctx, twilioSID)
+	ret0, _ := ret[0].(*domain.AssistanceRequest)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOpenRequestBySID indicates an expected call of GetOpenRequestBySID.
+func (mr *MockRepositoryMockRecorder) GetOpenRequestBySID(ctx, twilioSID any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateRequest", reflect.TypeOf((*MockRepository)(nil).CreateRequest), ctx, req)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOpenRequestBySID", reflect.TypeOf((*MockRepository)(nil).GetOpenRequestBySID), ctx, twilioSID)
+}
+
+// GetOpenRequestByUser mocks base method.
+func (m *MockRepository) GetOpenRequestByUser(ctx context.Context, userID uuid.UUID) (*domain.AssistanceRequest, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOpenRequestByUser", ctx, userID)
+	ret0, _ := ret[0].(*domain.AssistanceRequest)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOpenRequestByUser indicates an expected call of GetOpenRequestByUser.
+func (mr *MockRepositoryMockRecorder) GetOpenRequestByUser(ctx, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOpenRequestByUser", reflect.TypeOf((*MockRepository)(nil).GetOpenRequestByUser), ctx, userID)
 }
 
 // GetPendingRequests mocks base method.
-func (m *MockRepository) GetPendingRequests(ctx context.Context) ([]*domain.AssistanceRequest, error) {
+func (m *MockRepository) GetPendingRequests(ctx context.Context, expertID uuid.UUID, now time.Time) ([]*QueuedRequest, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetPendingRequests", ctx)
-	ret0, _ := ret[0].([]*domain.AssistanceRequest)
+	ret := m.ctrl.Call(m, "GetPendingRequests", ctx, expertID, now)
+	ret0, _ := ret[0].([]*QueuedRequest)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetPendingRequests indicates an expected call of GetPendingRequests.
-func (mr *MockRepositoryMockRecorder) GetPendingRequests(ctx any) *gomock.Call {
+func (mr *MockRepositoryMockRecorder) GetPendingRequests(ctx, expertID, now any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPendingRequests", reflect.TypeOf((*MockRepository)(nil).GetPendingRequests), ctx)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPendingRequests", reflect.TypeOf((*MockRepository)(nil).GetPendingRequests), ctx, expertID, now)
 }
 
 // GetRequestByID mocks base method.
@@ -114,16 +176,234 @@ func (mr *MockRepositoryMockRecorder) GetRequestByID(ctx, requestID any) *gomock
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRequestByID", reflect.TypeOf((*MockRepository)(nil).GetRequestByID), ctx, requestID)
 }
 
+// GetRequestByTwilioSID mocks base method.
+func (m *MockRepository) GetRequestByTwilioSID(ctx context.Context, twilioSID string) (*domain.AssistanceRequest, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRequestByTwilioSID", ctx, twilioSID)
+	ret0, _ := ret[0].(*domain.AssistanceRequest)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRequestByTwilioSID indicates an expected call of GetRequestByTwilioSID.
+func (mr *MockRepositoryMockRecorder) GetRequestByTwilioSID(ctx, twilioSID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRequestByTwilioSID", reflect.TypeOf((*MockRepository)(nil).GetRequestByTwilioSID), ctx, twilioSID)
+}
+
+// GetRequestStats mocks base method.
+func (m *MockRepository) GetRequestStats(ctx context.Context, from, to time.Time) (*RequestStats, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRequestStats", ctx, from, to)
+	ret0, _ := ret[0].(*RequestStats)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRequestStats indicates an expected call of GetRequestStats.
+func (mr *MockRepositoryMockRecorder) GetRequestStats(ctx, from, to any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRequestStats", reflect.TypeOf((*MockRepository)(nil).GetRequestStats), ctx, from, to)
+}
+
+// HasOpenRequest mocks base method.
+func (m *MockRepository) HasOpenRequest(ctx context.Context, userID uuid.UUID) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HasOpenRequest", ctx, userID)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// HasOpenRequest indicates an expected call of HasOpenRequest.
+func (mr *MockRepositoryMockRecorder) HasOpenRequest(ctx, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HasOpenRequest", reflect.TypeOf((*MockRepository)(nil).HasOpenRequest), ctx, userID)
+}
+
+// MarkOutboxEventFailed mocks base method.
+func (m *MockRepository) MarkOutboxEventFailed(ctx context.Context, eventID uuid.UUID, lastError string, retryAt time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkOutboxEventFailed", ctx, eventID, lastError, retryAt)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkOutboxEventFailed indicates an expected call of MarkOutboxEventFailed.
+func (mr *MockRepositoryMockRecorder) MarkOutboxEventFailed(ctx, eventID, lastError, retryAt any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkOutboxEventFailed", reflect.TypeOf((*MockRepository)(nil).MarkOutboxEventFailed), ctx, eventID, lastError, retryAt)
+}
+
+// MarkOutboxEventSent mocks base method.
+func (m *MockRepository) MarkOutboxEventSent(ctx context.Context, eventID uuid.UUID) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkOutboxEventSent", ctx, eventID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MarkOutboxEventSent indicates an expected call of MarkOutboxEventSent.
+func (mr *MockRepositoryMockRecorder) MarkOutboxEventSent(ctx, eventID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkOutboxEventSent", reflect.TypeOf((*MockRepository)(nil).MarkOutboxEventSent), ctx, eventID)
+}
+
+// ReopenRequest mocks base method.
+func (m *MockRepository) ReopenRequest(ctx context.Context, requestID uuid.UUID, resolvedAfter time.Time, keepExpert bool) (*domain.AssistanceRequest, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReopenRequest", ctx, requestID, resolvedAfter, keepExpert)
+	ret0, _ := ret[0].(*domain.AssistanceRequest)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReopenRequest indicates an expected call of ReopenRequest.
+func (mr *MockRepositoryMockRecorder) ReopenRequest(ctx, requestID, resolvedAfter, keepExpert any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReopenRequest", reflect.TypeOf((*MockRepository)(nil).ReopenRequest), ctx, requestID, resolvedAfter, keepExpert)
+}
+
+// ReserveRequest mocks base method.
+func (m *MockRepository) ReserveRequest(ctx context.Context, requestID, expertID uuid.UUID, now, until time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReserveRequest", ctx, requestID, expertID, now, until)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReserveRequest indicates an expected call of ReserveRequest.
+func (mr *MockRepositoryMockRecorder) ReserveRequest(ctx, requestID, expertID, now, until any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReserveRequest", reflect.TypeOf((*MockRepository)(nil).ReserveRequest), ctx, requestID, expertID, now, until)
+}
+
+// ResolveIdleRequests mocks base method.
+func (m *MockRepository) ResolveIdleRequests(ctx context.Context, idleSince time.Time, limit int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResolveIdleRequests", ctx, idleSince, limit)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ResolveIdleRequests indicates an expected call of ResolveIdleRequests.
+func (mr *MockRepositoryMockRecorder) ResolveIdleRequests(ctx, idleSince, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResolveIdleRequests", reflect.TypeOf((*MockRepository)(nil).ResolveIdleRequests), ctx, idleSince, limit)
+}
+
 // ResolveRequest mocks base method.
-func (m *MockRepository) ResolveRequest(ctx context.Context, requestID uuid.UUID) error {
+func (m *MockRepository) ResolveRequest(ctx context.Context, requestID, expertID uuid.UUID) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ResolveRequest", ctx, requestID)
+	ret := m.ctrl.Call(m, "ResolveRequest", ctx, requestID, expertID)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // ResolveRequest indicates an expected call of ResolveRequest.
-func (mr *MockRepositoryMockRecorder) ResolveRequest(ctx, requestID any) *gomock.Call {
+func (mr *MockRepositoryMockRecorder) ResolveRequest(ctx, requestID, expertID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResolveRequest", reflect.TypeOf((*MockRepository)(nil).ResolveRequest), ctx, requestID, expertID)
+}
+
+// RevertToPending mocks base method.
+func (m *MockRepository) RevertToPending(ctx context.Context, requestID, expertID uuid.UUID) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevertToPending", ctx, requestID, expertID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevertToPending indicates an expected call of RevertToPending.
+func (mr *MockRepositoryMockRecorder) RevertToPending(ctx, requestID, expertID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevertToPending", reflect.TypeOf((*MockRepository)(nil).RevertToPending), ctx, requestID, expertID)
+}
+
+// SearchRequests mocks base method.
+func (m *MockRepository) SearchRequests(ctx context.Context, filter SearchFilter) (*SearchResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SearchRequests", ctx, filter)
+	ret0, _ := ret[0].(*SearchResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SearchRequests indicates an expected call of SearchRequests.
+func (mr *MockRepositoryMockRecorder) SearchRequests(ctx, filter any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchRequests", reflect.TypeOf((*MockRepository)(nil).SearchRequests), ctx, filter)
+}
+
+// SetFirstResponse mocks base method.
+func (m *MockRepository) SetFirstResponse(ctx context.Context, requestID uuid.UUID, t time.Time) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetFirstResponse", ctx, requestID, t)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetFirstResponse indicates an expected call of SetFirstResponse.
+func (mr *MockRepositoryMockRecorder) SetFirstResponse(ctx, requestID, t any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetFirstResponse", reflect.TypeOf((*MockRepository)(nil).SetFirstResponse), ctx, requestID, t)
+}
+
+// StreamRequestsByUser mocks base method.
+func (m *MockRepository) StreamRequestsByUser(ctx context.Context, userID uuid.UUID, fn func(*RequestExportRow) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StreamRequestsByUser", ctx, userID, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// StreamRequestsByUser indicates an expected call of StreamRequestsByUser.
+func (mr *MockRepositoryMockRecorder) StreamRequestsByUser(ctx, userID, fn any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StreamRequestsByUser", reflect.TypeOf((*MockRepository)(nil).StreamRequestsByUser), ctx, userID, fn)
+}
+
+// TouchRequestActivity mocks base method.
+func (m *MockRepository) TouchRequestActivity(ctx context.Context, requestID uuid.UUID) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TouchRequestActivity", ctx, requestID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TouchRequestActivity indicates an expected call of TouchRequestActivity.
+func (mr *MockRepositoryMockRecorder) TouchRequestActivity(ctx, requestID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TouchRequestActivity", reflect.TypeOf((*MockRepository)(nil).TouchRequestActivity), ctx, requestID)
+}
+
+// TransferRequest mocks base method.
+func (m *MockRepository) TransferRequest(ctx context.Context, requestID, fromExpertID, toExpertID, actorID uuid.UUID, actorRole string) (*domain.AssistanceRequest, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TransferRequest", ctx, requestID, fromExpertID, toExpertID, actorID, actorRole)
+	ret0, _ := ret[0].(*domain.AssistanceRequest)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TransferRequest indicates an expected call of TransferRequest.
+func (mr *MockRepositoryMockRecorder) TransferRequest(ctx, requestID, fromExpertID, toExpertID, actorID, actorRole any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TransferRequest", reflect.TypeOf((*MockRepository)(nil).TransferRequest), ctx, requestID, fromExpertID, toExpertID, actorID, actorRole)
+}
+
+// UpdateSummary mocks base method.
+func (m *MockRepository) UpdateSummary(ctx context.Context, requestID uuid.UUID, summary string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateSummary", ctx, requestID, summary)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateSummary indicates an expected call of UpdateSummary.
+func (mr *MockRepositoryMockRecorder) UpdateSummary(ctx, requestID, summary any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResolveRequest", reflect.TypeOf((*MockRepository)(nil).ResolveRequest), ctx, requestID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateSummary", reflect.TypeOf((*MockRepository)(nil).UpdateSummary), ctx, requestID, summary)
 }
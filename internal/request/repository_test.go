@@ -3,9 +3,12 @@ package request
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"project-sage/internal/domain" // The shared domain models
+	"sync"
 	"testing"
 	"time"
 
@@ -126,7 +129,7 @@ func createTestRequest(ctx context.Context, twilioSid string) (*domain.Assistanc
 		LLMSummary:            "Test summary",
 		TwilioConversationSID: twilioSid,
 	}
-	err := testRepo.CreateRequest(ctx, req)
+	err := testRepo.CreateRequest(ctx, req, nil)
 	return req, err
 }
 
@@ -174,7 +177,7 @@ func TestGetRequestByID_NotFound(t *testing.T) {
 	if err == nil {
 		t.Fatal("Expected an error for non-existent request, but got nil")
 	}
-	if err.Error() != "request not found" {
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("Expected 'request not found', got '%v'", err)
 	}
 }
@@ -190,15 +193,17 @@ func TestRequestLifecycle(t *testing.T) {
 		t.Fatalf("Failed to create test request: %v", err)
 	}
 
-	// Accept the request.
-	err = testRepo.AcceptRequest(ctx, req.RequestID, testExpert.ExpertID)
+	// Accept the request. The updated row comes straight back from the UPDATE.
+	activeReq, err := testRepo.AcceptRequest(ctx, req.RequestID, testExpert.ExpertID)
 
 	if err != nil {
 		t.Fatalf("AcceptRequest() returned error: %v", err)
 	}
 
 	// Verify it's active and has the experts ID.
-	activeReq, _ := testRepo.GetRequestByID(ctx, req.RequestID)
+	if activeReq.TwilioConversationSID != "twil-lifecycle-456" {
+		t.Errorf("Expected twilio SID 'twil-lifecycle-456', got '%s'", activeReq.TwilioConversationSID)
+	}
 	if activeReq.Status != "active" {
 		t.Errorf("Expected status 'active', got '%s'", activeReq.Status)
 	}
@@ -209,8 +214,13 @@ func TestRequestLifecycle(t *testing.T) {
 		t.Error("Expected AcceptedAt to be set, but it was null")
 	}
 
+	// Another expert can't resolve it.
+	if err := testRepo.ResolveRequest(ctx, req.RequestID, uuid.New()); !errors.Is(err, ErrRequestNotActive) {
+		t.Errorf("Expected ErrRequestNotActive for another expert, got %v", err)
+	}
+
 	// Resolve the request.
-	err = testRepo.ResolveRequest(ctx, req.RequestID)
+	err = testRepo.ResolveRequest(ctx, req.RequestID, testExpert.ExpertID)
 
 	if err != nil {
 		t.Fatalf("ResolveRequest() returned error: %v", err)
@@ -224,6 +234,11 @@ func TestRequestLifecycle(t *testing.T) {
 	if !resolvedReq.ResolvedAt.Valid {
 		t.Error("Expected ResolvedAt to be set, but it was null")
 	}
+
+	// It isn't active any more, so a second resolve is refused.
+	if err := testRepo.ResolveRequest(ctx, req.RequestID, testExpert.ExpertID); !errors.Is(err, ErrRequestNotActive) {
+		t.Errorf("Expected ErrRequestNotActive resolving twice, got %v", err)
+	}
 }
 
 // TestGetPendingRequests verifies the expert queue logic.
@@ -239,10 +254,10 @@ func TestGetPendingRequests(t *testing.T) {
 	req3, _ := createTestRequest(ctx, "twil-p-3")
 
 	// Accept one of them, so it's no longer pending.
-	_ = testRepo.AcceptRequest(ctx, req2.RequestID, testExpert.ExpertID)
+	_, _ = testRepo.AcceptRequest(ctx, req2.RequestID, testExpert.ExpertID)
 
 	// Fetch the pending queue.
-	pending, err := testRepo.GetPendingRequests(ctx)
+	pending, err := testRepo.GetPendingRequests(ctx, testExpert.ExpertID, time.Now().UTC())
 
 	if err != nil {
 		t.Fatalf("GetPendingRequests() returned error: %v", err)
@@ -262,6 +277,69 @@ func TestGetPendingRequests(t *testing.T) {
 	}
 }
 
+// TestReserveRequest_Expiry checks a reservation hides the request from other experts, blocks their accepts
+// and claims, and stops counting once it runs out.
+func TestReserveRequest_Expiry(t *testing.T) {
+	cleanRequestTables()
+	ctx := context.Background()
+
+	held, _ := createTestRequest(ctx, "twil-res-1")
+	time.Sleep(10 * time.Millisecond)
+	free, _ := createTestRequest(ctx, "twil-res-2")
+
+	// Reservations aren't tied to the experts table, so any id can hold one.
+	otherID := uuid.New()
+	now := time.Now().UTC()
+	if err := testRepo.ReserveRequest(ctx, held.RequestID, otherID, now, now.Add(time.Minute)); err != nil {
+		t.Fatalf("ReserveRequest() returned error: %v", err)
+	}
+
+	// The holder still sees it, marked as theirs. Everyone else doesn't.
+	theirs, err := testRepo.GetPendingRequests(ctx, otherID, now)
+	if err != nil || len(theirs) != 2 || theirs[0].ReservedUntil == nil || theirs[1].ReservedUntil != nil {
+		t.Fatalf("Expected the holder to see both requests with the first marked, got %+v, %v", theirs, err)
+	}
+	ours, err := testRepo.GetPendingRequests(ctx, testExpert.ExpertID, now)
+	if err != nil || len(ours) != 1 || ours[0].RequestID != free.RequestID {
+		t.Fatalf("Expected only the free request, got %+v, %v", ours, err)
+	}
+
+	if err := testRepo.ReserveRequest(ctx, held.RequestID, testExpert.ExpertID, now, now.Add(time.Minute)); !errors.Is(err, ErrRequestReserved) {
+		t.Errorf("Expected ErrRequestReserved reserving someone else's request, got %v", err)
+	}
+	if _, err := testRepo.AcceptRequest(ctx, held.RequestID, testExpert.ExpertID); !errors.Is(err, ErrRequestAlreadyAccepted) {
+		t.Errorf("Expected the accept to be refused, got %v", err)
+	}
+	// Claiming skips the older, reserved request.
+	claimed, err := testRepo.ClaimNextRequest(ctx, testExpert.ExpertID)
+	if err != nil || claimed.RequestID != free.RequestID {
+		t.Fatalf("Expected to claim the free request, got %+v, %v", claimed, err)
+	}
+
+	// Two minutes on, the reservation has run out and the request is back for everyone.
+	later := now.Add(2 * time.Minute)
+	ours, err = testRepo.GetPendingRequests(ctx, testExpert.ExpertID, later)
+	if err != nil || len(ours) != 1 || ours[0].RequestID != held.RequestID {
+		t.Fatalf("Expected the expired reservation back in the queue, got %+v, %v", ours, err)
+	}
+	if theirs, _ := testRepo.GetPendingRequests(ctx, otherID, later); len(theirs) != 1 || theirs[0].ReservedUntil != nil {
+		t.Errorf("Expected the old holder to see it unmarked, got %+v", theirs)
+	}
+
+	// Accepting uses the real clock, so wind the reservation back rather than waiting for it.
+	if _, err := testDB.Exec("UPDATE assistance_requests SET reserved_until = now() - interval '1 second' WHERE request_id = $1", held.RequestID); err != nil {
+		t.Fatalf("Could not expire the reservation: %v", err)
+	}
+	if _, err := testRepo.AcceptRequest(ctx, held.RequestID, testExpert.ExpertID); err != nil {
+		t.Fatalf("Expected the accept to go through once the reservation expired, got %v", err)
+	}
+	var reservedBy uuid.NullUUID
+	testDB.QueryRow("SELECT reserved_by FROM assistance_requests WHERE request_id = $1", held.RequestID).Scan(&reservedBy)
+	if reservedBy.Valid {
+		t.Errorf("Expected the accept to clear the reservation, got %v", reservedBy.UUID)
+	}
+}
+
 // TestAcceptRequest_Concurrency verifies that a request can't be accepted more than once.
 func TestAcceptRequest_Concurrency(t *testing.T) {
 	cleanRequestTables()
@@ -269,31 +347,74 @@ func TestAcceptRequest_Concurrency(t *testing.T) {
 	req, _ := createTestRequest(ctx, "twil-concur-789")
 
 	// Accept it the first time.
-	err := testRepo.AcceptRequest(ctx, req.RequestID, testExpert.ExpertID)
+	_, err := testRepo.AcceptRequest(ctx, req.RequestID, testExpert.ExpertID)
 	if err != nil {
 		t.Fatalf("First accept failed: %v", err)
 	}
+	// The user's notification is queued with it.
+	events, err := testRepo.ClaimOutboxEvents(ctx, 10, time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimOutboxEvents() returned error: %v", err)
+	}
+	if len(events) != 1 || events[0].EventType != OutboxNotifyUser || events[0].RequestID != req.RequestID {
+		t.Errorf("Expected one notify_user event for the request, got %+v", events)
+	}
 
 	// Try to accept it again.
-	err = testRepo.AcceptRequest(ctx, req.RequestID, testExpert.ExpertID)
+	_, err = testRepo.AcceptRequest(ctx, req.RequestID, testExpert.ExpertID)
 
 	// This should fail with a specific error.
 	if err == nil {
 		t.Fatal("Expected an error for double-accept, but got nil")
 	}
-	if err.Error() != "request not found or was already accepted" {
+	if !errors.Is(err, ErrRequestAlreadyAccepted) {
 		t.Errorf("Expected '...already accepted' error, got '%v'", err)
 	}
 }
 
+// TestRevertToPending checks an accepted request goes back to pending and unassigned, another expert can then take
+// it, and a revert for an expert who no longer has it does nothing.
+func TestRevertToPending(t *testing.T) {
+	cleanRequestTables()
+	ctx := context.Background()
+	req, _ := createTestRequest(ctx, "twil-revert-202")
+
+	if _, err := testRepo.AcceptRequest(ctx, req.RequestID, testExpert.ExpertID); err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	if err := testRepo.RevertToPending(ctx, req.RequestID, testExpert.ExpertID); err != nil {
+		t.Fatalf("RevertToPending() returned error: %v", err)
+	}
+	reverted, err := testRepo.GetRequestByID(ctx, req.RequestID)
+	if err != nil {
+		t.Fatalf("GetRequestByID() returned error: %v", err)
+	}
+	if reverted.Status != "pending" || reverted.ExpertID.Valid || reverted.AcceptedAt.Valid {
+		t.Errorf("Expected pending with the expert cleared, got %+v", reverted)
+	}
+
+	// The accept's notification went with it, since nobody joined.
+	if events, err := testRepo.ClaimOutboxEvents(ctx, 10, time.Minute); err != nil || len(events) != 0 {
+		t.Errorf("Expected no outbox events after the revert, got %d, %v", len(events), err)
+	}
+
+	// It's pending again, so it can be accepted again.
+	if _, err := testRepo.AcceptRequest(ctx, req.RequestID, testExpert.ExpertID); err != nil {
+		t.Fatalf("Accept after revert failed: %v", err)
+	}
+	if err := testRepo.RevertToPending(ctx, req.RequestID, uuid.New()); !errors.Is(err, ErrRequestNotActive) {
+		t.Errorf("Expected ErrRequestNotActive for another expert, got: %v", err)
+	}
+}
+
 // TestCreateRating verifies a rating can be inserted.
 func TestCreateRating(t *testing.T) {
 	cleanRequestTables()
 	ctx := context.Background()
 	// Create a full request lifecycle first.
 	req, _ := createTestRequest(ctx, "twil-rating-101")
-	_ = testRepo.AcceptRequest(ctx, req.RequestID, testExpert.ExpertID)
-	_ = testRepo.ResolveRequest(ctx, req.RequestID)
+	_, _ = testRepo.AcceptRequest(ctx, req.RequestID, testExpert.ExpertID)
+	_ = testRepo.ResolveRequest(ctx, req.RequestID, testExpert.ExpertID)
 
 	// Define the rating.
 	rating := &domain.ExpertRating{
@@ -324,3 +445,608 @@ func TestCreateRating(t *testing.T) {
 		t.Errorf("Expected score 5, got %d", score)
 	}
 }
+
+// TestCreateRequest_DuplicateOpenSID verifies the partial unique index: one open request per conversation.
+func TestCreateRequest_DuplicateOpenSID(t *testing.T) {
+	cleanRequestTables()
+	ctx := context.Background()
+
+	first, err := createTestRequest(ctx, "twil-dup-202")
+	if err != nil {
+		t.Fatalf("Failed to create first request: %v", err)
+	}
+
+	// Same conversation while the first is still pending.
+	_, err = createTestRequest(ctx, "twil-dup-202")
+	if !errors.Is(err, ErrDuplicateRequest) {
+		t.Fatalf("Expected ErrDuplicateRequest, got: %v", err)
+	}
+
+	// The open one can be found by its SID.
+	open, err := testRepo.GetOpenRequestBySID(ctx, "twil-dup-202")
+	if err != nil {
+		t.Fatalf("GetOpenRequestBySID() returned error: %v", err)
+	}
+	if open.RequestID != first.RequestID {
+		t.Errorf("Expected open request %v, got %v", first.RequestID, open.RequestID)
+	}
+
+	// Once it's resolved, the conversation can get a new request.
+	_, _ = testRepo.AcceptRequest(ctx, first.RequestID, testExpert.ExpertID)
+	_ = testRepo.ResolveRequest(ctx, first.RequestID, testExpert.ExpertID)
+	if _, err := createTestRequest(ctx, "twil-dup-202"); err != nil {
+		t.Errorf("Expected a new request after resolve to succeed, got: %v", err)
+	}
+}
+
+// TestStreamRequestsByUser verifies the export join: expert name and rating for a rated request, nils for a pending one.
+func TestStreamRequestsByUser(t *testing.T) {
+	cleanRequestTables()
+	ctx := context.Background()
+
+	// One resolved and rated request, then one still pending.
+	rated, _ := createTestRequest(ctx, "twil-export-101")
+	_, _ = testRepo.AcceptRequest(ctx, rated.RequestID, testExpert.ExpertID)
+	_ = testRepo.ResolveRequest(ctx, rated.RequestID, testExpert.ExpertID)
+	_ = testRepo.CreateRating(ctx, &domain.ExpertRating{
+		RequestID: rated.RequestID,
+		UserID:    testUser.UserID,
+		ExpertID:  testExpert.ExpertID,
+		Score:     4,
+	})
+	pending, _ := createTestRequest(ctx, "twil-export-102")
+
+	var rows []*RequestExportRow
+	err := testRepo.StreamRequestsByUser(ctx, testUser.UserID, func(row *RequestExportRow) error {
+		rows = append(rows, row)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamRequestsByUser() returned error: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 rows, got %d", len(rows))
+	}
+
+	// Oldest first.
+	first, second := rows[0], rows[1]
+	if first.RequestID != rated.RequestID || second.RequestID != pending.RequestID {
+		t.Fatalf("Rows are not in creation order")
+	}
+	if first.ExpertDisplayName == nil || *first.ExpertDisplayName != testExpert.DisplayName {
+		t.Errorf("Expected expert name %q, got %v", testExpert.DisplayName, first.ExpertDisplayName)
+	}
+	if first.Rating == nil || *first.Rating != 4 {
+		t.Errorf("Expected rating 4, got %v", first.Rating)
+	}
+	if first.AcceptedAt == nil || first.ResolvedAt == nil {
+		t.Error("Expected accepted_at and resolved_at to be set on the resolved request")
+	}
+	if second.ExpertDisplayName != nil || second.Rating != nil || second.AcceptedAt != nil {
+		t.Error("Expected the pending request to have no expert, rating or accepted_at")
+	}
+}
+
+// insertRequestAt inserts a request with fixed timestamps, so the stats are known in advance.
+func insertRequestAt(t *testing.T, sid, status string, createdAt time.Time, acceptedAt, resolvedAt *time.Time) {
+	t.Helper()
+	var expertID uuid.NullUUID
+	if acceptedAt != nil {
+		expertID = uuid.NullUUID{UUID: testExpert.ExpertID, Valid: true}
+	}
+	_, err := testDB.Exec(`
+		INSERT INTO assistance_requests
+			(request_id, user_id, expert_id, status, llm_summary, twilio_conversation_sid, created_at, accepted_at, resolved_at)
+		VALUES ($1, $2, $3, $4, 'Test summary', $5, $6, $7, $8)
+	`, uuid.New(), testUser.UserID, expertID, status, sid, createdAt, acceptedAt, resolvedAt)
+	if err != nil {
+		t.Fatalf("Failed to seed request %s: %v", sid, err)
+	}
+}
+
+// TestGetRequestStats seeds requests at known times and checks the aggregates, including that
+// requests outside the window are ignored.
+func TestGetRequestStats(t *testing.T) {
+	cleanRequestTables()
+	ctx := context.Background()
+
+	day := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time { ts := day.Add(d); return &ts }
+
+	// Accepted after 60s and resolved 600s after creation.
+	insertRequestAt(t, "twil-stats-1", "resolved", day, at(60*time.Second), at(600*time.Second))
+	// Accepted after 120s, still active.
+	insertRequestAt(t, "twil-stats-2", "active", day.Add(time.Hour), at(time.Hour+120*time.Second), nil)
+	// Never accepted.
+	insertRequestAt(t, "twil-stats-3", "pending", day.Add(2*time.Hour), nil, nil)
+	// The next day, outside the window.
+	insertRequestAt(t, "twil-stats-4", "active", day.Add(25*time.Hour), at(25*time.Hour+time.Hour), nil)
+
+	stats, err := testRepo.GetRequestStats(ctx, day, day.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("GetRequestStats() returned error: %v", err)
+	}
+
+	if stats.TotalCreated != 3 || stats.Accepted != 2 || stats.Resolved != 1 || stats.Expired != 0 {
+		t.Errorf("Unexpected counts: %+v", stats)
+	}
+	if stats.AvgTimeToAcceptSeconds != 90 {
+		t.Errorf("Expected avg time to accept 90s, got %v", stats.AvgTimeToAcceptSeconds)
+	}
+	// percentile_cont interpolates between 60 and 120: 60 + 0.95*60.
+	if stats.P95TimeToAcceptSeconds < 116.9 || stats.P95TimeToAcceptSeconds > 117.1 {
+		t.Errorf("Expected p95 time to accept 117s, got %v", stats.P95TimeToAcceptSeconds)
+	}
+	if stats.AvgTimeToResolveSeconds != 600 {
+		t.Errorf("Expected avg time to resolve 600s, got %v", stats.AvgTimeToResolveSeconds)
+	}
+}
+
+// TestGetRequestStats_EmptyWindow verifies an empty window gives zeros, not a NULL scan error.
+func TestGetRequestStats_EmptyWindow(t *testing.T) {
+	cleanRequestTables()
+	ctx := context.Background()
+
+	from := time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC)
+	stats, err := testRepo.GetRequestStats(ctx, from, from.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("GetRequestStats() returned error: %v", err)
+	}
+	if stats.TotalCreated != 0 || stats.AvgTimeToAcceptSeconds != 0 || stats.P95TimeToAcceptSeconds != 0 || stats.AvgTimeToResolveSeconds != 0 {
+		t.Errorf("Expected all zeros, got %+v", stats)
+	}
+}
+
+// TestSearchRequests seeds requests across two users, statuses and days, and checks combinations of filters and paging.
+func TestSearchRequests(t *testing.T) {
+	cleanRequestTables()
+	ctx := context.Background()
+
+	// A second user, so the user filter has something to leave out.
+	otherUserID := uuid.New()
+	_, err := testDB.Exec(`
+		INSERT INTO users (user_id, firebase_auth_id, display_name, membership_tier, assistance_token_balance, role)
+		VALUES ($1, 'fb-req-test-search', 'Search Test User', 'free', 0, 'user')
+	`, otherUserID)
+	if err != nil {
+		t.Fatalf("Failed to insert second user: %v", err)
+	}
+	defer cleanRequestTables()
+
+	day := time.Date(2002, 3, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time { ts := day.Add(d); return &ts }
+	insertRequestAt(t, "twil-search-1", "resolved", day, at(time.Minute), at(time.Hour))
+	insertRequestAt(t, "twil-search-2", "active", day.Add(time.Hour), at(2*time.Hour), nil)
+	insertRequestAt(t, "twil-search-3", "pending", day.Add(24*time.Hour), nil, nil)
+	_, err = testDB.Exec(`
+		INSERT INTO assistance_requests (request_id, user_id, status, llm_summary, twilio_conversation_sid, created_at)
+		VALUES ($1, $2, 'pending', 'Test summary', 'twil-search-4', $3)
+	`, uuid.New(), otherUserID, day.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to seed other user's request: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		filter SearchFilter
+		want   []string // SIDs, newest first
+	}{
+		{"everything", SearchFilter{}, []string{"twil-search-3", "twil-search-4", "twil-search-2", "twil-search-1"}},
+		{"status", SearchFilter{Status: "pending"}, []string{"twil-search-3", "twil-search-4"}},
+		{"user", SearchFilter{UserID: otherUserID}, []string{"twil-search-4"}},
+		{"expert", SearchFilter{ExpertID: testExpert.ExpertID}, []string{"twil-search-2", "twil-search-1"}},
+		{"date range", SearchFilter{CreatedFrom: day.Add(time.Hour), CreatedTo: day.Add(24 * time.Hour)}, []string{"twil-search-4", "twil-search-2"}},
+		{"status and user", SearchFilter{Status: "pending", UserID: testUser.UserID}, []string{"twil-search-3"}},
+		{"expert and date", SearchFilter{ExpertID: testExpert.ExpertID, CreatedFrom: day.Add(time.Minute)}, []string{"twil-search-2"}},
+		{"no match", SearchFilter{Status: "expired"}, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.filter.Limit = 10
+			result, err := testRepo.SearchRequests(ctx, tc.filter)
+			if err != nil {
+				t.Fatalf("SearchRequests() returned error: %v", err)
+			}
+			var got []string
+			for _, req := range result.Requests {
+				got = append(got, req.TwilioConversationSID)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Errorf("Expected %v, got %v", tc.want, got)
+			}
+			if result.NextOffset != nil {
+				t.Errorf("Expected a single page, got next_offset %d", *result.NextOffset)
+			}
+		})
+	}
+
+	// Pages of 3: a full first page that points at the second, then the last one.
+	first, err := testRepo.SearchRequests(ctx, SearchFilter{Limit: 3})
+	if err != nil {
+		t.Fatalf("SearchRequests() returned error: %v", err)
+	}
+	if len(first.Requests) != 3 || first.NextOffset == nil || *first.NextOffset != 3 {
+		t.Fatalf("Expected 3 requests and next_offset 3, got %d and %v", len(first.Requests), first.NextOffset)
+	}
+	second, err := testRepo.SearchRequests(ctx, SearchFilter{Limit: 3, Offset: *first.NextOffset})
+	if err != nil {
+		t.Fatalf("SearchRequests() returned error: %v", err)
+	}
+	if len(second.Requests) != 1 || second.Requests[0].TwilioConversationSID != "twil-search-1" || second.NextOffset != nil {
+		t.Errorf("Expected only twil-search-1 on the last page, got %+v", second)
+	}
+}
+
+// TestGetRequestByTwilioSID verifies the newest request for a conversation wins over older resolved ones.
+func TestGetRequestByTwilioSID(t *testing.T) {
+	cleanRequestTables()
+	ctx := context.Background()
+
+	old := time.Now().UTC().Add(-time.Hour)
+	insertRequestAt(t, "twil-bysid-1", "resolved", old, &old, &old)
+	newest, err := createTestRequest(ctx, "twil-bysid-1")
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+
+	got, err := testRepo.GetRequestByTwilioSID(ctx, "twil-bysid-1")
+	if err != nil {
+		t.Fatalf("GetRequestByTwilioSID() returned error: %v", err)
+	}
+	if got.RequestID != newest.RequestID {
+		t.Errorf("Expected the newest request %s, got %s", newest.RequestID, got.RequestID)
+	}
+
+	if _, err := testRepo.GetRequestByTwilioSID(ctx, "twil-bysid-missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got: %v", err)
+	}
+}
+
+// TestClaimNextRequest_Concurrent has several experts claim at once and checks no request is handed out twice.
+func TestClaimNextRequest_Concurrent(t *testing.T) {
+	cleanRequestTables()
+	ctx := context.Background()
+
+	const numRequests = 10
+	const numClaimers = 5
+	for i := 0; i < numRequests; i++ {
+		if _, err := createTestRequest(ctx, fmt.Sprintf("twil-claim-%d", i)); err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		claimed = make(map[uuid.UUID]int)
+	)
+	for i := 0; i < numClaimers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Keep claiming until the queue runs dry.
+			for {
+				req, err := testRepo.ClaimNextRequest(ctx, testExpert.ExpertID)
+				if errors.Is(err, ErrQueueEmpty) {
+					return
+				}
+				if err != nil {
+					t.Errorf("ClaimNextRequest() returned error: %v", err)
+					return
+				}
+				mu.Lock()
+				claimed[req.RequestID]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(claimed) != numRequests {
+		t.Errorf("Expected %d distinct requests claimed, got %d", numRequests, len(claimed))
+	}
+	for id, n := range claimed {
+		if n != 1 {
+			t.Errorf("Request %s was claimed %d times", id, n)
+		}
+	}
+}
+
+// TestReopenRequest checks a resolved request can be reopened to either state, once, and only inside the window.
+func TestReopenRequest(t *testing.T) {
+	cleanRequestTables()
+	ctx := context.Background()
+	now := time.Now().UTC()
+	created := now.Add(-time.Hour)
+	accepted := now.Add(-50 * time.Minute)
+	recent := now.Add(-5 * time.Minute)
+	old := now.Add(-45 * time.Minute)
+	resolvedAfter := now.Add(-30 * time.Minute)
+
+	insertRequestAt(t, "twil-reopen-keep", "resolved", created, &accepted, &recent)
+	insertRequestAt(t, "twil-reopen-queue", "resolved", created, &accepted, &recent)
+	insertRequestAt(t, "twil-reopen-old", "resolved", created, &accepted, &old)
+
+	keep, _ := testRepo.GetRequestByTwilioSID(ctx, "twil-reopen-keep")
+	req, err := testRepo.ReopenRequest(ctx, keep.RequestID, resolvedAfter, true)
+	if err != nil {
+		t.Fatalf("ReopenRequest() returned error: %v", err)
+	}
+	if req.Status != "active" || !req.ExpertID.Valid || req.ResolvedAt.Valid {
+		t.Errorf("Expected active with the expert kept and no resolved_at, got %+v", req)
+	}
+
+	// It's active now, so a second reopen has nothing to do.
+	if _, err := testRepo.ReopenRequest(ctx, keep.RequestID, resolvedAfter, true); !errors.Is(err, ErrRequestNotResolved) {
+		t.Errorf("Expected ErrRequestNotResolved on a second reopen, got: %v", err)
+	}
+
+	queue, _ := testRepo.GetRequestByTwilioSID(ctx, "twil-reopen-queue")
+	req, err = testRepo.ReopenRequest(ctx, queue.RequestID, resolvedAfter, false)
+	if err != nil {
+		t.Fatalf("ReopenRequest() returned error: %v", err)
+	}
+	if req.Status != "pending" || req.ExpertID.Valid || req.AcceptedAt.Valid {
+		t.Errorf("Expected pending with the expert cleared, got %+v", req)
+	}
+
+	stale, _ := testRepo.GetRequestByTwilioSID(ctx, "twil-reopen-old")
+	if _, err := testRepo.ReopenRequest(ctx, stale.RequestID, resolvedAfter, true); !errors.Is(err, ErrRequestNotResolved) {
+		t.Errorf("Expected ErrRequestNotResolved outside the window, got: %v", err)
+	}
+}
+
+// TestResolveIdleRequests seeds an old active request and a fresh one, and checks only the old one is resolved.
+// A message on the old one first would have kept it open.
+func TestResolveIdleRequests(t *testing.T) {
+	cleanRequestTables()
+	ctx := context.Background()
+	now := time.Now().UTC()
+	longAgo := now.Add(-48 * time.Hour)
+	recently := now.Add(-10 * time.Minute)
+
+	insertRequestAt(t, "twil-idle-old", "active", longAgo, &longAgo, nil)
+	insertRequestAt(t, "twil-idle-touched", "active", longAgo, &longAgo, nil)
+	insertRequestAt(t, "twil-idle-fresh", "active", recently, &recently, nil)
+
+	touched, _ := testRepo.GetRequestByTwilioSID(ctx, "twil-idle-touched")
+	if err := testRepo.TouchRequestActivity(ctx, touched.RequestID); err != nil {
+		t.Fatalf("TouchRequestActivity() returned error: %v", err)
+	}
+
+	n, err := testRepo.ResolveIdleRequests(ctx, now.Add(-24*time.Hour), 10)
+	if err != nil {
+		t.Fatalf("ResolveIdleRequests() returned error: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 request resolved, got %d", n)
+	}
+
+	want := map[string]string{"twil-idle-old": "resolved", "twil-idle-touched": "active", "twil-idle-fresh": "active"}
+	for sid, status := range want {
+		req, err := testRepo.GetRequestByTwilioSID(ctx, sid)
+		if err != nil {
+			t.Fatalf("GetRequestByTwilioSID(%s) returned error: %v", sid, err)
+		}
+		if req.Status != status {
+			t.Errorf("Expected %s to be %s, got %s", sid, status, req.Status)
+		}
+		if status == "resolved" && !req.ResolvedAt.Valid {
+			t.Errorf("Expected %s to have resolved_at set", sid)
+		}
+	}
+
+	// Nothing left to do, so a second run resolves nothing.
+	if n, err := testRepo.ResolveIdleRequests(ctx, now.Add(-24*time.Hour), 10); err != nil || n != 0 {
+		t.Errorf("Expected nothing on a second run, got n=%d err=%v", n, err)
+	}
+}
+
+// TestTouchRequestActivity checks a touch moves last_activity_at forward on an active request, and
+// leaves a pending one alone.
+func TestTouchRequestActivity(t *testing.T) {
+	cleanRequestTables()
+	ctx := context.Background()
+
+	req, err := createTestRequest(ctx, "twil-touch")
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	if err := testRepo.TouchRequestActivity(ctx, req.RequestID); err != nil {
+		t.Fatalf("TouchRequestActivity() returned error: %v", err)
+	}
+	got, err := testRepo.GetRequestByID(ctx, req.RequestID)
+	if err != nil {
+		t.Fatalf("GetRequestByID() returned error: %v", err)
+	}
+	if got.LastActivityAt.Valid {
+		t.Errorf("Expected no activity on a pending request, got %v", got.LastActivityAt.Time)
+	}
+
+	accepted, err := testRepo.AcceptRequest(ctx, req.RequestID, testExpert.ExpertID)
+	if err != nil {
+		t.Fatalf("Failed to accept request: %v", err)
+	}
+	if !accepted.LastActivityAt.Valid || !accepted.LastActivityAt.Time.Equal(accepted.AcceptedAt.Time) {
+		t.Fatalf("Expected accepting to count as activity, got %+v", accepted.LastActivityAt)
+	}
+
+	time.Sleep(10 * time.Millisecond) // So the touch is measurably later
+	if err := testRepo.TouchRequestActivity(ctx, req.RequestID); err != nil {
+		t.Fatalf("TouchRequestActivity() returned error: %v", err)
+	}
+	got, err = testRepo.GetRequestByID(ctx, req.RequestID)
+	if err != nil {
+		t.Fatalf("GetRequestByID() returned error: %v", err)
+	}
+	if !got.LastActivityAt.Valid || !got.LastActivityAt.Time.After(accepted.LastActivityAt.Time) {
+		t.Errorf("Expected last_activity_at to move past %v, got %+v", accepted.LastActivityAt.Time, got.LastActivityAt)
+	}
+}
+
+// TestTransferRequest checks the expert is swapped, the audit row is written, and a stale transfer fails.
+func TestTransferRequest(t *testing.T) {
+	cleanRequestTables()
+	ctx := context.Background()
+
+	specialist := uuid.New()
+	_, err := testDB.Exec(`INSERT INTO experts (expert_id, firebase_auth_id, display_name, is_active, role)
+		VALUES ($1, 'fb-req-test-specialist', 'Specialist Sue', true, 'expert')`, specialist)
+	if err != nil {
+		t.Fatalf("Failed to create second expert: %v", err)
+	}
+
+	req, _ := createTestRequest(ctx, "twil-transfer")
+	if _, err := testRepo.AcceptRequest(ctx, req.RequestID, testExpert.ExpertID); err != nil {
+		t.Fatalf("AcceptRequest() returned error: %v", err)
+	}
+
+	got, err := testRepo.TransferRequest(ctx, req.RequestID, testExpert.ExpertID, specialist, testExpert.ExpertID, "expert")
+	if err != nil {
+		t.Fatalf("TransferRequest() returned error: %v", err)
+	}
+	if got.ExpertID.UUID != specialist || got.Status != "active" {
+		t.Errorf("Expected active with the specialist, got %+v", got)
+	}
+
+	var eventType, actorRole string
+	var fromID, toID uuid.UUID
+	err = testDB.QueryRow(`SELECT event_type, actor_role, from_expert_id, to_expert_id FROM request_events WHERE request_id = $1`, req.RequestID).
+		Scan(&eventType, &actorRole, &fromID, &toID)
+	if err != nil {
+		t.Fatalf("Could not read transfer event: %v", err)
+	}
+	if eventType != "transferred" || actorRole != "expert" || fromID != testExpert.ExpertID || toID != specialist {
+		t.Errorf("Unexpected event: %s %s %s -> %s", eventType, actorRole, fromID, toID)
+	}
+
+	// The first expert no longer has it, so transferring from them again is stale.
+	_, err = testRepo.TransferRequest(ctx, req.RequestID, testExpert.ExpertID, specialist, testExpert.ExpertID, "expert")
+	if !errors.Is(err, ErrRequestNotActive) {
+		t.Errorf("Expected ErrRequestNotActive for a stale transfer, got: %v", err)
+	}
+}
+
+// TestHasOpenRequest checks only pending and active requests count as open.
+func TestHasOpenRequest(t *testing.T) {
+	cleanRequestTables()
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	insertRequestAt(t, "twil-open-resolved", "resolved", now.Add(-time.Hour), &now, &now)
+	open, err := testRepo.HasOpenRequest(ctx, testUser.UserID)
+	if err != nil {
+		t.Fatalf("HasOpenRequest() returned error: %v", err)
+	}
+	if open {
+		t.Error("Expected a resolved request not to count as open")
+	}
+
+	req, _ := createTestRequest(ctx, "twil-open-pending")
+	open, err = testRepo.HasOpenRequest(ctx, testUser.UserID)
+	if err != nil {
+		t.Fatalf("HasOpenRequest() returned error: %v", err)
+	}
+	if !open {
+		t.Error("Expected the pending request to count as open")
+	}
+
+	got, err := testRepo.GetOpenRequestByUser(ctx, testUser.UserID)
+	if err != nil {
+		t.Fatalf("GetOpenRequestByUser() returned error: %v", err)
+	}
+	if got.RequestID != req.RequestID {
+		t.Errorf("Expected request %s, got %s", req.RequestID, got.RequestID)
+	}
+}
+
+// TestSetFirstResponse checks the timestamp is only written once, and shows up in the stats.
+func TestSetFirstResponse(t *testing.T) {
+	cleanRequestTables()
+	ctx := context.Background()
+
+	req, err := createTestRequest(ctx, "twil-first-response")
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	// Pending requests have no expert to respond yet.
+	if ok, err := testRepo.SetFirstResponse(ctx, req.RequestID, time.Now()); err != nil || ok {
+		t.Errorf("Expected no write on a pending request, got ok=%v err=%v", ok, err)
+	}
+
+	accepted, err := testRepo.AcceptRequest(ctx, req.RequestID, testExpert.ExpertID)
+	if err != nil {
+		t.Fatalf("Failed to accept request: %v", err)
+	}
+	first := accepted.AcceptedAt.Time.Add(45 * time.Second)
+	if ok, err := testRepo.SetFirstResponse(ctx, req.RequestID, first); err != nil || !ok {
+		t.Fatalf("Expected the first write to succeed, got ok=%v err=%v", ok, err)
+	}
+	if ok, err := testRepo.SetFirstResponse(ctx, req.RequestID, first.Add(time.Minute)); err != nil || ok {
+		t.Errorf("Expected the second write to be a no-op, got ok=%v err=%v", ok, err)
+	}
+
+	got, err := testRepo.GetRequestByID(ctx, req.RequestID)
+	if err != nil {
+		t.Fatalf("GetRequestByID() returned error: %v", err)
+	}
+	if !got.FirstResponseAt.Valid || !got.FirstResponseAt.Time.Equal(first.Truncate(time.Microsecond)) {
+		t.Errorf("Expected first_response_at %v, got %+v", first, got.FirstResponseAt)
+	}
+
+	stats, err := testRepo.GetRequestStats(ctx, req.CreatedAt.Add(-time.Minute), time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("GetRequestStats() returned error: %v", err)
+	}
+	if stats.Responded != 1 || stats.AvgTimeToFirstResponseSeconds < 44.9 || stats.AvgTimeToFirstResponseSeconds > 45.1 {
+		t.Errorf("Expected one response after 45s, got %+v", stats)
+	}
+}
+
+// TestOutboxEvents checks events are saved with the request, claimed once per lease, and retried after a failure.
+func TestOutboxEvents(t *testing.T) {
+	cleanRequestTables()
+	ctx := context.Background()
+
+	req := &domain.AssistanceRequest{UserID: testUser.UserID, LLMSummary: "Test summary", TwilioConversationSID: "twil-outbox"}
+	if err := testRepo.CreateRequest(ctx, req, []string{OutboxRemoveBot, OutboxNotifyExperts}); err != nil {
+		t.Fatalf("CreateRequest() returned error: %v", err)
+	}
+
+	events, err := testRepo.ClaimOutboxEvents(ctx, 10, time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimOutboxEvents() returned error: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(events))
+	}
+	for _, ev := range events {
+		if ev.RequestID != req.RequestID || ev.Attempts != 0 {
+			t.Errorf("Unexpected event %+v", ev)
+		}
+	}
+
+	// They're leased, so nobody else gets them.
+	if again, _ := testRepo.ClaimOutboxEvents(ctx, 10, time.Minute); len(again) != 0 {
+		t.Fatalf("Expected no events while leased, got %d", len(again))
+	}
+
+	// One goes out, the other fails and is due again straight away.
+	if err := testRepo.MarkOutboxEventSent(ctx, events[0].EventID); err != nil {
+		t.Fatalf("MarkOutboxEventSent() returned error: %v", err)
+	}
+	if err := testRepo.MarkOutboxEventFailed(ctx, events[1].EventID, "chat gateway down", time.Now().UTC().Add(-time.Second)); err != nil {
+		t.Fatalf("MarkOutboxEventFailed() returned error: %v", err)
+	}
+
+	retry, err := testRepo.ClaimOutboxEvents(ctx, 10, time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimOutboxEvents() returned error: %v", err)
+	}
+	if len(retry) != 1 || retry[0].EventID != events[1].EventID {
+		t.Fatalf("Expected only the failed event back, got %+v", retry)
+	}
+	if retry[0].Attempts != 1 || retry[0].LastError != "chat gateway down" {
+		t.Errorf("Expected 1 attempt with the last error, got %+v", retry[0])
+	}
+}
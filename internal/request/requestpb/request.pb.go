@@ -0,0 +1,516 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        v5.28.3
+// source: request/v1/request.proto
+
+// Internal gRPC contract for the RequestService.
+// The handlers delegate to the same request.Service the HTTP API uses.
+
+package requestpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// AssistanceRequest mirrors domain.AssistanceRequest.
+// Nullable fields are left empty/unset when they have no value.
+type AssistanceRequest struct {
+	state                 protoimpl.MessageState `protogen:"open.v1"`
+	RequestId             string                 `protobuf:"bytes,1,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	UserId                string                 `protobuf:"bytes,2,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	ExpertId              string                 `protobuf:"bytes,3,opt,name=expert_id,json=expertId,proto3" json:"expert_id,omitempty"`
+	Status                string                 `protobuf:"bytes,4,opt,name=status,proto3" json:"status,omitempty"`
+	LlmSummary            string                 `protobuf:"bytes,5,opt,name=llm_summary,json=llmSummary,proto3" json:"llm_summary,omitempty"`
+	TwilioConversationSid string                 `protobuf:"bytes,6,opt,name=twilio_conversation_sid,json=twilioConversationSid,proto3" json:"twilio_conversation_sid,omitempty"`
+	CreatedAt             *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	AcceptedAt            *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=accepted_at,json=acceptedAt,proto3" json:"accepted_at,omitempty"`
+	ResolvedAt            *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=resolved_at,json=resolvedAt,proto3" json:"resolved_at,omitempty"`
+	unknownFields         protoimpl.UnknownFields
+	sizeCache             protoimpl.SizeCache
+}
+
+func (x *AssistanceRequest) Reset() {
+	*x = AssistanceRequest{}
+	mi := &file_request_v1_request_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AssistanceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AssistanceRequest) ProtoMessage() {}
+
+func (x *AssistanceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_request_v1_request_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AssistanceRequest.ProtoReflect.Descriptor instead.
+func (*AssistanceRequest) Descriptor() ([]byte, []int) {
+	return file_request_v1_request_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *AssistanceRequest) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
+func (x *AssistanceRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *AssistanceRequest) GetExpertId() string {
+	if x != nil {
+		return x.ExpertId
+	}
+	return ""
+}
+
+func (x *AssistanceRequest) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *AssistanceRequest) GetLlmSummary() string {
+	if x != nil {
+		return x.LlmSummary
+	}
+	return ""
+}
+
+func (x *AssistanceRequest) GetTwilioConversationSid() string {
+	if x != nil {
+		return x.TwilioConversationSid
+	}
+	return ""
+}
+
+func (x *AssistanceRequest) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *AssistanceRequest) GetAcceptedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.AcceptedAt
+	}
+	return nil
+}
+
+func (x *AssistanceRequest) GetResolvedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ResolvedAt
+	}
+	return nil
+}
+
+type CreateRequestRequest struct {
+	state                 protoimpl.MessageState `protogen:"open.v1"`
+	UserId                string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	TwilioConversationSid string                 `protobuf:"bytes,2,opt,name=twilio_conversation_sid,json=twilioConversationSid,proto3" json:"twilio_conversation_sid,omitempty"`
+	unknownFields         protoimpl.UnknownFields
+	sizeCache             protoimpl.SizeCache
+}
+
+func (x *CreateRequestRequest) Reset() {
+	*x = CreateRequestRequest{}
+	mi := &file_request_v1_request_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateRequestRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateRequestRequest) ProtoMessage() {}
+
+func (x *CreateRequestRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_request_v1_request_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateRequestRequest.ProtoReflect.Descriptor instead.
+func (*CreateRequestRequest) Descriptor() ([]byte, []int) {
+	return file_request_v1_request_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *CreateRequestRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *CreateRequestRequest) GetTwilioConversationSid() string {
+	if x != nil {
+		return x.TwilioConversationSid
+	}
+	return ""
+}
+
+type GetPendingRequestsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetPendingRequestsRequest) Reset() {
+	*x = GetPendingRequestsRequest{}
+	mi := &file_request_v1_request_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPendingRequestsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPendingRequestsRequest) ProtoMessage() {}
+
+func (x *GetPendingRequestsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_request_v1_request_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPendingRequestsRequest.ProtoReflect.Descriptor instead.
+func (*GetPendingRequestsRequest) Descriptor() ([]byte, []int) {
+	return file_request_v1_request_proto_rawDescGZIP(), []int{2}
+}
+
+type GetPendingRequestsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Requests      []*AssistanceRequest   `protobuf:"bytes,1,rep,name=requests,proto3" json:"requests,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetPendingRequestsResponse) Reset() {
+	*x = GetPendingRequestsResponse{}
+	mi := &file_request_v1_request_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPendingRequestsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPendingRequestsResponse) ProtoMessage() {}
+
+func (x *GetPendingRequestsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_request_v1_request_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPendingRequestsResponse.ProtoReflect.Descriptor instead.
+func (*GetPendingRequestsResponse) Descriptor() ([]byte, []int) {
+	return file_request_v1_request_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GetPendingRequestsResponse) GetRequests() []*AssistanceRequest {
+	if x != nil {
+		return x.Requests
+	}
+	return nil
+}
+
+type AcceptRequestRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	RequestId     string                 `protobuf:"bytes,1,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	ExpertId      string                 `protobuf:"bytes,2,opt,name=expert_id,json=expertId,proto3" json:"expert_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AcceptRequestRequest) Reset() {
+	*x = AcceptRequestRequest{}
+	mi := &file_request_v1_request_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AcceptRequestRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AcceptRequestRequest) ProtoMessage() {}
+
+func (x *AcceptRequestRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_request_v1_request_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AcceptRequestRequest.ProtoReflect.Descriptor instead.
+func (*AcceptRequestRequest) Descriptor() ([]byte, []int) {
+	return file_request_v1_request_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *AcceptRequestRequest) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
+func (x *AcceptRequestRequest) GetExpertId() string {
+	if x != nil {
+		return x.ExpertId
+	}
+	return ""
+}
+
+type ResolveRequestRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	RequestId     string                 `protobuf:"bytes,1,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	ExpertId      string                 `protobuf:"bytes,2,opt,name=expert_id,json=expertId,proto3" json:"expert_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResolveRequestRequest) Reset() {
+	*x = ResolveRequestRequest{}
+	mi := &file_request_v1_request_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResolveRequestRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResolveRequestRequest) ProtoMessage() {}
+
+func (x *ResolveRequestRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_request_v1_request_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResolveRequestRequest.ProtoReflect.Descriptor instead.
+func (*ResolveRequestRequest) Descriptor() ([]byte, []int) {
+	return file_request_v1_request_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ResolveRequestRequest) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
+func (x *ResolveRequestRequest) GetExpertId() string {
+	if x != nil {
+		return x.ExpertId
+	}
+	return ""
+}
+
+type ResolveRequestResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ResolveRequestResponse) Reset() {
+	*x = ResolveRequestResponse{}
+	mi := &file_request_v1_request_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ResolveRequestResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ResolveRequestResponse) ProtoMessage() {}
+
+func (x *ResolveRequestResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_request_v1_request_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ResolveRequestResponse.ProtoReflect.Descriptor instead.
+func (*ResolveRequestResponse) Descriptor() ([]byte, []int) {
+	return file_request_v1_request_proto_rawDescGZIP(), []int{6}
+}
+
+var File_request_v1_request_proto protoreflect.FileDescriptor
+
+const file_request_v1_request_proto_rawDesc = "" +
+	"\n" +
+	"\x18request/v1/request.proto\x12\x0fsage.request.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\x8e\x03\n" +
+	"\x11AssistanceRequest\x12\x1d\n" +
+	"\n" +
+	"request_id\x18\x01 \x01(\tR\trequestId\x12\x17\n" +
+	"\auser_id\x18\x02 \x01(\tR\x06userId\x12\x1b\n" +
+	"\texpert_id\x18\x03 \x01(\tR\bexpertId\x12\x16\n" +
+	"\x06status\x18\x04 \x01(\tR\x06status\x12\x1f\n" +
+	"\vllm_summary\x18\x05 \x01(\tR\n" +
+	"llmSummary\x126\n" +
+	"\x17twilio_conversation_sid\x18\x06 \x01(\tR\x15twilioConversationSid\x129\n" +
+	"\n" +
+	"created_at\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x12;\n" +
+	"\vaccepted_at\x18\b \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"acceptedAt\x12;\n" +
+	"\vresolved_at\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"resolvedAt\"g\n" +
+	"\x14CreateRequestRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x126\n" +
+	"\x17twilio_conversation_sid\x18\x02 \x01(\tR\x15twilioConversationSid\"\x1b\n" +
+	"\x19GetPendingRequestsRequest\"\\\n" +
+	"\x1aGetPendingRequestsResponse\x12>\n" +
+	"\brequests\x18\x01 \x03(\v2\".sage.request.v1.AssistanceRequestR\brequests\"R\n" +
+	"\x14AcceptRequestRequest\x12\x1d\n" +
+	"\n" +
+	"request_id\x18\x01 \x01(\tR\trequestId\x12\x1b\n" +
+	"\texpert_id\x18\x02 \x01(\tR\bexpertId\"S\n" +
+	"\x15ResolveRequestRequest\x12\x1d\n" +
+	"\n" +
+	"request_id\x18\x01 \x01(\tR\trequestId\x12\x1b\n" +
+	"\texpert_id\x18\x02 \x01(\tR\bexpertId\"\x18\n" +
+	"\x16ResolveRequestResponse2\x9a\x03\n" +
+	"\x0eRequestService\x12Z\n" +
+	"\rCreateRequest\x12%.sage.request.v1.CreateRequestRequest\x1a\".sage.request.v1.AssistanceRequest\x12m\n" +
+	"\x12GetPendingRequests\x12*.sage.request.v1.GetPendingRequestsRequest\x1a+.sage.request.v1.GetPendingRequestsResponse\x12Z\n" +
+	"\rAcceptRequest\x12%.sage.request.v1.AcceptRequestRequest\x1a\".sage.request.v1.AssistanceRequest\x12a\n" +
+	"\x0eResolveRequest\x12&.sage.request.v1.ResolveRequestRequest\x1a'.sage.request.v1.ResolveRequestResponseB3Z1project-sage/internal/request/requestpb;requestpbb\x06proto3"
+
+var (
+	file_request_v1_request_proto_rawDescOnce sync.Once
+	file_request_v1_request_proto_rawDescData []byte
+)
+
+func file_request_v1_request_proto_rawDescGZIP() []byte {
+	file_request_v1_request_proto_rawDescOnce.Do(func() {
+		file_request_v1_request_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_request_v1_request_proto_rawDesc), len(file_request_v1_request_proto_rawDesc)))
+	})
+	return file_request_v1_request_proto_rawDescData
+}
+
+var file_request_v1_request_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_request_v1_request_proto_goTypes = []any{
+	(*AssistanceRequest)(nil),          // 0: sage.request.v1.AssistanceRequest
+	(*CreateRequestRequest)(nil),       // 1: sage.request.v1.CreateRequestRequest
+	(*GetPendingRequestsRequest)(nil),  // 2: sage.request.v1.GetPendingRequestsRequest
+	(*GetPendingRequestsResponse)(nil), // 3: sage.request.v1.GetPendingRequestsResponse
+	(*AcceptRequestRequest)(nil),       // 4: sage.request.v1.AcceptRequestRequest
+	(*ResolveRequestRequest)(nil),      // 5: sage.request.v1.ResolveRequestRequest
+	(*ResolveRequestResponse)(nil),     // 6: sage.request.v1.ResolveRequestResponse
+	(*timestamppb.Timestamp)(nil),      // 7: google.protobuf.Timestamp
+}
+var file_request_v1_request_proto_depIdxs = []int32{
+	7, // 0: sage.request.v1.AssistanceRequest.created_at:type_name -> google.protobuf.Timestamp
+	7, // 1: sage.request.v1.AssistanceRequest.accepted_at:type_name -> google.protobuf.Timestamp
+	7, // 2: sage.request.v1.AssistanceRequest.resolved_at:type_name -> google.protobuf.Timestamp
+	0, // 3: sage.request.v1.GetPendingRequestsResponse.requests:type_name -> sage.request.v1.AssistanceRequest
+	1, // 4: sage.request.v1.RequestService.CreateRequest:input_type -> sage.request.v1.CreateRequestRequest
+	2, // 5: sage.request.v1.RequestService.GetPendingRequests:input_type -> sage.request.v1.GetPendingRequestsRequest
+	4, // 6: sage.request.v1.RequestService.AcceptRequest:input_type -> sage.request.v1.AcceptRequestRequest
+	5, // 7: sage.request.v1.RequestService.ResolveRequest:input_type -> sage.request.v1.ResolveRequestRequest
+	0, // 8: sage.request.v1.RequestService.CreateRequest:output_type -> sage.request.v1.AssistanceRequest
+	3, // 9: sage.request.v1.RequestService.GetPendingRequests:output_type -> sage.request.v1.GetPendingRequestsResponse
+	0, // 10: sage.request.v1.RequestService.AcceptRequest:output_type -> sage.request.v1.AssistanceRequest
+	6, // 11: sage.request.v1.RequestService.ResolveRequest:output_type -> sage.request.v1.ResolveRequestResponse
+	8, // [8:12] is the sub-list for method output_type
+	4, // [4:8] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_request_v1_request_proto_init() }
+func file_request_v1_request_proto_init() {
+	if File_request_v1_request_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_request_v1_request_proto_rawDesc), len(file_request_v1_request_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_request_v1_request_proto_goTypes,
+		DependencyIndexes: file_request_v1_request_proto_depIdxs,
+		MessageInfos:      file_request_v1_request_proto_msgTypes,
+	}.Build()
+	File_request_v1_request_proto = out.File
+	file_request_v1_request_proto_goTypes = nil
+	file_request_v1_request_proto_depIdxs = nil
+}
@@ -1,9 +1,14 @@
 package request
 
+//go:generate mockgen -destination=./service_mock_test.go -package=request -source=service.go Service
+
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math/rand"
 	"project-sage/internal/domain" // The shared domain models
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -11,60 +16,212 @@ import (
 // Service defines the business logic operations for the request orchestrator.
 type Service interface {
 	// User-facing operations
-	CreateRequest(ctx context.Context, userID uuid.UUID, twilioSID string) (*domain.AssistanceRequest, error)
+	CreateRequest(ctx context.Context, userID uuid.UUID, twilioSID, requestType string) (*CreatedRequest, error)
+	CheckCreateRequest(ctx context.Context, userID uuid.UUID, requestType string) (*CreateCheck, error)
 	SubmitRating(ctx context.Context, reqID, userID, expertID uuid.UUID, score int) error
+	ExportRequests(ctx context.Context, userID uuid.UUID, fn func(*RequestExportRow) error) error
+	ReopenRequest(ctx context.Context, requestID, userID uuid.UUID) (*domain.AssistanceRequest, error)
 
 	// Expert-facing operations
-	GetPendingRequests(ctx context.Context) ([]*domain.AssistanceRequest, error)
+	GetPendingRequests(ctx context.Context, opts QueueOptions) (*domain.Page[*QueuedRequest], error)
+	ReserveRequest(ctx context.Context, requestID, expertID uuid.UUID, ttl time.Duration) (time.Time, error)
+	WatchPendingRequests(ctx context.Context) <-chan *domain.AssistanceRequest
 	AcceptRequest(ctx context.Context, requestID, expertID uuid.UUID) (*domain.AssistanceRequest, error)
+	ClaimNextRequest(ctx context.Context, expertID uuid.UUID) (*domain.AssistanceRequest, error)
 	ResolveRequest(ctx context.Context, requestID, expertID uuid.UUID) error
+	TransferRequest(ctx context.Context, requestID, targetExpertID uuid.UUID, caller Caller) (*domain.AssistanceRequest, error)
+	ResummarizeRequest(ctx context.Context, requestID uuid.UUID) (*domain.AssistanceRequest, error)
+	GetRequest(ctx context.Context, requestID uuid.UUID, caller Caller) (*domain.AssistanceRequest, error)
+
+	// Internal operations for other services
+	GetRequestByTwilioSID(ctx context.Context, twilioSID string) (*domain.AssistanceRequest, error)
+	RecordFirstResponse(ctx context.Context, twilioSID string, author uuid.UUID, sentAt time.Time) (bool, error)
+
+	// Admin operations
+	GetRequestStats(ctx context.Context, from, to time.Time) (*RequestStats, error)
+	SearchRequests(ctx context.Context, callerID uuid.UUID, filter SearchFilter) (*SearchResult, error)
 }
 
 // service implements the Service interface and orchestrates all other clients and repositories
 type service struct {
-	repo          Repository    // Our own database access
-	billingClient BillingClient // Client for the BillingService
-	llmClient     LLMClient     // Client for the LLMGatewayService
-	chatClient    ChatClient    // Client for the ChatGatewayService
-	userClient    UserClient    // Client for the UserService
+	repo          Repository       // Our own database access
+	billingClient BillingClient    // Client for the BillingService
+	llmClient     LLMClient        // Client for the LLMGatewayService
+	chatClient    ChatClient       // Client for the ChatGatewayService
+	userClient    UserClient       // Client for the UserService
+	expertClient  ExpertClient     // Client for expert profiles (also the UserService)
+	opts          Options          // Per-dependency timeouts
+	pending       *pendingBroker   // Newly pending requests, for experts watching the queue
+	now           func() time.Time // Reservation times and queue priorities. Tests swap it out
+	jitter        func() float64   // Noise for the balanced queue, in [0, 1). Tests swap it out
+}
+
+// Caller is who is asking for a change to a request. Exactly one of the ids is set.
+type Caller struct {
+	ExpertID uuid.UUID // An expert calling from the expert app
+	UserID   uuid.UUID // A user calling from the user app, who has to be a superadmin for admin actions
+}
+
+// Options holds the per-dependency timeouts used by the orchestration.
+// Each downstream call gets its own budget so one slow dependency can't starve the steps after it.
+type Options struct {
+	UserTimeout    time.Duration  // GetUserProfile and GetExpertProfile
+	BillingTimeout time.Duration  // HoldToken, CommitHold, ReleaseHold and GetBalance
+	LLMTimeout     time.Duration  // Summarize
+	RepoTimeout    time.Duration  // Repository writes
+	ReopenWindow   time.Duration  // How long after resolving the user can still reopen
+	TokenCosts     map[string]int // Tokens each request type costs. Types not in here can't be created
+}
+
+// DefaultOptions returns the timeouts used when none are configured.
+func DefaultOptions() Options {
+	return Options{
+		UserTimeout:    3 * time.Second,
+		BillingTimeout: 3 * time.Second,
+		LLMTimeout:     15 * time.Second, // Summaries are the slow step.
+		RepoTimeout:    3 * time.Second,
+		ReopenWindow:   30 * time.Minute,
+		TokenCosts:     DefaultTokenCosts(),
+	}
+}
+
+// withDefaults fills any zero timeouts from DefaultOptions.
+func (o Options) withDefaults() Options {
+	d := DefaultOptions()
+	if o.UserTimeout <= 0 {
+		o.UserTimeout = d.UserTimeout
+	}
+	if o.BillingTimeout <= 0 {
+		o.BillingTimeout = d.BillingTimeout
+	}
+	if o.LLMTimeout <= 0 {
+		o.LLMTimeout = d.LLMTimeout
+	}
+	if o.RepoTimeout <= 0 {
+		o.RepoTimeout = d.RepoTimeout
+	}
+	if o.ReopenWindow <= 0 {
+		o.ReopenWindow = d.ReopenWindow
+	}
+	if len(o.TokenCosts) == 0 {
+		o.TokenCosts = d.TokenCosts
+	}
+	return o
 }
 
 // NewService is the constructor, injecting all required dependencies.
-func NewService(r Repository, bc BillingClient, lc LLMClient, cc ChatClient, uc UserClient) Service {
+func NewService(r Repository, bc BillingClient, lc LLMClient, cc ChatClient, uc UserClient, ec ExpertClient) Service {
+	return NewServiceWithOptions(r, bc, lc, cc, uc, ec, DefaultOptions())
+}
+
+// NewServiceWithOptions is the same as NewService but with custom per-dependency timeouts.
+func NewServiceWithOptions(r Repository, bc BillingClient, lc LLMClient, cc ChatClient, uc UserClient, ec ExpertClient, opts Options) Service {
 	return &service{
 		repo:          r,
 		billingClient: bc,
 		llmClient:     lc,
 		chatClient:    cc,
 		userClient:    uc,
+		expertClient:  ec,
+		opts:          opts.withDefaults(),
+		pending:       newPendingBroker(),
+		now:           time.Now,
+		jitter:        rand.Float64,
+	}
+}
+
+// Request types. The type is what decides how many tokens a request costs.
+const (
+	RequestTypeStandard = "standard" // The default, and what every request was before there were types
+	RequestTypePriority = "priority"
+)
+
+// DefaultTokenCosts returns what each request type costs when nothing is configured.
+func DefaultTokenCosts() map[string]int {
+	return map[string]int{
+		RequestTypeStandard: 1,
+		RequestTypePriority: 3,
 	}
 }
 
-// CreateRequest orchestrates the new request handoff: debiting a token, summarizing the chat, and creating the request record.
-func (s *service) CreateRequest(ctx context.Context, userID uuid.UUID, twilioSID string) (*domain.AssistanceRequest, error) {
+// tokenCost looks up what a request of this type costs. An empty type is a standard request.
+func (s *service) tokenCost(requestType string) (int, error) {
+	if requestType == "" {
+		requestType = RequestTypeStandard
+	}
+	cost, ok := s.opts.TokenCosts[requestType]
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrUnknownRequestType, requestType)
+	}
+	return cost, nil
+}
+
+// CreatedRequest is a new request plus the balance the client shows next to it.
+// The request is embedded, so it encodes as the request's own fields with remaining_balance alongside.
+type CreatedRequest struct {
+	*domain.AssistanceRequest
+	RemainingBalance *int `json:"remaining_balance,omitempty"` // Nil when nothing was held, eg for a superadmin or a free type
+}
+
+// stepError tags err with the step name if the step's own deadline is what stopped it.
+func stepError(stepCtx context.Context, step string, err error) error {
+	if errors.Is(stepCtx.Err(), context.DeadlineExceeded) {
+		return &StepTimeoutError{Step: step, Err: err}
+	}
+	return err
+}
+
+// CreateRequest orchestrates the new request handoff: holding a token, summarizing the chat, and creating the request record.
+// Every downstream call runs under its own timeout from Options.
+// requestType picks the token cost from Options.TokenCosts. Empty means standard.
+func (s *service) CreateRequest(ctx context.Context, userID uuid.UUID, twilioSID, requestType string) (*CreatedRequest, error) {
+	// Check the type before anything else, since it can't get any better by calling other services.
+	cost, err := s.tokenCost(requestType)
+	if err != nil {
+		return nil, err
+	}
 
 	// all UserClient to fetch user's role.
-	user, err := s.userClient.GetUserProfile(ctx, userID)
+	userCtx, cancel := context.WithTimeout(ctx, s.opts.UserTimeout)
+	user, err := s.userClient.GetUserProfile(userCtx, userID)
+	cancel()
 	if err != nil {
-		return nil, fmt.Errorf("could not fetch user profile: %w", err)
+		return nil, fmt.Errorf("could not fetch user profile: %w", stepError(userCtx, "GetUserProfile", err))
 	}
 
-	// Attempt to debit a token only if not a superadmin.
+	// One open request per user, checked before the hold so a second tap can't burn another token.
+	// Superadmins are exempt, since they test with several at once. The unique index on the SID still catches
+	// the rare race where two creates pass this check together.
 	if user.Role != "superadmin" {
-		// This is a normal user, so debit a token.
-		if err := s.billingClient.DebitToken(ctx, userID); err != nil {
-			// If debit fails (eg insufficient funds), stop the process.
-			return nil, fmt.Errorf("token debit failed: %w", err)
+		if err := s.checkNoOpenRequest(ctx, userID); err != nil {
+			return nil, err
 		}
 	}
+
+	// Hold the tokens only if not a superadmin, and only if the type costs anything. They're committed once the
+	// request is saved and released if it isn't, so nobody pays for a request that doesn't exist. holdID stays uuid.Nil when nothing was held.
+	var holdID uuid.UUID
+	var remaining *int
+	if user.Role != "superadmin" && cost > 0 {
+		billingCtx, cancel := context.WithTimeout(ctx, s.opts.BillingTimeout)
+		id, balance, err := s.billingClient.HoldToken(billingCtx, userID, cost, uuid.NewString())
+		cancel()
+		if err != nil {
+			// If the hold fails (eg insufficient funds), stop the process.
+			return nil, fmt.Errorf("token hold failed: %w", stepError(billingCtx, "HoldToken", err))
+		}
+		// The held tokens are already off the balance, so this is what's left whether or not the commit goes through.
+		holdID, remaining = id, &balance
+	}
 	// If user.Role == "superadmin", we just skip this block.
 
 	// Get the LLM summary of the chat.
-	summary, err := s.llmClient.Summarize(ctx, twilioSID)
+	llmCtx, cancel := context.WithTimeout(ctx, s.opts.LLMTimeout)
+	summary, err := s.llmClient.Summarize(llmCtx, twilioSID)
+	cancel()
 	if err != nil {
-		// If summary fails, the token may have been debited. Log this as a warning.
-		fmt.Printf("WARNING: Token debited for user %s, but LLM summary failed: %v\n", userID, err)
-		return nil, fmt.Errorf("could not summarize chat: %w", err)
+		s.releaseHold(ctx, userID, holdID)
+		return nil, fmt.Errorf("could not summarize chat: %w", stepError(llmCtx, "Summarize", err))
 	}
 
 	// Create the new request object to be saved.
@@ -73,51 +230,587 @@ func (s *service) CreateRequest(ctx context.Context, userID uuid.UUID, twilioSID
 		LLMSummary:            summary,
 		TwilioConversationSID: twilioSID,
 	}
-	// Persist the new pending request to our database.
-	if err := s.repo.CreateRequest(ctx, req); err != nil {
-		return nil, fmt.Errorf("could not save request: %w", err)
+	// Persist the new pending request to our database. Removing the bot and telling the experts go in the outbox
+	// in the same transaction, and the OutboxDispatcher delivers them, retrying until they succeed.
+	repoCtx, cancel := context.WithTimeout(ctx, s.opts.RepoTimeout)
+	err = s.repo.CreateRequest(repoCtx, req, []string{OutboxRemoveBot, OutboxNotifyExperts})
+	cancel()
+	if errors.Is(err, ErrDuplicateRequest) {
+		// A double tap. The conversation already has an open request, so give the token back and point at that one.
+		return nil, s.handleDuplicateRequest(ctx, userID, twilioSID, holdID)
+	}
+	if err != nil {
+		s.releaseHold(ctx, userID, holdID)
+		return nil, fmt.Errorf("could not save request: %w", stepError(repoCtx, "CreateRequest", err))
 	}
 
-	// Remove the bot from the chat. Log a warning if this fails, but don't fail the request.
-	if err := s.chatClient.RemoveBot(ctx, twilioSID); err != nil {
-		fmt.Printf("WARNING: Failed to remove bot from %s: %v\n", twilioSID, err)
+	// The request exists, so now the token is spent.
+	if holdID != uuid.Nil {
+		billingCtx, cancel := context.WithTimeout(ctx, s.opts.BillingTimeout)
+		err := s.billingClient.CommitHold(billingCtx, userID, holdID)
+		cancel()
+		if err != nil {
+			// The request is there either way, so don't fail it. But the sweeper will release the hold, so the
+			// user gets this one for free. Log loudly so support can see how often that happens.
+			fmt.Printf("CRITICAL: Request %s was created but token hold %s for user %s could not be committed: %v\n",
+				req.RequestID, holdID, userID, err)
+		}
 	}
 
-	return req, nil
+	// Experts watching the queue on this instance hear about it straight away.
+	s.pending.publish(req)
+
+	return &CreatedRequest{AssistanceRequest: req, RemainingBalance: remaining}, nil
+}
+
+// Why CheckCreateRequest says a user can't create a request.
+const (
+	CreateDeniedUserNotFound       = "user_not_found"
+	CreateDeniedOpenRequest        = "open_request"
+	CreateDeniedInsufficientTokens = "insufficient_tokens"
+)
+
+// CreateCheck is whether the user could create a request right now, and if not, why not.
+type CreateCheck struct {
+	Allowed   bool   `json:"allowed"`
+	Reason    string `json:"reason,omitempty"`  // One of the CreateDenied constants. Empty when allowed
+	TokenCost int    `json:"token_cost"`        // What the request would cost
+	Balance   *int   `json:"balance,omitempty"` // The user's spendable tokens. Nil when they weren't needed, eg for a superadmin
+}
+
+// CheckCreateRequest is CreateRequest's checks without any of its side effects, so the client can grey out the
+// button instead of finding out after the tap. It only reads: the user's profile, whether they have a request
+// open, and their balance from the BillingService. Nothing is held or debited, so a yes is only a good guess,
+// and the real create can still fail if the balance changes in between.
+func (s *service) CheckCreateRequest(ctx context.Context, userID uuid.UUID, requestType string) (*CreateCheck, error) {
+	cost, err := s.tokenCost(requestType)
+	if err != nil {
+		return nil, err
+	}
+	check := &CreateCheck{TokenCost: cost}
+
+	userCtx, cancel := context.WithTimeout(ctx, s.opts.UserTimeout)
+	user, err := s.userClient.GetUserProfile(userCtx, userID)
+	cancel()
+	if errors.Is(err, ErrUserNotFound) {
+		check.Reason = CreateDeniedUserNotFound
+		return check, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch user profile: %w", stepError(userCtx, "GetUserProfile", err))
+	}
+
+	// Superadmins skip both checks when they create, so they skip them here too.
+	if user.Role == "superadmin" {
+		check.Allowed = true
+		return check, nil
+	}
+
+	var openErr *OpenRequestError
+	if err := s.checkNoOpenRequest(ctx, userID); errors.As(err, &openErr) {
+		check.Reason = CreateDeniedOpenRequest
+		return check, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if cost > 0 {
+		billingCtx, cancel := context.WithTimeout(ctx, s.opts.BillingTimeout)
+		balance, err := s.billingClient.GetBalance(billingCtx, userID)
+		cancel()
+		if errors.Is(err, ErrUserNotFound) {
+			check.Reason = CreateDeniedUserNotFound
+			return check, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not fetch balance: %w", stepError(billingCtx, "GetBalance", err))
+		}
+		check.Balance = &balance
+		if balance < cost {
+			check.Reason = CreateDeniedInsufficientTokens
+			return check, nil
+		}
+	}
+
+	check.Allowed = true
+	return check, nil
+}
+
+// checkNoOpenRequest returns an OpenRequestError if the user already has a pending or active request.
+func (s *service) checkNoOpenRequest(ctx context.Context, userID uuid.UUID) error {
+	repoCtx, cancel := context.WithTimeout(ctx, s.opts.RepoTimeout)
+	open, err := s.repo.HasOpenRequest(repoCtx, userID)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("could not check for open requests: %w", stepError(repoCtx, "HasOpenRequest", err))
+	}
+	if !open {
+		return nil
+	}
+
+	repoCtx, cancel = context.WithTimeout(ctx, s.opts.RepoTimeout)
+	existing, err := s.repo.GetOpenRequestByUser(repoCtx, userID)
+	cancel()
+	if err != nil {
+		// Still blocked, we just can't show which one.
+		fmt.Printf("WARNING: Could not fetch open request for user %s: %v\n", userID, err)
+	}
+	return &OpenRequestError{Existing: existing}
+}
+
+// releaseHold gives back the token held for a request that didn't get created. It does nothing if nothing was held.
+func (s *service) releaseHold(ctx context.Context, userID, holdID uuid.UUID) {
+	if holdID == uuid.Nil {
+		return
+	}
+	billingCtx, cancel := context.WithTimeout(ctx, s.opts.BillingTimeout)
+	err := s.billingClient.ReleaseHold(billingCtx, userID, holdID)
+	cancel()
+	if err != nil {
+		// Not lost, the billing sweeper releases it once it expires. The user just waits for it.
+		fmt.Printf("WARNING: Could not release token hold %s for user %s: %v\n", holdID, userID, err)
+	}
+}
+
+// handleDuplicateRequest releases the token held for a duplicate request and builds the error carrying the existing one.
+func (s *service) handleDuplicateRequest(ctx context.Context, userID uuid.UUID, twilioSID string, holdID uuid.UUID) error {
+	s.releaseHold(ctx, userID, holdID)
+
+	repoCtx, cancel := context.WithTimeout(ctx, s.opts.RepoTimeout)
+	existing, err := s.repo.GetOpenRequestBySID(repoCtx, twilioSID)
+	cancel()
+	if err != nil {
+		// Still a duplicate, we just can't show which one.
+		fmt.Printf("WARNING: Could not fetch existing request for %s: %v\n", twilioSID, err)
+	}
+
+	return &DuplicateRequestError{Existing: existing}
 }
 
 // AcceptRequest orchestrates an expert accepting a pending request.
 func (s *service) AcceptRequest(ctx context.Context, requestID, expertID uuid.UUID) (*domain.AssistanceRequest, error) {
-	// Atomically update the DB. This handles the already accepted race condition.
-	if err := s.repo.AcceptRequest(ctx, requestID, expertID); err != nil {
+	// Check the expert is still allowed to take requests before touching the DB.
+	if _, err := s.getActiveExpert(ctx, expertID); err != nil {
+		return nil, err
+	}
+
+	// Atomically update the DB. This handles the already accepted race condition,
+	// and gives back the updated row so we have the Twilio SID without a second query.
+	req, err := s.repo.AcceptRequest(ctx, requestID, expertID)
+	if errors.Is(err, ErrRequestAlreadyAccepted) {
+		return nil, s.acceptConflict(ctx, requestID, err)
+	}
+	if err != nil {
 		return nil, fmt.Errorf("could not accept request: %w", err)
 	}
 
-	// Need to fetch the request to get its Twilio SID.
-	req, err := s.repo.GetRequestByID(ctx, requestID)
+	return s.joinAcceptedRequest(ctx, req, expertID)
+}
+
+// acceptConflict works out why an accept matched nothing. The atomic UPDATE can't tell a missing request
+// from one that's already taken, so we look it up again: ErrNotFound if it doesn't exist, ErrRequestReserved
+// if it's still pending, otherwise an AlreadyAcceptedError carrying the request so the client can show who won.
+func (s *service) acceptConflict(ctx context.Context, requestID uuid.UUID, acceptErr error) error {
+	repoCtx, cancel := context.WithTimeout(ctx, s.opts.RepoTimeout)
+	current, err := s.repo.GetRequestByID(repoCtx, requestID)
+	cancel()
+	if errors.Is(err, ErrNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		// Still a conflict, we just can't say who won.
+		fmt.Printf("WARNING: Could not fetch request %s after a failed accept: %v\n", requestID, err)
+		return fmt.Errorf("could not accept request: %w", acceptErr)
+	}
+	// Still pending means it wasn't taken, another expert has it reserved.
+	if current.Status == "pending" {
+		return ErrRequestReserved
+	}
+	return &AlreadyAcceptedError{Current: current}
+}
+
+// ClaimNextRequest gives the expert the oldest pending request nobody else is claiming right now.
+// Unlike AcceptRequest, experts racing for the top of the queue each get a different request instead of a conflict.
+func (s *service) ClaimNextRequest(ctx context.Context, expertID uuid.UUID) (*domain.AssistanceRequest, error) {
+	if _, err := s.getActiveExpert(ctx, expertID); err != nil {
+		return nil, err
+	}
+
+	req, err := s.repo.ClaimNextRequest(ctx, expertID)
 	if err != nil {
-		return nil, fmt.Errorf("could not fetch accepted request: %w", err)
+		return nil, fmt.Errorf("could not claim request: %w", err)
 	}
 
+	return s.joinAcceptedRequest(ctx, req, expertID)
+}
+
+// getActiveExpert fetches the expert and returns ErrExpertNotActive if they've been deactivated.
+func (s *service) getActiveExpert(ctx context.Context, expertID uuid.UUID) (*domain.Expert, error) {
+	expertCtx, cancel := context.WithTimeout(ctx, s.opts.UserTimeout)
+	expert, err := s.expertClient.GetExpertProfile(expertCtx, expertID)
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch expert profile: %w", stepError(expertCtx, "GetExpertProfile", err))
+	}
+	if !expert.IsActive {
+		return nil, ErrExpertNotActive
+	}
+	return expert, nil
+}
+
+// joinAcceptedRequest does what's left once the DB has assigned the expert: join the chat. Telling the user is
+// the OutboxNotifyUser event the accept queued.
+func (s *service) joinAcceptedRequest(ctx context.Context, req *domain.AssistanceRequest, expertID uuid.UUID) (*domain.AssistanceRequest, error) {
 	// Add the expert to the Twilio chat.
 	if err := s.chatClient.AddExpert(ctx, req.TwilioConversationSID, expertID); err != nil {
-		// Critical failure - the DB says they accepted, but they can't join the chat.
-		fmt.Printf("CRITICAL: Failed to add expert %s to chat %s: %v\n", expertID, req.TwilioConversationSID, err)
+		// The DB says they accepted, but they can't join the chat. Put the request back so another expert can take it.
+		fmt.Printf("WARNING: Failed to add expert %s to chat %s: %v\n", expertID, req.TwilioConversationSID, err)
+		s.revertAccept(ctx, req, expertID)
 		return nil, fmt.Errorf("failed to add expert to chat: %w", err)
 	}
 
 	return req, nil
 }
 
-// GetPendingRequests is a simple pass through to the repository.
-func (s *service) GetPendingRequests(ctx context.Context) ([]*domain.AssistanceRequest, error) {
-	return s.repo.GetPendingRequests(ctx)
+// revertAccept is the compensation for an accept whose chat join failed: the request goes back to pending.
+// If that fails too the request is stuck active with nobody in the chat, until the idle resolver closes it.
+func (s *service) revertAccept(ctx context.Context, req *domain.AssistanceRequest, expertID uuid.UUID) {
+	repoCtx, cancel := context.WithTimeout(ctx, s.opts.RepoTimeout)
+	err := s.repo.RevertToPending(repoCtx, req.RequestID, expertID)
+	cancel()
+	if err != nil {
+		fmt.Printf("CRITICAL: Could not revert request %s to pending after expert %s failed to join the chat: %v\n", req.RequestID, expertID, err)
+		return
+	}
+	fmt.Printf("WARNING: Reverted request %s to pending, since expert %s could not join the chat\n", req.RequestID, expertID)
 }
 
-// ResolveRequest is a pass through to the repository.
+// GetRequest returns the whole request, summary included, which the queue leaves out. Any expert can read a
+// pending one, since they're deciding whether to take it, and after that only the expert it's assigned to can.
+// A user can only read their own. Anything else is ErrNotFound so request ids can't be probed.
+func (s *service) GetRequest(ctx context.Context, requestID uuid.UUID, caller Caller) (*domain.AssistanceRequest, error) {
+	req, err := s.repo.GetRequestByID(ctx, requestID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get request: %w", err)
+	}
+	if caller.ExpertID != uuid.Nil {
+		if req.Status != "pending" && req.ExpertID.UUID != caller.ExpertID {
+			return nil, ErrNotFound
+		}
+		return req, nil
+	}
+	if req.UserID != caller.UserID {
+		return nil, ErrNotFound
+	}
+	return req, nil
+}
+
+// ResummarizeRequest refreshes the LLM summary of an open request, since the chat keeps going after it's created.
+func (s *service) ResummarizeRequest(ctx context.Context, requestID uuid.UUID) (*domain.AssistanceRequest, error) {
+	req, err := s.repo.GetRequestByID(ctx, requestID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get request: %w", err)
+	}
+
+	// Resolved requests are history, there's nothing to refresh.
+	if req.Status != "pending" && req.Status != "active" {
+		return nil, ErrRequestNotOpen
+	}
+
+	llmCtx, cancel := context.WithTimeout(ctx, s.opts.LLMTimeout)
+	summary, err := s.llmClient.Summarize(llmCtx, req.TwilioConversationSID)
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("could not summarize chat: %w", stepError(llmCtx, "Summarize", err))
+	}
+
+	// The repo checks the status again, in case the request was resolved while the LLM was working.
+	repoCtx, cancel := context.WithTimeout(ctx, s.opts.RepoTimeout)
+	err = s.repo.UpdateSummary(repoCtx, requestID, summary)
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("could not save summary: %w", stepError(repoCtx, "UpdateSummary", err))
+	}
+
+	req.LLMSummary = summary
+	return req, nil
+}
+
+// GetPendingRequests returns a page of the queue as opts.ExpertID sees it, without other experts' reservations.
+// A balanced view is reordered by priority, see balanceQueue. The whole queue is ordered before it's paged,
+// since a balanced view can move a request across a page boundary.
+func (s *service) GetPendingRequests(ctx context.Context, opts QueueOptions) (*domain.Page[*QueuedRequest], error) {
+	now := s.now().UTC()
+	queue, err := s.repo.GetPendingRequests(ctx, opts.ExpertID, now)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Balanced {
+		balanceQueue(queue, now, s.jitter)
+	}
+	return domain.Paginate(queue, opts.Limit, opts.Offset), nil
+}
+
+// ReserveRequest holds a pending request for the expert for ttl (DefaultReservationTTL if zero, at most
+// MaxReservationTTL), so nobody else is shown it or can accept it meanwhile. Returns when the reservation runs out.
+func (s *service) ReserveRequest(ctx context.Context, requestID, expertID uuid.UUID, ttl time.Duration) (time.Time, error) {
+	// A deactivated expert can't accept, so they don't get to hide requests from the others either.
+	if _, err := s.getActiveExpert(ctx, expertID); err != nil {
+		return time.Time{}, err
+	}
+
+	now := s.now().UTC()
+	until := now.Add(clampReservationTTL(ttl))
+	repoCtx, cancel := context.WithTimeout(ctx, s.opts.RepoTimeout)
+	err := s.repo.ReserveRequest(repoCtx, requestID, expertID, now, until)
+	cancel()
+	if errors.Is(err, ErrRequestReserved) {
+		return time.Time{}, s.reserveConflict(ctx, requestID)
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not reserve request: %w", stepError(repoCtx, "ReserveRequest", err))
+	}
+	return until, nil
+}
+
+// reserveConflict works out why a reserve matched nothing, like acceptConflict does for accepts:
+// ErrNotFound, an AlreadyAcceptedError if it's no longer pending, otherwise it's someone else's reservation.
+func (s *service) reserveConflict(ctx context.Context, requestID uuid.UUID) error {
+	repoCtx, cancel := context.WithTimeout(ctx, s.opts.RepoTimeout)
+	current, err := s.repo.GetRequestByID(repoCtx, requestID)
+	cancel()
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return ErrNotFound
+	case err != nil:
+		fmt.Printf("WARNING: Could not fetch request %s after a failed reserve: %v\n", requestID, err)
+		return ErrRequestReserved
+	case current.Status != "pending":
+		return &AlreadyAcceptedError{Current: current}
+	}
+	return ErrRequestReserved
+}
+
+// WatchPendingRequests returns a channel of requests as they become pending, closed once ctx is done.
+func (s *service) WatchPendingRequests(ctx context.Context) <-chan *domain.AssistanceRequest {
+	return s.pending.subscribe(ctx)
+}
+
+// GetRequestByTwilioSID is a pass through to the repository.
+func (s *service) GetRequestByTwilioSID(ctx context.Context, twilioSID string) (*domain.AssistanceRequest, error) {
+	return s.repo.GetRequestByTwilioSID(ctx, twilioSID)
+}
+
+// RecordFirstResponse is called by the chat gateway for messages in a conversation. If the author is the
+// expert on the open request and they haven't written before, it stamps first_response_at with sentAt.
+// Messages from anyone else (the user, the bot, an expert who was transferred away) are ignored.
+// It reports whether the timestamp was set by this call.
+// Any message on an active request also counts as activity, so the idle resolver leaves the request alone.
+func (s *service) RecordFirstResponse(ctx context.Context, twilioSID string, author uuid.UUID, sentAt time.Time) (bool, error) {
+	req, err := s.repo.GetOpenRequestBySID(ctx, twilioSID)
+	if err != nil {
+		return false, err
+	}
+	if req.Status != "active" {
+		return false, nil
+	}
+	// Missing one touch only brings the auto-resolve forward a little, so it's not worth failing the call over.
+	if err := s.repo.TouchRequestActivity(ctx, req.RequestID); err != nil {
+		fmt.Printf("WARNING: Could not record activity on request %s: %v\n", req.RequestID, err)
+	}
+	if !req.ExpertID.Valid || req.ExpertID.UUID != author {
+		return false, nil
+	}
+	if req.FirstResponseAt.Valid {
+		return false, nil // Already set, don't bother the DB.
+	}
+
+	if sentAt.IsZero() {
+		sentAt = time.Now().UTC()
+	}
+	// Twilio's clock and ours can disagree by a little. A response can't come before the expert accepted,
+	// and a negative duration would throw the averages off, so clamp it.
+	if req.AcceptedAt.Valid && sentAt.Before(req.AcceptedAt.Time) {
+		sentAt = req.AcceptedAt.Time
+	}
+
+	return s.repo.SetFirstResponse(ctx, req.RequestID, sentAt)
+}
+
+// GetRequestStats is a pass through to the repository.
+func (s *service) GetRequestStats(ctx context.Context, from, to time.Time) (*RequestStats, error) {
+	return s.repo.GetRequestStats(ctx, from, to)
+}
+
+// SearchRequests lets support look requests up by status, user, expert and date. It's superadmin only,
+// since it shows everyone's requests.
+func (s *service) SearchRequests(ctx context.Context, callerID uuid.UUID, filter SearchFilter) (*SearchResult, error) {
+	if err := s.requireAdmin(ctx, callerID); err != nil {
+		return nil, err
+	}
+	return s.repo.SearchRequests(ctx, filter)
+}
+
+// requireAdmin returns ErrForbidden unless the user is a superadmin.
+func (s *service) requireAdmin(ctx context.Context, userID uuid.UUID) error {
+	userCtx, cancel := context.WithTimeout(ctx, s.opts.UserTimeout)
+	user, err := s.userClient.GetUserProfile(userCtx, userID)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("could not fetch user profile: %w", stepError(userCtx, "GetUserProfile", err))
+	}
+	if user.Role != "superadmin" {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// ResolveRequest marks the request resolved. Only the expert it's assigned to can. It returns ErrNotFound if there's
+// no such request, ErrForbidden if it's active with another expert, or ErrRequestNotActive if it isn't active
+// (still pending, or already resolved).
 func (s *service) ResolveRequest(ctx context.Context, requestID, expertID uuid.UUID) error {
-	// TODO: Verify the expertID here matches the one on the request.
-	return s.repo.ResolveRequest(ctx, requestID)
+	err := s.repo.ResolveRequest(ctx, requestID, expertID)
+	if !errors.Is(err, ErrRequestNotActive) {
+		return err
+	}
+
+	// Like acceptConflict, the UPDATE can't say why nothing matched, so look it up.
+	repoCtx, cancel := context.WithTimeout(ctx, s.opts.RepoTimeout)
+	req, getErr := s.repo.GetRequestByID(repoCtx, requestID)
+	cancel()
+	if errors.Is(getErr, ErrNotFound) {
+		return ErrNotFound
+	}
+	if getErr != nil {
+		fmt.Printf("WARNING: Could not fetch request %s after a failed resolve: %v\n", requestID, getErr)
+		return err
+	}
+	if req.Status == "active" && req.ExpertID.UUID != expertID {
+		return ErrForbidden
+	}
+	return err
+}
+
+// TransferRequest hands an active request from its current expert to targetExpertID, eg to escalate to a specialist.
+// Only the current expert or a superadmin may do it. The new expert joins the chat before the old one is removed,
+// so the user is never left alone in it.
+func (s *service) TransferRequest(ctx context.Context, requestID, targetExpertID uuid.UUID, caller Caller) (*domain.AssistanceRequest, error) {
+	req, err := s.repo.GetRequestByID(ctx, requestID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get request: %w", err)
+	}
+	if req.Status != "active" || !req.ExpertID.Valid {
+		return nil, ErrRequestNotActive
+	}
+	currentExpertID := req.ExpertID.UUID
+
+	actorID, actorRole, err := s.authorizeRequestChange(ctx, req, caller)
+	if err != nil {
+		return nil, err
+	}
+
+	if targetExpertID == currentExpertID {
+		return nil, ErrInvalidTransferTarget
+	}
+	// Missing or deactivated targets come back as ErrExpertNotFound/ErrExpertNotActive.
+	if _, err := s.getActiveExpert(ctx, targetExpertID); err != nil {
+		return nil, err
+	}
+
+	repoCtx, cancel := context.WithTimeout(ctx, s.opts.RepoTimeout)
+	transferred, err := s.repo.TransferRequest(repoCtx, requestID, currentExpertID, targetExpertID, actorID, actorRole)
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("could not transfer request: %w", stepError(repoCtx, "TransferRequest", err))
+	}
+
+	if err := s.chatClient.AddExpert(ctx, transferred.TwilioConversationSID, targetExpertID); err != nil {
+		// Critical failure - the DB says it's theirs, but they can't join the chat.
+		fmt.Printf("CRITICAL: Failed to add expert %s to chat %s after transfer: %v\n", targetExpertID, transferred.TwilioConversationSID, err)
+		return nil, fmt.Errorf("failed to add expert to chat: %w", err)
+	}
+	// The old expert hanging around in the chat is untidy but harmless, so this is best effort.
+	if err := s.chatClient.RemoveExpert(ctx, transferred.TwilioConversationSID, currentExpertID); err != nil {
+		fmt.Printf("WARNING: Failed to remove expert %s from chat %s after transfer: %v\n", currentExpertID, transferred.TwilioConversationSID, err)
+	}
+
+	return transferred, nil
+}
+
+// authorizeRequestChange checks the caller is the request's current expert or a superadmin.
+// It returns the id and role to record in the audit trail.
+func (s *service) authorizeRequestChange(ctx context.Context, req *domain.AssistanceRequest, caller Caller) (uuid.UUID, string, error) {
+	if caller.ExpertID != uuid.Nil {
+		if !req.ExpertID.Valid || req.ExpertID.UUID != caller.ExpertID {
+			return uuid.Nil, "", ErrForbidden
+		}
+		return caller.ExpertID, "expert", nil
+	}
+	if caller.UserID == uuid.Nil {
+		return uuid.Nil, "", ErrForbidden
+	}
+
+	if err := s.requireAdmin(ctx, caller.UserID); err != nil {
+		return uuid.Nil, "", err
+	}
+	return caller.UserID, "admin", nil
+}
+
+// ReopenRequest lets a user undo a resolve when the problem turns out not to be fixed.
+// It only works within Options.ReopenWindow of resolved_at. If the original expert is still active
+// the request goes straight back to them, otherwise it goes back into the pending queue.
+func (s *service) ReopenRequest(ctx context.Context, requestID, userID uuid.UUID) (*domain.AssistanceRequest, error) {
+	req, err := s.repo.GetRequestByID(ctx, requestID)
+	if err != nil {
+		return nil, fmt.Errorf("could not get request: %w", err)
+	}
+	// Someone else's request looks the same as a missing one, so we don't leak which ids exist.
+	if req.UserID != userID {
+		return nil, ErrNotFound
+	}
+	if req.Status != "resolved" || !req.ResolvedAt.Valid {
+		return nil, ErrRequestNotResolved
+	}
+	resolvedAfter := time.Now().UTC().Add(-s.opts.ReopenWindow)
+	if req.ResolvedAt.Time.Before(resolvedAfter) {
+		return nil, ErrReopenWindowExpired
+	}
+
+	// Only hand it back to the same expert if they can still take requests.
+	var expert *domain.Expert
+	if req.ExpertID.Valid {
+		expert, err = s.getActiveExpert(ctx, req.ExpertID.UUID)
+		if err != nil && !errors.Is(err, ErrExpertNotActive) {
+			return nil, err
+		}
+	}
+	keepExpert := expert != nil
+
+	// The repo checks the status and window again in the same statement, so two reopens can't both win.
+	repoCtx, cancel := context.WithTimeout(ctx, s.opts.RepoTimeout)
+	reopened, err := s.repo.ReopenRequest(repoCtx, requestID, resolvedAfter, keepExpert)
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("could not reopen request: %w", stepError(repoCtx, "ReopenRequest", err))
+	}
+
+	if !keepExpert {
+		// Back in the queue, so it's news to anyone watching it.
+		s.pending.publish(reopened)
+		return reopened, nil
+	}
+
+	// We don't know if the expert left the chat after resolving, so add them back either way.
+	// The request is already active again, so a failure here is logged rather than undone.
+	if err := s.chatClient.AddExpert(ctx, reopened.TwilioConversationSID, req.ExpertID.UUID); err != nil {
+		fmt.Printf("WARNING: Failed to re-add expert %s to chat %s: %v\n", req.ExpertID.UUID, reopened.TwilioConversationSID, err)
+	}
+	return reopened, nil
+}
+
+// ExportRequests is a pass through to the repository. The handler streams each row out as it arrives.
+func (s *service) ExportRequests(ctx context.Context, userID uuid.UUID, fn func(*RequestExportRow) error) error {
+	return s.repo.StreamRequestsByUser(ctx, userID, fn)
 }
 
 // SubmitRating builds the rating object and passes it to the repository
@@ -0,0 +1,310 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: service.go
+//
+// Generated by this command:
+//
+//	mockgen -destination=./service_mock_test.go -package=request -source=service.go Service
+//
+
+// Package request is a generated GoMock package.
+package request
+
+import (
+	context "context"
+	domain "project-sage/internal/domain"
+	reflect "reflect"
+	time "time"
+
+	uuid "github.com/google/uuid"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockService is a mock of Service interface.
+type MockService struct {
+	ctrl     *gomock.Controller
+	recorder *MockServiceMockRecorder
+	isgomock struct{}
+}
+
+// MockServiceMockRecorder is the mock recorder for MockService.
+type MockServiceMockRecorder struct {
+	mock *MockService
+}
+
+// NewMockService creates a new mock instance.
+func NewMockService(ctrl *gomock.Controller) *MockService {
+	mock := &MockService{ctrl: ctrl}
+	mock.recorder = &MockServiceMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockService) EXPECT() *MockServiceMockRecorder {
+	return m.recorder
+}
+
+// AcceptRequest mocks base method.
+func (m *MockService) AcceptRequest(ctx context.Context, requestID, expertID uuid.UUID) (*domain.AssistanceRequest, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AcceptRequest", ctx, requestID, expertID)
+	ret0, _ := ret[0].(*domain.AssistanceRequest)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AcceptRequest indicates an expected call of AcceptRequest.
+func (mr *MockServiceMockRecorder) AcceptRequest(ctx, requestID, expertID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AcceptRequest", reflect.TypeOf((*MockService)(nil).AcceptRequest), ctx, requestID, expertID)
+}
+
+// CheckCreateRequest mocks base method.
+func (m *MockService) CheckCreateRequest(ctx context.Context, userID uuid.UUID, requestType string) (*CreateCheck, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CheckCreateRequest", ctx, userID, requestType)
+	ret0, _ := ret[0].(*CreateCheck)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CheckCreateRequest indicates an expected call of CheckCreateRequest.
+func (mr *MockServiceMockRecorder) CheckCreateRequest(ctx, userID, requestType any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CheckCreateRequest", reflect.TypeOf((*MockService)(nil).CheckCreateRequest), ctx, userID, requestType)
+}
+
+// ClaimNextRequest mocks base method.
+func (m *MockService) ClaimNextRequest(ctx context.Context, expertID uuid.UUID) (*domain.AssistanceRequest, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimNextRequest", ctx, expertID)
+	ret0, _ := ret[0].(*domain.AssistanceRequest)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimNextRequest indicates an expected call of ClaimNextRequest.
+func (mr *MockServiceMockRecorder) ClaimNextRequest(ctx, expertID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimNextRequest", reflect.TypeOf((*MockService)(nil).ClaimNextRequest), ctx, expertID)
+}
+
+// CreateRequest mocks base method.
+func (m *MockService) CreateRequest(ctx context.Context, userID uuid.UUID, twilioSID, requestType string) (*CreatedRequest, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateRequest", ctx, userID, twilioSID, requestType)
+	ret0, _ := ret[0].(*CreatedRequest)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateRequest indicates an expected call of CreateRequest.
+func (mr *MockServiceMockRecorder) CreateRequest(ctx, userID, twilioSID, requestType any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateRequest", reflect.TypeOf((*MockService)(nil).CreateRequest), ctx, userID, twilioSID, requestType)
+}
+
+// ExportRequests mocks base method.
+func (m *MockService) ExportRequests(ctx context.Context, userID uuid.UUID, fn func(*RequestExportRow) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExportRequests", ctx, userID, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ExportRequests indicates an expected call of ExportRequests.
+func (mr *MockServiceMockRecorder) ExportRequests(ctx, userID, fn any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportRequests", reflect.TypeOf((*MockService)(nil).ExportRequests), ctx, userID, fn)
+}
+
+// GetPendingRequests mocks base method.
+func (m *MockService) GetPendingRequests(ctx context.Context, opts QueueOptions) (*domain.Page[*QueuedRequest], error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPendingRequests", ctx, opts)
+	ret0, _ := ret[0].(*domain.Page[*QueuedRequest])
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPendingRequests indicates an expected call of GetPendingRequests.
+func (mr *MockServiceMockRecorder) GetPendingRequests(ctx, opts any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPendingRequests", reflect.TypeOf((*MockService)(nil).GetPendingRequests), ctx, opts)
+}
+
+// GetRequest mocks base method.
+func (m *MockService) GetRequest(ctx context.Context, requestID uuid.UUID, caller Caller) (*domain.AssistanceRequest, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRequest", ctx, requestID, caller)
+	ret0, _ := ret[0].(*domain.AssistanceRequest)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRequest indicates an expected call of GetRequest.
+func (mr *MockServiceMockRecorder) GetRequest(ctx, requestID, caller any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRequest", reflect.TypeOf((*MockService)(nil).GetRequest), ctx, requestID, caller)
+}
+
+// GetRequestByTwilioSID mocks base method.
+func (m *MockService) GetRequestByTwilioSID(ctx context.Context, twilioSID string) (*domain.AssistanceRequest, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRequestByTwilioSID", ctx, twilioSID)
+	ret0, _ := ret[0].(*domain.AssistanceRequest)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRequestByTwilioSID indicates an expected call of GetRequestByTwilioSID.
+func (mr *MockServiceMockRecorder) GetRequestByTwilioSID(ctx, twilioSID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRequestByTwilioSID", reflect.TypeOf((*MockService)(nil).GetRequestByTwilioSID), ctx, twilioSID)
+}
+
+// GetRequestStats mocks base method.
+func (m *MockService) GetRequestStats(ctx context.Context, from, to time.Time) (*RequestStats, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRequestStats", ctx, from, to)
+	ret0, _ := ret[0].(*RequestStats)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRequestStats indicates an expected call of GetRequestStats.
+func (mr *MockServiceMockRecorder) GetRequestStats(ctx, from, to any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRequestStats", reflect.TypeOf((*MockService)(nil).GetRequestStats), ctx, from, to)
+}
+
+// RecordFirstResponse mocks base method.
+func (m *MockService) RecordFirstResponse(ctx context.Context, twilioSID string, author uuid.UUID, sentAt time.Time) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RecordFirstResponse", ctx, twilioSID, author, sentAt)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RecordFirstResponse indicates an expected call of RecordFirstResponse.
+func (mr *MockServiceMockRecorder) RecordFirstResponse(ctx, twilioSID, author, sentAt any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordFirstResponse", reflect.TypeOf((*MockService)(nil).RecordFirstResponse), ctx, twilioSID, author, sentAt)
+}
+
+// ReopenRequest mocks base method.
+func (m *MockService) ReopenRequest(ctx context.Context, requestID, userID uuid.UUID) (*domain.AssistanceRequest, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReopenRequest", ctx, requestID, userID)
+	ret0, _ := ret[0].(*domain.AssistanceRequest)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReopenRequest indicates an expected call of ReopenRequest.
+func (mr *MockServiceMockRecorder) ReopenRequest(ctx, requestID, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReopenRequest", reflect.TypeOf((*MockService)(nil).ReopenRequest), ctx, requestID, userID)
+}
+
+// ReserveRequest mocks base method.
+func (m *MockService) ReserveRequest(ctx context.Context, requestID, expertID uuid.UUID, ttl time.Duration) (time.Time, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReserveRequest", ctx, requestID, expertID, ttl)
+	ret0, _ := ret[0].(time.Time)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReserveRequest indicates an expected call of ReserveRequest.
+func (mr *MockServiceMockRecorder) ReserveRequest(ctx, requestID, expertID, ttl any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReserveRequest", reflect.TypeOf((*MockService)(nil).ReserveRequest), ctx, requestID, expertID, ttl)
+}
+
+// ResolveRequest mocks base method.
+func (m *MockService) ResolveRequest(ctx context.Context, requestID, expertID uuid.UUID) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResolveRequest", ctx, requestID, expertID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResolveRequest indicates an expected call of ResolveRequest.
+func (mr *MockServiceMockRecorder) ResolveRequest(ctx, requestID, expertID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResolveRequest", reflect.TypeOf((*MockService)(nil).ResolveRequest), ctx, requestID, expertID)
+}
+
+// ResummarizeRequest mocks base method.
+func (m *MockService) ResummarizeRequest(ctx context.Context, requestID uuid.UUID) (*domain.AssistanceRequest, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResummarizeRequest", ctx, requestID)
+	ret0, _ := ret[0].(*domain.AssistanceRequest)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ResummarizeRequest indicates an expected call of ResummarizeRequest.
+func (mr *MockServiceMockRecorder) ResummarizeRequest(ctx, requestID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResummarizeRequest", reflect.TypeOf((*MockService)(nil).ResummarizeRequest), ctx, requestID)
+}
+
+// SearchRequests mocks base method.
+func (m *MockService) SearchRequests(ctx context.Context, callerID uuid.UUID, filter SearchFilter) (*SearchResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SearchRequests", ctx, callerID, filter)
+	ret0, _ := ret[0].(*SearchResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SearchRequests indicates an expected call of SearchRequests.
+func (mr *MockServiceMockRecorder) SearchRequests(ctx, callerID, filter any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchRequests", reflect.TypeOf((*MockService)(nil).SearchRequests), ctx, callerID, filter)
+}
+
+// SubmitRating mocks base method.
+func (m *MockService) SubmitRating(ctx context.Context, reqID, userID, expertID uuid.UUID, score int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SubmitRating", ctx, reqID, userID, expertID, score)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SubmitRating indicates an expected call of SubmitRating.
+func (mr *MockServiceMockRecorder) SubmitRating(ctx, reqID, userID, expertID, score any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SubmitRating", reflect.TypeOf((*MockService)(nil).SubmitRating), ctx, reqID, userID, expertID, score)
+}
+
+// TransferRequest mocks base method.
+func (m *MockService) TransferRequest(ctx context.Context, requestID, targetExpertID uuid.UUID, caller Caller) (*domain.AssistanceRequest, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TransferRequest", ctx, requestID, targetExpertID, caller)
+	ret0, _ := ret[0].(*domain.AssistanceRequest)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TransferRequest indicates an expected call of TransferRequest.
+func (mr *MockServiceMockRecorder) TransferRequest(ctx, requestID, targetExpertID, caller any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TransferRequest", reflect.TypeOf((*MockService)(nil).TransferRequest), ctx, requestID, targetExpertID, caller)
+}
+
+// WatchPendingRequests mocks base method.
+func (m *MockService) WatchPendingRequests(ctx context.Context) <-chan *domain.AssistanceRequest {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WatchPendingRequests", ctx)
+	ret0, _ := ret[0].(<-chan *domain.AssistanceRequest)
+	return ret0
+}
+
+// WatchPendingRequests indicates an expected call of WatchPendingRequests.
+func (mr *MockServiceMockRecorder) WatchPendingRequests(ctx any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WatchPendingRequests", reflect.TypeOf((*MockService)(nil).WatchPendingRequests), ctx)
+}
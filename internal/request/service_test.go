@@ -2,9 +2,12 @@ package request
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"project-sage/internal/domain" // The shared domain models
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"go.uber.org/mock/gomock" // Mocking library
@@ -13,7 +16,7 @@ import (
 // This is the unit test for the service layer, the orchestrator.
 
 // setupMocks is a helper function to initialize all the mocks for each test.
-func setupMocks(t *testing.T) (context.Context, *MockRepository, *MockBillingClient, *MockLLMClient, *MockChatClient, *MockUserClient, *gomock.Controller) {
+func setupMocks(t *testing.T) (context.Context, *MockRepository, *MockBillingClient, *MockLLMClient, *MockChatClient, *MockUserClient, *MockExpertClient, *MockNotificationClient, *gomock.Controller) {
 	ctrl := gomock.NewController(t)
 	// This returns all the mocks and the controller to manage them.
 	return context.Background(),
@@ -22,49 +25,63 @@ func setupMocks(t *testing.T) (context.Context, *MockRepository, *MockBillingCli
 		NewMockLLMClient(ctrl),
 		NewMockChatClient(ctrl),
 		NewMockUserClient(ctrl),
+		NewMockExpertClient(ctrl),
+		NewMockNotificationClient(ctrl),
 		ctrl
 }
 
 // TestService_CreateRequest_Success_NormalUser tests the "happy path" for a regular user.
 func TestService_CreateRequest_Success_NormalUser(t *testing.T) {
 	// set up all mocks.
-	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, ctrl := setupMocks(t)
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, mockNotify, ctrl := setupMocks(t)
 	defer ctrl.Finish()
 
 	userID := uuid.New()
+	holdID := uuid.New()
 	twilioSID := "twilio-sid-123"
 	expectedSummary := "User needs help."
 	mockUser := &domain.User{UserID: userID, Role: "user"}
 
 	// We define the exact sequence of calls we expect the service to make.
+	// Each call gets its own timeout context, so the ctx argument is matched with Any.
 	gomock.InOrder(
-		mockUserClient.EXPECT().GetUserProfile(ctx, userID).Return(mockUser, nil).Times(1),
+		mockUserClient.EXPECT().GetUserProfile(gomock.Any(), userID).Return(mockUser, nil).Times(1),
+		mockRepo.EXPECT().HasOpenRequest(gomock.Any(), userID).Return(false, nil).Times(1),
 
-		// Debit token must be called next for a normal "user".
-		mockBilling.EXPECT().DebitToken(ctx, userID).Return(nil).Times(1),
+		// A token is held next for a normal "user".
+		mockBilling.EXPECT().HoldToken(gomock.Any(), userID, 1, gomock.Any()).Return(holdID, 4, nil).Times(1),
 
 		// Summarize must be called next.
-		mockLLM.EXPECT().Summarize(ctx, twilioSID).Return(expectedSummary, nil).Times(1),
+		mockLLM.EXPECT().Summarize(gomock.Any(), twilioSID).Return(expectedSummary, nil).Times(1),
 
 		// CreateRequest in my own repo is called third.
-		mockRepo.EXPECT().CreateRequest(ctx, gomock.Any()).DoAndReturn(
-			func(ctx context.Context, req *domain.AssistanceRequest) error {
+		mockRepo.EXPECT().CreateRequest(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, req *domain.AssistanceRequest, events []string) error {
 				if req.UserID != userID {
 					t.Errorf("UserID mismatch in CreateRequest")
 				}
 				if req.LLMSummary != expectedSummary {
 					t.Errorf("Summary mismatch in CreateRequest")
 				}
+				// Removing the bot and telling the experts are saved with the request, for the outbox dispatcher.
+				if len(events) != 2 || events[0] != OutboxRemoveBot || events[1] != OutboxNotifyExperts {
+					t.Errorf("Expected remove_bot and notify_experts outbox events, got %v", events)
+				}
 				return nil
 			}).Times(1),
 
-		//  RemoveBot is the last step.
-		mockChat.EXPECT().RemoveBot(ctx, twilioSID).Return(nil).Times(1),
+		// The request exists, so the hold is spent.
+		mockBilling.EXPECT().CommitHold(gomock.Any(), userID, holdID).Return(nil).Times(1),
 	)
+	mockBilling.EXPECT().ReleaseHold(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	// Those calls are the dispatcher's job now, not the create's.
+	mockChat.EXPECT().RemoveBot(gomock.Any(), gomock.Any()).Times(0)
+	mockNotify.EXPECT().NotifyExperts(gomock.Any(), gomock.Any()).Times(0)
 
 	// Create the service and call the method.
-	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient)
-	req, err := s.CreateRequest(ctx, userID, twilioSID)
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+	req, err := s.CreateRequest(ctx, userID, twilioSID, "")
 
 	// check that everything went well
 	if err != nil {
@@ -73,11 +90,15 @@ func TestService_CreateRequest_Success_NormalUser(t *testing.T) {
 	if req == nil {
 		t.Fatal("CreateRequest() returned nil request")
 	}
+	// The balance the hold left comes back with the request.
+	if req.RemainingBalance == nil || *req.RemainingBalance != 4 {
+		t.Errorf("Expected remaining balance 4, got %v", req.RemainingBalance)
+	}
 }
 
 // TestService_CreateRequest_Success_SuperAdmin tests the path for a superadmin.
 func TestService_CreateRequest_Success_SuperAdmin(t *testing.T) {
-	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, ctrl := setupMocks(t)
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
 	defer ctrl.Finish()
 
 	userID := uuid.New()
@@ -86,48 +107,52 @@ func TestService_CreateRequest_Success_SuperAdmin(t *testing.T) {
 	mockSuperAdmin := &domain.User{UserID: userID, Role: "superadmin"}
 
 	gomock.InOrder(
-		mockUserClient.EXPECT().GetUserProfile(ctx, userID).Return(mockSuperAdmin, nil).Times(1),
+		mockUserClient.EXPECT().GetUserProfile(gomock.Any(), userID).Return(mockSuperAdmin, nil).Times(1),
 
 		// Summarize is called next.
-		mockLLM.EXPECT().Summarize(ctx, twilioSID).Return(expectedSummary, nil).Times(1),
+		mockLLM.EXPECT().Summarize(gomock.Any(), twilioSID).Return(expectedSummary, nil).Times(1),
 
 		// CreateRequest is called.
-		mockRepo.EXPECT().CreateRequest(ctx, gomock.Any()).Return(nil).Times(1),
-
-		// RemoveBot is the last step.
-		mockChat.EXPECT().RemoveBot(ctx, twilioSID).Return(nil).Times(1),
+		mockRepo.EXPECT().CreateRequest(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(1),
 	)
 
 	// Expect the billing client to *never* be called.
-	mockBilling.EXPECT().DebitToken(gomock.Any(), gomock.Any()).Times(0)
+	mockBilling.EXPECT().HoldToken(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	mockBilling.EXPECT().CommitHold(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	// Superadmins can have several requests open, so the check is skipped too.
+	mockRepo.EXPECT().HasOpenRequest(gomock.Any(), gomock.Any()).Times(0)
 
-	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient)
-	_, err := s.CreateRequest(ctx, userID, twilioSID)
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+	req, err := s.CreateRequest(ctx, userID, twilioSID, "")
 
 	if err != nil {
 		t.Fatalf("CreateRequest() returned unexpected error: %v", err)
 	}
+	// Nothing was held, so there's no balance to report.
+	if req.RemainingBalance != nil {
+		t.Errorf("Expected no remaining balance for a superadmin, got %d", *req.RemainingBalance)
+	}
 }
 
 // TestService_CreateRequest_Fail_GetUserProfile tests when the very first step fails.
 func TestService_CreateRequest_Fail_GetUserProfile(t *testing.T) {
-	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, ctrl := setupMocks(t)
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
 	defer ctrl.Finish()
 
 	userID := uuid.New()
 	twilioSID := "twilio-sid-456"
 	expectedErr := fmt.Errorf("user service is down")
 
-	mockUserClient.EXPECT().GetUserProfile(ctx, userID).Return(nil, expectedErr).Times(1)
+	mockUserClient.EXPECT().GetUserProfile(gomock.Any(), userID).Return(nil, expectedErr).Times(1)
 
 	// Expect all other clients to never be called.
-	mockBilling.EXPECT().DebitToken(gomock.Any(), gomock.Any()).Times(0)
+	mockBilling.EXPECT().HoldToken(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
 	mockLLM.EXPECT().Summarize(gomock.Any(), gomock.Any()).Times(0)
-	mockRepo.EXPECT().CreateRequest(gomock.Any(), gomock.Any()).Times(0)
+	mockRepo.EXPECT().CreateRequest(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
 	mockChat.EXPECT().RemoveBot(gomock.Any(), gomock.Any()).Times(0)
 
-	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient)
-	_, err := s.CreateRequest(ctx, userID, twilioSID)
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+	_, err := s.CreateRequest(ctx, userID, twilioSID, "")
 
 	if err == nil {
 		t.Fatal("Expected an error but got nil")
@@ -137,63 +162,70 @@ func TestService_CreateRequest_Fail_GetUserProfile(t *testing.T) {
 	}
 }
 
-// TestService_CreateRequest_InsufficientFunds tests the failure case where the debiting fails.
+// TestService_CreateRequest_InsufficientFunds tests the failure case where the hold fails.
 func TestService_CreateRequest_InsufficientFunds(t *testing.T) {
-	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, ctrl := setupMocks(t)
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
 	defer ctrl.Finish()
 
 	userID := uuid.New()
 	twilioSID := "twilio-sid-456"
-	expectedErr := fmt.Errorf("insufficient funds")
+	expectedErr := ErrInsufficientFunds
 	mockUser := &domain.User{UserID: userID, Role: "user"} // A normal user
 
 	gomock.InOrder(
-		mockUserClient.EXPECT().GetUserProfile(ctx, userID).Return(mockUser, nil).Times(1),
-		// Debit token fails.
-		mockBilling.EXPECT().DebitToken(ctx, userID).Return(expectedErr).Times(1),
+		mockUserClient.EXPECT().GetUserProfile(gomock.Any(), userID).Return(mockUser, nil).Times(1),
+		mockRepo.EXPECT().HasOpenRequest(gomock.Any(), userID).Return(false, nil).Times(1),
+		// The hold fails.
+		mockBilling.EXPECT().HoldToken(gomock.Any(), userID, 1, gomock.Any()).Return(uuid.Nil, 0, expectedErr).Times(1),
 	)
+	mockBilling.EXPECT().ReleaseHold(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
 
 	// Expect the other clients to never be called.
 	mockLLM.EXPECT().Summarize(gomock.Any(), gomock.Any()).Times(0)
-	mockRepo.EXPECT().CreateRequest(gomock.Any(), gomock.Any()).Times(0)
+	mockRepo.EXPECT().CreateRequest(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
 	mockChat.EXPECT().RemoveBot(gomock.Any(), gomock.Any()).Times(0)
 
-	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient)
-	_, err := s.CreateRequest(ctx, userID, twilioSID)
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+	_, err := s.CreateRequest(ctx, userID, twilioSID, "")
 
 	if err == nil {
 		t.Fatal("Expected an error but got nil")
 	}
-	if err.Error() != "token debit failed: insufficient funds" {
+	if !errors.Is(err, ErrInsufficientFunds) {
 		t.Fatalf("Expected 'insufficient funds' error, got: %v", err)
 	}
 }
 
 // TestService_CreateRequest_LLMFailure tests a failure in the middle of the orchestration.
 func TestService_CreateRequest_LLMFailure(t *testing.T) {
-	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, ctrl := setupMocks(t)
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
 	defer ctrl.Finish()
 
 	userID := uuid.New()
+	holdID := uuid.New()
 	twilioSID := "twilio-sid-789"
 	expectedErr := fmt.Errorf("LLM API is down")
 	mockUser := &domain.User{UserID: userID, Role: "user"} // A normal user
 
 	// Expect the first steps to happen in order.
 	gomock.InOrder(
-		mockUserClient.EXPECT().GetUserProfile(ctx, userID).Return(mockUser, nil).Times(1),
-		// Debit succeeds.
-		mockBilling.EXPECT().DebitToken(ctx, userID).Return(nil).Times(1),
+		mockUserClient.EXPECT().GetUserProfile(gomock.Any(), userID).Return(mockUser, nil).Times(1),
+		mockRepo.EXPECT().HasOpenRequest(gomock.Any(), userID).Return(false, nil).Times(1),
+		// The hold succeeds.
+		mockBilling.EXPECT().HoldToken(gomock.Any(), userID, 1, gomock.Any()).Return(holdID, 4, nil).Times(1),
 		// LLM fails.
-		mockLLM.EXPECT().Summarize(ctx, twilioSID).Return("", expectedErr).Times(1),
+		mockLLM.EXPECT().Summarize(gomock.Any(), twilioSID).Return("", expectedErr).Times(1),
+		// So the token goes straight back.
+		mockBilling.EXPECT().ReleaseHold(gomock.Any(), userID, holdID).Return(nil).Times(1),
 	)
 
 	// The flow should stop here. These should not be called.
-	mockRepo.EXPECT().CreateRequest(gomock.Any(), gomock.Any()).Times(0)
+	mockRepo.EXPECT().CreateRequest(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	mockBilling.EXPECT().CommitHold(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
 	mockChat.EXPECT().RemoveBot(gomock.Any(), gomock.Any()).Times(0)
 
-	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient)
-	_, err := s.CreateRequest(ctx, userID, twilioSID)
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+	_, err := s.CreateRequest(ctx, userID, twilioSID, "")
 
 	if err == nil {
 		t.Fatal("Expected an error but got nil")
@@ -205,26 +237,32 @@ func TestService_CreateRequest_LLMFailure(t *testing.T) {
 
 // TestService_AcceptRequest_Success tests the happy path for an expert accepting a request.
 func TestService_AcceptRequest_Success(t *testing.T) {
-	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, ctrl := setupMocks(t)
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, mockNotify, ctrl := setupMocks(t)
 	defer ctrl.Finish()
 
 	reqID := uuid.New()
+	userID := uuid.New()
 	expertID := uuid.New()
 	twilioSID := "twilio-sid-abc"
 	mockRequest := &domain.AssistanceRequest{
 		RequestID:             reqID,
+		UserID:                userID,
 		ExpertID:              uuid.NullUUID{UUID: expertID, Valid: true},
 		TwilioConversationSID: twilioSID,
 		Status:                "active",
 	}
+	expert := &domain.Expert{ExpertID: expertID, DisplayName: "Joe", IsActive: true}
 
 	gomock.InOrder(
-		mockRepo.EXPECT().AcceptRequest(ctx, reqID, expertID).Return(nil).Times(1),
-		mockRepo.EXPECT().GetRequestByID(ctx, reqID).Return(mockRequest, nil).Times(1),
+		// The expert is checked first.
+		mockExpert.EXPECT().GetExpertProfile(gomock.Any(), expertID).Return(expert, nil).Times(1),
+		mockRepo.EXPECT().AcceptRequest(ctx, reqID, expertID).Return(mockRequest, nil).Times(1),
 		mockChat.EXPECT().AddExpert(ctx, twilioSID, expertID).Return(nil).Times(1),
 	)
+	// The user is told by the outbox, from the event the accept wrote, not from here.
+	mockNotify.EXPECT().NotifyUser(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
 
-	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient)
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
 	req, err := s.AcceptRequest(ctx, reqID, expertID)
 
 	if err != nil {
@@ -235,27 +273,1016 @@ func TestService_AcceptRequest_Success(t *testing.T) {
 	}
 }
 
+// TestService_AcceptRequest_ChatFails tests that an expert who can't join the chat doesn't keep the request:
+// it's reverted to pending for someone else, and the user isn't told anyone joined.
+func TestService_AcceptRequest_ChatFails(t *testing.T) {
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, mockNotify, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	reqID := uuid.New()
+	expertID := uuid.New()
+	mockRequest := &domain.AssistanceRequest{
+		RequestID:             reqID,
+		UserID:                uuid.New(),
+		ExpertID:              uuid.NullUUID{UUID: expertID, Valid: true},
+		TwilioConversationSID: "twilio-sid-ghi",
+		Status:                "active",
+	}
+	chatErr := errors.New("twilio down")
+
+	gomock.InOrder(
+		mockExpert.EXPECT().GetExpertProfile(gomock.Any(), expertID).Return(&domain.Expert{ExpertID: expertID, IsActive: true}, nil).Times(1),
+		mockRepo.EXPECT().AcceptRequest(ctx, reqID, expertID).Return(mockRequest, nil).Times(1),
+		mockChat.EXPECT().AddExpert(ctx, "twilio-sid-ghi", expertID).Return(chatErr).Times(1),
+		mockRepo.EXPECT().RevertToPending(gomock.Any(), reqID, expertID).Return(nil).Times(1),
+	)
+	mockNotify.EXPECT().NotifyUser(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+	_, err := s.AcceptRequest(ctx, reqID, expertID)
+
+	if !errors.Is(err, chatErr) {
+		t.Fatalf("Expected the chat error, got: %v", err)
+	}
+}
+
 // TestService_AcceptRequest_AlreadyAccepted tests the race condition.
 func TestService_AcceptRequest_AlreadyAccepted(t *testing.T) {
-	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, ctrl := setupMocks(t)
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
 	defer ctrl.Finish()
 
 	reqID := uuid.New()
 	expertID := uuid.New()
-	expectedErr := fmt.Errorf("request... already accepted")
+	expectedErr := ErrRequestAlreadyAccepted
 
-	mockRepo.EXPECT().AcceptRequest(ctx, reqID, expertID).Return(expectedErr).Times(1)
+	mockExpert.EXPECT().GetExpertProfile(gomock.Any(), expertID).Return(&domain.Expert{ExpertID: expertID, IsActive: true}, nil).Times(1)
+	mockRepo.EXPECT().AcceptRequest(ctx, reqID, expertID).Return(nil, expectedErr).Times(1)
+	// If the re-fetch fails it's still the same conflict, just without the details.
+	mockRepo.EXPECT().GetRequestByID(gomock.Any(), reqID).Return(nil, fmt.Errorf("db is down")).Times(1)
 
-	mockRepo.EXPECT().GetRequestByID(gomock.Any(), gomock.Any()).Times(0)
 	mockChat.EXPECT().AddExpert(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
 
-	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient)
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
 	_, err := s.AcceptRequest(ctx, reqID, expertID)
 
 	if err == nil {
 		t.Fatal("Expected an error but got nil")
 	}
-	if err.Error() != "could not accept request: request... already accepted" {
+	if !errors.Is(err, ErrRequestAlreadyAccepted) {
 		t.Fatalf("Wrong error returned: %v", err)
 	}
 }
+
+// TestService_AcceptRequest_AlreadyAccepted_Winner tests that losing the race tells us who won.
+func TestService_AcceptRequest_AlreadyAccepted_Winner(t *testing.T) {
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	reqID := uuid.New()
+	expertID := uuid.New()
+	winnerID := uuid.New()
+	acceptedAt := time.Now().UTC().Add(-time.Second)
+	winning := &domain.AssistanceRequest{
+		RequestID:  reqID,
+		Status:     "active",
+		ExpertID:   uuid.NullUUID{UUID: winnerID, Valid: true},
+		AcceptedAt: sql.NullTime{Time: acceptedAt, Valid: true},
+	}
+
+	mockExpert.EXPECT().GetExpertProfile(gomock.Any(), expertID).Return(&domain.Expert{ExpertID: expertID, IsActive: true}, nil).Times(1)
+	mockRepo.EXPECT().AcceptRequest(ctx, reqID, expertID).Return(nil, ErrRequestAlreadyAccepted).Times(1)
+	mockRepo.EXPECT().GetRequestByID(gomock.Any(), reqID).Return(winning, nil).Times(1)
+	mockChat.EXPECT().AddExpert(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+	_, err := s.AcceptRequest(ctx, reqID, expertID)
+
+	var acceptedErr *AlreadyAcceptedError
+	if !errors.As(err, &acceptedErr) {
+		t.Fatalf("Expected an AlreadyAcceptedError, got %v", err)
+	}
+	if !errors.Is(err, ErrRequestAlreadyAccepted) {
+		t.Errorf("Expected it to still match ErrRequestAlreadyAccepted")
+	}
+	if acceptedErr.Current.ExpertID.UUID != winnerID || !acceptedErr.Current.AcceptedAt.Time.Equal(acceptedAt) {
+		t.Errorf("Expected winner %s at %v, got %+v", winnerID, acceptedAt, acceptedErr.Current)
+	}
+}
+
+// TestService_AcceptRequest_NotFound tests that a request that doesn't exist isn't reported as taken.
+func TestService_AcceptRequest_NotFound(t *testing.T) {
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	reqID := uuid.New()
+	expertID := uuid.New()
+
+	mockExpert.EXPECT().GetExpertProfile(gomock.Any(), expertID).Return(&domain.Expert{ExpertID: expertID, IsActive: true}, nil).Times(1)
+	mockRepo.EXPECT().AcceptRequest(ctx, reqID, expertID).Return(nil, ErrRequestAlreadyAccepted).Times(1)
+	mockRepo.EXPECT().GetRequestByID(gomock.Any(), reqID).Return(nil, ErrNotFound).Times(1)
+
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+	_, err := s.AcceptRequest(ctx, reqID, expertID)
+
+	if !errors.Is(err, ErrNotFound) || errors.Is(err, ErrRequestAlreadyAccepted) {
+		t.Fatalf("Expected ErrNotFound only, got %v", err)
+	}
+}
+
+// TestService_ResolveRequest_NotActive tests a failed resolve is reported as missing, someone else's or not active,
+// not a plain error.
+func TestService_ResolveRequest_NotActive(t *testing.T) {
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	missingID := uuid.New()
+	resolvedID := uuid.New()
+	othersID := uuid.New()
+	expertID := uuid.New()
+
+	mockRepo.EXPECT().ResolveRequest(ctx, missingID, expertID).Return(ErrRequestNotActive)
+	mockRepo.EXPECT().GetRequestByID(gomock.Any(), missingID).Return(nil, ErrNotFound)
+	mockRepo.EXPECT().ResolveRequest(ctx, resolvedID, expertID).Return(ErrRequestNotActive)
+	mockRepo.EXPECT().GetRequestByID(gomock.Any(), resolvedID).Return(&domain.AssistanceRequest{RequestID: resolvedID, Status: "resolved"}, nil)
+	mockRepo.EXPECT().ResolveRequest(ctx, othersID, expertID).Return(ErrRequestNotActive)
+	mockRepo.EXPECT().GetRequestByID(gomock.Any(), othersID).
+		Return(&domain.AssistanceRequest{RequestID: othersID, Status: "active", ExpertID: uuid.NullUUID{UUID: uuid.New(), Valid: true}}, nil)
+
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+	if err := s.ResolveRequest(ctx, missingID, expertID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing request, got %v", err)
+	}
+	if err := s.ResolveRequest(ctx, resolvedID, expertID); !errors.Is(err, ErrRequestNotActive) {
+		t.Errorf("Expected ErrRequestNotActive for a resolved request, got %v", err)
+	}
+	if err := s.ResolveRequest(ctx, othersID, expertID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected ErrForbidden for another expert's request, got %v", err)
+	}
+}
+
+// TestService_AcceptRequest_ExpertNotActive tests that a switched off expert can't accept anything.
+func TestService_AcceptRequest_ExpertNotActive(t *testing.T) {
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	reqID := uuid.New()
+	expertID := uuid.New()
+
+	mockExpert.EXPECT().GetExpertProfile(gomock.Any(), expertID).Return(&domain.Expert{ExpertID: expertID, IsActive: false}, nil).Times(1)
+
+	// The request must not be touched.
+	mockRepo.EXPECT().AcceptRequest(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	mockChat.EXPECT().AddExpert(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+	_, err := s.AcceptRequest(ctx, reqID, expertID)
+
+	if !errors.Is(err, ErrExpertNotActive) {
+		t.Fatalf("Expected ErrExpertNotActive, got: %v", err)
+	}
+}
+
+// slowLLMClient is a fake LLMClient that takes longer than its budget to answer.
+type slowLLMClient struct {
+	delay time.Duration
+}
+
+func (c *slowLLMClient) Summarize(ctx context.Context, twilioSID string) (string, error) {
+	select {
+	case <-time.After(c.delay):
+		return "too late", nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// TestService_CreateRequest_LLMTimeout tests that a slow LLM call is cut off by its own timeout and reported by name.
+func TestService_CreateRequest_LLMTimeout(t *testing.T) {
+	ctx, mockRepo, mockBilling, _, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	userID := uuid.New()
+	twilioSID := "twilio-sid-slow"
+	mockUser := &domain.User{UserID: userID, Role: "user"}
+
+	gomock.InOrder(
+		mockUserClient.EXPECT().GetUserProfile(gomock.Any(), userID).Return(mockUser, nil).Times(1),
+		mockRepo.EXPECT().HasOpenRequest(gomock.Any(), userID).Return(false, nil).Times(1),
+		mockBilling.EXPECT().HoldToken(gomock.Any(), userID, 1, gomock.Any()).Return(uuid.New(), 4, nil).Times(1),
+		mockBilling.EXPECT().ReleaseHold(gomock.Any(), userID, gomock.Any()).Return(nil).Times(1),
+	)
+
+	// Nothing after the summary should run.
+	mockRepo.EXPECT().CreateRequest(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	mockChat.EXPECT().RemoveBot(gomock.Any(), gomock.Any()).Times(0)
+
+	// The LLM sleeps well past its 20ms budget.
+	slowLLM := &slowLLMClient{delay: time.Second}
+	opts := Options{LLMTimeout: 20 * time.Millisecond}
+
+	s := NewServiceWithOptions(mockRepo, mockBilling, slowLLM, mockChat, mockUserClient, mockExpert, opts)
+
+	start := time.Now()
+	_, err := s.CreateRequest(ctx, userID, twilioSID, "")
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatal("Expected a timeout error but got nil")
+	}
+	var stepErr *StepTimeoutError
+	if !errors.As(err, &stepErr) {
+		t.Fatalf("Expected a StepTimeoutError, got: %v", err)
+	}
+	if stepErr.Step != "Summarize" {
+		t.Errorf("Expected step 'Summarize', got '%s'", stepErr.Step)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected error to wrap context.DeadlineExceeded")
+	}
+	if elapsed > 500*time.Millisecond {
+		t.Errorf("CreateRequest took %v, the LLM timeout was not applied", elapsed)
+	}
+}
+
+// TestService_ResummarizeRequest_Success tests that the fresh summary is saved and returned.
+func TestService_ResummarizeRequest_Success(t *testing.T) {
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	reqID := uuid.New()
+	twilioSID := "twilio-sid-resum"
+	mockRequest := &domain.AssistanceRequest{
+		RequestID:             reqID,
+		TwilioConversationSID: twilioSID,
+		LLMSummary:            "Old summary.",
+		Status:                "pending",
+	}
+
+	gomock.InOrder(
+		mockRepo.EXPECT().GetRequestByID(ctx, reqID).Return(mockRequest, nil).Times(1),
+		mockLLM.EXPECT().Summarize(gomock.Any(), twilioSID).Return("New summary.", nil).Times(1),
+		mockRepo.EXPECT().UpdateSummary(gomock.Any(), reqID, "New summary.").Return(nil).Times(1),
+	)
+
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+	req, err := s.ResummarizeRequest(ctx, reqID)
+
+	if err != nil {
+		t.Fatalf("ResummarizeRequest() returned unexpected error: %v", err)
+	}
+	if req.LLMSummary != "New summary." {
+		t.Errorf("Expected summary 'New summary.', got '%s'", req.LLMSummary)
+	}
+}
+
+// TestService_GetRequest_Callers checks any expert and the owning user can read a request, and another user gets ErrNotFound.
+func TestService_GetRequest_Callers(t *testing.T) {
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	req := &domain.AssistanceRequest{RequestID: uuid.New(), UserID: uuid.New(), LLMSummary: "Summary.", Status: "pending"}
+	mockRepo.EXPECT().GetRequestByID(ctx, req.RequestID).Return(req, nil).Times(3)
+
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+	for _, caller := range []Caller{{ExpertID: uuid.New()}, {UserID: req.UserID}} {
+		if got, err := s.GetRequest(ctx, req.RequestID, caller); err != nil || got != req {
+			t.Errorf("Caller %+v: expected the request, got %v, %v", caller, got, err)
+		}
+	}
+	if _, err := s.GetRequest(ctx, req.RequestID, Caller{UserID: uuid.New()}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for another user, got %v", err)
+	}
+
+	// Once it's taken, only the assigned expert still can.
+	assigned := uuid.New()
+	taken := &domain.AssistanceRequest{RequestID: uuid.New(), UserID: req.UserID, Status: "resolved",
+		ExpertID: uuid.NullUUID{UUID: assigned, Valid: true}}
+	mockRepo.EXPECT().GetRequestByID(ctx, taken.RequestID).Return(taken, nil).Times(2)
+	if got, err := s.GetRequest(ctx, taken.RequestID, Caller{ExpertID: assigned}); err != nil || got != taken {
+		t.Errorf("Expected the assigned expert to get the request, got %v, %v", got, err)
+	}
+	if _, err := s.GetRequest(ctx, taken.RequestID, Caller{ExpertID: uuid.New()}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for another expert, got %v", err)
+	}
+}
+
+// TestService_ResummarizeRequest_Resolved tests that a resolved request is rejected before calling the LLM.
+func TestService_ResummarizeRequest_Resolved(t *testing.T) {
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	reqID := uuid.New()
+	mockRequest := &domain.AssistanceRequest{RequestID: reqID, Status: "resolved"}
+
+	mockRepo.EXPECT().GetRequestByID(ctx, reqID).Return(mockRequest, nil).Times(1)
+	mockLLM.EXPECT().Summarize(gomock.Any(), gomock.Any()).Times(0)
+	mockRepo.EXPECT().UpdateSummary(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+	_, err := s.ResummarizeRequest(ctx, reqID)
+
+	if !errors.Is(err, ErrRequestNotOpen) {
+		t.Fatalf("Expected ErrRequestNotOpen, got: %v", err)
+	}
+}
+
+// TestService_CreateRequest_Duplicate tests the double tap case: the held token is released and the existing request is returned.
+func TestService_CreateRequest_Duplicate(t *testing.T) {
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	userID := uuid.New()
+	twilioSID := "CH-dup-123"
+	mockUser := &domain.User{UserID: userID, Role: "user"}
+	existing := &domain.AssistanceRequest{RequestID: uuid.New(), UserID: userID, TwilioConversationSID: twilioSID, Status: "pending"}
+
+	holdID := uuid.New()
+	gomock.InOrder(
+		mockUserClient.EXPECT().GetUserProfile(gomock.Any(), userID).Return(mockUser, nil).Times(1),
+		mockRepo.EXPECT().HasOpenRequest(gomock.Any(), userID).Return(false, nil).Times(1),
+		mockBilling.EXPECT().HoldToken(gomock.Any(), userID, 1, gomock.Any()).Return(holdID, 4, nil).Times(1),
+		mockLLM.EXPECT().Summarize(gomock.Any(), twilioSID).Return("Summary.", nil).Times(1),
+		mockRepo.EXPECT().CreateRequest(gomock.Any(), gomock.Any(), gomock.Any()).Return(ErrDuplicateRequest).Times(1),
+
+		// The held token must come back, and never be spent.
+		mockBilling.EXPECT().ReleaseHold(gomock.Any(), userID, holdID).Return(nil).Times(1),
+		mockRepo.EXPECT().GetOpenRequestBySID(gomock.Any(), twilioSID).Return(existing, nil).Times(1),
+	)
+
+	// The bot was already removed for the first request.
+	mockChat.EXPECT().RemoveBot(gomock.Any(), gomock.Any()).Times(0)
+	mockBilling.EXPECT().CommitHold(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+	_, err := s.CreateRequest(ctx, userID, twilioSID, "")
+
+	if !errors.Is(err, ErrDuplicateRequest) {
+		t.Fatalf("Expected ErrDuplicateRequest, got: %v", err)
+	}
+	var dupErr *DuplicateRequestError
+	if !errors.As(err, &dupErr) || dupErr.Existing == nil || dupErr.Existing.RequestID != existing.RequestID {
+		t.Errorf("Expected the existing request %v in the error", existing.RequestID)
+	}
+}
+
+// TestService_ClaimNextRequest_Success tests that a claimed request goes through the same join steps as an accept.
+func TestService_ClaimNextRequest_Success(t *testing.T) {
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, mockNotify, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	reqID := uuid.New()
+	userID := uuid.New()
+	expertID := uuid.New()
+	claimed := &domain.AssistanceRequest{
+		RequestID:             reqID,
+		UserID:                userID,
+		ExpertID:              uuid.NullUUID{UUID: expertID, Valid: true},
+		TwilioConversationSID: "twilio-sid-next",
+		Status:                "active",
+	}
+
+	gomock.InOrder(
+		mockExpert.EXPECT().GetExpertProfile(gomock.Any(), expertID).Return(&domain.Expert{ExpertID: expertID, DisplayName: "Joe", IsActive: true}, nil).Times(1),
+		mockRepo.EXPECT().ClaimNextRequest(ctx, expertID).Return(claimed, nil).Times(1),
+		mockChat.EXPECT().AddExpert(ctx, "twilio-sid-next", expertID).Return(nil).Times(1),
+	)
+	mockNotify.EXPECT().NotifyUser(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+	req, err := s.ClaimNextRequest(ctx, expertID)
+
+	if err != nil {
+		t.Fatalf("ClaimNextRequest() returned unexpected error: %v", err)
+	}
+	if req.RequestID != reqID {
+		t.Errorf("Expected request %s, got %s", reqID, req.RequestID)
+	}
+}
+
+// TestService_ClaimNextRequest_QueueEmpty tests an empty queue skips the chat and notification.
+func TestService_ClaimNextRequest_QueueEmpty(t *testing.T) {
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, mockNotify, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	expertID := uuid.New()
+	mockExpert.EXPECT().GetExpertProfile(gomock.Any(), expertID).Return(&domain.Expert{ExpertID: expertID, IsActive: true}, nil).Times(1)
+	mockRepo.EXPECT().ClaimNextRequest(ctx, expertID).Return(nil, ErrQueueEmpty).Times(1)
+	mockChat.EXPECT().AddExpert(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	mockNotify.EXPECT().NotifyUser(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+	_, err := s.ClaimNextRequest(ctx, expertID)
+
+	if !errors.Is(err, ErrQueueEmpty) {
+		t.Fatalf("Expected ErrQueueEmpty, got: %v", err)
+	}
+}
+
+// resolvedRequest returns a request the user resolved with the given expert, resolvedAgo in the past.
+func resolvedRequest(userID, expertID uuid.UUID, resolvedAgo time.Duration) *domain.AssistanceRequest {
+	return &domain.AssistanceRequest{
+		RequestID:             uuid.New(),
+		UserID:                userID,
+		ExpertID:              uuid.NullUUID{UUID: expertID, Valid: true},
+		Status:                "resolved",
+		TwilioConversationSID: "twilio-sid-reopen",
+		ResolvedAt:            sql.NullTime{Time: time.Now().UTC().Add(-resolvedAgo), Valid: true},
+	}
+}
+
+// TestService_ReopenRequest_ExpertActive tests the request goes back to the same expert, who is re-added to the chat.
+func TestService_ReopenRequest_ExpertActive(t *testing.T) {
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	userID := uuid.New()
+	expertID := uuid.New()
+	req := resolvedRequest(userID, expertID, 5*time.Minute)
+	reopened := *req
+	reopened.Status = "active"
+	reopened.ResolvedAt = sql.NullTime{}
+
+	gomock.InOrder(
+		mockRepo.EXPECT().GetRequestByID(ctx, req.RequestID).Return(req, nil).Times(1),
+		mockExpert.EXPECT().GetExpertProfile(gomock.Any(), expertID).Return(&domain.Expert{ExpertID: expertID, IsActive: true}, nil).Times(1),
+		mockRepo.EXPECT().ReopenRequest(gomock.Any(), req.RequestID, gomock.Any(), true).Return(&reopened, nil).Times(1),
+		mockChat.EXPECT().AddExpert(ctx, "twilio-sid-reopen", expertID).Return(nil).Times(1),
+	)
+
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+	got, err := s.ReopenRequest(ctx, req.RequestID, userID)
+
+	if err != nil {
+		t.Fatalf("ReopenRequest() returned unexpected error: %v", err)
+	}
+	if got.Status != "active" {
+		t.Errorf("Expected status active, got %s", got.Status)
+	}
+}
+
+// TestService_ReopenRequest_ExpertInactive tests the request goes back to the queue when the expert was deactivated.
+func TestService_ReopenRequest_ExpertInactive(t *testing.T) {
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	userID := uuid.New()
+	expertID := uuid.New()
+	req := resolvedRequest(userID, expertID, 5*time.Minute)
+	pending := &domain.AssistanceRequest{RequestID: req.RequestID, UserID: userID, Status: "pending"}
+
+	mockRepo.EXPECT().GetRequestByID(ctx, req.RequestID).Return(req, nil).Times(1)
+	mockExpert.EXPECT().GetExpertProfile(gomock.Any(), expertID).Return(&domain.Expert{ExpertID: expertID, IsActive: false}, nil).Times(1)
+	mockRepo.EXPECT().ReopenRequest(gomock.Any(), req.RequestID, gomock.Any(), false).Return(pending, nil).Times(1)
+	mockChat.EXPECT().AddExpert(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+	got, err := s.ReopenRequest(ctx, req.RequestID, userID)
+
+	if err != nil {
+		t.Fatalf("ReopenRequest() returned unexpected error: %v", err)
+	}
+	if got.Status != "pending" {
+		t.Errorf("Expected status pending, got %s", got.Status)
+	}
+}
+
+// TestService_ReopenRequest_Rejected tests the checks that stop a reopen before anything is changed.
+func TestService_ReopenRequest_Rejected(t *testing.T) {
+	userID := uuid.New()
+	expertID := uuid.New()
+	active := resolvedRequest(userID, expertID, 0)
+	active.Status = "active"
+	active.ResolvedAt = sql.NullTime{}
+
+	tests := []struct {
+		name    string
+		req     *domain.AssistanceRequest
+		userID  uuid.UUID
+		wantErr error
+	}{
+		{"window expired", resolvedRequest(userID, expertID, 2*time.Hour), userID, ErrReopenWindowExpired},
+		{"not resolved", active, userID, ErrRequestNotResolved},
+		{"someone else's request", resolvedRequest(uuid.New(), expertID, time.Minute), userID, ErrNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+			defer ctrl.Finish()
+
+			mockRepo.EXPECT().GetRequestByID(ctx, tc.req.RequestID).Return(tc.req, nil).Times(1)
+			mockRepo.EXPECT().ReopenRequest(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+			s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+			_, err := s.ReopenRequest(ctx, tc.req.RequestID, tc.userID)
+
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("Expected %v, got: %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+// activeRequest returns a request the given expert is working on.
+func activeRequest(expertID uuid.UUID) *domain.AssistanceRequest {
+	return &domain.AssistanceRequest{
+		RequestID:             uuid.New(),
+		UserID:                uuid.New(),
+		ExpertID:              uuid.NullUUID{UUID: expertID, Valid: true},
+		Status:                "active",
+		TwilioConversationSID: "twilio-sid-transfer",
+	}
+}
+
+// TestService_TransferRequest_ByAssignee tests the new expert joins before the old one is removed.
+func TestService_TransferRequest_ByAssignee(t *testing.T) {
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	oldExpertID := uuid.New()
+	newExpertID := uuid.New()
+	req := activeRequest(oldExpertID)
+	transferred := *req
+	transferred.ExpertID = uuid.NullUUID{UUID: newExpertID, Valid: true}
+
+	gomock.InOrder(
+		mockRepo.EXPECT().GetRequestByID(ctx, req.RequestID).Return(req, nil).Times(1),
+		mockExpert.EXPECT().GetExpertProfile(gomock.Any(), newExpertID).Return(&domain.Expert{ExpertID: newExpertID, IsActive: true}, nil).Times(1),
+		mockRepo.EXPECT().TransferRequest(gomock.Any(), req.RequestID, oldExpertID, newExpertID, oldExpertID, "expert").Return(&transferred, nil).Times(1),
+		mockChat.EXPECT().AddExpert(ctx, "twilio-sid-transfer", newExpertID).Return(nil).Times(1),
+		mockChat.EXPECT().RemoveExpert(ctx, "twilio-sid-transfer", oldExpertID).Return(nil).Times(1),
+	)
+
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+	got, err := s.TransferRequest(ctx, req.RequestID, newExpertID, Caller{ExpertID: oldExpertID})
+
+	if err != nil {
+		t.Fatalf("TransferRequest() returned unexpected error: %v", err)
+	}
+	if got.ExpertID.UUID != newExpertID {
+		t.Errorf("Expected expert %s, got %s", newExpertID, got.ExpertID.UUID)
+	}
+}
+
+// TestService_TransferRequest_ByAdmin tests a superadmin can transfer a request that isn't theirs.
+func TestService_TransferRequest_ByAdmin(t *testing.T) {
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	adminID := uuid.New()
+	oldExpertID := uuid.New()
+	newExpertID := uuid.New()
+	req := activeRequest(oldExpertID)
+
+	mockRepo.EXPECT().GetRequestByID(ctx, req.RequestID).Return(req, nil).Times(1)
+	mockUserClient.EXPECT().GetUserProfile(gomock.Any(), adminID).Return(&domain.User{UserID: adminID, Role: "superadmin"}, nil).Times(1)
+	mockExpert.EXPECT().GetExpertProfile(gomock.Any(), newExpertID).Return(&domain.Expert{ExpertID: newExpertID, IsActive: true}, nil).Times(1)
+	mockRepo.EXPECT().TransferRequest(gomock.Any(), req.RequestID, oldExpertID, newExpertID, adminID, "admin").Return(req, nil).Times(1)
+	mockChat.EXPECT().AddExpert(ctx, gomock.Any(), newExpertID).Return(nil).Times(1)
+	// The old expert lingering in the chat doesn't fail the transfer.
+	mockChat.EXPECT().RemoveExpert(ctx, gomock.Any(), oldExpertID).Return(errors.New("twilio down")).Times(1)
+
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+	if _, err := s.TransferRequest(ctx, req.RequestID, newExpertID, Caller{UserID: adminID}); err != nil {
+		t.Fatalf("TransferRequest() returned unexpected error: %v", err)
+	}
+}
+
+// TestService_TransferRequest_Rejected tests the checks that stop a transfer before anything is changed.
+func TestService_TransferRequest_Rejected(t *testing.T) {
+	oldExpertID := uuid.New()
+	newExpertID := uuid.New()
+	userID := uuid.New()
+
+	tests := []struct {
+		name    string
+		caller  Caller
+		target  uuid.UUID
+		setup   func(*MockUserClient, *MockExpertClient)
+		wantErr error
+	}{
+		{"another expert", Caller{ExpertID: uuid.New()}, newExpertID, nil, ErrForbidden},
+		{"non-admin user", Caller{UserID: userID}, newExpertID, func(uc *MockUserClient, _ *MockExpertClient) {
+			uc.EXPECT().GetUserProfile(gomock.Any(), userID).Return(&domain.User{UserID: userID, Role: "user"}, nil)
+		}, ErrForbidden},
+		{"same expert", Caller{ExpertID: oldExpertID}, oldExpertID, nil, ErrInvalidTransferTarget},
+		{"inactive target", Caller{ExpertID: oldExpertID}, newExpertID, func(_ *MockUserClient, ec *MockExpertClient) {
+			ec.EXPECT().GetExpertProfile(gomock.Any(), newExpertID).Return(&domain.Expert{ExpertID: newExpertID, IsActive: false}, nil)
+		}, ErrExpertNotActive},
+		{"missing target", Caller{ExpertID: oldExpertID}, newExpertID, func(_ *MockUserClient, ec *MockExpertClient) {
+			ec.EXPECT().GetExpertProfile(gomock.Any(), newExpertID).Return(nil, ErrExpertNotFound)
+		}, ErrExpertNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+			defer ctrl.Finish()
+
+			req := activeRequest(oldExpertID)
+			mockRepo.EXPECT().GetRequestByID(ctx, req.RequestID).Return(req, nil).Times(1)
+			if tc.setup != nil {
+				tc.setup(mockUserClient, mockExpert)
+			}
+			mockRepo.EXPECT().TransferRequest(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+			s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+			_, err := s.TransferRequest(ctx, req.RequestID, tc.target, tc.caller)
+
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("Expected %v, got: %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestService_CreateRequest_AlreadyOpen tests a user with an open request is stopped before any token is debited.
+func TestService_CreateRequest_AlreadyOpen(t *testing.T) {
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	userID := uuid.New()
+	existing := &domain.AssistanceRequest{RequestID: uuid.New(), UserID: userID, TwilioConversationSID: "CH-first", Status: "pending"}
+
+	gomock.InOrder(
+		mockUserClient.EXPECT().GetUserProfile(gomock.Any(), userID).Return(&domain.User{UserID: userID, Role: "user"}, nil).Times(1),
+		mockRepo.EXPECT().HasOpenRequest(gomock.Any(), userID).Return(true, nil).Times(1),
+		mockRepo.EXPECT().GetOpenRequestByUser(gomock.Any(), userID).Return(existing, nil).Times(1),
+	)
+	// Nothing after the check runs, most importantly the hold.
+	mockBilling.EXPECT().HoldToken(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	mockLLM.EXPECT().Summarize(gomock.Any(), gomock.Any()).Times(0)
+	mockRepo.EXPECT().CreateRequest(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+	_, err := s.CreateRequest(ctx, userID, "CH-second", "")
+
+	if !errors.Is(err, ErrRequestAlreadyOpen) {
+		t.Fatalf("Expected ErrRequestAlreadyOpen, got: %v", err)
+	}
+	var openErr *OpenRequestError
+	if !errors.As(err, &openErr) || openErr.Existing == nil || openErr.Existing.RequestID != existing.RequestID {
+		t.Errorf("Expected the existing request %v in the error", existing.RequestID)
+	}
+}
+
+// TestService_RecordFirstResponse checks only the assigned expert's first message is recorded.
+func TestService_RecordFirstResponse(t *testing.T) {
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+
+	expertID := uuid.New()
+	req := activeRequest(expertID)
+	req.AcceptedAt = sql.NullTime{Time: time.Now().UTC().Add(-time.Minute), Valid: true}
+	sentAt := req.AcceptedAt.Time.Add(30 * time.Second)
+
+	// The user writing doesn't count, only the expert does. Both are activity though.
+	mockRepo.EXPECT().GetOpenRequestBySID(ctx, req.TwilioConversationSID).Return(req, nil).Times(2)
+	mockRepo.EXPECT().TouchRequestActivity(ctx, req.RequestID).Return(nil).Times(2)
+	mockRepo.EXPECT().SetFirstResponse(ctx, req.RequestID, sentAt).Return(true, nil).Times(1)
+
+	if recorded, err := s.RecordFirstResponse(ctx, req.TwilioConversationSID, req.UserID, sentAt); err != nil || recorded {
+		t.Errorf("Expected the user's message to be ignored, got recorded=%v err=%v", recorded, err)
+	}
+	if recorded, err := s.RecordFirstResponse(ctx, req.TwilioConversationSID, expertID, sentAt); err != nil || !recorded {
+		t.Errorf("Expected the expert's message to be recorded, got recorded=%v err=%v", recorded, err)
+	}
+}
+
+// TestService_RecordFirstResponse_ClampsToAccepted checks a message stamped before acceptance doesn't give a negative time.
+func TestService_RecordFirstResponse_ClampsToAccepted(t *testing.T) {
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+
+	expertID := uuid.New()
+	req := activeRequest(expertID)
+	req.AcceptedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+
+	mockRepo.EXPECT().GetOpenRequestBySID(ctx, req.TwilioConversationSID).Return(req, nil).Times(1)
+	mockRepo.EXPECT().TouchRequestActivity(ctx, req.RequestID).Return(nil).Times(1)
+	mockRepo.EXPECT().SetFirstResponse(ctx, req.RequestID, req.AcceptedAt.Time).Return(true, nil).Times(1)
+
+	if _, err := s.RecordFirstResponse(ctx, req.TwilioConversationSID, expertID, req.AcceptedAt.Time.Add(-2*time.Second)); err != nil {
+		t.Fatalf("RecordFirstResponse() returned error: %v", err)
+	}
+}
+
+// TestService_RecordFirstResponse_Activity checks any message on an active request touches it, a failed touch
+// doesn't fail the call, and a pending request isn't touched.
+func TestService_RecordFirstResponse_Activity(t *testing.T) {
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+
+	expertID := uuid.New()
+	req := activeRequest(expertID)
+	req.FirstResponseAt = sql.NullTime{Time: time.Now().UTC().Add(-time.Hour), Valid: true}
+	pending := &domain.AssistanceRequest{RequestID: uuid.New(), UserID: req.UserID, Status: "pending", TwilioConversationSID: "CH-pending"}
+
+	mockRepo.EXPECT().GetOpenRequestBySID(ctx, req.TwilioConversationSID).Return(req, nil).Times(1)
+	mockRepo.EXPECT().GetOpenRequestBySID(ctx, pending.TwilioConversationSID).Return(pending, nil).Times(1)
+	mockRepo.EXPECT().TouchRequestActivity(ctx, req.RequestID).Return(errors.New("db down")).Times(1)
+
+	if recorded, err := s.RecordFirstResponse(ctx, req.TwilioConversationSID, uuid.New(), time.Time{}); err != nil || recorded {
+		t.Errorf("Expected a failed touch to be ignored, got recorded=%v err=%v", recorded, err)
+	}
+	if recorded, err := s.RecordFirstResponse(ctx, pending.TwilioConversationSID, req.UserID, time.Time{}); err != nil || recorded {
+		t.Errorf("Expected nothing on a pending request, got recorded=%v err=%v", recorded, err)
+	}
+}
+
+// TestService_CreateRequest_Priority tests that a priority request holds, and then commits, its higher cost.
+func TestService_CreateRequest_Priority(t *testing.T) {
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	userID := uuid.New()
+	holdID := uuid.New()
+	twilioSID := "CH-priority"
+
+	gomock.InOrder(
+		mockUserClient.EXPECT().GetUserProfile(gomock.Any(), userID).Return(&domain.User{UserID: userID, Role: "user"}, nil).Times(1),
+		mockRepo.EXPECT().HasOpenRequest(gomock.Any(), userID).Return(false, nil).Times(1),
+		mockBilling.EXPECT().HoldToken(gomock.Any(), userID, 3, gomock.Any()).Return(holdID, 4, nil).Times(1),
+		mockLLM.EXPECT().Summarize(gomock.Any(), twilioSID).Return("Urgent.", nil).Times(1),
+		mockRepo.EXPECT().CreateRequest(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(1),
+		mockBilling.EXPECT().CommitHold(gomock.Any(), userID, holdID).Return(nil).Times(1),
+	)
+
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+	if _, err := s.CreateRequest(ctx, userID, twilioSID, RequestTypePriority); err != nil {
+		t.Fatalf("CreateRequest() returned unexpected error: %v", err)
+	}
+}
+
+// TestService_CreateRequest_TokenCosts tests configured costs: an unknown type is refused before anything is called,
+// and a free type holds nothing.
+func TestService_CreateRequest_TokenCosts(t *testing.T) {
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	userID := uuid.New()
+	opts := Options{TokenCosts: map[string]int{RequestTypeStandard: 0}}
+	s := NewServiceWithOptions(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, opts)
+
+	// Priority isn't configured here, so it doesn't exist. No expectations are set yet, so any call fails the test.
+	if _, err := s.CreateRequest(ctx, userID, "CH-unknown", RequestTypePriority); !errors.Is(err, ErrUnknownRequestType) {
+		t.Fatalf("Expected ErrUnknownRequestType, got: %v", err)
+	}
+
+	// A standard request is free with this config.
+	mockUserClient.EXPECT().GetUserProfile(gomock.Any(), userID).Return(&domain.User{UserID: userID, Role: "user"}, nil).Times(1)
+	mockRepo.EXPECT().HasOpenRequest(gomock.Any(), userID).Return(false, nil).Times(1)
+	mockLLM.EXPECT().Summarize(gomock.Any(), "CH-free").Return("Free.", nil).Times(1)
+	mockRepo.EXPECT().CreateRequest(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(1)
+	mockBilling.EXPECT().HoldToken(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	mockBilling.EXPECT().CommitHold(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	if _, err := s.CreateRequest(ctx, userID, "CH-free", ""); err != nil {
+		t.Fatalf("CreateRequest() returned unexpected error: %v", err)
+	}
+}
+
+// TestService_CheckCreateRequest walks the reasons a user can't create a request. Nothing is ever held,
+// debited, summarized or saved, so the mocks only expect reads.
+func TestService_CheckCreateRequest(t *testing.T) {
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	userID := uuid.New()
+	user := &domain.User{UserID: userID, Role: "user"}
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+
+	mockBilling.EXPECT().HoldToken(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	mockLLM.EXPECT().Summarize(gomock.Any(), gomock.Any()).Times(0)
+	mockRepo.EXPECT().CreateRequest(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	gomock.InOrder(
+		// Unknown user.
+		mockUserClient.EXPECT().GetUserProfile(gomock.Any(), userID).Return(nil, ErrUserNotFound),
+		// Already has one open.
+		mockUserClient.EXPECT().GetUserProfile(gomock.Any(), userID).Return(user, nil),
+		mockRepo.EXPECT().HasOpenRequest(gomock.Any(), userID).Return(true, nil),
+		mockRepo.EXPECT().GetOpenRequestByUser(gomock.Any(), userID).Return(&domain.AssistanceRequest{UserID: userID, Status: "pending"}, nil),
+		// A priority request costs 3 and they have 2.
+		mockUserClient.EXPECT().GetUserProfile(gomock.Any(), userID).Return(user, nil),
+		mockRepo.EXPECT().HasOpenRequest(gomock.Any(), userID).Return(false, nil),
+		mockBilling.EXPECT().GetBalance(gomock.Any(), userID).Return(2, nil),
+		// A standard one costs 1, which they can afford.
+		mockUserClient.EXPECT().GetUserProfile(gomock.Any(), userID).Return(user, nil),
+		mockRepo.EXPECT().HasOpenRequest(gomock.Any(), userID).Return(false, nil),
+		mockBilling.EXPECT().GetBalance(gomock.Any(), userID).Return(2, nil),
+	)
+
+	tests := []struct {
+		requestType string
+		wantAllowed bool
+		wantReason  string
+		wantCost    int
+	}{
+		{"", false, CreateDeniedUserNotFound, 1},
+		{"", false, CreateDeniedOpenRequest, 1},
+		{RequestTypePriority, false, CreateDeniedInsufficientTokens, 3},
+		{RequestTypeStandard, true, "", 1},
+	}
+	for i, tt := range tests {
+		check, err := s.CheckCreateRequest(ctx, userID, tt.requestType)
+		if err != nil {
+			t.Fatalf("Check %d returned unexpected error: %v", i, err)
+		}
+		if check.Allowed != tt.wantAllowed || check.Reason != tt.wantReason || check.TokenCost != tt.wantCost {
+			t.Errorf("Check %d: expected allowed=%v reason=%q cost=%d, got %+v", i, tt.wantAllowed, tt.wantReason, tt.wantCost, check)
+		}
+	}
+
+	if _, err := s.CheckCreateRequest(ctx, userID, "bogus"); !errors.Is(err, ErrUnknownRequestType) {
+		t.Errorf("Expected ErrUnknownRequestType, got: %v", err)
+	}
+}
+
+// TestService_CheckCreateRequest_SuperAdmin checks a superadmin is let through without looking at their balance.
+func TestService_CheckCreateRequest_SuperAdmin(t *testing.T) {
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	userID := uuid.New()
+	mockUserClient.EXPECT().GetUserProfile(gomock.Any(), userID).Return(&domain.User{UserID: userID, Role: "superadmin"}, nil).Times(1)
+	mockRepo.EXPECT().HasOpenRequest(gomock.Any(), gomock.Any()).Times(0)
+	mockBilling.EXPECT().GetBalance(gomock.Any(), gomock.Any()).Times(0)
+
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+	check, err := s.CheckCreateRequest(ctx, userID, RequestTypePriority)
+	if err != nil {
+		t.Fatalf("CheckCreateRequest() returned unexpected error: %v", err)
+	}
+	if !check.Allowed || check.Balance != nil {
+		t.Errorf("Expected allowed with no balance, got %+v", check)
+	}
+}
+
+// TestService_SearchRequests checks only a superadmin gets to the repository.
+func TestService_SearchRequests(t *testing.T) {
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	adminID := uuid.New()
+	userID := uuid.New()
+	filter := SearchFilter{Status: "pending", Limit: 10}
+
+	mockUserClient.EXPECT().GetUserProfile(gomock.Any(), adminID).Return(&domain.User{UserID: adminID, Role: "superadmin"}, nil)
+	mockUserClient.EXPECT().GetUserProfile(gomock.Any(), userID).Return(&domain.User{UserID: userID, Role: "user"}, nil)
+	mockRepo.EXPECT().SearchRequests(gomock.Any(), filter).Return(&SearchResult{Limit: 10}, nil).Times(1)
+
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+	if _, err := s.SearchRequests(ctx, adminID, filter); err != nil {
+		t.Fatalf("SearchRequests() returned unexpected error: %v", err)
+	}
+	if _, err := s.SearchRequests(ctx, userID, filter); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected ErrForbidden for a regular user, got %v", err)
+	}
+}
+
+// TestService_GetPendingRequests_Balanced checks the balanced view puts the expert's own reservation first, and
+// only reorders requests whose ages are within the jitter of each other.
+func TestService_GetPendingRequests_Balanced(t *testing.T) {
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	expertID := uuid.New()
+	queued := func(age time.Duration) *QueuedRequest {
+		return &QueuedRequest{AssistanceRequest: &domain.AssistanceRequest{RequestID: uuid.New(), Status: "pending", CreatedAt: now.Add(-age)}}
+	}
+	oldest, older, newer, mine := queued(10*time.Minute), queued(60*time.Second), queued(50*time.Second), queued(time.Second)
+	until := now.Add(time.Minute)
+	mine.ReservedUntil = &until
+
+	// Oldest first from the repository, the way the SQL sorts it.
+	mockRepo.EXPECT().GetPendingRequests(ctx, expertID, now).DoAndReturn(func(context.Context, uuid.UUID, time.Time) ([]*QueuedRequest, error) {
+		return []*QueuedRequest{oldest, older, newer, mine}, nil
+	}).Times(2)
+
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert).(*service)
+	s.now = func() time.Time { return now }
+
+	// The plain view is left alone.
+	plain, err := s.GetPendingRequests(ctx, QueueOptions{ExpertID: expertID})
+	if err != nil {
+		t.Fatalf("GetPendingRequests() returned error: %v", err)
+	}
+	if plain.Items[0] != oldest || plain.Items[0].Priority != 0 || plain.Total != 4 {
+		t.Errorf("Expected the whole plain view oldest first with no priority, got %+v", plain)
+	}
+
+	// Full jitter for the newer request and none for the rest is enough to put it ahead of the older one,
+	// but 10 minutes is too far to catch up.
+	jitters := map[*QueuedRequest]float64{newer: 0.99}
+	var order []*QueuedRequest
+	s.jitter = func() float64 {
+		q := []*QueuedRequest{oldest, older, newer, mine}[len(order)]
+		order = append(order, q)
+		return jitters[q]
+	}
+	// The second page of two, so the reordering has to happen before the queue is paged.
+	balanced, err := s.GetPendingRequests(ctx, QueueOptions{ExpertID: expertID, Balanced: true, Limit: 2, Offset: 2})
+	if err != nil {
+		t.Fatalf("GetPendingRequests() returned error: %v", err)
+	}
+	want := []*QueuedRequest{newer, older}
+	if len(balanced.Items) != len(want) || balanced.Total != 4 || balanced.NextOffset != nil {
+		t.Fatalf("Expected the last 2 of 4, got %d of %d", len(balanced.Items), balanced.Total)
+	}
+	for i := range want {
+		if balanced.Items[i] != want[i] {
+			t.Fatalf("Expected position %d to be %s, got %s", i, want[i].RequestID, balanced.Items[i].RequestID)
+		}
+	}
+	if oldest.Priority != 600 {
+		t.Errorf("Expected the oldest request's priority to be its age, 600, got %v", oldest.Priority)
+	}
+}
+
+// TestService_ReserveRequest checks the reservation length is defaulted and capped, and counted from now.
+func TestService_ReserveRequest(t *testing.T) {
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	reqID := uuid.New()
+	expertID := uuid.New()
+
+	mockExpert.EXPECT().GetExpertProfile(gomock.Any(), expertID).Return(&domain.Expert{ExpertID: expertID, IsActive: true}, nil).Times(3)
+	gomock.InOrder(
+		mockRepo.EXPECT().ReserveRequest(gomock.Any(), reqID, expertID, now, now.Add(DefaultReservationTTL)).Return(nil),
+		mockRepo.EXPECT().ReserveRequest(gomock.Any(), reqID, expertID, now, now.Add(90*time.Second)).Return(nil),
+		mockRepo.EXPECT().ReserveRequest(gomock.Any(), reqID, expertID, now, now.Add(MaxReservationTTL)).Return(nil),
+	)
+
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert).(*service)
+	s.now = func() time.Time { return now }
+
+	for _, tc := range []struct {
+		ttl  time.Duration
+		want time.Time
+	}{
+		{0, now.Add(DefaultReservationTTL)},
+		{90 * time.Second, now.Add(90 * time.Second)},
+		{time.Hour, now.Add(MaxReservationTTL)},
+	} {
+		until, err := s.ReserveRequest(ctx, reqID, expertID, tc.ttl)
+		if err != nil {
+			t.Fatalf("ReserveRequest(%v) returned error: %v", tc.ttl, err)
+		}
+		if !until.Equal(tc.want) {
+			t.Errorf("ReserveRequest(%v) = %v, want %v", tc.ttl, until, tc.want)
+		}
+	}
+}
+
+// TestService_ReserveRequest_Conflicts checks a failed reserve is told apart like a failed accept.
+func TestService_ReserveRequest_Conflicts(t *testing.T) {
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	expertID := uuid.New()
+	missingID, takenID, heldID := uuid.New(), uuid.New(), uuid.New()
+
+	mockExpert.EXPECT().GetExpertProfile(gomock.Any(), expertID).Return(&domain.Expert{ExpertID: expertID, IsActive: true}, nil).Times(3)
+	mockRepo.EXPECT().ReserveRequest(gomock.Any(), gomock.Any(), expertID, gomock.Any(), gomock.Any()).Return(ErrRequestReserved).Times(3)
+	mockRepo.EXPECT().GetRequestByID(gomock.Any(), missingID).Return(nil, ErrNotFound)
+	mockRepo.EXPECT().GetRequestByID(gomock.Any(), takenID).Return(&domain.AssistanceRequest{RequestID: takenID, Status: "active"}, nil)
+	mockRepo.EXPECT().GetRequestByID(gomock.Any(), heldID).Return(&domain.AssistanceRequest{RequestID: heldID, Status: "pending"}, nil)
+
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+	if _, err := s.ReserveRequest(ctx, missingID, expertID, 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	var acceptedErr *AlreadyAcceptedError
+	if _, err := s.ReserveRequest(ctx, takenID, expertID, 0); !errors.As(err, &acceptedErr) {
+		t.Errorf("Expected an AlreadyAcceptedError, got %v", err)
+	}
+	if _, err := s.ReserveRequest(ctx, heldID, expertID, 0); !errors.Is(err, ErrRequestReserved) {
+		t.Errorf("Expected ErrRequestReserved, got %v", err)
+	}
+}
+
+// TestService_AcceptRequest_Reserved checks an accept blocked by someone else's reservation isn't reported as taken.
+func TestService_AcceptRequest_Reserved(t *testing.T) {
+	ctx, mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert, _, ctrl := setupMocks(t)
+	defer ctrl.Finish()
+
+	reqID := uuid.New()
+	expertID := uuid.New()
+
+	mockExpert.EXPECT().GetExpertProfile(gomock.Any(), expertID).Return(&domain.Expert{ExpertID: expertID, IsActive: true}, nil).Times(1)
+	mockRepo.EXPECT().AcceptRequest(ctx, reqID, expertID).Return(nil, ErrRequestAlreadyAccepted).Times(1)
+	mockRepo.EXPECT().GetRequestByID(gomock.Any(), reqID).Return(&domain.AssistanceRequest{RequestID: reqID, Status: "pending"}, nil).Times(1)
+	mockChat.EXPECT().AddExpert(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	s := NewService(mockRepo, mockBilling, mockLLM, mockChat, mockUserClient, mockExpert)
+	if _, err := s.AcceptRequest(ctx, reqID, expertID); !errors.Is(err, ErrRequestReserved) {
+		t.Errorf("Expected ErrRequestReserved, got %v", err)
+	}
+}
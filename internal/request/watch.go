@@ -0,0 +1,107 @@
+package request
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"project-sage/internal/domain"
+	"project-sage/internal/httputil"
+)
+
+// watchBuffer is how many events a subscriber can fall behind before we start dropping events for it.
+const watchBuffer = 16
+
+// watchKeepAlive is how often an idle stream gets a comment line, so proxies don't close it.
+const watchKeepAlive = 25 * time.Second
+
+// pendingBroker is an in-process pub/sub for newly pending requests.
+// It only reaches experts connected to this instance, so with several replicas an expert still needs /request/pending
+// to see the whole queue. Good enough until we have a real message bus.
+type pendingBroker struct {
+	mu   sync.Mutex
+	subs map[chan *domain.AssistanceRequest]struct{}
+}
+
+func newPendingBroker() *pendingBroker {
+	return &pendingBroker{subs: make(map[chan *domain.AssistanceRequest]struct{})}
+}
+
+// subscribe registers a subscriber until ctx is done, then closes its channel.
+func (b *pendingBroker) subscribe(ctx context.Context) <-chan *domain.AssistanceRequest {
+	ch := make(chan *domain.AssistanceRequest, watchBuffer)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		delete(b.subs, ch)
+		close(ch)
+		b.mu.Unlock()
+	}()
+	return ch
+}
+
+// publish sends req to every subscriber. A subscriber with a full buffer misses this one rather than blocking the create.
+func (b *pendingBroker) publish(req *domain.AssistanceRequest) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- req:
+		default:
+			fmt.Printf("WARNING: Dropped pending request %s for a slow watcher\n", req.RequestID)
+		}
+	}
+}
+
+// handleWatchPendingRequests streams newly pending requests to the expert as server-sent events,
+// until the client goes away. It's the push version of /request/pending, which is still how to get the current queue.
+func (h *Handler) handleWatchPendingRequests(w http.ResponseWriter, r *http.Request) {
+	// _ , err := auth.GetExpertID(r.Context()) ...
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httputil.WriteError(w, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	events := h.service.WatchPendingRequests(r.Context())
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	// A comment first, so the client knows it's subscribed before anything is created.
+	fmt.Fprint(w, ": watching\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(watchKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case req, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(req)
+			if err != nil {
+				fmt.Printf("WARNING: Could not encode pending request %s: %v\n", req.RequestID, err)
+				continue
+			}
+			fmt.Fprintf(w, "event: request_pending\nid: %s\ndata: %s\n\n", req.RequestID, data)
+			flusher.Flush()
+		}
+	}
+}
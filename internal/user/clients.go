@@ -0,0 +1,124 @@
+package user
+
+//go:generate mockgen -destination=./clients_mock_test.go -package=user -source=clients.go BillingClient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"project-sage/internal/auth"
+	"project-sage/internal/httpclient"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// BillingClient is the contract for talking to the BillingService. The balance lives on the user's row, but
+// only the BillingService changes it.
+type BillingClient interface {
+	// ForfeitBalance takes whatever tokens the user has left, for a deleted account. It's safe to call again.
+	ForfeitBalance(ctx context.Context, userID uuid.UUID) error
+	// GrantTokens credits amount granted tokens to the user. A reference the user was already credited under
+	// credits nothing, so it's safe to call again.
+	GrantTokens(ctx context.Context, userID uuid.UUID, amount int, referenceID string) error
+}
+
+// DefaultClientTimeout is the whole-call timeout of the billing client when the constructor is given 0.
+const DefaultClientTimeout = 5 * time.Second
+
+// httpBillingClient is the implementation for the BillingClient.
+type httpBillingClient struct {
+	httpClient    *http.Client
+	baseURL       string
+	internalToken string // Sent as X-Internal-Token. The BillingService turns away anything without it
+}
+
+// NewHTTPBillingClient is the constructor. internalToken is the shared secret the BillingService expects.
+func NewHTTPBillingClient(baseURL, internalToken string, timeout time.Duration) BillingClient {
+	if timeout <= 0 {
+		timeout = DefaultClientTimeout
+	}
+	return &httpBillingClient{
+		httpClient:    httpclient.New(timeout),
+		baseURL:       baseURL,
+		internalToken: internalToken,
+	}
+}
+
+// forfeitReference is the reference_id on the forfeit's ledger entry, so support can see why the tokens went.
+const forfeitReference = "account-deleted"
+
+// DTO for the BillingService's /token/forfeit endpoint
+type forfeitRequest struct {
+	UserID      string `json:"user_id"`
+	ReferenceID string `json:"reference_id,omitempty"`
+}
+
+// ForfeitBalance posts to the BillingService's /token/forfeit. How much was taken doesn't matter here.
+func (c *httpBillingClient) ForfeitBalance(ctx context.Context, userID uuid.UUID) error {
+	reqBody, err := json.Marshal(forfeitRequest{UserID: userID.String(), ReferenceID: forfeitReference})
+	if err != nil {
+		return fmt.Errorf("could not marshal forfeit request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/token/forfeit", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return fmt.Errorf("could not create forfeit http request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(auth.InternalTokenHeader, c.internalToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("forfeit request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// Both services read the same users table, so billing not finding the user means nobody will.
+		if resp.StatusCode == http.StatusNotFound {
+			return ErrNotFound
+		}
+		return fmt.Errorf("billing service (forfeit) returned non-200 status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// DTO for the BillingService's /token/add endpoint.
+type grantRequest struct {
+	UserID      string `json:"user_id"`
+	Amount      int    `json:"amount"`
+	ReferenceID string `json:"reference_id"`
+	Source      string `json:"source"`
+}
+
+// GrantTokens posts to the BillingService's /token/add as a grant, so the tokens go in the granted bucket.
+func (c *httpBillingClient) GrantTokens(ctx context.Context, userID uuid.UUID, amount int, referenceID string) error {
+	reqBody, err := json.Marshal(grantRequest{UserID: userID.String(), Amount: amount, ReferenceID: referenceID, Source: "grant"})
+	if err != nil {
+		return fmt.Errorf("could not marshal grant request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/token/add", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return fmt.Errorf("could not create grant http request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(auth.InternalTokenHeader, c.internalToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("grant request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusNotFound {
+			return ErrNotFound
+		}
+		return fmt.Errorf("billing service (grant) returned non-200 status: %d", resp.StatusCode)
+	}
+	return nil
+}
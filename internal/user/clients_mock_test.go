@@ -0,0 +1,70 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: clients.go
+//
+// Generated by this command:
+//
+//	mockgen -destination=./clients_mock_test.go -package=user -source=clients.go BillingClient
+//
+
+// Package user is a generated GoMock package.
+package user
+
+import (
+	context "context"
+	reflect "reflect"
+
+	uuid "github.com/google/uuid"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockBillingClient is a mock of BillingClient interface.
+type MockBillingClient struct {
+	ctrl     *gomock.Controller
+	recorder *MockBillingClientMockRecorder
+	isgomock struct{}
+}
+
+// MockBillingClientMockRecorder is the mock recorder for MockBillingClient.
+type MockBillingClientMockRecorder struct {
+	mock *MockBillingClient
+}
+
+// NewMockBillingClient creates a new mock instance.
+func NewMockBillingClient(ctrl *gomock.Controller) *MockBillingClient {
+	mock := &MockBillingClient{ctrl: ctrl}
+	mock.recorder = &MockBillingClientMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockBillingClient) EXPECT() *MockBillingClientMockRecorder {
+	return m.recorder
+}
+
+// ForfeitBalance mocks base method.
+func (m *MockBillingClient) ForfeitBalance(ctx context.Context, userID uuid.UUID) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ForfeitBalance", ctx, userID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ForfeitBalance indicates an expected call of ForfeitBalance.
+func (mr *MockBillingClientMockRecorder) ForfeitBalance(ctx, userID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ForfeitBalance", reflect.TypeOf((*MockBillingClient)(nil).ForfeitBalance), ctx, userID)
+}
+
+// GrantTokens mocks base method.
+func (m *MockBillingClient) GrantTokens(ctx context.Context, userID uuid.UUID, amount int, referenceID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GrantTokens", ctx, userID, amount, referenceID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// GrantTokens indicates an expected call of GrantTokens.
+func (mr *MockBillingClientMockRecorder) GrantTokens(ctx, userID, amount, referenceID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GrantTokens", reflect.TypeOf((*MockBillingClient)(nil).GrantTokens), ctx, userID, amount, referenceID)
+}
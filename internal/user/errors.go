@@ -0,0 +1,21 @@
+package user
+
+import "errors"
+
+// Sentinel errors returned by the repository. The handler maps them to status codes with errors.Is.
+var (
+	// ErrNotFound is returned when no user matches the lookup (404).
+	ErrNotFound = errors.New("user not found")
+	// ErrVersionConflict means the profile changed since the caller read it (409).
+	ErrVersionConflict = errors.New("user profile was modified by someone else")
+	// ErrUserAlreadyExists means a user with that Firebase id is already registered (409).
+	ErrUserAlreadyExists = errors.New("user already registered")
+	// ErrLastSuperadmin means the change would leave nobody with the superadmin role (409).
+	ErrLastSuperadmin = errors.New("cannot demote the last superadmin")
+	// ErrUnknownTier means the membership tier isn't one the service knows (400).
+	ErrUnknownTier = errors.New("unknown membership tier")
+	// ErrExpertNotFound is returned when no expert matches the lookup (404).
+	ErrExpertNotFound = errors.New("expert not found")
+	// ErrExpertAlreadyExists means an expert with that Firebase id is already registered (409).
+	ErrExpertAlreadyExists = errors.New("expert already registered")
+)
@@ -0,0 +1,162 @@
+package user
+
+//go:generate mockgen -destination=./expert_repository_mock_test.go -package=user -source=expert_repository.go ExpertRepository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"project-sage/internal/database"
+	"project-sage/internal/domain"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ExpertRepository is the interface for the experts table. Experts sign in with Firebase like users,
+// but they're a separate table with no tokens or tier.
+type ExpertRepository interface {
+	// CreateExpert inserts a new expert record. Returns ErrExpertAlreadyExists if the Firebase id is already registered.
+	CreateExpert(ctx context.Context, expert *domain.Expert) error
+	// GetExpertByFirebaseID finds an expert by their unique auth ID.
+	GetExpertByFirebaseID(ctx context.Context, firebaseID string) (*domain.Expert, error)
+	// GetExpertByID finds an expert by their primary key (UUID).
+	GetExpertByID(ctx context.Context, expertID uuid.UUID) (*domain.Expert, error)
+	// ListExperts returns one page of the experts matching filter, ordered by display name, and how many match in all.
+	ListExperts(ctx context.Context, filter ExpertFilter) (experts []*domain.Expert, total int, err error)
+}
+
+// ExpertFilter narrows and pages the expert roster.
+type ExpertFilter struct {
+	Active *bool // Only experts whose is_active is this. Nil means all of them
+	Limit  int
+	Offset int
+}
+
+// postgresExpertRepository is the Postgres implementation of ExpertRepository.
+type postgresExpertRepository struct {
+	db           *sql.DB
+	queryTimeout time.Duration // 0 means no limit
+}
+
+// NewPostgresExpertRepository is the constructor for the expert repository. Each call gets database.DefaultQueryTimeout.
+func NewPostgresExpertRepository(db *sql.DB) ExpertRepository {
+	return NewPostgresExpertRepositoryWithOptions(db, RepositoryOptions{QueryTimeout: database.DefaultQueryTimeout})
+}
+
+// NewPostgresExpertRepositoryWithOptions is the same as NewPostgresExpertRepository, with the query timeout from opts.
+func NewPostgresExpertRepositoryWithOptions(db *sql.DB, opts RepositoryOptions) ExpertRepository {
+	return &postgresExpertRepository{
+		db:           db,
+		queryTimeout: max(opts.QueryTimeout, 0),
+	}
+}
+
+// CreateExpert inserts a new row into the experts table.
+func (pr *postgresExpertRepository) CreateExpert(ctx context.Context, expert *domain.Expert) error {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	expert.ExpertID = uuid.New()
+
+	query := `
+		INSERT INTO experts (expert_id, firebase_auth_id, display_name, is_active, role)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	_, err := pr.db.ExecContext(ctx, query,
+		expert.ExpertID,
+		expert.FirebaseAuthID,
+		expert.DisplayName,
+		expert.IsActive,
+		expert.Role,
+	)
+	if err != nil {
+		if database.IsUniqueViolation(err) {
+			return ErrExpertAlreadyExists
+		}
+		return fmt.Errorf("could not insert expert: %w", err)
+	}
+	return nil
+}
+
+// GetExpertByFirebaseID retrieves a single expert based on their Firebase ID.
+func (pr *postgresExpertRepository) GetExpertByFirebaseID(ctx context.Context, firebaseID string) (*domain.Expert, error) {
+	return pr.getExpert(ctx, "firebase_auth_id = $1", firebaseID)
+}
+
+// GetExpertByID retrieves a single expert based on their internal UUID.
+func (pr *postgresExpertRepository) GetExpertByID(ctx context.Context, expertID uuid.UUID) (*domain.Expert, error) {
+	return pr.getExpert(ctx, "expert_id = $1", expertID)
+}
+
+// getExpert reads the one expert matching where, whose only parameter is arg.
+func (pr *postgresExpertRepository) getExpert(ctx context.Context, where string, arg any) (*domain.Expert, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	expert := &domain.Expert{}
+	query := `
+		SELECT expert_id, firebase_auth_id, display_name, is_active, role
+		FROM experts
+		WHERE ` + where
+	err := pr.db.QueryRowContext(ctx, query, arg).Scan(
+		&expert.ExpertID,
+		&expert.FirebaseAuthID,
+		&expert.DisplayName,
+		&expert.IsActive,
+		&expert.Role,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrExpertNotFound
+		}
+		return nil, fmt.Errorf("could not get expert: %w", err)
+	}
+	return expert, nil
+}
+
+// ListExperts counts the matching experts, then reads the page. expert_id breaks ties between equal names,
+// so an expert can't show up on two pages.
+func (pr *postgresExpertRepository) ListExperts(ctx context.Context, filter ExpertFilter) ([]*domain.Expert, int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	var conditions []string
+	var args []any
+	if filter.Active != nil {
+		args = append(args, *filter.Active)
+		conditions = append(conditions, fmt.Sprintf("is_active = $%d", len(args)))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := pr.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM experts"+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("could not count experts: %w", err)
+	}
+
+	args = append(args, filter.Limit, filter.Offset)
+	query := `SELECT expert_id, firebase_auth_id, display_name, is_active, role FROM experts` + where +
+		fmt.Sprintf(" ORDER BY display_name, expert_id LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+	rows, err := pr.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not list experts: %w", err)
+	}
+	defer rows.Close()
+
+	experts := []*domain.Expert{}
+	for rows.Next() {
+		expert := &domain.Expert{}
+		if err := rows.Scan(&expert.ExpertID, &expert.FirebaseAuthID, &expert.DisplayName, &expert.IsActive, &expert.Role); err != nil {
+			return nil, 0, fmt.Errorf("could not scan expert: %w", err)
+		}
+		experts = append(experts, expert)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("could not list experts: %w", err)
+	}
+	return experts, total, nil
+}
@@ -0,0 +1,103 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: expert_repository.go
+//
+// Generated by this command:
+//
+//	mockgen -destination=./expert_repository_mock_test.go -package=user -source=expert_repository.go ExpertRepository
+//
+
+// Package user is a generated GoMock package.
+package user
+
+import (
+	context "context"
+	domain "project-sage/internal/domain"
+	reflect "reflect"
+
+	uuid "github.com/google/uuid"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockExpertRepository is a mock of ExpertRepository interface.
+type MockExpertRepository struct {
+	ctrl     *gomock.Controller
+	recorder *MockExpertRepositoryMockRecorder
+	isgomock struct{}
+}
+
+// MockExpertRepositoryMockRecorder is the mock recorder for MockExpertRepository.
+type MockExpertRepositoryMockRecorder struct {
+	mock *MockExpertRepository
+}
+
+// NewMockExpertRepository creates a new mock instance.
+func NewMockExpertRepository(ctrl *gomock.Controller) *MockExpertRepository {
+	mock := &MockExpertRepository{ctrl: ctrl}
+	mock.recorder = &MockExpertRepositoryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockExpertRepository) EXPECT() *MockExpertRepositoryMockRecorder {
+	return m.recorder
+}
+
+// CreateExpert mocks base method.
+func (m *MockExpertRepository) CreateExpert(ctx context.Context, expert *domain.Expert) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateExpert", ctx, expert)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateExpert indicates an expected call of CreateExpert.
+func (mr *MockExpertRepositoryMockRecorder) CreateExpert(ctx, expert any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateExpert", reflect.TypeOf((*MockExpertRepository)(nil).CreateExpert), ctx, expert)
+}
+
+// GetExpertByFirebaseID mocks base method.
+func (m *MockExpertRepository) GetExpertByFirebaseID(ctx context.Context, firebaseID string) (*domain.Expert, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetExpertByFirebaseID", ctx, firebaseID)
+	ret0, _ := ret[0].(*domain.Expert)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetExpertByFirebaseID indicates an expected call of GetExpertByFirebaseID.
+func (mr *MockExpertRepositoryMockRecorder) GetExpertByFirebaseID(ctx, firebaseID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetExpertByFirebaseID", reflect.TypeOf((*MockExpertRepository)(nil).GetExpertByFirebaseID), ctx, firebaseID)
+}
+
+// GetExpertByID mocks base method.
+func (m *MockExpertRepository) GetExpertByID(ctx context.Context, expertID uuid.UUID) (*domain.Expert, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetExpertByID", ctx, expertID)
+	ret0, _ := ret[0].(*domain.Expert)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetExpertByID indicates an expected call of GetExpertByID.
+func (mr *MockExpertRepositoryMockRecorder) GetExpertByID(ctx, expertID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetExpertByID", reflect.TypeOf((*MockExpertRepository)(nil).GetExpertByID), ctx, expertID)
+}
+
+// ListExperts mocks base method.
+func (m *MockExpertRepository) ListExperts(ctx context.Context, filter ExpertFilter) ([]*domain.Expert, int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListExperts", ctx, filter)
+	ret0, _ := ret[0].([]*domain.Expert)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// ListExperts indicates an expected call of ListExperts.
+func (mr *MockExpertRepositoryMockRecorder) ListExperts(ctx, filter any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListExperts", reflect.TypeOf((*MockExpertRepository)(nil).ListExperts), ctx, filter)
+}
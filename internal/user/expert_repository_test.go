@@ -0,0 +1,171 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"project-sage/internal/domain"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// These run against the same database as repository_test.go, whose TestMain skips them without TEST_DB_URL.
+
+// cleanExpertTable deletes the experts these tests create.
+func cleanExpertTable(t *testing.T) {
+	if _, err := testDB.Exec("DELETE FROM experts WHERE firebase_auth_id LIKE 'fb-test-%'"); err != nil {
+		t.Fatalf("Could not clean experts table: %v", err)
+	}
+}
+
+// TestCreateAndGetExpert verifies an expert can be inserted and read back by either id.
+func TestCreateAndGetExpert(t *testing.T) {
+	cleanExpertTable(t)
+	defer cleanExpertTable(t)
+	repo := NewPostgresExpertRepository(testDB)
+	ctx := context.Background()
+
+	expert := &domain.Expert{FirebaseAuthID: "fb-test-expert", DisplayName: "Joe from Support", Role: "expert"}
+	if err := repo.CreateExpert(ctx, expert); err != nil {
+		t.Fatalf("CreateExpert() returned an unexpected error: %v", err)
+	}
+	if expert.ExpertID == (uuid.UUID{}) {
+		t.Fatalf("CreateExpert() did not assign an ExpertID")
+	}
+
+	byFirebase, err := repo.GetExpertByFirebaseID(ctx, "fb-test-expert")
+	if err != nil {
+		t.Fatalf("GetExpertByFirebaseID() returned error: %v", err)
+	}
+	byID, err := repo.GetExpertByID(ctx, expert.ExpertID)
+	if err != nil {
+		t.Fatalf("GetExpertByID() returned error: %v", err)
+	}
+	for _, got := range []*domain.Expert{byFirebase, byID} {
+		if *got != *expert {
+			t.Errorf("Expected %+v, got %+v", expert, got)
+		}
+	}
+}
+
+// TestCreateExpert_Duplicate verifies a second expert with the same FirebaseID is ErrExpertAlreadyExists.
+func TestCreateExpert_Duplicate(t *testing.T) {
+	cleanExpertTable(t)
+	defer cleanExpertTable(t)
+	repo := NewPostgresExpertRepository(testDB)
+	ctx := context.Background()
+
+	if err := repo.CreateExpert(ctx, &domain.Expert{FirebaseAuthID: "fb-test-expert-dup", DisplayName: "First", Role: "expert"}); err != nil {
+		t.Fatalf("CreateExpert() failed: %v", err)
+	}
+	err := repo.CreateExpert(ctx, &domain.Expert{FirebaseAuthID: "fb-test-expert-dup", DisplayName: "Second", Role: "expert"})
+	if !errors.Is(err, ErrExpertAlreadyExists) {
+		t.Errorf("Expected ErrExpertAlreadyExists, got %v", err)
+	}
+}
+
+// TestGetExpert_NotFound verifies both lookups return ErrExpertNotFound for an unknown expert.
+func TestGetExpert_NotFound(t *testing.T) {
+	repo := NewPostgresExpertRepository(testDB)
+	ctx := context.Background()
+
+	if _, err := repo.GetExpertByFirebaseID(ctx, "fb-test-no-such-expert"); !errors.Is(err, ErrExpertNotFound) {
+		t.Errorf("Expected ErrExpertNotFound by Firebase id, got %v", err)
+	}
+	if _, err := repo.GetExpertByID(ctx, uuid.New()); !errors.Is(err, ErrExpertNotFound) {
+		t.Errorf("Expected ErrExpertNotFound by id, got %v", err)
+	}
+}
+
+// TestListExperts seeds active and inactive experts and checks the filter, the name order, paging and the total.
+func TestListExperts(t *testing.T) {
+	cleanExpertTable(t)
+	defer cleanExpertTable(t)
+	repo := NewPostgresExpertRepository(testDB)
+	ctx := context.Background()
+
+	// Other tests' experts may be in the table too, so only ours are counted, by their "Test " prefix.
+	seed := []struct {
+		name   string
+		active bool
+	}{
+		{"Test Carol", true},
+		{"Test Alice", true},
+		{"Test Dave", false},
+		{"Test Bob", true},
+		{"Test Erin", false},
+	}
+	for i, e := range seed {
+		expert := &domain.Expert{
+			FirebaseAuthID: fmt.Sprintf("fb-test-roster-%d", i),
+			DisplayName:    e.name,
+			IsActive:       e.active,
+			Role:           "expert",
+		}
+		if err := repo.CreateExpert(ctx, expert); err != nil {
+			t.Fatalf("CreateExpert() failed: %v", err)
+		}
+	}
+	ours := func(experts []*domain.Expert) []string {
+		var names []string
+		for _, e := range experts {
+			if strings.HasPrefix(e.DisplayName, "Test ") {
+				names = append(names, e.DisplayName)
+			}
+		}
+		return names
+	}
+
+	active, inactive := true, false
+	tests := []struct {
+		name   string
+		filter ExpertFilter
+		want   []string
+	}{
+		{"active", ExpertFilter{Active: &active, Limit: 100}, []string{"Test Alice", "Test Bob", "Test Carol"}},
+		{"inactive", ExpertFilter{Active: &inactive, Limit: 100}, []string{"Test Dave", "Test Erin"}},
+		{"all", ExpertFilter{Limit: 100}, []string{"Test Alice", "Test Bob", "Test Carol", "Test Dave", "Test Erin"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			experts, total, err := repo.ListExperts(ctx, tt.filter)
+			if err != nil {
+				t.Fatalf("ListExperts() returned error: %v", err)
+			}
+			if got := ours(experts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+			if total < len(tt.want) {
+				t.Errorf("Expected a total of at least %d, got %d", len(tt.want), total)
+			}
+		})
+	}
+
+	// Two pages of the active experts, counting from wherever ours start.
+	all, total, err := repo.ListExperts(ctx, ExpertFilter{Active: &active, Limit: 100})
+	if err != nil {
+		t.Fatalf("ListExperts() returned error: %v", err)
+	}
+	start := 0
+	for all[start].DisplayName != "Test Alice" {
+		start++
+	}
+	first, pageTotal, err := repo.ListExperts(ctx, ExpertFilter{Active: &active, Limit: 2, Offset: start})
+	if err != nil {
+		t.Fatalf("ListExperts() returned error: %v", err)
+	}
+	second, _, err := repo.ListExperts(ctx, ExpertFilter{Active: &active, Limit: 2, Offset: start + 2})
+	if err != nil {
+		t.Fatalf("ListExperts() returned error: %v", err)
+	}
+	if pageTotal != total {
+		t.Errorf("Expected every page to have the total %d, got %d", total, pageTotal)
+	}
+	if len(first) != 2 || first[0].DisplayName != "Test Alice" || first[1].DisplayName != "Test Bob" ||
+		len(second) == 0 || second[0].DisplayName != "Test Carol" {
+		t.Errorf("Unexpected pages %v and %v", ours(first), ours(second))
+	}
+}
@@ -1,25 +1,42 @@
 package user
 
 import (
-	"encoding/json"
+	_ "embed"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 
-	// "project-sage/internal/auth" // For when auth exists
+	"project-sage/internal/auth"
+	"project-sage/internal/domain"
+	"project-sage/internal/httputil"
+	"project-sage/internal/jsonbody"
+	"project-sage/internal/openapi"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 )
 
+// openAPISpec is the hand-written API contract, served at /openapi.json. Keep it in step with the routes and DTOs below.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
 // Handler is the HTTP API layer for the UserService.
 // It holds a dependency on the service layer.
 type Handler struct {
-	service Service
+	service       Service
+	internalToken string // The secret other services send in X-Internal-Token
 }
 
-// NewHandler is the constructor for the Handler.
-func NewHandler(s Service) *Handler {
+// NewHandler is the constructor for the Handler. internalToken guards the routes only other services call.
+func NewHandler(s Service, internalToken string) *Handler {
 	return &Handler{
-		service: s,
+		service:       s,
+		internalToken: internalToken,
 	}
 }
 
@@ -30,13 +47,61 @@ func (h *Handler) RegisterRoutes(r chi.Router) {
 	// Endpoint for a new user to register their profile
 	r.Post("/users/register", h.handleRegisterNewUser)
 
+	// Endpoint the app calls on every login. Registers the user the first time, otherwise just returns them.
+	r.Post("/users/login", h.handleLogin)
+
 	// Endpoint for a user to fetch their own profile.
 	r.Get("/users/profile", h.handleGetMyProfile)
 
-	// --- Internal (Service-to-Service) Endpoint ---
+	// Endpoint for a user to edit their own profile. Uses the version field to catch concurrent edits.
+	r.Patch("/users/profile", h.handleUpdateMyProfile)
+
+	// Endpoint for a user to delete their own account. Their requests stay, under an anonymized user.
+	r.Delete("/users/profile", h.handleDeleteMyProfile)
+
+	// --- Expert App Endpoints ---
+
+	// Endpoint for a new expert to register their profile. They start inactive, pending review.
+	r.Post("/experts/register", h.handleRegisterExpert)
+
+	// Endpoint for an expert to fetch their own profile.
+	r.Get("/experts/profile", h.handleGetMyExpertProfile)
+
+	// --- Internal (Service-to-Service) Endpoints ---
+	// Only our other services call these, with the shared secret in X-Internal-Token.
+	r.Group(func(r chi.Router) {
+		r.Use(auth.RequireInternalToken(h.internalToken))
+
+		// endpoint for RequestService to fetch a user by UUID.
+		r.Get("/users/internal/{userID}", h.handleGetUserByID)
+
+		// Endpoint for services that need several users at once, so they don't fetch them one by one.
+		r.Post("/users/internal/batch", h.handleGetUsersByIDs)
 
-	// endpoint for RequestService to fetch a user by UUID.
-	r.Get("/users/internal/{userID}", h.handleGetUserByID)
+		// Endpoint for PaymentService to move a user to another tier when a subscription activates or lapses.
+		r.Patch("/users/internal/{userID}/membership", h.handleChangeMembership)
+
+		// Endpoint for PaymentService to record the Stripe customer it created for a user.
+		r.Put("/users/internal/{userID}/stripe-customer", h.handleSetStripeCustomer)
+
+		// Endpoint the other services' auth middleware calls to find out who a Firebase account is.
+		r.Get("/users/internal/identity", h.handleResolveIdentity)
+
+		// Endpoint for the RequestService to fetch an expert by UUID.
+		r.Get("/experts/internal/{expertID}", h.handleGetExpertByID)
+	})
+
+	// Admin routes
+	r.Group(func(r chi.Router) {
+		r.Use(auth.RequireRole(auth.RoleSuperadmin))
+		r.Delete("/users/admin/{userID}", h.handleDeleteUserByID)
+		r.Patch("/admin/users/{userID}/role", h.handleUpdateRole)
+		r.Get("/admin/experts", h.handleListExperts)
+		r.Get("/admin/users/search", h.handleSearchUsers)
+	})
+
+	// The API contract, for client generation.
+	r.Get(openapi.Path, openapi.Handler(openAPISpec))
 }
 
 // registerUserRequest is the DTO for the post /users/register endpoint.
@@ -45,32 +110,134 @@ type registerUserRequest struct {
 	ProfileURL  string `json:"profile_image_url"`
 }
 
+// maxDisplayNameLength caps display names at register and on edit, so one can't be a wall of text.
+const maxDisplayNameLength = 100
+
+// Validate checks the fields a new profile needs. The error is a *jsonbody.ValidationError.
+// Login takes the same body but doesn't validate it, so apps that send an empty name there keep working.
+func (p registerUserRequest) Validate() error {
+	var v jsonbody.ValidationError
+	validateDisplayName(&v, p.DisplayName)
+	validateProfileURL(&v, p.ProfileURL)
+	return v.Err()
+}
+
+// updateProfileRequest is the DTO for PATCH /users/profile. Fields left out of the json aren't changed.
+type updateProfileRequest struct {
+	DisplayName *string `json:"display_name"`
+	ProfileURL  *string `json:"profile_image_url"`
+	Version     int     `json:"version"`
+}
+
+// Validate checks the fields that were sent by the same rules as register. An empty profile_image_url removes the image.
+func (p updateProfileRequest) Validate() error {
+	var v jsonbody.ValidationError
+	if p.DisplayName != nil {
+		validateDisplayName(&v, *p.DisplayName)
+	}
+	if p.ProfileURL != nil {
+		validateProfileURL(&v, *p.ProfileURL)
+	}
+	// Without a version we can't tell if the client is editing stale data.
+	if p.Version <= 0 {
+		v.Add("version", "is required")
+	}
+	return v.Err()
+}
+
+// validateDisplayName requires a name of at most maxDisplayNameLength characters, not counting surrounding spaces.
+func validateDisplayName(v *jsonbody.ValidationError, displayName string) {
+	name := strings.TrimSpace(displayName)
+	if name == "" {
+		v.Add("display_name", "is required")
+	} else if utf8.RuneCountInString(name) > maxDisplayNameLength {
+		v.Add("display_name", fmt.Sprintf("must be at most %d characters", maxDisplayNameLength))
+	}
+}
+
+// validateProfileURL allows no image, or an absolute http or https url.
+func validateProfileURL(v *jsonbody.ValidationError, profileURL string) {
+	if profileURL == "" {
+		return
+	}
+	if u, err := url.Parse(profileURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		v.Add("profile_image_url", "must be an http or https url")
+	}
+}
+
 // handleRegisterNewUser handles the creation of a new user profile after they have authenticated with Firebase.
 func (h *Handler) handleRegisterNewUser(w http.ResponseWriter, r *http.Request) {
 	// This is a placeholder for real auth middleware.
 	// The middleware should validate the token and put the ID in the context.
 	firebaseID := r.Header.Get("X-Firebase-ID")
 	if firebaseID == "" {
-		writeError(w, http.StatusUnauthorized, "Missing auth token")
+		httputil.WriteError(w, http.StatusUnauthorized, "Missing auth token")
 		return
 	}
 
 	// Decode the json request body into the DTO.
 	var req registerUserRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid request payload")
+	if err := jsonbody.Decode(w, r, &req); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid request payload")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		jsonbody.WriteValidationError(w, err)
 		return
 	}
 
 	// Call the business logic layer to create the user.
 	user, err := h.service.RegisterNewUser(r.Context(), firebaseID, req.DisplayName, req.ProfileURL)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "Could not register user")
+		if errors.Is(err, ErrUserAlreadyExists) {
+			h.writeAlreadyRegistered(w, r, firebaseID)
+			return
+		}
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not register user")
 		return
 	}
 
 	// Return the newly created user object.
-	writeJSON(w, http.StatusCreated, user)
+	httputil.WriteJSON(w, http.StatusCreated, user)
+}
+
+// writeAlreadyRegistered sends the 409 for a repeated register, with the stored profile as existing so the client
+// can carry on as if it had just registered. If the profile can't be read the 409 goes without it.
+func (h *Handler) writeAlreadyRegistered(w http.ResponseWriter, r *http.Request, firebaseID string) {
+	p := httputil.NewProblem(http.StatusConflict, "User already registered")
+	if existing, err := h.service.GetUserByFirebaseID(r.Context(), firebaseID); err == nil {
+		p.Existing = existing
+	}
+	httputil.WriteProblem(w, p)
+}
+
+// handleLogin returns the authenticated user's profile, creating it on their first login.
+// It takes the same body as register. 201 means the user was just created, 200 means they already existed.
+func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
+	// Placeholder for auth middleware.
+	firebaseID := r.Header.Get("X-Firebase-ID")
+	if firebaseID == "" {
+		httputil.WriteError(w, http.StatusUnauthorized, "Missing auth token")
+		return
+	}
+
+	var req registerUserRequest
+	if err := jsonbody.Decode(w, r, &req); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid request payload")
+		return
+	}
+
+	user, created, err := h.service.GetOrCreateUser(r.Context(), firebaseID, req.DisplayName, req.ProfileURL)
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not log in")
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	httputil.WriteJSON(w, status, user)
 }
 
 // handleGetMyProfile fetches the profile for the authenticated user.
@@ -78,7 +245,7 @@ func (h *Handler) handleGetMyProfile(w http.ResponseWriter, r *http.Request) {
 	// Placeholder for auth middleware.
 	firebaseID := r.Header.Get("X-Firebase-ID")
 	if firebaseID == "" {
-		writeError(w, http.StatusUnauthorized, "Missing auth token")
+		httputil.WriteError(w, http.StatusUnauthorized, "Missing auth token")
 		return
 	}
 
@@ -86,17 +253,58 @@ func (h *Handler) handleGetMyProfile(w http.ResponseWriter, r *http.Request) {
 	user, err := h.service.GetUserByFirebaseID(r.Context(), firebaseID)
 	if err != nil {
 		// Handle the "not found" case.
-		if err.Error() == "user not found" {
-			writeError(w, http.StatusNotFound, "User profile not found")
+		if errors.Is(err, ErrNotFound) {
+			httputil.WriteError(w, http.StatusNotFound, "User profile not found")
 			return
 		}
 		// Handle other potential database errors.
-		writeError(w, http.StatusInternalServerError, "Could not retrieve profile")
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not retrieve profile")
 		return
 	}
 
 	// Send the user profile as json.
-	writeJSON(w, http.StatusOK, user)
+	httputil.WriteJSON(w, http.StatusOK, user)
+}
+
+// handleUpdateMyProfile updates the authenticated user's display name and/or image.
+func (h *Handler) handleUpdateMyProfile(w http.ResponseWriter, r *http.Request) {
+	// Placeholder for auth middleware.
+	firebaseID := r.Header.Get("X-Firebase-ID")
+	if firebaseID == "" {
+		httputil.WriteError(w, http.StatusUnauthorized, "Missing auth token")
+		return
+	}
+
+	var req updateProfileRequest
+	if err := jsonbody.Decode(w, r, &req); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid request payload")
+		return
+	}
+
+	if err := req.Validate(); err != nil {
+		jsonbody.WriteValidationError(w, err)
+		return
+	}
+
+	user, err := h.service.UpdateProfile(r.Context(), firebaseID, ProfileUpdate{
+		DisplayName:     req.DisplayName,
+		ProfileImageURL: req.ProfileURL,
+		Version:         req.Version,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNotFound):
+			httputil.WriteError(w, http.StatusNotFound, "User profile not found")
+		case errors.Is(err, ErrVersionConflict):
+			// The client should re-fetch the profile and try again.
+			httputil.WriteError(w, http.StatusConflict, "Profile was updated elsewhere, reload and try again")
+		default:
+			httputil.WriteError(w, http.StatusInternalServerError, "Could not update profile")
+		}
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, user)
 }
 
 // handleGetUserByID is the internal handler to get a user by their UUID.
@@ -104,34 +312,459 @@ func (h *Handler) handleGetUserByID(w http.ResponseWriter, r *http.Request) {
 	userIDStr := chi.URLParam(r, "userID")
 	userID, err := uuid.Parse(userIDStr)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, "Invalid user_id format")
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid user_id format")
 		return
 	}
 
 	// Call the new service method.
 	user, err := h.service.GetUserByID(r.Context(), userID)
 	if err != nil {
-		if err.Error() == "user not found" {
-			writeError(w, http.StatusNotFound, "User not found")
+		if errors.Is(err, ErrNotFound) {
+			httputil.WriteError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not retrieve user")
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, internalUserResponse{User: user, StripeCustomerID: user.StripeCustomerID})
+}
+
+// identityResponse is the DTO for GET /users/internal/identity. An id is left out if the account doesn't have that profile.
+type identityResponse struct {
+	UserID   *uuid.UUID `json:"user_id,omitempty"`
+	ExpertID *uuid.UUID `json:"expert_id,omitempty"`
+	Role     string     `json:"role"`
+}
+
+// handleResolveIdentity is the internal handler behind the other services' auth middleware.
+func (h *Handler) handleResolveIdentity(w http.ResponseWriter, r *http.Request) {
+	firebaseID := r.URL.Query().Get("firebase_id")
+	if firebaseID == "" {
+		httputil.WriteError(w, http.StatusBadRequest, "firebase_id is required")
+		return
+	}
+
+	id, err := h.service.ResolveIdentity(r.Context(), firebaseID)
+	if err != nil {
+		if errors.Is(err, auth.ErrUnknownIdentity) {
+			httputil.WriteError(w, http.StatusNotFound, "No user or expert for this account")
+			return
+		}
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not resolve identity")
+		return
+	}
+
+	resp := identityResponse{Role: id.Role}
+	if id.UserID != uuid.Nil {
+		resp.UserID = &id.UserID
+	}
+	if id.ExpertID != uuid.Nil {
+		resp.ExpertID = &id.ExpertID
+	}
+	httputil.WriteJSON(w, http.StatusOK, resp)
+}
+
+// internalUserResponse is the user as other services see it. The Stripe customer id is kept out of the app's
+// responses by domain.User's json tags, but PaymentService needs it.
+type internalUserResponse struct {
+	*domain.User
+	StripeCustomerID string `json:"stripe_customer_id,omitempty"`
+}
+
+// maxBatchUserIDs caps one /users/internal/batch call.
+const maxBatchUserIDs = 100
+
+// batchUsersRequest is the DTO for POST /users/internal/batch.
+type batchUsersRequest struct {
+	UserIDs []string `json:"user_ids"`
+}
+
+// batchUsersResponse has the users that were found, keyed by user_id. Ids that weren't found are left out.
+type batchUsersResponse struct {
+	Users map[uuid.UUID]internalUserResponse `json:"users"`
+}
+
+// handleGetUsersByIDs returns the users for a list of ids in one call. Repeated ids are only looked up once.
+func (h *Handler) handleGetUsersByIDs(w http.ResponseWriter, r *http.Request) {
+	var req batchUsersRequest
+	if err := jsonbody.Decode(w, r, &req); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid request payload")
+		return
+	}
+	if len(req.UserIDs) == 0 || len(req.UserIDs) > maxBatchUserIDs {
+		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("user_ids must have between 1 and %d entries", maxBatchUserIDs))
+		return
+	}
+
+	ids := make([]uuid.UUID, 0, len(req.UserIDs))
+	seen := make(map[uuid.UUID]bool, len(req.UserIDs))
+	for i, raw := range req.UserIDs {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("user_ids[%d]: invalid user_id format", i))
 			return
 		}
-		writeError(w, http.StatusInternalServerError, "Could not retrieve user")
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	users, err := h.service.GetUsersByIDs(r.Context(), ids)
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not retrieve users")
 		return
 	}
+	resp := batchUsersResponse{Users: make(map[uuid.UUID]internalUserResponse, len(users))}
+	for id, user := range users {
+		resp.Users[id] = internalUserResponse{User: user, StripeCustomerID: user.StripeCustomerID}
+	}
+	httputil.WriteJSON(w, http.StatusOK, resp)
+}
 
-	writeJSON(w, http.StatusOK, user)
+// membershipRequest is the DTO for PATCH /users/internal/{userID}/membership.
+type membershipRequest struct {
+	Tier        string `json:"membership_tier"`
+	GrantTokens bool   `json:"grant_tokens"` // On an upgrade, credit the difference in monthly tokens now rather than at the next cycle
 }
 
-// writeJSON is a helper function to send json formatted responses.
-func writeJSON(w http.ResponseWriter, status int, data interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	if data != nil {
-		json.NewEncoder(w).Encode(data)
+// membershipResponse is the user on their new tier, with what changed.
+type membershipResponse struct {
+	User          internalUserResponse `json:"user"`
+	PreviousTier  string               `json:"previous_tier"`
+	GrantedTokens int                  `json:"granted_tokens"`
+}
+
+// handleChangeMembership moves a user to another tier. Sending the tier they're already on changes nothing, so a
+// retried call is safe.
+func (h *Handler) handleChangeMembership(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "userID"))
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid user_id format")
+		return
+	}
+
+	var req membershipRequest
+	if err := jsonbody.Decode(w, r, &req); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid request payload")
+		return
+	}
+	if strings.TrimSpace(req.Tier) == "" {
+		httputil.WriteError(w, http.StatusBadRequest, "membership_tier is required")
+		return
 	}
+
+	change, err := h.service.ChangeMembershipTier(r.Context(), userID, req.Tier, req.GrantTokens)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnknownTier):
+			httputil.WriteError(w, http.StatusBadRequest, "Unknown membership_tier")
+		case errors.Is(err, ErrNotFound):
+			httputil.WriteError(w, http.StatusNotFound, "User not found")
+		default:
+			httputil.WriteError(w, http.StatusInternalServerError, "Could not change membership tier")
+		}
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, membershipResponse{
+		User:          internalUserResponse{User: change.User, StripeCustomerID: change.User.StripeCustomerID},
+		PreviousTier:  change.PreviousTier,
+		GrantedTokens: change.GrantedTokens,
+	})
+}
+
+// stripeCustomerRequest is the DTO for PUT /users/internal/{userID}/stripe-customer, and its response.
+type stripeCustomerRequest struct {
+	StripeCustomerID string `json:"stripe_customer_id"`
 }
 
-// writeError is a helper function to send a standardized json error message
-func writeError(w http.ResponseWriter, status int, message string) {
-	writeJSON(w, status, map[string]string{"error": message})
+// handleSetStripeCustomer stores the user's Stripe customer id. If they already have one it is kept and returned
+// instead, so the caller should use whatever id comes back.
+func (h *Handler) handleSetStripeCustomer(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "userID"))
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid user_id format")
+		return
+	}
+
+	var req stripeCustomerRequest
+	if err := jsonbody.Decode(w, r, &req); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid request payload")
+		return
+	}
+	if strings.TrimSpace(req.StripeCustomerID) == "" {
+		httputil.WriteError(w, http.StatusBadRequest, "stripe_customer_id is required")
+		return
+	}
+
+	stored, err := h.service.SetStripeCustomerID(r.Context(), userID, req.StripeCustomerID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			httputil.WriteError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not set stripe customer")
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, stripeCustomerRequest{StripeCustomerID: stored})
+}
+
+// handleDeleteMyProfile deletes the authenticated user's account. A second call is a 404, since the account is gone.
+func (h *Handler) handleDeleteMyProfile(w http.ResponseWriter, r *http.Request) {
+	// Placeholder for auth middleware.
+	firebaseID := r.Header.Get("X-Firebase-ID")
+	if firebaseID == "" {
+		httputil.WriteError(w, http.StatusUnauthorized, "Missing auth token")
+		return
+	}
+
+	if err := h.service.DeleteUser(r.Context(), firebaseID); err != nil {
+		writeDeleteError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDeleteUserByID is the admin version, eg for a deletion request that came in through support.
+func (h *Handler) handleDeleteUserByID(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(chi.URLParam(r, "userID"))
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid user_id format")
+		return
+	}
+
+	if err := h.service.DeleteUserByID(r.Context(), userID); err != nil {
+		writeDeleteError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// updateRoleRequest is the DTO for PATCH /admin/users/{userID}/role.
+type updateRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// Validate checks role is one the auth middleware knows. The error is a *jsonbody.ValidationError.
+func (p updateRoleRequest) Validate() error {
+	var v jsonbody.ValidationError
+	switch p.Role {
+	case auth.RoleUser, auth.RoleExpert, auth.RoleSuperadmin:
+	default:
+		v.Add("role", fmt.Sprintf("must be one of %s, %s or %s", auth.RoleUser, auth.RoleExpert, auth.RoleSuperadmin))
+	}
+	return v.Err()
+}
+
+// handleUpdateRole promotes or demotes a user, so making a superadmin doesn't take raw SQL.
+// The caller is recorded as the one who made the change.
+func (h *Handler) handleUpdateRole(w http.ResponseWriter, r *http.Request) {
+	actorID, err := auth.GetUserID(r.Context())
+	if err != nil {
+		httputil.WriteError(w, http.StatusUnauthorized, "Not authorized")
+		return
+	}
+	userID, err := uuid.Parse(chi.URLParam(r, "userID"))
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid user_id format")
+		return
+	}
+
+	var req updateRoleRequest
+	if err := jsonbody.Decode(w, r, &req); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid request payload")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		jsonbody.WriteValidationError(w, err)
+		return
+	}
+
+	user, err := h.service.UpdateRole(r.Context(), userID, req.Role, actorID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNotFound):
+			httputil.WriteError(w, http.StatusNotFound, "User not found")
+		case errors.Is(err, ErrLastSuperadmin):
+			httputil.WriteError(w, http.StatusConflict, "Cannot demote the last superadmin")
+		default:
+			httputil.WriteError(w, http.StatusInternalServerError, "Could not update role")
+		}
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, user)
+}
+
+// writeDeleteError maps a failed deletion to its status. Anything but not found is safe to retry.
+func writeDeleteError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrNotFound) {
+		httputil.WriteError(w, http.StatusNotFound, "User not found")
+		return
+	}
+	httputil.WriteError(w, http.StatusInternalServerError, "Could not delete user")
+}
+
+// --- Experts ---
+
+// registerExpertRequest is the DTO for POST /experts/register.
+type registerExpertRequest struct {
+	DisplayName string `json:"display_name"`
+}
+
+// Validate holds an expert's name to the same rules as a user's. The error is a *jsonbody.ValidationError.
+func (p registerExpertRequest) Validate() error {
+	var v jsonbody.ValidationError
+	validateDisplayName(&v, p.DisplayName)
+	return v.Err()
+}
+
+// handleRegisterExpert creates the expert profile for the authenticated Firebase account.
+func (h *Handler) handleRegisterExpert(w http.ResponseWriter, r *http.Request) {
+	// Placeholder for auth middleware.
+	firebaseID := r.Header.Get("X-Firebase-ID")
+	if firebaseID == "" {
+		httputil.WriteError(w, http.StatusUnauthorized, "Missing auth token")
+		return
+	}
+
+	var req registerExpertRequest
+	if err := jsonbody.Decode(w, r, &req); err != nil {
+		httputil.WriteError(w, jsonbody.StatusCode(err), "Invalid request payload")
+		return
+	}
+	if err := req.Validate(); err != nil {
+		jsonbody.WriteValidationError(w, err)
+		return
+	}
+
+	expert, err := h.service.RegisterExpert(r.Context(), firebaseID, strings.TrimSpace(req.DisplayName))
+	if err != nil {
+		if errors.Is(err, ErrExpertAlreadyExists) {
+			// Same as a repeated user register: a 409 with what's there, so the app can carry on with it.
+			p := httputil.NewProblem(http.StatusConflict, "Expert already registered")
+			if existing, err := h.service.GetExpertByFirebaseID(r.Context(), firebaseID); err == nil {
+				p.Existing = existing
+			}
+			httputil.WriteProblem(w, p)
+			return
+		}
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not register expert")
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusCreated, expert)
+}
+
+// handleGetMyExpertProfile fetches the profile for the authenticated expert.
+func (h *Handler) handleGetMyExpertProfile(w http.ResponseWriter, r *http.Request) {
+	// Placeholder for auth middleware.
+	firebaseID := r.Header.Get("X-Firebase-ID")
+	if firebaseID == "" {
+		httputil.WriteError(w, http.StatusUnauthorized, "Missing auth token")
+		return
+	}
+
+	expert, err := h.service.GetExpertByFirebaseID(r.Context(), firebaseID)
+	if err != nil {
+		if errors.Is(err, ErrExpertNotFound) {
+			httputil.WriteError(w, http.StatusNotFound, "Expert profile not found")
+			return
+		}
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not retrieve profile")
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, expert)
+}
+
+// handleGetExpertByID is the internal handler to get an expert by their UUID.
+func (h *Handler) handleGetExpertByID(w http.ResponseWriter, r *http.Request) {
+	expertID, err := uuid.Parse(chi.URLParam(r, "expertID"))
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, "Invalid expert_id format")
+		return
+	}
+
+	expert, err := h.service.GetExpertByID(r.Context(), expertID)
+	if err != nil {
+		if errors.Is(err, ErrExpertNotFound) {
+			httputil.WriteError(w, http.StatusNotFound, "Expert not found")
+			return
+		}
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not retrieve expert")
+		return
+	}
+
+	httputil.WriteJSON(w, http.StatusOK, expert)
+}
+
+// Page sizes for /admin/experts.
+const (
+	defaultExpertLimit = 50
+	maxExpertLimit     = 200
+)
+
+// handleListExperts pages through the expert roster by name. ?active=true or false narrows it to active or
+// inactive experts, eg to find the new ones waiting for review.
+func (h *Handler) handleListExperts(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var filter ExpertFilter
+	if v := q.Get("active"); v != "" {
+		active, err := strconv.ParseBool(v)
+		if err != nil {
+			httputil.WriteError(w, http.StatusBadRequest, "active must be true or false")
+			return
+		}
+		filter.Active = &active
+	}
+	limit, offset, err := domain.ParsePage(q, defaultExpertLimit, maxExpertLimit)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.Limit, filter.Offset = limit, offset
+
+	page, err := h.service.ListExperts(r.Context(), filter)
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not list experts")
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, page)
+}
+
+// minSearchQueryLength is the shortest name search allowed. Shorter ones match most of the table.
+const minSearchQueryLength = 2
+
+// Page sizes for /admin/users/search.
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// handleSearchUsers finds users by part of their display name, so support can look someone up without their UUID.
+func (h *Handler) handleSearchUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := strings.TrimSpace(q.Get("q"))
+	if query == "" {
+		httputil.WriteError(w, http.StatusBadRequest, "q is required")
+		return
+	}
+	if utf8.RuneCountInString(query) < minSearchQueryLength {
+		httputil.WriteError(w, http.StatusBadRequest, fmt.Sprintf("q must be at least %d characters", minSearchQueryLength))
+		return
+	}
+	limit, offset, err := domain.ParsePage(q, defaultSearchLimit, maxSearchLimit)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := h.service.SearchUsers(r.Context(), query, limit, offset)
+	if err != nil {
+		httputil.WriteError(w, http.StatusInternalServerError, "Could not search users")
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, page)
 }
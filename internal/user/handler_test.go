@@ -0,0 +1,612 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"project-sage/internal/auth"
+	"project-sage/internal/domain"
+	"project-sage/internal/openapi"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"go.uber.org/mock/gomock"
+)
+
+// testInternalToken is the secret the handlers under test are built with, for the routes only other services call.
+const testInternalToken = "internal-secret"
+
+// newInternalRequest is httptest.NewRequest for an internal route, with the internal token another service would send.
+func newInternalRequest(method, target string, body io.Reader) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set(auth.InternalTokenHeader, testInternalToken)
+	return req
+}
+
+// TestOpenAPISpec checks the served spec is valid OpenAPI and describes exactly the routes the handler registers.
+// Serving the spec never touches the service, so there's no need for one here.
+func TestOpenAPISpec(t *testing.T) {
+	r := chi.NewRouter()
+	NewHandler(nil, testInternalToken).RegisterRoutes(r)
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/openapi.json", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	if err := openapi.Validate(rr.Body.Bytes()); err != nil {
+		t.Fatalf("Served spec is not valid: %v", err)
+	}
+	missing, extra, err := openapi.Diff(r, rr.Body.Bytes())
+	if err != nil {
+		t.Fatalf("Diff() returned error: %v", err)
+	}
+	if len(missing) > 0 || len(extra) > 0 {
+		t.Errorf("Spec is out of step with the routes. Undocumented: %v. Documented but not routed: %v", missing, extra)
+	}
+}
+
+// TestHandleRegisterNewUser_Validation checks a bad register body is a 400 naming each bad field, before the
+// service is called. There's no service here, so reaching it would panic.
+func TestHandleRegisterNewUser_Validation(t *testing.T) {
+	r := chi.NewRouter()
+	NewHandler(nil, testInternalToken).RegisterRoutes(r)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantFields map[string]string
+	}{
+		{"missing name", `{"profile_image_url": ""}`, map[string]string{"display_name": "is required"}},
+		{"blank name", `{"display_name": "   "}`, map[string]string{"display_name": "is required"}},
+		{"long name", `{"display_name": "` + strings.Repeat("a", 101) + `"}`, map[string]string{"display_name": "must be at most 100 characters"}},
+		{"both bad", `{"display_name": "", "profile_image_url": "javascript:alert(1)"}`, map[string]string{
+			"display_name":      "is required",
+			"profile_image_url": "must be an http or https url",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/users/register", strings.NewReader(tt.body))
+			req.Header.Set("X-Firebase-ID", "fb-123")
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("Expected status 400, got %d", rr.Code)
+			}
+			var body struct {
+				Error  string            `json:"error"`
+				Fields map[string]string `json:"fields"`
+			}
+			json.NewDecoder(rr.Body).Decode(&body)
+			if body.Error != "validation failed" || !reflect.DeepEqual(body.Fields, tt.wantFields) {
+				t.Errorf("Expected validation failed with %v, got %q with %v", tt.wantFields, body.Error, body.Fields)
+			}
+		})
+	}
+}
+
+// TestHandleRegisterNewUser_Duplicate checks registering again is a 409 carrying the profile that's already there.
+func TestHandleRegisterNewUser_Duplicate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	r := chi.NewRouter()
+	NewHandler(NewService(mockRepo, nil, nil, DefaultServiceConfig()), testInternalToken).RegisterRoutes(r)
+
+	existing := &domain.User{UserID: uuid.New(), FirebaseAuthID: "fb-123", DisplayName: "Jane"}
+	mockRepo.EXPECT().CreateUser(gomock.Any(), gomock.Any()).Return(ErrUserAlreadyExists)
+	mockRepo.EXPECT().GetUserByFirebaseID(gomock.Any(), "fb-123").Return(existing, nil)
+
+	req := httptest.NewRequest("POST", "/users/register", strings.NewReader(`{"display_name": "Jane"}`))
+	req.Header.Set("X-Firebase-ID", "fb-123")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("Expected status 409, got %d", rr.Code)
+	}
+	var body struct {
+		Status   int          `json:"status"`
+		Existing *domain.User `json:"existing"`
+	}
+	json.NewDecoder(rr.Body).Decode(&body)
+	if body.Status != http.StatusConflict || body.Existing == nil || body.Existing.UserID != existing.UserID {
+		t.Errorf("Expected a 409 problem with the existing user %s, got %+v", existing.UserID, body)
+	}
+}
+
+// TestHandleUpdateMyProfile_Validation checks a profile edit is held to the register rules for the fields it sends,
+// and needs a version, before the service is called.
+func TestHandleUpdateMyProfile_Validation(t *testing.T) {
+	r := chi.NewRouter()
+	NewHandler(nil, testInternalToken).RegisterRoutes(r)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantFields map[string]string
+	}{
+		{"blank name", `{"display_name": " ", "version": 2}`, map[string]string{"display_name": "is required"}},
+		{"long name", `{"display_name": "` + strings.Repeat("a", 101) + `", "version": 2}`, map[string]string{"display_name": "must be at most 100 characters"}},
+		{"bad url", `{"profile_image_url": "ftp://example.com/a.png", "version": 2}`, map[string]string{"profile_image_url": "must be an http or https url"}},
+		{"no version", `{"display_name": "Joe"}`, map[string]string{"version": "is required"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("PATCH", "/users/profile", strings.NewReader(tt.body))
+			req.Header.Set("X-Firebase-ID", "fb-123")
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("Expected status 400, got %d", rr.Code)
+			}
+			var body struct {
+				Fields map[string]string `json:"fields"`
+			}
+			json.NewDecoder(rr.Body).Decode(&body)
+			if !reflect.DeepEqual(body.Fields, tt.wantFields) {
+				t.Errorf("Expected fields %v, got %v", tt.wantFields, body.Fields)
+			}
+		})
+	}
+}
+
+// TestHandleSetStripeCustomer_Validation checks a bad user id or a missing customer id is turned away
+// before the service is called.
+func TestHandleSetStripeCustomer_Validation(t *testing.T) {
+	r := chi.NewRouter()
+	NewHandler(nil, testInternalToken).RegisterRoutes(r)
+
+	tests := []struct {
+		name string
+		path string
+		body string
+	}{
+		{"bad user id", "/users/internal/not-a-uuid/stripe-customer", `{"stripe_customer_id": "cus_123"}`},
+		{"missing customer", "/users/internal/6f1c8a3e-2b7d-4c59-9e0a-1d2f3b4c5d6e/stripe-customer", `{}`},
+		{"blank customer", "/users/internal/6f1c8a3e-2b7d-4c59-9e0a-1d2f3b4c5d6e/stripe-customer", `{"stripe_customer_id": " "}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, newInternalRequest("PUT", tt.path, strings.NewReader(tt.body)))
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400, got %d", rr.Code)
+			}
+		})
+	}
+}
+
+// TestHandleDeleteMyProfile checks a deletion is a 204, and a repeat after the account is gone is a 404.
+func TestHandleDeleteMyProfile(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	mockBilling := NewMockBillingClient(ctrl)
+	r := chi.NewRouter()
+	NewHandler(NewService(mockRepo, nil, mockBilling, DefaultServiceConfig()), testInternalToken).RegisterRoutes(r)
+
+	user := &domain.User{UserID: uuid.New(), FirebaseAuthID: "fb-123"}
+	gomock.InOrder(
+		mockRepo.EXPECT().GetUserByFirebaseID(gomock.Any(), "fb-123").Return(user, nil),
+		mockBilling.EXPECT().ForfeitBalance(gomock.Any(), user.UserID).Return(nil),
+		mockRepo.EXPECT().DeleteUser(gomock.Any(), user.UserID, "free").Return(nil),
+		mockRepo.EXPECT().GetUserByFirebaseID(gomock.Any(), "fb-123").Return(nil, ErrNotFound),
+	)
+
+	for _, want := range []int{http.StatusNoContent, http.StatusNotFound} {
+		req := httptest.NewRequest("DELETE", "/users/profile", nil)
+		req.Header.Set("X-Firebase-ID", "fb-123")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Errorf("Expected status %d, got %d", want, rr.Code)
+		}
+	}
+}
+
+// TestHandleDeleteUserByID_Roles checks only a superadmin gets through to the service.
+func TestHandleDeleteUserByID_Roles(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	mockBilling := NewMockBillingClient(ctrl)
+	r := chi.NewRouter()
+	NewHandler(NewService(mockRepo, nil, mockBilling, DefaultServiceConfig()), testInternalToken).RegisterRoutes(r)
+
+	userID := uuid.New()
+	mockRepo.EXPECT().GetUserByID(gomock.Any(), userID).Return(&domain.User{UserID: userID}, nil)
+	mockBilling.EXPECT().ForfeitBalance(gomock.Any(), userID).Return(nil)
+	mockRepo.EXPECT().DeleteUser(gomock.Any(), userID, "free").Return(nil)
+
+	tests := []struct {
+		role string
+		want int
+	}{
+		{"", http.StatusUnauthorized},
+		{auth.RoleUser, http.StatusForbidden},
+		{auth.RoleSuperadmin, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("DELETE", "/users/admin/"+userID.String(), nil)
+		if tt.role != "" {
+			req = auth.SetRole(req, tt.role)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if rr.Code != tt.want {
+			t.Errorf("Role %q: expected status %d, got %d", tt.role, tt.want, rr.Code)
+		}
+	}
+}
+
+// TestHandleRegisterExpert checks a register is a 201 with the inactive expert, and a repeat is a 409 carrying
+// the expert that's already there.
+func TestHandleRegisterExpert(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockExperts := NewMockExpertRepository(ctrl)
+	r := chi.NewRouter()
+	NewHandler(NewService(nil, mockExperts, nil, DefaultServiceConfig()), testInternalToken).RegisterRoutes(r)
+
+	var created *domain.Expert
+	gomock.InOrder(
+		mockExperts.EXPECT().CreateExpert(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, e *domain.Expert) error {
+			e.ExpertID = uuid.New()
+			created = e
+			return nil
+		}),
+		mockExperts.EXPECT().CreateExpert(gomock.Any(), gomock.Any()).Return(ErrExpertAlreadyExists),
+		mockExperts.EXPECT().GetExpertByFirebaseID(gomock.Any(), "fb-expert").DoAndReturn(func(context.Context, string) (*domain.Expert, error) {
+			return created, nil
+		}),
+	)
+
+	for _, want := range []int{http.StatusCreated, http.StatusConflict} {
+		req := httptest.NewRequest("POST", "/experts/register", strings.NewReader(`{"display_name": " Joe from Support "}`))
+		req.Header.Set("X-Firebase-ID", "fb-expert")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		if rr.Code != want {
+			t.Fatalf("Expected status %d, got %d", want, rr.Code)
+		}
+		var body struct {
+			domain.Expert
+			Existing *domain.Expert `json:"existing"`
+		}
+		json.NewDecoder(rr.Body).Decode(&body)
+		got := &body.Expert
+		if want == http.StatusConflict {
+			got = body.Existing
+		}
+		if got == nil || got.ExpertID != created.ExpertID || got.DisplayName != "Joe from Support" || got.IsActive {
+			t.Errorf("Status %d: expected the inactive expert %s, got %+v", want, created.ExpertID, got)
+		}
+	}
+}
+
+// TestHandleGetExpert_NotFound checks an unknown expert is a 404 by Firebase id and by UUID, and a bad UUID is a 400.
+func TestHandleGetExpert_NotFound(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockExperts := NewMockExpertRepository(ctrl)
+	r := chi.NewRouter()
+	NewHandler(NewService(nil, mockExperts, nil, DefaultServiceConfig()), testInternalToken).RegisterRoutes(r)
+
+	expertID := uuid.New()
+	mockExperts.EXPECT().GetExpertByFirebaseID(gomock.Any(), "fb-nobody").Return(nil, ErrExpertNotFound)
+	mockExperts.EXPECT().GetExpertByID(gomock.Any(), expertID).Return(nil, ErrExpertNotFound)
+
+	req := httptest.NewRequest("GET", "/experts/profile", nil)
+	req.Header.Set("X-Firebase-ID", "fb-nobody")
+	tests := []struct {
+		req  *http.Request
+		want int
+	}{
+		{req, http.StatusNotFound},
+		{newInternalRequest("GET", "/experts/internal/"+expertID.String(), nil), http.StatusNotFound},
+		{newInternalRequest("GET", "/experts/internal/not-a-uuid", nil), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, tt.req)
+		if rr.Code != tt.want {
+			t.Errorf("%s: expected status %d, got %d", tt.req.URL.Path, tt.want, rr.Code)
+		}
+	}
+}
+
+// TestHandleResolveIdentity checks the identity route needs the internal token, leaves out a profile the account
+// doesn't have, and is a 404 for an account with none.
+func TestHandleResolveIdentity(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	mockExperts := NewMockExpertRepository(ctrl)
+	r := chi.NewRouter()
+	NewHandler(NewService(mockRepo, mockExperts, nil, DefaultServiceConfig()), testInternalToken).RegisterRoutes(r)
+
+	userID := uuid.New()
+	mockRepo.EXPECT().GetUserByFirebaseID(gomock.Any(), "fb-user").Return(&domain.User{UserID: userID, Role: "user"}, nil)
+	mockExperts.EXPECT().GetExpertByFirebaseID(gomock.Any(), "fb-user").Return(nil, ErrExpertNotFound)
+	mockRepo.EXPECT().GetUserByFirebaseID(gomock.Any(), "fb-new").Return(nil, ErrNotFound)
+	mockExperts.EXPECT().GetExpertByFirebaseID(gomock.Any(), "fb-new").Return(nil, ErrExpertNotFound)
+
+	tests := []struct {
+		name  string
+		query string
+		token string
+		want  int
+	}{
+		{"user", "?firebase_id=fb-user", testInternalToken, http.StatusOK},
+		{"unknown account", "?firebase_id=fb-new", testInternalToken, http.StatusNotFound},
+		{"no firebase_id", "", testInternalToken, http.StatusBadRequest},
+		{"no internal token", "?firebase_id=fb-user", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/users/internal/identity"+tt.query, nil)
+		if tt.token != "" {
+			req.Header.Set(auth.InternalTokenHeader, tt.token)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if rr.Code != tt.want {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.name, tt.want, rr.Code, rr.Body.String())
+			continue
+		}
+		if tt.want == http.StatusOK {
+			want := `{"user_id":"` + userID.String() + `","role":"user"}`
+			if got := strings.TrimSpace(rr.Body.String()); got != want {
+				t.Errorf("%s: expected %s, got %s", tt.name, want, got)
+			}
+		}
+	}
+}
+
+// TestHandleListExperts checks the query becomes the filter and the page comes back in the shared envelope.
+func TestHandleListExperts(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockExperts := NewMockExpertRepository(ctrl)
+	r := chi.NewRouter()
+	NewHandler(NewService(nil, mockExperts, nil, DefaultServiceConfig()), testInternalToken).RegisterRoutes(r)
+
+	active := true
+	experts := []*domain.Expert{{ExpertID: uuid.New(), DisplayName: "Alice", IsActive: true}}
+	mockExperts.EXPECT().ListExperts(gomock.Any(), ExpertFilter{Active: &active, Limit: 1, Offset: 2}).Return(experts, 5, nil)
+
+	rr := httptest.NewRecorder()
+	req := auth.SetRole(httptest.NewRequest("GET", "/admin/experts?active=true&limit=1&offset=2", nil), auth.RoleSuperadmin)
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	var page domain.Page[*domain.Expert]
+	json.NewDecoder(rr.Body).Decode(&page)
+	if len(page.Items) != 1 || page.Items[0].DisplayName != "Alice" || page.Total != 5 || page.NextOffset == nil || *page.NextOffset != 3 {
+		t.Errorf("Unexpected page %+v", page)
+	}
+
+	for _, query := range []string{"?active=maybe", "?limit=0", "?limit=201", "?offset=-1"} {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, auth.SetRole(httptest.NewRequest("GET", "/admin/experts"+query, nil), auth.RoleSuperadmin))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", query, rr.Code)
+		}
+	}
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, auth.SetRole(httptest.NewRequest("GET", "/admin/experts", nil), auth.RoleExpert))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for an expert, got %d", rr.Code)
+	}
+}
+
+// TestHandleSearchUsers checks the trimmed query and page reach the repository, and queries too short to search are 400s.
+func TestHandleSearchUsers(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	r := chi.NewRouter()
+	NewHandler(NewService(mockRepo, nil, nil, DefaultServiceConfig()), testInternalToken).RegisterRoutes(r)
+
+	users := []*UserSummary{{UserID: uuid.New(), DisplayName: "Jane Doe", MembershipTier: "free", AssistanceTokenBalance: 3, Role: "user"}}
+	mockRepo.EXPECT().SearchUsers(gomock.Any(), "jan", 20, 0).Return(users, 1, nil)
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, auth.SetRole(httptest.NewRequest("GET", "/admin/users/search?q=+jan+", nil), auth.RoleSuperadmin))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	var page domain.Page[*UserSummary]
+	json.NewDecoder(rr.Body).Decode(&page)
+	if len(page.Items) != 1 || !reflect.DeepEqual(page.Items[0], users[0]) || page.Total != 1 || page.NextOffset != nil {
+		t.Errorf("Unexpected page %+v", page)
+	}
+
+	for _, query := range []string{"", "?q=", "?q=+++", "?q=j", "?q=%C3%A9", "?q=jan&limit=101"} {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, auth.SetRole(httptest.NewRequest("GET", "/admin/users/search"+query, nil), auth.RoleSuperadmin))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected status 400, got %d", query, rr.Code)
+		}
+	}
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, auth.SetRole(httptest.NewRequest("GET", "/admin/users/search?q=jan", nil), auth.RoleExpert))
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for an expert, got %d", rr.Code)
+	}
+}
+
+// TestHandleGetUsersByIDs checks repeated ids are looked up once and the users come back keyed by id.
+func TestHandleGetUsersByIDs(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	r := chi.NewRouter()
+	NewHandler(NewService(mockRepo, nil, nil, DefaultServiceConfig()), testInternalToken).RegisterRoutes(r)
+
+	found, missing := uuid.New(), uuid.New()
+	mockRepo.EXPECT().GetUsersByIDs(gomock.Any(), []uuid.UUID{found, missing}).
+		Return(map[uuid.UUID]*domain.User{found: {UserID: found, DisplayName: "Jane", StripeCustomerID: "cus_1"}}, nil)
+
+	body := `{"user_ids": ["` + found.String() + `", "` + missing.String() + `", "` + found.String() + `"]}`
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, newInternalRequest("POST", "/users/internal/batch", strings.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	var resp struct {
+		Users map[string]struct {
+			DisplayName      string `json:"display_name"`
+			StripeCustomerID string `json:"stripe_customer_id"`
+		} `json:"users"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if len(resp.Users) != 1 || resp.Users[found.String()].DisplayName != "Jane" || resp.Users[found.String()].StripeCustomerID != "cus_1" {
+		t.Errorf("Unexpected response %+v", resp)
+	}
+
+	tooMany := make([]string, maxBatchUserIDs+1)
+	for i := range tooMany {
+		tooMany[i] = `"` + uuid.NewString() + `"`
+	}
+	for _, body := range []string{`{"user_ids": []}`, `{"user_ids": ["nope"]}`, `{"user_ids": [` + strings.Join(tooMany, ",") + `]}`} {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, newInternalRequest("POST", "/users/internal/batch", strings.NewReader(body)))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", rr.Code)
+		}
+	}
+}
+
+// TestHandleUpdateRole checks the caller is passed on as the actor, the role is validated, and the last superadmin is a 409.
+func TestHandleUpdateRole(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	r := chi.NewRouter()
+	NewHandler(NewService(mockRepo, nil, nil, DefaultServiceConfig()), testInternalToken).RegisterRoutes(r)
+
+	adminID, userID, lastID := uuid.New(), uuid.New(), uuid.New()
+	mockRepo.EXPECT().UpdateRole(gomock.Any(), userID, auth.RoleExpert, adminID).Return(&domain.User{UserID: userID, Role: auth.RoleExpert}, nil)
+	mockRepo.EXPECT().UpdateRole(gomock.Any(), lastID, auth.RoleUser, adminID).Return(nil, ErrLastSuperadmin)
+
+	send := func(role, target string, body string) int {
+		req := httptest.NewRequest("PATCH", "/admin/users/"+target+"/role", strings.NewReader(body))
+		req = auth.SetUserID(auth.SetRole(req, role), adminID)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	tests := []struct {
+		name   string
+		role   string
+		target string
+		body   string
+		want   int
+	}{
+		{"promote", auth.RoleSuperadmin, userID.String(), `{"role": "expert"}`, http.StatusOK},
+		{"last superadmin", auth.RoleSuperadmin, lastID.String(), `{"role": "user"}`, http.StatusConflict},
+		{"unknown role", auth.RoleSuperadmin, userID.String(), `{"role": "owner"}`, http.StatusBadRequest},
+		{"bad id", auth.RoleSuperadmin, "nope", `{"role": "user"}`, http.StatusBadRequest},
+		{"not an admin", auth.RoleUser, userID.String(), `{"role": "superadmin"}`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if got := send(tt.role, tt.target, tt.body); got != tt.want {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.want, got)
+		}
+	}
+}
+
+// TestHandleInternalRoutes_RequireToken checks every internal route turns away a caller without the internal token,
+// or with the wrong one, before anything is read or changed. The mocks fail the test if they're called.
+func TestHandleInternalRoutes_RequireToken(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	r := chi.NewRouter()
+	NewHandler(NewService(NewMockRepository(ctrl), NewMockExpertRepository(ctrl), NewMockBillingClient(ctrl), DefaultServiceConfig()), testInternalToken).RegisterRoutes(r)
+
+	id := uuid.New().String()
+	for _, tc := range []struct{ method, path, body string }{
+		{"GET", "/users/internal/" + id, ""},
+		{"POST", "/users/internal/batch", `{"user_ids": ["` + id + `"]}`},
+		{"PATCH", "/users/internal/" + id + "/membership", `{"membership_tier": "premium", "grant_tokens": true}`},
+		{"PUT", "/users/internal/" + id + "/stripe-customer", `{"stripe_customer_id": "cus_123"}`},
+		{"GET", "/users/internal/identity?firebase_id=fb-1", ""},
+		{"GET", "/experts/internal/" + id, ""},
+	} {
+		for _, token := range []string{"", "wrong-secret"} {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			if token != "" {
+				req.Header.Set(auth.InternalTokenHeader, token)
+			}
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+			if rr.Code != http.StatusUnauthorized {
+				t.Errorf("%s %s with token %q: expected status 401, got %d", tc.method, tc.path, token, rr.Code)
+			}
+		}
+	}
+}
+
+// TestHandleChangeMembership checks the tier and grant flag reach the service, and its errors become the right statuses.
+func TestHandleChangeMembership(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	mockBilling := NewMockBillingClient(ctrl)
+	cfg := DefaultServiceConfig()
+	cfg.TierGrants = map[string]int{"premium": 10}
+	r := chi.NewRouter()
+	NewHandler(NewService(mockRepo, nil, mockBilling, cfg), testInternalToken).RegisterRoutes(r)
+
+	userID, missingID := uuid.New(), uuid.New()
+	mockRepo.EXPECT().GetUserByID(gomock.Any(), userID).Return(&domain.User{UserID: userID, MembershipTier: "free"}, nil)
+	mockBilling.EXPECT().GrantTokens(gomock.Any(), userID, 10, gomock.Any()).Return(nil)
+	mockRepo.EXPECT().UpdateMembershipTier(gomock.Any(), userID, "premium").Return(&domain.User{UserID: userID, MembershipTier: "premium"}, nil)
+	mockRepo.EXPECT().GetUserByID(gomock.Any(), missingID).Return(nil, ErrNotFound)
+
+	send := func(id, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, newInternalRequest("PATCH", "/users/internal/"+id+"/membership", strings.NewReader(body)))
+		return rr
+	}
+
+	rr := send(userID.String(), `{"membership_tier": "premium", "grant_tokens": true}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	var resp struct {
+		User          domain.User `json:"user"`
+		PreviousTier  string      `json:"previous_tier"`
+		GrantedTokens int         `json:"granted_tokens"`
+	}
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.User.MembershipTier != "premium" || resp.PreviousTier != "free" || resp.GrantedTokens != 10 {
+		t.Errorf("Unexpected response %+v", resp)
+	}
+
+	tests := []struct {
+		id, body string
+		want     int
+	}{
+		{missingID.String(), `{"membership_tier": "premium"}`, http.StatusNotFound},
+		{userID.String(), `{"membership_tier": "gold"}`, http.StatusBadRequest},
+		{userID.String(), `{}`, http.StatusBadRequest},
+		{"nope", `{"membership_tier": "premium"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if rr := send(tt.id, tt.body); rr.Code != tt.want {
+			t.Errorf("%s %s: expected status %d, got %d", tt.id, tt.body, tt.want, rr.Code)
+		}
+	}
+}
@@ -6,7 +6,10 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"project-sage/internal/database"
 	"project-sage/internal/domain" // Shared domain models
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -14,82 +17,206 @@ import (
 // Repository is the interface for all user related database operations.
 // It defines the contract for the data layer
 type Repository interface {
-	// CreateUser inserts a new user record.
+	// CreateUser inserts a new user record. Returns ErrUserAlreadyExists if the Firebase id is already registered.
 	CreateUser(ctx context.Context, user *domain.User) error
+	// GetOrCreateUser inserts the user unless one with the same firebase_auth_id exists, in which case
+	// user is overwritten with the existing row. created reports which happened.
+	GetOrCreateUser(ctx context.Context, user *domain.User) (created bool, err error)
 	// GetUserByFirebaseID finds a user by their unique auth ID.
 	GetUserByFirebaseID(ctx context.Context, firebaseID string) (*domain.User, error)
 	// GetUserByID finds a user by their primary key (UUID).
 	GetUserByID(ctx context.Context, userID uuid.UUID) (*domain.User, error)
+	// GetUsersByIDs finds several users at once, keyed by UserID. Ids with no user, or a deleted one, are left out of the map.
+	GetUsersByIDs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*domain.User, error)
+	// UpdateProfile writes the fields of update that are set, leaving the rest of the row as it is, but only if
+	// update.Version is still current. It returns the updated user.
+	UpdateProfile(ctx context.Context, userID uuid.UUID, update ProfileUpdate) (*domain.User, error)
+	// SetStripeCustomerID stores the user's Stripe customer id unless they already have one,
+	// and returns whichever id is stored afterwards.
+	SetStripeCustomerID(ctx context.Context, userID uuid.UUID, customerID string) (string, error)
+	// UpdateMembershipTier sets the user's tier and returns the updated user. Returns ErrNotFound if there's no such user.
+	UpdateMembershipTier(ctx context.Context, userID uuid.UUID, tier string) (*domain.User, error)
+	// UpdateRole changes the user's role and records actorID as the one who changed it. Returns ErrNotFound if
+	// there's no such user, and ErrLastSuperadmin if they're the only superadmin and role isn't superadmin.
+	UpdateRole(ctx context.Context, userID uuid.UUID, role string, actorID uuid.UUID) (*domain.User, error)
+	// DeleteUser anonymizes the user's row, moves it to membership tier tier and marks it deleted, after which every
+	// other method here treats the user as not found. Returns ErrNotFound if there's no such user or they were
+	// already deleted.
+	DeleteUser(ctx context.Context, userID uuid.UUID, tier string) error
+	// SearchUsers returns one page of the users whose display name contains query, ignoring case, ordered by
+	// display name, and how many match in all.
+	SearchUsers(ctx context.Context, query string, limit, offset int) (users []*UserSummary, total int, err error)
+}
+
+// UserSummary is the part of a user support staff see in search results.
+type UserSummary struct {
+	UserID                 uuid.UUID `json:"user_id"`
+	DisplayName            string    `json:"display_name"`
+	MembershipTier         string    `json:"membership_tier"`
+	AssistanceTokenBalance int       `json:"assistance_token_balance"`
+	Role                   string    `json:"role"`
+}
+
+// RepositoryOptions are the optional settings shared by the user and expert repositories.
+// The zero value has no query timeout.
+type RepositoryOptions struct {
+	QueryTimeout time.Duration // How long each repository call gets before it fails with context.DeadlineExceeded. 0 means no limit
 }
 
 // postgresRepository is the concrete implementation of the Repository that uses a Postgres database
 type postgresRepository struct {
-	db *sql.DB // The database connection pool.
+	db           *sql.DB       // The database connection pool.
+	queryTimeout time.Duration // 0 means no limit
 }
 
-// NewPostgresRepository is the constructor for the repository.
+// NewPostgresRepository is the constructor for the repository. Each call gets database.DefaultQueryTimeout.
 func NewPostgresRepository(db *sql.DB) Repository {
+	return NewPostgresRepositoryWithOptions(db, RepositoryOptions{QueryTimeout: database.DefaultQueryTimeout})
+}
+
+// NewPostgresRepositoryWithOptions is the same as NewPostgresRepository, with the query timeout from opts.
+func NewPostgresRepositoryWithOptions(db *sql.DB, opts RepositoryOptions) Repository {
 	return &postgresRepository{
-		db: db,
+		db:           db,
+		queryTimeout: max(opts.QueryTimeout, 0),
+	}
+}
+
+// userColumns is the column list every full user query selects or returns. It must match scanUser.
+const userColumns = `
+	user_id, firebase_auth_id, display_name, profile_image_url,
+	membership_tier, assistance_token_balance, granted_token_balance, role, version,
+	COALESCE(stripe_customer_id, ''), created_at, updated_at
+`
+
+// scanUser scans one row selected with userColumns. The purchased balance isn't a column;
+// it's whatever of the balance wasn't granted.
+func scanUser(row interface{ Scan(...any) error }) (*domain.User, error) {
+	var user domain.User
+	err := row.Scan(
+		&user.UserID,
+		&user.FirebaseAuthID,
+		&user.DisplayName,
+		&user.ProfileImageURL,
+		&user.MembershipTier,
+		&user.AssistanceTokenBalance,
+		&user.GrantedTokenBalance,
+		&user.Role,
+		&user.Version,
+		&user.StripeCustomerID,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
 	}
+	user.PurchasedTokenBalance = user.AssistanceTokenBalance - user.GrantedTokenBalance
+	return &user, nil
 }
 
 // CreateUser inserts a new row into the users table
 func (pr *postgresRepository) CreateUser(ctx context.Context, user *domain.User) error {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
 	// Generate a new uuid for the users primary key.
 	user.UserID = uuid.New()
 
 	query := `
 		INSERT INTO users (user_id, firebase_auth_id, display_name, profile_image_url, 
-		                 membership_tier, assistance_token_balance, role)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		                 membership_tier, assistance_token_balance, granted_token_balance, role)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING created_at, updated_at
 	`
 
-	// Execute the query.
-	_, err := pr.db.ExecContext(ctx, query,
+	// Execute the query. The timestamps come from the column defaults.
+	err := pr.db.QueryRowContext(ctx, query,
 		user.UserID,
 		user.FirebaseAuthID,
 		user.DisplayName,
 		user.ProfileImageURL,
 		user.MembershipTier,
 		user.AssistanceTokenBalance,
+		user.GrantedTokenBalance,
 		user.Role,
-	)
+	).Scan(&user.CreatedAt, &user.UpdatedAt)
 
 	if err != nil {
+		// firebase_auth_id is unique, and user_id is new, so this can only be the same Firebase account again.
+		if database.IsUniqueViolation(err) {
+			return ErrUserAlreadyExists
+		}
 		return fmt.Errorf("could not insert user: %w", err)
 	}
 
+	// New rows start at the column default.
+	user.Version = 1
+	user.PurchasedTokenBalance = user.AssistanceTokenBalance - user.GrantedTokenBalance
+
 	return nil
 }
 
+// GetOrCreateUser is the race-free version of "get, and create if missing" used on login.
+// ON CONFLICT DO NOTHING means two first logins at once can't both insert; the loser gets no row back
+// and reads the winner's row instead. The read is a separate statement so it sees the other insert once committed.
+func (pr *postgresRepository) GetOrCreateUser(ctx context.Context, user *domain.User) (bool, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	user.UserID = uuid.New()
+
+	query := `
+		INSERT INTO users (user_id, firebase_auth_id, display_name, profile_image_url,
+		                 membership_tier, assistance_token_balance, granted_token_balance, role)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (firebase_auth_id) DO NOTHING
+		RETURNING version, created_at, updated_at
+	`
+
+	err := pr.db.QueryRowContext(ctx, query,
+		user.UserID,
+		user.FirebaseAuthID,
+		user.DisplayName,
+		user.ProfileImageURL,
+		user.MembershipTier,
+		user.AssistanceTokenBalance,
+		user.GrantedTokenBalance,
+		user.Role,
+	).Scan(&user.Version, &user.CreatedAt, &user.UpdatedAt)
+	if err == nil {
+		user.PurchasedTokenBalance = user.AssistanceTokenBalance - user.GrantedTokenBalance
+		return true, nil
+	}
+	if err != sql.ErrNoRows {
+		return false, fmt.Errorf("could not insert user: %w", err)
+	}
+
+	// Already there, so hand back the existing user.
+	existing, err := pr.GetUserByFirebaseID(ctx, user.FirebaseAuthID)
+	if err != nil {
+		return false, err
+	}
+	*user = *existing
+	return false, nil
+}
+
 // GetUserByFirebaseID retrieves a single user based on their Firebase ID.
 func (pr *postgresRepository) GetUserByFirebaseID(ctx context.Context, firebaseID string) (*domain.User, error) {
-	user := &domain.User{}
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
 
 	query := `
-		SELECT user_id, firebase_auth_id, display_name, profile_image_url, 
-		       membership_tier, assistance_token_balance, role
+		SELECT ` + userColumns + `
 		FROM users
-		WHERE firebase_auth_id = $1
+		WHERE firebase_auth_id = $1 AND deleted_at IS NULL
 	`
 
 	// Use QueryRowContext as I'm expecting only one user
-	// Scan the results directly into the user struct
-	err := pr.db.QueryRowContext(ctx, query, firebaseID).Scan(
-		&user.UserID,
-		&user.FirebaseAuthID,
-		&user.DisplayName,
-		&user.ProfileImageURL,
-		&user.MembershipTier,
-		&user.AssistanceTokenBalance,
-		&user.Role,
-	)
-
+	user, err := scanUser(pr.db.QueryRowContext(ctx, query, firebaseID))
 	if err != nil {
 		// This is the standard error for "not found".
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("user not found")
+			return nil, ErrNotFound
 		}
 		// Some other database error occurred.
 		return nil, fmt.Errorf("could not get user: %w", err)
@@ -100,30 +227,306 @@ func (pr *postgresRepository) GetUserByFirebaseID(ctx context.Context, firebaseI
 
 // [GetUserByID retrieves a single user based on their internal UUID.
 func (pr *postgresRepository) GetUserByID(ctx context.Context, userID uuid.UUID) (*domain.User, error) {
-	user := &domain.User{}
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
 
 	query := `
-		SELECT user_id, firebase_auth_id, display_name, profile_image_url, 
-		       membership_tier, assistance_token_balance, role
+		SELECT ` + userColumns + `
 		FROM users
-		WHERE user_id = $1
+		WHERE user_id = $1 AND deleted_at IS NULL
 	`
-	err := pr.db.QueryRowContext(ctx, query, userID).Scan(
-		&user.UserID,
-		&user.FirebaseAuthID,
-		&user.DisplayName,
-		&user.ProfileImageURL,
-		&user.MembershipTier,
-		&user.AssistanceTokenBalance,
-		&user.Role,
-	)
-
+	user, err := scanUser(pr.db.QueryRowContext(ctx, query, userID))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("user not found")
+			return nil, ErrNotFound
 		}
 		return nil, fmt.Errorf("could not get user: %w", err)
 	}
 
 	return user, nil
 }
+
+// GetUsersByIDs reads all the users in one query.
+func (pr *postgresRepository) GetUsersByIDs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*domain.User, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	// Pass the ids as a text array and cast it in the query so we don't rely on the driver knowing about uuid slices.
+	idStrings := make([]string, len(ids))
+	for i, id := range ids {
+		idStrings[i] = id.String()
+	}
+
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE user_id = ANY($1::uuid[]) AND deleted_at IS NULL
+	`
+	rows, err := pr.db.QueryContext(ctx, query, idStrings)
+	if err != nil {
+		return nil, fmt.Errorf("could not get users: %w", err)
+	}
+	defer rows.Close()
+
+	users := make(map[uuid.UUID]*domain.User, len(ids))
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan user: %w", err)
+		}
+		users[user.UserID] = user
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not get users: %w", err)
+	}
+	return users, nil
+}
+
+// UpdateProfile writes display_name and profile_image_url using an optimistic version check.
+// A nil field goes in as NULL and COALESCE keeps the column, so a client that only sends its name can't wipe
+// the image with whatever it last read.
+func (pr *postgresRepository) UpdateProfile(ctx context.Context, userID uuid.UUID, update ProfileUpdate) (*domain.User, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	// The version in the where clause is what makes this safe. If someone else wrote in between, no row matches.
+	query := `
+		UPDATE users
+		SET display_name = COALESCE($1, display_name),
+		    profile_image_url = COALESCE($2, profile_image_url),
+		    version = version + 1,
+		    updated_at = now()
+		WHERE user_id = $3 AND version = $4 AND deleted_at IS NULL
+		RETURNING ` + userColumns + `
+	`
+
+	user, err := scanUser(pr.db.QueryRowContext(ctx, query,
+		update.DisplayName,
+		update.ProfileImageURL,
+		userID,
+		update.Version,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			// Either the user is gone or the version is stale. Check which so the caller gets the right error.
+			var exists bool
+			if err := pr.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE user_id = $1 AND deleted_at IS NULL)", userID).Scan(&exists); err != nil {
+				return nil, fmt.Errorf("could not check user: %w", err)
+			}
+			if !exists {
+				return nil, ErrNotFound
+			}
+			return nil, ErrVersionConflict
+		}
+		return nil, fmt.Errorf("could not update user: %w", err)
+	}
+
+	return user, nil
+}
+
+// SetStripeCustomerID only writes the id if the column is still empty. Two first purchases at once can each create a
+// Stripe customer, and this way they both end up using the one that got here first.
+func (pr *postgresRepository) SetStripeCustomerID(ctx context.Context, userID uuid.UUID, customerID string) (string, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET stripe_customer_id = $2, updated_at = now()
+		WHERE user_id = $1 AND COALESCE(stripe_customer_id, '') = '' AND deleted_at IS NULL
+		RETURNING stripe_customer_id
+	`
+
+	var stored string
+	err := pr.db.QueryRowContext(ctx, query, userID, customerID).Scan(&stored)
+	if err == nil {
+		return stored, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", fmt.Errorf("could not set stripe customer id: %w", err)
+	}
+
+	// No row updated: either there's no such user or they already have a customer.
+	err = pr.db.QueryRowContext(ctx, "SELECT stripe_customer_id FROM users WHERE user_id = $1 AND deleted_at IS NULL", userID).Scan(&stored)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("could not get stripe customer id: %w", err)
+	}
+	return stored, nil
+}
+
+// UpdateMembershipTier only touches the tier. Like a role change it leaves version alone, since that's for profile edits.
+func (pr *postgresRepository) UpdateMembershipTier(ctx context.Context, userID uuid.UUID, tier string) (*domain.User, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET membership_tier = $2, updated_at = CASE WHEN membership_tier = $2 THEN updated_at ELSE now() END
+		WHERE user_id = $1 AND deleted_at IS NULL
+		RETURNING ` + userColumns + `
+	`
+	user, err := scanUser(pr.db.QueryRowContext(ctx, query, userID, tier))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("could not update membership tier: %w", err)
+	}
+
+	return user, nil
+}
+
+// UpdateRole writes the role and the audit row in one transaction. Every superadmin row is locked before counting
+// them, so two superadmins demoting each other at once can't both get through and leave nobody.
+// Setting the role a user already has changes nothing and isn't recorded.
+func (pr *postgresRepository) UpdateRole(ctx context.Context, userID uuid.UUID, role string, actorID uuid.UUID) (*domain.User, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	tx, err := pr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not begin role transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op once committed.
+
+	rows, err := tx.QueryContext(ctx, "SELECT user_id FROM users WHERE role = 'superadmin' AND deleted_at IS NULL FOR UPDATE")
+	if err != nil {
+		return nil, fmt.Errorf("could not lock superadmins: %w", err)
+	}
+	superadmins := 0
+	for rows.Next() {
+		superadmins++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not lock superadmins: %w", err)
+	}
+
+	var current string
+	err = tx.QueryRowContext(ctx, "SELECT role FROM users WHERE user_id = $1 AND deleted_at IS NULL FOR UPDATE", userID).Scan(&current)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("could not get role: %w", err)
+	}
+	if current == "superadmin" && role != "superadmin" && superadmins <= 1 {
+		return nil, ErrLastSuperadmin
+	}
+
+	// The version is for profile edits, so a role change doesn't bump it and fail the user's next edit.
+	query := `
+		UPDATE users
+		SET role = $2, updated_at = CASE WHEN role = $2 THEN updated_at ELSE now() END
+		WHERE user_id = $1
+		RETURNING ` + userColumns + `
+	`
+	user, err := scanUser(tx.QueryRowContext(ctx, query, userID, role))
+	if err != nil {
+		return nil, fmt.Errorf("could not update role: %w", err)
+	}
+
+	if current != role {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO user_role_events (event_id, user_id, from_role, to_role, actor_id)
+			VALUES ($1, $2, $3, $4, $5)
+		`, uuid.New(), userID, current, role, actorID)
+		if err != nil {
+			return nil, fmt.Errorf("could not record role change: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit role change: %w", err)
+	}
+
+	return user, nil
+}
+
+// Tombstone values a deleted user's row is left with.
+const (
+	deletedDisplayName    = "Deleted user"
+	deletedFirebasePrefix = "deleted:" // Followed by the user id, since firebase_auth_id is unique
+)
+
+// DeleteUser overwrites everything that identifies the user rather than deleting the row, which their requests,
+// conversations and token ledger all reference. The firebase id goes too, so signing in again with the same
+// Firebase account registers a new user. The tier goes back to tier, the starting one, so a paid tier doesn't keep
+// a monthly grant coming. The balance columns are BillingService's, so they're left to it.
+func (pr *postgresRepository) DeleteUser(ctx context.Context, userID uuid.UUID, tier string) error {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET display_name = $2,
+		    profile_image_url = '',
+		    firebase_auth_id = $3::text || user_id::text,
+		    stripe_customer_id = NULL,
+		    membership_tier = $4,
+		    deleted_at = now(),
+		    updated_at = now(),
+		    version = version + 1
+		WHERE user_id = $1 AND deleted_at IS NULL
+	`
+	res, err := pr.db.ExecContext(ctx, query, userID, deletedDisplayName, deletedFirebasePrefix, tier)
+	if err != nil {
+		return fmt.Errorf("could not delete user: %w", err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not check rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// likeEscaper escapes the characters LIKE treats specially, so a search for "50%" matches just that.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// SearchUsers matches with ILIKE, which the trigram index from migrations/0023_index_users_display_name_trgm.sql
+// serves without scanning the table. Deleted users are left out. user_id breaks ties between equal names,
+// so a user can't show up on two pages.
+func (pr *postgresRepository) SearchUsers(ctx context.Context, query string, limit, offset int) ([]*UserSummary, int, error) {
+	ctx, cancel := database.WithQueryTimeout(ctx, pr.queryTimeout)
+	defer cancel()
+
+	pattern := "%" + likeEscaper.Replace(query) + "%"
+
+	var total int
+	err := pr.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM users WHERE display_name ILIKE $1 AND deleted_at IS NULL", pattern).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not count users: %w", err)
+	}
+
+	rows, err := pr.db.QueryContext(ctx, `
+		SELECT user_id, display_name, membership_tier, assistance_token_balance, role
+		FROM users
+		WHERE display_name ILIKE $1 AND deleted_at IS NULL
+		ORDER BY display_name, user_id
+		LIMIT $2 OFFSET $3
+	`, pattern, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not search users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []*UserSummary{}
+	for rows.Next() {
+		u := &UserSummary{}
+		if err := rows.Scan(&u.UserID, &u.DisplayName, &u.MembershipTier, &u.AssistanceTokenBalance, &u.Role); err != nil {
+			return nil, 0, fmt.Errorf("could not scan user: %w", err)
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("could not search users: %w", err)
+	}
+	return users, total, nil
+}
@@ -56,6 +56,35 @@ func (mr *MockRepositoryMockRecorder) CreateUser(ctx, user any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateUser", reflect.TypeOf((*MockRepository)(nil).CreateUser), ctx, user)
 }
 
+// DeleteUser mocks base method.
+func (m *MockRepository) DeleteUser(ctx context.Context, userID uuid.UUID, tier string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteUser", ctx, userID, tier)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteUser indicates an expected call of DeleteUser.
+func (mr *MockRepositoryMockRecorder) DeleteUser(ctx, userID, tier any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUser", reflect.TypeOf((*MockRepository)(nil).DeleteUser), ctx, userID, tier)
+}
+
+// GetOrCreateUser mocks base method.
+func (m *MockRepository) GetOrCreateUser(ctx context.Context, user *domain.User) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOrCreateUser", ctx, user)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOrCreateUser indicates an expected call of GetOrCreateUser.
+func (mr *MockRepositoryMockRecorder) GetOrCreateUser(ctx, user any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrCreateUser", reflect.TypeOf((*MockRepository)(nil).GetOrCreateUser), ctx, user)
+}
+
 // GetUserByFirebaseID mocks base method.
 func (m *MockRepository) GetUserByFirebaseID(ctx context.Context, firebaseID string) (*domain.User, error) {
 	m.ctrl.T.Helper()
@@ -85,3 +114,94 @@ func (mr *MockRepositoryMockRecorder) GetUserByID(ctx, userID any) *gomock.Call
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserByID", reflect.TypeOf((*MockRepository)(nil).GetUserByID), ctx, userID)
 }
+
+// GetUsersByIDs mocks base method.
+func (m *MockRepository) GetUsersByIDs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*domain.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUsersByIDs", ctx, ids)
+	ret0, _ := ret[0].(map[uuid.UUID]*domain.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUsersByIDs indicates an expected call of GetUsersByIDs.
+func (mr *MockRepositoryMockRecorder) GetUsersByIDs(ctx, ids any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUsersByIDs", reflect.TypeOf((*MockRepository)(nil).GetUsersByIDs), ctx, ids)
+}
+
+// SearchUsers mocks base method.
+func (m *MockRepository) SearchUsers(ctx context.Context, query string, limit, offset int) ([]*UserSummary, int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SearchUsers", ctx, query, limit, offset)
+	ret0, _ := ret[0].([]*UserSummary)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// SearchUsers indicates an expected call of SearchUsers.
+func (mr *MockRepositoryMockRecorder) SearchUsers(ctx, query, limit, offset any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchUsers", reflect.TypeOf((*MockRepository)(nil).SearchUsers), ctx, query, limit, offset)
+}
+
+// SetStripeCustomerID mocks base method.
+func (m *MockRepository) SetStripeCustomerID(ctx context.Context, userID uuid.UUID, customerID string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetStripeCustomerID", ctx, userID, customerID)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetStripeCustomerID indicates an expected call of SetStripeCustomerID.
+func (mr *MockRepositoryMockRecorder) SetStripeCustomerID(ctx, userID, customerID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetStripeCustomerID", reflect.TypeOf((*MockRepository)(nil).SetStripeCustomerID), ctx, userID, customerID)
+}
+
+// UpdateMembershipTier mocks base method.
+func (m *MockRepository) UpdateMembershipTier(ctx context.Context, userID uuid.UUID, tier string) (*domain.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateMembershipTier", ctx, userID, tier)
+	ret0, _ := ret[0].(*domain.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateMembershipTier indicates an expected call of UpdateMembershipTier.
+func (mr *MockRepositoryMockRecorder) UpdateMembershipTier(ctx, userID, tier any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateMembershipTier", reflect.TypeOf((*MockRepository)(nil).UpdateMembershipTier), ctx, userID, tier)
+}
+
+// UpdateProfile mocks base method.
+func (m *MockRepository) UpdateProfile(ctx context.Context, userID uuid.UUID, update ProfileUpdate) (*domain.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateProfile", ctx, userID, update)
+	ret0, _ := ret[0].(*domain.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateProfile indicates an expected call of UpdateProfile.
+func (mr *MockRepositoryMockRecorder) UpdateProfile(ctx, userID, update any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateProfile", reflect.TypeOf((*MockRepository)(nil).UpdateProfile), ctx, userID, update)
+}
+
+// UpdateRole mocks base method.
+func (m *MockRepository) UpdateRole(ctx context.Context, userID uuid.UUID, role string, actorID uuid.UUID) (*domain.User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateRole", ctx, userID, role, actorID)
+	ret0, _ := ret[0].(*domain.User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateRole indicates an expected call of UpdateRole.
+func (mr *MockRepositoryMockRecorder) UpdateRole(ctx, userID, role, actorID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateRole", reflect.TypeOf((*MockRepository)(nil).UpdateRole), ctx, userID, role, actorID)
+}
@@ -3,10 +3,15 @@ package user
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"project-sage/internal/database"
 	"project-sage/internal/domain" // Shared domain models
+	"project-sage/internal/request"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -69,6 +74,7 @@ func TestCreateAndGetUserByFirebaseID(t *testing.T) {
 		ProfileImageURL:        "http://example.com/img.png",
 		MembershipTier:         "premium",
 		AssistanceTokenBalance: 5,
+		GrantedTokenBalance:    3,
 		Role:                   "user",
 	}
 	ctx := context.Background()
@@ -99,9 +105,44 @@ func TestCreateAndGetUserByFirebaseID(t *testing.T) {
 	if fetchedUser.AssistanceTokenBalance != 5 {
 		t.Errorf("Fetched user token balance mismatch: expected 5, got %d", fetchedUser.AssistanceTokenBalance)
 	}
+	if fetchedUser.GrantedTokenBalance != 3 || fetchedUser.PurchasedTokenBalance != 2 {
+		t.Errorf("Expected 3 granted and 2 purchased tokens, got %d and %d", fetchedUser.GrantedTokenBalance, fetchedUser.PurchasedTokenBalance)
+	}
 	if fetchedUser.Role != "user" {
 		t.Errorf("Fetched user role mismatch: expected 'user', got '%s'", fetchedUser.Role)
 	}
+	// The timestamps come from the database, on the created user and the one read back alike.
+	if newUser.CreatedAt.IsZero() || newUser.UpdatedAt.IsZero() {
+		t.Errorf("CreateUser() did not set the timestamps: created %v, updated %v", newUser.CreatedAt, newUser.UpdatedAt)
+	}
+	if !fetchedUser.CreatedAt.Equal(newUser.CreatedAt) || !fetchedUser.UpdatedAt.Equal(newUser.UpdatedAt) {
+		t.Errorf("Expected timestamps %v and %v, got %v and %v", newUser.CreatedAt, newUser.UpdatedAt, fetchedUser.CreatedAt, fetchedUser.UpdatedAt)
+	}
+}
+
+// TestCreateUser_Duplicate verifies a second user with the same FirebaseID is ErrUserAlreadyExists,
+// and the first one is left as it was.
+func TestCreateUser_Duplicate(t *testing.T) {
+	cleanUserTable()
+	ctx := context.Background()
+
+	first := &domain.User{FirebaseAuthID: "fb-test-dup", DisplayName: "First", Role: "user"}
+	if err := testRepo.CreateUser(ctx, first); err != nil {
+		t.Fatalf("CreateUser() failed: %v", err)
+	}
+
+	second := &domain.User{FirebaseAuthID: "fb-test-dup", DisplayName: "Second", Role: "user"}
+	if err := testRepo.CreateUser(ctx, second); !errors.Is(err, ErrUserAlreadyExists) {
+		t.Fatalf("Expected ErrUserAlreadyExists, got %v", err)
+	}
+
+	fetched, err := testRepo.GetUserByFirebaseID(ctx, "fb-test-dup")
+	if err != nil {
+		t.Fatalf("Failed to fetch user back: %v", err)
+	}
+	if fetched.UserID != first.UserID || fetched.DisplayName != "First" {
+		t.Errorf("Expected the first user untouched, got %+v", fetched)
+	}
 }
 
 // TestGetUserByID_Success verifies fetching by the primary key UUID.
@@ -142,6 +183,38 @@ func TestGetUserByID_Success(t *testing.T) {
 	}
 }
 
+// TestGetUsersByIDs fetches three users in one call, and checks an unknown id is just left out.
+func TestGetUsersByIDs(t *testing.T) {
+	cleanUserTable()
+	ctx := context.Background()
+
+	var ids []uuid.UUID
+	for i, name := range []string{"Batch One", "Batch Two", "Batch Three"} {
+		u := &domain.User{FirebaseAuthID: fmt.Sprintf("fb-test-batch-%d", i), DisplayName: name, AssistanceTokenBalance: 5, GrantedTokenBalance: i, Role: "user"}
+		if err := testRepo.CreateUser(ctx, u); err != nil {
+			t.Fatalf("CreateUser() failed: %v", err)
+		}
+		ids = append(ids, u.UserID)
+	}
+
+	users, err := testRepo.GetUsersByIDs(ctx, append(ids, uuid.New()))
+	if err != nil {
+		t.Fatalf("GetUsersByIDs() returned error: %v", err)
+	}
+	if len(users) != 3 {
+		t.Fatalf("Expected 3 users, got %d", len(users))
+	}
+	for i, id := range ids {
+		u, ok := users[id]
+		if !ok {
+			t.Fatalf("User %s is missing", id)
+		}
+		if u.UserID != id || u.GrantedTokenBalance != i || u.PurchasedTokenBalance != 5-i || u.CreatedAt.IsZero() {
+			t.Errorf("Unexpected user %+v", u)
+		}
+	}
+}
+
 // TestGetUserByFirebaseID_NotFound verifies that the correct "not found" error is returned for a non-existent user.
 func TestGetUserByFirebaseID_NotFound(t *testing.T) {
 	cleanUserTable()
@@ -156,7 +229,7 @@ func TestGetUserByFirebaseID_NotFound(t *testing.T) {
 	}
 
 	// Check for the specific error string from the repository.
-	if err.Error() != "user not found" {
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("Expected 'user not found' error, got: %v", err)
 	}
 }
@@ -173,7 +246,452 @@ func TestGetUserByID_NotFound(t *testing.T) {
 	if err == nil {
 		t.Fatal("Expected an error for a non-existent user, but got nil")
 	}
-	if err.Error() != "user not found" {
+	if !errors.Is(err, ErrNotFound) {
 		t.Errorf("Expected 'user not found' error, got: %v", err)
 	}
 }
+
+// TestUpdateProfile_StaleVersion simulates two clients editing the same profile.
+// The second write is based on an old version and must be rejected.
+func TestUpdateProfile_StaleVersion(t *testing.T) {
+	cleanUserTable()
+	ctx := context.Background()
+
+	newUser := &domain.User{
+		FirebaseAuthID: "fb-test-version",
+		DisplayName:    "Original Name",
+		Role:           "user",
+	}
+	if err := testRepo.CreateUser(ctx, newUser); err != nil {
+		t.Fatalf("CreateUser() failed: %v", err)
+	}
+
+	// Both clients read the profile at the same version.
+	first, err := testRepo.GetUserByID(ctx, newUser.UserID)
+	if err != nil {
+		t.Fatalf("GetUserByID() failed: %v", err)
+	}
+
+	// The first client saves, which bumps the version.
+	firstEdit, secondEdit := "First Edit", "Second Edit"
+	updated, err := testRepo.UpdateProfile(ctx, first.UserID, ProfileUpdate{DisplayName: &firstEdit, Version: first.Version})
+	if err != nil {
+		t.Fatalf("First UpdateProfile() returned error: %v", err)
+	}
+	if updated.Version != first.Version+1 {
+		t.Errorf("Expected version %d after update, got %d", first.Version+1, updated.Version)
+	}
+
+	// The second client still has the old version.
+	_, err = testRepo.UpdateProfile(ctx, first.UserID, ProfileUpdate{DisplayName: &secondEdit, Version: first.Version})
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("Expected ErrVersionConflict for a stale update, got: %v", err)
+	}
+
+	// The first edit must have survived.
+	fetched, err := testRepo.GetUserByID(ctx, newUser.UserID)
+	if err != nil {
+		t.Fatalf("GetUserByID() failed: %v", err)
+	}
+	if fetched.DisplayName != "First Edit" {
+		t.Errorf("Expected display name 'First Edit', got '%s'", fetched.DisplayName)
+	}
+}
+
+// TestUpdateProfile_PartialUpdate checks only the sent field is written, and every other column keeps its value
+// apart from version and updated_at, which move forward while created_at stays put.
+func TestUpdateProfile_PartialUpdate(t *testing.T) {
+	cleanUserTable()
+	ctx := context.Background()
+
+	newUser := &domain.User{
+		FirebaseAuthID:         "fb-test-partial",
+		DisplayName:            "Original Name",
+		ProfileImageURL:        "https://example.com/original.png",
+		MembershipTier:         "premium",
+		AssistanceTokenBalance: 7,
+		Role:                   "user",
+	}
+	if err := testRepo.CreateUser(ctx, newUser); err != nil {
+		t.Fatalf("CreateUser() failed: %v", err)
+	}
+	before, err := testRepo.GetUserByID(ctx, newUser.UserID)
+	if err != nil {
+		t.Fatalf("GetUserByID() failed: %v", err)
+	}
+
+	name := "New Name"
+	if _, err := testRepo.UpdateProfile(ctx, newUser.UserID, ProfileUpdate{DisplayName: &name, Version: before.Version}); err != nil {
+		t.Fatalf("UpdateProfile() returned error: %v", err)
+	}
+
+	after, err := testRepo.GetUserByID(ctx, newUser.UserID)
+	if err != nil {
+		t.Fatalf("GetUserByID() failed: %v", err)
+	}
+	if !after.UpdatedAt.After(before.UpdatedAt) {
+		t.Errorf("Expected updated_at to move past %s, got %s", before.UpdatedAt, after.UpdatedAt)
+	}
+	if !after.CreatedAt.Equal(before.CreatedAt) {
+		t.Errorf("Expected created_at to stay %s, got %s", before.CreatedAt, after.CreatedAt)
+	}
+	want := *before
+	want.DisplayName = name
+	want.Version = before.Version + 1
+	want.CreatedAt, want.UpdatedAt = after.CreatedAt, after.UpdatedAt
+	if *after != want {
+		t.Errorf("Expected only the name, version and updated_at to change:\nwant %+v\ngot  %+v", want, *after)
+	}
+
+	// An empty image is sent, so it's written, and removes the image.
+	empty := ""
+	updated, err := testRepo.UpdateProfile(ctx, newUser.UserID, ProfileUpdate{ProfileImageURL: &empty, Version: after.Version})
+	if err != nil {
+		t.Fatalf("UpdateProfile() returned error: %v", err)
+	}
+	if updated.ProfileImageURL != "" || updated.DisplayName != name {
+		t.Errorf("Expected the image cleared and the name kept, got %+v", updated)
+	}
+
+	if _, err := testRepo.UpdateProfile(ctx, uuid.New(), ProfileUpdate{DisplayName: &name, Version: 1}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing user, got: %v", err)
+	}
+}
+
+// TestGetOrCreateUser_Twice verifies a second login returns the same user instead of creating another.
+func TestGetOrCreateUser_Twice(t *testing.T) {
+	cleanUserTable()
+	ctx := context.Background()
+
+	first := &domain.User{FirebaseAuthID: "fb-test-login", DisplayName: "First", MembershipTier: "free", AssistanceTokenBalance: 3, Role: "user"}
+	created, err := testRepo.GetOrCreateUser(ctx, first)
+	if err != nil {
+		t.Fatalf("GetOrCreateUser() returned error: %v", err)
+	}
+	if !created {
+		t.Error("Expected the first call to create the user")
+	}
+
+	second := &domain.User{FirebaseAuthID: "fb-test-login", DisplayName: "Second", MembershipTier: "free", AssistanceTokenBalance: 3, Role: "user"}
+	created, err = testRepo.GetOrCreateUser(ctx, second)
+	if err != nil {
+		t.Fatalf("GetOrCreateUser() returned error: %v", err)
+	}
+	if created {
+		t.Error("Expected the second call to find the existing user")
+	}
+	if second.UserID != first.UserID {
+		t.Errorf("Expected the same UserID both times, got %s and %s", first.UserID, second.UserID)
+	}
+	if second.DisplayName != "First" {
+		t.Errorf("Expected the stored display name 'First', got '%s'", second.DisplayName)
+	}
+}
+
+// TestSetStripeCustomerID checks the first customer id sticks, and a later one gets the stored id back instead.
+func TestSetStripeCustomerID(t *testing.T) {
+	cleanUserTable()
+	ctx := context.Background()
+
+	newUser := &domain.User{FirebaseAuthID: "fb-test-stripe", DisplayName: "Stripe User", Role: "user"}
+	if err := testRepo.CreateUser(ctx, newUser); err != nil {
+		t.Fatalf("CreateUser() failed: %v", err)
+	}
+
+	stored, err := testRepo.SetStripeCustomerID(ctx, newUser.UserID, "cus_first")
+	if err != nil {
+		t.Fatalf("SetStripeCustomerID() returned error: %v", err)
+	}
+	if stored != "cus_first" {
+		t.Errorf("Expected 'cus_first' to be stored, got '%s'", stored)
+	}
+
+	stored, err = testRepo.SetStripeCustomerID(ctx, newUser.UserID, "cus_second")
+	if err != nil {
+		t.Fatalf("Second SetStripeCustomerID() returned error: %v", err)
+	}
+	if stored != "cus_first" {
+		t.Errorf("Expected the first customer to be kept, got '%s'", stored)
+	}
+
+	fetched, err := testRepo.GetUserByID(ctx, newUser.UserID)
+	if err != nil {
+		t.Fatalf("GetUserByID() failed: %v", err)
+	}
+	if fetched.StripeCustomerID != "cus_first" {
+		t.Errorf("Expected GetUserByID to read 'cus_first', got '%s'", fetched.StripeCustomerID)
+	}
+
+	if _, err := testRepo.SetStripeCustomerID(ctx, uuid.New(), "cus_nobody"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an unknown user, got: %v", err)
+	}
+}
+
+// TestSearchUsers checks the search ignores case, matches LIKE wildcards literally, leaves out deleted users and pages by name.
+func TestSearchUsers(t *testing.T) {
+	cleanUserTable()
+	ctx := context.Background()
+
+	var deleted uuid.UUID
+	for i, name := range []string{"Test Search Carol", "test search alice", "Test Search Bob", "Test Search 100%", "Test Search Gone"} {
+		u := &domain.User{FirebaseAuthID: fmt.Sprintf("fb-test-search-%d", i), DisplayName: name, MembershipTier: "free", Role: "user"}
+		if err := testRepo.CreateUser(ctx, u); err != nil {
+			t.Fatalf("CreateUser() failed: %v", err)
+		}
+		deleted = u.UserID
+	}
+	// The last one is deleted, and no longer matches cleanUserTable.
+	if err := testRepo.DeleteUser(ctx, deleted, "free"); err != nil {
+		t.Fatalf("DeleteUser() failed: %v", err)
+	}
+	defer testDB.Exec("DELETE FROM users WHERE user_id = $1", deleted)
+
+	names := func(users []*UserSummary) []string {
+		var out []string
+		for _, u := range users {
+			out = append(out, u.DisplayName)
+		}
+		return out
+	}
+
+	users, total, err := testRepo.SearchUsers(ctx, "TEST SEARCH", 2, 0)
+	if err != nil {
+		t.Fatalf("SearchUsers() returned error: %v", err)
+	}
+	if total != 4 {
+		t.Errorf("Expected 4 matches, got %d", total)
+	}
+	// ORDER BY display_name follows the database collation, so only the two unambiguous names are checked.
+	if len(users) != 2 {
+		t.Fatalf("Expected a page of 2, got %v", names(users))
+	}
+	rest, _, err := testRepo.SearchUsers(ctx, "TEST SEARCH", 2, 2)
+	if err != nil {
+		t.Fatalf("SearchUsers() returned error: %v", err)
+	}
+	seen := map[string]bool{}
+	for _, name := range append(names(users), names(rest)...) {
+		if seen[name] {
+			t.Errorf("%q is on two pages", name)
+		}
+		seen[name] = true
+	}
+	if len(seen) != 4 || seen["Test Search Gone"] {
+		t.Errorf("Expected the 4 users that aren't deleted across the pages, got %v", seen)
+	}
+
+	users, total, err = testRepo.SearchUsers(ctx, "100%", 10, 0)
+	if err != nil {
+		t.Fatalf("SearchUsers() returned error: %v", err)
+	}
+	if total != 1 || len(users) != 1 || users[0].DisplayName != "Test Search 100%" || users[0].MembershipTier != "free" || users[0].Role != "user" {
+		t.Errorf("Expected just the literal 100%% match, got %v", names(users))
+	}
+}
+
+// TestUpdateMembershipTier checks the tier is written and nothing else changes, and a deleted user isn't found.
+func TestUpdateMembershipTier(t *testing.T) {
+	cleanUserTable()
+	ctx := context.Background()
+
+	newUser := &domain.User{FirebaseAuthID: "fb-test-tier", DisplayName: "Subscriber", MembershipTier: "free", AssistanceTokenBalance: 3, GrantedTokenBalance: 3, Role: "user"}
+	if err := testRepo.CreateUser(ctx, newUser); err != nil {
+		t.Fatalf("CreateUser() failed: %v", err)
+	}
+
+	updated, err := testRepo.UpdateMembershipTier(ctx, newUser.UserID, "premium")
+	if err != nil {
+		t.Fatalf("UpdateMembershipTier() returned error: %v", err)
+	}
+	if updated.MembershipTier != "premium" || updated.Version != newUser.Version || updated.AssistanceTokenBalance != 3 {
+		t.Errorf("Expected only the tier to change, got %+v", updated)
+	}
+	fetched, err := testRepo.GetUserByID(ctx, newUser.UserID)
+	if err != nil {
+		t.Fatalf("GetUserByID() failed: %v", err)
+	}
+	if fetched.MembershipTier != "premium" {
+		t.Errorf("Expected the stored tier to be premium, got %q", fetched.MembershipTier)
+	}
+
+	if _, err := testRepo.UpdateMembershipTier(ctx, uuid.New(), "premium"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an unknown user, got %v", err)
+	}
+}
+
+// TestUpdateRole checks a role change is written with its audit row, a repeat isn't recorded again, and the last
+// superadmin can't be demoted.
+func TestUpdateRole(t *testing.T) {
+	cleanUserTable()
+	ctx := context.Background()
+
+	first := &domain.User{FirebaseAuthID: "fb-test-role-1", DisplayName: "First Admin", Role: "user"}
+	second := &domain.User{FirebaseAuthID: "fb-test-role-2", DisplayName: "Second Admin", Role: "user"}
+	for _, u := range []*domain.User{first, second} {
+		if err := testRepo.CreateUser(ctx, u); err != nil {
+			t.Fatalf("CreateUser() failed: %v", err)
+		}
+	}
+	actor := uuid.New()
+
+	promoted, err := testRepo.UpdateRole(ctx, first.UserID, "superadmin", actor)
+	if err != nil {
+		t.Fatalf("UpdateRole() returned error: %v", err)
+	}
+	if promoted.Role != "superadmin" || promoted.Version != first.Version || !promoted.UpdatedAt.After(first.UpdatedAt) {
+		t.Errorf("Expected a superadmin with the same version and a later updated_at, got %+v", promoted)
+	}
+	if _, err := testRepo.UpdateRole(ctx, first.UserID, "superadmin", actor); err != nil {
+		t.Fatalf("UpdateRole() to the same role returned error: %v", err)
+	}
+	var fromRole, toRole string
+	var actorID uuid.UUID
+	var events int
+	err = testDB.QueryRow(`
+		SELECT from_role, to_role, actor_id, COUNT(*) OVER () FROM user_role_events WHERE user_id = $1
+	`, first.UserID).Scan(&fromRole, &toRole, &actorID, &events)
+	if err != nil {
+		t.Fatalf("Could not read the audit row: %v", err)
+	}
+	if fromRole != "user" || toRole != "superadmin" || actorID != actor || events != 1 {
+		t.Errorf("Expected one user -> superadmin event by %s, got %s -> %s by %s, %d events", actor, fromRole, toRole, actorID, events)
+	}
+
+	if _, err := testRepo.UpdateRole(ctx, uuid.New(), "user", actor); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an unknown user, got %v", err)
+	}
+
+	// The last superadmin rule can only be checked on a database with no superadmins of its own.
+	var others int
+	testDB.QueryRow("SELECT COUNT(*) FROM users WHERE role = 'superadmin' AND deleted_at IS NULL AND user_id <> $1", first.UserID).Scan(&others)
+	if others > 0 {
+		t.Logf("%d other superadmins in the database, not checking the last superadmin rule", others)
+		return
+	}
+	if _, err := testRepo.UpdateRole(ctx, first.UserID, "user", actor); !errors.Is(err, ErrLastSuperadmin) {
+		t.Errorf("Expected ErrLastSuperadmin, got %v", err)
+	}
+	if _, err := testRepo.UpdateRole(ctx, second.UserID, "superadmin", actor); err != nil {
+		t.Fatalf("UpdateRole() returned error: %v", err)
+	}
+	if _, err := testRepo.UpdateRole(ctx, first.UserID, "user", actor); err != nil {
+		t.Errorf("Expected a demotion with another superadmin left to work, got %v", err)
+	}
+}
+
+// TestDeleteUser_KeepsRequests checks a deleted user's row is anonymized and put back on the starting tier rather
+// than removed, every read treats them as gone, and their requests still load.
+func TestDeleteUser_KeepsRequests(t *testing.T) {
+	cleanUserTable()
+	ctx := context.Background()
+
+	newUser := &domain.User{FirebaseAuthID: "fb-test-delete", DisplayName: "Leaving Soon", ProfileImageURL: "https://example.com/me.png", MembershipTier: "premium", Role: "user"}
+	if err := testRepo.CreateUser(ctx, newUser); err != nil {
+		t.Fatalf("CreateUser() failed: %v", err)
+	}
+	if _, err := testRepo.SetStripeCustomerID(ctx, newUser.UserID, "cus_leaving"); err != nil {
+		t.Fatalf("SetStripeCustomerID() failed: %v", err)
+	}
+	requestID := uuid.New()
+	_, err := testDB.Exec(`
+		INSERT INTO assistance_requests (request_id, user_id, status, llm_summary, twilio_conversation_sid)
+		VALUES ($1, $2, 'resolved', 'Old question', 'twil-test-delete')
+	`, requestID, newUser.UserID)
+	if err != nil {
+		t.Fatalf("Failed to seed request: %v", err)
+	}
+	// The tombstoned row no longer matches cleanUserTable, so it goes here.
+	defer func() {
+		testDB.Exec("DELETE FROM assistance_requests WHERE request_id = $1", requestID)
+		testDB.Exec("DELETE FROM users WHERE user_id = $1", newUser.UserID)
+	}()
+
+	if err := testRepo.DeleteUser(ctx, newUser.UserID, "free"); err != nil {
+		t.Fatalf("DeleteUser() returned error: %v", err)
+	}
+	if err := testRepo.DeleteUser(ctx, newUser.UserID, "free"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound deleting twice, got %v", err)
+	}
+
+	if _, err := testRepo.GetUserByID(ctx, newUser.UserID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected GetUserByID() to return ErrNotFound, got %v", err)
+	}
+	if _, err := testRepo.GetUserByFirebaseID(ctx, "fb-test-delete"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected GetUserByFirebaseID() to return ErrNotFound, got %v", err)
+	}
+	name := "Back again"
+	if _, err := testRepo.UpdateProfile(ctx, newUser.UserID, ProfileUpdate{DisplayName: &name, Version: 2}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected UpdateProfile() to return ErrNotFound, got %v", err)
+	}
+	if _, err := testRepo.SetStripeCustomerID(ctx, newUser.UserID, "cus_other"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected SetStripeCustomerID() to return ErrNotFound, got %v", err)
+	}
+
+	var displayName, imageURL, firebaseID, tier string
+	var stripeCustomer sql.NullString
+	err = testDB.QueryRow(`
+		SELECT display_name, profile_image_url, firebase_auth_id, stripe_customer_id, membership_tier FROM users WHERE user_id = $1 AND deleted_at IS NOT NULL
+	`, newUser.UserID).Scan(&displayName, &imageURL, &firebaseID, &stripeCustomer, &tier)
+	if err != nil {
+		t.Fatalf("Expected the row to be kept and marked deleted: %v", err)
+	}
+	if displayName != deletedDisplayName || imageURL != "" || firebaseID != deletedFirebasePrefix+newUser.UserID.String() || stripeCustomer.Valid {
+		t.Errorf("Expected a tombstoned row, got %q, %q, %q, %v", displayName, imageURL, firebaseID, stripeCustomer)
+	}
+	if tier != "free" {
+		t.Errorf("Expected the deleted user back on the free tier, got %q", tier)
+	}
+
+	// The request is still there for the expert's history and the reports.
+	req, err := request.NewPostgresRepository(testDB).GetRequestByID(ctx, requestID)
+	if err != nil {
+		t.Fatalf("GetRequestByID() returned error after the user was deleted: %v", err)
+	}
+	if req.UserID != newUser.UserID || req.LLMSummary != "Old question" {
+		t.Errorf("Expected the request unchanged, got %+v", req)
+	}
+
+	// The same Firebase account can sign up again, as a new user.
+	again := &domain.User{FirebaseAuthID: "fb-test-delete", DisplayName: "New Me", Role: "user"}
+	if created, err := testRepo.GetOrCreateUser(ctx, again); err != nil || !created || again.UserID == newUser.UserID {
+		t.Errorf("Expected a new user for the same Firebase id, got created=%v id=%s, %v", created, again.UserID, err)
+	}
+}
+
+// TestQueryTimeout checks a query that runs past the timeout is cut off with a deadline error, both for a bare
+// pg_sleep and for a repository call stuck behind a row another transaction holds.
+func TestQueryTimeout(t *testing.T) {
+	cleanUserTable()
+	ctx := context.Background()
+
+	queryCtx, cancel := database.WithQueryTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := testDB.ExecContext(queryCtx, "SELECT pg_sleep(5)"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded from pg_sleep, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the sleep to be cut off, it ran for %s", elapsed)
+	}
+
+	newUser := &domain.User{FirebaseAuthID: "fb-test-timeout", DisplayName: "Slow", MembershipTier: "free", Role: "user"}
+	if err := testRepo.CreateUser(ctx, newUser); err != nil {
+		t.Fatalf("CreateUser() failed: %v", err)
+	}
+	// Hold the row in an open transaction, so the update below waits on the lock until its deadline.
+	tx, err := testDB.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("BeginTx() failed: %v", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "SELECT user_id FROM users WHERE user_id = $1 FOR UPDATE", newUser.UserID); err != nil {
+		t.Fatalf("Could not lock the user: %v", err)
+	}
+
+	repo := NewPostgresRepositoryWithOptions(testDB, RepositoryOptions{QueryTimeout: 100 * time.Millisecond})
+	name := "Renamed"
+	_, err = repo.UpdateProfile(ctx, newUser.UserID, ProfileUpdate{DisplayName: &name, Version: newUser.Version})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded from UpdateProfile, got %v", err)
+	}
+}
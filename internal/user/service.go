@@ -2,8 +2,11 @@ package user
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"project-sage/internal/auth"
 	"project-sage/internal/domain" // Shared domain models
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -12,37 +15,100 @@ import (
 type Service interface {
 	// RegisterNewUser handles the logic for creating a new user.
 	RegisterNewUser(ctx context.Context, firebaseID, displayName, profileURL string) (*domain.User, error)
+	// GetOrCreateUser returns the user with this Firebase id, registering them first if they're new.
+	// created is true if the user was registered by this call.
+	GetOrCreateUser(ctx context.Context, firebaseID, displayName, profileURL string) (user *domain.User, created bool, err error)
 	// GetUser retrieves a user by their Firebase id
 	GetUserByFirebaseID(ctx context.Context, firebaseID string) (*domain.User, error) // Renamed for clarity
 	// GetUserByID retrieves a user by their internal UUID.
 	GetUserByID(ctx context.Context, userID uuid.UUID) (*domain.User, error)
+	// GetUsersByIDs retrieves several users at once, keyed by UserID. Unknown ids are left out.
+	GetUsersByIDs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*domain.User, error)
+	// UpdateProfile changes the user's editable fields. Nil fields are left alone.
+	UpdateProfile(ctx context.Context, firebaseID string, update ProfileUpdate) (*domain.User, error)
+	// SetStripeCustomerID records the user's Stripe customer, unless one is already recorded.
+	// It returns the customer id the user has afterwards, which is the earlier one if there was one.
+	SetStripeCustomerID(ctx context.Context, userID uuid.UUID, customerID string) (string, error)
+	// DeleteUser deletes the user's own account: their tokens are forfeited and their row is anonymized.
+	DeleteUser(ctx context.Context, firebaseID string) error
+	// DeleteUserByID is DeleteUser for an admin, who only has the user's UUID.
+	DeleteUserByID(ctx context.Context, userID uuid.UUID) error
+	// ChangeMembershipTier moves a user to tier, eg when a subscription starts or lapses. With grant set, an upgrade
+	// credits the difference in monthly tokens straight away. A downgrade takes nothing back.
+	ChangeMembershipTier(ctx context.Context, userID uuid.UUID, tier string, grant bool) (*MembershipChange, error)
+	// UpdateRole changes a user's role on behalf of the superadmin actorID, who goes in the audit trail.
+	UpdateRole(ctx context.Context, userID uuid.UUID, role string, actorID uuid.UUID) (*domain.User, error)
+	// RegisterExpert creates the expert profile for a Firebase account. New experts are inactive until reviewed.
+	RegisterExpert(ctx context.Context, firebaseID, displayName string) (*domain.Expert, error)
+	// GetExpertByFirebaseID retrieves an expert by their Firebase id.
+	GetExpertByFirebaseID(ctx context.Context, firebaseID string) (*domain.Expert, error)
+	// GetExpertByID retrieves an expert by their internal UUID.
+	GetExpertByID(ctx context.Context, expertID uuid.UUID) (*domain.Expert, error)
+	// ResolveIdentity finds the user and expert profiles of a Firebase account and the role it acts with.
+	// Returns auth.ErrUnknownIdentity if it has neither.
+	ResolveIdentity(ctx context.Context, firebaseID string) (*auth.Identity, error)
+	// ListExperts returns a page of the expert roster, for admins.
+	ListExperts(ctx context.Context, filter ExpertFilter) (*domain.Page[*domain.Expert], error)
+	// SearchUsers returns a page of the users whose display name contains query, for support staff.
+	SearchUsers(ctx context.Context, query string, limit, offset int) (*domain.Page[*UserSummary], error)
+}
+
+// ProfileUpdate is a partial update to a user's profile.
+// Version must be the version the client last read, otherwise the update is rejected.
+type ProfileUpdate struct {
+	DisplayName     *string
+	ProfileImageURL *string
+	Version         int
+}
+
+// MembershipChange is the outcome of ChangeMembershipTier.
+type MembershipChange struct {
+	User          *domain.User
+	PreviousTier  string
+	GrantedTokens int // Tokens credited for an upgrade. 0 for anything else
+}
+
+// ServiceConfig holds the defaults handed to new users. Changing these lets us run promotions without a redeploy.
+type ServiceConfig struct {
+	// StartingTokens is how many tokens a new user gets on registration.
+	StartingTokens int
+	// StartingTier is the membership tier a new user starts on.
+	StartingTier string
+	// TierGrants is each paid tier's monthly tokens, the same MONTHLY_TIER_GRANTS the BillingService grants from.
+	// The known tiers are these and StartingTier, and a move to a tier with a bigger grant is an upgrade.
+	TierGrants map[string]int
+}
+
+// DefaultServiceConfig returns the standard new user setup: 3 tokens on the free tier.
+func DefaultServiceConfig() ServiceConfig {
+	return ServiceConfig{
+		StartingTokens: 3,
+		StartingTier:   "free",
+	}
 }
 
 // service is the concrete implementation of the Service interface.
 type service struct {
-	repo Repository // It depends on the repository
+	repo    Repository // It depends on the repository
+	experts ExpertRepository
+	billing BillingClient
+	cfg     ServiceConfig
 }
 
-// NewService is the constructor for the service injecting the repository.
-func NewService(repo Repository) Service {
+// NewService is the constructor for the service injecting the user and expert repositories, the billing client and config.
+func NewService(repo Repository, experts ExpertRepository, billing BillingClient, cfg ServiceConfig) Service {
 	return &service{
-		repo: repo,
+		repo:    repo,
+		experts: experts,
+		billing: billing,
+		cfg:     cfg,
 	}
 }
 
 // RegisterNewUser contains the business logic for creating a new user.
 func (s *service) RegisterNewUser(ctx context.Context, firebaseID, displayName, profileURL string) (*domain.User, error) {
 
-	// This is where business logic lives.
-	// We set default values for new users here.
-	newUser := &domain.User{
-		FirebaseAuthID:         firebaseID,
-		DisplayName:            displayName,
-		ProfileImageURL:        profileURL,
-		MembershipTier:         "free", // All new users start on free tier.
-		AssistanceTokenBalance: 3,      // Give new users 3 starting tokens.
-		Role:                   "user",
-	}
+	newUser := s.newUser(firebaseID, displayName, profileURL)
 
 	// Pass the completed user object to the repository to be saved.
 	err := s.repo.CreateUser(ctx, newUser)
@@ -55,6 +121,33 @@ func (s *service) RegisterNewUser(ctx context.Context, firebaseID, displayName,
 	return newUser, nil
 }
 
+// newUser builds a user with the defaults every new account gets.
+func (s *service) newUser(firebaseID, displayName, profileURL string) *domain.User {
+	// This is where business logic lives.
+	// We set default values for new users here.
+	return &domain.User{
+		FirebaseAuthID:         firebaseID,
+		DisplayName:            displayName,
+		ProfileImageURL:        profileURL,
+		MembershipTier:         s.cfg.StartingTier,   // All new users start on the configured tier (free by default).
+		AssistanceTokenBalance: s.cfg.StartingTokens, // Starting grant, 3 by default.
+		GrantedTokenBalance:    s.cfg.StartingTokens, // Nobody paid for those, so they're all granted.
+		Role:                   "user",
+	}
+}
+
+// GetOrCreateUser is what login calls, so the client doesn't have to guess between register and get-profile.
+// An existing user is returned as is; the display name and image are only used for a new one.
+func (s *service) GetOrCreateUser(ctx context.Context, firebaseID, displayName, profileURL string) (*domain.User, bool, error) {
+	user := s.newUser(firebaseID, displayName, profileURL)
+
+	created, err := s.repo.GetOrCreateUser(ctx, user)
+	if err != nil {
+		return nil, false, fmt.Errorf("service could not get or create user: %w", err)
+	}
+	return user, created, nil
+}
+
 // GetUserByFirebaseID is a simple pass through to the repository.
 func (s *service) GetUserByFirebaseID(ctx context.Context, firebaseID string) (*domain.User, error) {
 	// Any future caching logic goes here
@@ -66,3 +159,163 @@ func (s *service) GetUserByID(ctx context.Context, userID uuid.UUID) (*domain.Us
 	// This is also a simple passthrough.
 	return s.repo.GetUserByID(ctx, userID)
 }
+
+// GetUsersByIDs is the passthrough for the internal batch endpoint.
+func (s *service) GetUsersByIDs(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]*domain.User, error) {
+	return s.repo.GetUsersByIDs(ctx, ids)
+}
+
+// UpdateProfile finds the user and hands the partial update to the repository, which checks the version.
+func (s *service) UpdateProfile(ctx context.Context, firebaseID string, update ProfileUpdate) (*domain.User, error) {
+	user, err := s.repo.GetUserByFirebaseID(ctx, firebaseID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The client's version goes through as it is, not the one just read, so an edit based on stale data is caught.
+	return s.repo.UpdateProfile(ctx, user.UserID, update)
+}
+
+// SetStripeCustomerID is a passthrough too. The repository is what keeps the first id.
+func (s *service) SetStripeCustomerID(ctx context.Context, userID uuid.UUID, customerID string) (string, error) {
+	return s.repo.SetStripeCustomerID(ctx, userID, customerID)
+}
+
+// DeleteUser finds the user by their Firebase id and deletes their account.
+func (s *service) DeleteUser(ctx context.Context, firebaseID string) error {
+	user, err := s.repo.GetUserByFirebaseID(ctx, firebaseID)
+	if err != nil {
+		return err
+	}
+	return s.deleteUser(ctx, user.UserID)
+}
+
+// DeleteUserByID checks the user is there and not deleted already, then deletes their account.
+func (s *service) DeleteUserByID(ctx context.Context, userID uuid.UUID) error {
+	if _, err := s.repo.GetUserByID(ctx, userID); err != nil {
+		return err
+	}
+	return s.deleteUser(ctx, userID)
+}
+
+// ChangeMembershipTier grants before it writes the tier. The grant's reference is the tier and the month, so if the
+// write fails and the caller retries, the user still looks like an upgrade but billing credits them only once.
+// Done the other way round, a retry would see the new tier already set and never grant.
+func (s *service) ChangeMembershipTier(ctx context.Context, userID uuid.UUID, tier string, grant bool) (*MembershipChange, error) {
+	if _, ok := s.cfg.TierGrants[tier]; !ok && tier != s.cfg.StartingTier {
+		return nil, ErrUnknownTier
+	}
+	user, err := s.repo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	change := &MembershipChange{PreviousTier: user.MembershipTier}
+	if extra := s.cfg.TierGrants[tier] - s.cfg.TierGrants[user.MembershipTier]; grant && extra > 0 {
+		reference := fmt.Sprintf("tier-upgrade:%s:%s", tier, time.Now().UTC().Format("2006-01"))
+		if err := s.billing.GrantTokens(ctx, userID, extra, reference); err != nil {
+			return nil, fmt.Errorf("could not grant upgrade tokens: %w", err)
+		}
+		change.GrantedTokens = extra
+	}
+
+	if change.User, err = s.repo.UpdateMembershipTier(ctx, userID, tier); err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// UpdateRole is a passthrough. The repository keeps at least one superadmin and writes the audit row.
+func (s *service) UpdateRole(ctx context.Context, userID uuid.UUID, role string, actorID uuid.UUID) (*domain.User, error) {
+	return s.repo.UpdateRole(ctx, userID, role, actorID)
+}
+
+// deleteUser forfeits the balance before anonymizing the row. Both are safe to repeat, so if the forfeit fails
+// nothing has changed and the caller can just try again. The other way round, a failed forfeit would leave a
+// deleted user nobody can find still holding tokens. The row goes back to the starting tier.
+func (s *service) deleteUser(ctx context.Context, userID uuid.UUID) error {
+	if err := s.billing.ForfeitBalance(ctx, userID); err != nil {
+		return fmt.Errorf("service could not forfeit balance: %w", err)
+	}
+	return s.repo.DeleteUser(ctx, userID, s.cfg.StartingTier)
+}
+
+// RegisterExpert creates an inactive expert. Anyone with a Firebase account can call register, so an expert
+// only starts seeing requests once someone has reviewed them and set is_active.
+func (s *service) RegisterExpert(ctx context.Context, firebaseID, displayName string) (*domain.Expert, error) {
+	expert := &domain.Expert{
+		FirebaseAuthID: firebaseID,
+		DisplayName:    displayName,
+		IsActive:       false,
+		Role:           auth.RoleExpert,
+	}
+	if err := s.experts.CreateExpert(ctx, expert); err != nil {
+		return nil, fmt.Errorf("service could not register expert: %w", err)
+	}
+	return expert, nil
+}
+
+// GetExpertByFirebaseID is a passthrough to the expert repository.
+func (s *service) GetExpertByFirebaseID(ctx context.Context, firebaseID string) (*domain.Expert, error) {
+	return s.experts.GetExpertByFirebaseID(ctx, firebaseID)
+}
+
+// GetExpertByID is the passthrough for the internal endpoint.
+func (s *service) GetExpertByID(ctx context.Context, expertID uuid.UUID) (*domain.Expert, error) {
+	return s.experts.GetExpertByID(ctx, expertID)
+}
+
+// ResolveIdentity looks the account up as a user and as an expert. A superadmin keeps that role even with an expert
+// profile, otherwise having one makes the account an expert, so staff who also tried the user app can still work the
+// queue. Anyone else has the role on their user row. An expert profile nobody has reviewed yet counts as none at
+// all, since anyone can register one: it gets neither the role nor the expert id.
+func (s *service) ResolveIdentity(ctx context.Context, firebaseID string) (*auth.Identity, error) {
+	user, err := s.repo.GetUserByFirebaseID(ctx, firebaseID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("service could not look up user: %w", err)
+	}
+	expert, err := s.experts.GetExpertByFirebaseID(ctx, firebaseID)
+	if err != nil && !errors.Is(err, ErrExpertNotFound) {
+		return nil, fmt.Errorf("service could not look up expert: %w", err)
+	}
+	if expert != nil && !expert.IsActive {
+		expert = nil
+	}
+
+	id := &auth.Identity{}
+	switch {
+	case user == nil && expert == nil:
+		return nil, auth.ErrUnknownIdentity
+	case user != nil && user.Role == auth.RoleSuperadmin:
+		id.Role = auth.RoleSuperadmin
+	case expert != nil:
+		id.Role = auth.RoleExpert
+	default:
+		id.Role = user.Role
+	}
+	if user != nil {
+		id.UserID = user.UserID
+	}
+	if expert != nil {
+		id.ExpertID = expert.ExpertID
+	}
+	return id, nil
+}
+
+// ListExperts wraps the repository's page and total in the shared page envelope.
+func (s *service) ListExperts(ctx context.Context, filter ExpertFilter) (*domain.Page[*domain.Expert], error) {
+	experts, total, err := s.experts.ListExperts(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("service could not list experts: %w", err)
+	}
+	return domain.NewPage(experts, total, filter.Limit, filter.Offset), nil
+}
+
+// SearchUsers wraps the repository's matches and total in the shared page envelope.
+func (s *service) SearchUsers(ctx context.Context, query string, limit, offset int) (*domain.Page[*UserSummary], error) {
+	users, total, err := s.repo.SearchUsers(ctx, query, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("service could not search users: %w", err)
+	}
+	return domain.NewPage(users, total, limit, offset), nil
+}
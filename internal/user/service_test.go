@@ -2,8 +2,10 @@ package user
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"project-sage/internal/auth"
 	"project-sage/internal/domain" // The shared domain models
+	"strings"
 	"testing"
 
 	"github.com/google/uuid"
@@ -23,7 +25,7 @@ func TestService_RegisterNewUser(t *testing.T) {
 	mockRepo := NewMockRepository(ctrl)
 
 	// Create the service and inject the mock.
-	s := NewService(mockRepo)
+	s := NewService(mockRepo, nil, nil, DefaultServiceConfig())
 
 	ctx := context.Background()
 
@@ -35,6 +37,7 @@ func TestService_RegisterNewUser(t *testing.T) {
 		ProfileImageURL:        "http://new.com/img.png",
 		MembershipTier:         "free", // This default is important.
 		AssistanceTokenBalance: 3,      // So is this one.
+		GrantedTokenBalance:    3,      // And they're all granted, not purchased.
 		Role:                   "user",
 	}
 
@@ -69,12 +72,47 @@ func TestService_RegisterNewUser(t *testing.T) {
 	}
 }
 
+// TestService_RegisterNewUser_CustomGrant verifies the starting grant comes from the config.
+func TestService_RegisterNewUser_CustomGrant(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+
+	// A promotion: 10 tokens instead of 3.
+	cfg := DefaultServiceConfig()
+	cfg.StartingTokens = 10
+	s := NewService(mockRepo, nil, nil, cfg)
+
+	ctx := context.Background()
+
+	mockRepo.EXPECT().
+		CreateUser(ctx, gomock.Any()).
+		DoAndReturn(func(ctx context.Context, u *domain.User) error {
+			if u.AssistanceTokenBalance != 10 {
+				t.Errorf("Expected CreateUser to get balance 10, got %d", u.AssistanceTokenBalance)
+			}
+			return nil
+		}).
+		Times(1)
+
+	user, err := s.RegisterNewUser(ctx, "fb-promo-user", "Promo User", "")
+	if err != nil {
+		t.Fatalf("RegisterNewUser() returned an unexpected error: %v", err)
+	}
+	if user.AssistanceTokenBalance != 10 {
+		t.Errorf("Expected user balance 10, got %d", user.AssistanceTokenBalance)
+	}
+	if user.MembershipTier != "free" {
+		t.Errorf("Expected user tier 'free', got '%s'", user.MembershipTier)
+	}
+}
+
 // TestService_GetUserByID tests the passthrough for GetUserByID.
 func TestService_GetUserByID(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 	mockRepo := NewMockRepository(ctrl)
-	s := NewService(mockRepo)
+	s := NewService(mockRepo, nil, nil, DefaultServiceConfig())
 
 	ctx := context.Background()
 	testID := uuid.New()
@@ -104,11 +142,11 @@ func TestService_GetUserByID_NotFound(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 	mockRepo := NewMockRepository(ctrl)
-	s := NewService(mockRepo)
+	s := NewService(mockRepo, nil, nil, DefaultServiceConfig())
 
 	ctx := context.Background()
 	testID := uuid.New()
-	repoError := fmt.Errorf("user not found")
+	repoError := ErrNotFound
 
 	// Expect the service to call the repo and return an error
 	mockRepo.EXPECT().
@@ -120,7 +158,343 @@ func TestService_GetUserByID_NotFound(t *testing.T) {
 	if err == nil {
 		t.Fatal("Expected an error but got nil")
 	}
-	if err.Error() != "user not found" {
+	if !errors.Is(err, ErrNotFound) {
 		t.Fatalf("Expected 'user not found', got '%v'", err)
 	}
 }
+
+// TestService_UpdateProfile_PartialUpdate checks that the update reaches the repository as sent, for the user the
+// firebase id belongs to, with the client's version.
+func TestService_UpdateProfile_PartialUpdate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	s := NewService(mockRepo, nil, nil, DefaultServiceConfig())
+
+	ctx := context.Background()
+	existing := &domain.User{
+		UserID:          uuid.New(),
+		FirebaseAuthID:  "fb-update-user",
+		DisplayName:     "Old Name",
+		ProfileImageURL: "http://old.com/img.png",
+		Version:         5,
+	}
+	newName := "New Name"
+
+	mockRepo.EXPECT().GetUserByFirebaseID(ctx, "fb-update-user").Return(existing, nil).Times(1)
+	mockRepo.EXPECT().
+		UpdateProfile(ctx, existing.UserID, gomock.Any()).
+		DoAndReturn(func(ctx context.Context, userID uuid.UUID, update ProfileUpdate) (*domain.User, error) {
+			if update.DisplayName == nil || *update.DisplayName != "New Name" {
+				t.Errorf("Expected display name 'New Name', got %v", update.DisplayName)
+			}
+			if update.ProfileImageURL != nil {
+				t.Errorf("Profile image should be left out, got '%s'", *update.ProfileImageURL)
+			}
+			if update.Version != 4 {
+				t.Errorf("Expected the client's version 4 to be passed through, got %d", update.Version)
+			}
+			return nil, ErrVersionConflict
+		}).
+		Times(1)
+
+	// The client read version 4, so this should come back as a conflict.
+	_, err := s.UpdateProfile(ctx, "fb-update-user", ProfileUpdate{DisplayName: &newName, Version: 4})
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("Expected ErrVersionConflict, got: %v", err)
+	}
+}
+
+// TestService_GetOrCreateUser_New verifies a first login gets the same defaults as register.
+func TestService_GetOrCreateUser_New(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	s := NewService(mockRepo, nil, nil, DefaultServiceConfig())
+	ctx := context.Background()
+
+	expectedUser := &domain.User{
+		FirebaseAuthID:         "fb-login-new",
+		DisplayName:            "New User",
+		MembershipTier:         "free",
+		AssistanceTokenBalance: 3,
+		GrantedTokenBalance:    3,
+		Role:                   "user",
+	}
+	mockRepo.EXPECT().GetOrCreateUser(ctx, expectedUser).Return(true, nil).Times(1)
+
+	user, created, err := s.GetOrCreateUser(ctx, "fb-login-new", "New User", "")
+
+	if err != nil {
+		t.Fatalf("GetOrCreateUser() returned an unexpected error: %v", err)
+	}
+	if !created {
+		t.Error("Expected created to be true for a new user")
+	}
+	if user.AssistanceTokenBalance != 3 {
+		t.Errorf("Expected user balance 3, got %d", user.AssistanceTokenBalance)
+	}
+}
+
+// TestService_GetOrCreateUser_Existing verifies an existing user comes back untouched.
+func TestService_GetOrCreateUser_Existing(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	s := NewService(mockRepo, nil, nil, DefaultServiceConfig())
+	ctx := context.Background()
+
+	existing := domain.User{
+		UserID:                 uuid.New(),
+		FirebaseAuthID:         "fb-login-existing",
+		DisplayName:            "Old Name",
+		MembershipTier:         "premium",
+		AssistanceTokenBalance: 12,
+		Role:                   "user",
+		Version:                4,
+	}
+	// The repo overwrites the user it's given with the stored row.
+	mockRepo.EXPECT().GetOrCreateUser(ctx, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, u *domain.User) (bool, error) {
+			*u = existing
+			return false, nil
+		}).Times(1)
+
+	user, created, err := s.GetOrCreateUser(ctx, "fb-login-existing", "New Name", "")
+
+	if err != nil {
+		t.Fatalf("GetOrCreateUser() returned an unexpected error: %v", err)
+	}
+	if created {
+		t.Error("Expected created to be false for an existing user")
+	}
+	if user.UserID != existing.UserID || user.DisplayName != "Old Name" || user.AssistanceTokenBalance != 12 {
+		t.Errorf("Expected the stored user back, got %+v", user)
+	}
+}
+
+// TestService_GetOrCreateUser_RepoError verifies repository errors are passed up.
+func TestService_GetOrCreateUser_RepoError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	s := NewService(mockRepo, nil, nil, DefaultServiceConfig())
+
+	dbErr := errors.New("connection refused")
+	mockRepo.EXPECT().GetOrCreateUser(gomock.Any(), gomock.Any()).Return(false, dbErr).Times(1)
+
+	_, _, err := s.GetOrCreateUser(context.Background(), "fb-login-err", "", "")
+
+	if !errors.Is(err, dbErr) {
+		t.Errorf("Expected the repository error, got: %v", err)
+	}
+}
+
+// TestService_DeleteUser verifies the balance is forfeited before the row is anonymized and put back on the
+// starting tier, and a failed forfeit leaves the row alone so the deletion can be retried.
+func TestService_DeleteUser(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	mockBilling := NewMockBillingClient(ctrl)
+	s := NewService(mockRepo, nil, mockBilling, DefaultServiceConfig())
+	ctx := context.Background()
+
+	user := &domain.User{UserID: uuid.New(), FirebaseAuthID: "fb-delete-me"}
+	gomock.InOrder(
+		mockRepo.EXPECT().GetUserByFirebaseID(ctx, "fb-delete-me").Return(user, nil),
+		mockBilling.EXPECT().ForfeitBalance(ctx, user.UserID).Return(nil),
+		mockRepo.EXPECT().DeleteUser(ctx, user.UserID, "free").Return(nil),
+	)
+	if err := s.DeleteUser(ctx, "fb-delete-me"); err != nil {
+		t.Fatalf("DeleteUser() returned an unexpected error: %v", err)
+	}
+
+	billingErr := errors.New("billing service returned non-200 status: 503")
+	mockRepo.EXPECT().GetUserByFirebaseID(ctx, "fb-delete-me").Return(user, nil)
+	mockBilling.EXPECT().ForfeitBalance(ctx, user.UserID).Return(billingErr)
+	// No DeleteUser expected.
+	if err := s.DeleteUser(ctx, "fb-delete-me"); !errors.Is(err, billingErr) {
+		t.Errorf("Expected the billing error, got: %v", err)
+	}
+}
+
+// TestService_DeleteUserByID_NotFound verifies an unknown or already deleted user is a 404 before billing is called.
+func TestService_DeleteUserByID_NotFound(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockRepo := NewMockRepository(ctrl)
+	s := NewService(mockRepo, nil, NewMockBillingClient(ctrl), DefaultServiceConfig())
+
+	userID := uuid.New()
+	mockRepo.EXPECT().GetUserByID(gomock.Any(), userID).Return(nil, ErrNotFound)
+
+	if err := s.DeleteUserByID(context.Background(), userID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got: %v", err)
+	}
+}
+
+// TestService_RegisterExpert checks a new expert is inactive with the expert role, and a duplicate comes back
+// as ErrExpertAlreadyExists.
+func TestService_RegisterExpert(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockExperts := NewMockExpertRepository(ctrl)
+	s := NewService(nil, mockExperts, nil, DefaultServiceConfig())
+	ctx := context.Background()
+
+	want := &domain.Expert{FirebaseAuthID: "fb-expert", DisplayName: "Joe", IsActive: false, Role: "expert"}
+	mockExperts.EXPECT().CreateExpert(ctx, want).Return(nil)
+	mockExperts.EXPECT().CreateExpert(ctx, gomock.Any()).Return(ErrExpertAlreadyExists)
+
+	expert, err := s.RegisterExpert(ctx, "fb-expert", "Joe")
+	if err != nil {
+		t.Fatalf("RegisterExpert() returned an unexpected error: %v", err)
+	}
+	if expert.IsActive || expert.Role != "expert" {
+		t.Errorf("Expected an inactive expert, got %+v", expert)
+	}
+	if _, err := s.RegisterExpert(ctx, "fb-expert", "Joe"); !errors.Is(err, ErrExpertAlreadyExists) {
+		t.Errorf("Expected ErrExpertAlreadyExists, got %v", err)
+	}
+}
+
+// TestService_ResolveIdentity checks which role an account acts with for each mix of user and expert profiles.
+func TestService_ResolveIdentity(t *testing.T) {
+	userID, expertID := uuid.New(), uuid.New()
+	tests := []struct {
+		name     string
+		userRole string // Empty means no user profile
+		expert   bool
+		inactive bool // The expert profile hasn't been reviewed yet
+		want     string
+	}{
+		{"user", "user", false, false, auth.RoleUser},
+		{"expert", "", true, false, auth.RoleExpert},
+		{"expert who also has a user profile", "user", true, false, auth.RoleExpert},
+		{"inactive expert who also has a user profile", "user", true, true, auth.RoleUser},
+		{"superadmin", "superadmin", false, false, auth.RoleSuperadmin},
+		{"superadmin with an expert profile", "superadmin", true, false, auth.RoleSuperadmin},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mockRepo := NewMockRepository(ctrl)
+			mockExperts := NewMockExpertRepository(ctrl)
+			s := NewService(mockRepo, mockExperts, nil, DefaultServiceConfig())
+
+			if tt.userRole != "" {
+				mockRepo.EXPECT().GetUserByFirebaseID(gomock.Any(), "fb-1").Return(&domain.User{UserID: userID, Role: tt.userRole}, nil)
+			} else {
+				mockRepo.EXPECT().GetUserByFirebaseID(gomock.Any(), "fb-1").Return(nil, ErrNotFound)
+			}
+			if tt.expert {
+				mockExperts.EXPECT().GetExpertByFirebaseID(gomock.Any(), "fb-1").Return(&domain.Expert{ExpertID: expertID, IsActive: !tt.inactive}, nil)
+			} else {
+				mockExperts.EXPECT().GetExpertByFirebaseID(gomock.Any(), "fb-1").Return(nil, ErrExpertNotFound)
+			}
+
+			id, err := s.ResolveIdentity(context.Background(), "fb-1")
+			if err != nil {
+				t.Fatalf("ResolveIdentity() returned unexpected error: %v", err)
+			}
+			if id.Role != tt.want {
+				t.Errorf("Expected role %q, got %q", tt.want, id.Role)
+			}
+			if (id.UserID == userID) != (tt.userRole != "") || (id.ExpertID == expertID) != (tt.expert && !tt.inactive) {
+				t.Errorf("Expected the ids of the profiles that count, got %+v", id)
+			}
+		})
+	}
+
+	t.Run("neither", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mockRepo := NewMockRepository(ctrl)
+		mockExperts := NewMockExpertRepository(ctrl)
+		s := NewService(mockRepo, mockExperts, nil, DefaultServiceConfig())
+		mockRepo.EXPECT().GetUserByFirebaseID(gomock.Any(), "fb-new").Return(nil, ErrNotFound)
+		mockExperts.EXPECT().GetExpertByFirebaseID(gomock.Any(), "fb-new").Return(nil, ErrExpertNotFound)
+
+		if _, err := s.ResolveIdentity(context.Background(), "fb-new"); !errors.Is(err, auth.ErrUnknownIdentity) {
+			t.Errorf("Expected auth.ErrUnknownIdentity, got %v", err)
+		}
+	})
+
+	// A self-registered expert waiting for review is nobody yet, not an expert.
+	t.Run("inactive expert only", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		mockRepo := NewMockRepository(ctrl)
+		mockExperts := NewMockExpertRepository(ctrl)
+		s := NewService(mockRepo, mockExperts, nil, DefaultServiceConfig())
+		mockRepo.EXPECT().GetUserByFirebaseID(gomock.Any(), "fb-applicant").Return(nil, ErrNotFound)
+		mockExperts.EXPECT().GetExpertByFirebaseID(gomock.Any(), "fb-applicant").Return(&domain.Expert{ExpertID: expertID}, nil)
+
+		if _, err := s.ResolveIdentity(context.Background(), "fb-applicant"); !errors.Is(err, auth.ErrUnknownIdentity) {
+			t.Errorf("Expected auth.ErrUnknownIdentity, got %v", err)
+		}
+	})
+}
+
+// TestService_ChangeMembershipTier checks each transition: an upgrade grants the difference in monthly tokens only
+// when asked, before the tier is written, and a downgrade or a repeat never touches billing.
+func TestService_ChangeMembershipTier(t *testing.T) {
+	cfg := DefaultServiceConfig()
+	cfg.TierGrants = map[string]int{"premium": 10, "premium_plus": 25}
+	billingErr := errors.New("billing down")
+
+	tests := []struct {
+		name        string
+		from, to    string
+		grant       bool
+		wantGrant   int   // Tokens billing should be asked for. 0 means billing isn't called
+		grantErr    error // What billing answers
+		wantErr     error
+		wantUpdated bool
+	}{
+		{"free to premium", "free", "premium", true, 10, nil, nil, true},
+		{"premium to premium_plus", "premium", "premium_plus", true, 15, nil, nil, true},
+		{"upgrade without a grant", "free", "premium", false, 0, nil, nil, true},
+		{"downgrade", "premium_plus", "free", true, 0, nil, nil, true},
+		{"same tier", "premium", "premium", true, 0, nil, nil, true},
+		{"unknown tier", "free", "gold", true, 0, nil, ErrUnknownTier, false},
+		{"grant fails", "free", "premium", true, 10, billingErr, billingErr, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			mockRepo := NewMockRepository(ctrl)
+			mockBilling := NewMockBillingClient(ctrl)
+			s := NewService(mockRepo, nil, mockBilling, cfg)
+			ctx := context.Background()
+			userID := uuid.New()
+
+			if tt.wantErr != ErrUnknownTier {
+				mockRepo.EXPECT().GetUserByID(ctx, userID).Return(&domain.User{UserID: userID, MembershipTier: tt.from}, nil)
+			}
+			if tt.wantGrant > 0 {
+				mockBilling.EXPECT().GrantTokens(ctx, userID, tt.wantGrant, gomock.Any()).
+					DoAndReturn(func(_ context.Context, _ uuid.UUID, _ int, reference string) error {
+						if !strings.HasPrefix(reference, "tier-upgrade:"+tt.to+":") {
+							t.Errorf("Unexpected grant reference %q", reference)
+						}
+						return tt.grantErr
+					})
+			}
+			if tt.wantUpdated {
+				mockRepo.EXPECT().UpdateMembershipTier(ctx, userID, tt.to).Return(&domain.User{UserID: userID, MembershipTier: tt.to}, nil)
+			}
+
+			change, err := s.ChangeMembershipTier(ctx, userID, tt.to, tt.grant)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if change.PreviousTier != tt.from || change.GrantedTokens != tt.wantGrant || change.User.MembershipTier != tt.to {
+				t.Errorf("Unexpected change %+v", change)
+			}
+		})
+	}
+}
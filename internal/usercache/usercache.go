@@ -0,0 +1,106 @@
+package usercache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"project-sage/internal/domain"
+
+	"github.com/google/uuid"
+)
+
+// This package is a short-lived in-memory cache in front of the UserService's profile lookup.
+// Several services fetch the same profile over and over (the RequestService on every create, the PaymentService
+// on every purchase), and a few seconds of staleness is fine for what they read from it.
+// Each instance has its own cache, so an Invalidate only reaches the instance it's called on.
+
+// UserClient is the profile lookup being cached. request.UserClient and payment.UserClient both have this shape,
+// so a *Cache can stand in for either.
+type UserClient interface {
+	GetUserProfile(ctx context.Context, userID uuid.UUID) (*domain.User, error)
+}
+
+// Invalidator is implemented by a cache in front of a UserClient. Callers that just changed a user,
+// eg credited tokens, type-assert their client to it and drop the stale profile before reading it again.
+type Invalidator interface {
+	Invalidate(userID uuid.UUID)
+}
+
+// maxEntries is how many profiles we keep before pruning the expired ones.
+const maxEntries = 10000
+
+type entry struct {
+	user    domain.User
+	expires time.Time
+}
+
+// Cache is a UserClient that remembers each profile for a TTL. Errors aren't cached.
+type Cache struct {
+	next    UserClient
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[uuid.UUID]entry
+	gen     uint64           // Bumped by Invalidate, so a lookup that started before it doesn't store what it got
+	now     func() time.Time // Swappable for tests.
+}
+
+// New wraps next in a cache that keeps profiles for ttl.
+func New(next UserClient, ttl time.Duration) *Cache {
+	return &Cache{
+		next:    next,
+		ttl:     ttl,
+		entries: make(map[uuid.UUID]entry),
+		now:     time.Now,
+	}
+}
+
+// GetUserProfile implements UserClient. Two misses for the same user at the same time both go to next,
+// which is fine at the traffic we have.
+func (c *Cache) GetUserProfile(ctx context.Context, userID uuid.UUID) (*domain.User, error) {
+	c.mu.Lock()
+	if e, ok := c.entries[userID]; ok && c.now().Before(e.expires) {
+		c.mu.Unlock()
+		// A copy, so a caller changing it doesn't change what everyone else gets.
+		user := e.user
+		return &user, nil
+	}
+	gen := c.gen
+	c.mu.Unlock()
+
+	user, err := c.next.GetUserProfile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen == gen {
+		if len(c.entries) >= maxEntries {
+			c.prune()
+		}
+		c.entries[userID] = entry{user: *user, expires: c.now().Add(c.ttl)}
+	}
+	return user, nil
+}
+
+// Invalidate drops the user's cached profile, so the next lookup goes to the UserService.
+func (c *Cache) Invalidate(userID uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, userID)
+	c.gen++
+}
+
+// prune drops expired profiles, and everything if that doesn't make room. Must hold mu.
+func (c *Cache) prune() {
+	now := c.now()
+	for id, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, id)
+		}
+	}
+	if len(c.entries) >= maxEntries {
+		c.entries = make(map[uuid.UUID]entry)
+	}
+}
@@ -0,0 +1,90 @@
+package usercache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"project-sage/internal/domain"
+
+	"github.com/google/uuid"
+)
+
+// countingClient is a UserClient that counts calls and answers with a balance that goes up on each one,
+// so a test can tell a cached profile from a fresh one.
+type countingClient struct {
+	calls int
+	err   error
+}
+
+func (c *countingClient) GetUserProfile(ctx context.Context, userID uuid.UUID) (*domain.User, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &domain.User{UserID: userID, AssistanceTokenBalance: c.calls}, nil
+}
+
+// TestCache_HitWithinTTL checks two lookups within the TTL only reach the client once, and the next one after it does.
+func TestCache_HitWithinTTL(t *testing.T) {
+	next := &countingClient{}
+	cache := New(next, 30*time.Second)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+	ctx := context.Background()
+	userID := uuid.New()
+
+	first, err := cache.GetUserProfile(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUserProfile() returned error: %v", err)
+	}
+	// Changing what we got back doesn't change the cached copy.
+	first.AssistanceTokenBalance = 99
+
+	now = now.Add(29 * time.Second)
+	second, err := cache.GetUserProfile(ctx, userID)
+	if err != nil || next.calls != 1 || second.AssistanceTokenBalance != 1 {
+		t.Fatalf("Expected the cached profile from one call, got %d calls, %+v, %v", next.calls, second, err)
+	}
+
+	now = now.Add(time.Second)
+	if third, _ := cache.GetUserProfile(ctx, userID); next.calls != 2 || third.AssistanceTokenBalance != 2 {
+		t.Errorf("Expected a fresh profile once the TTL is up, got %d calls, %+v", next.calls, third)
+	}
+}
+
+// TestCache_Invalidate checks an invalidated profile is fetched again, and other users stay cached.
+func TestCache_Invalidate(t *testing.T) {
+	next := &countingClient{}
+	cache := New(next, time.Minute)
+	ctx := context.Background()
+	userID, otherID := uuid.New(), uuid.New()
+
+	cache.GetUserProfile(ctx, userID)
+	cache.GetUserProfile(ctx, otherID)
+	cache.Invalidate(userID)
+
+	if user, _ := cache.GetUserProfile(ctx, userID); next.calls != 3 || user.AssistanceTokenBalance != 3 {
+		t.Errorf("Expected the invalidated profile to be fetched again, got %d calls, %+v", next.calls, user)
+	}
+	if cache.GetUserProfile(ctx, otherID); next.calls != 3 {
+		t.Errorf("Expected the other user to stay cached, got %d calls", next.calls)
+	}
+}
+
+// TestCache_ErrorsNotCached checks a failed lookup is tried again next time.
+func TestCache_ErrorsNotCached(t *testing.T) {
+	next := &countingClient{err: errors.New("user service down")}
+	cache := New(next, time.Minute)
+	userID := uuid.New()
+
+	for i := 0; i < 2; i++ {
+		if _, err := cache.GetUserProfile(context.Background(), userID); err == nil {
+			t.Fatalf("Lookup %d: expected the client's error", i+1)
+		}
+	}
+	if next.calls != 2 {
+		t.Errorf("Expected 2 calls, got %d", next.calls)
+	}
+}
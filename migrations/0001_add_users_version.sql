@@ -0,0 +1,3 @@
+-- Optimistic concurrency for user profile updates.
+-- Every write to a profile bumps version, and PATCH /users/profile only applies if the caller saw the latest one.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
@@ -0,0 +1,10 @@
+-- The ChatGatewayService owns the mapping from users to their Twilio conversations.
+CREATE TABLE IF NOT EXISTS conversations (
+    conversation_id         UUID PRIMARY KEY,
+    user_id                 UUID NOT NULL REFERENCES users(user_id),
+    twilio_conversation_sid TEXT NOT NULL UNIQUE,
+    status                  TEXT NOT NULL DEFAULT 'active', -- 'active' or 'closed'
+    created_at              TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS conversations_user_id_created_at_idx ON conversations (user_id, created_at DESC);
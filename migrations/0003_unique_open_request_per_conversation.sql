@@ -0,0 +1,5 @@
+-- Only one open (pending or active) request may point at a conversation.
+-- A double tap on "request help" now fails the second insert instead of creating a second row.
+CREATE UNIQUE INDEX IF NOT EXISTS assistance_requests_open_sid_uniq
+    ON assistance_requests (twilio_conversation_sid)
+    WHERE status IN ('pending', 'active');
@@ -0,0 +1,4 @@
+-- Lookups by conversation SID (chat webhooks) need every row, not just open ones,
+-- so the partial unique index from 0003 doesn't cover them.
+CREATE INDEX IF NOT EXISTS assistance_requests_sid_created_idx
+    ON assistance_requests (twilio_conversation_sid, created_at DESC);
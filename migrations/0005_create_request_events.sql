@@ -0,0 +1,14 @@
+-- Audit trail of changes to a request that aren't visible from its own columns, eg who transferred it to whom.
+-- One row per event, written in the same transaction as the change itself.
+CREATE TABLE IF NOT EXISTS request_events (
+    event_id       UUID PRIMARY KEY,
+    request_id     UUID NOT NULL REFERENCES assistance_requests(request_id) ON DELETE CASCADE,
+    event_type     TEXT NOT NULL,        -- 'transferred'
+    actor_id       UUID NOT NULL,        -- The expert or admin user who made the change
+    actor_role     TEXT NOT NULL,        -- 'expert' or 'admin'
+    from_expert_id UUID REFERENCES experts(expert_id),
+    to_expert_id   UUID REFERENCES experts(expert_id),
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS request_events_request_id_created_at_idx ON request_events (request_id, created_at);
@@ -0,0 +1,3 @@
+-- When the assigned expert first wrote in the chat, for the time-to-first-response SLA.
+-- Set once by the RequestService when the chat gateway reports the expert's first message. NULL until then.
+ALTER TABLE assistance_requests ADD COLUMN IF NOT EXISTS first_response_at TIMESTAMPTZ;
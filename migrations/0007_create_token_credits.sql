@@ -0,0 +1,10 @@
+-- Ledger of credits that came with an external reference, eg a payment the PaymentService may retry.
+-- The primary key is what makes a repeated credit a no-op. balance_after is what we answer the repeat with.
+CREATE TABLE IF NOT EXISTS token_credits (
+    user_id       UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    reference_id  TEXT NOT NULL,
+    amount        INT NOT NULL,
+    balance_after INT NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, reference_id)
+);
@@ -0,0 +1,20 @@
+-- Ledger of debits and the refunds that reverse them, so every token taken can be traced and given back exactly once.
+-- Credits with a reference are still in token_credits (0007).
+CREATE TABLE IF NOT EXISTS token_ledger (
+    entry_id      UUID PRIMARY KEY,
+    user_id       UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    kind          TEXT NOT NULL,                          -- 'debit' or 'refund'
+    amount        INT NOT NULL,
+    reference_id  TEXT,                                   -- The caller's reference for a debit, optional
+    refund_of     UUID REFERENCES token_ledger(entry_id),  -- The debit a refund reverses
+    balance_after INT NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- A debit can only be refunded once.
+CREATE UNIQUE INDEX IF NOT EXISTS token_ledger_refund_of_uniq ON token_ledger (refund_of) WHERE refund_of IS NOT NULL;
+
+-- A debit reference is unique per user, so it can be used to find the debit again.
+CREATE UNIQUE INDEX IF NOT EXISTS token_ledger_debit_reference_uniq
+    ON token_ledger (user_id, reference_id)
+    WHERE kind = 'debit' AND reference_id IS NOT NULL;
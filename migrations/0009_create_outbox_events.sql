@@ -0,0 +1,15 @@
+-- Transactional outbox for the RequestService. Side effects of a change (removing the bot, notifying experts)
+-- are written here in the same transaction as the change, then delivered by the background dispatcher,
+-- so a failed call is retried instead of lost.
+CREATE TABLE IF NOT EXISTS outbox_events (
+    event_id        UUID PRIMARY KEY,
+    event_type      TEXT NOT NULL,                      -- 'remove_bot' or 'notify_experts'
+    request_id      UUID NOT NULL REFERENCES assistance_requests(request_id) ON DELETE CASCADE,
+    attempts        INT NOT NULL DEFAULT 0,
+    last_error      TEXT,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(), -- Also pushed forward while a dispatcher holds the event
+    sent_at         TIMESTAMPTZ,                        -- NULL until delivered
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS outbox_events_unsent_idx ON outbox_events (next_attempt_at) WHERE sent_at IS NULL;
@@ -0,0 +1,5 @@
+-- Monthly tier grants go in token_ledger as kind 'monthly_grant', with the cycle month ('2024-05') as reference_id.
+-- One grant per user per cycle, so re-running the job for a month it already did grants nothing.
+CREATE UNIQUE INDEX IF NOT EXISTS token_ledger_monthly_grant_uniq
+    ON token_ledger (user_id, reference_id)
+    WHERE kind = 'monthly_grant';
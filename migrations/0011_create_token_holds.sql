@@ -0,0 +1,23 @@
+-- Token holds: tokens set aside for something that might not happen, eg a request that's still being created.
+-- Holding moves tokens out of assistance_token_balance (what the user can spend) into held_token_balance.
+-- Committing turns the hold into a 'debit' in token_ledger, releasing puts the tokens back.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS held_token_balance INT NOT NULL DEFAULT 0 CHECK (held_token_balance >= 0);
+
+CREATE TABLE IF NOT EXISTS token_holds (
+    hold_id         UUID PRIMARY KEY,
+    user_id         UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    amount          INT NOT NULL CHECK (amount > 0),
+    reference_id    TEXT,                                   -- The caller's reference, optional
+    status          TEXT NOT NULL DEFAULT 'held',           -- 'held', 'committed' or 'released'
+    ledger_entry_id UUID REFERENCES token_ledger(entry_id), -- The debit a committed hold became
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    resolved_at     TIMESTAMPTZ                             -- When it was committed or released
+);
+
+-- A reference is unique per user, so a retried hold gets the same hold back.
+CREATE UNIQUE INDEX IF NOT EXISTS token_holds_reference_uniq
+    ON token_holds (user_id, reference_id)
+    WHERE reference_id IS NOT NULL;
+
+-- For the sweeper, which looks for old holds nobody committed or released.
+CREATE INDEX IF NOT EXISTS token_holds_open_idx ON token_holds (created_at) WHERE status = 'held';
@@ -0,0 +1,2 @@
+-- For reading a user's ledger back newest first, a page at a time, with keyset pagination on (created_at, entry_id).
+CREATE INDEX IF NOT EXISTS token_ledger_user_created_idx ON token_ledger (user_id, created_at DESC, entry_id DESC);
@@ -0,0 +1,5 @@
+-- Soft reservations on pending requests: an expert looking at a request holds it for a short while so other
+-- experts don't go for the same one. Nothing clears an expired reservation, it just stops counting once
+-- reserved_until has passed. Accepting or claiming the request clears it.
+ALTER TABLE assistance_requests ADD COLUMN IF NOT EXISTS reserved_by UUID;
+ALTER TABLE assistance_requests ADD COLUMN IF NOT EXISTS reserved_until TIMESTAMPTZ;
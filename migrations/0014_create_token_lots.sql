@@ -0,0 +1,34 @@
+-- Token lots: tokens that run out, eg a trial or promotion that's only good for 30 days.
+-- A lot's tokens are part of users.assistance_token_balance like any others. Whatever part of the balance isn't in
+-- an open lot never expires, so paid tokens and existing balances need no rows here.
+-- Spending takes from the soonest expiring lot first, and the sweeper writes an 'expired' row in token_ledger for
+-- whatever is left once a lot runs out.
+-- Every change to a user's lots happens with their users row locked, so SUM(remaining) can never be more than the balance.
+CREATE TABLE IF NOT EXISTS token_lots (
+    lot_id     UUID PRIMARY KEY,
+    user_id    UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    source     TEXT NOT NULL,                                -- Where the tokens came from, eg 'trial' or 'promo'
+    amount     INT NOT NULL CHECK (amount > 0),              -- What was credited
+    remaining  INT NOT NULL CHECK (remaining >= 0),          -- What's left to spend
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- For spending a user's lots in expiry order, and for their balance breakdown.
+CREATE INDEX IF NOT EXISTS token_lots_user_open_idx ON token_lots (user_id, expires_at) WHERE remaining > 0;
+
+-- For the sweeper, which looks for lots past their expiry that still have tokens in them.
+CREATE INDEX IF NOT EXISTS token_lots_expiry_idx ON token_lots (expires_at) WHERE remaining > 0;
+
+-- Which lots a debit or hold took its tokens from, so a refund or release can put them back in the same lots.
+-- A hold's draws get the debit's ledger_entry_id too once it's committed.
+CREATE TABLE IF NOT EXISTS token_lot_draws (
+    lot_id          UUID NOT NULL REFERENCES token_lots(lot_id) ON DELETE CASCADE,
+    ledger_entry_id UUID REFERENCES token_ledger(entry_id) ON DELETE CASCADE,
+    hold_id         UUID REFERENCES token_holds(hold_id) ON DELETE CASCADE,
+    amount          INT NOT NULL CHECK (amount > 0),
+    CHECK (ledger_entry_id IS NOT NULL OR hold_id IS NOT NULL)
+);
+
+CREATE INDEX IF NOT EXISTS token_lot_draws_entry_idx ON token_lot_draws (ledger_entry_id) WHERE ledger_entry_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS token_lot_draws_hold_idx ON token_lot_draws (hold_id) WHERE hold_id IS NOT NULL;
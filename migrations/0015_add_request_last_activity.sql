@@ -0,0 +1,9 @@
+-- When anything last happened on an active request: accepted, transferred, reopened, or a message in the
+-- conversation. The idle resolver closes active requests that have been quiet for too long, so an expert who
+-- forgets to resolve doesn't leave them open forever. Rows from before this column fall back to accepted_at.
+ALTER TABLE assistance_requests ADD COLUMN IF NOT EXISTS last_activity_at TIMESTAMPTZ;
+
+-- For the idle resolver, which looks for the quietest active requests.
+CREATE INDEX IF NOT EXISTS assistance_requests_idle_idx
+    ON assistance_requests ((COALESCE(last_activity_at, accepted_at)))
+    WHERE status = 'active';
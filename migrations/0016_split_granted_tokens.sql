@@ -0,0 +1,26 @@
+-- Purchased and granted tokens: finance wants to know how many of the tokens users spend were paid for.
+-- users.assistance_token_balance stays the whole spendable balance, and granted_token_balance is the part of it
+-- that was given away (starter tokens, monthly grants, campaigns, expiring lots). The rest was purchased.
+-- Spending takes granted tokens first, so a user's paid tokens are the ones left over.
+-- Lots are always granted tokens, so SUM(remaining) of a user's lots is never more than granted_token_balance either.
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_name = 'users' AND column_name = 'granted_token_balance'
+    ) THEN
+        ALTER TABLE users ADD COLUMN granted_token_balance INT NOT NULL DEFAULT 0;
+        -- There's no telling which existing tokens were paid for, so they all start out granted.
+        -- Only done when the column is new, so running this again doesn't undo any purchases since.
+        UPDATE users SET granted_token_balance = assistance_token_balance WHERE assistance_token_balance > 0;
+        ALTER TABLE users ADD CONSTRAINT users_granted_token_balance_check
+            CHECK (granted_token_balance >= 0 AND granted_token_balance <= assistance_token_balance);
+    END IF;
+END $$;
+
+-- How much of each debit, refund, grant or expiry was granted tokens. The rest of amount was purchased.
+-- Entries from before this migration are all 0, since nobody knew then.
+ALTER TABLE token_ledger ADD COLUMN IF NOT EXISTS granted_amount INT NOT NULL DEFAULT 0;
+
+-- The same for a hold, so committing it writes the split onto the debit and releasing it puts the granted part back.
+ALTER TABLE token_holds ADD COLUMN IF NOT EXISTS granted_amount INT NOT NULL DEFAULT 0;
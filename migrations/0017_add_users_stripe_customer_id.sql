@@ -0,0 +1,3 @@
+-- The Stripe customer a user pays as. Created the first time they start a Stripe purchase, so it's NULL until then.
+-- Kept on the user so every later PaymentIntent goes to the same customer, and Stripe shows one purchase history.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS stripe_customer_id TEXT;
@@ -0,0 +1,40 @@
+-- Every change to a balance now has a row in token_ledger, so a user's ledger adds up to
+-- assistance_token_balance + held_token_balance and the reconciler can check that it still does.
+-- Held tokens are still the user's until the hold is committed, which is when its debit row is written.
+--   'credit'  is any credit that isn't a monthly grant, purchased or granted. reference_id is the caller's, if any.
+--   'opening' is a balance the user had before the ledger saw it: what a new user starts with, and, once, whatever
+--             everyone's ledger didn't account for when this ran. That one can be negative.
+
+-- The catch up only runs the first time, before the trigger below exists, so running this again doesn't
+-- paper over a discrepancy found since.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_proc WHERE proname = 'record_opening_balance') THEN
+        INSERT INTO token_ledger (entry_id, user_id, kind, amount, granted_amount, balance_after)
+        SELECT gen_random_uuid(), u.user_id, 'opening',
+               u.assistance_token_balance + u.held_token_balance - COALESCE(l.total, 0), 0, u.assistance_token_balance
+        FROM users u
+        LEFT JOIN (
+            SELECT user_id, SUM(CASE WHEN kind IN ('debit', 'expired') THEN -amount ELSE amount END) AS total
+            FROM token_ledger
+            GROUP BY user_id
+        ) l ON l.user_id = u.user_id
+        WHERE u.assistance_token_balance + u.held_token_balance <> COALESCE(l.total, 0);
+    END IF;
+END $$;
+
+-- The UserService creates users with their starter tokens already on the balance. A trigger records them whoever
+-- does the insert, rather than every service that creates users having to know about the ledger.
+CREATE OR REPLACE FUNCTION record_opening_balance() RETURNS trigger AS $$
+BEGIN
+    IF NEW.assistance_token_balance + NEW.held_token_balance <> 0 THEN
+        INSERT INTO token_ledger (entry_id, user_id, kind, amount, granted_amount, balance_after)
+        VALUES (gen_random_uuid(), NEW.user_id, 'opening', NEW.assistance_token_balance + NEW.held_token_balance,
+                NEW.granted_token_balance, NEW.assistance_token_balance);
+    END IF;
+    RETURN NULL;
+END $$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_opening_balance ON users;
+CREATE TRIGGER users_opening_balance AFTER INSERT ON users
+    FOR EACH ROW EXECUTE FUNCTION record_opening_balance();
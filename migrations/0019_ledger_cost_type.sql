@@ -0,0 +1,3 @@
+-- Which cost type a debit was priced from, for a debit made by cost type rather than by amount. NULL for everything
+-- else, including debits from before cost types, which were all the standard single token or an explicit amount.
+ALTER TABLE token_ledger ADD COLUMN IF NOT EXISTS cost_type TEXT;
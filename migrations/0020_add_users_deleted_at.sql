@@ -0,0 +1,3 @@
+-- When the user deleted their account. The row is kept, anonymized, so their requests, conversations and ledger
+-- still point at something, but the UserService treats a row with this set as if it wasn't there.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
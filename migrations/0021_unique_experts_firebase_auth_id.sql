@@ -0,0 +1,3 @@
+-- Experts now register through the UserService. One Firebase account is one expert, so a repeated register
+-- fails the insert and is answered with the existing expert, like users.
+CREATE UNIQUE INDEX IF NOT EXISTS experts_firebase_auth_id_uniq ON experts (firebase_auth_id);
@@ -0,0 +1,4 @@
+-- When the user registered, and when their row last changed. Rows from before this migration get the time it ran,
+-- since nothing earlier recorded when they signed up.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE users ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
@@ -0,0 +1,7 @@
+-- For the admin user search, which matches any part of a display name with ILIKE. A btree can't serve a
+-- leading wildcard, but a trigram index can, so the search doesn't scan the whole table.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS users_display_name_trgm_idx
+    ON users USING gin (display_name gin_trgm_ops)
+    WHERE deleted_at IS NULL;
@@ -0,0 +1,12 @@
+-- Audit trail of role changes, eg who made someone a superadmin. One row per change, written in the same
+-- transaction as the change itself.
+CREATE TABLE IF NOT EXISTS user_role_events (
+    event_id   UUID PRIMARY KEY,
+    user_id    UUID NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    from_role  TEXT NOT NULL,
+    to_role    TEXT NOT NULL,
+    actor_id   UUID NOT NULL,        -- The superadmin who made the change
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS user_role_events_user_id_created_at_idx ON user_role_events (user_id, created_at);
@@ -0,0 +1,4 @@
+-- Where a credit came from, so support can tell a purchase from a grant or a refund when a user disputes their
+-- balance: 'purchase', 'grant', 'refund', or a lot's own source. NULL for everything that isn't a credit,
+-- and for credits from before this column.
+ALTER TABLE token_ledger ADD COLUMN IF NOT EXISTS source TEXT;
@@ -0,0 +1,19 @@
+-- 0022 gave users from before it the time it ran as created_at. Move that back to the earliest thing we have
+-- on record for them: a request, a conversation, or a token ledger or credit row. Users with none keep the time
+-- 0022 ran. updated_at is left alone, since it's already no earlier than any of these.
+UPDATE users u
+SET created_at = e.earliest
+FROM (
+    SELECT user_id, min(created_at) AS earliest
+    FROM (
+        SELECT user_id, created_at FROM assistance_requests
+        UNION ALL
+        SELECT user_id, created_at FROM conversations
+        UNION ALL
+        SELECT user_id, created_at FROM token_ledger
+        UNION ALL
+        SELECT user_id, created_at FROM token_credits
+    ) activity
+    GROUP BY user_id
+) e
+WHERE e.user_id = u.user_id AND e.earliest < u.created_at;
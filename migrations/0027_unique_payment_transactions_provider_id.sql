@@ -0,0 +1,10 @@
+-- IAP purchases are logged under the store's id for the purchase now, not the receipt, and each purchase can be
+-- claimed by one user only. Rows from before logged the whole receipt, which can be kilobytes long and repeats
+-- across retries, so it moves to receipt and their provider_transaction_id becomes one that can't clash.
+ALTER TABLE payment_transactions ADD COLUMN IF NOT EXISTS receipt TEXT;
+UPDATE payment_transactions
+SET receipt = provider_transaction_id,
+    provider_transaction_id = 'receipt:' || transaction_id
+WHERE provider IN ('apple', 'google') AND receipt IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS payment_transactions_provider_transaction_id_uniq
+    ON payment_transactions (provider, provider_transaction_id);